@@ -0,0 +1,198 @@
+// Copyright (c) Liam Stanley <me@liamstanley.io>. All rights reserved. Use
+// of this source code is governed by the MIT license that can be found in
+// the LICENSE file.
+
+package girc
+
+import "strings"
+
+// ACLRule is a rule of an ACL, which returns true if the event (e.g. a
+// PRIVMSG with a command) matches it. Custom rules can be any function,
+// e.g. to look up permissions in a database.
+type ACLRule func(c *Client, e Event) bool
+
+// ACLAccount matches events from users logged in to one of the given
+// services accounts, as known from the account tag (see the account-tag
+// capability), or otherwise from tracking (see User.Extras.Account).
+// Accounts are compared with the casemapping of the server.
+func ACLAccount(accounts ...string) ACLRule {
+	return func(c *Client, e Event) bool {
+		if e.Source == nil {
+			return false
+		}
+
+		account, ok := e.Tag("account")
+		if !ok && !c.Config.disableTracking {
+			if user, found := c.LookupUser(e.Source.Name); found {
+				account = user.Extras.Account
+			}
+		}
+
+		if account == "" {
+			return false
+		}
+
+		account = c.toLower(account)
+		for _, allowed := range accounts {
+			if c.toLower(allowed) == account {
+				return true
+			}
+		}
+
+		return false
+	}
+}
+
+// ACLHostmask matches events from sources matching one of the given masks.
+// Masks are of the form "nick!ident@host", and may contain the "*" glob
+// character, as with Ignores.Add.
+func ACLHostmask(masks ...string) ACLRule {
+	return func(c *Client, e Event) bool {
+		if e.Source == nil {
+			return false
+		}
+
+		for _, mask := range masks {
+			if matchMask(c.casemapping(), e.Source, mask) {
+				return true
+			}
+		}
+
+		return false
+	}
+}
+
+// ACLChannelStatus matches events sent to a channel, from users whose
+// permissions in that channel satisfy status, e.g. UserPerms.IsAdmin (op or
+// higher) or UserPerms.IsTrusted (voice or higher). Requires tracking to be
+// enabled.
+func ACLChannelStatus(status func(perms UserPerms) bool) ACLRule {
+	return func(c *Client, e Event) bool {
+		if e.Source == nil || c.Config.disableTracking || len(e.Params) < 1 || !IsValidChannel(e.Params[0]) {
+			return false
+		}
+
+		channel, ok := c.LookupChannel(e.Params[0])
+		if !ok {
+			return false
+		}
+
+		user := channel.Lookup(e.Source.Name)
+		return user != nil && status(user.Perms)
+	}
+}
+
+// ACLCommand matches messages (PRIVMSG) whose text is one of the given
+// commands (e.g. "!kick"), optionally followed by arguments. Useful as
+// ACL.Match.
+func ACLCommand(commands ...string) ACLRule {
+	return func(c *Client, e Event) bool {
+		if e.Command != PRIVMSG || e.IsAction() {
+			return false
+		}
+
+		word := e.Trailing
+		if i := strings.IndexByte(word, eventSpace); i > -1 {
+			word = word[:i]
+		}
+
+		for _, command := range commands {
+			if strings.EqualFold(word, command) {
+				return true
+			}
+		}
+
+		return false
+	}
+}
+
+// ACLAll matches events which match all of the given rules, e.g. an op
+// who is also logged in to a specific account.
+func ACLAll(rules ...ACLRule) ACLRule {
+	return func(c *Client, e Event) bool {
+		for _, rule := range rules {
+			if !rule(c, e) {
+				return false
+			}
+		}
+
+		return len(rules) > 0
+	}
+}
+
+// ACL is an access control list, which restricts handlers (e.g. of admin
+// only commands) to the events which it allows. See ACL.Wrap.
+type ACL struct {
+	// Match, if set, selects the events which the ACL applies to, e.g.
+	// ACLCommand("!kick"). Other events are dropped by ACL.Wrap without
+	// being checked, so they aren't audited or answered with DenyMessage.
+	Match ACLRule
+	// Allow are the rules which grant access. An event is allowed if it
+	// matches any of them.
+	Allow []ACLRule
+	// Deny are the rules which deny access, even if an event is allowed by
+	// one of the Allow rules.
+	Deny []ACLRule
+	// DenyMessage, if set, is sent to the source of a denied event as a
+	// NOTICE.
+	DenyMessage string
+	// Audit, if set, is called for every event which is checked, with
+	// whether it was allowed. Useful to log the use of privileged commands.
+	Audit func(c *Client, e Event, allowed bool)
+}
+
+// Allowed returns true if the event is allowed by the ACL, calling
+// ACL.Audit if set.
+func (a *ACL) Allowed(c *Client, e Event) bool {
+	allowed := a.allowed(c, e)
+
+	if a.Audit != nil {
+		a.Audit(c, e, allowed)
+	}
+
+	return allowed
+}
+
+// allowed evaluates the rules of the ACL against the event.
+func (a *ACL) allowed(c *Client, e Event) bool {
+	for _, rule := range a.Deny {
+		if rule(c, e) {
+			return false
+		}
+	}
+
+	for _, rule := range a.Allow {
+		if rule(c, e) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Wrap returns a handler which only executes handler for events which are
+// matched (see ACL.Match) and allowed by the ACL. Denied events are answered
+// with ACL.DenyMessage, if set. For example:
+//
+//	acl := &girc.ACL{
+//		Match:       girc.ACLCommand("!kick", "!ban"),
+//		Allow:       []girc.ACLRule{girc.ACLAccount("admin"), girc.ACLChannelStatus(girc.UserPerms.IsAdmin)},
+//		DenyMessage: "Permission denied.",
+//	}
+//	client.Handlers.AddHandler(girc.PRIVMSG, acl.Wrap(girc.HandlerFunc(moderationCommands)))
+func (a *ACL) Wrap(handler Handler) Handler {
+	return HandlerFunc(func(c *Client, e Event) {
+		if a.Match != nil && !a.Match(c, e) {
+			return
+		}
+
+		if a.Allowed(c, e) {
+			handler.Execute(c, e)
+			return
+		}
+
+		if a.DenyMessage != "" && e.Source != nil {
+			c.Commands.Notice(e.Source.Name, a.DenyMessage)
+		}
+	})
+}
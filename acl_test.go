@@ -0,0 +1,93 @@
+// Copyright (c) Liam Stanley <me@liamstanley.io>. All rights reserved. Use
+// of this source code is governed by the MIT license that can be found in
+// the LICENSE file.
+
+package girc
+
+import "testing"
+
+func TestACL(t *testing.T) {
+	c := New(Config{Server: "irc.example.com", Nick: "nick", User: "user"})
+
+	for _, line := range []string{
+		":nick!user@host JOIN #channel",
+		":irc.example.com 353 nick = #channel :nick @op +voice user",
+		":irc.example.com 354 nick 1 #channel user host user H admin :User",
+	} {
+		c.RunHandlers(ParseEvent(line))
+	}
+
+	acl := &ACL{
+		Allow: []ACLRule{
+			ACLAccount("Admin"),
+			ACLHostmask("*!*@trusted.example.com"),
+			ACLChannelStatus(UserPerms.IsAdmin),
+		},
+		Deny: []ACLRule{ACLHostmask("*!*@banned.example.com")},
+	}
+
+	tests := []struct {
+		line string
+		want bool
+	}{
+		{line: ":user!user@host PRIVMSG #channel :!kick someone", want: true},
+		{line: "@account=other :user!user@host PRIVMSG #channel :!kick someone", want: false},
+		{line: ":op!user@host PRIVMSG #channel :!kick someone", want: true},
+		{line: ":op!user@host PRIVMSG nick :!kick someone", want: false},
+		{line: ":voice!user@host PRIVMSG #channel :!kick someone", want: false},
+		{line: ":other!user@trusted.example.com PRIVMSG nick :!kick someone", want: true},
+		{line: ":op!user@banned.example.com PRIVMSG #channel :!kick someone", want: false},
+	}
+
+	for _, tt := range tests {
+		if got := acl.Allowed(c, *ParseEvent(tt.line)); got != tt.want {
+			t.Errorf("Allowed(%q) = %t, want %t", tt.line, got, tt.want)
+		}
+	}
+}
+
+func TestACLAccountCasemapping(t *testing.T) {
+	c := New(Config{Server: "irc.example.com", Nick: "nick", User: "user"})
+	rule := ACLAccount("a[b]")
+
+	if e := ParseEvent("@account=A{B} :user!user@host PRIVMSG #channel :!kick"); !rule(c, *e) {
+		t.Fatal("ACLAccount(a[b]) doesn't match A{B} with rfc1459")
+	}
+
+	c.state.mu.Lock()
+	c.state.isupport.Casemapping = CaseMappingASCII
+	c.state.mu.Unlock()
+
+	if e := ParseEvent("@account=A{B} :user!user@host PRIVMSG #channel :!kick"); rule(c, *e) {
+		t.Fatal("ACLAccount(a[b]) matches A{B} with ascii")
+	}
+
+	if e := ParseEvent("@account=A[B] :user!user@host PRIVMSG #channel :!kick"); !rule(c, *e) {
+		t.Fatal("ACLAccount(a[b]) doesn't match A[B] with ascii")
+	}
+}
+
+func TestACLWrap(t *testing.T) {
+	c, server := mockClient(t, Config{})
+	defer c.Stop()
+
+	var audited []bool
+	executed := make(chan string, 1)
+
+	acl := &ACL{
+		Match:       ACLCommand("!op"),
+		Allow:       []ACLRule{ACLHostmask("admin!*@*")},
+		DenyMessage: "Permission denied.",
+		Audit:       func(c *Client, e Event, allowed bool) { audited = append(audited, allowed) },
+	}
+	c.Handlers.AddHandler(PRIVMSG, acl.Wrap(HandlerFunc(func(c *Client, e Event) { executed <- e.Source.Name })))
+
+	server.send(":other!user@host PRIVMSG #channel :hello")
+	server.send(":other!user@host PRIVMSG #channel :!op")
+	server.expect("NOTICE other :Permission denied.")
+	server.send(":admin!user@host PRIVMSG #channel :!OP me")
+
+	if nick := <-executed; nick != "admin" || len(audited) != 2 || audited[0] || !audited[1] {
+		t.Fatalf("handler executed for %q, audited %v", nick, audited)
+	}
+}
@@ -0,0 +1,485 @@
+// Copyright (c) Liam Stanley <me@liamstanley.io>. All rights reserved. Use
+// of this source code is governed by the MIT license that can be found in
+// the LICENSE file.
+
+package girc
+
+import (
+	"bytes"
+	"strconv"
+	"strings"
+)
+
+// fmtState is the formatting in effect at some point of a message.
+type fmtState struct {
+	bold, italic, underline, strikethrough, monospace, reverse bool
+	// fg and bg are the foreground and background colors, which are
+	// ColorDefault if not set.
+	fg, bg Color
+}
+
+// defaultFmtState is the formatting at the start of a message.
+var defaultFmtState = fmtState{fg: ColorDefault, bg: ColorDefault}
+
+// walkFmt splits text into runs of plain text, calling fn with each run and
+// the formatting which applies to it.
+func walkFmt(text string, fn func(state fmtState, run string)) {
+	walkFmtFrom(text, defaultFmtState, fn)
+}
+
+// walkFmtFrom is much like walkFmt, however the formatting at the start of
+// text is state. Returns the formatting at the end of text. fn may be nil.
+func walkFmtFrom(text string, state fmtState, fn func(state fmtState, run string)) fmtState {
+	start := 0
+
+	for i := 0; i < len(text); i++ {
+		next := state
+		end := i + 1
+
+		switch text[i] {
+		case fmtBold:
+			next.bold = !next.bold
+		case fmtItalic:
+			next.italic = !next.italic
+		case fmtUnderline:
+			next.underline = !next.underline
+		case fmtStrikethrough:
+			next.strikethrough = !next.strikethrough
+		case fmtMonospace:
+			next.monospace = !next.monospace
+		case fmtReverse:
+			next.reverse = !next.reverse
+		case fmtReset:
+			next = defaultFmtState
+		case fmtColor:
+			end = skipFmtColor(text, i+1, 2, isFmtDigit)
+			next.fg, next.bg = parseColorCode(text[i+1:end], next.fg, next.bg)
+		case fmtHexColor:
+			end = skipFmtColor(text, i+1, 6, isFmtHexDigit)
+			next.fg, next.bg = parseHexColorCode(text[i+1:end], next.fg, next.bg)
+		default:
+			continue
+		}
+
+		if i > start && fn != nil {
+			fn(state, text[start:i])
+		}
+
+		state = next
+		start = end
+		i = end - 1
+	}
+
+	if start < len(text) && fn != nil {
+		fn(state, text[start:])
+	}
+
+	return state
+}
+
+// parseColorCode parses the "fg[,bg]" colors of a color code. A color code
+// without colors resets both colors.
+func parseColorCode(code string, fg, bg Color) (Color, Color) {
+	if code == "" {
+		return ColorDefault, ColorDefault
+	}
+
+	colors := strings.SplitN(code, ",", 2)
+	fg = parseCodeColor(colors[0])
+	if len(colors) > 1 {
+		bg = parseCodeColor(colors[1])
+	}
+
+	return fg, bg
+}
+
+// parseCodeColor parses a color number of a color code.
+func parseCodeColor(num string) Color {
+	n, _ := strconv.Atoi(num)
+	if !Color(n).Valid() {
+		return ColorDefault
+	}
+
+	return Color(n)
+}
+
+// parseHexColorCode parses the "RRGGBB[,RRGGBB]" colors of a hex color code,
+// which are approximated with the nearest colors. A hex color code without
+// colors resets both colors.
+func parseHexColorCode(code string, fg, bg Color) (Color, Color) {
+	if code == "" {
+		return ColorDefault, ColorDefault
+	}
+
+	colors := strings.SplitN(code, ",", 2)
+	fg = parseHexColor(colors[0])
+	if len(colors) > 1 {
+		bg = parseHexColor(colors[1])
+	}
+
+	return fg, bg
+}
+
+// parseHexColor returns the nearest color to an "RRGGBB" hex color.
+func parseHexColor(hex string) Color {
+	rgb, err := strconv.ParseUint(hex, 16, 32)
+	if err != nil || len(hex) != 6 {
+		return ColorDefault
+	}
+
+	return nearestColor(uint8(rgb>>16), uint8(rgb>>8), uint8(rgb), ColorDefault)
+}
+
+// nearestColor returns the color below max which is closest to the given
+// red, green and blue components.
+func nearestColor(r, g, b uint8, max Color) Color {
+	best, bestDist := ColorWhite, -1
+
+	for c := ColorWhite; c < max && int(c) < len(colorRGB); c++ {
+		cr, cg, cb, _ := c.RGB()
+		dr, dg, db := int(r)-int(cr), int(g)-int(cg), int(b)-int(cb)
+
+		if dist := dr*dr + dg*dg + db*db; bestDist < 0 || dist < bestDist {
+			best, bestDist = c, dist
+		}
+	}
+
+	return best
+}
+
+// ANSIMode is the color support of the terminal which text is converted for
+// by FmtToANSI.
+type ANSIMode int
+
+const (
+	// ANSI16 uses only the 16 standard ANSI colors, which all terminals
+	// support. The extended colors are approximated by the nearest common
+	// color.
+	ANSI16 ANSIMode = iota
+	// ANSI256 uses the 256 color palette of xterm (and most other modern
+	// terminals) for the extended colors.
+	ANSI256
+)
+
+// ansi16 maps the common colors to the standard ANSI colors (0 to 7 being
+// the normal colors, and 8 to 15 the bright colors). The terminal applies
+// its own palette to them, as IRC clients do for the common colors.
+var ansi16 = [16]int{
+	15, // white: bright white
+	0,  // black: black
+	4,  // blue: blue
+	2,  // green: green
+	9,  // red: bright red
+	1,  // brown: red
+	5,  // purple: magenta
+	3,  // orange: yellow
+	11, // yellow: bright yellow
+	10, // light green: bright green
+	6,  // teal: cyan
+	14, // cyan: bright cyan
+	12, // light blue: bright blue
+	13, // pink: bright magenta
+	8,  // grey: bright black
+	7,  // light grey: white
+}
+
+// ansi256 maps the extended colors (16 to 98) to the 256 color palette. See
+// https://modern.ircdocs.horse/formatting#colors-16-98.
+var ansi256 = [...]int{
+	52, 94, 100, 58, 22, 29, 23, 24, 17, 54, 53, 89,
+	88, 130, 142, 64, 28, 35, 30, 25, 18, 91, 90, 125,
+	124, 166, 184, 106, 34, 49, 37, 33, 19, 129, 127, 161,
+	196, 208, 226, 154, 46, 86, 51, 75, 21, 171, 201, 198,
+	203, 215, 227, 191, 83, 122, 87, 111, 63, 177, 207, 205,
+	217, 223, 229, 193, 157, 158, 159, 153, 147, 183, 219, 212,
+	16, 233, 235, 237, 239, 241, 244, 247, 250, 254, 231,
+}
+
+// ansiColor returns the SGR parameters which set the foreground (or with
+// bg, the background) color to c.
+func ansiColor(c Color, bg bool, mode ANSIMode) string {
+	if c >= 16 && mode == ANSI256 {
+		if bg {
+			return "48;5;" + strconv.Itoa(ansi256[c-16])
+		}
+
+		return "38;5;" + strconv.Itoa(ansi256[c-16])
+	}
+
+	if c >= 16 {
+		r, g, b, _ := c.RGB()
+		c = nearestColor(r, g, b, 16)
+	}
+
+	sgr := 30 + ansi16[c]
+	if ansi16[c] > 7 {
+		sgr = 90 + ansi16[c] - 8
+	}
+	if bg {
+		sgr += 10
+	}
+
+	return strconv.Itoa(sgr)
+}
+
+// ansiSequence returns the SGR escape sequence which applies state, after
+// resetting any previous formatting. Monospace has no equivalent, so it's
+// ignored.
+func ansiSequence(state fmtState, mode ANSIMode) string {
+	params := []string{"0"}
+
+	for _, attr := range []struct {
+		set bool
+		sgr string
+	}{
+		{state.bold, "1"}, {state.italic, "3"}, {state.underline, "4"},
+		{state.reverse, "7"}, {state.strikethrough, "9"},
+	} {
+		if attr.set {
+			params = append(params, attr.sgr)
+		}
+	}
+
+	if state.fg != ColorDefault {
+		params = append(params, ansiColor(state.fg, false, mode))
+	}
+	if state.bg != ColorDefault {
+		params = append(params, ansiColor(state.bg, true, mode))
+	}
+
+	return "\x1b[" + strings.Join(params, ";") + "m"
+}
+
+// FmtToANSI converts the formatting codes of text (e.g. of an incoming
+// message) to ANSI escape sequences, so it can be printed to a terminal with
+// its formatting. If text has any formatting, the result ends with a reset,
+// so formatting doesn't leak into whatever is printed after it. Hex colors
+// are approximated by the nearest color. See ANSIToFmt for the inverse.
+func FmtToANSI(text string, mode ANSIMode) string {
+	var out bytes.Buffer
+	last := defaultFmtState
+
+	walkFmt(text, func(state fmtState, run string) {
+		state.monospace = false
+		if state != last {
+			out.WriteString(ansiSequence(state, mode))
+			last = state
+		}
+
+		out.WriteString(run)
+	})
+
+	if last != defaultFmtState {
+		out.WriteString("\x1b[0m")
+	}
+
+	return out.String()
+}
+
+// ansiToColor maps the standard ANSI colors to the common colors, the
+// inverse of ansi16.
+var ansiToColor = func() (colors [16]Color) {
+	for c, ansi := range ansi16 {
+		colors[ansi] = Color(c)
+	}
+
+	return colors
+}()
+
+// xtermRGB returns the red, green and blue components of a color of the 256
+// color palette, from 16 to 255.
+func xtermRGB(n int) (r, g, b uint8) {
+	if n >= 232 {
+		gray := uint8(8 + 10*(n-232))
+		return gray, gray, gray
+	}
+
+	levels := [6]uint8{0, 95, 135, 175, 215, 255}
+	n -= 16
+	return levels[n/36], levels[n/6%6], levels[n%6]
+}
+
+// xtermColor returns the color matching a color of the 256 color palette.
+func xtermColor(n int) Color {
+	switch {
+	case n < 0 || n > 255:
+		return ColorDefault
+	case n < 16:
+		return ansiToColor[n]
+	}
+
+	for i, ansi := range ansi256 {
+		if ansi == n {
+			return Color(i + 16)
+		}
+	}
+
+	r, g, b := xtermRGB(n)
+	return nearestColor(r, g, b, ColorDefault)
+}
+
+// applySGR applies the parameters of an SGR escape sequence to state.
+// Unsupported parameters are ignored.
+func applySGR(state fmtState, params []int) fmtState {
+	for i := 0; i < len(params); i++ {
+		switch p := params[i]; {
+		case p == 0:
+			state = defaultFmtState
+		case p == 1:
+			state.bold = true
+		case p == 3:
+			state.italic = true
+		case p == 4:
+			state.underline = true
+		case p == 7:
+			state.reverse = true
+		case p == 9:
+			state.strikethrough = true
+		case p == 22:
+			state.bold = false
+		case p == 23:
+			state.italic = false
+		case p == 24:
+			state.underline = false
+		case p == 27:
+			state.reverse = false
+		case p == 29:
+			state.strikethrough = false
+		case p >= 30 && p <= 37:
+			state.fg = ansiToColor[p-30]
+		case p >= 40 && p <= 47:
+			state.bg = ansiToColor[p-40]
+		case p >= 90 && p <= 97:
+			state.fg = ansiToColor[p-90+8]
+		case p >= 100 && p <= 107:
+			state.bg = ansiToColor[p-100+8]
+		case p == 39:
+			state.fg = ColorDefault
+		case p == 49:
+			state.bg = ColorDefault
+		case p == 38 || p == 48:
+			// 38;5;n or 38;2;r;g;b (and likewise for 48).
+			color := ColorDefault
+			switch {
+			case i+2 < len(params) && params[i+1] == 5:
+				color = xtermColor(params[i+2])
+				i += 2
+			case i+4 < len(params) && params[i+1] == 2:
+				color = nearestColor(uint8(params[i+2]), uint8(params[i+3]), uint8(params[i+4]), ColorDefault)
+				i += 4
+			default:
+				i = len(params)
+			}
+
+			if p == 38 {
+				state.fg = color
+			} else {
+				state.bg = color
+			}
+		}
+	}
+
+	return state
+}
+
+// fmtTransition returns the formatting codes which change the formatting
+// from one state to another.
+func fmtTransition(from, to fmtState) string {
+	if from == to {
+		return ""
+	}
+
+	if to == defaultFmtState {
+		return string(fmtReset)
+	}
+
+	var out bytes.Buffer
+
+	for _, attr := range []struct {
+		from, to bool
+		code     byte
+	}{
+		{from.bold, to.bold, fmtBold},
+		{from.italic, to.italic, fmtItalic},
+		{from.underline, to.underline, fmtUnderline},
+		{from.strikethrough, to.strikethrough, fmtStrikethrough},
+		{from.monospace, to.monospace, fmtMonospace},
+		{from.reverse, to.reverse, fmtReverse},
+	} {
+		if attr.from != attr.to {
+			out.WriteByte(attr.code)
+		}
+	}
+
+	// Always set both colors, so digits (or a comma) at the start of the
+	// text which follows aren't mistaken for part of the code.
+	if from.fg != to.fg || from.bg != to.bg {
+		out.WriteString(ColorCode(to.fg, to.bg))
+	}
+
+	return out.String()
+}
+
+// ANSIToFmt converts the ANSI escape sequences of text (e.g. the output of
+// a command) to formatting codes, so it can be sent to IRC with its
+// formatting. Colors of the 256 color palette and 24-bit colors are
+// approximated by the nearest color. Other escape sequences (e.g. cursor
+// movement) are removed. See FmtToANSI for the inverse.
+func ANSIToFmt(text string) string {
+	var out bytes.Buffer
+	last, state := defaultFmtState, defaultFmtState
+
+	for i := 0; i < len(text); i++ {
+		if text[i] != 0x1B { // ESC
+			out.WriteString(fmtTransition(last, state))
+			last = state
+			out.WriteByte(text[i])
+			continue
+		}
+
+		if i+1 >= len(text) || text[i+1] != 0x5B { // [
+			// Not a control sequence, but possibly another two byte escape
+			// sequence (e.g. ESC c), which is dropped with the escape.
+			if i+1 < len(text) && text[i+1] >= 0x40 && text[i+1] <= 0x7E {
+				i++
+			}
+			continue
+		}
+
+		// Control sequences are parameter bytes, then intermediate bytes,
+		// then a final byte.
+		end := i + 2
+		for end < len(text) && text[end] >= 0x30 && text[end] <= 0x3F {
+			end++
+		}
+		paramEnd := end
+		for end < len(text) && text[end] >= 0x20 && text[end] <= 0x2F {
+			end++
+		}
+
+		if end >= len(text) {
+			// Truncated sequence.
+			break
+		}
+
+		if text[end] == 0x6D && paramEnd == end { // m
+			var params []int
+			for _, p := range strings.Split(text[i+2:paramEnd], ";") {
+				n, _ := strconv.Atoi(p)
+				params = append(params, n)
+			}
+
+			state = applySGR(state, params)
+		}
+
+		i = end
+	}
+
+	// The formatting at the very end doesn't matter, unless it's a reset,
+	// so no formatting leaks into text which is appended.
+	if state == defaultFmtState && last != defaultFmtState {
+		out.WriteByte(fmtReset)
+	}
+
+	return out.String()
+}
@@ -0,0 +1,83 @@
+// Copyright (c) Liam Stanley <me@liamstanley.io>. All rights reserved. Use
+// of this source code is governed by the MIT license that can be found in
+// the LICENSE file.
+
+package girc
+
+import "testing"
+
+func TestFmtToANSI(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		mode ANSIMode
+		want string
+	}{
+		{name: "plain", text: "this is a test.", want: "this is a test."},
+		{name: "bold", text: "\x02bold\x02 text", want: "\x1b[0;1mbold\x1b[0m text"},
+		{name: "reset", text: "\x02\x1dboth\x0f", want: "\x1b[0;1;3mboth\x1b[0m"},
+		{name: "unterminated", text: "\x1fopen", want: "\x1b[0;4mopen\x1b[0m"},
+		{name: "color", text: "\x0304red\x03 \x0302,01blue", want: "\x1b[0;91mred\x1b[0m \x1b[0;34;40mblue\x1b[0m"},
+		{name: "keep bg", text: "\x0304,01a\x0302b", want: "\x1b[0;91;40ma\x1b[0;34;40mb\x1b[0m"},
+		{name: "extended 16", text: "\x0352red", want: "\x1b[0;91mred\x1b[0m"},
+		{name: "extended 256", text: "\x0352red", mode: ANSI256, want: "\x1b[0;38;5;196mred\x1b[0m"},
+		{name: "common 256", text: "\x0304red", mode: ANSI256, want: "\x1b[0;91mred\x1b[0m"},
+		{name: "hex", text: "\x04FF0000red", mode: ANSI256, want: "\x1b[0;91mred\x1b[0m"},
+		{name: "digits", text: "\x03041234", want: "\x1b[0;91m1234\x1b[0m"},
+		{name: "monospace", text: "\x11code\x11", want: "code"},
+	}
+
+	for _, tt := range tests {
+		if got := FmtToANSI(tt.text, tt.mode); got != tt.want {
+			t.Errorf("%s: FmtToANSI(%q) = %q, want %q", tt.name, tt.text, got, tt.want)
+		}
+	}
+}
+
+func TestANSIToFmt(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		want string
+	}{
+		{name: "plain", text: "this is a test.", want: "this is a test."},
+		{name: "bold", text: "\x1b[1mbold\x1b[0m text", want: "\x02bold\x0f text"},
+		{name: "attrs", text: "\x1b[1;3mab\x1b[22mc\x1b[m", want: "\x02\x1dab\x02c\x0f"},
+		{name: "color", text: "\x1b[31mred\x1b[39m", want: "\x0305,99red\x0f"},
+		{name: "bright", text: "\x1b[91;44mx\x1b[0m", want: "\x0304,02x\x0f"},
+		{name: "256", text: "\x1b[38;5;196mx", want: "\x0352,99x"},
+		{name: "256 gray", text: "\x1b[38;5;232mx", want: "\x0301,99x"},
+		{name: "truecolor", text: "\x1b[38;2;255;0;0mx", want: "\x0304,99x"},
+		{name: "redundant", text: "\x1b[1m\x1b[1mx\x1b[1my", want: "\x02xy"},
+		{name: "other", text: "\x1b[2Jclear\x1b[1;1H\x1bc", want: "clear"},
+		{name: "truncated", text: "x\x1b[1", want: "x"},
+	}
+
+	for _, tt := range tests {
+		if got := ANSIToFmt(tt.text); got != tt.want {
+			t.Errorf("%s: ANSIToFmt(%q) = %q, want %q", tt.name, tt.text, got, tt.want)
+		}
+	}
+}
+
+func TestANSIRoundTrip(t *testing.T) {
+	text := Fmt("{b}{red,black}alert{r}: {u}disk{u} {lightblue}full")
+	got := ANSIToFmt(FmtToANSI(text, ANSI16))
+
+	if TrimFmt(got) != TrimFmt(text) {
+		t.Fatalf("round trip changed the text: %q", got)
+	}
+
+	var want, have []fmtState
+	walkFmt(text, func(state fmtState, run string) { want = append(want, state) })
+	walkFmt(got, func(state fmtState, run string) { have = append(have, state) })
+
+	if len(want) != len(have) {
+		t.Fatalf("round trip = %q, want runs like %q", got, text)
+	}
+	for i := range want {
+		if want[i] != have[i] {
+			t.Errorf("run %d: state = %+v, want %+v", i, have[i], want[i])
+		}
+	}
+}
@@ -0,0 +1,269 @@
+// Copyright (c) Liam Stanley <me@liamstanley.io>. All rights reserved. Use
+// of this source code is governed by the MIT license that can be found in
+// the LICENSE file.
+
+package girc
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"strings"
+)
+
+// ServiceAuth is an authentication provider for the services bot of a
+// network which supports neither SASL nor NickServ, such as QuakeNet's Q
+// (see QAuth), Undernet's X (see XAuth) or GameSurge's AuthServ (see
+// AuthServ). See Config.ServiceAuth.
+type ServiceAuth interface {
+	// Service returns the target messages to the service are sent to. This
+	// should be the full nick@server form where the network supports it,
+	// so the messages can't be intercepted by whoever uses the nickname of
+	// the service.
+	Service() string
+	// Start returns the message which is sent to the service to start
+	// authentication.
+	Start() string
+	// Next is called with each notice from the service (with formatting
+	// stripped) while authenticating. It returns the message to send to the
+	// service in response (if any), the account once logged in, or an
+	// error once authentication failed. Notices which aren't part of
+	// authentication return all zero values.
+	Next(notice string) (reply, account string, err error)
+}
+
+// serviceNick returns the nickname of the service the target of auth is,
+// which its notices are sent from.
+func serviceNick(auth ServiceAuth) string {
+	service := auth.Service()
+	if i := strings.IndexByte(service, 0x40); i > 0 { // @
+		return service[:i]
+	}
+
+	return service
+}
+
+// defaultQService is the target of QuakeNet's Q.
+const defaultQService = "Q@CServe.quakenet.org"
+
+// QAuth authenticates with QuakeNet's Q, using CHALLENGEAUTH with
+// HMAC-SHA-256, so the password itself is never sent. Authentication fails
+// if Q doesn't offer HMAC-SHA-256. Set Config.HideHost to hide our host
+// once logged in.
+type QAuth struct {
+	// Account is the Q account to log in as.
+	Account string
+	// Password is the password of the account.
+	Password string
+	// Target is the target of Q. Defaults to "Q@CServe.quakenet.org".
+	Target string
+}
+
+// Service implements ServiceAuth.
+func (q *QAuth) Service() string {
+	if q.Target == "" {
+		return defaultQService
+	}
+
+	return q.Target
+}
+
+// Start implements ServiceAuth, requesting a challenge from Q.
+func (q *QAuth) Start() string {
+	return "CHALLENGE"
+}
+
+// Next implements ServiceAuth.
+func (q *QAuth) Next(notice string) (reply, account string, err error) {
+	fields := strings.Fields(notice)
+	if len(fields) >= 2 && fields[0] == "CHALLENGE" {
+		for _, algorithm := range fields[2:] {
+			if algorithm == "HMAC-SHA-256" {
+				return "CHALLENGEAUTH " + q.Account + " " + q.response(fields[1]) + " HMAC-SHA-256", "", nil
+			}
+		}
+
+		return "", "", errors.New("Q does not support HMAC-SHA-256 challenges")
+	}
+
+	text := strings.ToLower(notice)
+	switch {
+	case strings.HasPrefix(text, "you are now logged in as "):
+		return "", strings.TrimSuffix(notice[len("you are now logged in as "):], "."), nil
+	case strings.Contains(text, "incorrect"), strings.Contains(text, "suspended"), strings.Contains(text, "too many"):
+		return "", "", errors.New(notice)
+	}
+
+	return "", "", nil
+}
+
+// response returns the response to challenge: the HMAC-SHA-256 of the
+// challenge, keyed with the (hex encoded) SHA-256 of the lowercase account
+// and the SHA-256 of the password, which Q only considers the first 10
+// characters of.
+func (q *QAuth) response(challenge string) string {
+	password := q.Password
+	if len(password) > 10 {
+		password = password[:10]
+	}
+
+	hash := sha256.Sum256([]byte(password))
+	key := sha256.Sum256([]byte(ToRFC1459(q.Account) + ":" + hex.EncodeToString(hash[:])))
+
+	mac := hmac.New(sha256.New, []byte(hex.EncodeToString(key[:])))
+	mac.Write([]byte(challenge))
+
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// defaultXService is the target of Undernet's X.
+const defaultXService = "x@channels.undernet.org"
+
+// XAuth authenticates with Undernet's X, using LOGIN. Set Config.HideHost to
+// hide our host once logged in.
+type XAuth struct {
+	// Account is the X account to log in as.
+	Account string
+	// Password is the password of the account.
+	Password string
+	// Target is the target of X. Defaults to "x@channels.undernet.org".
+	Target string
+}
+
+// Service implements ServiceAuth.
+func (x *XAuth) Service() string {
+	if x.Target == "" {
+		return defaultXService
+	}
+
+	return x.Target
+}
+
+// Start implements ServiceAuth.
+func (x *XAuth) Start() string {
+	return "LOGIN " + x.Account + " " + x.Password
+}
+
+// Next implements ServiceAuth.
+func (x *XAuth) Next(notice string) (reply, account string, err error) {
+	text := strings.ToLower(notice)
+	switch {
+	case strings.HasPrefix(text, "authentication successful"), strings.Contains(text, "already authenticated"):
+		return "", x.Account, nil
+	case strings.HasPrefix(text, "authentication failed"), strings.Contains(text, "suspended"):
+		return "", "", errors.New(notice)
+	}
+
+	return "", "", nil
+}
+
+// defaultAuthServService is the target of GameSurge's AuthServ.
+const defaultAuthServService = "AuthServ@Services.GameSurge.net"
+
+// AuthServ authenticates with AuthServ of srvx, as used by GameSurge, using
+// AUTH. Set Config.HideHost to hide our host once logged in.
+type AuthServ struct {
+	// Account is the AuthServ account to log in as.
+	Account string
+	// Password is the password of the account.
+	Password string
+	// Target is the target of AuthServ. Defaults to
+	// "AuthServ@Services.GameSurge.net".
+	Target string
+}
+
+// Service implements ServiceAuth.
+func (a *AuthServ) Service() string {
+	if a.Target == "" {
+		return defaultAuthServService
+	}
+
+	return a.Target
+}
+
+// Start implements ServiceAuth.
+func (a *AuthServ) Start() string {
+	return "AUTH " + a.Account + " " + a.Password
+}
+
+// Next implements ServiceAuth.
+func (a *AuthServ) Next(notice string) (reply, account string, err error) {
+	text := strings.ToLower(notice)
+	switch {
+	case strings.Contains(text, "i recognize you"), strings.Contains(text, "already authenticated"):
+		return "", a.Account, nil
+	case strings.Contains(text, "incorrect password"), strings.Contains(text, "could not find your account"), strings.Contains(text, "suspended"):
+		return "", "", errors.New(notice)
+	}
+
+	return "", "", nil
+}
+
+// authenticate starts authentication with Config.ServiceAuth, if enabled and
+// we aren't already logged in.
+func (c *Client) authenticate() {
+	auth := c.Config.ServiceAuth
+	if auth == nil {
+		return
+	}
+
+	c.state.mu.Lock()
+	if c.state.account != "" {
+		c.state.mu.Unlock()
+		return
+	}
+	c.state.authenticating = true
+	c.state.mu.Unlock()
+
+	c.Send(&Event{Command: PRIVMSG, Params: []string{auth.Service()}, Trailing: auth.Start(), Sensitive: true})
+}
+
+// handleServiceAuth starts authentication with Config.ServiceAuth once
+// connected.
+func handleServiceAuth(c *Client, e Event) {
+	c.authenticate()
+}
+
+// handleServiceAuthNotice passes the notices of the service to
+// Config.ServiceAuth while authenticating. Once logged in, AUTHENTICATED is
+// dispatched (the services these are for don't send RPL_LOGGEDIN), and if
+// authentication failed, IDENTIFY_FAILED.
+func handleServiceAuthNotice(c *Client, e Event) {
+	auth := c.Config.ServiceAuth
+	if auth == nil || e.Source == nil || c.toLower(e.Source.Name) != c.toLower(serviceNick(auth)) {
+		return
+	}
+
+	c.state.mu.RLock()
+	pending := c.state.authenticating
+	c.state.mu.RUnlock()
+
+	if !pending {
+		return
+	}
+
+	reply, account, err := auth.Next(StripRaw(e.Trailing))
+	if reply != "" {
+		c.Send(&Event{Command: PRIVMSG, Params: []string{auth.Service()}, Trailing: reply, Sensitive: true})
+	}
+
+	if account == "" && err == nil {
+		return
+	}
+
+	c.state.mu.Lock()
+	c.state.authenticating = false
+	c.state.mu.Unlock()
+
+	if err != nil {
+		c.RunHandlers(&Event{Command: IDENTIFY_FAILED, Trailing: err.Error()})
+		return
+	}
+
+	c.loggedIn(account)
+
+	if c.Config.HideHost {
+		c.Send(&Event{Command: MODE, Params: []string{c.GetNick(), "+x"}})
+	}
+}
@@ -0,0 +1,152 @@
+// Copyright (c) Liam Stanley <me@liamstanley.io>. All rights reserved. Use
+// of this source code is governed by the MIT license that can be found in
+// the LICENSE file.
+
+package girc
+
+import (
+	"testing"
+	"time"
+)
+
+func TestQAuthResponse(t *testing.T) {
+	// The account is lowercased, and only the first 10 characters of the
+	// password are used.
+	q := &QAuth{Account: "Account", Password: "secretpassword"}
+
+	reply, account, err := q.Next("CHALLENGE 3afabede5c2859fd821e315f889d9a6c HMAC-MD5 HMAC-SHA-1 HMAC-SHA-256 LEGACY-MD5")
+	want := "CHALLENGEAUTH Account ecb153ec8f25531369d88e896fa8252e660b02ec70bfe62cfac03247aef785bb HMAC-SHA-256"
+	if reply != want || account != "" || err != nil {
+		t.Fatalf("Next() = %q, %q, %v, want %q", reply, account, err, want)
+	}
+
+	if reply, _, err = q.Next("CHALLENGE 3afabede5c2859fd821e315f889d9a6c HMAC-MD5 LEGACY-MD5"); reply != "" || err == nil {
+		t.Fatalf("Next() without HMAC-SHA-256 = %q, %v, want an error", reply, err)
+	}
+}
+
+func TestServiceAuth(t *testing.T) {
+	tests := []struct {
+		name   string
+		auth   ServiceAuth
+		lines  []string
+		notice string
+		want   string
+	}{
+		{
+			name: "quakenet",
+			auth: &QAuth{Account: "account", Password: "secret"},
+			lines: []string{
+				"PRIVMSG Q@CServe.quakenet.org :CHALLENGE",
+				":Q!TheQBot@CServe.quakenet.org NOTICE nick :CHALLENGE 3afabede5c2859fd821e315f889d9a6c HMAC-MD5 HMAC-SHA-1 HMAC-SHA-256 LEGACY-MD5",
+				"PRIVMSG Q@CServe.quakenet.org :CHALLENGEAUTH account ",
+			},
+			notice: ":Q!TheQBot@CServe.quakenet.org NOTICE nick :You are now logged in as account.",
+			want:   "AUTHENTICATED account",
+		},
+		{
+			name:   "quakenet failure",
+			auth:   &QAuth{Account: "account", Password: "wrong"},
+			lines:  []string{"PRIVMSG Q@CServe.quakenet.org :CHALLENGE"},
+			notice: ":Q!TheQBot@CServe.quakenet.org NOTICE nick :CHALLENGE 3afabede5c2859fd821e315f889d9a6c HMAC-MD5 LEGACY-MD5",
+			want:   "IDENTIFY_FAILED :Q does not support HMAC-SHA-256 challenges",
+		},
+		{
+			name:   "undernet",
+			auth:   &XAuth{Account: "account", Password: "secret"},
+			lines:  []string{"PRIVMSG x@channels.undernet.org :LOGIN account secret"},
+			notice: ":X!cservice@undernet.org NOTICE nick :AUTHENTICATION SUCCESSFUL as account!",
+			want:   "AUTHENTICATED account",
+		},
+		{
+			name:   "gamesurge failure",
+			auth:   &AuthServ{Account: "account", Password: "wrong"},
+			lines:  []string{"PRIVMSG AuthServ@Services.GameSurge.net :AUTH account wrong"},
+			notice: ":AuthServ!AuthServ@Services.GameSurge.net NOTICE nick :Incorrect password; please try again.",
+			want:   "IDENTIFY_FAILED :Incorrect password; please try again.",
+		},
+	}
+
+	for _, tt := range tests {
+		c, server := mockClient(t, Config{ServiceAuth: tt.auth, HideHost: true})
+
+		events := make(chan Event, 1)
+		for _, cmd := range []string{AUTHENTICATED, IDENTIFY_FAILED} {
+			c.Handlers.Add(cmd, func(c *Client, e Event) { events <- e })
+		}
+
+		server.send(":irc.example.com 001 nick :Welcome")
+		server.send(":irc.example.com 422 nick :MOTD File is missing")
+		for _, line := range tt.lines {
+			if line[0] == 0x3A { // :
+				server.send(line)
+				continue
+			}
+
+			server.expect(line)
+		}
+		server.send(tt.notice)
+
+		select {
+		case e := <-events:
+			if e.String() != tt.want {
+				t.Errorf("%s: got %q, want %q", tt.name, e.String(), tt.want)
+			}
+		case <-time.After(2 * time.Second):
+			t.Errorf("%s: never received %q", tt.name, tt.want)
+		}
+
+		if tt.want[0] == 0x41 { // A
+			server.expect("MODE nick +x")
+
+			if account := c.GetAccount(); account != "account" {
+				t.Errorf("%s: account is %q, want %q", tt.name, account, "account")
+			}
+		}
+
+		c.Stop()
+	}
+}
+
+func TestServiceAuthCasemapping(t *testing.T) {
+	c, server := mockClient(t, Config{ServiceAuth: &XAuth{Account: "account", Password: "secret", Target: "x[1]@services"}})
+	defer c.Stop()
+
+	events := make(chan Event, 2)
+	for _, cmd := range []string{AUTHENTICATED, IDENTIFY_FAILED} {
+		c.Handlers.Add(cmd, func(c *Client, e Event) { events <- e })
+	}
+
+	server.send(":irc.example.com 001 nick :Welcome")
+	server.send(":irc.example.com 005 nick CASEMAPPING=ascii :are supported by this server")
+	server.send(":irc.example.com 422 nick :MOTD File is missing")
+	server.expect("PRIVMSG x[1]@services :LOGIN account secret")
+
+	// With ascii, X{1} isn't the service.
+	server.send(":X{1}!cservice@services NOTICE nick :AUTHENTICATION FAILED as account!")
+	server.send(":X[1]!cservice@services NOTICE nick :AUTHENTICATION SUCCESSFUL as account!")
+
+	select {
+	case e := <-events:
+		if e.Command != AUTHENTICATED {
+			t.Fatalf("got %q, want AUTHENTICATED", e.String())
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("never received AUTHENTICATED")
+	}
+}
+
+func TestServiceAuthLoggedIn(t *testing.T) {
+	c, server := mockClient(t, Config{ServiceAuth: &QAuth{Account: "account", Password: "secret"}})
+	defer c.Stop()
+
+	// Already logged in, e.g. with a certificate.
+	server.send(":irc.example.com 900 nick nick!user@host account :You are now logged in as account")
+	server.send(":irc.example.com 001 nick :Welcome")
+	server.send(":irc.example.com 422 nick :MOTD File is missing")
+	server.send("PING :sync")
+
+	if line := server.expect("P"); line != "PONG sync" {
+		t.Fatalf("unexpected line while logged in: %q", line)
+	}
+}
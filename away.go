@@ -0,0 +1,78 @@
+// Copyright (c) Liam Stanley <me@liamstanley.io>. All rights reserved. Use
+// of this source code is governed by the MIT license that can be found in
+// the LICENSE file.
+
+package girc
+
+import (
+	"strconv"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+// updateAway sets the away status of a tracked user, emitting
+// USER_AWAY_CHANGED if it changed. If msgKnown is false (e.g. from the flags
+// of a WHO reply), the known away message is kept if the user was already
+// away.
+func (c *Client) updateAway(nick string, away bool, msg string, msgKnown bool) {
+	var src *Source
+
+	c.state.mu.Lock()
+	for _, user := range c.state.lookupUsers("nick", nick) {
+		if !away {
+			msg = ""
+		} else if !msgKnown && user.Away {
+			msg = user.AwayMsg
+		}
+
+		if user.Away == away && user.AwayMsg == msg {
+			continue
+		}
+
+		user.Away, user.AwayMsg = away, msg
+		src = &Source{Name: user.Nick, Ident: user.Ident, Host: user.Host}
+	}
+	c.state.mu.Unlock()
+
+	if src != nil {
+		c.RunHandlers(&Event{Command: USER_AWAY_CHANGED, Source: src, Params: []string{strconv.FormatBool(away)}, Trailing: msg})
+	}
+}
+
+// handleRPLAWAY handles RPL_AWAY, which is sent when messaging (or sending
+// a WHOIS for) a user who is away.
+func handleRPLAWAY(c *Client, e Event) {
+	if len(e.Params) < 2 {
+		return
+	}
+
+	c.updateAway(e.Params[1], true, e.Trailing, true)
+}
+
+// whoRefreshLoop periodically sends a WHO query for each tracked channel,
+// to keep the away status of users up to date, if the server doesn't
+// support away-notify. See Config.WhoRefreshInterval.
+func (c *Client) whoRefreshLoop(ctx context.Context) {
+	if c.Config.WhoRefreshInterval <= 0 || c.Config.disableTracking || c.Config.TwitchMode {
+		return
+	}
+
+	ticker := time.NewTicker(c.Config.WhoRefreshInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if c.HasCapability("away-notify") {
+				continue
+			}
+
+			for _, channel := range c.Channels() {
+				c.Send(&Event{Command: WHO, Params: whoxParams(channel, whoxTrackingToken, whoxTrackingFields)})
+			}
+		}
+	}
+}
@@ -0,0 +1,57 @@
+// Copyright (c) Liam Stanley <me@liamstanley.io>. All rights reserved. Use
+// of this source code is governed by the MIT license that can be found in
+// the LICENSE file.
+
+package girc
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestAwayTracking(t *testing.T) {
+	c := New(Config{Server: "irc.example.com", Nick: "nick", User: "user"})
+
+	var events []string
+	c.Handlers.Add(USER_AWAY_CHANGED, func(c *Client, e Event) {
+		events = append(events, e.Source.Name+" "+e.Params[0]+" "+e.Trailing)
+	})
+
+	tests := []struct {
+		line    string
+		away    bool
+		awayMsg string
+	}{
+		{line: ":irc.example.com 354 nick 1 #channel ident example.com other H account :Other User", away: false},
+		{line: ":irc.example.com 352 nick #channel ident example.com irc.example.com other G@ :0 Other User", away: true},
+		{line: ":irc.example.com 301 nick other :gone fishing", away: true, awayMsg: "gone fishing"},
+		// The away message from WHO replies isn't known, so it's kept.
+		{line: ":irc.example.com 354 nick 1 #channel ident example.com other G account :Other User", away: true, awayMsg: "gone fishing"},
+		{line: ":other!ident@example.com AWAY", away: false},
+		{line: ":other!ident@example.com AWAY :lunch", away: true, awayMsg: "lunch"},
+	}
+
+	for _, tt := range tests {
+		c.RunHandlers(ParseEvent(tt.line))
+
+		user, ok := c.LookupUser("other")
+		if !ok || user.Away != tt.away || user.AwayMsg != tt.awayMsg {
+			t.Fatalf("after %q: LookupUser() = %#v, want away %t with message %q", tt.line, user, tt.away, tt.awayMsg)
+		}
+	}
+
+	if user := c.Lookup("#channel").Lookup("other"); user.Extras.Name != "Other User" || user.Extras.Account != "account" {
+		t.Fatalf("unexpected user state: %#v", user)
+	}
+
+	want := []string{
+		"other true ",
+		"other true gone fishing",
+		"other false ",
+		"other true lunch",
+	}
+
+	if !reflect.DeepEqual(events, want) {
+		t.Fatalf("USER_AWAY_CHANGED events = %q, want %q", events, want)
+	}
+}
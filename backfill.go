@@ -0,0 +1,279 @@
+// Copyright (c) Liam Stanley <me@liamstanley.io>. All rights reserved. Use
+// of this source code is governed by the MIT license that can be found in
+// the LICENSE file.
+
+package girc
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+const (
+	// defaultBackfillLimit is the default maximum amount of messages
+	// requested per channel.
+	defaultBackfillLimit = 500
+	// defaultBackfillWindow is the default amount of message IDs remembered
+	// per channel.
+	defaultBackfillWindow = 1000
+	// defaultBackfillTimeout is the default time allowed to backfill a
+	// channel.
+	defaultBackfillTimeout = 30 * time.Second
+)
+
+// Backfill configures requesting the messages of channels which were missed
+// while disconnected, with the chathistory capability. Once a channel is
+// rejoined after reconnecting, the messages since the last one we received
+// are requested, and dispatched as usual (with Event.Replayed set), skipping
+// those which were already received: by message ID (the "msgid" tag), or if
+// the server doesn't send them, by time. Replayed events only go through
+// user handlers, and are written to the chat log (see Config.ChatLog).
+// Requires tracking to be enabled.
+type Backfill struct {
+	// Limit is the maximum amount of messages requested per channel.
+	// Defaults to 500.
+	Limit int
+	// Window is the amount of recent message IDs remembered per channel, to
+	// skip messages which were already received. Defaults to 1000.
+	Window int
+	// Timeout is the time allowed to backfill a channel. Defaults to 30
+	// seconds.
+	Timeout time.Duration
+}
+
+// limit returns the maximum amount of messages requested per channel.
+func (b *Backfill) limit() int {
+	if b.Limit <= 0 {
+		return defaultBackfillLimit
+	}
+
+	return b.Limit
+}
+
+// window returns the amount of message IDs remembered per channel.
+func (b *Backfill) window() int {
+	if b.Window <= 0 {
+		return defaultBackfillWindow
+	}
+
+	return b.Window
+}
+
+// timeout returns the time allowed to backfill a channel.
+func (b *Backfill) timeout() time.Duration {
+	if b.Timeout <= 0 {
+		return defaultBackfillTimeout
+	}
+
+	return b.Timeout
+}
+
+// backfillTracker tracks the last message received in each channel, to
+// backfill them after reconnecting.
+type backfillTracker struct {
+	mu       sync.Mutex
+	channels map[string]*backfillChannel
+}
+
+// backfillChannel is a channel which is tracked for backfilling.
+type backfillChannel struct {
+	// last is the last message received.
+	last HistorySelector
+	// pending is true after reconnecting, until the channel has been
+	// rejoined.
+	pending bool
+	// ids are the recent message IDs received, and order is the same IDs,
+	// oldest first.
+	ids   map[string]struct{}
+	order []string
+}
+
+// seen records msgid as received, returning true if it already was.
+func (ch *backfillChannel) seen(msgid string, window int) bool {
+	if _, ok := ch.ids[msgid]; ok {
+		return true
+	}
+
+	if ch.ids == nil {
+		ch.ids = make(map[string]struct{})
+	}
+
+	ch.ids[msgid] = struct{}{}
+	ch.order = append(ch.order, msgid)
+	for len(ch.order) > window {
+		delete(ch.ids, ch.order[0])
+		ch.order = ch.order[1:]
+	}
+
+	return false
+}
+
+// handleBackfillMessage records the messages received in channels, if
+// backfilling is enabled.
+func handleBackfillMessage(c *Client, e Event) {
+	conf := c.Config.Backfill
+	if conf == nil || len(e.Params) < 1 || !IsValidChannel(e.Params[0]) {
+		return
+	}
+
+	msgid, _ := e.Tag("msgid")
+	id := c.toLower(e.Params[0])
+
+	c.backfills.mu.Lock()
+	defer c.backfills.mu.Unlock()
+
+	if c.backfills.channels == nil {
+		c.backfills.channels = make(map[string]*backfillChannel)
+	}
+
+	ch := c.backfills.channels[id]
+	if ch == nil {
+		ch = &backfillChannel{}
+		c.backfills.channels[id] = ch
+	}
+
+	ch.last = HistorySelector{MsgID: msgid, Time: e.Timestamp}
+	if msgid != "" {
+		ch.seen(msgid, conf.window())
+	}
+}
+
+// handleBackfillConnect marks the channels we were in to be backfilled,
+// once they're rejoined.
+func handleBackfillConnect(c *Client, e Event) {
+	c.backfills.mu.Lock()
+	for _, ch := range c.backfills.channels {
+		ch.pending = true
+	}
+	c.backfills.mu.Unlock()
+}
+
+// handleBackfillMembership backfills channels once they're rejoined, and
+// forgets those we leave.
+func handleBackfillMembership(c *Client, e Event) {
+	if c.Config.Backfill == nil || len(e.Params) < 1 {
+		return
+	}
+
+	channel := e.Params[0]
+	id := c.toLower(channel)
+
+	switch e.Command {
+	case JOIN:
+		if !c.isSelf(e.Source) {
+			return
+		}
+
+		c.backfills.mu.Lock()
+		ch := c.backfills.channels[id]
+		if ch == nil || !ch.pending || ch.last.IsZero() {
+			c.backfills.mu.Unlock()
+			return
+		}
+		ch.pending = false
+		from := ch.last
+		c.backfills.mu.Unlock()
+
+		// Requesting history waits for the replies, which are dispatched
+		// after this handler.
+		go c.backfill(channel, from)
+	case PART, KICK:
+		if (e.Command == PART && !c.isSelf(e.Source)) || (e.Command == KICK && (len(e.Params) < 2 || !c.isSelf(&Source{Name: e.Params[1]}))) {
+			return
+		}
+
+		c.backfills.mu.Lock()
+		delete(c.backfills.channels, id)
+		c.backfills.mu.Unlock()
+	}
+}
+
+// backfill requests the messages of channel since from, dispatching those
+// which weren't received yet. See Config.Backfill.
+func (c *Client) backfill(channel string, from HistorySelector) {
+	conf := c.Config.Backfill
+
+	ctx, cancel := context.WithTimeout(context.Background(), conf.timeout())
+	defer cancel()
+
+	c.state.mu.RLock()
+	page := c.state.isupport.ChatHistory
+	c.state.mu.RUnlock()
+
+	if page <= 0 {
+		page = defaultHistoryLimit
+	}
+
+	since := from.Time
+	for remaining := conf.limit(); remaining > 0; {
+		if page > remaining {
+			page = remaining
+		}
+
+		events, err := c.Commands.ChatHistory(ctx, channel, ChatHistoryOptions{Subcommand: HistoryAfter, From: from, Limit: page})
+		var failed *ErrChatHistoryFailed
+		if errors.As(err, &failed) && from.MsgID != "" && !from.Time.IsZero() {
+			// The server may no longer know the message, e.g. if it has
+			// expired, so retry by time.
+			from.MsgID = ""
+			continue
+		}
+
+		if err != nil {
+			c.debug.Printf("unable to backfill %s: %s", channel, err)
+			return
+		}
+
+		for _, e := range events {
+			c.replay(channel, since, e)
+
+			if msgid, _ := e.Tag("msgid"); msgid != "" || !e.Timestamp.IsZero() {
+				from = HistorySelector{MsgID: msgid, Time: e.Timestamp}
+			}
+		}
+
+		if len(events) < page {
+			return
+		}
+		remaining -= len(events)
+	}
+}
+
+// replay dispatches an event from the history of channel, unless it was
+// already received (by its msgid, or if it doesn't have one, if it's from
+// before since).
+func (c *Client) replay(channel string, since time.Time, e *Event) {
+	msgid, _ := e.Tag("msgid")
+	id := c.toLower(channel)
+
+	c.backfills.mu.Lock()
+	var dup bool
+	ch := c.backfills.channels[id]
+	if ch != nil && msgid != "" {
+		dup = ch.seen(msgid, c.Config.Backfill.window())
+	} else if msgid == "" {
+		dup = !e.Timestamp.After(since)
+	}
+
+	// Only messages are used to backfill from, as with those received.
+	if ch != nil && !dup && (e.Command == PRIVMSG || e.Command == NOTICE || e.Command == TAGMSG) && e.Timestamp.After(ch.last.Time) {
+		ch.last = HistorySelector{MsgID: msgid, Time: e.Timestamp}
+	}
+	c.backfills.mu.Unlock()
+
+	if dup {
+		return
+	}
+
+	e.Replayed = true
+	c.dispatch(e)
+
+	// The chat log is written by an internal handler, which replayed
+	// events don't go through.
+	if c.chatlog != nil {
+		handleChatLog(c, *e)
+	}
+}
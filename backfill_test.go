@@ -0,0 +1,118 @@
+// Copyright (c) Liam Stanley <me@liamstanley.io>. All rights reserved. Use
+// of this source code is governed by the MIT license that can be found in
+// the LICENSE file.
+
+package girc
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestBackfillSeen(t *testing.T) {
+	ch := &backfillChannel{}
+
+	for _, id := range []string{"a", "b", "c"} {
+		if ch.seen(id, 2) {
+			t.Errorf("seen(%q) = true for a new message", id)
+		}
+	}
+
+	if !ch.seen("c", 2) {
+		t.Error("seen(c) = false for a remembered message")
+	}
+
+	// a has been forgotten, as only 2 ids are remembered.
+	if ch.seen("a", 2) {
+		t.Error("seen(a) = true for a forgotten message")
+	}
+}
+
+func TestBackfill(t *testing.T) {
+	c, server := mockClient(t, Config{AllowFlood: true, Backfill: &Backfill{}})
+	defer c.Stop()
+
+	c.state.mu.Lock()
+	c.state.enabledCap = []string{"batch", "draft/chathistory", "message-tags", "server-time"}
+	c.state.mu.Unlock()
+
+	var mu sync.Mutex
+	var replayed []string
+	received := make(chan struct{}, 10)
+	c.Handlers.Add(PRIVMSG, func(c *Client, e Event) {
+		if !e.Replayed {
+			return
+		}
+
+		mu.Lock()
+		replayed = append(replayed, e.Trailing)
+		mu.Unlock()
+		received <- struct{}{}
+	})
+
+	server.send(":nick!user@host JOIN #channel")
+	server.send("@msgid=a;time=2020-01-01T00:00:01.000Z :other!user@host PRIVMSG #channel :first")
+	server.send("@msgid=b;time=2020-01-01T00:00:02.000Z :other!user@host PRIVMSG #channel :second")
+	server.send("PING :sync")
+	server.expect("PONG sync")
+
+	// Reconnected, and rejoined.
+	server.send(":irc.example.com 001 nick :Welcome")
+	server.send(":nick!user@host JOIN #channel")
+	server.expect("CHATHISTORY AFTER #channel msgid=b 50")
+
+	server.send(":irc.example.com BATCH +ref chathistory #channel")
+	server.send("@batch=ref;msgid=b;time=2020-01-01T00:00:02.000Z :other!user@host PRIVMSG #channel :second")
+	server.send("@batch=ref;msgid=c;time=2020-01-01T00:00:03.000Z :late!user@host JOIN #channel")
+	server.send("@batch=ref;msgid=d;time=2020-01-01T00:00:04.000Z :other!user@host PRIVMSG #channel :third")
+	server.send("@batch=ref;time=2020-01-01T00:00:05.000Z :other!user@host PRIVMSG #channel :fourth")
+	server.send(":irc.example.com BATCH -ref")
+
+	for i := 0; i < 2; i++ {
+		select {
+		case <-received:
+		case <-time.After(2 * time.Second):
+			t.Fatal("timed out waiting for replayed messages")
+		}
+	}
+
+	server.send("PING :sync")
+	server.expect("PONG sync")
+
+	mu.Lock()
+	if len(replayed) != 2 || replayed[0] != "third" || replayed[1] != "fourth" {
+		t.Errorf("replayed = %q, want third and fourth", replayed)
+	}
+	mu.Unlock()
+
+	// Replayed events don't affect the state.
+	if user, ok := c.LookupUser("late"); ok {
+		t.Errorf("replayed JOIN added %v to the state", user)
+	}
+
+	c.backfills.mu.Lock()
+	last := c.backfills.channels["#channel"].last
+	c.backfills.mu.Unlock()
+	if want := time.Date(2020, 1, 1, 0, 0, 5, 0, time.UTC); !last.Time.Equal(want) || last.MsgID != "" {
+		t.Errorf("last message = %v, want the replayed message at %v", last, want)
+	}
+}
+
+func TestBackfillCasemapping(t *testing.T) {
+	c := New(Config{Server: "irc.example.com", Nick: "nick", User: "user", Backfill: &Backfill{}})
+	c.state.mu.Lock()
+	c.state.isupport.Casemapping = CaseMappingASCII
+	c.state.mu.Unlock()
+
+	handleBackfillMessage(c, *ParseEvent("@msgid=a :other!user@host PRIVMSG #a[b] :first"))
+	handleBackfillMessage(c, *ParseEvent("@msgid=b :other!user@host PRIVMSG #A{B} :second"))
+	handleBackfillMessage(c, *ParseEvent("@msgid=c :other!user@host PRIVMSG #A[B] :third"))
+
+	c.backfills.mu.Lock()
+	defer c.backfills.mu.Unlock()
+
+	if len(c.backfills.channels) != 2 || c.backfills.channels["#a[b]"] == nil || c.backfills.channels["#a[b]"].last.MsgID != "c" {
+		t.Fatalf("channels = %v, want #a[b] and #a{b} tracked separately with ascii", c.backfills.channels)
+	}
+}
@@ -0,0 +1,125 @@
+// Copyright (c) Liam Stanley <me@liamstanley.io>. All rights reserved. Use
+// of this source code is governed by the MIT license that can be found in
+// the LICENSE file.
+
+package girc
+
+import (
+	"net"
+	"strings"
+)
+
+// BanStyle is the style of ban mask generated by BanMask.
+type BanStyle int
+
+const (
+	// BanHost bans the host of the user, with any nickname and ident, e.g.
+	// "*!*@host.example.com". This is the most common style.
+	BanHost BanStyle = iota
+	// BanIdentHost bans the ident of the user, on their host, e.g.
+	// "*!*ident@host.example.com". Useful when many users share a host.
+	BanIdentHost
+	// BanDomain bans the ident of the user, on any host of their domain
+	// (or IPv4 /24, or IPv6 /64), e.g. "*!*ident@*.example.com". Useful
+	// against users with dynamic hosts.
+	BanDomain
+	// BanNick bans the nickname of the user, e.g. "nick!*@*". Easily evaded
+	// by changing nickname.
+	BanNick
+	// BanAccount bans the account of the user, with the account extended
+	// ban (e.g. "$a:account"), which follows the user across hosts. Falls back
+	// to BanHost if the user isn't logged in, or the server doesn't
+	// support it. Only supported by Client.BanMask, as the extban depends
+	// on the server.
+	BanAccount
+)
+
+// BanMask returns a ban mask for the user in the given style, or an empty
+// string if user is nil. If the host of the user isn't known, BanNick is
+// used. BanAccount isn't supported by BanMask (see Client.BanMask), and
+// falls back to BanHost.
+func BanMask(user *User, style BanStyle) string {
+	if user == nil {
+		return ""
+	}
+
+	if user.Host == "" {
+		return user.Nick + "!*@*"
+	}
+
+	switch style {
+	case BanIdentHost:
+		return "*!" + banIdent(user.Ident) + "@" + user.Host
+	case BanDomain:
+		return "*!" + banIdent(user.Ident) + "@" + banDomain(user.Host)
+	case BanNick:
+		return user.Nick + "!*@*"
+	default:
+		return "*!*@" + user.Host
+	}
+}
+
+// BanMask returns a ban mask for the user in the given style, as with
+// BanMask, supporting BanAccount if the server supports the account
+// extended ban (see ISupport.ExtBan).
+func (c *Client) BanMask(user *User, style BanStyle) string {
+	if user == nil {
+		return ""
+	}
+
+	if style == BanAccount && user.Extras.Account != "" && user.Extras.Account != "*" {
+		c.state.mu.RLock()
+		mask, ok := c.state.isupport.ExtBan(ExtBanAccount, user.Extras.Account)
+		c.state.mu.RUnlock()
+
+		if ok {
+			return mask
+		}
+	}
+
+	return BanMask(user, style)
+}
+
+// banIdent returns the ident part of a ban mask for ident. Idents not
+// verified by identd are prefixed with "~" by the server, which the user
+// may avoid by running identd, so the prefix is wildcarded.
+func banIdent(ident string) string {
+	if ident == "" {
+		return "*"
+	}
+
+	return "*" + strings.TrimPrefix(ident, "~")
+}
+
+// banDomain returns the host part of a ban mask covering the domain of host,
+// e.g. "*.example.com" for "dsl-1-2-3-4.example.com". IPv4 addresses are
+// covered by their /24, and IPv6 addresses by their /64. Hosts which are too
+// short to have a domain (or are cloaks, e.g. "user/nick") are returned
+// as-is.
+func banDomain(host string) string {
+	if ip := net.ParseIP(host); ip != nil {
+		if ip.To4() != nil {
+			return host[:strings.LastIndexByte(host, 0x2E)+1] + "*" // .
+		}
+
+		// The host is matched as a string, so the server's formatting of
+		// the address must be kept.
+		groups := strings.Split(host, ":")
+		if len(groups) == 8 {
+			return strings.Join(groups[:4], ":") + ":*"
+		}
+
+		return host
+	}
+
+	if strings.IndexByte(host, 0x2F) >= 0 { // /
+		return host
+	}
+
+	labels := strings.Split(host, ".")
+	if len(labels) < 3 {
+		return host
+	}
+
+	return "*." + strings.Join(labels[1:], ".")
+}
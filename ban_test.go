@@ -0,0 +1,64 @@
+// Copyright (c) Liam Stanley <me@liamstanley.io>. All rights reserved. Use
+// of this source code is governed by the MIT license that can be found in
+// the LICENSE file.
+
+package girc
+
+import "testing"
+
+func TestBanMask(t *testing.T) {
+	user := &User{Nick: "nick", Ident: "~ident", Host: "dsl-1-2-3-4.isp.example.com"}
+
+	tests := []struct {
+		user  *User
+		style BanStyle
+		want  string
+	}{
+		{user: user, style: BanHost, want: "*!*@dsl-1-2-3-4.isp.example.com"},
+		{user: user, style: BanIdentHost, want: "*!*ident@dsl-1-2-3-4.isp.example.com"},
+		{user: user, style: BanDomain, want: "*!*ident@*.isp.example.com"},
+		{user: user, style: BanNick, want: "nick!*@*"},
+		{user: user, style: BanAccount, want: "*!*@dsl-1-2-3-4.isp.example.com"},
+		{user: &User{Nick: "nick", Ident: "ident", Host: "192.0.2.55"}, style: BanDomain, want: "*!*ident@192.0.2.*"},
+		{user: &User{Nick: "nick", Ident: "ident", Host: "2001:db8:1:2:3:4:5:6"}, style: BanDomain, want: "*!*ident@2001:db8:1:2:*"},
+		{user: &User{Nick: "nick", Ident: "ident", Host: "2001:db8::1"}, style: BanDomain, want: "*!*ident@2001:db8::1"},
+		{user: &User{Nick: "nick", Ident: "ident", Host: "user/nick"}, style: BanDomain, want: "*!*ident@user/nick"},
+		{user: &User{Nick: "nick", Ident: "ident", Host: "example.com"}, style: BanDomain, want: "*!*ident@example.com"},
+		{user: &User{Nick: "nick"}, style: BanHost, want: "nick!*@*"},
+		{user: nil, style: BanHost, want: ""},
+	}
+
+	for _, tt := range tests {
+		if got := BanMask(tt.user, tt.style); got != tt.want {
+			t.Errorf("BanMask(%v, %d) = %q, want %q", tt.user, tt.style, got, tt.want)
+		}
+	}
+}
+
+func TestClientBanMask(t *testing.T) {
+	c := New(Config{Server: "irc.example.com", Nick: "nick", User: "user"})
+
+	user := &User{Nick: "nick", Ident: "ident", Host: "host.example.com"}
+	user.Extras.Account = "account"
+
+	if got, want := c.BanMask(user, BanAccount), "*!*@host.example.com"; got != want {
+		t.Errorf("BanMask() without EXTBAN = %q, want %q", got, want)
+	}
+
+	c.state.mu.Lock()
+	c.state.isupport.parse("EXTBAN=$,ajrxz")
+	c.state.mu.Unlock()
+
+	if got, want := c.BanMask(user, BanAccount), "$a:account"; got != want {
+		t.Errorf("BanMask() = %q, want %q", got, want)
+	}
+
+	if got, want := c.BanMask(user, BanHost), "*!*@host.example.com"; got != want {
+		t.Errorf("BanMask() = %q, want %q", got, want)
+	}
+
+	user.Extras.Account = ""
+	if got, want := c.BanMask(user, BanAccount), "*!*@host.example.com"; got != want {
+		t.Errorf("BanMask() when not logged in = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,201 @@
+// Copyright (c) Liam Stanley <me@liamstanley.io>. All rights reserved. Use
+// of this source code is governed by the MIT license that can be found in
+// the LICENSE file.
+
+package girc
+
+import (
+	"strings"
+	"sync"
+	"time"
+)
+
+// Batch is an IRCv3 batch, a group of events which the server sends
+// together, and which should be processed as a whole (e.g. a netsplit, or
+// a chathistory response). See https://ircv3.net/specs/extensions/batch.
+//
+// Depending on the batch type, events within a batch are either streamed,
+// in which case they are dispatched as usual with Event.Batch set to the
+// batch they belong to, or collected, in which case they aren't dispatched
+// at all, and instead a single BATCH_COMPLETE event is dispatched once the
+// batch has been closed, with Event.Batch containing all of the events. See
+// Config.CollectBatches.
+//
+// Batches are shared between events, and should be treated as read-only.
+type Batch struct {
+	// Ref is the reference tag of the batch, which events within the batch
+	// reference with their "batch" tag.
+	Ref string
+	// Type is the type of the batch, e.g. "netsplit" or "chathistory".
+	Type string
+	// Params are the parameters of the batch, following the type.
+	Params []string
+	// Tags are the tags of the BATCH event which opened the batch.
+	Tags Tags
+	// Source is the source of the BATCH event which opened the batch.
+	Source *Source
+	// Timestamp is the time of the BATCH event which opened the batch. See
+	// Event.Timestamp.
+	Timestamp time.Time
+	// Parent is the batch this batch is nested in, if any.
+	Parent *Batch
+	// Events are the events within the batch, in the order they were
+	// received. Only set for collected batches, and complete once the
+	// BATCH_COMPLETE event has been dispatched.
+	Events []*Event
+
+	// collect is true if the events within the batch are collected, rather
+	// than streamed.
+	collect bool
+}
+
+// collectedBatchTypes are batch types which are always collected, as their
+// events shouldn't be handled as if they happened now, or only make sense
+// as a whole.
+var collectedBatchTypes = []string{"chathistory", "draft/chathistory", multilineBatchType, playbackBatchType}
+
+// batchTracker keeps track of the batches the server has opened.
+type batchTracker struct {
+	mu   sync.Mutex
+	open map[string]*Batch
+}
+
+// reset discards all open batches, e.g. when reconnecting.
+func (t *batchTracker) reset() {
+	t.mu.Lock()
+	t.open = nil
+	t.mu.Unlock()
+}
+
+// process tracks BATCH events, and associates events with the batch they
+// belong to. It returns the events which should be dispatched in place of
+// the event, which is empty if the event was collected.
+func (t *batchTracker) process(c *Client, e *Event) []*Event {
+	ref, inBatch := e.Tag("batch")
+	if e.Command != BATCH && !inBatch {
+		return []*Event{e}
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	var parent *Batch
+	if inBatch {
+		parent = t.open[ref]
+	}
+
+	if e.Command != BATCH || len(e.Params) == 0 || len(e.Params[0]) < 2 {
+		return t.deliver(parent, e)
+	}
+
+	ref = e.Params[0][1:]
+
+	switch e.Params[0][0] {
+	case 0x2B: // +
+		if len(e.Params) < 2 {
+			return t.deliver(parent, e)
+		}
+
+		b := &Batch{
+			Ref:       ref,
+			Type:      e.Params[1],
+			Params:    append([]string(nil), e.Params[2:]...),
+			Tags:      e.Copy().LoadTags(),
+			Timestamp: e.Timestamp,
+			Parent:    parent,
+		}
+
+		if e.Source != nil {
+			b.Source = e.Copy().Source
+		}
+
+		b.collect = (parent != nil && parent.collect) || c.collectBatch(b.Type)
+
+		if t.open == nil {
+			t.open = make(map[string]*Batch)
+		}
+		t.open[ref] = b
+
+		if b.collect {
+			return nil
+		}
+
+		e.Batch = b
+		return []*Event{e}
+	case 0x2D: // -
+		b, ok := t.open[ref]
+		if !ok {
+			return t.deliver(parent, e)
+		}
+		delete(t.open, ref)
+
+		if !b.collect {
+			e.Batch = b
+			return []*Event{e}
+		}
+
+		var result *Event
+		if b.Type == multilineBatchType {
+			result = reassembleMultiline(b)
+		} else {
+			result = &Event{
+				Source:    b.Source,
+				Tags:      b.Tags,
+				Command:   BATCH_COMPLETE,
+				Params:    append([]string{b.Type}, b.Params...),
+				Batch:     b,
+				Timestamp: b.Timestamp,
+			}
+
+			if b.Parent != nil {
+				result.Tags = result.Copy().Tags
+				if result.Tags == nil {
+					result.Tags = Tags{}
+				}
+				result.Tags["batch"] = b.Parent.Ref
+			}
+		}
+
+		if result == nil {
+			return nil
+		}
+
+		return t.deliver(b.Parent, result)
+	}
+
+	return t.deliver(parent, e)
+}
+
+// deliver either collects the event into batch, if it is being collected,
+// or returns it to be dispatched with Event.Batch set.
+func (t *batchTracker) deliver(b *Batch, e *Event) []*Event {
+	if b == nil {
+		return []*Event{e}
+	}
+
+	e.Batch = b
+	if b.collect {
+		b.Events = append(b.Events, e)
+		return nil
+	}
+
+	return []*Event{e}
+}
+
+// collectBatch returns true if events within batches of the given type
+// should be collected, rather than streamed.
+func (c *Client) collectBatch(batchType string) bool {
+	for _, t := range collectedBatchTypes {
+		if t == batchType {
+			return true
+		}
+	}
+
+	for _, t := range c.Config.CollectBatches {
+		if strings.EqualFold(t, batchType) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,111 @@
+// Copyright (c) Liam Stanley <me@liamstanley.io>. All rights reserved. Use
+// of this source code is governed by the MIT license that can be found in
+// the LICENSE file.
+
+package girc
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestBatchStreamed(t *testing.T) {
+	c := New(Config{Server: "irc.example.com", Nick: "nick", User: "user"})
+
+	var events []Event
+	c.Handlers.Add(ALLEVENTS, func(c *Client, e Event) {
+		events = append(events, e)
+	})
+
+	for _, line := range []string{
+		":irc.example.com BATCH +ref netsplit irc.a.net irc.b.net",
+		"@batch=ref :other!user@host QUIT :irc.a.net irc.b.net",
+		":irc.example.com BATCH -ref",
+		":other!user@host PRIVMSG #channel :not in a batch",
+	} {
+		c.RunHandlers(ParseEvent(line))
+	}
+
+	if len(events) != 4 {
+		t.Fatalf("got %d events, want 4: %v", len(events), events)
+	}
+
+	for i, e := range events[:3] {
+		if e.Batch == nil || e.Batch.Ref != "ref" || e.Batch.Type != "netsplit" {
+			t.Fatalf("event %d (%q) has batch %#v", i, e.String(), e.Batch)
+		}
+	}
+
+	if want := []string{"irc.a.net", "irc.b.net"}; !reflect.DeepEqual(events[1].Batch.Params, want) {
+		t.Fatalf("batch params = %q, want %q", events[1].Batch.Params, want)
+	}
+	if len(events[1].Batch.Events) != 0 {
+		t.Fatal("streamed batch collected events")
+	}
+	if events[3].Batch != nil {
+		t.Fatal("event outside of a batch has a batch")
+	}
+}
+
+func TestBatchCollected(t *testing.T) {
+	c := New(Config{Server: "irc.example.com", Nick: "nick", User: "user", CollectBatches: []string{"netsplit"}})
+
+	var events []Event
+	c.Handlers.Add(ALLEVENTS, func(c *Client, e Event) {
+		events = append(events, e)
+	})
+
+	for _, line := range []string{
+		"@time=2020-01-01T00:00:00.000Z :irc.example.com BATCH +outer chathistory #channel",
+		"@batch=outer :other!user@host PRIVMSG #channel :first",
+		"@batch=outer :irc.example.com BATCH +inner draft/multiline #channel",
+		"@batch=inner :other!user@host PRIVMSG #channel :second",
+		"@batch=inner :other!user@host PRIVMSG #channel :third",
+		":irc.example.com BATCH -inner",
+		":irc.example.com BATCH +split netsplit irc.a.net irc.b.net",
+		"@batch=split :gone!user@host QUIT :irc.a.net irc.b.net",
+		":irc.example.com BATCH -split",
+		":irc.example.com BATCH -outer",
+	} {
+		c.RunHandlers(ParseEvent(line))
+	}
+
+	if len(events) != 2 {
+		t.Fatalf("got %d events, want 2: %v", len(events), events)
+	}
+
+	split := events[0]
+	if split.Command != BATCH_COMPLETE || !reflect.DeepEqual(split.Params, []string{"netsplit", "irc.a.net", "irc.b.net"}) {
+		t.Fatalf("unexpected netsplit event: %q", split.String())
+	}
+	if len(split.Batch.Events) != 1 || split.Batch.Events[0].Command != QUIT {
+		t.Fatalf("unexpected netsplit batch events: %v", split.Batch.Events)
+	}
+
+	history := events[1]
+	if history.Command != BATCH_COMPLETE || !reflect.DeepEqual(history.Params, []string{"chathistory", "#channel"}) {
+		t.Fatalf("unexpected chathistory event: %q", history.String())
+	}
+	if ts, _ := history.Tags.Get("time"); ts != "2020-01-01T00:00:00.000Z" {
+		t.Fatalf("chathistory event has time tag %q", ts)
+	}
+	if want := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC); !history.Timestamp.Equal(want) {
+		t.Fatalf("chathistory event has timestamp %v, want %v", history.Timestamp, want)
+	}
+
+	var messages []string
+	for _, e := range history.Batch.Events {
+		if e.Batch != history.Batch {
+			t.Fatalf("event %q has batch %#v", e.String(), e.Batch)
+		}
+
+		if e.Command == PRIVMSG {
+			messages = append(messages, e.Trailing)
+		}
+	}
+
+	if want := []string{"first", "second\nthird"}; !reflect.DeepEqual(messages, want) {
+		t.Fatalf("chathistory messages = %q, want %q", messages, want)
+	}
+}
@@ -0,0 +1,54 @@
+// Copyright (c) Liam Stanley <me@liamstanley.io>. All rights reserved. Use
+// of this source code is governed by the MIT license that can be found in
+// the LICENSE file.
+
+package girc
+
+// botTags are the message tags servers attach to messages sent by users
+// with the bot mode set. See https://ircv3.net/specs/extensions/bot-mode.
+var botTags = []string{"bot", "draft/bot"}
+
+// isBotEvent returns true if the event was sent by a user marked as a bot.
+func isBotEvent(e *Event) bool {
+	for _, tag := range botTags {
+		if _, ok := e.Tag(tag); ok {
+			return true
+		}
+	}
+
+	return false
+}
+
+// setBotMode marks us as a bot once connected, if enabled with Config.Bot
+// and the server advertises a bot mode (see ISupport.Bot).
+func setBotMode(c *Client, e Event) {
+	if !c.Config.Bot {
+		return
+	}
+
+	c.state.mu.RLock()
+	mode := c.state.isupport.Bot
+	c.state.mu.RUnlock()
+
+	if mode == "" {
+		return
+	}
+
+	c.Send(&Event{Command: MODE, Params: []string{c.GetNick(), ModeAddPrefix + mode}})
+}
+
+// handleBotTag keeps User.IsBot up to date, from the bot tag which the
+// server attaches to messages from bots.
+func handleBotTag(c *Client, e Event) {
+	if e.Source == nil || e.Source.Ident == "" || !c.HasCapability("message-tags") {
+		return
+	}
+
+	bot := isBotEvent(&e)
+
+	c.updateUsers(e.Source.Name, func(user *User) bool {
+		return user.IsBot != bot
+	}, func(user *User) {
+		user.IsBot = bot
+	})
+}
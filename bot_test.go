@@ -0,0 +1,57 @@
+// Copyright (c) Liam Stanley <me@liamstanley.io>. All rights reserved. Use
+// of this source code is governed by the MIT license that can be found in
+// the LICENSE file.
+
+package girc
+
+import "testing"
+
+func TestBotMode(t *testing.T) {
+	c, server := mockClient(t, Config{Bot: true})
+	defer c.Stop()
+
+	server.send(":irc.example.com 001 nick :Welcome")
+	server.send(":irc.example.com 005 nick BOT=B :are supported by this server")
+	server.send(":irc.example.com 422 nick :MOTD File is missing")
+	server.expect("MODE nick +B")
+}
+
+func TestBotTracking(t *testing.T) {
+	c := New(Config{Server: "irc.example.com", Nick: "nick", User: "user", IgnoreBots: true})
+	c.state.enabledCap = []string{"message-tags"}
+
+	var messages []string
+	c.Handlers.Add(PRIVMSG, func(c *Client, e Event) {
+		messages = append(messages, e.Trailing)
+	})
+
+	isBot := func(nick string) bool {
+		user, _ := c.LookupUser(nick)
+		return user != nil && user.IsBot
+	}
+
+	for _, line := range []string{
+		":irc.example.com 005 nick BOT=B :are supported by this server",
+		":bot!user@host JOIN #channel",
+		":human!user@host JOIN #channel",
+		":irc.example.com 352 nick #channel user host irc.example.com bot HB :0 A Bot",
+		":irc.example.com 352 nick #channel user host irc.example.com human H@ :0 A Human",
+	} {
+		c.RunHandlers(ParseEvent(line))
+	}
+
+	if !isBot("bot") || isBot("human") {
+		t.Fatalf("bot flags from WHO weren't tracked: bot=%t human=%t", isBot("bot"), isBot("human"))
+	}
+
+	c.RunHandlers(ParseEvent("@bot :human!user@host PRIVMSG #channel :beep"))
+	c.RunHandlers(ParseEvent(":bot!user@host PRIVMSG #channel :hello"))
+
+	if isBot("bot") || !isBot("human") {
+		t.Fatalf("bot tags weren't tracked: bot=%t human=%t", isBot("bot"), isBot("human"))
+	}
+
+	if len(messages) != 1 || messages[0] != "hello" {
+		t.Fatalf("unexpected messages with IgnoreBots: %q", messages)
+	}
+}
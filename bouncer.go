@@ -0,0 +1,173 @@
+// Copyright (c) Liam Stanley <me@liamstanley.io>. All rights reserved. Use
+// of this source code is governed by the MIT license that can be found in
+// the LICENSE file.
+
+package girc
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+const (
+	// capSelfMessage is the ZNC capability which delivers the messages we
+	// sent from other clients of the bouncer to us, with us as the source.
+	capSelfMessage = "znc.in/self-message"
+	// capPlayback is the ZNC capability to request buffer playback on
+	// demand (see Commands.Playback), rather than on every attach.
+	capPlayback = "znc.in/playback"
+	// playbackBatchType is the batch type ZNC wraps buffer playback in.
+	playbackBatchType = "znc.in/playback"
+	// syncPingPrefix is the prefix of the tokens of PINGs sent to find out
+	// when the server has processed the commands sent before them, which
+	// aren't keep-alive pings.
+	syncPingPrefix = "girc-sync-"
+)
+
+// bouncerCapPrefixes are the prefixes of the vendor capabilities bouncers
+// advertise, which no regular server does.
+var bouncerCapPrefixes = []string{"znc.in/", "soju.im/"}
+
+// ErrPlaybackUnsupported is returned by Commands.Playback when the bouncer
+// doesn't support the znc.in/playback and batch capabilities.
+var ErrPlaybackUnsupported = errors.New("bouncer does not support znc.in/playback")
+
+// IsBouncer returns true if we're connected to a bouncer (e.g. ZNC or soju)
+// rather than directly to a server, as far as can be detected: either by
+// the vendor capabilities it advertises, or by its version (see
+// Client.ServerVersion). Only accurate once registered. Panics if tracking
+// is disabled.
+func (c *Client) IsBouncer() bool {
+	c.panicIfNotTracking()
+
+	c.state.mu.RLock()
+	defer c.state.mu.RUnlock()
+
+	for name := range c.state.capValues {
+		for _, prefix := range bouncerCapPrefixes {
+			if strings.HasPrefix(name, prefix) {
+				return true
+			}
+		}
+	}
+
+	return strings.Contains(strings.ToLower(c.state.serverVersion), "znc")
+}
+
+// Conversation returns the conversation a PRIVMSG, NOTICE or TAGMSG belongs
+// to: the channel, or for private messages, the other user. For messages
+// we sent ourselves (delivered to us by echo-message, or by a bouncer from
+// its other clients with znc.in/self-message), that's the target rather
+// than the source, so replies to the conversation don't end up being sent
+// to ourselves. Empty if the event isn't a message.
+func (c *Client) Conversation(e *Event) string {
+	switch e.Command {
+	case PRIVMSG, NOTICE, TAGMSG:
+	default:
+		return ""
+	}
+
+	if len(e.Params) < 1 {
+		return ""
+	}
+
+	if IsValidChannel(e.Params[0]) || c.isSelf(e.Source) || e.Source == nil {
+		return e.Params[0]
+	}
+
+	return e.Source.Name
+}
+
+// Playback requests the buffered messages of target (a channel or user)
+// from the bouncer, sent after from (and before to, unless zero), and waits
+// for them to be returned, or until ctx is done. This requires the
+// znc.in/playback and batch capabilities (ZNC with the playback module),
+// otherwise ErrPlaybackUnsupported is returned. The returned events are in
+// the order they were buffered (oldest first), with Event.Timestamp set to
+// when they were originally received.
+//
+// Playback batches (including those sent on attach, without the playback
+// capability) are collected rather than dispatched (see Batch), so the
+// backlog isn't handled as if it happened now.
+func (cmd *Commands) Playback(ctx context.Context, target string, from, to time.Time) ([]*Event, error) {
+	if !IsValidNick(target) && !IsValidChannel(target) {
+		return nil, &ErrInvalidTarget{Target: target}
+	}
+
+	c := cmd.c
+	if !c.HasCapability("batch") || !c.HasCapability(capPlayback) {
+		return nil, ErrPlaybackUnsupported
+	}
+	id := c.toLower(target)
+
+	params := []string{"PLAY", target, playbackTime(from)}
+	if !to.IsZero() {
+		params = append(params, playbackTime(to))
+	}
+
+	// Nothing is sent back if there is nothing buffered, so follow up
+	// with a PING, which is answered once the playback has been sent. It's
+	// queued with the PRIVMSG, so it isn't sent first.
+	token := syncPingPrefix + randomRef(10)
+
+	var mu sync.Mutex
+	var events []*Event
+	done := make(chan struct{}, 1)
+
+	cuid := c.Handlers.sregisterOwned(ALLEVENTS, HandlerFunc(func(client *Client, e Event) {
+		switch e.Command {
+		case BATCH_COMPLETE:
+			if e.Batch == nil || e.Batch.Parent != nil || len(e.Params) < 2 || e.Params[0] != playbackBatchType || client.toLower(e.Params[1]) != id {
+				return
+			}
+
+			mu.Lock()
+			events = append(events, e.Batch.Events...)
+			mu.Unlock()
+		case PONG:
+			if pongToken(&e) != token {
+				return
+			}
+
+			select {
+			case done <- struct{}{}:
+			default:
+			}
+		}
+	}))
+	defer c.Handlers.Remove(cuid)
+
+	c.Send(&Event{Command: PRIVMSG, Params: []string{"*playback"}, Trailing: strings.Join(params, " ")})
+	c.writePriority(&Event{Command: PING, Params: []string{token}}, priorityBulk)
+
+	select {
+	case <-done:
+		mu.Lock()
+		defer mu.Unlock()
+
+		history := make([]*Event, len(events))
+		for i := 0; i < len(events); i++ {
+			history[i] = events[i].Copy()
+		}
+
+		return history, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// playbackTime formats t as a timestamp for the playback module, in
+// (fractional) seconds since the epoch. The zero time is the start of the
+// buffer.
+func playbackTime(t time.Time) string {
+	if t.IsZero() {
+		return "0"
+	}
+
+	return strconv.FormatFloat(float64(t.UnixNano())/float64(time.Second), 'f', 3, 64)
+}
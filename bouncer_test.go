@@ -0,0 +1,189 @@
+// Copyright (c) Liam Stanley <me@liamstanley.io>. All rights reserved. Use
+// of this source code is governed by the MIT license that can be found in
+// the LICENSE file.
+
+package girc
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+func TestIsBouncer(t *testing.T) {
+	c, server := mockClient(t, Config{})
+	defer c.Stop()
+
+	server.expect("CAP LS 302")
+	server.send(":irc.znc.in CAP * LS :batch znc.in/playback znc.in/self-message")
+	server.expect("CAP REQ")
+	server.send(":irc.znc.in CAP * ACK :batch znc.in/playback znc.in/self-message")
+	server.send("PING :sync")
+	server.expect("PONG sync")
+
+	if !c.IsBouncer() {
+		t.Fatal("IsBouncer() = false with ZNC capabilities")
+	}
+	if !c.HasCapability(capSelfMessage) || !c.HasCapability(capPlayback) {
+		t.Fatal("ZNC capabilities weren't negotiated")
+	}
+
+	if New(Config{}).IsBouncer() {
+		t.Fatal("IsBouncer() = true without a connection")
+	}
+}
+
+func TestPlayback(t *testing.T) {
+	c, server := mockClient(t, Config{RateBurst: 10})
+	defer c.Stop()
+
+	if _, err := c.Commands.Playback(context.Background(), "#channel", time.Time{}, time.Time{}); err != ErrPlaybackUnsupported {
+		t.Fatalf("Playback() without the capability returned %v", err)
+	}
+
+	c.state.mu.Lock()
+	c.state.enabledCap = []string{"batch", capPlayback}
+	c.state.mu.Unlock()
+
+	type result struct {
+		events []*Event
+		err    error
+	}
+	results := make(chan result, 1)
+
+	go func() {
+		events, err := c.Commands.Playback(context.Background(), "#channel", time.Unix(1500000000, 0), time.Time{})
+		results <- result{events, err}
+	}()
+
+	server.expect("PRIVMSG *playback :PLAY #channel 1500000000.000")
+	server.send(":irc.znc.in BATCH +ref znc.in/playback #channel")
+	server.send("@batch=ref;time=2017-07-14T02:40:01.000Z :other!user@host PRIVMSG #channel :first")
+	server.send("@batch=ref;time=2017-07-14T02:40:02.000Z :other!user@host PRIVMSG #channel :second")
+	server.send(":irc.znc.in BATCH -ref")
+
+	token := strings.TrimPrefix(server.expect("PING "+syncPingPrefix), "PING ")
+	server.send(":irc.znc.in PONG irc.znc.in :" + token)
+
+	select {
+	case r := <-results:
+		if r.err != nil {
+			t.Fatal(r.err)
+		}
+		if len(r.events) != 2 || r.events[0].Trailing != "first" || r.events[1].Trailing != "second" {
+			t.Fatalf("Playback() = %v", r.events)
+		}
+		if r.events[0].Timestamp.Unix() != 1500000001 {
+			t.Fatalf("Playback() event has timestamp %s", r.events[0].Timestamp)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Playback() never returned")
+	}
+
+	// Nothing buffered, so only the PONG is sent back.
+	go func() {
+		events, err := c.Commands.Playback(context.Background(), "other", time.Time{}, time.Time{})
+		results <- result{events, err}
+	}()
+
+	server.expect("PRIVMSG *playback :PLAY other 0")
+	token = strings.TrimPrefix(server.expect("PING "+syncPingPrefix), "PING ")
+	server.send(":irc.znc.in PONG irc.znc.in " + token)
+
+	select {
+	case r := <-results:
+		if r.err != nil || len(r.events) != 0 {
+			t.Fatalf("Playback() = %v, %v, want no events", r.events, r.err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Playback() never returned without anything buffered")
+	}
+
+	// With ascii, the playback of #a{b} isn't that of #a[b].
+	c.state.mu.Lock()
+	c.state.isupport.Casemapping = CaseMappingASCII
+	c.state.mu.Unlock()
+
+	go func() {
+		events, err := c.Commands.Playback(context.Background(), "#a[b]", time.Time{}, time.Time{})
+		results <- result{events, err}
+	}()
+
+	server.expect("PRIVMSG *playback :PLAY #a[b] 0")
+	server.send(":irc.znc.in BATCH +other znc.in/playback #a{b}")
+	server.send("@batch=other :other!user@host PRIVMSG #a{b} :elsewhere")
+	server.send(":irc.znc.in BATCH -other")
+	server.send(":irc.znc.in BATCH +ref znc.in/playback #A[B]")
+	server.send("@batch=ref :other!user@host PRIVMSG #A[B] :here")
+	server.send(":irc.znc.in BATCH -ref")
+	token = strings.TrimPrefix(server.expect("PING "+syncPingPrefix), "PING ")
+	server.send(":irc.znc.in PONG irc.znc.in " + token)
+
+	select {
+	case r := <-results:
+		if r.err != nil || len(r.events) != 1 || r.events[0].Trailing != "here" {
+			t.Fatalf("Playback() = %v, %v, want the playback of #A[B]", r.events, r.err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Playback() never returned")
+	}
+}
+
+func TestBouncerReplayedJoin(t *testing.T) {
+	c, server := mockClient(t, Config{})
+	defer c.Stop()
+
+	joins := make(chan struct{}, 2)
+	c.Handlers.Add(USER_JOINED, func(c *Client, e Event) {
+		joins <- struct{}{}
+	})
+
+	server.send(":nick!user@host JOIN #channel")
+	server.send(":nick!user@host JOIN #channel")
+	server.send("PING :sync")
+
+	var whos int
+	for line := server.expect(""); line != "PONG sync"; line = server.expect("") {
+		if strings.HasPrefix(line, "WHO #channel") {
+			whos++
+		}
+	}
+
+	// PONG is sent with a higher priority than WHO, so also wait for a
+	// message queued after it.
+	c.Commands.Message("other", "sync")
+	for line := server.expect(""); line != "PRIVMSG other :sync"; line = server.expect("") {
+		if strings.HasPrefix(line, "WHO #channel") {
+			whos++
+		}
+	}
+
+	if whos != 1 || len(joins) != 1 {
+		t.Fatalf("replayed JOIN resulted in %d WHO queries and %d USER_JOINED events, want 1 each", whos, len(joins))
+	}
+}
+
+func TestConversation(t *testing.T) {
+	c := New(Config{Server: "irc.example.com", Nick: "nick", User: "user"})
+	c.state.nick = "nick"
+
+	tests := []struct {
+		raw  string
+		want string
+	}{
+		{raw: ":other!user@host PRIVMSG #channel :hello", want: "#channel"},
+		{raw: ":nick!user@host PRIVMSG #channel :hello", want: "#channel"},
+		{raw: ":other!user@host PRIVMSG nick :hello", want: "other"},
+		{raw: ":nick!user@host PRIVMSG other :hello", want: "other"},
+		{raw: ":other!user@host NOTICE nick :hello", want: "other"},
+		{raw: ":other!user@host JOIN #channel", want: ""},
+	}
+
+	for _, tt := range tests {
+		if got := c.Conversation(ParseEvent(tt.raw)); got != tt.want {
+			t.Errorf("Conversation(%q) = %q, want %q", tt.raw, got, tt.want)
+		}
+	}
+}
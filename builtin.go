@@ -5,6 +5,7 @@
 package girc
 
 import (
+	"strconv"
 	"strings"
 	"time"
 )
@@ -17,10 +18,37 @@ func (c *Client) registerBuiltins() {
 
 	// Built-in things that should always be supported.
 	c.Handlers.register(true, RPL_WELCOME, HandlerFunc(func(c *Client, e Event) {
+		handleRegistered(c, e)
 		go handleConnect(c, e)
 	}))
 	c.Handlers.register(true, PING, HandlerFunc(handlePING))
 	c.Handlers.register(true, PONG, HandlerFunc(handlePONG))
+	c.Handlers.register(true, RPL_REDIR, HandlerFunc(handleREDIR))
+	c.Handlers.register(true, RPL_WELCOME, HandlerFunc(handleRedirected))
+	c.Handlers.register(true, RPL_WELCOME, HandlerFunc(handleIdentdRegistered))
+	c.Handlers.register(true, CONNECTED, HandlerFunc(handleAutoJoin))
+	c.Handlers.register(true, NOTICE, HandlerFunc(handleServerNotice))
+
+	if c.Config.ChatLog != nil {
+		for _, cmd := range []string{PRIVMSG, NOTICE, JOIN, PART, KICK, TOPIC, MODE, USER_LEFT, USER_RENAMED, STOPPED} {
+			c.Handlers.register(true, cmd, HandlerFunc(handleChatLog))
+		}
+	}
+
+	if c.Config.Backfill != nil && !c.Config.disableTracking {
+		c.Handlers.register(true, RPL_WELCOME, HandlerFunc(handleBackfillConnect))
+		for _, cmd := range []string{PRIVMSG, NOTICE, TAGMSG} {
+			c.Handlers.register(true, cmd, HandlerFunc(handleBackfillMessage))
+		}
+		for _, cmd := range []string{JOIN, PART, KICK} {
+			c.Handlers.register(true, cmd, HandlerFunc(handleBackfillMembership))
+		}
+	}
+
+	if c.Config.FloodGuard != nil {
+		c.Handlers.register(true, PRIVMSG, HandlerFunc(handleFlood))
+		c.Handlers.register(true, NOTICE, HandlerFunc(handleFlood))
+	}
 
 	if !c.Config.disableTracking {
 		// Joins/parts/anything that may add/remove/rename users.
@@ -29,23 +57,38 @@ func (c *Client) registerBuiltins() {
 		c.Handlers.register(true, KICK, HandlerFunc(handleKICK))
 		c.Handlers.register(true, QUIT, HandlerFunc(handleQUIT))
 		c.Handlers.register(true, NICK, HandlerFunc(handleNICK))
+		c.Handlers.register(true, RENAME, HandlerFunc(handleRENAME))
+		c.Handlers.register(true, ERR_LINKCHANNEL, HandlerFunc(handleLINKCHANNEL))
 		c.Handlers.register(true, RPL_NAMREPLY, HandlerFunc(handleNAMES))
+		c.Handlers.register(true, RPL_ENDOFNAMES, HandlerFunc(handleSyncEnd))
+		c.Handlers.register(true, RPL_ENDOFWHO, HandlerFunc(handleSyncEnd))
 
 		// Modes.
 		c.Handlers.register(true, MODE, HandlerFunc(handleMODE))
 		c.Handlers.register(true, RPL_CHANNELMODEIS, HandlerFunc(handleMODE))
+		c.Handlers.register(true, RPL_BANLIST, HandlerFunc(handleModeList))
+		c.Handlers.register(true, RPL_ENDOFBANLIST, HandlerFunc(handleModeList))
+		c.Handlers.register(true, RPL_EXCEPTLIST, HandlerFunc(handleModeList))
+		c.Handlers.register(true, RPL_ENDOFEXCEPTLIST, HandlerFunc(handleModeList))
+		c.Handlers.register(true, RPL_INVITELIST, HandlerFunc(handleModeList))
+		c.Handlers.register(true, RPL_ENDOFINVITELIST, HandlerFunc(handleModeList))
 
 		// WHO/WHOX responses.
 		c.Handlers.register(true, RPL_WHOREPLY, HandlerFunc(handleWHO))
 		c.Handlers.register(true, RPL_WHOSPCRPL, HandlerFunc(handleWHO))
 
 		// Other misc. useful stuff.
+		c.Handlers.register(true, INVITE, HandlerFunc(handleINVITE))
 		c.Handlers.register(true, TOPIC, HandlerFunc(handleTOPIC))
 		c.Handlers.register(true, RPL_TOPIC, HandlerFunc(handleTOPIC))
+		c.Handlers.register(true, RPL_NOTOPIC, HandlerFunc(handleTOPIC))
+		c.Handlers.register(true, RPL_TOPICWHOTIME, HandlerFunc(handleTOPICWHOTIME))
 		c.Handlers.register(true, RPL_MYINFO, HandlerFunc(handleMYINFO))
 		c.Handlers.register(true, RPL_ISUPPORT, HandlerFunc(handleISUPPORT))
 		c.Handlers.register(true, RPL_MOTDSTART, HandlerFunc(handleMOTD))
 		c.Handlers.register(true, RPL_MOTD, HandlerFunc(handleMOTD))
+		c.Handlers.register(true, RPL_ENDOFMOTD, HandlerFunc(setBotMode))
+		c.Handlers.register(true, ERR_NOMOTD, HandlerFunc(setBotMode))
 
 		// Keep users lastactive times up to date.
 		c.Handlers.register(true, PRIVMSG, HandlerFunc(updateLastActive))
@@ -56,11 +99,67 @@ func (c *Client) registerBuiltins() {
 		// CAP IRCv3-specific tracking and functionality.
 		c.Handlers.register(true, CAP, HandlerFunc(handleCAP))
 		c.Handlers.register(true, CAP_CHGHOST, HandlerFunc(handleCHGHOST))
+		c.Handlers.register(true, CAP_SETNAME, HandlerFunc(handleSETNAME))
 		c.Handlers.register(true, CAP_AWAY, HandlerFunc(handleAWAY))
+		c.Handlers.register(true, RPL_AWAY, HandlerFunc(handleRPLAWAY))
 		c.Handlers.register(true, CAP_ACCOUNT, HandlerFunc(handleACCOUNT))
 		c.Handlers.register(true, ALLEVENTS, HandlerFunc(handleTags))
+		c.Handlers.register(true, PRIVMSG, HandlerFunc(handleEcho))
+		c.Handlers.register(true, NOTICE, HandlerFunc(handleEcho))
+		c.Handlers.register(true, TAGMSG, HandlerFunc(handleTyping))
+		c.Handlers.register(true, PRIVMSG, HandlerFunc(handleBotTag))
+		c.Handlers.register(true, NOTICE, HandlerFunc(handleBotTag))
+		c.Handlers.register(true, TAGMSG, HandlerFunc(handleBotTag))
+
+		// Twitch specific tracking.
+		if c.Config.TwitchMode {
+			c.Handlers.register(true, PRIVMSG, HandlerFunc(handleTwitchUser))
+			c.Handlers.register(true, USERNOTICE, HandlerFunc(handleTwitchUser))
+			c.Handlers.register(true, USERSTATE, HandlerFunc(handleTwitchUser))
+			c.Handlers.register(true, ROOMSTATE, HandlerFunc(handleROOMSTATE))
+		}
+
+		// SASL authentication.
+		c.Handlers.register(true, AUTHENTICATE, HandlerFunc(handleSASL))
+		c.Handlers.register(true, RPL_SASLSUCCESS, HandlerFunc(handleSASLResult))
+		c.Handlers.register(true, ERR_SASLFAIL, HandlerFunc(handleSASLResult))
+		c.Handlers.register(true, ERR_SASLTOOLONG, HandlerFunc(handleSASLResult))
+		c.Handlers.register(true, ERR_SASLABORTED, HandlerFunc(handleSASLResult))
+		c.Handlers.register(true, ERR_SASLALREADY, HandlerFunc(handleSASLResult))
+		c.Handlers.register(true, RPL_LOGGEDIN, HandlerFunc(handleLOGGEDIN))
+		c.Handlers.register(true, RPL_LOGGEDOUT, HandlerFunc(handleLOGGEDIN))
+
+		// Services.
+		c.Handlers.register(true, RPL_ENDOFMOTD, HandlerFunc(handleNickServ))
+		c.Handlers.register(true, ERR_NOMOTD, HandlerFunc(handleNickServ))
+		c.Handlers.register(true, NOTICE, HandlerFunc(handleNickServNotice))
+		c.Handlers.register(true, AUTHENTICATED, HandlerFunc(handleNickServLogin))
+		c.Handlers.register(true, RPL_ENDOFMOTD, HandlerFunc(handleServiceAuth))
+		c.Handlers.register(true, ERR_NOMOTD, HandlerFunc(handleServiceAuth))
+		c.Handlers.register(true, NOTICE, HandlerFunc(handleServiceAuthNotice))
+		c.Handlers.register(true, RPL_ENDOFMOTD, HandlerFunc(handleRegain))
+		c.Handlers.register(true, ERR_NOMOTD, HandlerFunc(handleRegain))
+		c.Handlers.register(true, QUIT, HandlerFunc(handleRegainFreed))
+		c.Handlers.register(true, NICK, HandlerFunc(handleRegainFreed))
+		c.Handlers.register(true, MONITOR_OFFLINE, HandlerFunc(handleRegainFreed))
+		c.Handlers.register(true, SELF_NICK_CHANGED, HandlerFunc(handleRegained))
+
+		// Rejoining channels.
+		c.Handlers.register(true, JOIN, HandlerFunc(handleRejoinJoin))
+		c.Handlers.register(true, DISCONNECTED, HandlerFunc(handleRejoinDisconnect))
+		for _, cmd := range joinErrors {
+			c.Handlers.register(true, cmd, HandlerFunc(handleRejoinError))
+		}
 	}
 
+	// Presence tracking.
+	c.Handlers.register(true, RPL_MONONLINE, HandlerFunc(handleMONITOR))
+	c.Handlers.register(true, RPL_MONOFFLINE, HandlerFunc(handleMONITOR))
+	c.Handlers.register(true, RPL_ISON, HandlerFunc(handleISON))
+	c.Handlers.register(true, RPL_WELCOME, HandlerFunc(handleMonitorRegistered))
+	c.Handlers.register(true, RPL_ENDOFMOTD, HandlerFunc(func(c *Client, e Event) { c.Monitor.sync() }))
+	c.Handlers.register(true, ERR_NOMOTD, HandlerFunc(func(c *Client, e Event) { c.Monitor.sync() }))
+
 	// Nickname collisions.
 	c.Handlers.register(true, ERR_NICKNAMEINUSE, HandlerFunc(nickCollisionHandler))
 	c.Handlers.register(true, ERR_NICKCOLLISION, HandlerFunc(nickCollisionHandler))
@@ -69,32 +168,76 @@ func (c *Client) registerBuiltins() {
 	c.Handlers.mu.Unlock()
 }
 
-// handleConnect is a helper function which lets the client know that enough
-// time has passed and now they can send commands.
-//
-// Should always run in separate thread due to blocking delay.
-func handleConnect(c *Client, e Event) {
+// handleRegistered updates our nickname once the server has accepted our
+// registration, and lets handlers know that we're registered.
+func handleRegistered(c *Client, e Event) {
 	// This should be the nick that the server gives us. 99% of the time, it's
 	// the one we supplied during connection, but some networks will rename
 	// users on connect.
 	if len(e.Params) > 0 {
+		c.state.mu.Lock()
 		c.state.nick = e.Params[0]
+		c.state.mu.Unlock()
 	}
 
+	c.state.mu.Lock()
+	c.state.registered = true
+	c.state.mu.Unlock()
+
+	c.RunHandlers(&Event{Command: REGISTERED, Params: e.Params, Trailing: c.Server()})
+}
+
+// handleConnect is a helper function which lets the client know that enough
+// time has passed and now they can send commands.
+//
+// Should always run in separate thread due to blocking delay.
+func handleConnect(c *Client, e Event) {
 	time.Sleep(2 * time.Second)
 
 	c.RunHandlers(&Event{Command: CONNECTED, Trailing: c.Server()})
 }
 
 // nickCollisionHandler helps prevent the client from having conflicting
-// nicknames with another bot, user, etc.
+// nicknames with another bot, user, etc, during registration. Each of
+// Config.AltNicks is tried first, and then Config.HandleNickCollide (or
+// appending underscores).
 func nickCollisionHandler(c *Client, e Event) {
-	if c.Config.HandleNickCollide == nil {
-		c.Commands.Nick(c.GetNick() + "_")
+	c.state.mu.Lock()
+	if c.state.registered {
+		// A nick change which we (or the user) requested failed, so there's
+		// nothing to recover from.
+		c.state.mu.Unlock()
 		return
 	}
 
-	c.Commands.Nick(c.Config.HandleNickCollide(c.GetNick()))
+	// The nick which was just rejected.
+	tried := c.state.nick
+	if tried == "" {
+		tried = c.configNick()
+	}
+
+	attempt := c.state.nickAttempts
+	c.state.nickAttempts++
+
+	if attempt == 0 {
+		// Config.Nick is in use, so attempt to regain it once registered.
+		c.state.regaining = true
+	}
+
+	var next string
+	switch {
+	case attempt < len(c.Config.AltNicks):
+		next = c.Config.AltNicks[attempt]
+	case c.Config.HandleNickCollide != nil:
+		next = c.Config.HandleNickCollide(tried)
+	default:
+		next = c.configNick() + strings.Repeat("_", attempt-len(c.Config.AltNicks)+1)
+	}
+
+	c.state.nick = next
+	c.state.mu.Unlock()
+
+	c.Commands.Nick(next)
 }
 
 // handlePING helps respond to ping requests from the server.
@@ -102,8 +245,30 @@ func handlePING(c *Client, e Event) {
 	c.Commands.Pong(e.Trailing)
 }
 
+// handlePONG records the round-trip time of our last ping to the server.
 func handlePONG(c *Client, e Event) {
+	// Not a response to one of our keep-alive pings.
+	if strings.HasPrefix(pongToken(&e), syncPingPrefix) {
+		return
+	}
+
+	c.conn.mu.Lock()
 	c.conn.lastPong = time.Now()
+	c.conn.lastLag = c.conn.lastPong.Sub(c.conn.lastPing)
+	if c.conn.lastLag >= 0 {
+		c.conn.lagHistory.add(c.conn.lastLag)
+	}
+	c.conn.mu.Unlock()
+}
+
+// pongToken returns the token of a PONG, which servers either send as the
+// trailing parameter, or as the second parameter.
+func pongToken(e *Event) string {
+	if e.Trailing == "" && len(e.Params) > 1 {
+		return e.Params[1]
+	}
+
+	return e.Trailing
 }
 
 // handleJOIN ensures that the state has updated users and channels.
@@ -116,18 +281,25 @@ func handleJOIN(c *Client, e Event) {
 		return
 	}
 
+	self := c.isSelf(e.Source)
+
 	// Create the user in state. This will also verify the channel.
 	c.state.mu.Lock()
+	channel := c.state.lookupChannel(e.Params[0])
+	joined := channel == nil || channel.Lookup(e.Source.Name) == nil
+
 	user := c.state.createUserIfNotExists(e.Params[0], e.Source.Name)
-	c.state.mu.Unlock()
 	if user == nil {
+		c.state.mu.Unlock()
 		return
 	}
 
 	// Assume extended-join (ircv3).
 	if len(e.Params) == 2 {
-		if e.Params[1] != "*" {
-			user.Extras.Account = e.Params[1]
+		// "*" means the user isn't logged in.
+		user.Extras.Account = e.Params[1]
+		if user.Extras.Account == "*" {
+			user.Extras.Account = ""
 		}
 
 		if len(e.Trailing) > 0 {
@@ -135,25 +307,84 @@ func handleJOIN(c *Client, e Event) {
 		}
 	}
 
-	if e.Source.Name == c.GetNick() {
-		// If it's us, don't just add our user to the list. Run a WHO which
-		// will tell us who exactly is in the entire channel.
-		c.Send(&Event{Command: WHO, Params: []string{e.Params[0], "%tacuhnr,1"}})
+	// Bouncers replay the JOINs of the channels we're in when we attach, so
+	// if we're already in the channel, there is nothing to sync.
+	if self && !joined {
+		c.state.mu.Unlock()
+		return
+	}
 
-		// Also send a MODE to obtain the list of channel modes.
-		c.Send(&Event{Command: MODE, Params: []string{e.Params[0]}})
+	if self {
+		c.state.lookupChannel(e.Params[0]).pendingSync = syncNames | syncWho
+		if c.Config.TwitchMode {
+			c.state.lookupChannel(e.Params[0]).pendingSync = syncNames
+		}
+		c.state.removeInvites(e.Params[0])
 
 		// Update our ident and host too, in state -- since there is no
 		// cleaner method to do this.
-		c.state.mu.Lock()
 		c.state.ident = e.Source.Ident
 		c.state.host = e.Source.Host
-		c.state.mu.Unlock()
+	}
+	c.state.mu.Unlock()
+
+	if joined {
+		c.RunHandlers(&Event{Command: USER_JOINED, Source: e.Source, Params: []string{e.Params[0]}})
+	}
+
+	// Twitch supports neither WHO nor MODE.
+	if c.Config.TwitchMode {
+		return
+	}
+
+	if self {
+		// If it's us, don't just add our user to the list. Run a WHO which
+		// will tell us who exactly is in the entire channel.
+		c.Send(&Event{Command: WHO, Params: whoxParams(e.Params[0], whoxTrackingToken, whoxTrackingFields)})
+
+		// Also send a MODE to obtain the list of channel modes.
+		c.Send(&Event{Command: MODE, Params: []string{e.Params[0]}})
 		return
 	}
 
 	// Only WHO the user, which is more efficient.
-	c.Send(&Event{Command: WHO, Params: []string{e.Source.Name, "%tacuhnr,1"}})
+	c.Send(&Event{Command: WHO, Params: whoxParams(e.Source.Name, whoxTrackingToken, whoxTrackingFields)})
+}
+
+// handleSyncEnd handles the end of the NAMES and WHO replies which are
+// requested after joining a channel, emitting CHANNEL_SYNCED once both have
+// been received.
+func handleSyncEnd(c *Client, e Event) {
+	if len(e.Params) < 2 {
+		return
+	}
+
+	flag := syncNames
+	if e.Command == RPL_ENDOFWHO {
+		flag = syncWho
+	}
+
+	c.state.mu.Lock()
+	channel := c.state.lookupChannel(e.Params[1])
+	if channel == nil || channel.pendingSync&flag == 0 {
+		c.state.mu.Unlock()
+		return
+	}
+
+	channel.pendingSync &^= flag
+	synced := channel.pendingSync == 0
+	name := channel.Name
+	c.state.mu.Unlock()
+
+	if synced {
+		c.RunHandlers(&Event{Command: CHANNEL_SYNCED, Params: []string{name}})
+	}
+}
+
+// userLeft emits USER_LEFT for a user which has been removed from a
+// channel.
+func userLeft(c *Client, src *Source, channel string, e Event) {
+	c.RunHandlers(&Event{Command: USER_LEFT, Source: src, Params: []string{channel, e.Command}, Trailing: e.Trailing})
 }
 
 // handlePART ensures that the state is clean of old user and channel entries.
@@ -166,16 +397,18 @@ func handlePART(c *Client, e Event) {
 		return
 	}
 
-	if e.Source.Name == c.GetNick() {
-		c.state.mu.Lock()
-		c.state.deleteChannel(e.Params[0])
-		c.state.mu.Unlock()
-		return
-	}
+	self := c.isSelf(e.Source)
 
 	c.state.mu.Lock()
-	c.state.deleteUser(e.Source.Name)
+	user := c.state.deleteChannelUser(e.Params[0], e.Source.Name)
+	if self {
+		c.state.deleteChannel(e.Params[0])
+	}
 	c.state.mu.Unlock()
+
+	if user != nil {
+		userLeft(c, e.Source, e.Params[0], e)
+	}
 }
 
 // handleTOPIC handles incoming TOPIC events and keeps channel tracking info
@@ -191,6 +424,11 @@ func handleTOPIC(c *Client, e Event) {
 		name = e.Params[len(e.Params)-1]
 	}
 
+	topic := e.Trailing
+	if e.Command == RPL_NOTOPIC {
+		topic = ""
+	}
+
 	c.state.mu.Lock()
 	channel := c.state.createChanIfNotExists(name)
 	if channel == nil {
@@ -198,32 +436,89 @@ func handleTOPIC(c *Client, e Event) {
 		return
 	}
 
-	channel.Topic = e.Trailing
+	old := channel.Topic
+	channel.Topic = topic
+
+	switch {
+	case e.Command == TOPIC:
+		// Set by someone while we're in the channel.
+		if e.Source != nil {
+			channel.TopicBy = e.Source.String()
+		}
+		channel.TopicTime = time.Now()
+	case old != topic:
+		// Setter and time will follow in RPL_TOPICWHOTIME, if supported.
+		channel.TopicBy = ""
+		channel.TopicTime = time.Time{}
+	}
+	name = channel.Name
+	c.state.mu.Unlock()
+
+	if old == topic {
+		return
+	}
+
+	var src *Source
+	if e.Command == TOPIC {
+		src = e.Source
+	}
+
+	c.RunHandlers(&Event{Command: TOPIC_CHANGED, Source: src, Params: []string{name, old}, Trailing: topic})
+}
+
+// handleTOPICWHOTIME updates who set the topic of a channel, and when.
+func handleTOPICWHOTIME(c *Client, e Event) {
+	// <nick> <channel> <setter> <timestamp>.
+	if len(e.Params) != 4 {
+		return
+	}
+
+	ts, err := strconv.ParseInt(e.Params[3], 10, 64)
+	if err != nil {
+		return
+	}
+
+	c.state.mu.Lock()
+	channel := c.state.lookupChannel(e.Params[1])
+	if channel != nil {
+		channel.TopicBy = e.Params[2]
+		channel.TopicTime = time.Unix(ts, 0)
+	}
 	c.state.mu.Unlock()
 }
 
 // handlWHO updates our internal tracking of users/channels with WHO/WHOX
 // information.
 func handleWHO(c *Client, e Event) {
-	var channel, ident, host, nick, account string
+	var channel, ident, host, nick, flags, account, name string
 
 	// Assume WHOX related.
 	if e.Command == RPL_WHOSPCRPL {
-		if len(e.Params) != 7 {
-			// Assume there was some form of error or invalid WHOX response.
+		token, reply, ok := parseWhoxReply(&e, whoxTrackingFields)
+		if !ok || token != whoxTrackingToken {
+			// Either an invalid WHOX response, or a reply to a query which
+			// wasn't sent for tracking (see Commands.Whox), which we can
+			// ignore.
 			return
 		}
 
-		if e.Params[1] != "1" {
-			// We should always be sending 1, and we should receive 1. If this
-			// is anything but, then we didn't send the request and we can
-			// ignore it.
+		channel, ident, host, nick = reply.Channel, reply.Ident, reply.Host, reply.Nick
+		flags, account, name = reply.Flags, reply.Account, reply.Realname
+	} else {
+		if len(e.Params) < 6 {
 			return
 		}
 
-		channel, ident, host, nick, account = e.Params[2], e.Params[3], e.Params[4], e.Params[5], e.Params[6]
-	} else {
 		channel, ident, host, nick = e.Params[1], e.Params[2], e.Params[3], e.Params[5]
+		if len(e.Params) > 6 {
+			flags = e.Params[6]
+		}
+
+		// The trailing is prefixed with the hop count.
+		name = e.Trailing
+		if i := strings.IndexByte(name, 0x20); i > -1 {
+			name = name[i+1:]
+		}
 	}
 
 	c.state.mu.Lock()
@@ -235,13 +530,24 @@ func handleWHO(c *Client, e Event) {
 
 	user.Host = host
 	user.Ident = ident
-	user.Extras.Name = e.Trailing
+	user.Extras.Name = name
+
+	// The flags include the bot mode, if the user is a bot.
+	if bot := c.state.isupport.Bot; bot != "" && flags != "" {
+		user.IsBot = strings.Contains(flags[1:], bot)
+	}
 
-	if account != "0" {
+	if account != "" && account != "0" {
 		user.Extras.Account = account
 	}
 
 	c.state.mu.Unlock()
+
+	// The flags start with "H" (here) or "G" (gone), followed by other flags
+	// like "*" (IRC operator), and the channel user prefixes.
+	if flags != "" {
+		c.updateAway(nick, flags[0] == 0x47, "", false) // G
+	}
 }
 
 // handleKICK ensures that users are cleaned up after being kicked from the
@@ -252,17 +558,28 @@ func handleKICK(c *Client, e Event) {
 		return
 	}
 
-	if e.Params[1] == c.GetNick() {
-		c.state.mu.Lock()
-		c.state.deleteChannel(e.Params[0])
-		c.state.mu.Unlock()
-		return
-	}
+	self := c.isSelf(&Source{Name: e.Params[1]})
+
+	var key string
 
-	// Assume it's just another user.
 	c.state.mu.Lock()
-	c.state.deleteUser(e.Params[1])
+	user := c.state.deleteChannelUser(e.Params[0], e.Params[1])
+	if self {
+		if channel := c.state.lookupChannel(e.Params[0]); channel != nil {
+			key = channel.Modes.Key()
+		}
+
+		c.state.deleteChannel(e.Params[0])
+	}
 	c.state.mu.Unlock()
+
+	if user != nil {
+		userLeft(c, &Source{Name: user.Nick, Ident: user.Ident, Host: user.Host}, e.Params[0], e)
+	}
+
+	if self {
+		c.scheduleRejoin(e.Params[0], key)
+	}
 }
 
 // handleNICK ensures that users are renamed in state, or the client name is
@@ -272,14 +589,57 @@ func handleNICK(c *Client, e Event) {
 		return
 	}
 
+	to := e.Trailing
+	if len(e.Params) == 1 {
+		to = e.Params[0]
+	}
+
+	if to == "" {
+		return
+	}
+
+	self := c.isSelf(e.Source)
+
 	c.state.mu.Lock()
 	// renameUser updates the LastActive time automatically.
-	if len(e.Params) == 1 {
-		c.state.renameUser(e.Source.Name, e.Params[0])
-	} else if len(e.Trailing) > 0 {
-		c.state.renameUser(e.Source.Name, e.Trailing)
+	renamed := c.state.renameUser(e.Source.Name, to)
+	c.state.mu.Unlock()
+
+	if renamed {
+		c.RunHandlers(&Event{Command: USER_RENAMED, Source: e.Source, Params: []string{e.Source.Name, to}})
+	}
+
+	if self {
+		c.RunHandlers(&Event{Command: SELF_NICK_CHANGED, Params: []string{e.Source.Name, to}})
 	}
+}
+
+// handleRENAME moves the state of channels which are renamed by the server
+// (see https://ircv3.net/specs/extensions/channel-rename) to their new name.
+func handleRENAME(c *Client, e Event) {
+	if len(e.Params) < 2 {
+		return
+	}
+
+	from, to := e.Params[0], e.Params[1]
+
+	c.state.mu.Lock()
+	renamed := c.state.renameChannel(from, to)
 	c.state.mu.Unlock()
+
+	if !renamed {
+		return
+	}
+
+	c.RunHandlers(&Event{
+		Source:    e.Source,
+		Tags:      e.Tags,
+		RawTags:   e.RawTags,
+		Command:   CHANNEL_RENAMED,
+		Params:    []string{from, to},
+		Trailing:  e.Trailing,
+		Timestamp: e.Timestamp,
+	})
 }
 
 // handleQUIT handles users that are quitting from the network.
@@ -289,8 +649,12 @@ func handleQUIT(c *Client, e Event) {
 	}
 
 	c.state.mu.Lock()
-	c.state.deleteUser(e.Source.Name)
+	channels := c.state.deleteUser(e.Source.Name)
 	c.state.mu.Unlock()
+
+	for i := 0; i < len(channels); i++ {
+		userLeft(c, e.Source, channels[i], e)
+	}
 }
 
 // handleMYINFO handles incoming MYINFO events -- these are commonly used
@@ -304,8 +668,8 @@ func handleMYINFO(c *Client, e Event) {
 	}
 
 	c.state.mu.Lock()
-	c.state.serverOptions["SERVER"] = e.Params[1]
-	c.state.serverOptions["VERSION"] = e.Params[2]
+	c.state.serverName = e.Params[1]
+	c.state.serverVersion = e.Params[2]
 	c.state.mu.Unlock()
 }
 
@@ -330,16 +694,7 @@ func handleISUPPORT(c *Client, e Event) {
 	c.state.mu.Lock()
 	// Skip the first parameter, as it's our nickname.
 	for i := 1; i < len(e.Params); i++ {
-		j := strings.IndexByte(e.Params[i], 0x3D) // =
-
-		if j < 1 || (j+1) == len(e.Params[i]) {
-			c.state.serverOptions[e.Params[i]] = ""
-			continue
-		}
-
-		name := e.Params[i][0:j]
-		val := e.Params[i][j+1:]
-		c.state.serverOptions[name] = val
+		c.state.isupport.parse(e.Params[i])
 	}
 	c.state.mu.Unlock()
 }
@@ -381,8 +736,11 @@ func handleNAMES(c *Client, e Event) {
 	var ok bool
 
 	c.state.mu.Lock()
+	userPrefixes := c.state.userPrefixes()
+	_, symbols := parsePrefixes(userPrefixes)
+
 	for i := 0; i < len(parts); i++ {
-		modes, nick, ok = parseUserPrefix(parts[i])
+		modes, nick, ok = parseUserPrefix(symbols, parts[i])
 		if !ok {
 			continue
 		}
@@ -417,7 +775,7 @@ func handleNAMES(c *Client, e Event) {
 		}
 
 		// Don't append modes, overwrite them.
-		user.Perms.set(modes, false)
+		user.Perms.set(userPrefixes, modes, false)
 	}
 	c.state.mu.Unlock()
 }
@@ -425,17 +783,20 @@ func handleNAMES(c *Client, e Event) {
 // updateLastActive is a wrapper for any event which the source author
 // should have it's LastActive time updated. This is useful for things like
 // a KICK where we know they are active, as they just kicked another user,
-// even though they may not be talking.
+// even though they may not be talking. As this happens for every message,
+// only a read lock is taken (see User.setActive).
 func updateLastActive(c *Client, e Event) {
 	if e.Source == nil {
 		return
 	}
 
-	c.state.mu.Lock()
+	now := time.Now()
+
+	c.state.mu.RLock()
 	// Update the users last active time, if they exist.
-	users := c.state.lookupUsers("nick", e.Source.Name)
+	users := c.state.usersByNick(e.Source.Name)
 	for i := 0; i < len(users); i++ {
-		users[i].LastActive = time.Now()
+		users[i].setActive(now)
 	}
-	c.state.mu.Unlock()
+	c.state.mu.RUnlock()
 }
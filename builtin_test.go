@@ -0,0 +1,323 @@
+// Copyright (c) Liam Stanley <me@liamstanley.io>. All rights reserved. Use
+// of this source code is governed by the MIT license that can be found in
+// the LICENSE file.
+
+package girc
+
+import (
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestHandleTOPIC(t *testing.T) {
+	c := New(Config{Server: "irc.example.com", Nick: "nick", User: "user"})
+
+	var mu sync.Mutex
+	var changes []Event
+	c.Handlers.Add(TOPIC_CHANGED, func(c *Client, e Event) {
+		mu.Lock()
+		changes = append(changes, e)
+		mu.Unlock()
+	})
+
+	c.state.mu.Lock()
+	c.state.createChanIfNotExists("#channel")
+	c.state.mu.Unlock()
+
+	handleTOPIC(c, *ParseEvent(":irc.example.com 332 nick #channel :first topic"))
+	handleTOPICWHOTIME(c, *ParseEvent(":irc.example.com 333 nick #channel setter!user@host 1500000000"))
+
+	channel := c.Lookup("#channel")
+	if channel.Topic != "first topic" || channel.TopicBy != "setter!user@host" || !channel.TopicTime.Equal(time.Unix(1500000000, 0)) {
+		t.Fatalf("unexpected topic state: %q by %q at %s", channel.Topic, channel.TopicBy, channel.TopicTime)
+	}
+
+	// Same topic shouldn't trigger a change.
+	handleTOPIC(c, *ParseEvent(":irc.example.com 332 nick #channel :first topic"))
+
+	before := time.Now()
+	handleTOPIC(c, *ParseEvent(":other!user@host TOPIC #channel :second topic"))
+
+	channel = c.Lookup("#channel")
+	if channel.Topic != "second topic" || channel.TopicBy != "other!user@host" || channel.TopicTime.Before(before) {
+		t.Fatalf("unexpected topic state: %q by %q at %s", channel.Topic, channel.TopicBy, channel.TopicTime)
+	}
+
+	handleTOPIC(c, *ParseEvent(":irc.example.com 331 nick #channel :No topic is set"))
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	want := []struct{ old, topic, source string }{
+		{old: "", topic: "first topic"},
+		{old: "first topic", topic: "second topic", source: "other!user@host"},
+		{old: "second topic", topic: ""},
+	}
+
+	if len(changes) != len(want) {
+		t.Fatalf("got %d TOPIC_CHANGED events, want %d: %v", len(changes), len(want), changes)
+	}
+
+	for i, w := range want {
+		e := changes[i]
+		var source string
+		if e.Source != nil {
+			source = e.Source.String()
+		}
+
+		if e.Params[0] != "#channel" || e.Params[1] != w.old || e.Trailing != w.topic || source != w.source {
+			t.Errorf("TOPIC_CHANGED[%d] = %q %q %q (source %q), want %q %q (source %q)",
+				i, e.Params[0], e.Params[1], e.Trailing, source, w.old, w.topic, w.source)
+		}
+	}
+}
+
+func TestHandleRENAME(t *testing.T) {
+	c := New(Config{Server: "irc.example.com", Nick: "nick", User: "user"})
+
+	var mu sync.Mutex
+	var got []string
+	c.Handlers.Add(CHANNEL_RENAMED, func(c *Client, e Event) {
+		mu.Lock()
+		got = append(got, e.String())
+		mu.Unlock()
+	})
+
+	for _, line := range []string{
+		":nick!user@host JOIN #old",
+		":other!user@host JOIN #old",
+		":irc.example.com 332 nick #old :the topic",
+		":irc.example.com 324 nick #old +nt",
+		":irc.example.com RENAME #old #New :Moved",
+		":irc.example.com RENAME #unknown #other :Not tracked",
+	} {
+		c.RunHandlers(ParseEvent(line))
+	}
+
+	if c.Lookup("#old") != nil {
+		t.Fatal("#old is still tracked after being renamed")
+	}
+
+	channel := c.Lookup("#new")
+	if channel == nil {
+		t.Fatal("#new isn't tracked after renaming #old")
+	}
+
+	if channel.Name != "#new" || channel.Topic != "the topic" || channel.Len() != 2 || channel.Lookup("other") == nil || channel.Modes.String() != "+nt" {
+		t.Fatalf("unexpected #new state: name %q, topic %q, users %v, modes %q", channel.Name, channel.Topic, channel.NickList(), channel.Modes.String())
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	want := ":irc.example.com CHANNEL_RENAMED #old #New :Moved"
+	if len(got) != 1 || got[0] != want {
+		t.Fatalf("got CHANNEL_RENAMED events %q, want %q", got, want)
+	}
+}
+
+func TestCasemapping(t *testing.T) {
+	cases := []struct {
+		casemapping string
+		nick        string
+		variant     string
+		same        bool
+	}{
+		{casemapping: CaseMappingRFC1459, nick: "nick[away]", variant: "NICK{AWAY}", same: true},
+		{casemapping: CaseMappingRFC1459Strict, nick: "nick|", variant: "Nick\\", same: true},
+		{casemapping: CaseMappingASCII, nick: "nick|", variant: "Nick\\", same: false},
+		{casemapping: CaseMappingASCII, nick: "nick[away]", variant: "NICK{AWAY}", same: false},
+		{casemapping: CaseMappingASCII, nick: "nick", variant: "NICK", same: true},
+	}
+
+	for _, tt := range cases {
+		c := New(Config{Server: "irc.example.com", Nick: "nick", User: "user"})
+
+		c.state.mu.Lock()
+		c.state.isupport.parse("CASEMAPPING=" + tt.casemapping)
+		c.state.createUserIfNotExists("#Channel[1]", tt.nick)
+		c.state.mu.Unlock()
+
+		channel := c.Lookup("#CHANNEL[1]")
+		if channel == nil {
+			t.Errorf("%s: channel not found", tt.casemapping)
+			continue
+		}
+
+		if got := channel.Lookup(tt.variant) != nil; got != tt.same {
+			t.Errorf("%s: Lookup(%q) found = %t, want %t", tt.casemapping, tt.variant, got, tt.same)
+		}
+
+		// Renames should also match case variants, keeping the new case.
+		handleNICK(c, *ParseEvent(":" + tt.variant + "!user@host NICK :renamed"))
+		if got := c.Lookup("#CHANNEL[1]").NickList(); (len(got) == 1 && got[0] == "renamed") != tt.same {
+			t.Errorf("%s: NickList() after rename of %q = %v", tt.casemapping, tt.variant, got)
+		}
+	}
+}
+
+func TestStateEvents(t *testing.T) {
+	c := New(Config{Server: "irc.example.com", Nick: "nick", User: "user"})
+
+	var mu sync.Mutex
+	var got []string
+	for _, cmd := range []string{USER_JOINED, USER_LEFT, USER_RENAMED, SELF_NICK_CHANGED, CHANNEL_SYNCED} {
+		c.Handlers.Add(cmd, func(c *Client, e Event) {
+			mu.Lock()
+			got = append(got, e.String())
+			mu.Unlock()
+		})
+	}
+
+	for _, line := range []string{
+		":nick!user@host JOIN #channel",
+		":irc.example.com 353 nick = #channel :nick other third fourth",
+		":irc.example.com 366 nick #channel :End of /NAMES list.",
+		":other!user@host JOIN #channel",
+		":irc.example.com 315 nick #channel :End of /WHO list.",
+		":irc.example.com 315 nick #channel :End of /WHO list.",
+		":nick!user@host JOIN #second",
+		":other!user@host JOIN #second",
+		":other!user@host NICK renamed",
+		":nick!user@host NICK me",
+		":renamed!user@host PART #channel :bye",
+		":me!user@host KICK #channel third :spam",
+		":fourth!user@host QUIT :gone",
+		":renamed!user@host QUIT :gone",
+		":ghost!user@host QUIT :never seen",
+	} {
+		c.RunHandlers(ParseEvent(line))
+	}
+
+	want := []string{
+		":nick!user@host USER_JOINED #channel",
+		"CHANNEL_SYNCED #channel",
+		":nick!user@host USER_JOINED #second",
+		":other!user@host USER_JOINED #second",
+		":other!user@host USER_RENAMED other renamed",
+		":nick!user@host USER_RENAMED nick me",
+		"SELF_NICK_CHANGED nick me",
+		":renamed!user@host USER_LEFT #channel PART :bye",
+		":third USER_LEFT #channel KICK :spam",
+		":fourth!user@host USER_LEFT #channel QUIT :gone",
+		":renamed!user@host USER_LEFT #second QUIT :gone",
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Fatalf("got events:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+
+	if channel := c.Lookup("#channel"); channel == nil || channel.Len() != 1 || channel.Lookup("me") == nil {
+		t.Fatalf("unexpected #channel state: %v", channel)
+	}
+}
+
+func TestLookupUser(t *testing.T) {
+	c := New(Config{Server: "irc.example.com", Nick: "nick", User: "user"})
+
+	for _, line := range []string{
+		":irc.example.com 353 nick = #one :nick @other",
+		":irc.example.com 353 nick = #two :nick other",
+		":irc.example.com 354 nick 1 #two ident example.com other H account",
+	} {
+		c.RunHandlers(ParseEvent(line))
+	}
+
+	user, ok := c.LookupUser("OTHER")
+	if !ok || user.Nick != "other" || user.Host != "example.com" || user.Extras.Account != "account" || user.Perms.IsTrusted() {
+		t.Fatalf("LookupUser() = %#v, %t", user, ok)
+	}
+
+	if _, ok = c.LookupUser("missing"); ok {
+		t.Fatal("LookupUser() found missing user")
+	}
+
+	channel, ok := c.LookupChannel("#ONE")
+	if !ok || !channel.Lookup("other").Perms.IsOp() {
+		t.Fatalf("LookupChannel() = %#v, %t", channel, ok)
+	}
+
+	// Modifying copies must not modify state.
+	channel.Lookup("other").Perms.Op = false
+	user.Host = "modified"
+	if channel, _ = c.LookupChannel("#one"); !channel.Lookup("other").Perms.IsOp() {
+		t.Fatal("LookupChannel() returned a shallow copy")
+	}
+	if user, _ = c.LookupUser("other"); user.Host != "example.com" {
+		t.Fatal("LookupUser() returned a shallow copy")
+	}
+}
+
+func TestAccountTracking(t *testing.T) {
+	c := New(Config{Server: "irc.example.com", Nick: "nick", User: "user"})
+	c.state.enabledCap = []string{"account-tag", "extended-join"}
+
+	tests := []struct {
+		line    string
+		account string
+	}{
+		{line: ":other!ident@example.com JOIN #one * :Other User", account: ""},
+		{line: "@account=acct :other!ident@example.com PRIVMSG #one :hello", account: "acct"},
+		{line: "@account=acct :other!ident@example.com JOIN #two acct :Other User", account: "acct"},
+		{line: "@account=renamed :other!ident@example.com NOTICE #two :hello", account: "renamed"},
+		// Server numerics don't tell us anything.
+		{line: ":irc.example.com 301 nick other :away", account: "renamed"},
+		// Without the tag, the user has logged out.
+		{line: ":other!ident@example.com PRIVMSG #one :hello", account: ""},
+	}
+
+	for _, tt := range tests {
+		c.RunHandlers(ParseEvent(tt.line))
+
+		for _, channel := range c.Channels() {
+			if user := c.Lookup(channel).Lookup("other"); user != nil && user.Extras.Account != tt.account {
+				t.Fatalf("after %q: account in %s = %q, want %q", tt.line, channel, user.Extras.Account, tt.account)
+			}
+		}
+	}
+
+	c.RunHandlers(ParseEvent("@account=shared :third!ident@example.com JOIN #one shared :Third"))
+	c.RunHandlers(ParseEvent("@account=shared :other!ident@example.com JOIN #three shared :Other User"))
+
+	users := c.LookupUsersByAccount("SHARED")
+	if len(users) != 2 || users[0].Nick != "other" || users[1].Nick != "third" {
+		t.Fatalf("LookupUsersByAccount() = %#v, want other and third", users)
+	}
+
+	if users := c.LookupUsersByAccount(""); len(users) != 0 {
+		t.Fatalf("LookupUsersByAccount(\"\") = %#v, want none", users)
+	}
+}
+
+func TestSelfEventsCasemapping(t *testing.T) {
+	c := New(Config{Server: "irc.example.com", Nick: "nick[1]", User: "user"})
+
+	var mu sync.Mutex
+	var got []string
+	c.Handlers.Add(SELF_NICK_CHANGED, func(c *Client, e Event) {
+		mu.Lock()
+		got = append(got, e.String())
+		mu.Unlock()
+	})
+
+	c.RunHandlers(ParseEvent(":NICK{1}!user@host JOIN #channel"))
+	c.RunHandlers(ParseEvent(":irc.example.com 353 nick[1] = #channel :nick[1] other"))
+	c.RunHandlers(ParseEvent(":Nick{1}!user@host PART #channel"))
+	if channel := c.Lookup("#channel"); channel != nil {
+		t.Fatalf("#channel = %v, want it removed after our PART", channel)
+	}
+
+	c.RunHandlers(ParseEvent(":NICK{1}!user@host NICK renamed"))
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	if len(got) != 1 || got[0] != "SELF_NICK_CHANGED NICK{1} renamed" {
+		t.Fatalf("SELF_NICK_CHANGED = %q, want our case variant renamed", got)
+	}
+}
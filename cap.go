@@ -7,22 +7,35 @@ package girc
 import (
 	"bytes"
 	"fmt"
-	"io"
+	"sort"
 	"strings"
+	"time"
+	"unicode/utf8"
 )
 
 var possibleCap = map[string][]string{
-	"account-notify":    nil,
-	"account-tag":       nil,
-	"away-notify":       nil,
-	"batch":             nil,
-	"cap-notify":        nil,
-	"chghost":           nil,
-	"extended-join":     nil,
-	"invite-notify":     nil,
-	"message-tags":      nil,
-	"multi-prefix":      nil,
-	"userhost-in-names": nil,
+	"account-notify":       nil,
+	"account-tag":          nil,
+	"away-notify":          nil,
+	"batch":                nil,
+	"cap-notify":           nil,
+	"chathistory":          nil,
+	"chghost":              nil,
+	"draft/bot":            nil,
+	"draft/channel-rename": nil,
+	"draft/chathistory":    nil,
+	"draft/multiline":      nil,
+	"echo-message":         nil,
+	"extended-join":        nil,
+	"invite-notify":        nil,
+	"labeled-response":     nil,
+	"message-tags":         nil,
+	"multi-prefix":         nil,
+	"server-time":          nil,
+	"setname":              nil,
+	"userhost-in-names":    nil,
+	capPlayback:            nil,
+	capSelfMessage:         nil,
 }
 
 func (c *Client) listCAP() {
@@ -31,9 +44,65 @@ func (c *Client) listCAP() {
 	}
 }
 
+// endCAP lets the server know that we're done with capability negotiation,
+// so registration can continue.
+func endCAP(c *Client) {
+	c.write(&Event{Command: CAP, Params: []string{CAP_END}})
+
+	c.state.mu.RLock()
+	enabled := make([]string, len(c.state.enabledCap))
+	copy(enabled, c.state.enabledCap)
+	c.state.mu.RUnlock()
+
+	c.RunHandlers(&Event{Command: CAP_NEGOTIATED, Params: enabled, Trailing: c.Server()})
+}
+
+// CapPolicy declares which IRCv3 capabilities should be negotiated with the
+// server. See Config.Caps.
+type CapPolicy struct {
+	// Want are capabilities which are requested if the server supports
+	// them, in addition to those the client supports by default (and
+	// Config.SupportedCaps).
+	Want []string
+	// Require are capabilities which must be enabled. If the server doesn't
+	// support one of them, or rejects it, the connection is aborted with
+	// ErrCapRequired, rather than continuing without it (e.g. connecting
+	// unauthenticated if "sasl" is unavailable). Required capabilities are
+	// requested like those in Want.
+	Require []string
+	// Deny are capabilities which are never requested, even if the client
+	// supports them by default (e.g. "echo-message"). Deny takes precedence
+	// over Want and Require.
+	Deny []string
+}
+
+// ErrCapRequired is returned (through Config.HandleError) when a capability
+// in CapPolicy.Require couldn't be enabled.
+type ErrCapRequired struct {
+	// Capability is the required capability.
+	Capability string
+	// Rejected is true if the server supports the capability, but rejected
+	// our request for it (CAP NAK).
+	Rejected bool
+}
+
+func (e *ErrCapRequired) Error() string {
+	if e.Rejected {
+		return "required capability " + e.Capability + " was rejected by the server"
+	}
+
+	return "required capability " + e.Capability + " is not supported by the server"
+}
+
 func possibleCapList(c *Client) map[string][]string {
 	out := make(map[string][]string)
 
+	for _, lists := range [][]string{c.Config.Caps.Want, c.Config.Caps.Require} {
+		for _, name := range lists {
+			out[name] = nil
+		}
+	}
+
 	for k := range c.Config.SupportedCaps {
 		out[k] = c.Config.SupportedCaps[k]
 	}
@@ -42,6 +111,20 @@ func possibleCapList(c *Client) map[string][]string {
 		out[k] = possibleCap[k]
 	}
 
+	if c.Config.SASL != nil {
+		out["sasl"] = []string{c.Config.SASL.Name()}
+	}
+
+	if c.Config.TwitchMode {
+		for _, name := range twitchCaps {
+			out[name] = nil
+		}
+	}
+
+	for _, name := range c.Config.Caps.Deny {
+		delete(out, name)
+	}
+
 	return out
 }
 
@@ -71,15 +154,37 @@ func parseCap(raw string) map[string][]string {
 // This will lock further registration until we have acknowledged the
 // capabilities.
 func handleCAP(c *Client, e Event) {
-	if len(e.Params) >= 2 && (e.Params[1] == CAP_NEW || e.Params[1] == CAP_DEL) {
-		c.listCAP()
+	if len(e.Params) >= 2 && e.Params[1] == CAP_NEW {
+		handleCAPNew(c, e)
+		return
+	}
+
+	if len(e.Params) >= 2 && e.Params[1] == CAP_DEL {
+		handleCAPDel(c, e)
 		return
 	}
 
+	c.state.mu.RLock()
+	registered := c.state.registered
+	c.state.mu.RUnlock()
+
 	// We can assume there was a failure attempting to enable a capability.
 	if len(e.Params) == 2 && e.Params[1] == CAP_NAK {
+		if registered {
+			// This was a request for a capability which was added after
+			// registration (see handleCAPNew).
+			return
+		}
+
+		for _, name := range strings.Fields(e.Trailing) {
+			if c.requiredCap(name) {
+				c.abort(&ErrCapRequired{Capability: name, Rejected: true})
+				return
+			}
+		}
+
 		// Let the server know that we're done.
-		c.write(&Event{Command: CAP, Params: []string{CAP_END}})
+		endCAP(c)
 		return
 	}
 
@@ -91,48 +196,25 @@ func handleCAP(c *Client, e Event) {
 		caps := parseCap(e.Trailing)
 
 		for k := range caps {
-			if _, ok := possible[k]; !ok {
-				continue
-			}
+			c.state.capValues[k] = caps[k]
 
-			if len(possible[k]) == 0 || len(caps[k]) == 0 {
+			if wantCap(possible, k, caps[k]) {
 				c.state.tmpCap = append(c.state.tmpCap, k)
-				continue
-			}
-
-			var contains bool
-			for i := 0; i < len(caps[k]); i++ {
-				for j := 0; j < len(possible[k]); j++ {
-					if caps[k][i] == possible[k][j] {
-						// Assume we have a matching split value.
-						contains = true
-						break
-					}
-
-					if contains {
-						break
-					}
-				}
-
-				if contains {
-					break
-				}
-			}
-
-			if !contains {
-				continue
 			}
-
-			c.state.tmpCap = append(c.state.tmpCap, k)
 		}
 		c.state.mu.Unlock()
 
 		// Indicates if this is a multi-line LS. (2 args means it's the
 		// last LS).
 		if len(e.Params) == 2 {
+			if name := c.missingCap(c.state.tmpCap); name != "" {
+				c.abort(&ErrCapRequired{Capability: name})
+				return
+			}
+
 			// If we support no caps, just ack the CAP message and END.
 			if len(c.state.tmpCap) == 0 {
-				c.write(&Event{Command: CAP, Params: []string{CAP_END}})
+				endCAP(c)
 				return
 			}
 
@@ -140,7 +222,7 @@ func handleCAP(c *Client, e Event) {
 			c.write(&Event{Command: CAP, Params: []string{CAP_REQ}, Trailing: strings.Join(c.state.tmpCap, " ")})
 
 			// Re-initialize the tmpCap, so if we get multiple 'CAP LS' requests
-			// due to cap-notify, we can re-evaluate what we can support.
+			// we can re-evaluate what we can support.
 			c.state.mu.Lock()
 			c.state.tmpCap = []string{}
 			c.state.mu.Unlock()
@@ -148,14 +230,181 @@ func handleCAP(c *Client, e Event) {
 	}
 
 	if len(e.Params) == 2 && len(e.Trailing) > 1 && e.Params[1] == CAP_ACK {
+		var added []string
+
 		c.state.mu.Lock()
-		c.state.enabledCap = strings.Split(e.Trailing, " ")
+		for _, name := range strings.Fields(e.Trailing) {
+			if name[0] == 0x2D { // -
+				c.state.removeCap(name[1:])
+				continue
+			}
+
+			if c.state.addCap(name) {
+				added = append(added, name)
+			}
+		}
 		c.state.mu.Unlock()
 
+		if registered {
+			// Capabilities which were added after registration (see
+			// handleCAPNew).
+			for _, name := range added {
+				c.RunHandlers(&Event{Command: CAPABILITY_ADDED, Params: []string{name}})
+
+				if name == "sasl" && c.Config.SASL != nil {
+					startSASL(c)
+				}
+			}
+			return
+		}
+
+		// If the server accepted SASL, we need to authenticate before we
+		// end CAP negotiation. See handleSASL and handleSASLResult.
+		if c.Config.SASL != nil && c.HasCapability("sasl") {
+			startSASL(c)
+			return
+		}
+
 		// Let the server know that we're done.
-		c.write(&Event{Command: CAP, Params: []string{CAP_END}})
+		endCAP(c)
+		return
+	}
+}
+
+// requiredCap returns true if the capability is in CapPolicy.Require, and
+// hasn't been denied.
+func (c *Client) requiredCap(name string) bool {
+	for _, denied := range c.Config.Caps.Deny {
+		if denied == name {
+			return false
+		}
+	}
+
+	for _, required := range c.Config.Caps.Require {
+		if required == name {
+			return true
+		}
+	}
+
+	return false
+}
+
+// missingCap returns the first required capability which isn't going to be
+// requested, as the server doesn't support it (or doesn't support the values
+// we need, e.g. our SASL mechanism), if any.
+func (c *Client) missingCap(requested []string) string {
+	for _, name := range c.Config.Caps.Require {
+		if !c.requiredCap(name) {
+			continue
+		}
+
+		var found bool
+		for i := 0; i < len(requested); i++ {
+			if requested[i] == name {
+				found = true
+				break
+			}
+		}
+
+		if !found {
+			return name
+		}
+	}
+
+	return ""
+}
+
+// wantCap returns true if we'd like to enable the capability name, with the
+// values the server advertised for it.
+func wantCap(possible map[string][]string, name string, values []string) bool {
+	wanted, ok := possible[name]
+	if !ok {
+		return false
+	}
+
+	if len(wanted) == 0 || len(values) == 0 {
+		return true
+	}
+
+	for i := 0; i < len(values); i++ {
+		for j := 0; j < len(wanted); j++ {
+			if values[i] == wanted[j] {
+				// Assume we have a matching split value.
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// handleCAPNew requests capabilities which the server has started supporting
+// after registration (see cap-notify), if we'd like to enable them.
+func handleCAPNew(c *Client, e Event) {
+	possible := possibleCapList(c)
+	caps := parseCap(e.Trailing)
+
+	var want []string
+	var reauth bool
+
+	c.state.mu.Lock()
+	for k := range caps {
+		c.state.capValues[k] = caps[k]
+
+		if !wantCap(possible, k, caps[k]) {
+			continue
+		}
+
+		if !c.state.hasCap(k) {
+			want = append(want, k)
+		} else if k == "sasl" && c.state.account == "" {
+			// The server may advertise sasl again once its mechanisms
+			// change, e.g. when services reconnect, so try again if we
+			// aren't logged in yet.
+			reauth = true
+		}
+	}
+	c.state.mu.Unlock()
+
+	if reauth && c.Config.SASL != nil {
+		startSASL(c)
+	}
+
+	if len(want) == 0 {
 		return
 	}
+
+	sort.Strings(want)
+	c.write(&Event{Command: CAP, Params: []string{CAP_REQ}, Trailing: strings.Join(want, " ")})
+}
+
+// handleCAPDel disables capabilities which the server no longer supports
+// (see cap-notify), and drops any state which depends on them.
+func handleCAPDel(c *Client, e Event) {
+	var removed []string
+
+	c.state.mu.Lock()
+	for _, name := range strings.Fields(e.Trailing) {
+		delete(c.state.capValues, name)
+
+		if c.state.removeCap(name) {
+			removed = append(removed, name)
+		}
+	}
+	c.state.mu.Unlock()
+
+	for _, name := range removed {
+		switch name {
+		case "batch":
+			c.batches.reset()
+		case capEchoMessage:
+			c.echoes.reset()
+		case "message-tags":
+			c.Typing.reset()
+		}
+
+		c.RunHandlers(&Event{Command: CAPABILITY_REMOVED, Params: []string{name}})
+	}
 }
 
 // handleCHGHOST handles incoming IRCv3 hostname change events. CHGHOST is
@@ -177,18 +426,28 @@ func handleCHGHOST(c *Client, e Event) {
 	c.state.mu.Unlock()
 }
 
-// handleAWAY handles incoming IRCv3 AWAY events, for which are sent both
-// when users are no longer away, or when they are away.
-func handleAWAY(c *Client, e Event) {
+// handleSETNAME handles incoming IRCv3 SETNAME events, which are sent when a
+// user (including us) changes their "realname".
+func handleSETNAME(c *Client, e Event) {
+	if e.Source == nil {
+		return
+	}
+
 	c.state.mu.Lock()
 	users := c.state.lookupUsers("nick", e.Source.Name)
 
 	for i := 0; i < len(users); i++ {
-		users[i].Extras.Away = e.Trailing
+		users[i].Extras.Name = e.Trailing
 	}
 	c.state.mu.Unlock()
 }
 
+// handleAWAY handles incoming IRCv3 AWAY events, for which are sent both
+// when users are no longer away, or when they are away.
+func handleAWAY(c *Client, e Event) {
+	c.updateAway(e.Source.Name, e.Trailing != "", e.Trailing, true)
+}
+
 // handleACCOUNT handles incoming IRCv3 ACCOUNT events. ACCOUNT is sent when
 // a user logs into an account, logs out of their account, or logs into a
 // different account. The account backend is handled server-side, so this
@@ -203,34 +462,43 @@ func handleACCOUNT(c *Client, e Event) {
 		account = ""
 	}
 
-	c.state.mu.Lock()
-	users := c.state.lookupUsers("nick", e.Source.Name)
+	c.updateUsers(e.Source.Name, func(user *User) bool {
+		return user.Extras.Account != account
+	}, func(user *User) {
+		user.Extras.Account = account
+	})
+}
 
-	for i := 0; i < len(users); i++ {
-		users[i].Extras.Account = account
-	}
-	c.state.mu.Unlock()
+// accountTagCommands are the commands sent by users, which the server tags
+// with the account of the user (if logged in) when account-tag is enabled.
+var accountTagCommands = map[string]bool{
+	PRIVMSG: true, NOTICE: true, JOIN: true, PART: true, TOPIC: true,
+	KICK: true, MODE: true, NICK: true, INVITE: true, CAP_AWAY: true,
+	CAP_CHGHOST: true, CAP_SETNAME: true,
 }
 
 // handleTags handles any messages that have tags that will affect state. (e.g.
-// 'account' tags.)
+// 'account' tags.) This keeps accounts up to date, even if account-notify
+// and extended-join aren't supported.
 func handleTags(c *Client, e Event) {
-	if len(e.Tags) == 0 {
+	if e.Source == nil || !accountTagCommands[e.Command] {
 		return
 	}
 
-	account, ok := e.Tags.Get("account")
+	account, ok := e.Tag("account")
 	if !ok {
-		return
+		// If account-tag is enabled, the tag is sent with everything sent by
+		// a logged in user, so they're not logged in (anymore).
+		if e.Source.Ident == "" || !c.HasCapability("account-tag") {
+			return
+		}
 	}
 
-	c.state.mu.Lock()
-	users := c.state.lookupUsers("nick", e.Source.Name)
-
-	for i := 0; i < len(users); i++ {
-		users[i].Extras.Account = account
-	}
-	c.state.mu.Unlock()
+	c.updateUsers(e.Source.Name, func(user *User) bool {
+		return user.Extras.Account != account
+	}, func(user *User) {
+		user.Extras.Account = account
+	})
 }
 
 const (
@@ -279,7 +547,7 @@ func ParseTags(raw string) (t Tags) {
 		}
 
 		// Check if tag key or decoded value are invalid.
-		if !validTag(parts[i][:hasValue]) || !validTagValue(tagDecoder.Replace(parts[i][hasValue+1:])) {
+		if !validTag(parts[i][:hasValue]) || !validTagValue(parts[i][hasValue+1:]) {
 			continue
 		}
 
@@ -289,6 +557,53 @@ func ParseTags(raw string) (t Tags) {
 	return t
 }
 
+// checkTags returns an error if any of the tags in raw (as passed to
+// ParseTags, without the "@") are invalid, rather than dropping them like
+// ParseTags does.
+func checkTags(raw string) error {
+	for _, part := range strings.Split(raw, string(tagSeparator)) {
+		key, value := part, ""
+		if i := strings.IndexByte(part, prefixTagValue); i > -1 {
+			key, value = part[:i], part[i+1:]
+		}
+
+		if !validTag(key) {
+			return fmt.Errorf("invalid tag %q", key)
+		}
+		if !validTagValue(value) {
+			return fmt.Errorf("invalid value for tag %q", key)
+		}
+	}
+
+	return nil
+}
+
+// rawTag returns the escaped value of the tag key in raw (as passed to
+// ParseTags, without the "@"), without parsing the other tags. Like
+// ParseTags, invalid tags are ignored, and the last occurrence of a tag
+// wins.
+func rawTag(raw, key string) (value string, ok bool) {
+	for raw != "" {
+		part := raw
+		if i := strings.IndexByte(raw, tagSeparator); i > -1 {
+			part, raw = raw[:i], raw[i+1:]
+		} else {
+			raw = ""
+		}
+
+		k, v := part, ""
+		if i := strings.IndexByte(part, prefixTagValue); i > -1 {
+			k, v = part[:i], part[i+1:]
+		}
+
+		if k == key && validTag(k) && validTagValue(v) {
+			value, ok = v, true
+		}
+	}
+
+	return value, ok
+}
+
 // Len determines the length of the bytes representation of this tag map. This
 // does not include the trailing space required when creating an event, but
 // does include the tag prefix ("@").
@@ -304,20 +619,39 @@ func (t Tags) Count() int {
 // Bytes returns a []byte representation of this tag map, including the tag
 // prefix ("@").
 func (t Tags) Bytes() []byte {
-	max := len(t)
-	if max == 0 {
+	if len(t) == 0 {
 		return nil
 	}
 
 	buffer := new(bytes.Buffer)
+	t.writeTo(buffer)
+
+	return buffer.Bytes()
+}
+
+// String returns a string representation of this tag map.
+func (t Tags) String() string {
+	return string(t.Bytes())
+}
+
+// writeTo appends the representation of the tags to buffer, including the
+// tag prefix ("@"), but not the space which separates them from the rest of
+// an event.
+func (t Tags) writeTo(buffer *bytes.Buffer) {
+	max := len(t)
+	if max == 0 {
+		return
+	}
+
+	start := buffer.Len()
 	buffer.WriteByte(prefixTag)
 
 	var current int
 
 	for tagName, tagValue := range t {
 		// Trim at max allowed chars.
-		if (buffer.Len() + len(tagName) + len(tagValue) + 2) > maxTagLength {
-			return buffer.Bytes()
+		if (buffer.Len() - start + len(tagName) + len(tagValue) + 2) > maxTagLength {
+			return
 		}
 
 		buffer.WriteString(tagName)
@@ -329,50 +663,54 @@ func (t Tags) Bytes() []byte {
 		}
 
 		// add the separator ";" between tags.
-		if current <= max {
+		if current < max-1 {
 			buffer.WriteByte(tagSeparator)
 		}
 
 		current++
 	}
-
-	return buffer.Bytes()
 }
 
-// String returns a string representation of this tag map.
-func (t Tags) String() string {
-	return string(t.Bytes())
+// tagUnescape maps the characters which follow a backslash in escaped tag
+// values, to the characters they represent.
+var tagUnescape = map[byte]byte{
+	0x3A: 0x3B, // \: -> ;
+	0x73: 0x20, // \s -> space
+	0x5C: 0x5C, // \\ -> \
+	0x72: 0x0D, // \r -> CR
+	0x6E: 0x0A, // \n -> LF
 }
 
-// writeTo writes the necessary tag bytes to an io.Writer, including a trailing
-// space-separator.
-func (t Tags) writeTo(w io.Writer) (n int, err error) {
-	b := t.Bytes()
-	if len(b) == 0 {
-		return n, err
+// unescapeTag decodes an escaped tag value. It's decoded a character at a
+// time, so escapes can't overlap (e.g. \\n is a backslash followed by "n",
+// not a backslash followed by a newline). Backslashes followed by any other
+// character are dropped, as are trailing backslashes.
+func unescapeTag(value string) string {
+	if strings.IndexByte(value, 0x5C) < 0 { // \
+		return value
 	}
 
-	n, err = w.Write(b)
-	if err != nil {
-		return n, err
-	}
+	buf := make([]byte, 0, len(value))
+	for i := 0; i < len(value); i++ {
+		if value[i] != 0x5C { // \
+			buf = append(buf, value[i])
+			continue
+		}
 
-	var j int
-	j, err = w.Write([]byte{eventSpace})
-	n += j
+		i++
+		if i == len(value) {
+			break
+		}
 
-	return n, err
-}
+		if c, ok := tagUnescape[value[i]]; ok {
+			buf = append(buf, c)
+		} else {
+			buf = append(buf, value[i])
+		}
+	}
 
-// tagDecode are encoded -> decoded pairs for replacement to decode.
-var tagDecode = []string{
-	"\\:", ";",
-	"\\s", " ",
-	"\\\\", "\\",
-	"\\r", "\r",
-	"\\n", "\n",
+	return string(buf)
 }
-var tagDecoder = strings.NewReplacer(tagDecode...)
 
 // tagEncode are decoded -> encoded pairs for replacement to decode.
 var tagEncode = []string{
@@ -388,13 +726,34 @@ var tagEncoder = strings.NewReplacer(tagEncode...)
 // concurrent safe.
 func (t Tags) Get(key string) (tag string, success bool) {
 	if _, ok := t[key]; ok {
-		tag = tagDecoder.Replace(t[key])
+		tag = unescapeTag(t[key])
 		success = true
 	}
 
 	return tag, success
 }
 
+// Time returns the time the server attached to the message with the "time"
+// tag (see the server-time capability), if any, and if it is valid.
+func (t Tags) Time() (ts time.Time, success bool) {
+	tag, ok := t.Get("time")
+	if !ok {
+		return ts, false
+	}
+
+	return parseTagTime(tag)
+}
+
+// parseTagTime parses the value of the "time" tag.
+func parseTagTime(tag string) (ts time.Time, success bool) {
+	ts, err := time.Parse(time.RFC3339Nano, tag)
+	if err != nil {
+		return ts, false
+	}
+
+	return ts, true
+}
+
 // Set escapes given value and saves it as the value for given key. Note that
 // this is not concurrent safe.
 func (t Tags) Set(key, value string) error {
@@ -444,14 +803,15 @@ func validTag(name string) bool {
 	return true
 }
 
-// validTagValue valids a decoded IRC tag value. If the value is not decoded
-// with tagDecoder first, it may be seen as invalid.
+// validTagValue validates an escaped IRC tag value. Values may contain any
+// UTF-8, besides NUL, CR, LF, spaces and semicolons, which must be escaped.
 func validTagValue(value string) bool {
 	for i := 0; i < len(value); i++ {
-		// Don't allow any invisible chars within the tag, or semicolons.
-		if value[i] < 0x21 || value[i] > 0x7E || value[i] == 0x3B {
+		switch value[i] {
+		case 0x00, 0x0D, 0x0A, 0x20, 0x3B:
 			return false
 		}
 	}
-	return true
+
+	return utf8.ValidString(value)
 }
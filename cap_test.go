@@ -0,0 +1,139 @@
+// Copyright (c) Liam Stanley <me@liamstanley.io>. All rights reserved. Use
+// of this source code is governed by the MIT license that can be found in
+// the LICENSE file.
+
+package girc
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCapNotify(t *testing.T) {
+	c, server := mockClient(t, Config{})
+	defer c.Stop()
+
+	events := make(chan Event, 10)
+	for _, cmd := range []string{CAP_NEGOTIATED, CAPABILITY_ADDED, CAPABILITY_REMOVED} {
+		c.Handlers.Add(cmd, func(c *Client, e Event) { events <- e })
+	}
+
+	expectEvent := func(command, capability string) {
+		t.Helper()
+
+		select {
+		case e := <-events:
+			if e.Command != command || (capability != "" && (len(e.Params) == 0 || e.Params[0] != capability)) {
+				t.Fatalf("received %q, want %s %s", e.String(), command, capability)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatalf("never received %s %s", command, capability)
+		}
+	}
+
+	server.expect("CAP LS 302")
+	server.send(":irc.example.com CAP * LS :away-notify unsupported-cap")
+	server.expect("CAP REQ :away-notify")
+	server.send(":irc.example.com CAP * ACK :away-notify")
+	server.expect("CAP END")
+	expectEvent(CAP_NEGOTIATED, "away-notify")
+	server.send(":irc.example.com 001 nick :Welcome to the network")
+
+	server.send(":irc.example.com CAP nick NEW :unsupported-other echo-message")
+	server.expect("CAP REQ :echo-message")
+	server.send(":irc.example.com CAP nick ACK :echo-message")
+	expectEvent(CAPABILITY_ADDED, "echo-message")
+
+	server.send(":irc.example.com CAP nick DEL :away-notify unsupported-cap")
+	expectEvent(CAPABILITY_REMOVED, "away-notify")
+
+	if c.HasCapability("away-notify") || !c.HasCapability("echo-message") {
+		t.Fatal("capabilities weren't updated")
+	}
+
+	// CAP END isn't sent again after registration.
+	server.send(":irc.example.com CAP nick NEW :chghost")
+	server.expect("CAP REQ :chghost")
+	server.send(":irc.example.com CAP nick NAK :chghost")
+	server.send(":irc.example.com PING :sync")
+	if line := server.expect(""); line != "PONG sync" {
+		t.Fatalf("unexpected line after CAP NAK: %q", line)
+	}
+}
+
+func TestCapPolicy(t *testing.T) {
+	errs := make(chan error, 1)
+	c, server := mockClient(t, Config{
+		Caps:        CapPolicy{Want: []string{"example.com/custom"}, Require: []string{"sasl"}, Deny: []string{"echo-message"}},
+		HandleError: func(err error) { errs <- err },
+	})
+	defer c.Stop()
+
+	possible := possibleCapList(c)
+	if _, ok := possible["example.com/custom"]; !ok {
+		t.Error("wanted capability isn't requested")
+	}
+	if _, ok := possible["echo-message"]; ok {
+		t.Error("denied capability is requested")
+	}
+
+	server.expect("CAP LS 302")
+	server.send(":irc.example.com CAP * LS :example.com/custom echo-message")
+
+	select {
+	case err := <-errs:
+		if err, ok := err.(*ErrCapRequired); !ok || err.Capability != "sasl" || err.Rejected {
+			t.Fatalf("connection aborted with %v, want ErrCapRequired", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("connection wasn't aborted without a required capability")
+	}
+}
+
+func TestCapPolicyRejected(t *testing.T) {
+	errs := make(chan error, 1)
+	c, server := mockClient(t, Config{
+		Caps:        CapPolicy{Require: []string{"account-tag"}},
+		HandleError: func(err error) { errs <- err },
+	})
+	defer c.Stop()
+
+	server.expect("CAP LS 302")
+	server.send(":irc.example.com CAP * LS :account-tag")
+	server.expect("CAP REQ :account-tag")
+	server.send(":irc.example.com CAP * NAK :account-tag")
+
+	select {
+	case err := <-errs:
+		if err, ok := err.(*ErrCapRequired); !ok || err.Capability != "account-tag" || !err.Rejected {
+			t.Fatalf("connection aborted with %v, want ErrCapRequired", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("connection wasn't aborted when a required capability was rejected")
+	}
+}
+
+func TestSetName(t *testing.T) {
+	c, server := mockClient(t, Config{})
+	defer c.Stop()
+
+	if err := c.Commands.SetName("New Name"); err != ErrSetNameUnsupported {
+		t.Fatalf("SetName() without the capability returned %v", err)
+	}
+
+	c.state.mu.Lock()
+	c.state.enabledCap = []string{"setname"}
+	c.state.mu.Unlock()
+
+	if err := c.Commands.SetName("New Name"); err != nil {
+		t.Fatal(err)
+	}
+	server.expect("SETNAME :New Name")
+
+	c.RunHandlers(ParseEvent(":other!ident@example.com JOIN #channel"))
+	c.RunHandlers(ParseEvent(":other!ident@example.com SETNAME :Other Name"))
+
+	if user, _ := c.LookupUser("other"); user == nil || user.Extras.Name != "Other Name" {
+		t.Fatalf("realname wasn't updated: %#v", user)
+	}
+}
@@ -0,0 +1,199 @@
+// Copyright (c) Liam Stanley <me@liamstanley.io>. All rights reserved. Use
+// of this source code is governed by the MIT license that can be found in
+// the LICENSE file.
+
+package girc
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+// ChatHistory subcommands. See ChatHistoryOptions.
+const (
+	HistoryBefore  = "BEFORE"  // messages before From
+	HistoryAfter   = "AFTER"   // messages after From
+	HistoryLatest  = "LATEST"  // the latest messages, after From if set
+	HistoryAround  = "AROUND"  // messages around From
+	HistoryBetween = "BETWEEN" // messages between From and To
+)
+
+// defaultHistoryLimit is the amount of messages requested if neither
+// ChatHistoryOptions.Limit or ISupport.ChatHistory are set.
+const defaultHistoryLimit = 50
+
+// HistorySelector selects a point in history, either by message ID (see the
+// "msgid" tag), or by time. If both are set, the message ID is used.
+type HistorySelector struct {
+	MsgID string
+	Time  time.Time
+}
+
+// IsZero returns true if the selector doesn't select anything.
+func (s HistorySelector) IsZero() bool {
+	return s.MsgID == "" && s.Time.IsZero()
+}
+
+// String returns the selector as used in a CHATHISTORY query, e.g.
+// "msgid=abc" or "timestamp=2019-01-04T14:33:26.123Z", or "*" if the selector
+// is empty.
+func (s HistorySelector) String() string {
+	if s.MsgID != "" {
+		return "msgid=" + s.MsgID
+	}
+
+	if !s.Time.IsZero() {
+		return "timestamp=" + s.Time.UTC().Format("2006-01-02T15:04:05.000Z")
+	}
+
+	return "*"
+}
+
+// ChatHistoryOptions are the options of a CHATHISTORY query. See
+// Commands.ChatHistory.
+type ChatHistoryOptions struct {
+	// Subcommand is the type of query, e.g. HistoryBefore. Defaults to
+	// HistoryLatest.
+	Subcommand string
+	// From is the point in history the query is relative to. Required for
+	// all subcommands besides HistoryLatest.
+	From HistorySelector
+	// To is the end of the range for HistoryBetween.
+	To HistorySelector
+	// Limit is the maximum amount of messages to return. Defaults to the
+	// maximum the server allows (see ISupport.ChatHistory), or 50.
+	Limit int
+}
+
+// ErrChatHistoryFailed is returned when the server refuses a CHATHISTORY
+// query, with a FAIL reply.
+type ErrChatHistoryFailed struct {
+	// Code is the code of the FAIL reply, e.g. "INVALID_TARGET".
+	Code string
+	// Reason is the reason the server supplied, if any.
+	Reason string
+}
+
+func (e *ErrChatHistoryFailed) Error() string {
+	return "chathistory failed (" + e.Code + "): " + e.Reason
+}
+
+// ErrChatHistoryUnsupported is returned by Commands.ChatHistory when the
+// server doesn't support the chathistory capability.
+var ErrChatHistoryUnsupported = errors.New("server does not support chathistory")
+
+// ChatHistory requests past messages of target (a channel or user) from the
+// server, and waits for them to be returned, or until ctx is done. This
+// requires the server to support the draft/chathistory and batch
+// capabilities. The returned events are in the order the server sent them
+// (oldest first), with multiline messages reassembled, and with
+// Event.Timestamp set to when they were originally sent. Events other than
+// messages (e.g. JOIN) may be included, depending on the server.
+func (cmd *Commands) ChatHistory(ctx context.Context, target string, opts ChatHistoryOptions) ([]*Event, error) {
+	if !IsValidNick(target) && !IsValidChannel(target) {
+		return nil, &ErrInvalidTarget{Target: target}
+	}
+
+	c := cmd.c
+	if !c.HasCapability("batch") || (!c.HasCapability("draft/chathistory") && !c.HasCapability("chathistory")) {
+		return nil, ErrChatHistoryUnsupported
+	}
+	id := c.toLower(target)
+
+	if opts.Subcommand == "" {
+		opts.Subcommand = HistoryLatest
+	}
+	opts.Subcommand = strings.ToUpper(opts.Subcommand)
+
+	if opts.Limit <= 0 {
+		c.state.mu.RLock()
+		opts.Limit = c.state.isupport.ChatHistory
+		c.state.mu.RUnlock()
+
+		if opts.Limit <= 0 {
+			opts.Limit = defaultHistoryLimit
+		}
+	}
+
+	params := []string{opts.Subcommand, target}
+	switch opts.Subcommand {
+	case HistoryLatest:
+		params = append(params, opts.From.String())
+	case HistoryBefore, HistoryAfter, HistoryAround:
+		if opts.From.IsZero() {
+			return nil, errors.New("chathistory " + opts.Subcommand + " requires a selector")
+		}
+		params = append(params, opts.From.String())
+	case HistoryBetween:
+		if opts.From.IsZero() || opts.To.IsZero() {
+			return nil, errors.New("chathistory " + opts.Subcommand + " requires two selectors")
+		}
+		params = append(params, opts.From.String(), opts.To.String())
+	default:
+		return nil, errors.New("unknown chathistory subcommand: " + opts.Subcommand)
+	}
+	params = append(params, strconv.Itoa(opts.Limit))
+
+	var mu sync.Mutex
+	var events []*Event
+	done := make(chan error, 1)
+
+	cuid := c.Handlers.sregisterOwned(ALLEVENTS, HandlerFunc(func(client *Client, e Event) {
+		var err error
+
+		switch e.Command {
+		case BATCH_COMPLETE:
+			if e.Batch == nil || e.Batch.Parent != nil || len(e.Params) < 2 || client.toLower(e.Params[1]) != id {
+				return
+			}
+			if e.Params[0] != "chathistory" && e.Params[0] != "draft/chathistory" {
+				return
+			}
+
+			mu.Lock()
+			events = e.Batch.Events
+			mu.Unlock()
+		case FAIL:
+			if len(e.Params) < 2 || strings.ToUpper(e.Params[0]) != CHATHISTORY {
+				return
+			}
+
+			err = &ErrChatHistoryFailed{Code: e.Params[1], Reason: e.Trailing}
+		default:
+			return
+		}
+
+		select {
+		case done <- err:
+		default:
+			// Already finished.
+		}
+	}))
+	defer c.Handlers.Remove(cuid)
+
+	c.Send(&Event{Command: CHATHISTORY, Params: params})
+
+	select {
+	case err := <-done:
+		if err != nil {
+			return nil, err
+		}
+
+		mu.Lock()
+		defer mu.Unlock()
+
+		history := make([]*Event, len(events))
+		for i := 0; i < len(events); i++ {
+			history[i] = events[i].Copy()
+		}
+
+		return history, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
@@ -0,0 +1,118 @@
+// Copyright (c) Liam Stanley <me@liamstanley.io>. All rights reserved. Use
+// of this source code is governed by the MIT license that can be found in
+// the LICENSE file.
+
+package girc
+
+import (
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+func TestHistorySelector(t *testing.T) {
+	tests := []struct {
+		sel  HistorySelector
+		want string
+	}{
+		{sel: HistorySelector{}, want: "*"},
+		{sel: HistorySelector{MsgID: "abc"}, want: "msgid=abc"},
+		{sel: HistorySelector{Time: time.Date(2019, 1, 4, 14, 33, 26, 123000000, time.UTC)}, want: "timestamp=2019-01-04T14:33:26.123Z"},
+	}
+
+	for _, tt := range tests {
+		if got := tt.sel.String(); got != tt.want {
+			t.Errorf("%#v.String() = %q, want %q", tt.sel, got, tt.want)
+		}
+	}
+}
+
+func TestChatHistory(t *testing.T) {
+	c, server := mockClient(t, Config{RateBurst: 10})
+	defer c.Stop()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	if _, err := c.Commands.ChatHistory(ctx, "#channel", ChatHistoryOptions{}); err != ErrChatHistoryUnsupported {
+		t.Fatalf("ChatHistory() without the capability returned %v", err)
+	}
+
+	c.state.mu.Lock()
+	c.state.enabledCap = []string{"batch", "draft/chathistory", capMultiline}
+	c.state.mu.Unlock()
+
+	type result struct {
+		events []*Event
+		err    error
+	}
+	results := make(chan result, 1)
+
+	history := func(opts ChatHistoryOptions) {
+		go func() {
+			events, err := c.Commands.ChatHistory(ctx, "#channel", opts)
+			results <- result{events, err}
+		}()
+	}
+
+	history(ChatHistoryOptions{Subcommand: HistoryBefore, From: HistorySelector{MsgID: "xyz"}, Limit: 10})
+	server.expect("CHATHISTORY BEFORE #channel msgid=xyz 10")
+	server.send(":irc.example.com BATCH +ref chathistory #channel")
+	server.send("@batch=ref;time=2019-01-04T14:33:26.123Z;msgid=a :other!user@host PRIVMSG #channel :first")
+	server.send("@batch=ref;time=2019-01-04T14:34:00.000Z;msgid=b :other!user@host BATCH +ml draft/multiline #channel")
+	server.send("@batch=ml :other!user@host PRIVMSG #channel :second")
+	server.send("@batch=ml :other!user@host PRIVMSG #channel :line")
+	server.send(":irc.example.com BATCH -ml")
+	server.send(":irc.example.com BATCH -ref")
+
+	res := <-results
+	if res.err != nil || len(res.events) != 2 {
+		t.Fatalf("ChatHistory() = %v, %v", res.events, res.err)
+	}
+	if res.events[0].Trailing != "first" || res.events[1].Trailing != "second\nline" {
+		t.Fatalf("unexpected history: %q", res.events)
+	}
+	if msgid, _ := res.events[1].Tags.Get("msgid"); msgid != "b" {
+		t.Fatalf("reassembled message has msgid %q, want b", msgid)
+	}
+	if want := time.Date(2019, 1, 4, 14, 33, 26, 123000000, time.UTC); !res.events[0].Timestamp.Equal(want) {
+		t.Fatalf("first message has timestamp %v, want %v", res.events[0].Timestamp, want)
+	}
+
+	history(ChatHistoryOptions{})
+	server.expect("CHATHISTORY LATEST #channel * 50")
+	server.send(":irc.example.com FAIL CHATHISTORY INVALID_TARGET LATEST #channel :Messages could not be retrieved")
+
+	res = <-results
+	if err, ok := res.err.(*ErrChatHistoryFailed); !ok || err.Code != "INVALID_TARGET" {
+		t.Fatalf("ChatHistory() returned %v, want ErrChatHistoryFailed", res.err)
+	}
+
+	if _, err := c.Commands.ChatHistory(ctx, "#channel", ChatHistoryOptions{Subcommand: HistoryBetween, From: HistorySelector{MsgID: "a"}}); err == nil {
+		t.Fatal("ChatHistory() BETWEEN with a single selector returned no error")
+	}
+
+	// With ascii, the history of #a{b} isn't that of #a[b].
+	c.state.mu.Lock()
+	c.state.isupport.Casemapping = CaseMappingASCII
+	c.state.mu.Unlock()
+
+	go func() {
+		events, err := c.Commands.ChatHistory(ctx, "#a[b]", ChatHistoryOptions{})
+		results <- result{events, err}
+	}()
+
+	server.expect("CHATHISTORY LATEST #a[b] * 50")
+	server.send(":irc.example.com BATCH +other chathistory #a{b}")
+	server.send("@batch=other :other!user@host PRIVMSG #a{b} :elsewhere")
+	server.send(":irc.example.com BATCH -other")
+	server.send(":irc.example.com BATCH +ref chathistory #A[B]")
+	server.send("@batch=ref :other!user@host PRIVMSG #A[B] :here")
+	server.send(":irc.example.com BATCH -ref")
+
+	res = <-results
+	if res.err != nil || len(res.events) != 1 || res.events[0].Trailing != "here" {
+		t.Fatalf("ChatHistory() = %v, %v, want the history of #A[B]", res.events, res.err)
+	}
+}
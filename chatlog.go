@@ -0,0 +1,499 @@
+// Copyright (c) Liam Stanley <me@liamstanley.io>. All rights reserved. Use
+// of this source code is governed by the MIT license that can be found in
+// the LICENSE file.
+
+package girc
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// defaultChatLogMaxOpen is the amount of log files kept open, if
+// ChatLog.MaxOpen isn't set.
+const defaultChatLogMaxOpen = 32
+
+// ChatLog configures the built-in chat logger, which writes the messages
+// (and joins, parts, kicks, quits, nickname changes, topic and mode
+// changes) of each channel we're in, and of each private conversation
+// ("query"), to a file of its own. See Config.ChatLog.
+type ChatLog struct {
+	// Dir is the directory the logs are written to, which is created if it
+	// doesn't exist. Each log is named after the lowercased channel, or
+	// nickname of the query, e.g. "#channel.log".
+	Dir string
+	// Ext is the extension of the log files. Defaults to ".log".
+	Ext string
+	// Formatter formats the events written to the logs. Defaults to
+	// LogText. See also LogJSON.
+	Formatter LogFormatter
+	// MaxSize is the size (in bytes) at which a log is rotated: it's renamed
+	// with the first free number appended (e.g. "#channel.log.1"), and a new
+	// log is started. If 0, logs are never rotated by size.
+	MaxSize int64
+	// Daily starts a new log every day, with the (local) date of the events
+	// in the name, e.g. "#channel.2006-01-02.log".
+	Daily bool
+	// MaxOpen is the amount of log files kept open at once, closing the
+	// least recently written to. Defaults to 32.
+	MaxOpen int
+}
+
+// LogFormatter formats an event for the log of target (a channel, or the
+// nickname of a query), returning the line to write (without a trailing
+// newline), or "" to skip the event. e.Timestamp is when the event
+// happened, from server-time where supported. The events passed are
+// PRIVMSG and NOTICE (including CTCP ACTION), JOIN, PART, KICK, QUIT, NICK
+// (with the new nickname as the first param), TOPIC and MODE.
+type LogFormatter func(target string, e *Event) string
+
+// LogText formats events as plain text, e.g. "[2006-01-02 15:04:05] <nick>
+// message". See LogFormatter.
+func LogText(target string, e *Event) string {
+	if e.Source == nil {
+		return ""
+	}
+
+	nick := e.Source.Name
+	mask := nick
+	if e.Source.Ident != "" && e.Source.Host != "" {
+		mask += " (" + e.Source.Ident + "@" + e.Source.Host + ")"
+	}
+
+	reason := func(text string) string {
+		if text == "" {
+			return ""
+		}
+
+		return " (" + text + ")"
+	}
+
+	var line string
+	switch e.Command {
+	case PRIVMSG:
+		if e.IsAction() {
+			line = "* " + nick + " " + e.StripAction()
+		} else {
+			line = "<" + nick + "> " + e.Trailing
+		}
+	case NOTICE:
+		line = "-" + nick + "- " + e.Trailing
+	case JOIN:
+		line = "*** " + mask + " has joined " + target
+	case PART:
+		line = "*** " + mask + " has left " + target + reason(e.Trailing)
+	case KICK:
+		if len(e.Params) < 2 {
+			return ""
+		}
+
+		line = "*** " + e.Params[1] + " was kicked by " + nick + reason(e.Trailing)
+	case QUIT:
+		line = "*** " + mask + " has quit" + reason(e.Trailing)
+	case NICK:
+		if len(e.Params) < 1 {
+			return ""
+		}
+
+		line = "*** " + nick + " is now known as " + e.Params[0]
+	case TOPIC:
+		line = "*** " + nick + " changes topic to: " + e.Trailing
+	case MODE:
+		if len(e.Params) < 2 {
+			return ""
+		}
+
+		line = "*** " + nick + " sets mode: " + strings.Join(e.Params[1:], " ")
+	default:
+		return ""
+	}
+
+	return "[" + e.Timestamp.Local().Format("2006-01-02 15:04:05") + "] " + line
+}
+
+// logEntry is a line written by LogJSON.
+type logEntry struct {
+	Time    time.Time `json:"time"`
+	Target  string    `json:"target"`
+	Command string    `json:"command"`
+	Nick    string    `json:"nick"`
+	Ident   string    `json:"ident,omitempty"`
+	Host    string    `json:"host,omitempty"`
+	Params  []string  `json:"params,omitempty"`
+	Text    string    `json:"text,omitempty"`
+	Action  bool      `json:"action,omitempty"`
+}
+
+// LogJSON formats events as JSON objects (with the time, target, command,
+// nick, ident, host, params and text of the event), for a log with an
+// object per line (JSON Lines). See LogFormatter.
+func LogJSON(target string, e *Event) string {
+	if e.Source == nil {
+		return ""
+	}
+
+	entry := logEntry{
+		Time:    e.Timestamp,
+		Target:  target,
+		Command: e.Command,
+		Nick:    e.Source.Name,
+		Ident:   e.Source.Ident,
+		Host:    e.Source.Host,
+		Text:    e.Trailing,
+	}
+
+	if len(e.Params) > 1 {
+		entry.Params = e.Params[1:]
+	} else if e.Command == NICK && len(e.Params) > 0 {
+		entry.Params = e.Params
+	}
+
+	if e.IsAction() {
+		entry.Action, entry.Text = true, e.StripAction()
+	}
+
+	out, err := json.Marshal(entry)
+	if err != nil {
+		return ""
+	}
+
+	return string(out)
+}
+
+// chatLogger writes the logs of a client. See ChatLog.
+type chatLogger struct {
+	conf ChatLog
+
+	mu sync.Mutex
+	// files are the open logs, keyed by their (file) name, without the date
+	// and extension.
+	files map[string]*chatLogFile
+	// seq orders the writes to files, to close the least recently written
+	// to.
+	seq uint64
+}
+
+// chatLogFile is an open log.
+type chatLogFile struct {
+	file *os.File
+	path string
+	date string
+	size int64
+	used uint64
+}
+
+// newChatLogger returns a chat logger for conf, with the defaults applied.
+func newChatLogger(conf ChatLog) *chatLogger {
+	if conf.Ext == "" {
+		conf.Ext = ".log"
+	}
+
+	if conf.Formatter == nil {
+		conf.Formatter = LogText
+	}
+
+	if conf.MaxOpen < 1 {
+		conf.MaxOpen = defaultChatLogMaxOpen
+	}
+
+	return &chatLogger{conf: conf, files: make(map[string]*chatLogFile)}
+}
+
+// chatLogName returns the name of the log file of a (lowercased) target,
+// replacing the characters which aren't allowed (or are troublesome) in
+// file names.
+func chatLogName(target string) string {
+	return strings.Map(func(r rune) rune {
+		if r < 0x20 || strings.ContainsRune(`/\:*?"<>|`, r) {
+			return 0x5F // _
+		}
+
+		return r
+	}, target)
+}
+
+// write formats e, and writes it to the log of target (named name).
+func (l *chatLogger) write(name, target string, e *Event) error {
+	line := l.conf.Formatter(target, e)
+	if line == "" {
+		return nil
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	var date string
+	if l.conf.Daily {
+		date = e.Timestamp.Local().Format("2006-01-02")
+	}
+
+	lf := l.files[name]
+	if lf != nil && lf.date != date {
+		l.closeFile(name)
+		lf = nil
+	}
+
+	var err error
+	if lf == nil {
+		if lf, err = l.open(name, date); err != nil {
+			return err
+		}
+	}
+
+	if l.conf.MaxSize > 0 && lf.size > 0 && lf.size+int64(len(line))+1 > l.conf.MaxSize {
+		if lf, err = l.rotate(name, lf); err != nil {
+			return err
+		}
+	}
+
+	l.seq++
+	lf.used = l.seq
+
+	n, err := lf.file.WriteString(line + "\n")
+	lf.size += int64(n)
+
+	return err
+}
+
+// open opens (or creates) the log of name, for date, closing the least
+// recently written to log if too many are open.
+func (l *chatLogger) open(name, date string) (*chatLogFile, error) {
+	if len(l.files) >= l.conf.MaxOpen {
+		var oldest string
+		for key, lf := range l.files {
+			if oldest == "" || lf.used < l.files[oldest].used {
+				oldest = key
+			}
+		}
+
+		l.closeFile(oldest)
+	}
+
+	if err := os.MkdirAll(l.conf.Dir, 0755); err != nil {
+		return nil, err
+	}
+
+	file := name
+	if date != "" {
+		file += "." + date
+	}
+
+	lf := &chatLogFile{path: filepath.Join(l.conf.Dir, file+l.conf.Ext), date: date}
+	if err := lf.openFile(); err != nil {
+		return nil, err
+	}
+
+	l.files[name] = lf
+	return lf, nil
+}
+
+// openFile opens the file at the path of the log for appending.
+func (lf *chatLogFile) openFile() error {
+	file, err := os.OpenFile(lf.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+
+	lf.file, lf.size = file, info.Size()
+	return nil
+}
+
+// rotate renames the log of name with the first free number appended, and
+// starts a new one.
+func (l *chatLogger) rotate(name string, lf *chatLogFile) (*chatLogFile, error) {
+	lf.file.Close()
+	delete(l.files, name)
+
+	for i := 1; ; i++ {
+		rotated := lf.path + "." + strconv.Itoa(i)
+		if _, err := os.Stat(rotated); os.IsNotExist(err) {
+			if err = os.Rename(lf.path, rotated); err != nil {
+				return nil, err
+			}
+
+			break
+		}
+	}
+
+	if err := lf.openFile(); err != nil {
+		return nil, err
+	}
+
+	l.files[name] = lf
+	return lf, nil
+}
+
+// closeFile closes the log of name. Always use chatLogger.mu for
+// transaction.
+func (l *chatLogger) closeFile(name string) {
+	if lf, ok := l.files[name]; ok {
+		lf.file.Close()
+		delete(l.files, name)
+	}
+}
+
+// close closes all open logs. They're reopened when next written to.
+func (l *chatLogger) close() {
+	l.mu.Lock()
+	for name := range l.files {
+		l.closeFile(name)
+	}
+	l.mu.Unlock()
+}
+
+// logChat writes e to the log of target.
+func (c *Client) logChat(target string, e *Event) {
+	c.state.mu.RLock()
+	name := chatLogName(c.state.toLower(target))
+	c.state.mu.RUnlock()
+
+	if e.Timestamp.IsZero() {
+		e.Timestamp = time.Now()
+	}
+
+	if err := c.chatlog.write(name, target, e); err != nil {
+		c.debug.Printf("unable to write chat log of %s: %s", target, err)
+	}
+}
+
+// logChannels writes e to the logs of the tracked channels nick is in.
+func (c *Client) logChannels(nick string, e *Event) {
+	var channels []string
+
+	c.state.mu.RLock()
+	for _, ch := range c.state.channels {
+		if _, ok := ch.users[c.state.toLower(nick)]; ok {
+			channels = append(channels, ch.Name)
+		}
+	}
+	c.state.mu.RUnlock()
+
+	for _, channel := range channels {
+		c.logChat(channel, e)
+	}
+}
+
+// chatLogTarget returns the target of a message, which is the channel for
+// channel messages (including those for only the users with a prefix, e.g.
+// "@#channel"), or otherwise the nickname of the other user of the query.
+func (c *Client) chatLogTarget(e *Event) (target string, ok bool) {
+	if len(e.Params) < 1 || e.Source == nil || e.Source.Name == "" || e.Source.IsServer() {
+		return "", false
+	}
+
+	c.state.mu.RLock()
+	statusmsg := c.state.isupport.StatusMsg
+	c.state.mu.RUnlock()
+
+	target = strings.TrimLeft(e.Params[0], statusmsg)
+	if IsValidChannel(target) {
+		return target, true
+	}
+
+	// With echo-message, the messages we send are also received.
+	if c.isSelf(e.Source) {
+		return e.Params[0], true
+	}
+
+	return e.Source.Name, true
+}
+
+// isCTCP returns true if the message is a CTCP query or reply.
+func isCTCP(e *Event) bool {
+	return len(e.Trailing) > 1 && e.Trailing[0] == ctcpDelim && e.Trailing[len(e.Trailing)-1] == ctcpDelim
+}
+
+// handleChatLog writes the events received to the chat logs.
+func handleChatLog(c *Client, e Event) {
+	if c.chatlog == nil {
+		return
+	}
+
+	switch e.Command {
+	case PRIVMSG, NOTICE:
+		// CTCP queries and replies (other than actions) aren't logged.
+		if isCTCP(&e) && !e.IsAction() {
+			return
+		}
+
+		if target, ok := c.chatLogTarget(&e); ok {
+			c.logChat(target, &e)
+		}
+	case JOIN, PART, KICK, TOPIC, MODE:
+		if len(e.Params) > 0 && IsValidChannel(e.Params[0]) && e.Source != nil {
+			c.logChat(e.Params[0], &e)
+		}
+	case USER_LEFT:
+		// QUIT isn't sent to a channel, so log it to each channel the user
+		// was in, which are only known when tracking.
+		if len(e.Params) > 1 && e.Params[1] == QUIT {
+			c.logChat(e.Params[0], &Event{Source: e.Source, Command: QUIT, Trailing: e.Trailing, Timestamp: e.Timestamp})
+		}
+	case USER_RENAMED:
+		if len(e.Params) > 1 {
+			c.logChannels(e.Params[1], &Event{Source: e.Source, Command: NICK, Params: []string{e.Params[1]}, Timestamp: e.Timestamp})
+		}
+	case STOPPED:
+		c.chatlog.close()
+	}
+}
+
+// logSent writes a message we've sent to the chat logs, if the server
+// won't echo it back (see echo-message). Messages sent with CPRIVMSG or
+// CNOTICE are logged as the PRIVMSG or NOTICE they were sent as.
+func (c *Client) logSent(event *Event) {
+	if c.chatlog == nil || event.Sensitive || len(event.Params) < 1 || c.HasCapability("echo-message") {
+		return
+	}
+
+	switch event.Command {
+	case PRIVMSG, NOTICE:
+	case CPRIVMSG, CNOTICE:
+		// CPRIVMSG <nick> <channel> :<text>
+		event = event.Copy()
+		event.Command, event.Params = event.Command[1:], event.Params[:1]
+	default:
+		return
+	}
+
+	if isCTCP(event) && !event.IsAction() {
+		return
+	}
+
+	nick := c.configNick()
+	if !c.Config.disableTracking {
+		nick = c.GetNick()
+	}
+
+	c.state.mu.RLock()
+	statusmsg := c.state.isupport.StatusMsg
+	c.state.mu.RUnlock()
+
+	for _, target := range strings.Split(event.Params[0], ",") {
+		if target == "" {
+			continue
+		}
+
+		e := event.Copy()
+		e.Source = &Source{Name: nick}
+		e.Params = append([]string{target}, event.Params[1:]...)
+		e.Timestamp = time.Now()
+
+		if channel := strings.TrimLeft(target, statusmsg); IsValidChannel(channel) {
+			target = channel
+		}
+
+		c.logChat(target, e)
+	}
+}
@@ -0,0 +1,200 @@
+// Copyright (c) Liam Stanley <me@liamstanley.io>. All rights reserved. Use
+// of this source code is governed by the MIT license that can be found in
+// the LICENSE file.
+
+package girc
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// readChatLog returns the lines of the log file name in dir.
+func readChatLog(t *testing.T, dir, name string) []string {
+	t.Helper()
+
+	data, err := ioutil.ReadFile(filepath.Join(dir, name))
+	if err != nil {
+		t.Fatalf("unable to read log %s: %s", name, err)
+	}
+
+	return strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+}
+
+func TestChatLog(t *testing.T) {
+	dir, err := ioutil.TempDir("", "girc-chatlog")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	c, server := mockClient(t, Config{AllowFlood: true, ChatLog: &ChatLog{Dir: dir}})
+	defer c.Stop()
+
+	server.send(":nick!user@host JOIN #Channel")
+	server.send(":other!ident@example.com JOIN #channel")
+	server.send("@time=2020-01-02T03:04:05.000Z :other!ident@example.com PRIVMSG #channel :hello")
+	server.send(":other!ident@example.com PRIVMSG #channel :\001ACTION waves\001")
+	server.send(":other!ident@example.com PRIVMSG nick :\001VERSION\001")
+	server.send(":other!ident@example.com PRIVMSG nick :hi there")
+	server.send(":irc.example.com NOTICE nick :*** server notice")
+	server.send("PING :sync")
+	server.expect("PONG sync")
+
+	c.Commands.Message("#channel", "hey")
+	c.Commands.Message("Other", "private")
+	server.expect("PRIVMSG #channel :hey")
+	server.expect("PRIVMSG Other :private")
+
+	server.send(":other!ident@example.com NICK other2")
+	server.send(":other2!ident@example.com QUIT :bye")
+	server.send("PING :sync")
+	server.expect("PONG sync")
+
+	stamp := func(t time.Time) string {
+		return "[" + t.Local().Format("2006-01-02 15:04:05") + "] "
+	}
+
+	channel := readChatLog(t, dir, "#channel.log")
+	want := []string{
+		"*** nick (user@host) has joined #Channel",
+		"*** other (ident@example.com) has joined #channel",
+		stamp(time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)) + "<other> hello",
+		"* other waves",
+		"<nick> hey",
+		"*** other is now known as other2",
+		"*** other2 (ident@example.com) has quit (bye)",
+	}
+	if len(channel) != len(want) {
+		t.Fatalf("channel log = %q, want %d lines", channel, len(want))
+	}
+
+	for i := range want {
+		if !strings.HasSuffix(channel[i], want[i]) {
+			t.Errorf("channel log line %d = %q, want %q", i, channel[i], want[i])
+		}
+	}
+
+	if !strings.HasPrefix(channel[2], want[2]) {
+		t.Errorf("channel log line 2 = %q, not using the server-time", channel[2])
+	}
+
+	query := readChatLog(t, dir, "other.log")
+	if len(query) != 2 || !strings.HasSuffix(query[0], "<other> hi there") || !strings.HasSuffix(query[1], "<nick> private") {
+		t.Errorf("query log = %q", query)
+	}
+
+	if _, err := os.Stat(filepath.Join(dir, "irc.example.com.log")); !os.IsNotExist(err) {
+		t.Errorf("server notices were logged: %v", err)
+	}
+}
+
+func TestChatLogRotate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "girc-chatlog")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	l := newChatLogger(ChatLog{Dir: dir, Ext: ".jsonl", Formatter: LogJSON, MaxSize: 400, Daily: true, MaxOpen: 1})
+	defer l.close()
+
+	day := time.Date(2020, 1, 2, 12, 0, 0, 0, time.Local)
+	src := &Source{Name: "nick", Ident: "user", Host: "host"}
+
+	for i := 0; i < 3; i++ {
+		e := &Event{Source: src, Command: PRIVMSG, Params: []string{"#a"}, Trailing: "a message of some length", Timestamp: day}
+		if err := l.write("#a", "#a", e); err != nil {
+			t.Fatalf("write failed: %s", err)
+		}
+	}
+
+	// Writing to another log closes #a, as only one may be open.
+	if err := l.write("b", "b", &Event{Source: src, Command: PRIVMSG, Params: []string{"test"}, Trailing: "hi", Timestamp: day}); err != nil {
+		t.Fatalf("write failed: %s", err)
+	}
+
+	if len(l.files) != 1 || l.files["b"] == nil {
+		t.Errorf("open logs = %v, want only b", l.files)
+	}
+
+	next := day.AddDate(0, 0, 1)
+	if err := l.write("#a", "#a", &Event{Source: src, Command: PART, Params: []string{"#a"}, Timestamp: next}); err != nil {
+		t.Fatalf("write failed: %s", err)
+	}
+
+	current := readChatLog(t, dir, "#a.2020-01-02.jsonl")
+	rotated := readChatLog(t, dir, "#a.2020-01-02.jsonl.1")
+	if len(rotated) != 2 || len(current) != 1 {
+		t.Errorf("rotated log = %q, current log = %q, want 2 and 1 lines", rotated, current)
+	}
+
+	if want := `{"time":"`; !strings.HasPrefix(current[0], want) || !strings.Contains(current[0], `"text":"a message of some length"`) {
+		t.Errorf("json line = %q", current[0])
+	}
+
+	if next := readChatLog(t, dir, "#a.2020-01-03.jsonl"); len(next) != 1 || !strings.Contains(next[0], `"command":"PART"`) {
+		t.Errorf("next day log = %q", next)
+	}
+}
+
+func TestChatLogSent(t *testing.T) {
+	dir, err := ioutil.TempDir("", "girc-chatlog")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// Messages are only logged once sent, not when dropped.
+	c := New(Config{ChatLog: &ChatLog{Dir: dir}, SendQueueSize: 1, SendOverflow: OverflowError, HandleError: func(err error) {}})
+	c.Commands.Message("#channel", "queued")
+	c.Commands.Message("#channel", "dropped")
+	c.Commands.Join("#queued")
+	c.Commands.JoinKey("#secret", "key")
+
+	if _, err := os.Stat(filepath.Join(dir, "#channel.log")); !os.IsNotExist(err) {
+		t.Errorf("messages which weren't sent were logged: %v", err)
+	}
+	if key := c.keys.get(CaseMappingRFC1459, "#secret"); key != "" {
+		t.Errorf("key of a JOIN which wasn't sent was recorded: %q", key)
+	}
+
+	c, server := mockClient(t, Config{AllowFlood: true, ChatLog: &ChatLog{Dir: dir}})
+	defer c.Stop()
+
+	server.send(":irc.example.com 001 nick :Welcome")
+	server.send(":irc.example.com 005 nick CPRIVMSG :are supported by this server")
+	server.send(":nick!user@host JOIN #channel")
+	server.send(":irc.example.com 353 nick = #channel :+nick other")
+	server.send("PING :sync")
+	server.expect("PONG sync")
+
+	c.Commands.Message("Other", "private")
+	server.expect("CPRIVMSG Other #channel :private")
+	server.send("PING :sync")
+	server.expect("PONG sync")
+
+	query := readChatLog(t, dir, "other.log")
+	if len(query) != 1 || !strings.HasSuffix(query[0], "<nick> private") {
+		t.Errorf("query log = %q, want the message sent with CPRIVMSG", query)
+	}
+}
+
+func TestChatLogName(t *testing.T) {
+	tests := map[string]string{
+		"#channel":  "#channel",
+		"#a/../b":   "#a_.._b",
+		"nick|away": "nick_away",
+		"#c:d\x01":  "#c_d_",
+	}
+
+	for in, want := range tests {
+		if got := chatLogName(in); got != want {
+			t.Errorf("chatLogName(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
@@ -11,8 +11,9 @@ import (
 	"io"
 	"io/ioutil"
 	"log"
+	"net/http"
 	"runtime"
-	"strings"
+	"sort"
 	"sync"
 	"time"
 
@@ -26,8 +27,9 @@ type Client struct {
 	Config Config
 	// rx is a buffer of events waiting to be processed.
 	rx chan *Event
-	// tx is a buffer of events waiting to be sent.
-	tx chan *Event
+	// tx are buffers of events waiting to be sent, one for each priority
+	// lane. See eventPriority.
+	tx [priorityLanes]chan *Event
 
 	// state represents the throw-away state for the irc session.
 	state *state
@@ -40,6 +42,16 @@ type Client struct {
 	CTCP *CTCP
 	// Commands contains various helper methods to interact with the server.
 	Commands *Commands
+	// Ignores is a list of masks which incoming messages are ignored from.
+	Ignores *Ignores
+	// Monitor tracks the presence of a list of users.
+	Monitor *Monitor
+	// Typing sends typing notifications.
+	Typing *Typing
+	// DCC sends and receives files with DCC SEND.
+	DCC *DCC
+	// Plugins manages the plugins loaded into the client.
+	Plugins *Plugins
 
 	// conn is a net.Conn reference to the IRC server.
 	conn *ircConn
@@ -48,10 +60,58 @@ type Client struct {
 	// reconnecting is true if the client is reconnecting, used so multiple
 	// threads aren't trying to reconnect at the same time.
 	reconnecting bool
+	// redirects is the amount of redirects (RPL_REDIR) which were followed
+	// since we last registered. See Config.Redirects.
+	redirects int
+	// identd is the running ident responder, if any. See Config.Identd.
+	identd *identd
 	// cmux is the mux used for connections/disconnections from the server,
 	// so multiple threads aren't trying to connect at the same time, and
 	// vice versa.
 	cmux sync.Mutex
+	// hmux protects sendHooks.
+	hmux sync.RWMutex
+	// sendHooks are the hooks which outgoing events are passed through. See
+	// Client.AddSendHook().
+	sendHooks []SendHook
+	// qmux protects quitDone.
+	qmux sync.Mutex
+	// quitDone is non-nil while QuitGraceful() is in progress, and is closed
+	// once the server has closed the connection.
+	quitDone chan struct{}
+	// batches tracks the IRCv3 batches opened by the server.
+	batches batchTracker
+	// echoes tracks messages sent with SendEcho, until they are echoed back.
+	echoes echoTracker
+	// whoxTokens is incremented for each WHOX query (see Commands.Whox),
+	// and must be accessed atomically.
+	whoxTokens uint32
+	// regain tracks the attempts to regain our nickname. See
+	// Config.NickRegain.
+	regain nickRegain
+	// rejoin tracks the channels which are being rejoined. See
+	// Config.RejoinOnKick.
+	rejoin rejoinTracker
+	// keys are the keys we've joined channels with. See Client.ChannelKey.
+	keys channelKeys
+	// chatlog writes the chat logs, if enabled. See Config.ChatLog.
+	chatlog *chatLogger
+	// backfills tracks the last messages received in channels. See
+	// Config.Backfill.
+	backfills backfillTracker
+	// floods tracks the recent messages of users. See Config.FloodGuard.
+	floods floodTracker
+	// confmu guards the fields of Config which can be changed at runtime:
+	// Nick, Name, AutoJoin, AllowFlood, RateBurst, RateInterval and Debug
+	// (see Client.SetNick, etc), and Server and Port (see
+	// Config.Redirects).
+	confmu sync.RWMutex
+	// limiter is the rate limiter shared with the other clients of a pool
+	// which connect to the same server, if any. See Pool.
+	limiter *tokenBucket
+	// jobs are the functions scheduled for the current connection. See
+	// Client.Schedule.
+	jobs scheduler
 
 	// debug is used if a writer is supplied for Client.Config.Debugger.
 	debug *log.Logger
@@ -72,17 +132,39 @@ type Config struct {
 	// Port is the port that will be used during server connection. This only
 	// has an affect during the dial process.
 	Port int
-	// Password is the server password used to authenticate. This only has an
-	// affect during the dial process.
+	// ServerPass is the server password used to authenticate, which is sent
+	// with PASS before registration. This only has an affect during the dial
+	// process.
+	ServerPass string
+	// Password is the server password, if ServerPass is empty.
+	//
+	// Deprecated: use ServerPass instead.
 	Password string
+	// WebIRC allows gateways (e.g. web clients) to pass along the real host
+	// and IP of the user they are connecting on behalf of. See WebIRC for
+	// more information. This only has an affect during the dial process.
+	WebIRC WebIRC
 	// Nick is an rfc-valid nickname used during connection. This only has an
-	// affect during the dial process.
+	// affect during the dial process. Use Client.SetNick to change it at
+	// runtime.
 	Nick string
+	// AltNicks are alternative nicknames which are tried (in order) if Nick
+	// is already in use during registration. Once exhausted, the client
+	// falls back to HandleNickCollide. This only has an affect during the
+	// dial process.
+	AltNicks []string
 	// User is the username/ident to use on connect. Ignored if an identd
 	// server is used. This only has an affect during the dial process.
 	User string
+	// Identd enables the built-in ident (RFC 1413) responder, which answers
+	// the ident lookup of the server with Config.User (unless Identd.Ident
+	// is set) while connecting. It's started just before dialing, and
+	// stopped once registered. Connect fails if it's unable to listen. This
+	// only has an affect during the dial process.
+	Identd *Identd
 	// Name is the "realname" that's used during connection. This only has an
-	// affect during the dial process.
+	// affect during the dial process. Use Client.SetName to change it at
+	// runtime.
 	Name string
 	// Proxy is a proxy based address, used during the dial process when
 	// connecting to the server. This only has an affect during the dial
@@ -96,12 +178,49 @@ type Config struct {
 	//    customProxy://example.com:8000
 	//
 	Proxy string
+	// WebSocketURL is an optional ws:// or wss:// url, which when set, makes
+	// the client speak IRC framed over WebSocket (e.g. to a webircgateway
+	// deployment), rather than a raw TCP connection. Each text frame carries
+	// a single IRC line. Server and Port are ignored for dialing when this
+	// is used, and wss:// urls use TLSConfig if supplied. This only has an
+	// affect during the dial process.
+	WebSocketURL string
+	// WebSocketHeader are additional HTTP headers which are sent during the
+	// WebSocket handshake, e.g. "Origin", or "Authorization" headers that
+	// the gateway may require. This only has an affect during the dial
+	// process.
+	WebSocketHeader http.Header
+	// Dialer is an optional, user supplied transport which is used to make
+	// the connection to the server (or the proxy, if Proxy is also set). If
+	// SSL is enabled, the TLS session is established on top of the
+	// connection returned by the dialer. Bind is ignored if a Dialer is
+	// supplied. This only has an affect during the dial process.
+	Dialer Dialer
 	// Bind is used to bind to a specific host or ip during the dial process
 	// when connecting to the server. This can be a hostname, however it must
 	// resolve to an IPv4/IPv6 address bindable on your system. Otherwise,
 	// you can simply use a IPv4/IPv6 address directly. This only has an
 	// affect during the dial process.
 	Bind string
+	// DialTimeout is the timeout of each connection attempt. Unless a Dialer
+	// is supplied, every address the server resolves to is tried,
+	// alternating between IPv6 and IPv4 ("Happy Eyeballs"), so a broken
+	// address family or an unreachable address doesn't hold up connecting.
+	// Defaults to 5 seconds. This only has an affect during the dial
+	// process.
+	DialTimeout time.Duration
+	// SRV looks up the _ircs._tcp (with SSL) or _irc._tcp SRV records of
+	// Server, and connects to the servers they list (in order of priority
+	// and weight) rather than to Server and Port, which are used if there
+	// are no records. TLS certificates are still verified against (and SNI
+	// sent for) Server, not the hosts listed in the records: the records
+	// aren't authenticated, so anyone able to spoof them could otherwise
+	// send us to a server they have a valid certificate for (see RFC 6125,
+	// section 6.2.1). Servers reached through SRV records must have a
+	// certificate valid for Server, as is the case on most networks. Set
+	// TLSConfig to verify certificates otherwise. This only has an affect
+	// during the dial process.
+	SRV bool
 	// SSL allows dialing via TLS. See TLSConfig to set your own TLS
 	// configuration (e.g. to not force hostname checking). This only has an
 	// affect during the dial process.
@@ -110,19 +229,87 @@ type Config struct {
 	// socket creation to the server. SSL must be enabled for this to be used.
 	// This only has an affect during the dial process.
 	TLSConfig *tls.Config
+	// Redirects is the maximum amount of times the redirect of a server to
+	// another server (RPL_REDIR, e.g. when the server is full) is followed
+	// in a row, before registration is left to fail. Server and Port are
+	// updated to the server redirected to (see Client.Server), and
+	// SERVER_REDIRECTED is dispatched. Redirects aren't followed by default,
+	// or with WebSocketURL.
+	Redirects int
 	// Retries is the number of times the client will attempt to reconnect
 	// to the server after the last disconnect.
 	Retries int
 	// AllowFlood allows the client to bypass the rate limit of outbound
-	// messages.
+	// messages. Use Client.SetAllowFlood to change it at runtime.
 	AllowFlood bool
+	// RateBurst is the amount of messages which can be sent at once before
+	// outbound messages are rate limited. Defaults to 5, which along with
+	// the default RateInterval stays within the flood limits of most
+	// networks (e.g. Charybdis, InspIRCd and UnrealIRCd based networks).
+	// Control messages (PONG, QUIT, CAP and AUTHENTICATE) are never rate
+	// limited.
+	RateBurst int
+	// RateInterval is the sustained rate at which outbound messages are sent
+	// once the burst has been used up (one message per RateInterval).
+	// Defaults to 2s. Use Client.SetRateLimit to change the rate limit at
+	// runtime.
+	RateInterval time.Duration
+	// SendQueueSize is the amount of events which can be queued to be sent
+	// in each priority lane (see Client.QueueLen), before SendOverflow
+	// applies. Only used by New. Defaults to 25.
+	SendQueueSize int
+	// SendOverflow is what happens to events sent while their queue is
+	// full, e.g. when messages are sent faster than the rate limit allows.
+	// Defaults to OverflowBlock. Control messages (PONG, QUIT, CAP and
+	// AUTHENTICATE) always block, as the connection depends on them.
+	SendOverflow OverflowPolicy
+	// ReceiveQueueSize is the amount of events which can be received from
+	// the server, and be waiting to be handled, before reading from the
+	// server blocks (which lets the server know to slow down). Only used by
+	// New. Defaults to 25.
+	ReceiveQueueSize int
+	// ReadBufferSize is the size of the buffer messages from the server are
+	// read into, in bytes. Defaults to 4096.
+	ReadBufferSize int
+	// StrictParsing parses the messages received from the server exactly
+	// as specified (see ParseEventStrict), rather than repairing malformed
+	// messages where possible. Messages which are invalid are dropped, and
+	// logged to the debug log.
+	StrictParsing bool
+	// LazyTags defers parsing the tags of the messages received from the
+	// server until they're needed, which saves allocations on servers which
+	// tag most messages (e.g. with server-time or account-tag). Received
+	// events then only have Event.RawTags set, and Event.Tags is nil until
+	// Event.LoadTags is called. Use Event.Tag to look up single tags.
+	LazyTags bool
 	// Debug is an optional, user supplied location to log the raw lines
 	// sent from the server, or other useful debug logs. Defaults to
 	// ioutil.Discard. For quick debugging, this could be set to os.Stdout.
+	// Use Client.SetDebug to change it at runtime.
 	Debug io.Writer
 	// Out is used to print out a prettified version of certain, important
 	// events, ignoring ones that are not important.
 	Out io.Writer
+	// ChatLog enables the built-in chat logger, which writes the messages
+	// of each channel and query to a log file of its own. Quits and
+	// nickname changes are only logged to channels when tracking is
+	// enabled. See ChatLog for more info.
+	ChatLog *ChatLog
+	// Redact are matchers of events which contain sensitive data (e.g.
+	// credentials for a custom service), in addition to the events which are
+	// known to (see Event.Redacted). Matching events, sent or received, are
+	// marked as sensitive (see Event.Sensitive), and are redacted in the
+	// debug log. See Client.Redact to redact events in your own logging.
+	Redact []RedactMatcher
+	// ParallelDispatch is the amount of workers which handle events, if
+	// events for different targets (channels, or users messaging us) should
+	// be handled concurrently, so one slow handler on a busy channel
+	// doesn't delay the traffic of every other channel. Events for the same
+	// target are still handled in the order they were received, and events
+	// without a target (e.g. NICK, QUIT or numerics) are handled once all
+	// events before them have been. Defaults to 0, handling events one at
+	// a time.
+	ParallelDispatch int
 	// RecoverFunc is called when a handler throws a panic. If RecoverFunc is
 	// set, the panic will be considered recovered, otherwise the client will
 	// panic. Set this to DefaultRecoverHandler if you don't want the client
@@ -130,15 +317,92 @@ type Config struct {
 	// DefaultRecoverHandler will log the panic to Debug or os.Stdout if
 	// Debug is unset.
 	RecoverFunc func(c *Client, e *HandlerError)
+	// Tracer, if set, traces each dispatch of an incoming event, each of
+	// its handlers, and sending events, which are traced as part of a
+	// given trace with Client.SendContext. See the gircotel package for
+	// tracing with OpenTelemetry. If nil, tracing is disabled, at no cost.
+	Tracer Tracer
+	// SASL contains the necessary authentication data to authenticate
+	// with SASL. See the documentation for SASLMech for what is currently
+	// supported. If the server doesn't support the mechanism, registration
+	// continues unauthenticated. If authentication fails, the connection is
+	// aborted, and an ErrSASLFailed is passed to HandleError. SASL requires
+	// tracking to be enabled, as it is negotiated with the "sasl" IRCv3
+	// capability. Authentication is repeated on every reconnect, and when
+	// the server (re-)advertises sasl after registration (see cap-notify)
+	// while we aren't logged in. See AUTHENTICATED.
+	SASL SASLMech
+	// NickServ identifies with NickServ once connected, for networks which
+	// don't support SASL. See NickServ.
+	NickServ *NickServ
+	// ServiceAuth authenticates with the services bot of networks which
+	// support neither SASL nor NickServ (e.g. QuakeNet's Q), once
+	// connected. See QAuth, XAuth and AuthServ.
+	ServiceAuth ServiceAuth
+	// HideHost sets usermode +x once logged in with ServiceAuth, which
+	// hides our host on networks such as QuakeNet, Undernet and GameSurge.
+	HideHost bool
+	// ServerNotices are the parsers of server notices (sent to operators,
+	// depending on their snomask), which are tried in order, dispatching
+	// SNOTICE (or SERVER_BAN_ADDED and SERVER_BAN_REMOVED, for server ban
+	// notices) for the first one which supports a notice. Defaults to
+	// DefaultServerNotices, which your own parsers can be appended to. Set
+	// to an empty slice to disable parsing server notices.
+	ServerNotices []ServerNoticeParser
+	// NickRegain configures regaining Config.Nick, if it was in use when we
+	// registered. Enabled by default. See NickRegain.
+	NickRegain NickRegain
+	// AutoJoin are the channels which are joined once connected (see
+	// CONNECTED), including after reconnecting, with the key we last joined
+	// them with (if any). Use Client.SetAutoJoin to change them at runtime.
+	AutoJoin []string
+	// RejoinOnKick, if set, rejoins channels automatically after being
+	// kicked from them. See Rejoin.
+	RejoinOnKick *Rejoin
+	// Backfill, if set, requests the messages of channels which were missed
+	// while disconnected, once they're rejoined after reconnecting. See
+	// Backfill.
+	Backfill *Backfill
+	// FloodGuard, if set, detects users flooding us or the channels we're
+	// in, and acts on them (e.g. ignoring or kicking them). See FloodGuard.
+	FloodGuard *FloodGuard
 	// SupportedCaps are the IRCv3 capabilities you would like the client to
 	// support. Only use this if DisableTracking and DisableCapTracking are
 	// not enabled, otherwise you will need to handle CAP negotiation yourself.
 	// The keys value gets passed to the server if supported.
 	SupportedCaps map[string][]string
+	// Caps controls which IRCv3 capabilities are negotiated with the server,
+	// in addition to those the client supports by default. See CapPolicy.
+	Caps CapPolicy
+	// TwitchMode adapts the client to Twitch chat (irc.chat.twitch.tv),
+	// which only partly implements IRC: the Twitch capabilities (see
+	// TwitchCapMembership, TwitchCapTags and TwitchCapCommands) are
+	// negotiated, WHO and MODE aren't sent after joining channels (Twitch
+	// doesn't support them), and the Twitch tags are tracked onto
+	// User.Extras.Twitch and Channel.RoomState. Use DecodeTwitchUserNotice
+	// and similar to handle the Twitch specific commands. Authenticate by
+	// setting ServerPass to "oauth:" followed by your token.
+	TwitchMode bool
 	// Version is the application version information that will be used in
 	// response to a CTCP VERSION, if default CTCP replies have not been
 	// overwritten or a VERSION handler was already supplied.
 	Version string
+	// Source is the reply to CTCP SOURCE queries, like Version. Defaults to
+	// the location of girc's source code.
+	Source string
+	// CTCPReply, if set, is called before each reply of the default CTCP
+	// responders (e.g. VERSION, SOURCE or TIME), and returns the reply
+	// text to send instead, or false to send the default reply.
+	CTCPReply func(ctcp CTCPEvent) (reply string, ok bool)
+	// DisableCTCP are the CTCP commands (e.g. CTCP_VERSION) which the
+	// default responders should not reply to, e.g. for privacy. Use
+	// CTCP_ERRMSG to not reply to unknown queries, or "*" to disable all
+	// automatic replies. Handlers added with CTCP.Register are unaffected.
+	DisableCTCP []string
+	// CTCPLimits limits how often the default CTCP responders reply, so
+	// users flooding us with CTCP queries can't get us disconnected for
+	// flooding. See CTCPLimits.
+	CTCPLimits CTCPLimits
 	// ReconnectDelay is the a duration of time to delay before attempting a
 	// reconnection. Defaults to 10s (minimum of 5s). This is ignored if
 	// Reconnect() is called directly.
@@ -149,6 +413,64 @@ type Config struct {
 	// Client.Lag() if you want to determine the delay between the server
 	// and the client.
 	PingDelay time.Duration
+	// PingTimeout is how long the client waits for a PONG in response to a
+	// keep-alive ping, before considering the connection dropped. When this
+	// happens, ErrPingTimeout (which wraps ErrTimedOut) is passed to
+	// HandleError, and the client attempts to reconnect (see Retries).
+	// Defaults to 60s.
+	PingTimeout time.Duration
+	// WhoisCacheTTL is how long the result of Commands.Whois is cached on
+	// the tracked user, during which further WHOIS queries for the same user
+	// return the cached result, rather than querying the server. The cache
+	// is only used for users in one of our channels. Disabled if 0.
+	WhoisCacheTTL time.Duration
+	// WhoRefreshInterval is how often a WHO query is sent for each tracked
+	// channel, to keep the away status of users up to date (see User.Away)
+	// on servers which don't support the away-notify capability. Channels
+	// are queried one at a time, spread out over the interval. Disabled if
+	// 0.
+	WhoRefreshInterval time.Duration
+	// MaxChannelUsers is the maximum amount of users which are tracked in a
+	// single channel, and MaxUsers the maximum amount of users tracked
+	// across all channels (counting a user once for each channel). Once
+	// exceeded, the least recently active users (see User.LastActive) are
+	// removed from state, until they are seen again. This keeps the memory
+	// usage of long-running clients on large networks bounded. See
+	// Client.StateStats. No limit if 0.
+	MaxChannelUsers int
+	MaxUsers        int
+	// UserTTL is how long users are tracked after they were last active,
+	// before they are removed from state (until they are seen again).
+	// Disabled if 0.
+	UserTTL time.Duration
+	// Bot marks us as a bot once connected, using the bot user mode, on
+	// servers which advertise it (see ISupport.Bot). Servers then let other
+	// users know that we're a bot (see User.IsBot).
+	Bot bool
+	// IgnoreBots ignores messages from users which are marked as bots,
+	// which is useful to prevent bots from triggering each other. Like with
+	// Client.Ignores, these messages are only passed to internal handlers.
+	IgnoreBots bool
+	// SelfMessages passes our own messages (PRIVMSG, NOTICE and TAGMSG from
+	// ourselves, see Event.IsSelf), which are sent back to us with the
+	// echo-message or znc.in/self-message capabilities, to user handlers as
+	// SELF_MESSAGE instead, so they aren't mistaken for those of others.
+	// Internal handlers still receive them as usual.
+	SelfMessages bool
+	// AcceptInvites is called when we're invited to a channel. If it returns
+	// true, the channel is joined. Use this to only accept invites from
+	// trusted users, or to certain channels. Invites are not accepted if
+	// nil. See Client.PendingInvites.
+	AcceptInvites func(invite Invite) bool
+	// HandleDCCOffer is called when a user offers us a file with DCC SEND.
+	// Use DCC.Accept to receive the file (in a separate goroutine, as
+	// HandleDCCOffer shouldn't block). Offers are ignored if nil.
+	HandleDCCOffer func(offer *DCCOffer)
+	// CollectBatches are IRCv3 batch types (e.g. "netsplit") whose events
+	// are collected, and dispatched as a single BATCH_COMPLETE event once
+	// the batch is closed, rather than being dispatched individually. See
+	// Batch. Chathistory and multiline batches are always collected.
+	CollectBatches []string
 	// HandleError if supplied, is called when one is disconnected from the
 	// server, with a given error.
 	HandleError func(error)
@@ -157,22 +479,70 @@ type Config struct {
 	// for highly embedded scripts with single purposes.
 	disableTracking bool
 	// HandleNickCollide when set, allows the client to handle nick collisions
-	// in a custom way. If unset, the client will attempt to append a
-	// underscore to the end of the nickname, in order to bypass using
-	// an invalid nickname. For example, if "test" is already in use, or is
-	// blocked by the network/a service, the client will try and use "test_",
-	// then it will attempt "test__", "test___", and so on.
+	// during registration in a custom way, once AltNicks have been
+	// exhausted. If unset, the client will attempt to append a underscore to
+	// the end of the nickname, in order to bypass using an invalid nickname.
+	// For example, if "test" is already in use, or is blocked by the
+	// network/a service, the client will try and use "test_", then it will
+	// attempt "test__", "test___", and so on.
 	HandleNickCollide func(oldNick string) (newNick string)
 }
 
+// WebIRC is useful when a user connects through an indirect method, such as
+// a web client or other gateway, as it lets the server know the real
+// hostname and IP of the user, rather than those of the gateway. The
+// server must be configured to trust the gateway (using the password). See
+// https://ircv3.net/specs/extensions/webirc for more information.
+type WebIRC struct {
+	// Password that authenticates the WEBIRC command from this client. If
+	// empty, WEBIRC isn't sent.
+	Password string
+	// Gateway or client type requesting spoof (e.g. "cgiirc" or "kiwiirc").
+	Gateway string
+	// Hostname of the user.
+	Hostname string
+	// IP address of the user.
+	IP string
+	// Secure is true if the user is connected to the gateway using TLS, so
+	// the server can treat them as a secure connection.
+	Secure bool
+}
+
+// Params returns the arguments for the WEBIRC command.
+func (w WebIRC) Params() []string {
+	params := []string{w.Password, w.Gateway, w.Hostname, w.IP}
+
+	if w.Secure {
+		params = append(params, "secure")
+	}
+
+	return params
+}
+
+// serverPass returns the server password, falling back to the deprecated
+// Password field.
+func (conf Config) serverPass() string {
+	if conf.ServerPass != "" {
+		return conf.ServerPass
+	}
+
+	return conf.Password
+}
+
 // isValid checks some basic settings to ensure the config is valid.
 func (conf Config) isValid() error {
-	if conf.Server == "" {
-		return errors.New("invalid server specified")
-	}
+	if conf.WebSocketURL != "" {
+		if _, _, err := parseWebSocketURL(conf.WebSocketURL); err != nil {
+			return err
+		}
+	} else {
+		if conf.Server == "" {
+			return errors.New("invalid server specified")
+		}
 
-	if conf.Port < 21 || conf.Port > 65535 {
-		return errors.New("invalid port (21-65535)")
+		if conf.Port < 21 || conf.Port > 65535 {
+			return errors.New("invalid port (21-65535)")
+		}
 	}
 
 	if !IsValidNick(conf.Nick) || !IsValidUser(conf.User) {
@@ -202,13 +572,25 @@ func (e *ErrInvalidTarget) Error() string { return "invalid target: " + e.Target
 func New(config Config) *Client {
 	c := &Client{
 		Config:   config,
-		rx:       make(chan *Event, 25),
-		tx:       make(chan *Event, 25),
+		rx:       make(chan *Event, queueSize(config.ReceiveQueueSize, rxBufferSize)),
 		CTCP:     newCTCP(),
 		initTime: time.Now(),
 	}
 
+	for i := 0; i < len(c.tx); i++ {
+		c.tx[i] = make(chan *Event, queueSize(config.SendQueueSize, txBufferSize))
+	}
+
 	c.Commands = &Commands{c: c}
+	c.Ignores = newIgnores(c.casemapping)
+	c.Monitor = newMonitor(c)
+	c.Typing = newTyping(c)
+	c.DCC = newDCC(c)
+	c.Plugins = newPlugins(c)
+
+	if c.Config.ChatLog != nil {
+		c.chatlog = newChatLogger(*c.Config.ChatLog)
+	}
 
 	if c.Config.PingDelay < (20 * time.Second) {
 		c.Config.PingDelay = 20 * time.Second
@@ -216,6 +598,22 @@ func New(config Config) *Client {
 		c.Config.PingDelay = 600 * time.Second
 	}
 
+	if c.Config.ReconnectDelay < (5 * time.Second) {
+		c.Config.ReconnectDelay = 5 * time.Second
+	}
+
+	if c.Config.PingTimeout <= 0 {
+		c.Config.PingTimeout = 60 * time.Second
+	}
+
+	if c.Config.RateBurst < 1 {
+		c.Config.RateBurst = defaultRateBurst
+	}
+
+	if c.Config.RateInterval <= 0 {
+		c.Config.RateInterval = defaultRateInterval
+	}
+
 	if c.Config.Debug == nil {
 		c.debug = log.New(ioutil.Discard, "", 0)
 	} else {
@@ -227,7 +625,7 @@ func New(config Config) *Client {
 	c.Handlers = newCaller(c.debug)
 
 	// Give ourselves a new state.
-	c.state = newState()
+	c.state = newState(c.Config)
 
 	// Register builtin handlers.
 	c.registerBuiltins()
@@ -240,10 +638,7 @@ func New(config Config) *Client {
 
 // String returns a brief description of the current client state.
 func (c *Client) String() string {
-	var connected bool
-	if c.conn != nil {
-		connected = c.conn.connected
-	}
+	connected := c.IsConnected()
 
 	return fmt.Sprintf(
 		"<Client init:%q handlers:%d connected:%t reconnecting:%t tries:%d>",
@@ -256,13 +651,6 @@ func (c *Client) String() string {
 func (c *Client) cleanup(all bool) {
 	c.cmux.Lock()
 
-	// Close any connections they have open.
-	if c.conn != nil {
-		c.conn.Close()
-	}
-
-	c.flushTx()
-
 	if c.closeRead != nil {
 		c.closeRead()
 	}
@@ -272,6 +660,21 @@ func (c *Client) cleanup(all bool) {
 	if c.closeExec != nil {
 		c.closeExec()
 	}
+	if c.closePing != nil {
+		c.closePing()
+	}
+
+	c.jobs.stopAll()
+
+	// Close any connections they have open. This is done after the read
+	// loop has been told to stop, so it doesn't consider the closed socket
+	// as an unexpected disconnect.
+	if c.conn != nil {
+		c.conn.Close()
+	}
+
+	c.flushTx()
+	c.stopIdentd()
 
 	if all {
 		if c.closeLoop != nil {
@@ -292,6 +695,20 @@ func (c *Client) quit(sendMessage bool) {
 	c.cleanup(false)
 }
 
+// abort is used when the connection can't continue (e.g. registration
+// failed during SASL authentication). It disconnects from the server without
+// attempting to reconnect, and passes err to Config.HandleError.
+func (c *Client) abort(err error) {
+	c.debug.Printf("aborting connection: %s", err)
+
+	c.cleanup(false)
+	c.RunHandlers(&Event{Command: DISCONNECTED, Params: []string{err.Error()}, Trailing: c.Server()})
+
+	if c.Config.HandleError != nil {
+		c.Config.HandleError(err)
+	}
+}
+
 // Quit disconnects from the server.
 func (c *Client) Quit() {
 	c.quit(true)
@@ -303,6 +720,97 @@ func (c *Client) QuitWithMessage(message string) {
 	c.quit(false)
 }
 
+// ErrQuitInProgress is returned from QuitGraceful() if it has already been
+// called, and hasn't returned yet.
+var ErrQuitInProgress = errors.New("quit already in progress")
+
+// QuitGraceful disconnects from the server with a given message, without
+// losing any queued events. New commands and messages are no longer
+// accepted, and any which are already queued are sent (respecting rate
+// limits) before the QUIT. It then waits for the server to close the
+// connection (or to send ERROR), or for ctx to be done, before closing the
+// socket. If ctx is done first, ctx.Err() is returned, and any remaining
+// queued events are lost.
+func (c *Client) QuitGraceful(ctx context.Context, message string) error {
+	if !c.IsConnected() {
+		return ErrNotConnected
+	}
+
+	c.qmux.Lock()
+	if c.quitDone != nil {
+		c.qmux.Unlock()
+		return ErrQuitInProgress
+	}
+	done := make(chan struct{})
+	c.quitDone = done
+	c.qmux.Unlock()
+
+	defer func() {
+		c.qmux.Lock()
+		c.quitDone = nil
+		c.qmux.Unlock()
+	}()
+
+	err := c.waitTxDrained(ctx)
+	if err == nil {
+		c.write(&Event{Command: QUIT, Trailing: message})
+
+		select {
+		case <-done:
+		case <-ctx.Done():
+			err = ctx.Err()
+		}
+	}
+
+	c.quit(false)
+
+	return err
+}
+
+// waitTxDrained waits until all queued events have been sent to the server,
+// or ctx is done.
+func (c *Client) waitTxDrained(ctx context.Context) error {
+	tick := time.NewTicker(50 * time.Millisecond)
+	defer tick.Stop()
+
+	for c.QueueLen() > 0 {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-tick.C:
+		}
+	}
+
+	return nil
+}
+
+// isQuitting returns true if QuitGraceful() is in progress.
+func (c *Client) isQuitting() bool {
+	c.qmux.Lock()
+	defer c.qmux.Unlock()
+
+	return c.quitDone != nil
+}
+
+// quitComplete lets QuitGraceful() (if in progress) know that the server has
+// closed the connection. Returns true if QuitGraceful() is in progress.
+func (c *Client) quitComplete() bool {
+	c.qmux.Lock()
+	defer c.qmux.Unlock()
+
+	if c.quitDone == nil {
+		return false
+	}
+
+	select {
+	case <-c.quitDone:
+	default:
+		close(c.quitDone)
+	}
+
+	return true
+}
+
 // Stop exits the clients main loop and any other goroutines created by
 // the client itself. This does not include handlers, as they will run for
 // any incoming events prior to when Stop() or Quit() was called, until the
@@ -325,6 +833,11 @@ func (c *Client) Loop() {
 }
 
 func (c *Client) execLoop(ctx context.Context) {
+	if c.Config.ParallelDispatch > 0 {
+		c.parallelExecLoop(ctx)
+		return
+	}
+
 	for {
 		select {
 		case event := <-c.rx:
@@ -348,9 +861,15 @@ func (c *Client) DisableTracking() {
 	c.registerBuiltins()
 }
 
-// Server returns the string representation of host+port pair for net.Conn.
+// Server returns the string representation of host+port pair for net.Conn,
+// or the WebSocket url if Config.WebSocketURL is being used.
 func (c *Client) Server() string {
-	return fmt.Sprintf("%s:%d", c.Config.Server, c.Config.Port)
+	if c.Config.WebSocketURL != "" {
+		return c.Config.WebSocketURL
+	}
+
+	server, port := c.configServer()
+	return fmt.Sprintf("%s:%d", server, port)
 }
 
 // Lifetime returns the amount of time that has passed since the client was
@@ -388,9 +907,46 @@ func (c *Client) IsConnected() (connected bool) {
 	if c.conn == nil {
 		return false
 	}
+
+	c.conn.mu.RLock()
+	defer c.conn.mu.RUnlock()
+
 	return c.conn.connected
 }
 
+// isSelf returns true if source is us, comparing nicknames with the
+// casemapping of the server.
+func (c *Client) isSelf(source *Source) bool {
+	if source == nil || c.Config.disableTracking {
+		return false
+	}
+
+	nick := c.GetNick()
+
+	c.state.mu.RLock()
+	defer c.state.mu.RUnlock()
+
+	return c.state.toLower(source.Name) == c.state.toLower(nick)
+}
+
+// casemapping returns the casemapping of the server (see ToLower).
+func (c *Client) casemapping() string {
+	c.state.mu.RLock()
+	defer c.state.mu.RUnlock()
+
+	return c.state.isupport.Casemapping
+}
+
+// toLower converts a nickname or channel name to lowercase, using the
+// casemapping of the server. Must not be used while holding state.mu (use
+// state.toLower instead).
+func (c *Client) toLower(name string) string {
+	c.state.mu.RLock()
+	defer c.state.mu.RUnlock()
+
+	return c.state.toLower(name)
+}
+
 // GetNick returns the current nickname of the active connection. Panics if
 // tracking is disabled.
 func (c *Client) GetNick() string {
@@ -399,7 +955,7 @@ func (c *Client) GetNick() string {
 
 	c.state.mu.RLock()
 	if c.state.nick == "" {
-		nick = c.Config.Nick
+		nick = c.configNick()
 	} else {
 		nick = c.state.nick
 	}
@@ -417,7 +973,7 @@ func (c *Client) GetIdent() string {
 
 	c.state.mu.RLock()
 	if c.state.ident == "" {
-		ident = c.Config.Name
+		ident = c.configName()
 	} else {
 		ident = c.state.ident
 	}
@@ -435,7 +991,7 @@ func (c *Client) GetHost() string {
 
 	c.state.mu.RLock()
 	if c.state.host == "" {
-		host = c.Config.Name
+		host = c.configName()
 	} else {
 		host = c.state.host
 	}
@@ -444,13 +1000,26 @@ func (c *Client) GetHost() string {
 	return host
 }
 
+// GetAccount returns the account we're logged in as (e.g. with SASL), or an
+// empty string if we aren't logged in. See AUTHENTICATED. Panics if
+// tracking is disabled.
+func (c *Client) GetAccount() string {
+	c.panicIfNotTracking()
+
+	c.state.mu.RLock()
+	account := c.state.account
+	c.state.mu.RUnlock()
+
+	return account
+}
+
 // Channels returns the active list of channels that the client is in.
 // Panics if tracking is disabled.
 func (c *Client) Channels() []string {
 	c.panicIfNotTracking()
-	channels := make([]string, len(c.state.channels))
 
 	c.state.mu.RLock()
+	channels := make([]string, len(c.state.channels))
 	var i int
 	for channel := range c.state.channels {
 		channels[i] = channel
@@ -465,8 +1034,8 @@ func (c *Client) Channels() []string {
 // channel is nil. Panics if tracking is disabled.
 func (c *Client) Lookup(name string) *Channel {
 	c.panicIfNotTracking()
-	c.state.mu.Lock()
-	defer c.state.mu.Unlock()
+	c.state.mu.RLock()
+	defer c.state.mu.RUnlock()
 
 	channel := c.state.lookupChannel(name)
 	if channel == nil {
@@ -476,13 +1045,110 @@ func (c *Client) Lookup(name string) *Channel {
 	return channel.Copy()
 }
 
+// LookupChannel looks up a given channel in state, returning a copy of the
+// channel (which is safe to use concurrently with the client), and if the
+// channel was found. Panics if tracking is disabled.
+func (c *Client) LookupChannel(name string) (channel *Channel, ok bool) {
+	channel = c.Lookup(name)
+
+	return channel, channel != nil
+}
+
+// LookupUser looks up a given user across all tracked channels, returning a
+// copy of the user (which is safe to use concurrently with the client), and
+// if the user was found. As permissions are per-channel, Perms is always
+// empty -- use LookupChannel and Channel.Lookup instead to check those.
+// Panics if tracking is disabled.
+func (c *Client) LookupUser(nick string) (user *User, ok bool) {
+	c.panicIfNotTracking()
+	c.state.mu.RLock()
+	defer c.state.mu.RUnlock()
+
+	user = mergeUsers(c.state.usersByNick(nick))
+
+	return user, user != nil
+}
+
+// LookupUsersByAccount returns a copy of all tracked users which are logged
+// in as the given account (see User.Extras.Account), as with LookupUser.
+// Panics if tracking is disabled.
+func (c *Client) LookupUsersByAccount(account string) []*User {
+	c.panicIfNotTracking()
+	c.state.mu.RLock()
+	defer c.state.mu.RUnlock()
+
+	if account == "" {
+		return nil
+	}
+
+	// Users are tracked per channel, so group them by nickname first.
+	var nicks []string
+	byNick := make(map[string][]*User)
+	for _, user := range c.state.lookupUsers("account", account) {
+		nick := c.state.toLower(user.Nick)
+		if byNick[nick] == nil {
+			nicks = append(nicks, nick)
+		}
+		byNick[nick] = append(byNick[nick], user)
+	}
+	sort.Strings(nicks)
+
+	users := make([]*User, 0, len(nicks))
+	for _, nick := range nicks {
+		users = append(users, mergeUsers(byNick[nick]))
+	}
+
+	return users
+}
+
+// mergeUsers returns a copy of the first user, merged with the information
+// known about the same user in other channels. Perms are left empty.
+func mergeUsers(users []*User) (user *User) {
+	if len(users) == 0 {
+		return nil
+	}
+
+	user = users[0].Copy()
+	user.Perms = UserPerms{}
+
+	// Users are tracked per channel, and some information may only be known
+	// in some channels, so merge what is known.
+	for i := 1; i < len(users); i++ {
+		if active := users[i].lastActive(); active.After(user.LastActive) {
+			user.LastActive = active
+		}
+		if users[i].FirstSeen.Before(user.FirstSeen) {
+			user.FirstSeen = users[i].FirstSeen
+		}
+
+		mergeString(&user.Ident, users[i].Ident)
+		mergeString(&user.Host, users[i].Host)
+		mergeString(&user.Extras.Name, users[i].Extras.Name)
+		mergeString(&user.Extras.Account, users[i].Extras.Account)
+		mergeString(&user.Extras.Twitch.DisplayName, users[i].Extras.Twitch.DisplayName)
+		mergeString(&user.Extras.Twitch.Color, users[i].Extras.Twitch.Color)
+		mergeString(&user.AwayMsg, users[i].AwayMsg)
+		user.Away = user.Away || users[i].Away
+		user.IsBot = user.IsBot || users[i].IsBot
+	}
+
+	return user
+}
+
+// mergeString sets dst to src, if dst is empty.
+func mergeString(dst *string, src string) {
+	if *dst == "" {
+		*dst = src
+	}
+}
+
 // IsInChannel returns true if the client is in channel. Panics if tracking
 // is disabled.
 func (c *Client) IsInChannel(channel string) bool {
 	c.panicIfNotTracking()
 
 	c.state.mu.RLock()
-	_, inChannel := c.state.channels[strings.ToLower(channel)]
+	inChannel := c.state.lookupChannel(channel) != nil
 	c.state.mu.RUnlock()
 
 	return inChannel
@@ -494,23 +1160,52 @@ func (c *Client) IsInChannel(channel string) bool {
 //
 //   nickLen, success := GetServerOption("MAXNICKLEN")
 //
+// See ISupport for the parsed settings.
 func (c *Client) GetServerOption(key string) (result string, ok bool) {
 	c.panicIfNotTracking()
 
-	c.state.mu.Lock()
-	result, ok = c.state.serverOptions[key]
-	c.state.mu.Unlock()
+	c.state.mu.RLock()
+	result, ok = c.state.isupport.Get(key)
+	c.state.mu.RUnlock()
 
 	return result, ok
 }
 
+// ISupport returns the parsed ISUPPORT (or RPL_PROTOCTL) settings which the
+// server supplied during connection. Settings which haven't been supplied
+// by the server contain the common defaults. Will panic if used when
+// tracking has been disabled.
+func (c *Client) ISupport() ISupport {
+	c.panicIfNotTracking()
+
+	c.state.mu.RLock()
+	defer c.state.mu.RUnlock()
+
+	return c.state.isupport.Copy()
+}
+
+// HasCapability checks if the client connection has the given IRCv3
+// capability enabled (acknowledged by the server). Will panic if used when
+// tracking has been disabled.
+func (c *Client) HasCapability(name string) (has bool) {
+	c.panicIfNotTracking()
+
+	c.state.mu.RLock()
+	has = c.state.hasCap(name)
+	c.state.mu.RUnlock()
+
+	return has
+}
+
 // ServerName returns the server host/name that the server itself identifies
 // as. May be empty if the server does not support RPL_MYINFO. Will panic if
 // used when tracking has been disabled.
 func (c *Client) ServerName() (name string) {
 	c.panicIfNotTracking()
 
-	name, _ = c.GetServerOption("SERVER")
+	c.state.mu.RLock()
+	name = c.state.serverName
+	c.state.mu.RUnlock()
 
 	return name
 }
@@ -521,7 +1216,9 @@ func (c *Client) ServerName() (name string) {
 func (c *Client) NetworkName() (name string) {
 	c.panicIfNotTracking()
 
-	name, _ = c.GetServerOption("NETWORK")
+	c.state.mu.RLock()
+	name = c.state.isupport.Network
+	c.state.mu.RUnlock()
 
 	return name
 }
@@ -533,7 +1230,9 @@ func (c *Client) NetworkName() (name string) {
 func (c *Client) ServerVersion() (version string) {
 	c.panicIfNotTracking()
 
-	version, _ = c.GetServerOption("VERSION")
+	c.state.mu.RLock()
+	version = c.state.serverVersion
+	c.state.mu.RUnlock()
 
 	return version
 }
@@ -551,15 +1250,61 @@ func (c *Client) ServerMOTD() (motd string) {
 }
 
 // Lag is the latency between the server and the client. This is measured by
-// determining the difference in time between when we ping the server, and
-// when we receive a pong.
+// determining the difference in time between when we last pinged the server,
+// and when we received a pong. Returns 0 if not connected, or if no pings
+// have been answered yet.
 func (c *Client) Lag() time.Duration {
-	delta := c.conn.lastPong.Sub(c.conn.lastPing)
-	if delta < 0 {
+	if c.conn == nil {
 		return 0
 	}
 
-	return delta
+	c.conn.mu.RLock()
+	defer c.conn.mu.RUnlock()
+
+	if c.conn.lastLag < 0 {
+		return 0
+	}
+
+	return c.conn.lastLag
+}
+
+// LagHistory returns the round-trip time of recent pings to the server
+// (up to the last 32), oldest first. Returns nil if not connected.
+func (c *Client) LagHistory() []time.Duration {
+	if c.conn == nil {
+		return nil
+	}
+
+	c.conn.mu.RLock()
+	defer c.conn.mu.RUnlock()
+
+	return c.conn.lagHistory.list()
+}
+
+// LagStats returns the minimum, average and maximum round-trip time of the
+// pings returned by LagHistory(). All are 0 if there are no measurements
+// yet.
+func (c *Client) LagStats() (min, avg, max time.Duration) {
+	history := c.LagHistory()
+	if len(history) == 0 {
+		return 0, 0, 0
+	}
+
+	var total time.Duration
+	min = history[0]
+
+	for i := 0; i < len(history); i++ {
+		if history[i] < min {
+			min = history[i]
+		}
+		if history[i] > max {
+			max = history[i]
+		}
+
+		total += history[i]
+	}
+
+	return min, total / time.Duration(len(history)), max
 }
 
 // panicIfNotTracking will throw a panic when it's called, and tracking is
@@ -0,0 +1,392 @@
+// Copyright (c) Liam Stanley <me@liamstanley.io>. All rights reserved. Use
+// of this source code is governed by the MIT license that can be found in
+// the LICENSE file.
+
+package girc
+
+import (
+	"bytes"
+	"strconv"
+	"strings"
+)
+
+// Formatting control codes. See https://modern.ircdocs.horse/formatting.
+const (
+	fmtBold          byte = 0x02
+	fmtColor         byte = 0x03
+	fmtHexColor      byte = 0x04
+	fmtReset         byte = 0x0F
+	fmtMonospace     byte = 0x11
+	fmtReverse       byte = 0x16
+	fmtItalic        byte = 0x1D
+	fmtStrikethrough byte = 0x1E
+	fmtUnderline     byte = 0x1F
+)
+
+// Color is a mIRC color, from 0 to 98, or ColorDefault. Colors 0 to 15 are
+// the common colors (which clients may render with their own palette), and
+// 16 to 98 are the extended colors, which have a fixed RGB value.
+type Color int
+
+// The common colors.
+const (
+	ColorWhite      Color = iota // white
+	ColorBlack                   // black
+	ColorBlue                    // blue (navy)
+	ColorGreen                   // green
+	ColorRed                     // red
+	ColorBrown                   // brown (maroon)
+	ColorPurple                  // purple
+	ColorOrange                  // orange (olive)
+	ColorYellow                  // yellow
+	ColorLightGreen              // light green (lime)
+	ColorTeal                    // teal
+	ColorCyan                    // cyan
+	ColorLightBlue               // light blue (royal)
+	ColorPink                    // pink (light purple, fuchsia)
+	ColorGrey                    // grey
+	ColorLightGrey               // light grey (silver)
+)
+
+// The extended colors, which are named by their number.
+const (
+	Color16 Color = iota + 16
+	Color17
+	Color18
+	Color19
+	Color20
+	Color21
+	Color22
+	Color23
+	Color24
+	Color25
+	Color26
+	Color27
+	Color28
+	Color29
+	Color30
+	Color31
+	Color32
+	Color33
+	Color34
+	Color35
+	Color36
+	Color37
+	Color38
+	Color39
+	Color40
+	Color41
+	Color42
+	Color43
+	Color44
+	Color45
+	Color46
+	Color47
+	Color48
+	Color49
+	Color50
+	Color51
+	Color52
+	Color53
+	Color54
+	Color55
+	Color56
+	Color57
+	Color58
+	Color59
+	Color60
+	Color61
+	Color62
+	Color63
+	Color64
+	Color65
+	Color66
+	Color67
+	Color68
+	Color69
+	Color70
+	Color71
+	Color72
+	Color73
+	Color74
+	Color75
+	Color76
+	Color77
+	Color78
+	Color79
+	Color80
+	Color81
+	Color82
+	Color83
+	Color84
+	Color85
+	Color86
+	Color87
+	Color88
+	Color89
+	Color90
+	Color91
+	Color92
+	Color93
+	Color94
+	Color95
+	Color96
+	Color97
+	Color98
+)
+
+// ColorDefault resets the foreground or background to the default color of
+// the client.
+const ColorDefault Color = 99
+
+// colorRGB is the RGB value of each color, from the palette used by mIRC.
+var colorRGB = [...]uint32{
+	0xffffff, 0x000000, 0x00007f, 0x009300, 0xff0000, 0x7f0000,
+	0x9c009c, 0xfc7f00, 0xffff00, 0x00fc00, 0x009393, 0x00ffff,
+	0x0000fc, 0xff00ff, 0x7f7f7f, 0xd2d2d2, 0x470000, 0x472100,
+	0x474700, 0x324700, 0x004700, 0x00472c, 0x004747, 0x002747,
+	0x000047, 0x2e0047, 0x470047, 0x47002a, 0x740000, 0x743a00,
+	0x747400, 0x517400, 0x007400, 0x007449, 0x007474, 0x004074,
+	0x000074, 0x4b0074, 0x740074, 0x740045, 0xb50000, 0xb56300,
+	0xb5b500, 0x7db500, 0x00b500, 0x00b571, 0x00b5b5, 0x0063b5,
+	0x0000b5, 0x7500b5, 0xb500b5, 0xb5006b, 0xff0000, 0xff8c00,
+	0xffff00, 0xb2ff00, 0x00ff00, 0x00ffa0, 0x00ffff, 0x008cff,
+	0x0000ff, 0xa500ff, 0xff00ff, 0xff0098, 0xff5959, 0xffb459,
+	0xffff71, 0xcfff60, 0x6fff6f, 0x65ffc9, 0x6dffff, 0x59b4ff,
+	0x5959ff, 0xc459ff, 0xff66ff, 0xff59bc, 0xff9c9c, 0xffd39c,
+	0xffff9c, 0xe2ff9c, 0x9cff9c, 0x9cffdb, 0x9cffff, 0x9cd3ff,
+	0x9c9cff, 0xdc9cff, 0xff9cff, 0xff94d3, 0x000000, 0x131313,
+	0x282828, 0x363636, 0x4d4d4d, 0x656565, 0x818181, 0x9f9f9f,
+	0xbcbcbc, 0xe2e2e2, 0xffffff,
+}
+
+// String returns the color as the two digits used in color codes, e.g.
+// "04".
+func (c Color) String() string {
+	if c < 10 && c >= 0 {
+		return "0" + strconv.Itoa(int(c))
+	}
+
+	return strconv.Itoa(int(c))
+}
+
+// Valid returns true if the color is a color (0 to 98), or ColorDefault.
+func (c Color) Valid() bool {
+	return c >= 0 && c <= ColorDefault
+}
+
+// RGB returns the red, green and blue components of the color. ok is false
+// for ColorDefault (or invalid colors), which don't have a fixed value.
+func (c Color) RGB() (r, g, b uint8, ok bool) {
+	if c < 0 || int(c) >= len(colorRGB) {
+		return 0, 0, 0, false
+	}
+
+	rgb := colorRGB[c]
+	return uint8(rgb >> 16), uint8(rgb >> 8), uint8(rgb), true
+}
+
+// Code returns the control code which sets the foreground color to c, e.g.
+// "\x0304". See ColorCode to also set the background.
+func (c Color) Code() string {
+	return string(fmtColor) + c.String()
+}
+
+// ColorCode returns the control code which sets the foreground color to fg,
+// and the background color to bg.
+func ColorCode(fg, bg Color) string {
+	return string(fmtColor) + fg.String() + "," + bg.String()
+}
+
+// fmtExtraCodes are the placeholders supported by Format, in addition to the
+// codes of IRC clients which predate them.
+var fmtExtraCodes = []*ircFmtCode{
+	{aliases: []string{"u"}, val: string(fmtUnderline)},
+	{aliases: []string{"strikethrough", "strike"}, val: string(fmtStrikethrough)},
+	{aliases: []string{"monospace", "mono"}, val: string(fmtMonospace)},
+	{aliases: []string{"default"}, val: ColorDefault.Code()},
+}
+
+// fmtPlaceholders maps the placeholders supported by Format to their codes.
+var fmtPlaceholders = func() map[string]string {
+	m := make(map[string]string)
+	for _, list := range [][]*ircFmtCode{codes, fmtExtraCodes} {
+		for _, code := range list {
+			for _, alias := range code.aliases {
+				m[alias] = code.val
+			}
+		}
+	}
+
+	return m
+}()
+
+// parseFmtColor parses a color of a Format placeholder, which is either a
+// number (0 to 99) or a color name (e.g. "red").
+func parseFmtColor(name string) (Color, bool) {
+	if n, err := strconv.Atoi(name); err == nil {
+		return Color(n), len(name) <= 2 && Color(n).Valid()
+	}
+
+	code, ok := fmtPlaceholders[name]
+	if !ok || len(code) != 3 || code[0] != fmtColor {
+		return 0, false
+	}
+
+	n, _ := strconv.Atoi(code[1:])
+	return Color(n), true
+}
+
+// fmtPlaceholder returns the code of a Format placeholder (without braces).
+func fmtPlaceholder(name string) (string, bool) {
+	name = strings.ToLower(strings.TrimSpace(name))
+
+	if i := strings.IndexByte(name, 0x2C); i > -1 { // ,
+		fg, fok := parseFmtColor(strings.TrimSpace(name[:i]))
+		bg, bok := parseFmtColor(strings.TrimSpace(name[i+1:]))
+		if !fok || !bok {
+			return "", false
+		}
+
+		return ColorCode(fg, bg), true
+	}
+
+	if color, ok := parseFmtColor(name); ok {
+		return color.Code(), true
+	}
+
+	code, ok := fmtPlaceholders[name]
+	return code, ok
+}
+
+// Fmt expands placeholders like "{red}" and "{b}" into the formatting codes
+// used by IRC clients, the same as Format. For example:
+//
+//	client.Commands.Message("#channel", girc.Fmt("{red}{b}alert{r}: disk full"))
+func Fmt(text string) string {
+	return Format(text)
+}
+
+// replaceFmt replaces the placeholders of Format in text with their codes,
+// or strips them if strip is true.
+func replaceFmt(text string, strip bool) string {
+	if strings.IndexByte(text, 0x7B) < 0 { // {
+		return text
+	}
+
+	var out bytes.Buffer
+	for {
+		i := strings.IndexByte(text, 0x7B) // {
+		if i < 0 {
+			break
+		}
+
+		j := strings.IndexByte(text[i:], 0x7D) // }
+		if j < 0 {
+			break
+		}
+
+		out.WriteString(text[:i])
+
+		if code, ok := fmtPlaceholder(text[i+1 : i+j]); ok {
+			if !strip {
+				out.WriteString(code)
+			}
+			text = text[i+j+1:]
+			continue
+		}
+
+		// Not a placeholder, but it may contain one (e.g. "{{red}").
+		out.WriteByte(text[i])
+		text = text[i+1:]
+	}
+
+	out.WriteString(text)
+	return out.String()
+}
+
+// TrimFmt strips all formatting codes (bold, colors including their
+// numbers, etc) from text, e.g. to log messages as plain text. Unlike
+// StripRaw, it leaves CTCP delimiters intact.
+func TrimFmt(text string) string {
+	var out bytes.Buffer
+	walkFmt(text, func(_ fmtState, run string) {
+		out.WriteString(run)
+	})
+
+	return out.String()
+}
+
+// skipFmtColor returns the index after the "fg[,bg]" colors of a color code
+// starting at i, where each color is up to max characters matching valid.
+func skipFmtColor(text string, i, max int, valid func(byte) bool) int {
+	n := skipFmtChars(text, i, max, valid)
+	if n == i {
+		return i
+	}
+
+	// The comma is only part of the code if a background color follows it.
+	if n < len(text) && text[n] == 0x2C { // ,
+		if bg := skipFmtChars(text, n+1, max, valid); bg > n+1 {
+			return bg
+		}
+	}
+
+	return n
+}
+
+// skipFmtChars returns the index after up to max characters from i which
+// match valid.
+func skipFmtChars(text string, i, max int, valid func(byte) bool) int {
+	end := i
+	for end < len(text) && end-i < max && valid(text[end]) {
+		end++
+	}
+
+	return end
+}
+
+// isFmtDigit returns true if b is a decimal digit.
+func isFmtDigit(b byte) bool {
+	return b >= 0x30 && b <= 0x39 // 0-9
+}
+
+// isFmtHexDigit returns true if b is a hexadecimal digit.
+func isFmtHexDigit(b byte) bool {
+	return isFmtDigit(b) || (b >= 0x41 && b <= 0x46) || (b >= 0x61 && b <= 0x66) // A-F, a-f
+}
+
+// maxFmtPrefix is the longest prefix which wrapFormatted adds to lines to
+// continue formatting: every toggle, and both colors.
+const maxFmtPrefix = 6 + len("\x0300,00")
+
+// wrapFormatted is much like wrapMessage, however formatting (e.g. bold or
+// colors) which is still in effect at the end of a line is continued on the
+// next line, as clients reset formatting at the end of each message.
+func wrapFormatted(text string, max int) (lines []string) {
+	if strings.IndexFunc(text, isFmtCode) < 0 {
+		return wrapMessage(text, max)
+	}
+
+	lines = wrapMessage(text, max-maxFmtPrefix)
+	state := defaultFmtState
+	for i, line := range lines {
+		prefix := fmtTransition(defaultFmtState, state)
+		state = walkFmtFrom(line, state, nil)
+		lines[i] = prefix + line
+	}
+
+	return lines
+}
+
+// isFmtCode returns true if r is a formatting code (excluding the CTCP
+// delimiter).
+func isFmtCode(r rune) bool {
+	switch r {
+	case rune(fmtBold), rune(fmtColor), rune(fmtHexColor), rune(fmtReset), rune(fmtMonospace),
+		rune(fmtReverse), rune(fmtItalic), rune(fmtStrikethrough), rune(fmtUnderline):
+		return true
+	}
+
+	return false
+}
@@ -0,0 +1,122 @@
+// Copyright (c) Liam Stanley <me@liamstanley.io>. All rights reserved. Use
+// of this source code is governed by the MIT license that can be found in
+// the LICENSE file.
+
+package girc
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestFmt(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		want string
+	}{
+		{name: "named", text: "{red}{b}alert{r}: disk full", want: "\x0304\x02alert\x0f: disk full"},
+		{name: "case", text: "{RED}test", want: "\x0304test"},
+		{name: "extras", text: "{u}{strike}{mono}x", want: "\x1f\x1e\x11x"},
+		{name: "number", text: "{4}a{04}b{52}c", want: "\x0304a\x0304b\x0352c"},
+		{name: "background", text: "{red,black}a{4, 99}b", want: "\x0304,01a\x0304,99b"},
+		{name: "default", text: "{default}x", want: "\x0399x"},
+		{name: "unknown", text: "{foo} {100} {red,foo} {}", want: "{foo} {100} {red,foo} {}"},
+		{name: "nested", text: "{{red}}", want: "{\x0304}"},
+		{name: "unclosed", text: "{red", want: "{red"},
+		{name: "nothing", text: "this is a test.", want: "this is a test."},
+	}
+
+	for _, tt := range tests {
+		if got := Fmt(tt.text); got != tt.want {
+			t.Errorf("%s: Fmt(%q) = %q, want %q", tt.name, tt.text, got, tt.want)
+		}
+	}
+}
+
+func TestTrimFmt(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		want string
+	}{
+		{name: "simple", text: "\x02bold\x02 \x1ditalic\x0f", want: "bold italic"},
+		{name: "color", text: "\x0304red\x03 \x034,12both\x03", want: "red both"},
+		{name: "digits", text: "\x03041234", want: "1234"},
+		{name: "comma", text: "\x0304,test", want: ",test"},
+		{name: "hex", text: "\x04FF0000,00ff00text\x04", want: "text"},
+		{name: "ctcp", text: "\x01ACTION \x02waves\x02\x01", want: "\x01ACTION waves\x01"},
+		{name: "fmt", text: Fmt("{red,black}{b}alert{r}: disk full"), want: "alert: disk full"},
+		{name: "nothing", text: "this is a test.", want: "this is a test."},
+	}
+
+	for _, tt := range tests {
+		if got := TrimFmt(tt.text); got != tt.want {
+			t.Errorf("%s: TrimFmt(%q) = %q, want %q", tt.name, tt.text, got, tt.want)
+		}
+	}
+}
+
+func TestColor(t *testing.T) {
+	tests := []struct {
+		color   Color
+		str     string
+		r, g, b uint8
+		ok      bool
+	}{
+		{color: ColorWhite, str: "00", r: 0xff, g: 0xff, b: 0xff, ok: true},
+		{color: ColorRed, str: "04", r: 0xff, ok: true},
+		{color: Color16, str: "16", r: 0x47, ok: true},
+		{color: Color98, str: "98", r: 0xff, g: 0xff, b: 0xff, ok: true},
+		{color: ColorDefault, str: "99"},
+	}
+
+	for _, tt := range tests {
+		if got := tt.color.String(); got != tt.str {
+			t.Errorf("Color(%d).String() = %q, want %q", tt.color, got, tt.str)
+		}
+
+		r, g, b, ok := tt.color.RGB()
+		if r != tt.r || g != tt.g || b != tt.b || ok != tt.ok {
+			t.Errorf("Color(%d).RGB() = %x, %x, %x, %v, want %x, %x, %x, %v", tt.color, r, g, b, ok, tt.r, tt.g, tt.b, tt.ok)
+		}
+	}
+
+	if Color(100).Valid() || Color(-1).Valid() || !ColorDefault.Valid() {
+		t.Error("Color.Valid() returned unexpected results")
+	}
+}
+
+func TestWrapFormatted(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		max  int
+		want []string
+	}{
+		{name: "plain", text: "aaa bbb ccc", max: 7, want: []string{"aaa bbb", "ccc"}},
+		{
+			name: "bold", text: "\x02" + strings.Repeat("a ", 10) + "b\x02 c", max: 12 + maxFmtPrefix,
+			want: []string{"\x02a a a a a a", "\x02a a a a b\x02 c"},
+		},
+		{
+			name: "color", text: "\x0304,01red text\x03 then plain text", max: 15 + maxFmtPrefix,
+			want: []string{"\x0304,01red text\x03", "then plain text"},
+		},
+		{
+			name: "color split", text: "\x0304,01red text\x03", max: 10 + maxFmtPrefix,
+			want: []string{"\x0304,01red", "\x0304,01text\x03"},
+		},
+		{
+			name: "newline", text: "\x1dline 1\nline 2\x1d", max: 100,
+			want: []string{"\x1dline 1", "\x1dline 2\x1d"},
+		},
+	}
+
+	for _, tt := range tests {
+		if got := wrapFormatted(tt.text, tt.max); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: wrapFormatted(%q, %d) = %q, want %q", tt.name, tt.text, tt.max, got, tt.want)
+		}
+	}
+}
@@ -7,6 +7,7 @@ package girc
 import (
 	"errors"
 	"fmt"
+	"strings"
 )
 
 // Commands holds a large list of useful methods to interact with the server,
@@ -93,12 +94,13 @@ func (cmd *Commands) PartMessage(channel, message string) error {
 // SendCTCP sends a CTCP request to target. Note that this method uses
 // PRIVMSG specifically.
 func (cmd *Commands) SendCTCP(target, ctcpType, message string) error {
-	out := encodeCTCPRaw(ctcpType, message)
+	out := EncodeCTCPRaw(ctcpType, message)
 	if out == "" {
 		return errors.New("invalid CTCP")
 	}
 
-	return cmd.Message(target, out)
+	_, err := cmd.Message(target, out)
+	return err
 }
 
 // SendCTCPf sends a CTCP request to target using a specific format. Note that
@@ -116,27 +118,32 @@ func (cmd *Commands) SendCTCPReplyf(target, ctcpType, format string, a ...interf
 // SendCTCPReply sends a CTCP response to target. Note that this method uses
 // NOTICE specifically.
 func (cmd *Commands) SendCTCPReply(target, ctcpType, message string) error {
-	out := encodeCTCPRaw(ctcpType, message)
+	out := EncodeCTCPRaw(ctcpType, message)
 	if out == "" {
 		return errors.New("invalid CTCP")
 	}
 
-	return cmd.Notice(target, out)
+	_, err := cmd.Notice(target, out)
+	return err
 }
 
 // Message sends a PRIVMSG to target (either channel, service, or user).
-func (cmd *Commands) Message(target, message string) error {
-	if !IsValidNick(target) && !IsValidChannel(target) {
-		return &ErrInvalidTarget{Target: target}
-	}
-
-	cmd.c.Send(&Event{Command: PRIVMSG, Params: []string{target}, Trailing: message})
-	return nil
+// Messages which are too long to be relayed by the server in a single line,
+// or which contain newlines, are split across multiple messages (on word
+// boundaries where possible), with any formatting (see Fmt) continued on
+// each of them. Returns the amount of messages sent, which may be less than
+// needed if an error is returned.
+//
+// If the server supports CPRIVMSG (e.g. on Undernet), messages to users we
+// share a channel with, in which we're opped or voiced, are sent with it,
+// so they aren't subject to target change limits.
+func (cmd *Commands) Message(target, message string) (int, error) {
+	return cmd.sendWrapped(PRIVMSG, target, message, nil, nil)
 }
 
 // Messagef sends a formated PRIVMSG to target (either channel, service, or
-// user).
-func (cmd *Commands) Messagef(target, format string, a ...interface{}) error {
+// user). See Message for how long messages are handled.
+func (cmd *Commands) Messagef(target, format string, a ...interface{}) (int, error) {
 	return cmd.Message(target, fmt.Sprintf(format, a...))
 }
 
@@ -161,20 +168,223 @@ func (cmd *Commands) Actionf(target, format string, a ...interface{}) error {
 	return cmd.Action(target, fmt.Sprintf(format, a...))
 }
 
-// Notice sends a NOTICE to target (either channel, service, or user).
-func (cmd *Commands) Notice(target, message string) error {
+// Notice sends a NOTICE to target (either channel, service, or user). Long
+// messages are split like with Message, and CNOTICE is used like CPRIVMSG
+// is with Message. Returns the amount of notices sent.
+func (cmd *Commands) Notice(target, message string) (int, error) {
+	return cmd.sendWrapped(NOTICE, target, message, nil, nil)
+}
+
+// Noticef sends a formated NOTICE to target (either channel, service, or
+// user). See Notice for how long messages are handled.
+func (cmd *Commands) Noticef(target, format string, a ...interface{}) (int, error) {
+	return cmd.Notice(target, fmt.Sprintf(format, a...))
+}
+
+// MessageMany sends a PRIVMSG to each of targets (channels or users), with
+// as many targets per line as the server allows (see ISupport.TargMax),
+// rather than a line per target, which servers throttle. If the server
+// doesn't advertise a limit, a line per target is sent. Long messages are
+// split like with Message, and duplicate targets are skipped. Returns the
+// amount of messages sent.
+func (cmd *Commands) MessageMany(targets []string, message string) (int, error) {
+	return cmd.sendMany(PRIVMSG, targets, message)
+}
+
+// NoticeMany sends a NOTICE to each of targets (channels or users), like
+// MessageMany. Returns the amount of notices sent.
+func (cmd *Commands) NoticeMany(targets []string, message string) (int, error) {
+	return cmd.sendMany(NOTICE, targets, message)
+}
+
+// sendMany sends a PRIVMSG or NOTICE to each of targets, with as many
+// targets per line as TARGMAX and the line length allow. Duplicate targets
+// are compared with the casemapping of the server.
+func (cmd *Commands) sendMany(command string, targets []string, message string) (int, error) {
+	casemapping := cmd.c.casemapping()
+	seen := make(map[string]bool, len(targets))
+	unique := make([]string, 0, len(targets))
+
+	// The message is relayed to each target separately, so it must fit
+	// for all of them.
+	width := maxLength
+	for _, target := range targets {
+		if !IsValidNick(target) && !IsValidChannel(target) {
+			return 0, &ErrInvalidTarget{Target: target}
+		}
+
+		key := ToLower(casemapping, target)
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		unique = append(unique, target)
+
+		if max := cmd.c.maxMessageLen(command, target); max < width {
+			width = max
+		}
+	}
+
+	lines := []string{message}
+	if len(message) == 0 || message[0] != ctcpDelim {
+		lines = wrapFormatted(message, width)
+	}
+
+	var longest int
+	for _, line := range lines {
+		if len(line) > longest {
+			longest = len(line)
+		}
+	}
+
+	cmd.c.state.mu.RLock()
+	limit, ok := cmd.c.state.isupport.TargMax[command]
+	cmd.c.state.mu.RUnlock()
+
+	if !ok {
+		limit = 1
+	}
+
+	// "COMMAND target,target :text"
+	max := maxLength - len(command) - 1 - 2 - longest
+
+	for _, target := range unique {
+		cmd.c.Typing.sent(target)
+	}
+
+	var sent int
+	for len(unique) > 0 {
+		n, size := 1, len(unique[0])
+		for n < len(unique) && (limit == 0 || n < limit) && size+1+len(unique[n]) <= max {
+			size += 1 + len(unique[n])
+			n++
+		}
+
+		batch := strings.Join(unique[:n], ",")
+		unique = unique[n:]
+
+		for _, line := range lines {
+			cmd.c.Send(&Event{Command: command, Params: []string{batch}, Trailing: line})
+			sent++
+		}
+	}
+
+	return sent, nil
+}
+
+// sendWrapped sends a PRIVMSG or NOTICE to target, split into as many lines
+// as needed, each with the given tags. CTCP messages are never split, as the
+// CTCP delimiters would be lost. If fn is supplied, it is called once each
+// line has been echoed back by the server. See Client.SendEcho. Returns the
+// amount of lines sent, also if sending one of them failed.
+func (cmd *Commands) sendWrapped(command, target, message string, tags Tags, fn func(EchoConfirmation)) (int, error) {
 	if !IsValidNick(target) && !IsValidChannel(target) {
-		return &ErrInvalidTarget{Target: target}
+		return 0, &ErrInvalidTarget{Target: target}
 	}
 
-	cmd.c.Send(&Event{Command: NOTICE, Params: []string{target}, Trailing: message})
-	return nil
+	lines := []string{message}
+	if len(message) == 0 || message[0] != ctcpDelim {
+		lines = wrapFormatted(message, cmd.c.maxMessageLen(command, target))
+	}
+
+	// Client.SendEcho only confirms PRIVMSG and NOTICE.
+	var via string
+	if fn == nil {
+		via = cmd.c.viaChannel(command, target)
+	}
+
+	cmd.c.Typing.sent(target)
+
+	for i, line := range lines {
+		event := &Event{Command: command, Params: []string{target}, Trailing: line}
+		if via != "" {
+			event.Command, event.Params = "C"+command, []string{target, via}
+		}
+		if tags != nil {
+			event.Tags = Tags{}
+			for k, v := range tags {
+				event.Tags[k] = v
+			}
+		}
+
+		if fn == nil {
+			cmd.c.Send(event)
+			continue
+		}
+
+		if err := cmd.c.SendEcho(event, fn); err != nil {
+			return i, err
+		}
+	}
+
+	return len(lines), nil
 }
 
-// Noticef sends a formated NOTICE to target (either channel, service, or
-// user).
-func (cmd *Commands) Noticef(target, format string, a ...interface{}) error {
-	return cmd.Notice(target, fmt.Sprintf(format, a...))
+// viaChannel returns the channel to send a PRIVMSG or NOTICE (command) to
+// the user target through with CPRIVMSG or CNOTICE, if the server supports
+// them: a channel we share with target, in which we're opped or voiced.
+// Unlike regular messages, these aren't subject to the target change limits
+// of networks such as Undernet. Empty if the message should be sent as is.
+func (c *Client) viaChannel(command, target string) string {
+	if c.Config.disableTracking || IsValidChannel(target) {
+		return ""
+	}
+
+	c.state.mu.RLock()
+	defer c.state.mu.RUnlock()
+
+	if _, ok := c.state.isupport.Get("C" + command); !ok {
+		return ""
+	}
+
+	self, nick := c.state.toLower(c.state.nick), c.state.toLower(target)
+
+	// Prefer the same channel each time, rather than a random one.
+	var via string
+	for key, channel := range c.state.channels {
+		user := channel.users[self]
+		if user == nil || !(user.Perms.IsAdmin() || user.Perms.HalfOp || user.Perms.Voice) {
+			continue
+		}
+
+		if _, ok := channel.users[nick]; ok && (via == "" || key < c.state.toLower(via)) {
+			via = channel.Name
+		}
+	}
+
+	return via
+}
+
+const (
+	// maxIdentLen and maxHostLen are the lengths assumed for our ident and
+	// host when calculating how long messages can be, until they are known
+	// (e.g. from joining a channel).
+	maxIdentLen = 10
+	maxHostLen  = 63
+)
+
+// maxMessageLen returns the maximum length of the text of a PRIVMSG or
+// NOTICE to target, once the server has prefixed it with our hostmask when
+// relaying it to others.
+func (c *Client) maxMessageLen(command, target string) int {
+	c.state.mu.RLock()
+	nick, ident, host := c.state.nick, c.state.ident, c.state.host
+	c.state.mu.RUnlock()
+
+	if nick == "" {
+		nick = c.configNick()
+	}
+
+	identLen, hostLen := len(ident), len(host)
+	if identLen == 0 {
+		identLen = maxIdentLen
+	}
+	if hostLen == 0 {
+		hostLen = maxHostLen
+	}
+
+	// ":nick!ident@host COMMAND target :text"
+	return maxLength - (1 + len(nick) + 1 + identLen + 1 + hostLen + 1) - (len(command) + 1 + len(target) + 2)
 }
 
 // SendRaw sends a raw string back to the server, without carriage returns
@@ -204,24 +414,16 @@ func (cmd *Commands) Topic(channel, message string) {
 // Who sends a WHO query to the server, which will attempt WHOX by default.
 // See http://faerion.sourceforge.net/doc/irc/whox.var for more details. This
 // sends "%tcuhnr,2" per default. Do not use "1" as this will conflict with
-// girc's builtin tracking functionality.
+// girc's builtin tracking functionality. Use Whox to choose the fields, and
+// wait for the replies.
 func (cmd *Commands) Who(target string) error {
 	if !IsValidNick(target) && !IsValidChannel(target) && !IsValidUser(target) {
 		return &ErrInvalidTarget{Target: target}
 	}
 
-	cmd.c.Send(&Event{Command: WHO, Params: []string{target, "%tcuhnr,2"}})
-	return nil
-}
-
-// Whois sends a WHOIS query to the server, targeted at a specific user.
-// as WHOIS is a bit slower, you may want to use WHO for brief user info.
-func (cmd *Commands) Whois(nick string) error {
-	if !IsValidNick(nick) {
-		return &ErrInvalidTarget{Target: nick}
-	}
-
-	cmd.c.Send(&Event{Command: WHOIS, Params: []string{nick}})
+	cmd.c.Send(&Event{Command: WHO, Params: whoxParams(target, whoxUserToken, []WhoxField{
+		WhoxChannel, WhoxIdent, WhoxHost, WhoxNick, WhoxRealname,
+	})})
 	return nil
 }
 
@@ -296,55 +498,20 @@ func (cmd *Commands) Back() {
 	cmd.c.Send(&Event{Command: AWAY})
 }
 
-// List sends a LIST query to the server, which will list channels and topics.
-// Supports multiple channels at once, in hopes it will reduce extensive
-// LIST queries to the server. Supply no channels to run a list against the
-// entire server (warning, that may mean LOTS of channels!)
-func (cmd *Commands) List(channels ...string) error {
-	if len(channels) == 0 {
-		cmd.c.Send(&Event{Command: LIST})
-		return nil
-	}
-
-	// We can LIST multiple channels at once, however we need to ensure that
-	// we are not exceeding the line length. (see maxLength)
-	max := maxLength - len(JOIN) - 1
-
-	var buffer string
-
-	for i := 0; i < len(channels); i++ {
-		if !IsValidChannel(channels[i]) {
-			return &ErrInvalidTarget{Target: channels[i]}
-		}
-
-		if len(buffer+","+channels[i]) > max {
-			cmd.c.Send(&Event{Command: LIST, Params: []string{buffer}})
-			buffer = ""
-			continue
-		}
-
-		if len(buffer) == 0 {
-			buffer = channels[i]
-		} else {
-			buffer += "," + channels[i]
-		}
-
-		if i == len(channels)-1 {
-			cmd.c.Send(&Event{Command: LIST, Params: []string{buffer}})
-			return nil
-		}
-	}
-
-	return nil
-}
-
-// Whowas sends a WHOWAS query to the server. amount is the amount of results
-// you want back.
-func (cmd *Commands) Whowas(nick string, amount int) error {
-	if !IsValidNick(nick) {
-		return &ErrInvalidTarget{Target: nick}
+// ErrSetNameUnsupported is returned by Commands.SetName when the server
+// doesn't support the setname capability.
+var ErrSetNameUnsupported = errors.New("server does not support setname")
+
+// SetName changes our "realname" (see Config.Name) for the current
+// connection. This requires the setname capability, otherwise
+// ErrSetNameUnsupported is returned. Once the server has accepted the
+// change, it is reflected in state (see User.Extras.Name). If the server
+// rejects it, e.g. because it is too long, it sends a FAIL SETNAME reply.
+func (cmd *Commands) SetName(realname string) error {
+	if !cmd.c.HasCapability("setname") {
+		return ErrSetNameUnsupported
 	}
 
-	cmd.c.Send(&Event{Command: WHOWAS, Params: []string{nick, string(amount)}})
+	cmd.c.Send(&Event{Command: CAP_SETNAME, Trailing: realname, EmptyTrailing: true})
 	return nil
 }
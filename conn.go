@@ -11,6 +11,9 @@ import (
 	"fmt"
 	"net"
 	"net/url"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 
 	"golang.org/x/net/context"
@@ -31,21 +34,86 @@ type ircConn struct {
 
 	// lastWrite is used ot keep track of when we last wrote to the server.
 	lastWrite time.Time
-	// writeDelay is used to keep track of rate limiting of events sent to
-	// the server.
-	writeDelay time.Duration
+	// limiter is used to keep track of rate limiting of events sent to the
+	// server.
+	limiter *tokenBucket
+	// strict is true if messages are parsed with ParseEventStrict. See
+	// Config.StrictParsing.
+	strict bool
+	// lazyTags is true if the tags of messages are parsed lazily. See
+	// Config.LazyTags.
+	lazyTags bool
 
 	// connected is true if we're actively connected to a server.
 	connected bool
 	// connTime is the time at which the client has connected to a server.
 	connTime *time.Time
 
+	// mu protects connected, as well as the ping related fields.
+	mu sync.RWMutex
 	// lastPing is the last time that we pinged the server.
 	lastPing time.Time
 	// lastPong is the last successful time that we pinged the server and
 	// received a successful pong back.
-	lastPong  time.Time
-	pingDelay time.Duration
+	lastPong time.Time
+	// lastLag is the round-trip time of the last successful ping.
+	lastLag time.Duration
+	// lagHistory is the round-trip time of recent successful pings.
+	lagHistory lagRing
+}
+
+// lagHistorySize is the amount of round-trip measurements kept by
+// Client.LagHistory().
+const lagHistorySize = 32
+
+// lagRing is a fixed size ring buffer of round-trip measurements.
+type lagRing struct {
+	buf [lagHistorySize]time.Duration
+	// next is the index of buf which will be written next.
+	next int
+	// full is true once buf has wrapped around.
+	full bool
+}
+
+// add records a new measurement, overwriting the oldest if full.
+func (r *lagRing) add(lag time.Duration) {
+	r.buf[r.next] = lag
+	if r.next++; r.next == len(r.buf) {
+		r.next = 0
+		r.full = true
+	}
+}
+
+// list returns the measurements, oldest first.
+func (r *lagRing) list() []time.Duration {
+	if !r.full {
+		return append([]time.Duration(nil), r.buf[:r.next]...)
+	}
+
+	return append(append([]time.Duration(nil), r.buf[r.next:]...), r.buf[:r.next]...)
+}
+
+// Dialer is an interface implementation of net.Dialer. Use this if you would
+// like to supply your own transport (e.g. Tor, SSH tunnels, or pipes for
+// testing), which the client will use when connecting to the server.
+type Dialer interface {
+	// DialContext connects to the address on the named network. network
+	// will generally be "tcp", and address will be the "host:port" pair of
+	// the server being connected to. See net.Dialer.DialContext() for more
+	// information.
+	DialContext(ctx context.Context, network, address string) (net.Conn, error)
+}
+
+// forwardDialer allows a Dialer to be used as the forwarding dialer of a
+// proxy, which only supports the non-context Dial method.
+type forwardDialer struct {
+	Dialer
+}
+
+// Dial connects to the address on the named network, using the underlying
+// Dialer.
+func (d forwardDialer) Dial(network, address string) (net.Conn, error) {
+	return d.DialContext(context.Background(), network, address)
 }
 
 // newConn sets up and returns a new connection to the server. This includes
@@ -58,63 +126,115 @@ func newConn(conf Config, addr string) (*ircConn, error) {
 	var conn net.Conn
 	var err error
 
-	dialer := &net.Dialer{Timeout: 5 * time.Second}
-
-	if conf.Bind != "" {
-		var local *net.TCPAddr
-		local, err = net.ResolveTCPAddr("tcp", conf.Bind+":0")
+	if conf.WebSocketURL != "" {
+		conn, err = dialWebSocket(conf)
 		if err != nil {
-			return nil, fmt.Errorf("unable to resolve bind address %s: %s", conf.Bind, err)
+			return nil, err
 		}
+	} else {
+		conn, err = dial(conf, addr)
+		if err != nil {
+			return nil, err
+		}
+
+		if conf.SSL {
+			// Even if addr is a target of the SRV records of Config.Server,
+			// as those aren't authenticated. See Config.SRV.
+			var tlsConn net.Conn
+			tlsConn, err = tlsHandshake(conn, conf.TLSConfig, conf.Server, true)
+			if err != nil {
+				return nil, err
+			}
+
+			conn = tlsConn
+		}
+	}
+
+	ctime := time.Now()
 
-		dialer.LocalAddr = local
+	c := &ircConn{
+		sock:      conn,
+		connTime:  &ctime,
+		connected: true,
+		limiter:   newTokenBucket(conf.RateBurst, conf.RateInterval),
+		strict:    conf.StrictParsing,
+		lazyTags:  conf.LazyTags,
 	}
+	c.newReadWriter(conf.ReadBufferSize)
 
+	return c, nil
+}
+
+// dial makes the raw connection to addr, using the user supplied Dialer (or
+// a happyDialer which respects Config.Bind), through Config.Proxy if one
+// is supplied. With Config.SRV, the servers listed in the SRV records of
+// Config.Server are tried in order instead, if it has any.
+func dial(conf Config, addr string) (conn net.Conn, err error) {
+	dialer := conf.Dialer
+	if dialer == nil {
+		netDialer := &net.Dialer{Timeout: dialTimeout(conf)}
+
+		if conf.Bind != "" {
+			var local *net.TCPAddr
+			local, err = net.ResolveTCPAddr("tcp", conf.Bind+":0")
+			if err != nil {
+				return nil, fmt.Errorf("unable to resolve bind address %s: %s", conf.Bind, err)
+			}
+
+			netDialer.LocalAddr = local
+		}
+
+		dialer = &happyDialer{dialer: netDialer}
+	}
+
+	var proxyDialer proxy.Dialer
 	if conf.Proxy != "" {
 		var proxyURI *url.URL
-		var proxyDialer proxy.Dialer
 
 		proxyURI, err = url.Parse(conf.Proxy)
 		if err != nil {
 			return nil, fmt.Errorf("unable to use proxy %q: %s", conf.Proxy, err)
 		}
 
-		proxyDialer, err = proxy.FromURL(proxyURI, dialer)
+		proxyDialer, err = proxy.FromURL(proxyURI, forwardDialer{dialer})
 		if err != nil {
 			return nil, fmt.Errorf("unable to use proxy %q: %s", conf.Proxy, err)
 		}
+	}
 
-		conn, err = proxyDialer.Dial("tcp", addr)
-		if err != nil {
-			return nil, fmt.Errorf("unable to connect to proxy %q: %s", conf.Proxy, err)
+	addrs := []string{addr}
+	if conf.SRV {
+		var targets []string
+		if targets, err = srvAddrs(conf); err != nil {
+			return nil, fmt.Errorf("unable to connect to %q: %s", conf.Server, err)
 		}
-	} else {
-		conn, err = dialer.Dial("tcp", addr)
-		if err != nil {
-			return nil, fmt.Errorf("unable to connect to %q: %s", addr, err)
+
+		if len(targets) > 0 {
+			addrs = targets
 		}
 	}
 
-	if conf.SSL {
-		var tlsConn net.Conn
-		tlsConn, err = tlsHandshake(conn, conf.TLSConfig, conf.Server, true)
-		if err != nil {
-			return nil, err
-		}
+	for _, addr = range addrs {
+		if proxyDialer != nil {
+			conn, err = proxyDialer.Dial("tcp", addr)
+			if err != nil {
+				err = fmt.Errorf("unable to connect to proxy %q: %s", conf.Proxy, err)
+				continue
+			}
 
-		conn = tlsConn
-	}
+			return conn, nil
+		}
 
-	ctime := time.Now()
+		conn, err = dialer.DialContext(context.Background(), "tcp", addr)
+		if err != nil {
+			err = fmt.Errorf("unable to connect to %q: %s", addr, err)
+			continue
+		}
 
-	c := &ircConn{
-		sock:      conn,
-		connTime:  &ctime,
-		connected: true,
+		return conn, nil
 	}
-	c.newReadWriter()
 
-	return c, nil
+	return nil, err
 }
 
 func (c *ircConn) decode() (event *Event, err error) {
@@ -123,16 +243,23 @@ func (c *ircConn) decode() (event *Event, err error) {
 		return nil, err
 	}
 
-	event = ParseEvent(line)
+	event, err = parseEvent(line, c.strict, c.lazyTags)
+	if err != nil {
+		return nil, err
+	}
 	if event == nil {
 		return nil, fmt.Errorf("unable to parse incoming event: %s", event)
 	}
 
+	if event.Timestamp.IsZero() {
+		event.Timestamp = time.Now()
+	}
+
 	return event, nil
 }
 
 func (c *ircConn) encode(event *Event) error {
-	if _, err := c.io.Write(event.Bytes()); err != nil {
+	if _, err := event.WriteTo(c.io); err != nil {
 		return err
 	}
 	if _, err := c.io.Write(endline); err != nil {
@@ -142,8 +269,15 @@ func (c *ircConn) encode(event *Event) error {
 	return c.io.Flush()
 }
 
-func (c *ircConn) newReadWriter() {
-	c.io = bufio.NewReadWriter(bufio.NewReader(c.sock), bufio.NewWriter(c.sock))
+// newReadWriter sets up the buffered reader and writer of the socket. If
+// size is less than 1, the default buffer size is used (see
+// Config.ReadBufferSize).
+func (c *ircConn) newReadWriter(size int) {
+	if size < 1 {
+		size = defaultReadBufferSize
+	}
+
+	c.io = bufio.NewReadWriter(bufio.NewReaderSize(c.sock, size), bufio.NewWriter(c.sock))
 }
 
 func tlsHandshake(conn net.Conn, conf *tls.Config, server string, validate bool) (net.Conn, error) {
@@ -157,6 +291,10 @@ func tlsHandshake(conn net.Conn, conf *tls.Config, server string, validate bool)
 
 // Close closes the underlying socket.
 func (c *ircConn) Close() error {
+	c.mu.Lock()
+	c.connected = false
+	c.mu.Unlock()
+
 	return c.sock.Close()
 }
 
@@ -165,23 +303,72 @@ func (c *Client) Connect() error {
 	// Clean up any old running stuff.
 	c.cleanup(false)
 
+	// The nickname, realname, etc, may be changed at runtime (see
+	// Client.SetNick), so the connection uses a snapshot of the config.
+	c.confmu.Lock()
+	if c.Config.Name == "" {
+		c.Config.Name = c.Config.User
+	}
+	conf := c.Config
+	c.confmu.Unlock()
+
 	// We want to be the only one handling connects/disconnects right now.
 	c.cmux.Lock()
 
 	// Reset the state.
-	c.state = newState()
+	c.state = newState(conf)
+	c.batches.reset()
+	c.echoes.reset()
+	c.Typing.reset()
 
 	// Validate info, and actually make the connection.
 	c.debug.Printf("connecting to %s...", c.Server())
-	conn, err := newConn(c.Config, c.Server())
+	c.RunHandlers(&Event{Command: DIALING, Trailing: c.Server()})
+
+	// The server looks up our ident as soon as we're connected, so the
+	// identd must be listening before we dial.
+	if err := c.startIdentd(); err != nil {
+		c.cmux.Unlock()
+		return err
+	}
+
+	conn, err := newConn(conf, c.Server())
 	if err != nil {
+		c.stopIdentd()
 		c.cmux.Unlock()
 		return err
 	}
 
+	if c.identd != nil {
+		c.identd.expect(conn.sock)
+	}
+
+	// Clients in a pool share the rate limit of the server.
+	if c.limiter != nil {
+		conn.limiter = c.limiter
+	}
+
+	// Complete the TLS handshake now (rather than on the first write), so
+	// handshake errors are returned from Connect.
+	secure := strings.HasPrefix(conf.WebSocketURL, "wss://")
+	if tlsConn, ok := conn.sock.(*tls.Conn); ok {
+		if err = tlsConn.Handshake(); err != nil {
+			conn.Close()
+			c.stopIdentd()
+			c.cmux.Unlock()
+			return err
+		}
+
+		secure = true
+	}
+
 	c.conn = conn
 	c.cmux.Unlock()
 
+	if secure {
+		c.RunHandlers(&Event{Command: TLS_HANDSHAKE_DONE, Trailing: c.Server()})
+	}
+
 	// Start read loop to process messages from the server.
 	var rctx, ectx, sctx, pctx context.Context
 	rctx, c.closeRead = context.WithCancel(context.Background())
@@ -189,27 +376,32 @@ func (c *Client) Connect() error {
 	sctx, c.closeSend = context.WithCancel(context.Background())
 	pctx, c.closePing = context.WithCancel(context.Background())
 	go c.execLoop(ectx)
-	go c.readLoop(rctx)
-	go c.pingLoop(pctx)
-	go c.sendLoop(sctx)
+	go c.readLoop(rctx, conn)
+	go c.pingLoop(pctx, conn)
+	go c.Monitor.loop(pctx)
+	go c.whoRefreshLoop(pctx)
+	go c.evictLoop(pctx)
+	go c.regainLoop(pctx)
+	go c.sendLoop(sctx, conn)
 
 	// Send a virtual event allowing hooks for successful socket connection.
 	c.RunHandlers(&Event{Command: INITIALIZED, Trailing: c.Server()})
 
+	// WEBIRC must be sent before anything else related to registration.
+	if conf.WebIRC.Password != "" {
+		c.write(&Event{Command: WEBIRC, Params: conf.WebIRC.Params(), Sensitive: true})
+	}
+
 	// Passwords first.
-	if c.Config.Password != "" {
-		c.write(&Event{Command: PASS, Params: []string{c.Config.Password}})
+	if pass := conf.serverPass(); pass != "" {
+		c.write(&Event{Command: PASS, Params: []string{pass}, Sensitive: true})
 	}
 
 	// Then nickname.
-	c.write(&Event{Command: NICK, Params: []string{c.Config.Nick}})
+	c.write(&Event{Command: NICK, Params: []string{conf.Nick}})
 
 	// Then username and realname.
-	if c.Config.Name == "" {
-		c.Config.Name = c.Config.User
-	}
-
-	c.write(&Event{Command: USER, Params: []string{c.Config.User, "+iw", "*"}, Trailing: c.Config.Name})
+	c.write(&Event{Command: USER, Params: []string{conf.User, "+iw", "*"}, Trailing: conf.Name})
 
 	// List the IRCv3 capabilities, specifically with the max protocol we
 	// support.
@@ -217,7 +409,6 @@ func (c *Client) Connect() error {
 
 	// Consider the connection a success at this point.
 	c.tries = 0
-	c.reconnecting = false
 
 	return nil
 }
@@ -225,20 +416,24 @@ func (c *Client) Connect() error {
 // reconnect is the internal wrapper for reconnecting to the IRC server (if
 // requested.)
 func (c *Client) reconnect(remoteInvoked bool) (err error) {
+	// Multiple loops may fail at the same time (e.g. both reading and
+	// writing), so only let the first one reconnect.
+	c.cmux.Lock()
 	if c.reconnecting {
+		c.cmux.Unlock()
 		return nil
 	}
 	c.reconnecting = true
+	c.cmux.Unlock()
+
 	defer func() {
+		c.cmux.Lock()
 		c.reconnecting = false
+		c.cmux.Unlock()
 	}()
 
 	c.cleanup(false)
 
-	if c.Config.ReconnectDelay < (5 * time.Second) {
-		c.Config.ReconnectDelay = 5 * time.Second
-	}
-
 	if c.Config.Retries < 1 && !remoteInvoked {
 		return ErrDisconnected
 	}
@@ -247,12 +442,12 @@ func (c *Client) reconnect(remoteInvoked bool) (err error) {
 		// Delay so we're not slaughtering the server with a bunch of
 		// connections.
 		c.debug.Printf("reconnecting to %s in %s", c.Server(), c.Config.ReconnectDelay)
-		time.Sleep(c.Config.ReconnectDelay)
+		c.scheduleReconnect()
 	}
 
 	for err = c.Connect(); err != nil && c.tries < c.Config.Retries; c.tries++ {
 		c.debug.Printf("reconnecting to %s in %s (%d tries)", c.Server(), c.Config.ReconnectDelay, c.tries)
-		time.Sleep(c.Config.ReconnectDelay)
+		c.scheduleReconnect()
 	}
 
 	if err != nil {
@@ -263,6 +458,18 @@ func (c *Client) reconnect(remoteInvoked bool) (err error) {
 	return err
 }
 
+// scheduleReconnect lets handlers know that we're about to reconnect, and
+// waits for Config.ReconnectDelay.
+func (c *Client) scheduleReconnect() {
+	c.RunHandlers(&Event{
+		Command:  RECONNECT_SCHEDULED,
+		Params:   []string{c.Config.ReconnectDelay.String(), strconv.Itoa(c.tries + 1)},
+		Trailing: c.Server(),
+	})
+
+	time.Sleep(c.Config.ReconnectDelay)
+}
+
 // Reconnect checks to make sure we want to, and then attempts to reconnect
 // to the server. This will ignore the reconnect delay.
 func (c *Client) Reconnect() error {
@@ -270,8 +477,15 @@ func (c *Client) Reconnect() error {
 }
 
 func (c *Client) disconnectHandler(err error) {
+	// If we're gracefully quitting, the server closing the connection is
+	// expected, and QuitGraceful() takes care of the rest.
+	if c.quitComplete() {
+		return
+	}
+
 	if err != nil {
 		c.debug.Println("disconnecting due to error: " + err.Error())
+		c.RunHandlers(&Event{Command: DISCONNECTED, Params: []string{err.Error()}, Trailing: c.Server()})
 	}
 
 	rerr := c.reconnect(false)
@@ -288,8 +502,10 @@ func (c *Client) disconnectHandler(err error) {
 }
 
 // readLoop sets a timeout of 300 seconds, and then attempts to read from the
-// IRC server. If there is an error, it calls Reconnect.
-func (c *Client) readLoop(ctx context.Context) {
+// IRC server. If there is an error, it calls Reconnect. It reads from conn
+// rather than c.conn, which is replaced when reconnecting while the loop
+// may still be finishing up.
+func (c *Client) readLoop(ctx context.Context, conn *ircConn) {
 	var event *Event
 	var err error
 
@@ -299,8 +515,20 @@ func (c *Client) readLoop(ctx context.Context) {
 			return
 		default:
 			// c.conn.sock.SetDeadline(time.Now().Add(300 * time.Second))
-			event, err = c.conn.decode()
+			event, err = conn.decode()
+			if perr, ok := err.(*ErrParse); ok {
+				// Invalid messages are dropped in strict mode.
+				c.debug.Printf("dropping message: %s", perr)
+				continue
+			}
+
 			if err != nil {
+				// If we were intentionally closed (e.g. with Quit() or
+				// Stop()), don't attempt to reconnect.
+				if ctx.Err() != nil {
+					return
+				}
+
 				// Attempt a reconnect (if applicable). If it fails, send
 				// the error to c.Config.HandleError to be dealt with, if
 				// the handler exists.
@@ -308,6 +536,11 @@ func (c *Client) readLoop(ctx context.Context) {
 				return
 			}
 
+			if event.Command == ERROR {
+				// The server is about to close the connection.
+				c.quitComplete()
+			}
+
 			c.rx <- event
 		}
 	}
@@ -316,112 +549,526 @@ func (c *Client) readLoop(ctx context.Context) {
 // Send sends an event to the server. Use Client.RunHandlers() if you are
 // simply looking to trigger handlers with an event.
 func (c *Client) Send(event *Event) {
-	if !c.Config.AllowFlood {
-		<-time.After(c.conn.rate(event.Len()))
-	}
-
 	c.write(event)
 }
 
-// write is the lower level function to write an event. It does not have a
-// write-delay when sending events.
+// write is the lower level function to queue an event to be sent. Events are
+// sent in order of their priority (see eventPriority), and are rate limited
+// in sendLoop unless Config.AllowFlood is set. If the queue for the events
+// priority is full, Config.SendOverflow applies (blocking by default). See
+// Client.QueueLen() to apply backpressure.
+// Events (other than control traffic) are dropped while QuitGraceful() is
+// in progress.
 func (c *Client) write(event *Event) {
-	c.tx <- event
+	c.writePriority(event, -1)
 }
 
-// rate allows limiting events based on how frequent the event is being sent,
-// as well as how many characters each event has.
-func (c *ircConn) rate(chars int) time.Duration {
-	_time := time.Second + ((time.Duration(chars) * time.Second) / 100)
-	if c.writeDelay += _time - time.Now().Sub(c.lastWrite); c.writeDelay < 0 {
-		c.writeDelay = 0
+// writePriority is like write, however the event is queued in the given
+// priority lane (rather than that of eventPriority, if negative), e.g. so
+// it's sent in order with the events of that lane.
+func (c *Client) writePriority(event *Event, priority int) {
+	// Mark credentials as sensitive before the send hooks see them, so
+	// hooks which log can skip (or redact) them.
+	if !event.Sensitive && c.isSensitive(event) {
+		event.Sensitive = true
+	}
+
+	event, err := c.runSendHooks(event)
+	if err != nil {
+		c.debug.Printf("send hook blocked event: %s", err)
+		return
+	}
+
+	if event == nil {
+		return
+	}
+
+	if tracer := c.Config.Tracer; tracer != nil {
+		traceSend(tracer, event)
+	}
+
+	if priority < 0 {
+		priority = eventPriority(event)
 	}
 
-	if c.writeDelay > (8 * time.Second) {
-		return _time
+	// Control traffic is still allowed during QuitGraceful(), as it's
+	// needed to keep the connection alive, and for the QUIT itself.
+	if priority != priorityControl && c.isQuitting() {
+		c.debug.Printf("quitting, dropping %s event", event.Command)
+		endSend(event, errSendDropped)
+		return
 	}
 
-	return 0
+	c.enqueue(priority, event)
 }
 
-func (c *Client) sendLoop(ctx context.Context) {
-	var err error
+// OverflowPolicy is what happens to events sent while the queue they belong
+// in is full. See Config.SendOverflow.
+type OverflowPolicy int
+
+const (
+	// OverflowBlock blocks the sender until there is room in the queue.
+	OverflowBlock OverflowPolicy = iota
+	// OverflowDropOldest drops the oldest event in the queue to make room,
+	// so the most recent messages are sent, e.g. for relays of live
+	// traffic.
+	OverflowDropOldest
+	// OverflowError drops the event being sent, and passes an ErrQueueFull
+	// to Config.HandleError.
+	OverflowError
+)
+
+// ErrQueueFull is passed to Config.HandleError when an event is dropped as
+// its queue is full, with Config.SendOverflow set to OverflowError.
+type ErrQueueFull struct {
+	// Event is the event which was dropped.
+	Event *Event
+}
+
+func (e *ErrQueueFull) Error() string {
+	return fmt.Sprintf("send queue full, dropped %s event", e.Event.Command)
+}
+
+// enqueue queues the event in the given priority lane, applying
+// Config.SendOverflow if it's full.
+func (c *Client) enqueue(priority int, event *Event) {
+	policy := c.Config.SendOverflow
+	if priority == priorityControl {
+		policy = OverflowBlock
+	}
 
 	for {
 		select {
-		case <-ctx.Done():
+		case c.tx[priority] <- event:
 			return
-		case event := <-c.tx:
-			// Log the event.
-			if !event.Sensitive {
-				c.debug.Print("> ", StripRaw(event.String()))
+		default:
+		}
+
+		switch policy {
+		case OverflowDropOldest:
+			// sendLoop may empty the queue in the meantime, in which case
+			// there is room now.
+			select {
+			case old := <-c.tx[priority]:
+				c.debug.Printf("send queue full, dropping oldest %s event", old.Command)
+				endSend(old, errSendDropped)
+			default:
 			}
-			if c.Config.Out != nil {
-				if pretty, ok := event.Pretty(); ok {
-					fmt.Fprintln(c.Config.Out, StripRaw(pretty))
-				}
+		case OverflowError:
+			err := &ErrQueueFull{Event: event}
+			c.debug.Print(err)
+			endSend(event, err)
+
+			if c.Config.HandleError != nil {
+				c.Config.HandleError(err)
 			}
+			return
+		default:
+			c.tx[priority] <- event
+			return
+		}
+	}
+}
+
+// SendHook is a function which is called for each outgoing event, before it
+// is queued to be sent. Hooks can modify the event (e.g. add tags or redact
+// text), return a completely different event, or block the event by either
+// returning a nil event, or an error. See Client.AddSendHook().
+type SendHook func(event *Event) (*Event, error)
+
+// AddSendHook adds a hook to the end of the chain of hooks which outgoing
+// events are passed through before being sent. Each hook is passed the event
+// returned by the previous hook. If a hook blocks the event, it is dropped
+// (and any error is logged to Config.Debug), and later hooks aren't called.
+// Hooks are called from the goroutine sending the event, and must not send
+// events themselves.
+func (c *Client) AddSendHook(hook SendHook) {
+	c.hmux.Lock()
+	c.sendHooks = append(c.sendHooks, hook)
+	c.hmux.Unlock()
+}
+
+// runSendHooks passes the event through the chain of send hooks.
+func (c *Client) runSendHooks(event *Event) (*Event, error) {
+	c.hmux.RLock()
+	hooks := c.sendHooks
+	c.hmux.RUnlock()
+
+	var err error
+	for i := 0; i < len(hooks) && event != nil; i++ {
+		if event, err = hooks[i](event); err != nil {
+			return nil, err
+		}
+	}
+
+	return event, nil
+}
 
-			c.conn.lastWrite = time.Now()
+// Priorities of outgoing events. Lower values are sent first.
+const (
+	// priorityControl is for control traffic, which must always be sent
+	// promptly, and is never rate limited.
+	priorityControl = iota
+	// priorityCommand is for regular commands, such as JOIN or MODE.
+	priorityCommand
+	// priorityBulk is for bulk messages, such as PRIVMSG and NOTICE.
+	priorityBulk
+
+	// priorityLanes is the amount of priority lanes.
+	priorityLanes
+)
+
+const (
+	// txBufferSize is the default amount of events which can be queued in
+	// each priority lane. See Config.SendQueueSize.
+	txBufferSize = 25
+	// rxBufferSize is the default amount of received events which can be
+	// waiting to be handled. See Config.ReceiveQueueSize.
+	rxBufferSize = 25
+	// defaultReadBufferSize is the default value of Config.ReadBufferSize.
+	defaultReadBufferSize = 4096
+)
 
-			// Write the raw line.
-			_, err = c.conn.io.Write(event.Bytes())
-			if err == nil {
-				// And the \r\n.
-				_, err = c.conn.io.Write(endline)
-				if err == nil {
-					// Lastly, flush everything to the socket.
-					err = c.conn.io.Flush()
+// queueSize returns size, or def if size is less than 1.
+func queueSize(size, def int) int {
+	if size < 1 {
+		return def
+	}
+
+	return size
+}
+
+// eventPriority returns the priority lane which an outgoing event should be
+// queued in. Keep-alive pings and responses must always be sent promptly,
+// otherwise the server may consider us timed out (and Client.Lag() would
+// include time spent in the queue). CAP and AUTHENTICATE are also
+// control traffic, so registration isn't delayed by capability negotiation,
+// as are WEBIRC and PASS, so they're always sent before CAP LS. BATCH is
+// queued with the messages it wraps, so they're sent in order.
+func eventPriority(event *Event) int {
+	switch event.Command {
+	case PING, PONG, QUIT, CAP, AUTHENTICATE, WEBIRC, PASS:
+		return priorityControl
+	case PRIVMSG, NOTICE, TAGMSG, BATCH:
+		return priorityBulk
+	}
+
+	return priorityCommand
+}
+
+// QueueLen returns the amount of events which are waiting to be sent to the
+// server. Callers sending a large amount of messages can use this to apply
+// backpressure, rather than blocking once the queue is full.
+func (c *Client) QueueLen() (n int) {
+	for i := 0; i < len(c.tx); i++ {
+		n += len(c.tx[i])
+	}
+
+	return n
+}
+
+const (
+	// defaultRateBurst is the default value of Config.RateBurst.
+	defaultRateBurst = 5
+	// defaultRateInterval is the default value of Config.RateInterval.
+	defaultRateInterval = 2 * time.Second
+)
+
+// tokenBucket is a token bucket rate limiter. The bucket holds up to burst
+// tokens, and is refilled by one token every interval. It may be shared by
+// several clients (see Pool).
+type tokenBucket struct {
+	mu       sync.Mutex
+	burst    int
+	interval time.Duration
+
+	// tokens is the amount of tokens in the bucket as of last.
+	tokens float64
+	// last is the last time tokens was updated.
+	last time.Time
+}
+
+// newTokenBucket returns a new, full, tokenBucket.
+func newTokenBucket(burst int, interval time.Duration) *tokenBucket {
+	if burst < 1 {
+		burst = defaultRateBurst
+	}
+
+	if interval <= 0 {
+		interval = defaultRateInterval
+	}
+
+	return &tokenBucket{burst: burst, interval: interval, tokens: float64(burst), last: time.Now()}
+}
+
+// set changes the burst and interval of the bucket.
+func (b *tokenBucket) set(burst int, interval time.Duration) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.refill()
+	b.burst, b.interval = burst, interval
+	if b.tokens > float64(burst) {
+		b.tokens = float64(burst)
+	}
+}
+
+// refill adds the tokens earned since the bucket was last updated.
+func (b *tokenBucket) refill() {
+	now := time.Now()
+
+	b.tokens += float64(now.Sub(b.last)) / float64(b.interval)
+	if b.tokens > float64(b.burst) {
+		b.tokens = float64(b.burst)
+	}
+	b.last = now
+}
+
+// wait returns the duration until a token is available, or 0 if one is
+// available now.
+func (b *tokenBucket) wait() time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.refill()
+
+	if b.tokens >= 1 {
+		return 0
+	}
+
+	return time.Duration((1 - b.tokens) * float64(b.interval))
+}
+
+// take takes a token from the bucket. The bucket may go into debt if there
+// are no tokens available, so check wait() first.
+func (b *tokenBucket) take() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.refill()
+	b.tokens--
+}
+
+// sendLoop writes queued events to conn in order of priority, rate limiting
+// them as necessary.
+func (c *Client) sendLoop(ctx context.Context, conn *ircConn) {
+	var event *Event
+
+	for {
+		event = nil
+
+		// Control traffic always goes first, and isn't rate limited.
+		select {
+		case event = <-c.tx[priorityControl]:
+		default:
+		}
+
+		if event == nil {
+			var wait time.Duration
+			if !c.allowFlood() {
+				wait = conn.limiter.wait()
+			}
+
+			if wait > 0 {
+				// Wait for the rate limit, while still sending control
+				// traffic.
+				timer := time.NewTimer(wait)
+
+				select {
+				case <-ctx.Done():
+					timer.Stop()
+					return
+				case event = <-c.tx[priorityControl]:
+					timer.Stop()
+				case <-timer.C:
+					continue
+				}
+			} else {
+				if event = c.nextEvent(ctx); event == nil {
+					return
+				}
+
+				if eventPriority(event) != priorityControl && !c.allowFlood() {
+					conn.limiter.take()
 				}
 			}
+		}
 
-			if err != nil {
-				c.disconnectHandler(err)
+		if err := c.sendEvent(conn, event); err != nil {
+			// If we were intentionally closed, don't attempt to reconnect.
+			if ctx.Err() != nil {
+				return
 			}
+
+			c.disconnectHandler(err)
+			return
 		}
 	}
 }
 
-// flushTx empties c.tx.
-func (c *Client) flushTx() {
-	for {
+// nextEvent returns the next queued event with the highest priority,
+// blocking until one is available. Returns nil if ctx is cancelled.
+func (c *Client) nextEvent(ctx context.Context) *Event {
+	for i := 0; i < len(c.tx); i++ {
 		select {
-		case <-c.tx:
+		case event := <-c.tx[i]:
+			return event
 		default:
-			return
+		}
+	}
+
+	select {
+	case <-ctx.Done():
+		return nil
+	case event := <-c.tx[priorityControl]:
+		return event
+	case event := <-c.tx[priorityCommand]:
+		return event
+	case event := <-c.tx[priorityBulk]:
+		return event
+	}
+}
+
+// sendEvent logs, and writes a single event to conn. Events are only
+// logged to the chat logs once written, as queued events may still be
+// dropped.
+func (c *Client) sendEvent(conn *ircConn, event *Event) (err error) {
+	// Keys are recorded before writing, so they're known by the time the
+	// server replies to the JOIN.
+	if event.Command == JOIN {
+		c.keys.record(c.casemapping(), event)
+	}
+
+	// Log the event.
+	c.debug.Print("> ", StripRaw(c.Redact(event).String()))
+	if c.Config.Out != nil {
+		if pretty, ok := event.Pretty(); ok {
+			fmt.Fprintln(c.Config.Out, StripRaw(pretty))
+		}
+	}
+
+	conn.lastWrite = time.Now()
+
+	// Write the raw line.
+	_, err = event.WriteTo(conn.io)
+	if err == nil {
+		// And the \r\n.
+		_, err = conn.io.Write(endline)
+		if err == nil {
+			// Lastly, flush everything to the socket.
+			err = conn.io.Flush()
+		}
+	}
+
+	if err == nil {
+		c.logSent(event)
+	}
+
+	endSend(event, err)
+	return err
+}
+
+// flushTx empties all priority lanes of c.tx.
+func (c *Client) flushTx() {
+	for i := 0; i < len(c.tx); i++ {
+	flush:
+		for {
+			select {
+			case event := <-c.tx[i]:
+				endSend(event, errSendDropped)
+			default:
+				break flush
+			}
 		}
 	}
 }
 
 // ErrTimedOut is returned when we attempt to ping the server, and time out
-// before receiving a PONG back.
+// before receiving a PONG back. The error passed to Config.HandleError is an
+// ErrPingTimeout, with the details, which wraps ErrTimedOut:
+//
+//	if errors.Is(err, girc.ErrTimedOut) {
+//		// ...
+//	}
 var ErrTimedOut = errors.New("timed out during ping to server")
 
-func (c *Client) pingLoop(ctx context.Context) {
-	c.conn.lastPing = time.Now()
-	c.conn.lastPong = time.Now()
+// ErrPingTimeout is returned when we attempt to ping the server, and time
+// out before receiving a PONG back. It wraps ErrTimedOut, for use with
+// errors.Is.
+type ErrPingTimeout struct {
+	// TimeSinceSuccess is how long ago we received a successful pong.
+	TimeSinceSuccess time.Duration
+	// LastPong is the time we received our last successful pong.
+	LastPong time.Time
+	// LastPing is the time we last sent a ping.
+	LastPing time.Time
+	// Timeout is the configured ping timeout.
+	Timeout time.Duration
+}
+
+func (e *ErrPingTimeout) Error() string {
+	return fmt.Sprintf("%s: no pong received in %s", ErrTimedOut, e.TimeSinceSuccess)
+}
+
+// Unwrap returns ErrTimedOut.
+func (e *ErrPingTimeout) Unwrap() error { return ErrTimedOut }
 
-	// Delay for 30 seconds during connect to wait for the client to register
-	// and what not.
-	time.Sleep(20 * time.Second)
+// pingLoop sends a PING to the server every Config.PingDelay, and disconnects
+// (with ErrPingTimeout) if a PONG isn't received within Config.PingTimeout, on
+// conn.
+func (c *Client) pingLoop(ctx context.Context, conn *ircConn) {
+	conn.mu.Lock()
+	conn.lastPing = time.Now()
+	conn.lastPong = time.Now()
+	conn.mu.Unlock()
 
+	// The first ping is only sent after PingDelay, which gives the client
+	// time to register.
 	tick := time.NewTicker(c.Config.PingDelay)
 	defer tick.Stop()
 
+	timeout := time.NewTimer(c.Config.PingTimeout)
+	timeout.Stop()
+	defer timeout.Stop()
+
+	// pending is when the ping which is currently being timed was sent.
+	var pending time.Time
+
 	for {
 		select {
 		case <-ctx.Done():
 			return
 		case <-tick.C:
-			if time.Since(c.conn.lastPong) > c.Config.PingDelay+(60*time.Second) {
-				// It's 60 seconds over what out ping delay is, connection
-				// has probably dropped.
-				c.disconnectHandler(ErrTimedOut)
+			now := time.Now()
+
+			conn.mu.Lock()
+			conn.lastPing = now
+			conn.mu.Unlock()
+
+			c.Commands.Ping(fmt.Sprintf("%d", now.UnixNano()))
+
+			if pending.IsZero() {
+				pending = now
+				timeout.Reset(c.Config.PingTimeout)
+			}
+		case <-timeout.C:
+			conn.mu.RLock()
+			err := &ErrPingTimeout{
+				TimeSinceSuccess: time.Since(conn.lastPong),
+				LastPong:         conn.lastPong,
+				LastPing:         conn.lastPing,
+				Timeout:          c.Config.PingTimeout,
+			}
+			conn.mu.RUnlock()
+
+			if err.LastPong.Before(pending) {
+				// The server hasn't responded in time, so the connection has
+				// probably dropped.
+				c.disconnectHandler(err)
 				return
 			}
 
-			c.conn.lastPing = time.Now()
-			c.Commands.Ping(fmt.Sprintf("%d", time.Now().UnixNano()))
+			pending = time.Time{}
 		}
 	}
 }
@@ -7,8 +7,16 @@ package girc
 import (
 	"bufio"
 	"bytes"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
 	"testing"
 	"time"
+
+	"golang.org/x/net/context"
 )
 
 func TestNewConn(t *testing.T) {
@@ -28,6 +36,106 @@ func TestNewConn(t *testing.T) {
 	conn.Close()
 }
 
+type mockDialer struct {
+	network, address string
+	conn             net.Conn
+}
+
+func (d *mockDialer) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	d.network, d.address = network, address
+	return d.conn, nil
+}
+
+func TestNewConnDialer(t *testing.T) {
+	local, remote := net.Pipe()
+	defer remote.Close()
+
+	dialer := &mockDialer{conn: local}
+	conf := Config{Server: "irc.example.com", Port: 6667, Nick: "nick", User: "user", Dialer: dialer}
+
+	conn, err := newConn(conf, "irc.example.com:6667")
+	if err != nil {
+		t.Fatalf("newConn() with custom dialer returned error: %s", err)
+	}
+	defer conn.Close()
+
+	if dialer.network != "tcp" || dialer.address != "irc.example.com:6667" {
+		t.Fatalf("dialer called with %q/%q, wanted tcp/irc.example.com:6667", dialer.network, dialer.address)
+	}
+
+	if conn.sock != local {
+		t.Fatal("newConn() did not use the connection returned by the dialer")
+	}
+
+	conf.SSL = true
+	dialer.conn = local
+	conn, err = newConn(conf, "irc.example.com:6667")
+	if err != nil {
+		t.Fatalf("newConn() with custom dialer and SSL returned error: %s", err)
+	}
+
+	if _, ok := conn.sock.(*tls.Conn); !ok {
+		t.Fatalf("newConn() with SSL did not wrap the dialed connection, got %T", conn.sock)
+	}
+}
+
+// mockServer is the server side of an in-memory connection to a client.
+type mockServer struct {
+	t    *testing.T
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+// mockClient connects a new client, with the given config, to an in-memory
+// server.
+func mockClient(t *testing.T, conf Config) (*Client, *mockServer) {
+	local, remote := net.Pipe()
+
+	if conf.Server == "" {
+		conf.Server, conf.Port = "irc.example.com", 6667
+	}
+	if conf.Nick == "" {
+		conf.Nick, conf.User = "nick", "user"
+	}
+	conf.Dialer = &mockDialer{conn: local}
+
+	c := New(conf)
+	if err := c.Connect(); err != nil {
+		t.Fatalf("unable to connect to mock server: %s", err)
+	}
+
+	return c, &mockServer{t: t, conn: remote, r: bufio.NewReader(remote)}
+}
+
+// expect reads lines sent by the client until one is found which starts
+// with prefix, failing the test if it's not received in time.
+func (s *mockServer) expect(prefix string) string {
+	s.t.Helper()
+	s.conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+
+	for {
+		line, err := s.r.ReadString(delim)
+		if err != nil {
+			s.t.Fatalf("never received line with prefix %q from client: %s", prefix, err)
+		}
+
+		line = strings.TrimRight(line, "\r\n")
+		if strings.HasPrefix(line, prefix) {
+			return line
+		}
+	}
+}
+
+// send writes a raw line to the client.
+func (s *mockServer) send(line string) {
+	s.t.Helper()
+	s.conn.SetWriteDeadline(time.Now().Add(2 * time.Second))
+
+	if _, err := s.conn.Write([]byte(line + "\r\n")); err != nil {
+		s.t.Fatalf("unable to send %q to client: %s", line, err)
+	}
+}
+
 func mockBuffers() (in *bytes.Buffer, out *bytes.Buffer, irc *ircConn) {
 	in = &bytes.Buffer{}
 	out = &bytes.Buffer{}
@@ -91,32 +199,525 @@ func TestEncode(t *testing.T) {
 }
 
 func TestRate(t *testing.T) {
-	_, _, c := mockBuffers()
-	c.lastWrite = time.Now()
-	if delay := c.rate(100); delay > time.Second {
-		t.Fatal("first instance of rate is > second")
+	b := newTokenBucket(3, time.Second)
+
+	for i := 0; i < 3; i++ {
+		if wait := b.wait(); wait != 0 {
+			t.Fatalf("wait() within burst returned %s", wait)
+		}
+		b.take()
 	}
 
-	for i := 0; i < 500; i++ {
-		c.rate(200)
+	wait := b.wait()
+	if wait <= 0 || wait > time.Second {
+		t.Fatalf("wait() after burst returned %s, want (0, 1s]", wait)
 	}
 
-	if delay := c.rate(200); delay > (3 * time.Second) {
-		t.Fatal("rate delay too high")
+	// Pretend the interval has passed.
+	b.last = b.last.Add(-time.Second)
+	if wait = b.wait(); wait != 0 {
+		t.Fatalf("wait() after refill returned %s", wait)
 	}
 
-	return
+	// The bucket should never hold more than the burst.
+	b.last = b.last.Add(-time.Hour)
+	for i := 0; i < 3; i++ {
+		b.take()
+	}
+	if wait = b.wait(); wait == 0 {
+		t.Fatal("bucket refilled past burst")
+	}
+}
+
+func TestRateBypass(t *testing.T) {
+	c, server := mockClient(t, Config{RateBurst: 1, RateInterval: time.Hour})
+	defer c.Stop()
+
+	// Registration uses the only token, so this will be stuck in the queue.
+	c.Commands.Message("#channel", "queued")
+	c.Send(&Event{Command: PONG, Params: []string{"1234"}})
+
+	server.expect("PONG 1234")
 }
 
 func TestFlushTx(t *testing.T) {
-	c := &Client{tx: make(chan *Event, 50)}
+	c := New(Config{})
+
+	for i := 0; i < 20; i++ {
+		c.write(&Event{Command: PRIVMSG})
+		c.write(&Event{Command: JOIN})
+		c.write(&Event{Command: PONG})
+	}
 
-	for i := 0; i < 25; i++ {
-		c.tx <- &Event{}
+	if n := c.QueueLen(); n != 60 {
+		t.Fatalf("QueueLen() = %d, want 60", n)
 	}
 
 	c.flushTx()
-	if len(c.tx) > 0 {
-		t.Fatalf("flush failed too flush all events: %d remaining", len(c.tx))
+	if n := c.QueueLen(); n > 0 {
+		t.Fatalf("flush failed too flush all events: %d remaining", n)
+	}
+}
+
+func TestSendOverflow(t *testing.T) {
+	// Blocking is tested by TestFlushTx, through the default queue size.
+	c := New(Config{SendQueueSize: 2, SendOverflow: OverflowDropOldest})
+	for i := 0; i < 5; i++ {
+		c.write(&Event{Command: PRIVMSG, Params: []string{"#channel"}, Trailing: fmt.Sprint(i)})
+	}
+
+	if n := c.QueueLen(); n != 2 {
+		t.Fatalf("QueueLen() = %d, want 2", n)
+	}
+	if e := <-c.tx[priorityBulk]; e.Trailing != "3" {
+		t.Fatalf("oldest queued event is %q, want the 4th", e)
+	}
+
+	var errs []error
+	c = New(Config{SendQueueSize: 1, SendOverflow: OverflowError, HandleError: func(err error) { errs = append(errs, err) }})
+	c.write(&Event{Command: PRIVMSG, Params: []string{"#channel"}, Trailing: "queued"})
+	c.write(&Event{Command: PRIVMSG, Params: []string{"#channel"}, Trailing: "dropped"})
+
+	if len(errs) != 1 {
+		t.Fatalf("HandleError() called with %v, want a single error", errs)
+	}
+	if err, ok := errs[0].(*ErrQueueFull); !ok || err.Event.Trailing != "dropped" {
+		t.Fatalf("HandleError() called with %#v, want *ErrQueueFull", errs[0])
+	}
+
+	// Control messages are never dropped.
+	go c.write(&Event{Command: PONG, Params: []string{"1"}})
+	go c.write(&Event{Command: PONG, Params: []string{"2"}})
+	for i := 0; i < 2; i++ {
+		select {
+		case <-c.tx[priorityControl]:
+		case <-time.After(time.Second):
+			t.Fatal("control message was dropped")
+		}
+	}
+}
+
+func TestReadBufferSize(t *testing.T) {
+	local, remote := net.Pipe()
+	defer local.Close()
+	defer remote.Close()
+
+	c := &ircConn{sock: local}
+	c.newReadWriter(16 * 1024)
+	if size := c.io.Reader.Size(); size != 16*1024 {
+		t.Fatalf("read buffer size = %d, want %d", size, 16*1024)
+	}
+
+	c.newReadWriter(0)
+	if size := c.io.Reader.Size(); size != defaultReadBufferSize {
+		t.Fatalf("default read buffer size = %d, want %d", size, defaultReadBufferSize)
+	}
+}
+
+func TestEventPriority(t *testing.T) {
+	tests := []struct {
+		command string
+		want    int
+	}{
+		{command: PONG, want: priorityControl},
+		{command: CAP, want: priorityControl},
+		{command: AUTHENTICATE, want: priorityControl},
+		{command: JOIN, want: priorityCommand},
+		{command: MODE, want: priorityCommand},
+		{command: PRIVMSG, want: priorityBulk},
+		{command: NOTICE, want: priorityBulk},
+	}
+
+	for _, tt := range tests {
+		if got := eventPriority(&Event{Command: tt.command}); got != tt.want {
+			t.Errorf("eventPriority(%s) = %d, want %d", tt.command, got, tt.want)
+		}
+	}
+}
+
+func TestSendPriority(t *testing.T) {
+	c, server := mockClient(t, Config{RateBurst: 1, RateInterval: 100 * time.Millisecond})
+	defer c.Stop()
+
+	server.expect("NICK")
+	server.expect("USER")
+
+	// Registration has used the burst, so all of these are queued, and
+	// should be sent in order of priority.
+	c.Commands.Message("#channel", "bulk")
+	c.Commands.Join("#channel")
+	c.Send(&Event{Command: PONG, Params: []string{"1234"}})
+
+	for _, want := range []string{"PONG 1234", "JOIN #channel", "PRIVMSG #channel"} {
+		if line := server.expect(""); !strings.HasPrefix(line, want) {
+			t.Fatalf("received %q, want %q", line, want)
+		}
+	}
+}
+
+func TestMessageWrap(t *testing.T) {
+	c, server := mockClient(t, Config{RateBurst: 10})
+	defer c.Stop()
+
+	server.send(":irc.example.com 001 nick :Welcome")
+	server.send(":nick!user@example.com JOIN #channel")
+	server.expect("WHO #channel")
+	server.expect("MODE #channel")
+
+	// ":nick!user@example.com PRIVMSG #channel :" is 41 bytes.
+	max := maxLength - 41
+	if got := c.maxMessageLen(PRIVMSG, "#channel"); got != max {
+		t.Fatalf("maxMessageLen() = %d, want %d", got, max)
+	}
+
+	words := strings.Repeat("word ", max/5) + "overflow"
+	lines, err := c.Commands.Message("#channel", words)
+	if err != nil || lines != 2 {
+		t.Fatalf("Message() = %d, %v, want 2 lines", lines, err)
+	}
+
+	if line, want := server.expect("PRIVMSG"), "PRIVMSG #channel :"+strings.TrimSpace(strings.Repeat("word ", max/5)); line != want {
+		t.Fatalf("received %q, want %q", line, want)
+	}
+	server.expect("PRIVMSG #channel :overflow")
+
+	if lines, _ = c.Commands.Notice("#channel", "one\ntwo"); lines != 2 {
+		t.Fatalf("Notice() sent %d lines, want 2", lines)
+	}
+	server.expect("NOTICE #channel :one")
+	server.expect("NOTICE #channel :two")
+}
+
+func TestCPRIVMSG(t *testing.T) {
+	c, server := mockClient(t, Config{RateBurst: 20})
+	defer c.Stop()
+
+	server.send(":irc.example.com 001 nick :Welcome")
+	for _, line := range []string{
+		":nick!user@example.com JOIN #c",
+		":irc.example.com 353 nick = #c :nick friend",
+		":nick!user@example.com JOIN #b",
+		":irc.example.com 353 nick = #b :+nick friend",
+		":nick!user@example.com JOIN #a",
+		":irc.example.com 353 nick = #a :@nick friend stranger",
+	} {
+		server.send(line)
+	}
+	server.send("PING :sync")
+	server.expect("PONG sync")
+
+	// Not advertised.
+	c.Commands.Message("friend", "hello")
+	server.expect("PRIVMSG friend :hello")
+
+	server.send(":irc.example.com 005 nick CPRIVMSG CNOTICE :are supported by this server")
+	server.send("PING :sync")
+	server.expect("PONG sync")
+
+	tests := []struct {
+		send func()
+		want string
+	}{
+		{send: func() { c.Commands.Message("Friend", "hello") }, want: "CPRIVMSG Friend #a :hello"},
+		{send: func() { c.Commands.Notice("friend", "hello") }, want: "CNOTICE friend #a :hello"},
+		{send: func() { c.Commands.Message("#b", "hello") }, want: "PRIVMSG #b :hello"},
+		{send: func() { c.Commands.Message("other", "hello") }, want: "PRIVMSG other :hello"},
+	}
+
+	for _, tt := range tests {
+		tt.send()
+		if line := server.expect(""); line != tt.want {
+			t.Fatalf("received %q, want %q", line, tt.want)
+		}
+	}
+
+	// We're not opped or voiced in the only channel we share.
+	server.send(":irc.example.com MODE #a -o nick")
+	server.send("PING :sync")
+	server.expect("PONG sync")
+
+	c.Commands.Message("stranger", "hello")
+	server.expect("PRIVMSG stranger :hello")
+}
+
+func TestMessageMany(t *testing.T) {
+	c, server := mockClient(t, Config{AllowFlood: true})
+	defer c.Stop()
+
+	targets := []string{"#a", "b", "#C", "#c", "d", "#e"}
+
+	// Unknown limit.
+	if sent, err := c.Commands.MessageMany(targets, "hello"); err != nil || sent != 5 {
+		t.Fatalf("MessageMany() = %d, %v, want 5 messages", sent, err)
+	}
+	for _, target := range []string{"#a", "b", "#C", "d", "#e"} {
+		server.expect("PRIVMSG " + target + " :hello")
+	}
+
+	server.send(":irc.example.com 005 nick TARGMAX=PRIVMSG:3,NOTICE: :are supported by this server")
+	server.send("PING :sync")
+	server.expect("PONG sync")
+
+	if sent, _ := c.Commands.MessageMany(targets, "hello"); sent != 2 {
+		t.Fatalf("MessageMany() sent %d messages, want 2", sent)
+	}
+	server.expect("PRIVMSG #a,b,#C :hello")
+	server.expect("PRIVMSG d,#e :hello")
+
+	// No limit, but the targets must fit on the line.
+	long := strings.Repeat("x", 40)
+	var many []string
+	for i := 0; i < 12; i++ {
+		many = append(many, "#"+strconv.Itoa(i)+long)
+	}
+
+	if sent, _ := c.Commands.NoticeMany(many, "hello"); sent != 2 {
+		t.Fatalf("NoticeMany() sent %d notices, want 2", sent)
+	}
+	server.expect("NOTICE " + strings.Join(many[:11], ",") + " :hello")
+	server.expect("NOTICE " + many[11] + " :hello")
+
+	if _, err := c.Commands.MessageMany([]string{"#a", "in valid"}, "hello"); err == nil {
+		t.Fatal("MessageMany() with an invalid target returned no error")
+	}
+
+	// With ascii, #a[b] and #A{B} aren't duplicates.
+	c.state.mu.Lock()
+	c.state.isupport.Casemapping = CaseMappingASCII
+	c.state.mu.Unlock()
+
+	if sent, _ := c.Commands.MessageMany([]string{"#a[b]", "#A{B}", "#A[B]"}, "hello"); sent != 1 {
+		t.Fatalf("MessageMany() sent %d messages, want 1", sent)
+	}
+	server.expect("PRIVMSG #a[b],#A{B} :hello")
+}
+
+func TestPingTimeout(t *testing.T) {
+	local, remote := net.Pipe()
+	errs := make(chan error, 2)
+
+	c := New(Config{
+		Server:      "irc.example.com",
+		Port:        6667,
+		Nick:        "nick",
+		User:        "user",
+		Dialer:      &mockDialer{conn: local},
+		HandleError: func(err error) { errs <- err },
+	})
+	c.Config.PingDelay = 50 * time.Millisecond
+	c.Config.PingTimeout = 100 * time.Millisecond
+	defer c.Stop()
+
+	if err := c.Connect(); err != nil {
+		t.Fatalf("unable to connect to mock server: %s", err)
+	}
+	server := &mockServer{t: t, conn: remote, r: bufio.NewReader(remote)}
+
+	// Answer the first ping, to measure lag.
+	ping := server.expect("PING")
+	server.send(":irc.example.com PONG irc.example.com :" + strings.TrimPrefix(ping, "PING "))
+
+	// Then ignore the next one.
+	server.expect("PING")
+
+	select {
+	case err := <-errs:
+		if _, ok := err.(*ErrPingTimeout); !ok || !errors.Is(err, ErrTimedOut) {
+			t.Fatalf("HandleError() called with %#v, wanted *ErrPingTimeout wrapping ErrTimedOut", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("HandleError() not called after ping timeout")
+	}
+
+	if c.Lag() <= 0 {
+		t.Fatalf("Lag() = %s after successful ping, want > 0", c.Lag())
+	}
+}
+
+func TestLagHistory(t *testing.T) {
+	var r lagRing
+
+	if got := r.list(); len(got) != 0 {
+		t.Fatalf("empty lagRing.list() = %v", got)
+	}
+
+	for i := 1; i <= lagHistorySize+2; i++ {
+		r.add(time.Duration(i))
+	}
+
+	got := r.list()
+	if len(got) != lagHistorySize || got[0] != 3 || got[len(got)-1] != lagHistorySize+2 {
+		t.Fatalf("lagRing.list() after wrap = %v", got)
+	}
+
+	c := &Client{conn: &ircConn{}}
+	for _, lag := range []time.Duration{30, 10, 20} {
+		c.conn.lagHistory.add(lag)
+	}
+
+	if min, avg, max := c.LagStats(); min != 10 || avg != 20 || max != 30 {
+		t.Fatalf("LagStats() = %s, %s, %s, want 10ns, 20ns, 30ns", min, avg, max)
+	}
+}
+
+func TestLifecycleEvents(t *testing.T) {
+	local, remote := net.Pipe()
+	events := make(chan string, 10)
+
+	c := New(Config{
+		Server: "irc.example.com",
+		Port:   6667,
+		Nick:   "nick",
+		User:   "user",
+		Dialer: &mockDialer{conn: local},
+	})
+	defer c.Stop()
+
+	for _, cmd := range []string{DIALING, INITIALIZED, CAP_NEGOTIATED, REGISTERED, DISCONNECTED} {
+		c.Handlers.Add(cmd, func(c *Client, e Event) { events <- e.Command })
+	}
+
+	if err := c.Connect(); err != nil {
+		t.Fatalf("unable to connect to mock server: %s", err)
+	}
+	server := &mockServer{t: t, conn: remote, r: bufio.NewReader(remote)}
+
+	server.expect("CAP LS 302")
+	server.send(":irc.example.com CAP * LS :unsupported-cap")
+	server.expect("CAP END")
+	server.send(":irc.example.com 001 nick :Welcome to the network")
+
+	for _, want := range []string{DIALING, INITIALIZED, CAP_NEGOTIATED, REGISTERED, DISCONNECTED} {
+		if want == DISCONNECTED {
+			remote.Close()
+		}
+
+		select {
+		case got := <-events:
+			if got != want {
+				t.Fatalf("received lifecycle event %q, want %q", got, want)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatalf("never received lifecycle event %q", want)
+		}
+	}
+}
+
+func TestQuitGraceful(t *testing.T) {
+	c, server := mockClient(t, Config{RateBurst: 1, RateInterval: 50 * time.Millisecond})
+	defer c.Stop()
+
+	for i := 0; i < 3; i++ {
+		c.Commands.Message("#channel", "queued")
+	}
+
+	result := make(chan error, 1)
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		defer cancel()
+
+		result <- c.QuitGraceful(ctx, "bye")
+	}()
+
+	for i := 0; i < 3; i++ {
+		server.expect("PRIVMSG #channel")
+	}
+	server.expect("QUIT :bye")
+	server.send("ERROR :Closing Link: nick (Quit: bye)")
+	server.conn.Close()
+
+	select {
+	case err := <-result:
+		if err != nil {
+			t.Fatalf("QuitGraceful() returned error: %s", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("QuitGraceful() did not return after the server closed the connection")
+	}
+
+	if c.IsConnected() {
+		t.Fatal("client still connected after QuitGraceful()")
+	}
+}
+
+func TestWebIRC(t *testing.T) {
+	c, server := mockClient(t, Config{WebIRC: WebIRC{
+		Password: "pass",
+		Gateway:  "gateway",
+		Hostname: "user.example.com",
+		IP:       "192.0.2.1",
+		Secure:   true,
+	}})
+	defer c.Stop()
+
+	want := "WEBIRC pass gateway user.example.com 192.0.2.1 secure"
+	if line := server.expect(""); line != want {
+		t.Fatalf("first line sent = %q, want %q", line, want)
+	}
+}
+
+func TestServerPassFallback(t *testing.T) {
+	// The deprecated Password field is used if ServerPass isn't set.
+	c, server := mockClient(t, Config{Password: "old"})
+	defer c.Stop()
+
+	if line := server.expect(""); line != "PASS old" {
+		t.Fatalf("first line sent = %q, want %q", line, "PASS old")
+	}
+
+	d, server := mockClient(t, Config{ServerPass: "new", Password: "old"})
+	defer d.Stop()
+
+	if line := server.expect(""); line != "PASS new" {
+		t.Fatalf("first line sent = %q, want %q", line, "PASS new")
+	}
+}
+
+func TestAltNicks(t *testing.T) {
+	c, server := mockClient(t, Config{ServerPass: "secret", AltNicks: []string{"alt1", "alt2"}, RateBurst: 10})
+	defer c.Stop()
+
+	server.expect("PASS secret")
+	server.expect("NICK nick")
+
+	for _, want := range []string{"alt1", "alt2", "nick_", "nick__"} {
+		server.send(":irc.example.com 433 * nick :Nickname is already in use")
+		server.expect("NICK " + want)
+	}
+
+	server.send(":irc.example.com 001 nick__ :Welcome to the network")
+	server.send(":irc.example.com 433 nick__ other :Nickname is already in use")
+	server.send(":irc.example.com PING :sync")
+	server.expect("PONG")
+
+	if nick := c.GetNick(); nick != "nick__" {
+		t.Fatalf("GetNick() = %q after registration, want %q", nick, "nick__")
+	}
+}
+
+func TestSendHooks(t *testing.T) {
+	c, server := mockClient(t, Config{})
+	defer c.Stop()
+
+	c.AddSendHook(func(e *Event) (*Event, error) {
+		if e.Command == PRIVMSG && e.Params[0] == "#muted" {
+			return nil, nil
+		}
+
+		return e, nil
+	})
+	c.AddSendHook(func(e *Event) (*Event, error) {
+		if e.Command == PRIVMSG {
+			e.Trailing = strings.Replace(e.Trailing, "secret", "******", -1)
+		}
+
+		return e, nil
+	})
+
+	c.Commands.Message("#muted", "dropped")
+	c.Commands.Message("#channel", "the secret is out")
+
+	if line := server.expect("PRIVMSG"); line != "PRIVMSG #channel :the ****** is out" {
+		t.Fatalf("received %q, want muted message dropped, and secret redacted", line)
 	}
 }
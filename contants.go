@@ -14,16 +14,52 @@ const (
 	CTCP_SOURCE     = "SOURCE"
 	CTCP_TIME       = "TIME"
 	CTCP_ERRMSG     = "ERRMSG"
+	CTCP_ACTION     = "ACTION"
+	CTCP_DCC        = "DCC"
 )
 
 // Emulated event commands used to allow easier hooks into the changing
 // state of the client.
 const (
-	ALLEVENTS    = "*"            // trigger on all events
-	CONNECTED    = "CONNECTED"    // when it's safe to send arbitrary commands (joins, list, who, etc), trailing is host:port
-	INITIALIZED  = "INIT"         // verifies successful socket connection, trailing is host:port
-	DISCONNECTED = "DISCONNECTED" // occurs when we're disconnected from the server (user-requested or not)
-	STOPPED      = "STOPPED"      // occurs when Client.Stop() has been called
+	ALLEVENTS           = "*"                   // trigger on all events
+	DIALING             = "DIALING"             // before the connection to the server is made, trailing is host:port
+	TLS_HANDSHAKE_DONE  = "TLS_HANDSHAKE_DONE"  // after a successful TLS handshake, trailing is host:port
+	INITIALIZED         = "INIT"                // verifies successful socket connection, trailing is host:port
+	CAP_NEGOTIATED      = "CAP_NEGOTIATED"      // when IRCv3 capability negotiation has finished, params are the enabled capabilities, trailing is host:port
+	CAPABILITY_ADDED    = "CAPABILITY_ADDED"    // when an IRCv3 capability is enabled after registration (see cap-notify), params[0] is the capability
+	CAPABILITY_REMOVED  = "CAPABILITY_REMOVED"  // when the server stops supporting an enabled IRCv3 capability (see cap-notify), params[0] is the capability
+	REGISTERED          = "REGISTERED"          // when the server has accepted our registration (RPL_WELCOME), params[0] is our nick, trailing is host:port
+	CONNECTED           = "CONNECTED"           // when it's safe to send arbitrary commands (joins, list, who, etc), trailing is host:port
+	DISCONNECTED        = "DISCONNECTED"        // occurs when we're disconnected from the server (user-requested or not), params[0] is the error if unexpected, trailing is host:port
+	RECONNECT_SCHEDULED = "RECONNECT_SCHEDULED" // before waiting to reconnect, params are the delay and attempt number, trailing is host:port
+	STOPPED             = "STOPPED"             // occurs when Client.Stop() has been called
+	TOPIC_CHANGED       = "TOPIC_CHANGED"       // when a tracked channel topic changes, params are the channel and old topic, trailing is the new topic
+	USER_JOINED         = "USER_JOINED"         // when a user (including us) is added to a tracked channel, source is the user, params[0] is the channel
+	USER_LEFT           = "USER_LEFT"           // when a user (including us) is removed from a tracked channel, source is the user, params are the channel and the command which caused it (PART, KICK or QUIT), trailing is the reason
+	USER_RENAMED        = "USER_RENAMED"        // when a tracked user changes nickname, source is the user (with the old nickname), params are the old and new nickname
+	SELF_NICK_CHANGED   = "SELF_NICK_CHANGED"   // when our nickname changes, params are the old and new nickname
+	SELF_MESSAGE        = "SELF_MESSAGE"        // in place of our own PRIVMSG, NOTICE or TAGMSG with Config.SelfMessages, source is us, params are the target and the command, trailing is the message
+	CHANNEL_SYNCED      = "CHANNEL_SYNCED"      // after joining a channel, once the NAMES and WHO replies have been received, params[0] is the channel
+	USER_AWAY_CHANGED   = "USER_AWAY_CHANGED"   // when the away status of a tracked user changes, source is the user, params[0] is "true" if away, trailing is the away message (if known)
+	MONITOR_ONLINE      = "MONITOR_ONLINE"      // when a user in Client.Monitor is online, source is the user
+	MONITOR_OFFLINE     = "MONITOR_OFFLINE"     // when a user in Client.Monitor is offline, source is the user
+	USER_INVITED        = "USER_INVITED"        // when a user (including us) is invited to a channel, source is the inviter, params are the invitee and channel (see Invite)
+	USER_TYPING         = "USER_TYPING"         // when a user sends a typing notification, source is the user, params are the target (channel or us) and state (see TypingActive)
+	BATCH_COMPLETE      = "BATCH_COMPLETE"      // when a collected IRCv3 batch has been closed, params are the batch type and parameters, Event.Batch holds the events (see Batch)
+	CHANNEL_RENAMED     = "CHANNEL_RENAMED"     // when a tracked channel is renamed by the server (see draft/channel-rename), params are the old and new channel name, trailing is the reason
+	AUTHENTICATED       = "AUTHENTICATED"       // when we log in to an account (RPL_LOGGEDIN), e.g. with SASL, params[0] is the account
+	DEAUTHENTICATED     = "DEAUTHENTICATED"     // when we log out of our account (RPL_LOGGEDOUT), params[0] is the account we were logged in as
+	FLOOD_DETECTED      = "FLOOD_DETECTED"      // when a user exceeds a limit of Config.FloodGuard, source is the user, params are the target (channel or us) and the amount of messages, bytes and repeats, trailing is the FloodAction taken
+	IDENTIFIED          = "IDENTIFIED"          // when we've identified with NickServ (see Config.NickServ), params[0] is the account
+	IDENTIFY_FAILED     = "IDENTIFY_FAILED"     // when identifying with NickServ or services failed (see Config.NickServ and Config.ServiceAuth), trailing is the reason
+	NICK_REGAINED       = "NICK_REGAINED"       // when we've regained our nickname after it was in use (see Config.NickRegain), params are the old and new nickname
+	REJOINED            = "REJOINED"            // when we've rejoined a channel after being kicked (see Config.RejoinOnKick), params are the channel and the amount of attempts
+	REJOIN_FAILED       = "REJOIN_FAILED"       // when we've given up rejoining a channel (see Config.RejoinOnKick), params[0] is the channel, trailing is the last error
+	CHANNEL_FORWARDED   = "CHANNEL_FORWARDED"   // when the server forwards our join to another channel (ERR_LINKCHANNEL), params are the original and target channel, trailing is the reason
+	SERVER_REDIRECTED   = "SERVER_REDIRECTED"   // when we follow the redirect of the server to another server (see Config.Redirects), params are the old and new host:port, trailing is the reason
+	SERVER_BAN_ADDED    = "SERVER_BAN_ADDED"    // when an operator places a server ban (from server notices), params are the type (e.g. "K"), mask, setter and duration in seconds (0 if permanent), trailing is the reason (see ParseServerBan)
+	SERVER_BAN_REMOVED  = "SERVER_BAN_REMOVED"  // when an operator removes a server ban (from server notices), params are the type, mask and remover, trailing is the reason (if known)
+	SNOTICE             = "SNOTICE"             // when a server notice about a user is parsed (see Config.ServerNotices), source is the user, params are the kind (e.g. "connect"), ip and operator (if known), trailing is the reason (see ParseServerNotice)
 )
 
 // User/channel prefixes :: RFC1459
@@ -66,6 +102,11 @@ const (
 	ModeOwner        = "q" // owner privileges (non-rfc)
 	ModeAdmin        = "a" // admin privileges (non-rfc)
 	ModeHalfOperator = "h" // half-operator privileges (non-rfc)
+
+	ModeBan             = "b" // ban mask
+	ModeException       = "e" // ban exception mask (non-rfc, see ISUPPORT EXCEPTS)
+	ModeInviteException = "I" // invite exception mask (non-rfc, see ISUPPORT INVEX)
+	ModeQuiet           = "q" // quiet mask (non-rfc, conflicts with ModeOwner)
 )
 
 // IRC commands :: RFC2812; section 3 :: RFC2813; section 4
@@ -127,6 +168,7 @@ const (
 	RPL_MYINFO            = "004"
 	RPL_BOUNCE            = "005"
 	RPL_ISUPPORT          = "005"
+	RPL_REDIR             = "010"
 	RPL_USERHOST          = "302"
 	RPL_ISON              = "303"
 	RPL_AWAY              = "301"
@@ -243,6 +285,7 @@ const (
 	ERR_YOUREBANNEDCREEP  = "465"
 	ERR_YOUWILLBEBANNED   = "466"
 	ERR_KEYSET            = "467"
+	ERR_LINKCHANNEL       = "470"
 	ERR_CHANNELISFULL     = "471"
 	ERR_UNKNOWNMODE       = "472"
 	ERR_INVITEONLYCHAN    = "473"
@@ -265,6 +308,13 @@ const (
 const (
 	AUTHENTICATE = "AUTHENTICATE"
 	STARTTLS     = "STARTTLS"
+	WEBIRC       = "WEBIRC"
+	MONITOR      = "MONITOR"
+	BATCH        = "BATCH"
+	CHATHISTORY  = "CHATHISTORY"
+	TAGMSG       = "TAGMSG"
+	RENAME       = "RENAME"
+	FAIL         = "FAIL"
 
 	CAP       = "CAP"
 	CAP_ACK   = "ACK"
@@ -280,6 +330,7 @@ const (
 	CAP_CHGHOST = "CHGHOST"
 	CAP_AWAY    = "AWAY"
 	CAP_ACCOUNT = "ACCOUNT"
+	CAP_SETNAME = "SETNAME"
 )
 
 // Numeric IRC reply mapping for ircv3 :: http://ircv3.net/irc/
@@ -297,6 +348,15 @@ const (
 	ERR_STARTTLS    = "691"
 )
 
+// Numeric IRC reply mapping for MONITOR :: http://ircv3.net/specs/core/monitor-3.2.html
+const (
+	RPL_MONONLINE    = "730"
+	RPL_MONOFFLINE   = "731"
+	RPL_MONLIST      = "732"
+	RPL_ENDOFMONLIST = "733"
+	ERR_MONLISTFULL  = "734"
+)
+
 // Numeric IRC event mapping :: RFC2812; section 5.3
 const (
 	RPL_STATSCLINE    = "213"
@@ -332,4 +392,16 @@ const (
 	RPL_LOCALUSERS     = "265" // aircd/hybrid/bahamut, used on freenode.
 	RPL_TOPICWHOTIME   = "333" // ircu, in use on Freenode.
 	RPL_WHOSPCRPL      = "354" // ircu, used on networks with WHOX support.
+	RPL_WHOISACCOUNT   = "330" // ircu, used on networks with services.
+	RPL_WHOISSECURE    = "671" // unreal/charybdis, used on networks with TLS.
+	RPL_WHOISBOT       = "335" // used on networks with bot mode support.
+
+	// ircu/hybrid, used on Undernet (see Commands.Message).
+	CPRIVMSG = "CPRIVMSG"
+	CNOTICE  = "CNOTICE"
+
+	// InspIRCd/UnrealIRCd operator commands (see Commands.SAJoin).
+	SAJOIN = "SAJOIN"
+	SAMODE = "SAMODE"
+	SANICK = "SANICK"
 )
@@ -0,0 +1,192 @@
+// Copyright (c) Liam Stanley <me@liamstanley.io>. All rights reserved. Use
+// of this source code is governed by the MIT license that can be found in
+// the LICENSE file.
+
+package girc
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	// defaultCooldownInterval is the default value of
+	// CooldownLimit.Interval.
+	defaultCooldownInterval = 10 * time.Second
+	// maxCooldownKeys is the maximum amount of users or channels which are
+	// tracked by each limit of a Cooldown, after which fully cooled down
+	// ones are forgotten.
+	maxCooldownKeys = 500
+)
+
+// CooldownLimit is a leaky bucket limit: up to Burst uses are allowed at
+// once, after which one more use is allowed every Interval. A Burst of 0
+// disables the limit.
+type CooldownLimit struct {
+	Burst int
+	// Interval defaults to 10 seconds.
+	Interval time.Duration
+}
+
+// bucket returns a new bucket for the limit, or nil if it's disabled.
+func (l CooldownLimit) bucket() *tokenBucket {
+	if l.Burst <= 0 {
+		return nil
+	}
+
+	interval := l.Interval
+	if interval <= 0 {
+		interval = defaultCooldownInterval
+	}
+
+	return newTokenBucket(l.Burst, interval)
+}
+
+// Cooldown throttles the use of a handler, e.g. of a public trigger command,
+// so it can't be used to make us spam. Use a Cooldown for each command which
+// should be limited separately. See Cooldown.Wrap.
+type Cooldown struct {
+	// Match, if set, selects the events which the cooldown applies to, e.g.
+	// ACLCommand("!roll"). Other events are dropped by Cooldown.Wrap.
+	Match ACLRule
+	// PerUser limits the uses by each user, identified by their host.
+	PerUser CooldownLimit
+	// PerChannel limits the uses in each channel. Doesn't apply to private
+	// messages.
+	PerChannel CooldownLimit
+	// Global limits all uses.
+	Global CooldownLimit
+	// OnLimited, if set, is called when an event is dropped because of a
+	// limit, with the time until it would be allowed, e.g. to tell the user
+	// they're rate limited. It's only called once for each user until
+	// they're allowed again, so it can't be used to make us spam either.
+	OnLimited func(c *Client, e Event, wait time.Duration)
+
+	mu       sync.Mutex
+	global   *tokenBucket
+	users    map[string]*tokenBucket
+	channels map[string]*tokenBucket
+	// limited are the users which OnLimited has been called for, since they
+	// were last allowed.
+	limited map[string]bool
+}
+
+// lookup returns the bucket of key, creating it from limit if needed.
+// Always use Cooldown.mu for transaction.
+func (cd *Cooldown) lookup(buckets *map[string]*tokenBucket, key string, limit CooldownLimit) *tokenBucket {
+	if *buckets == nil {
+		*buckets = make(map[string]*tokenBucket)
+	}
+
+	bucket := (*buckets)[key]
+	if bucket == nil {
+		if bucket = limit.bucket(); bucket == nil {
+			return nil
+		}
+
+		pruneBuckets(*buckets, maxCooldownKeys)
+		(*buckets)[key] = bucket
+	}
+
+	return bucket
+}
+
+// Allow returns true if the event is within the limits, in which case the
+// use is counted against them. Otherwise, it returns the time until the
+// event would be allowed. Users and channels are compared with the
+// casemapping of the server c is connected to.
+func (cd *Cooldown) Allow(c *Client, e Event) (ok bool, wait time.Duration) {
+	casemapping := c.casemapping()
+
+	cd.mu.Lock()
+	defer cd.mu.Unlock()
+
+	var buckets []*tokenBucket
+
+	if cd.global == nil {
+		cd.global = cd.Global.bucket()
+	}
+	if cd.global != nil {
+		buckets = append(buckets, cd.global)
+	}
+
+	if e.Source != nil {
+		if bucket := cd.lookup(&cd.users, limitKey(casemapping, e.Source), cd.PerUser); bucket != nil {
+			buckets = append(buckets, bucket)
+		}
+	}
+
+	if len(e.Params) > 0 && IsValidChannel(e.Params[0]) {
+		if bucket := cd.lookup(&cd.channels, ToLower(casemapping, e.Params[0]), cd.PerChannel); bucket != nil {
+			buckets = append(buckets, bucket)
+		}
+	}
+
+	for _, bucket := range buckets {
+		if w := bucket.wait(); w > wait {
+			wait = w
+		}
+	}
+
+	if wait > 0 {
+		return false, wait
+	}
+
+	for _, bucket := range buckets {
+		bucket.take()
+	}
+
+	return true, 0
+}
+
+// Wrap returns a handler which only executes handler for events which are
+// matched (see Cooldown.Match) and within the limits of the cooldown. For
+// example:
+//
+//	cd := &girc.Cooldown{
+//		Match:      girc.ACLCommand("!roll"),
+//		PerUser:    girc.CooldownLimit{Burst: 2, Interval: 30 * time.Second},
+//		PerChannel: girc.CooldownLimit{Burst: 5},
+//		OnLimited: func(c *girc.Client, e girc.Event, wait time.Duration) {
+//			c.Commands.Noticef(e.Source.Name, "You're rate limited, try again in %s.", wait)
+//		},
+//	}
+//	client.Handlers.AddHandler(girc.PRIVMSG, cd.Wrap(girc.HandlerFunc(roll)))
+func (cd *Cooldown) Wrap(handler Handler) Handler {
+	return HandlerFunc(func(c *Client, e Event) {
+		if cd.Match != nil && !cd.Match(c, e) {
+			return
+		}
+
+		ok, wait := cd.Allow(c, e)
+
+		var key string
+		if e.Source != nil {
+			key = limitKey(c.casemapping(), e.Source)
+		}
+
+		cd.mu.Lock()
+		notify := !ok && !cd.limited[key]
+		if ok {
+			delete(cd.limited, key)
+		} else {
+			if cd.limited == nil {
+				cd.limited = make(map[string]bool)
+			}
+			if len(cd.limited) >= maxCooldownKeys {
+				cd.limited = make(map[string]bool)
+			}
+			cd.limited[key] = true
+		}
+		cd.mu.Unlock()
+
+		if ok {
+			handler.Execute(c, e)
+			return
+		}
+
+		if notify && cd.OnLimited != nil {
+			cd.OnLimited(c, e, wait)
+		}
+	})
+}
@@ -0,0 +1,95 @@
+// Copyright (c) Liam Stanley <me@liamstanley.io>. All rights reserved. Use
+// of this source code is governed by the MIT license that can be found in
+// the LICENSE file.
+
+package girc
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCooldown(t *testing.T) {
+	c := New(Config{Server: "irc.example.com", Nick: "nick", User: "user"})
+	cd := &Cooldown{
+		PerUser:    CooldownLimit{Burst: 2, Interval: time.Hour},
+		PerChannel: CooldownLimit{Burst: 3, Interval: time.Hour},
+		Global:     CooldownLimit{Burst: 4, Interval: time.Hour},
+	}
+
+	tests := []struct {
+		line string
+		want bool
+	}{
+		{line: ":one!user@one.example.com PRIVMSG #channel :!roll", want: true},
+		{line: ":one!user@one.example.com PRIVMSG #channel :!roll", want: true},
+		// Per user, even after changing nickname.
+		{line: ":renamed!user@one.example.com PRIVMSG #channel :!roll", want: false},
+		{line: ":two!user@two.example.com PRIVMSG #channel :!roll", want: true},
+		// Per channel.
+		{line: ":three!user@three.example.com PRIVMSG #channel :!roll", want: false},
+		{line: ":three!user@three.example.com PRIVMSG nick :!roll", want: true},
+		// Global.
+		{line: ":four!user@four.example.com PRIVMSG #other :!roll", want: false},
+	}
+
+	for _, tt := range tests {
+		ok, wait := cd.Allow(c, *ParseEvent(tt.line))
+		if ok != tt.want || (!ok && wait <= 0) {
+			t.Errorf("Allow(%q) = %t, %s, want %t", tt.line, ok, wait, tt.want)
+		}
+	}
+}
+
+func TestCooldownCasemapping(t *testing.T) {
+	c := New(Config{Server: "irc.example.com", Nick: "nick", User: "user"})
+	cd := &Cooldown{PerChannel: CooldownLimit{Burst: 1, Interval: time.Hour}}
+
+	if ok, _ := cd.Allow(c, *ParseEvent(":one PRIVMSG #a[b] :!roll")); !ok {
+		t.Fatal("Allow() in #a[b] = false, want true")
+	}
+
+	if ok, _ := cd.Allow(c, *ParseEvent(":two PRIVMSG #A{B} :!roll")); ok {
+		t.Fatal("Allow() in #A{B} = true, want the limit of #a[b] with rfc1459")
+	}
+
+	// With ascii, these are different channels.
+	c.state.mu.Lock()
+	c.state.isupport.Casemapping = CaseMappingASCII
+	c.state.mu.Unlock()
+
+	if ok, _ := cd.Allow(c, *ParseEvent(":three PRIVMSG #A[B] :!roll")); !ok {
+		t.Fatal("Allow() in #A[B] = false, want true with ascii")
+	}
+
+	if ok, _ := cd.Allow(c, *ParseEvent(":four PRIVMSG #a[b] :!roll")); ok {
+		t.Fatal("Allow() in #a[b] = true, want the limit of #A[B] with ascii")
+	}
+}
+
+func TestCooldownWrap(t *testing.T) {
+	c, server := mockClient(t, Config{})
+	defer c.Stop()
+
+	executed := make(chan string, 10)
+	limited := make(chan time.Duration, 10)
+
+	cd := &Cooldown{
+		Match:     ACLCommand("!roll"),
+		PerUser:   CooldownLimit{Burst: 1, Interval: time.Hour},
+		OnLimited: func(c *Client, e Event, wait time.Duration) { limited <- wait },
+	}
+	c.Handlers.AddHandler(PRIVMSG, cd.Wrap(HandlerFunc(func(c *Client, e Event) { executed <- e.Trailing })))
+
+	for i := 0; i < 3; i++ {
+		server.send(":other!user@host PRIVMSG #channel :!roll")
+	}
+	server.send(":other!user@host PRIVMSG #channel :hello")
+	server.send("PING :sync")
+
+	server.expect("PONG sync")
+
+	if len(executed) != 1 || len(limited) != 1 {
+		t.Fatalf("handler executed %d times, OnLimited called %d times, want 1 each", len(executed), len(limited))
+	}
+}
@@ -5,10 +5,15 @@
 package girc
 
 import (
+	"errors"
+	"fmt"
 	"runtime"
+	"sort"
 	"strings"
 	"sync"
 	"time"
+
+	"golang.org/x/net/context"
 )
 
 // ctcpDelim if the delimiter used for CTCP formatted events/messages.
@@ -29,9 +34,9 @@ type CTCPEvent struct {
 	Reply bool
 }
 
-// decodeCTCP decodes an incoming CTCP event, if it is CTCP. nil is returned
+// DecodeCTCP decodes an incoming CTCP event, if it is CTCP. nil is returned
 // if the incoming event does not match a valid CTCP.
-func decodeCTCP(e *Event) *CTCPEvent {
+func DecodeCTCP(e *Event) *CTCPEvent {
 	// http://www.irchelp.org/protocol/ctcpspec.html
 
 	// Must be targeting a user/channel, AND trailing must have
@@ -87,18 +92,19 @@ func decodeCTCP(e *Event) *CTCPEvent {
 	}
 }
 
-// encodeCTCP encodes a CTCP event into a string, including delimiters.
-func encodeCTCP(ctcp *CTCPEvent) (out string) {
+// EncodeCTCP encodes a CTCP event into a string, including delimiters, which
+// can be sent as the text of a PRIVMSG (query) or NOTICE (reply).
+func EncodeCTCP(ctcp *CTCPEvent) (out string) {
 	if ctcp == nil {
 		return ""
 	}
 
-	return encodeCTCPRaw(ctcp.Command, ctcp.Text)
+	return EncodeCTCPRaw(ctcp.Command, ctcp.Text)
 }
 
-// encodeCTCPRaw is much like encodeCTCP, however accepts a raw command and
+// EncodeCTCPRaw is much like EncodeCTCP, however accepts a raw command and
 // string as input.
-func encodeCTCPRaw(cmd, text string) (out string) {
+func EncodeCTCPRaw(cmd, text string) (out string) {
 	if len(cmd) <= 0 {
 		return ""
 	}
@@ -112,6 +118,226 @@ func encodeCTCPRaw(cmd, text string) (out string) {
 	return out + string(ctcpDelim)
 }
 
+// ErrCTCPFailed is returned by Commands.CTCPQuery when the user doesn't
+// exist, or responds with an ERRMSG reply.
+type ErrCTCPFailed struct {
+	// Nick is the nickname which was queried.
+	Nick string
+	// Command is the CTCP command which was sent.
+	Command string
+	// Reason is the reason the server or user supplied, if any.
+	Reason string
+}
+
+func (e *ErrCTCPFailed) Error() string {
+	return "ctcp " + e.Command + " to " + e.Nick + " failed: " + e.Reason
+}
+
+// CTCPQuery sends a CTCP query (e.g. CTCP_VERSION) to nick, and waits for
+// the reply, or until ctx is done. The reply text is returned, e.g. the
+// version of the users client. If nick doesn't exist, or responds with an
+// ERRMSG, ErrCTCPFailed is returned.
+func (cmd *Commands) CTCPQuery(ctx context.Context, nick, command, args string) (reply string, err error) {
+	if !IsValidNick(nick) {
+		return "", &ErrInvalidTarget{Target: nick}
+	}
+
+	command = strings.ToUpper(command)
+	if EncodeCTCPRaw(command, args) == "" {
+		return "", errors.New("invalid CTCP")
+	}
+
+	c := cmd.c
+	id := c.toLower(nick)
+
+	type result struct {
+		text string
+		err  error
+	}
+	done := make(chan result, 1)
+
+	cuid := c.Handlers.sregisterOwned(ALLEVENTS, HandlerFunc(func(client *Client, e Event) {
+		var res result
+
+		switch e.Command {
+		case NOTICE:
+			ctcp := DecodeCTCP(&e)
+			if ctcp == nil || ctcp.Source == nil || client.toLower(ctcp.Source.Name) != id {
+				return
+			}
+
+			switch ctcp.Command {
+			case command:
+				res.text = ctcp.Text
+			case CTCP_ERRMSG:
+				res.err = &ErrCTCPFailed{Nick: nick, Command: command, Reason: ctcp.Text}
+			default:
+				return
+			}
+		case ERR_NOSUCHNICK:
+			if len(e.Params) < 2 || client.toLower(e.Params[1]) != id {
+				return
+			}
+
+			res.err = &ErrCTCPFailed{Nick: nick, Command: command, Reason: e.Trailing}
+		default:
+			return
+		}
+
+		select {
+		case done <- res:
+		default:
+			// Already finished.
+		}
+	}))
+	defer c.Handlers.Remove(cuid)
+
+	if err = cmd.SendCTCP(nick, command, args); err != nil {
+		return "", err
+	}
+
+	select {
+	case res := <-done:
+		return res.text, res.err
+	case <-ctx.Done():
+		return "", ctx.Err()
+	}
+}
+
+const (
+	// defaultCTCPSourceBurst is the default value of CTCPLimits.PerSource.
+	defaultCTCPSourceBurst = 3
+	// defaultCTCPGlobalBurst is the default value of CTCPLimits.Global.
+	defaultCTCPGlobalBurst = 10
+	// defaultCTCPInterval is the default value of CTCPLimits.Interval.
+	defaultCTCPInterval = 5 * time.Second
+	// maxCTCPSources is the maximum amount of sources which are rate
+	// limited separately. Once exceeded, sources which haven't sent queries
+	// recently are forgotten.
+	maxCTCPSources = 500
+)
+
+// CTCPLimits limits how often the default CTCP responders (see
+// CTCP.ClearAll) reply to queries, both per user and in total. Queries
+// over the limit are dropped. Handlers added with CTCP.Register are not
+// limited.
+type CTCPLimits struct {
+	// PerSource is the amount of replies which can be sent to a single host
+	// at once, before further queries from the host are dropped. Defaults
+	// to 3, -1 disables the limit.
+	PerSource int
+	// Global is the amount of replies which can be sent at once (to all
+	// users), before further queries are dropped. Defaults to 10, -1
+	// disables the limit.
+	Global int
+	// Interval is how often one more reply is allowed, once the burst is
+	// used up. Defaults to 5s.
+	Interval time.Duration
+	// OnDrop is called for each query which is dropped. It may be called
+	// concurrently. See also CTCP.Dropped.
+	OnDrop func(ctcp CTCPEvent)
+}
+
+// ctcpLimiter rate limits the replies of the default CTCP responders. See
+// CTCPLimits.
+type ctcpLimiter struct {
+	mu      sync.Mutex
+	global  *tokenBucket
+	sources map[string]*tokenBucket
+	dropped uint64
+}
+
+// allow returns true if a reply to source is within limits, in which case
+// the reply is counted against them. Nicknames are compared with
+// casemapping.
+func (l *ctcpLimiter) allow(casemapping string, limits CTCPLimits, source *Source) bool {
+	if limits.PerSource == 0 {
+		limits.PerSource = defaultCTCPSourceBurst
+	}
+
+	if limits.Global == 0 {
+		limits.Global = defaultCTCPGlobalBurst
+	}
+
+	if limits.Interval <= 0 {
+		limits.Interval = defaultCTCPInterval
+	}
+
+	key := limitKey(casemapping, source)
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	var global, perSource *tokenBucket
+	if limits.Global > 0 {
+		if l.global == nil {
+			l.global = newTokenBucket(limits.Global, limits.Interval)
+		}
+		global = l.global
+	}
+
+	if limits.PerSource > 0 {
+		if l.sources == nil {
+			l.sources = make(map[string]*tokenBucket)
+		}
+
+		if perSource = l.sources[key]; perSource == nil {
+			pruneBuckets(l.sources, maxCTCPSources)
+			perSource = newTokenBucket(limits.PerSource, limits.Interval)
+			l.sources[key] = perSource
+		}
+	}
+
+	if (global != nil && global.wait() > 0) || (perSource != nil && perSource.wait() > 0) {
+		l.dropped++
+		return false
+	}
+
+	if global != nil {
+		global.take()
+	}
+
+	if perSource != nil {
+		perSource.take()
+	}
+
+	return true
+}
+
+// limitKey returns the key which source is rate limited by: its host if
+// known (so changing nickname doesn't reset the limit), otherwise its
+// nickname, converted to lowercase with casemapping.
+func limitKey(casemapping string, source *Source) string {
+	key := source.Name
+	if source.Host != "" {
+		key = source.Host
+	}
+
+	return ToLower(casemapping, key)
+}
+
+// pruneBuckets forgets about the buckets which have been fully refilled,
+// once there are max or more of them, so they don't grow without bound.
+func pruneBuckets(buckets map[string]*tokenBucket, max int) {
+	if len(buckets) < max {
+		return
+	}
+
+	for key, bucket := range buckets {
+		if bucket.refill(); bucket.tokens >= float64(bucket.burst) {
+			delete(buckets, key)
+		}
+	}
+
+	// Still too many, so forget about any bucket.
+	for key := range buckets {
+		if len(buckets) < max {
+			break
+		}
+		delete(buckets, key)
+	}
+}
+
 // CTCP handles the storage and execution of CTCP handlers against incoming
 // CTCP events.
 type CTCP struct {
@@ -119,6 +345,17 @@ type CTCP struct {
 	mu sync.RWMutex
 	// handlers is a map of CTCP message -> functions.
 	handlers map[string]CTCPHandler
+	// limiter rate limits the replies of the default handlers.
+	limiter ctcpLimiter
+}
+
+// Dropped returns the amount of CTCP queries which the default responders
+// didn't reply to, as they exceeded the limits (see Config.CTCPLimits).
+func (c *CTCP) Dropped() uint64 {
+	c.limiter.mu.Lock()
+	defer c.limiter.mu.Unlock()
+
+	return c.limiter.dropped
 }
 
 // newCTCP returns a new clean CTCP handler.
@@ -133,8 +370,13 @@ func (c *CTCP) call(client *Client, event *CTCPEvent) {
 	defer c.mu.RUnlock()
 
 	// If they want to catch any panics, add to defer stack.
-	if client.Config.RecoverFunc != nil && event.Origin != nil {
-		defer recoverHandlerPanic(client, event.Origin, "ctcp-"+strings.ToLower(event.Command), 3)
+	if client.Config.RecoverFunc != nil {
+		origin := event.Origin
+		if origin == nil {
+			origin = &Event{}
+		}
+
+		defer recoverHandlerPanic(client, origin, "ctcp-"+strings.ToLower(event.Command), 3)
 	}
 
 	// Support wildcard CTCP event handling. Gets executed first before
@@ -144,9 +386,10 @@ func (c *CTCP) call(client *Client, event *CTCPEvent) {
 	}
 
 	if _, ok := c.handlers[event.Command]; !ok {
-		// Send a ERRMSG reply, if we know who sent it.
-		if event.Source != nil && IsValidNick(event.Source.Name) {
-			client.Commands.SendCTCPReply(event.Source.Name, CTCP_ERRMSG, "that is an unknown CTCP query")
+		// Send a ERRMSG reply, if we know who sent it. Replies and actions
+		// are never answered, to avoid reply loops.
+		if !event.Reply && event.Command != CTCP_ACTION && event.Source != nil && IsValidNick(event.Source.Name) {
+			client.ctcpReply(*event, CTCP_ERRMSG, "that is an unknown CTCP query")
 		}
 		return
 	}
@@ -175,20 +418,28 @@ func (c *CTCP) parseCMD(cmd string) string {
 	return cmd
 }
 
-// Set saves handler for execution upon a matching incoming CTCP event.
-// Use SetBg if the handler may take an extended period of time to execute.
-// If you would like to have a handler which will catch ALL CTCP requests,
-// simply use "*" in place of the command.
-func (c *CTCP) Set(cmd string, handler func(client *Client, ctcp CTCPEvent)) {
-	if cmd = c.parseCMD(cmd); cmd == "" {
+// Register saves handler for execution upon a matching incoming CTCP event
+// (e.g. "VERSION"), replacing any existing handler for the command,
+// including the default responders. Use SetBg if the handler may take an
+// extended period of time to execute. If you would like to have a handler
+// which will catch ALL CTCP requests, simply use "*" in place of the
+// command. Handlers are called for both queries and replies (see
+// CTCPEvent.Reply).
+func (c *CTCP) Register(cmd string, handler CTCPHandler) {
+	if cmd = c.parseCMD(cmd); cmd == "" || handler == nil {
 		return
 	}
 
 	c.mu.Lock()
-	c.handlers[cmd] = CTCPHandler(handler)
+	c.handlers[cmd] = handler
 	c.mu.Unlock()
 }
 
+// Set is the same as Register.
+func (c *CTCP) Set(cmd string, handler func(client *Client, ctcp CTCPEvent)) {
+	c.Register(cmd, handler)
+}
+
 // SetBg is much like Set, however the handler is executed in the background,
 // ensuring that event handling isn't hung during long running tasks. See Set
 // for more information.
@@ -209,6 +460,22 @@ func (c *CTCP) Clear(cmd string) {
 	c.mu.Unlock()
 }
 
+// Commands returns the (sorted) CTCP commands which have a handler, e.g. for
+// CLIENTINFO replies.
+func (c *CTCP) Commands() []string {
+	c.mu.RLock()
+	cmds := make([]string, 0, len(c.handlers))
+	for cmd := range c.handlers {
+		if cmd != "*" {
+			cmds = append(cmds, cmd)
+		}
+	}
+	c.mu.RUnlock()
+
+	sort.Strings(cmds)
+	return cmds
+}
+
 // ClearAll removes all currently setup and re-sets the default handlers.
 func (c *CTCP) ClearAll() {
 	c.mu.Lock()
@@ -230,6 +497,46 @@ func (c *CTCP) addDefaultHandlers() {
 	c.SetBg(CTCP_VERSION, handleCTCPVersion)
 	c.SetBg(CTCP_SOURCE, handleCTCPSource)
 	c.SetBg(CTCP_TIME, handleCTCPTime)
+	c.SetBg(CTCP_CLIENTINFO, handleCTCPClientInfo)
+	c.SetBg(CTCP_DCC, handleCTCPDCC)
+}
+
+// ctcpDisabled returns true if the default responders shouldn't reply to
+// the CTCP command. See Config.DisableCTCP.
+func (c *Client) ctcpDisabled(cmd string) bool {
+	for _, disabled := range c.Config.DisableCTCP {
+		if disabled == "*" || strings.EqualFold(disabled, cmd) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// ctcpReply sends the reply of a default responder to a CTCP query, unless
+// disabled with Config.DisableCTCP, or rate limited (see Config.CTCPLimits).
+// The reply may be overridden with Config.CTCPReply.
+func (c *Client) ctcpReply(ctcp CTCPEvent, cmd, text string) {
+	if ctcp.Source == nil || c.ctcpDisabled(cmd) {
+		return
+	}
+
+	if !c.CTCP.limiter.allow(c.casemapping(), c.Config.CTCPLimits, ctcp.Source) {
+		c.debug.Printf("dropping ctcp %s from %s: rate limited", ctcp.Command, ctcp.Source)
+
+		if c.Config.CTCPLimits.OnDrop != nil {
+			c.Config.CTCPLimits.OnDrop(ctcp)
+		}
+		return
+	}
+
+	if c.Config.CTCPReply != nil {
+		if reply, ok := c.Config.CTCPReply(ctcp); ok {
+			text = reply
+		}
+	}
+
+	c.Commands.SendCTCPReply(ctcp.Source.Name, cmd, text)
 }
 
 // handleCTCPPing replies with a ping and whatever was originally requested.
@@ -237,7 +544,7 @@ func handleCTCPPing(client *Client, ctcp CTCPEvent) {
 	if ctcp.Reply {
 		return
 	}
-	client.Commands.SendCTCPReply(ctcp.Source.Name, CTCP_PING, ctcp.Text)
+	client.ctcpReply(ctcp, CTCP_PING, ctcp.Text)
 }
 
 // handleCTCPPong replies with a pong.
@@ -245,32 +552,66 @@ func handleCTCPPong(client *Client, ctcp CTCPEvent) {
 	if ctcp.Reply {
 		return
 	}
-	client.Commands.SendCTCPReply(ctcp.Source.Name, CTCP_PONG, "")
+	client.ctcpReply(ctcp, CTCP_PONG, "")
 }
 
 // handleCTCPVersion replies with the name of the client, Go version, as well
 // as the os type (darwin, linux, windows, etc) and architecture type (x86,
 // arm, etc).
 func handleCTCPVersion(client *Client, ctcp CTCPEvent) {
+	if ctcp.Reply {
+		return
+	}
+
 	if client.Config.Version != "" {
-		client.Commands.SendCTCPReply(ctcp.Source.Name, CTCP_VERSION, client.Config.Version)
+		client.ctcpReply(ctcp, CTCP_VERSION, client.Config.Version)
 		return
 	}
 
-	client.Commands.SendCTCPReplyf(
-		ctcp.Source.Name, CTCP_VERSION,
+	client.ctcpReply(ctcp, CTCP_VERSION, fmt.Sprintf(
 		"girc (github.com/lrstanley/girc) using %s (%s, %s)",
 		runtime.Version(), runtime.GOOS, runtime.GOARCH,
-	)
+	))
 }
 
 // handleCTCPSource replies with the public git location of this library.
 func handleCTCPSource(client *Client, ctcp CTCPEvent) {
-	client.Commands.SendCTCPReply(ctcp.Source.Name, CTCP_SOURCE, "https://github.com/lrstanley/girc")
+	if ctcp.Reply {
+		return
+	}
+
+	if client.Config.Source != "" {
+		client.ctcpReply(ctcp, CTCP_SOURCE, client.Config.Source)
+		return
+	}
+
+	client.ctcpReply(ctcp, CTCP_SOURCE, "https://github.com/lrstanley/girc")
 }
 
 // handleCTCPTime replies with a RFC 1123 (Z) formatted version of Go's
 // local time.
 func handleCTCPTime(client *Client, ctcp CTCPEvent) {
-	client.Commands.SendCTCPReply(ctcp.Source.Name, CTCP_TIME, ":"+time.Now().Format(time.RFC1123Z))
+	if ctcp.Reply {
+		return
+	}
+
+	client.ctcpReply(ctcp, CTCP_TIME, ":"+time.Now().Format(time.RFC1123Z))
+}
+
+// handleCTCPClientInfo replies with the CTCP commands we support, i.e. the
+// commands with a handler, and ACTION.
+func handleCTCPClientInfo(client *Client, ctcp CTCPEvent) {
+	if ctcp.Reply {
+		return
+	}
+
+	cmds := []string{CTCP_ACTION}
+	for _, cmd := range client.CTCP.Commands() {
+		if !client.ctcpDisabled(cmd) {
+			cmds = append(cmds, cmd)
+		}
+	}
+	sort.Strings(cmds)
+
+	client.ctcpReply(ctcp, CTCP_CLIENTINFO, strings.Join(cmds, " "))
 }
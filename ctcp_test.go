@@ -8,6 +8,8 @@ import (
 	"reflect"
 	"testing"
 	"time"
+
+	"golang.org/x/net/context"
 )
 
 func TestEncodeCTCP(t *testing.T) {
@@ -27,8 +29,8 @@ func TestEncodeCTCP(t *testing.T) {
 	}
 
 	for _, tt := range tests {
-		if got := encodeCTCP(tt.args.ctcp); got != tt.want {
-			t.Errorf("%s: encodeCTCP() = %q, want %q", tt.name, got, tt.want)
+		if got := EncodeCTCP(tt.args.ctcp); got != tt.want {
+			t.Errorf("%s: EncodeCTCP() = %q, want %q", tt.name, got, tt.want)
 		}
 	}
 }
@@ -93,13 +95,13 @@ func TestDecodeCTCP(t *testing.T) {
 	}
 
 	for _, tt := range tests {
-		got := decodeCTCP(tt.args.event)
+		got := DecodeCTCP(tt.args.event)
 		if got != nil {
 			got.Origin = tt.want.Origin
 		}
 
 		if !reflect.DeepEqual(got, tt.want) {
-			t.Errorf("%s: decodeCTCP() = %#v, want %#v", tt.name, got, tt.want)
+			t.Errorf("%s: DecodeCTCP() = %#v, want %#v", tt.name, got, tt.want)
 		}
 	}
 }
@@ -183,3 +185,190 @@ func TestClearAll(t *testing.T) {
 		t.Fatalf("ctcp.ClearAll() didn't remove all handlers: 1: %v 2: %v", first, second)
 	}
 }
+
+func TestCTCPDefaults(t *testing.T) {
+	c, server := mockClient(t, Config{})
+	defer c.Stop()
+
+	c.CTCP.Register(CTCP_VERSION, func(client *Client, ctcp CTCPEvent) {
+		if !ctcp.Reply {
+			client.Commands.SendCTCPReply(ctcp.Source.Name, CTCP_VERSION, "custom")
+		}
+	})
+
+	server.send(":irc.example.com 001 nick :Welcome")
+
+	server.send(":other!user@host PRIVMSG nick :\x01CLIENTINFO\x01")
+	server.expect("NOTICE other :\x01CLIENTINFO ACTION CLIENTINFO DCC PING PONG SOURCE TIME VERSION\x01")
+
+	server.send(":other!user@host PRIVMSG nick :\x01VERSION\x01")
+	server.expect("NOTICE other :\x01VERSION custom\x01")
+
+	// Unknown replies and actions aren't answered with ERRMSG.
+	server.send(":other!user@host NOTICE nick :\x01UNKNOWN reply\x01")
+	server.send(":other!user@host PRIVMSG nick :\x01ACTION waves\x01")
+	server.send("PING :sync")
+	if line := server.expect(""); line != "PONG sync" {
+		t.Fatalf("unexpected line after CTCP reply and action: %q", line)
+	}
+
+	server.send(":other!user@host PRIVMSG nick :\x01UNKNOWN\x01")
+	server.expect("NOTICE other :\x01ERRMSG that is an unknown CTCP query\x01")
+}
+
+func TestCTCPConfig(t *testing.T) {
+	c, server := mockClient(t, Config{
+		Source:      "https://example.com/bot",
+		DisableCTCP: []string{CTCP_VERSION, "time", CTCP_ERRMSG},
+		CTCPReply: func(ctcp CTCPEvent) (string, bool) {
+			if ctcp.Command == CTCP_PING {
+				return "overridden", true
+			}
+			return "", false
+		},
+	})
+	defer c.Stop()
+
+	server.send(":irc.example.com 001 nick :Welcome")
+
+	server.send(":other!user@host PRIVMSG nick :\x01SOURCE\x01")
+	server.expect("NOTICE other :\x01SOURCE https://example.com/bot\x01")
+
+	server.send(":other!user@host PRIVMSG nick :\x01PING 123\x01")
+	server.expect("NOTICE other :\x01PING overridden\x01")
+
+	server.send(":other!user@host PRIVMSG nick :\x01CLIENTINFO\x01")
+	server.expect("NOTICE other :\x01CLIENTINFO ACTION CLIENTINFO DCC PING PONG SOURCE\x01")
+
+	// Disabled queries aren't answered.
+	server.send(":other!user@host PRIVMSG nick :\x01VERSION\x01")
+	server.send(":other!user@host PRIVMSG nick :\x01TIME\x01")
+	server.send(":other!user@host PRIVMSG nick :\x01UNKNOWN\x01")
+
+	// The default responders run in the background, so give them a moment.
+	time.Sleep(100 * time.Millisecond)
+	server.send("PING :sync")
+	if line := server.expect(""); line != "PONG sync" {
+		t.Fatalf("unexpected line after disabled CTCP queries: %q", line)
+	}
+}
+
+func TestCTCPQuery(t *testing.T) {
+	c, server := mockClient(t, Config{})
+	defer c.Stop()
+
+	server.send(":irc.example.com 001 nick :Welcome")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	type result struct {
+		reply string
+		err   error
+	}
+	results := make(chan result, 1)
+	query := func(nick, command, args string) {
+		go func() {
+			reply, err := c.Commands.CTCPQuery(ctx, nick, command, args)
+			results <- result{reply, err}
+		}()
+	}
+
+	query("Other", "version", "")
+	server.expect("PRIVMSG Other :\x01VERSION\x01")
+	server.send(":someone!user@host NOTICE nick :\x01VERSION wrong user\x01")
+	server.send(":other!user@host NOTICE nick :\x01TIME wrong command\x01")
+	server.send(":other!user@host NOTICE nick :\x01VERSION client 1.0\x01")
+
+	if res := <-results; res.err != nil || res.reply != "client 1.0" {
+		t.Fatalf("CTCPQuery() = %q, %v, want %q", res.reply, res.err, "client 1.0")
+	}
+
+	query("missing", CTCP_PING, "123")
+	server.expect("PRIVMSG missing :\x01PING 123\x01")
+	server.send(":irc.example.com 401 nick missing :No such nick/channel")
+
+	if res := <-results; res.err == nil {
+		t.Fatal("CTCPQuery() to missing user returned no error")
+	} else if _, ok := res.err.(*ErrCTCPFailed); !ok {
+		t.Fatalf("CTCPQuery() to missing user returned %#v, want *ErrCTCPFailed", res.err)
+	}
+
+	// With ascii, "a{b}" is someone else than "a[b]".
+	c.state.mu.Lock()
+	c.state.isupport.Casemapping = CaseMappingASCII
+	c.state.mu.Unlock()
+
+	query("a[b]", "version", "")
+	server.expect("PRIVMSG a[b] :\x01VERSION\x01")
+	server.send(":a{b}!user@host NOTICE nick :\x01VERSION wrong user\x01")
+	server.send(":irc.example.com 401 nick a{b} :No such nick/channel")
+	server.send(":A[B]!user@host NOTICE nick :\x01VERSION client 2.0\x01")
+
+	if res := <-results; res.err != nil || res.reply != "client 2.0" {
+		t.Fatalf("CTCPQuery() = %q, %v, want %q", res.reply, res.err, "client 2.0")
+	}
+}
+
+func TestCTCPLimits(t *testing.T) {
+	drops := make(chan CTCPEvent, 10)
+	c, server := mockClient(t, Config{AllowFlood: true, CTCPLimits: CTCPLimits{
+		PerSource: 2,
+		Global:    3,
+		Interval:  time.Hour,
+		OnDrop:    func(ctcp CTCPEvent) { drops <- ctcp },
+	}})
+	defer c.Stop()
+
+	server.send(":irc.example.com 001 nick :Welcome")
+
+	for _, line := range []string{
+		":one!user@one.example.com PRIVMSG nick :\x01PING 1\x01",
+		":one!user@one.example.com PRIVMSG nick :\x01PING 2\x01",
+		":renamed!user@ONE.example.com PRIVMSG nick :\x01PING 3\x01", // same host
+		":two!user@two.example.com PRIVMSG nick :\x01PING 4\x01",
+		":two!user@two.example.com PRIVMSG nick :\x01PING 5\x01", // over the global limit
+	} {
+		server.send(line)
+	}
+
+	for i := 0; i < 2; i++ {
+		select {
+		case <-drops:
+		case <-time.After(2 * time.Second):
+			t.Fatalf("OnDrop called %d times, want 2", i)
+		}
+	}
+
+	for i := 0; i < 3; i++ {
+		server.expect("NOTICE ")
+	}
+
+	server.send("PING :sync")
+	if line := server.expect(""); line != "PONG sync" {
+		t.Fatalf("unexpected line after rate limited CTCP queries: %q", line)
+	}
+
+	if dropped := c.CTCP.Dropped(); dropped != 2 {
+		t.Fatalf("CTCP.Dropped() = %d, want 2", dropped)
+	}
+}
+
+func TestLimitKey(t *testing.T) {
+	cases := []struct {
+		casemapping string
+		a, b        *Source
+		same        bool
+	}{
+		{CaseMappingRFC1459, &Source{Name: "a[b]"}, &Source{Name: "A{B}"}, true},
+		{CaseMappingASCII, &Source{Name: "a[b]"}, &Source{Name: "A{B}"}, false},
+		{CaseMappingASCII, &Source{Name: "a[b]"}, &Source{Name: "A[B]"}, true},
+		{CaseMappingASCII, &Source{Name: "one", Host: "Example.com"}, &Source{Name: "two", Host: "example.COM"}, true},
+	}
+
+	for _, tt := range cases {
+		if same := limitKey(tt.casemapping, tt.a) == limitKey(tt.casemapping, tt.b); same != tt.same {
+			t.Errorf("%s: limitKey(%s) == limitKey(%s) is %t, want %t", tt.casemapping, tt.a, tt.b, same, tt.same)
+		}
+	}
+}
@@ -0,0 +1,684 @@
+// Copyright (c) Liam Stanley <me@liamstanley.io>. All rights reserved. Use
+// of this source code is governed by the MIT license that can be found in
+// the LICENSE file.
+
+package girc
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+// DCC subcommands, sent as the first argument of a CTCP DCC message.
+const (
+	dccSend   = "SEND"
+	dccResume = "RESUME"
+	dccAccept = "ACCEPT"
+)
+
+const (
+	// dccBufferSize is the size of the chunks files are transferred in.
+	dccBufferSize = 32 * 1024
+	// dccTokenLength is the length of the tokens of passive DCC offers.
+	dccTokenLength = 8
+)
+
+// ErrDCCNoAddress is returned when a DCC transfer needs to listen for a
+// connection, but we don't know the address to advertise to the other user.
+// See DCCOptions.IP.
+var ErrDCCNoAddress = errors.New("unable to determine address to advertise for dcc")
+
+// DCCOffer is an offer of a file with DCC SEND, which can be received with
+// DCC.Accept. See Config.HandleDCCOffer.
+type DCCOffer struct {
+	// Source is the user who offered the file.
+	Source *Source
+	// Filename is the name of the file, without any directories, and never
+	// "." or "..". Make sure to sanitize it further before using it as a
+	// local path.
+	Filename string
+	// IP and Port are the address to connect to, to receive the file. Port
+	// is 0 for passive offers (see Passive).
+	IP   net.IP
+	Port int
+	// Size is the size of the file in bytes, or 0 if unknown.
+	Size int64
+	// Token identifies a passive offer.
+	Token string
+}
+
+// Passive returns true if the offer is a passive (or "reverse") offer, where
+// the receiver listens for a connection instead of the sender, e.g. as the
+// sender is behind a NAT.
+func (o *DCCOffer) Passive() bool {
+	return o.Port == 0 && o.Token != ""
+}
+
+// DCCOptions are the options of a DCC transfer. See DCC.Send and DCC.Accept.
+type DCCOptions struct {
+	// IP is the address advertised to the other user, when we listen for
+	// the connection. Defaults to the local address of the connection to
+	// the IRC server, which may need to be overridden when behind a NAT.
+	IP net.IP
+	// ListenAddr is the address we listen on, when listening for the
+	// connection, e.g. ":5000". Defaults to a random port.
+	ListenAddr string
+	// Passive is used by DCC.Send, to let the receiver listen for the
+	// connection instead, which is useful if we're behind a NAT.
+	Passive bool
+	// Offset is used by DCC.Accept, to resume a partial transfer from the
+	// offset (in bytes) with DCC RESUME. The sender may not support
+	// resuming, in which case the transfer won't start.
+	Offset int64
+	// Rate limits the transfer to the amount of bytes per second. No limit
+	// if 0.
+	Rate int64
+	// Progress is called each time a chunk of the file is transferred, with
+	// the amount of bytes transferred so far (including Offset), and the
+	// total size of the file (0 if unknown).
+	Progress func(transferred, total int64)
+}
+
+// DCC sends and receives files using DCC SEND, including resuming transfers
+// (DCC RESUME and ACCEPT), and passive DCC. Offers from other users are
+// passed to Config.HandleDCCOffer.
+type DCC struct {
+	c  *Client
+	mu sync.Mutex
+	// tokens are the tokens of our pending passive offers, so the replies
+	// to them aren't mistaken for offers.
+	tokens map[string]struct{}
+}
+
+// newDCC returns a new DCC, without any pending offers.
+func newDCC(c *Client) *DCC {
+	return &DCC{c: c, tokens: make(map[string]struct{})}
+}
+
+// ParseDCCSend parses a DCC SEND offer. An error is returned if the CTCP
+// event isn't a valid DCC SEND.
+func ParseDCCSend(ctcp CTCPEvent) (*DCCOffer, error) {
+	if ctcp.Command != CTCP_DCC {
+		return nil, errors.New("not a dcc message: " + ctcp.Command)
+	}
+
+	sub, filename, args := splitDCC(ctcp.Text)
+	if sub != dccSend || filename == "" || len(args) < 3 {
+		return nil, errors.New("invalid dcc send: " + ctcp.Text)
+	}
+
+	// Only keep the base name, so the offer can't point outside of the
+	// directory it's saved to.
+	name := filepath.Base(filename)
+	if name == "." || name == ".." || name == string(filepath.Separator) {
+		return nil, errors.New("invalid dcc filename: " + filename)
+	}
+
+	offer := &DCCOffer{Source: ctcp.Source, Filename: name}
+
+	offer.IP = parseDCCAddress(args[0])
+	if offer.IP == nil {
+		return nil, errors.New("invalid dcc address: " + args[0])
+	}
+
+	var err error
+	if offer.Port, err = strconv.Atoi(args[1]); err != nil || offer.Port < 0 || offer.Port > 65535 {
+		return nil, errors.New("invalid dcc port: " + args[1])
+	}
+
+	if offer.Size, err = strconv.ParseInt(args[2], 10, 64); err != nil || offer.Size < 0 {
+		return nil, errors.New("invalid dcc size: " + args[2])
+	}
+
+	if len(args) > 3 {
+		offer.Token = args[3]
+	}
+
+	if offer.Port == 0 && offer.Token == "" {
+		return nil, errors.New("passive dcc offer without token")
+	}
+
+	return offer, nil
+}
+
+// splitDCC splits the text of a CTCP DCC message into the subcommand, the
+// filename (which may be quoted) and the remaining arguments.
+func splitDCC(text string) (sub, filename string, args []string) {
+	i := strings.IndexByte(text, eventSpace)
+	if i < 0 {
+		return strings.ToUpper(text), "", nil
+	}
+
+	sub, text = strings.ToUpper(text[:i]), strings.TrimLeft(text[i+1:], " ")
+
+	if len(text) > 0 && text[0] == 0x22 { // "
+		if j := strings.IndexByte(text[1:], 0x22); j > -1 {
+			return sub, text[1 : j+1], strings.Fields(text[j+2:])
+		}
+	}
+
+	fields := strings.Fields(text)
+	if len(fields) == 0 {
+		return sub, "", nil
+	}
+
+	return sub, fields[0], fields[1:]
+}
+
+// formatDCC returns the text of a CTCP DCC message, quoting the filename if
+// necessary.
+func formatDCC(sub, filename string, args ...string) string {
+	if strings.IndexByte(filename, eventSpace) > -1 {
+		filename = `"` + filename + `"`
+	}
+
+	return sub + " " + filename + " " + strings.Join(args, " ")
+}
+
+// parseDCCAddress parses an address of a DCC offer, which is either an IPv4
+// address as an integer, or an IPv6 address. Some clients use dotted IPv4
+// addresses instead.
+func parseDCCAddress(addr string) net.IP {
+	if n, err := strconv.ParseUint(addr, 10, 32); err == nil {
+		ip := make(net.IP, net.IPv4len)
+		binary.BigEndian.PutUint32(ip, uint32(n))
+		return ip
+	}
+
+	return net.ParseIP(addr)
+}
+
+// formatDCCAddress returns ip as used in DCC offers.
+func formatDCCAddress(ip net.IP) string {
+	if ip == nil {
+		return "0"
+	}
+
+	if ip4 := ip.To4(); ip4 != nil {
+		return strconv.FormatUint(uint64(binary.BigEndian.Uint32(ip4)), 10)
+	}
+
+	return ip.String()
+}
+
+// advertisedIP returns the address we advertise to other users, when we
+// listen for connections.
+func (d *DCC) advertisedIP(opts DCCOptions) net.IP {
+	if opts.IP != nil {
+		return opts.IP
+	}
+
+	if d.c.conn == nil || d.c.conn.sock == nil {
+		return nil
+	}
+
+	if addr, ok := d.c.conn.sock.LocalAddr().(*net.TCPAddr); ok && !addr.IP.IsUnspecified() {
+		return addr.IP
+	}
+
+	return nil
+}
+
+// await calls fn with the filename and arguments of each DCC message with
+// the subcommand sub that nick (compared with the casemapping of the server)
+// sends us, until the returned function is called. fn is called from the
+// event handler goroutine.
+func (d *DCC) await(nick, sub string, fn func(filename string, args []string)) (remove func()) {
+	id := d.c.toLower(nick)
+	cuid := d.c.Handlers.sregisterOwned(PRIVMSG, HandlerFunc(func(client *Client, e Event) {
+		ctcp := DecodeCTCP(&e)
+		if ctcp == nil || ctcp.Command != CTCP_DCC || ctcp.Source == nil || client.toLower(ctcp.Source.Name) != id {
+			return
+		}
+
+		if s, filename, args := splitDCC(ctcp.Text); s == sub {
+			fn(filename, args)
+		}
+	}))
+
+	return func() { d.c.Handlers.Remove(cuid) }
+}
+
+// dccResumeRequest is a request to resume a transfer (DCC RESUME) or the
+// acceptance of such a request (DCC ACCEPT).
+type dccResumeRequest struct {
+	port     string
+	position int64
+	token    string
+}
+
+// awaitResume sends the DCC RESUME or ACCEPT messages (sub) from nick, which
+// match the port (or token, for passive transfers), to the returned channel.
+func (d *DCC) awaitResume(nick, sub, port, token string) (<-chan dccResumeRequest, func()) {
+	requests := make(chan dccResumeRequest, 1)
+
+	remove := d.await(nick, sub, func(filename string, args []string) {
+		if len(args) < 2 {
+			return
+		}
+
+		req := dccResumeRequest{port: args[0]}
+		if len(args) > 2 {
+			req.token = args[2]
+		}
+
+		var err error
+		if req.position, err = strconv.ParseInt(args[1], 10, 64); err != nil || req.position < 0 {
+			return
+		}
+
+		if (token != "" && req.token != token) || (token == "" && req.port != port) {
+			return
+		}
+
+		select {
+		case requests <- req:
+		default:
+		}
+	})
+
+	return requests, remove
+}
+
+// listen listens for a DCC connection, returning the address to advertise.
+func (d *DCC) listen(opts DCCOptions) (net.Listener, net.IP, int, error) {
+	ip := d.advertisedIP(opts)
+	if ip == nil {
+		return nil, nil, 0, ErrDCCNoAddress
+	}
+
+	addr := opts.ListenAddr
+	if addr == "" {
+		addr = ":0"
+	}
+
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, nil, 0, err
+	}
+
+	return ln, ip, ln.Addr().(*net.TCPAddr).Port, nil
+}
+
+// acceptConn accepts a single connection on ln, closing ln afterwards.
+func acceptConn(ln net.Listener) <-chan net.Conn {
+	conns := make(chan net.Conn, 1)
+
+	go func() {
+		defer ln.Close()
+
+		conn, err := ln.Accept()
+		if err != nil {
+			close(conns)
+			return
+		}
+
+		conns <- conn
+	}()
+
+	return conns
+}
+
+// Send offers a file to nick with DCC SEND, and sends it once they accept
+// the offer, or until ctx is done. Only the base name of filename is sent
+// to the other user. size is the size of the file, which is read from r.
+// If the other user asks to resume a partial transfer (DCC RESUME), the
+// file is sent from the requested offset. The amount of bytes sent is
+// returned.
+func (d *DCC) Send(ctx context.Context, nick, filename string, r io.ReaderAt, size int64, opts DCCOptions) (int64, error) {
+	if !IsValidNick(nick) {
+		return 0, &ErrInvalidTarget{Target: nick}
+	}
+
+	name := filepath.Base(filename)
+	sizeArg := strconv.FormatInt(size, 10)
+
+	var conn net.Conn
+	var offset int64
+
+	if opts.Passive {
+		token := randomRef(dccTokenLength)
+
+		d.mu.Lock()
+		d.tokens[token] = struct{}{}
+		d.mu.Unlock()
+
+		defer func() {
+			d.mu.Lock()
+			delete(d.tokens, token)
+			d.mu.Unlock()
+		}()
+
+		resumes, removeResume := d.awaitResume(nick, dccResume, "0", token)
+		defer removeResume()
+
+		replies := make(chan *DCCOffer, 1)
+		removeReply := d.await(nick, dccSend, func(filename string, args []string) {
+			offer, err := ParseDCCSend(CTCPEvent{Command: CTCP_DCC, Text: formatDCC(dccSend, filename, args...)})
+			if err != nil || offer.Token != token || offer.Port == 0 {
+				return
+			}
+
+			select {
+			case replies <- offer:
+			default:
+			}
+		})
+		defer removeReply()
+
+		d.c.Commands.SendCTCP(nick, CTCP_DCC, formatDCC(dccSend, name, formatDCCAddress(d.advertisedIP(opts)), "0", sizeArg, token))
+
+		for conn == nil {
+			select {
+			case req := <-resumes:
+				offset = req.position
+				d.c.Commands.SendCTCP(nick, CTCP_DCC, formatDCC(dccAccept, name, "0", strconv.FormatInt(offset, 10), token))
+			case offer := <-replies:
+				var err error
+				conn, err = (&net.Dialer{}).DialContext(ctx, "tcp", net.JoinHostPort(offer.IP.String(), strconv.Itoa(offer.Port)))
+				if err != nil {
+					return 0, err
+				}
+			case <-ctx.Done():
+				return 0, ctx.Err()
+			}
+		}
+	} else {
+		ln, ip, port, err := d.listen(opts)
+		if err != nil {
+			return 0, err
+		}
+		defer ln.Close()
+
+		portArg := strconv.Itoa(port)
+		resumes, removeResume := d.awaitResume(nick, dccResume, portArg, "")
+		defer removeResume()
+
+		conns := acceptConn(ln)
+		d.c.Commands.SendCTCP(nick, CTCP_DCC, formatDCC(dccSend, name, formatDCCAddress(ip), portArg, sizeArg))
+
+		for conn == nil {
+			var ok bool
+
+			select {
+			case req := <-resumes:
+				offset = req.position
+				d.c.Commands.SendCTCP(nick, CTCP_DCC, formatDCC(dccAccept, name, portArg, strconv.FormatInt(offset, 10)))
+			case conn, ok = <-conns:
+				if !ok {
+					return 0, errors.New("dcc listener closed")
+				}
+			case <-ctx.Done():
+				return 0, ctx.Err()
+			}
+		}
+	}
+	defer conn.Close()
+
+	if offset > size {
+		offset = size
+	}
+
+	return sendDCC(ctx, conn, io.NewSectionReader(r, offset, size-offset), offset, size, opts)
+}
+
+// Accept accepts a DCC SEND offer, and writes the file to w, until it has
+// been received, or ctx is done. If DCCOptions.Offset is set, the transfer
+// is resumed from the offset (w should already contain the first Offset
+// bytes of the file). The amount of bytes received is returned.
+func (d *DCC) Accept(ctx context.Context, offer *DCCOffer, w io.Writer, opts DCCOptions) (int64, error) {
+	if offer.Source == nil || !IsValidNick(offer.Source.Name) {
+		return 0, errors.New("dcc offer without source")
+	}
+
+	nick := offer.Source.Name
+	offset := opts.Offset
+	if offer.Size > 0 && offset >= offer.Size {
+		return 0, nil
+	}
+
+	portArg := strconv.Itoa(offer.Port)
+
+	if offset > 0 {
+		accepts, remove := d.awaitResume(nick, dccAccept, portArg, offer.Token)
+		defer remove()
+
+		args := []string{portArg, strconv.FormatInt(offset, 10)}
+		if offer.Token != "" {
+			args = append(args, offer.Token)
+		}
+		d.c.Commands.SendCTCP(nick, CTCP_DCC, formatDCC(dccResume, offer.Filename, args...))
+
+		select {
+		case req := <-accepts:
+			offset = req.position
+		case <-ctx.Done():
+			return 0, ctx.Err()
+		}
+	}
+
+	var conn net.Conn
+
+	if offer.Passive() {
+		ln, ip, port, err := d.listen(opts)
+		if err != nil {
+			return 0, err
+		}
+		defer ln.Close()
+
+		conns := acceptConn(ln)
+		d.c.Commands.SendCTCP(nick, CTCP_DCC, formatDCC(
+			dccSend, offer.Filename, formatDCCAddress(ip), strconv.Itoa(port),
+			strconv.FormatInt(offer.Size, 10), offer.Token,
+		))
+
+		var ok bool
+		select {
+		case conn, ok = <-conns:
+			if !ok {
+				return 0, errors.New("dcc listener closed")
+			}
+		case <-ctx.Done():
+			return 0, ctx.Err()
+		}
+	} else {
+		var err error
+		conn, err = (&net.Dialer{}).DialContext(ctx, "tcp", net.JoinHostPort(offer.IP.String(), portArg))
+		if err != nil {
+			return 0, err
+		}
+	}
+	defer conn.Close()
+
+	return receiveDCC(ctx, conn, w, offset, offer.Size, opts)
+}
+
+// closeOnDone closes conn once ctx is done, until the returned function is
+// called.
+func closeOnDone(ctx context.Context, conn net.Conn) (stop func()) {
+	done := make(chan struct{})
+
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.Close()
+		case <-done:
+		}
+	}()
+
+	return func() { close(done) }
+}
+
+// throttleDCC waits until transferring n bytes since start is within rate
+// (bytes per second).
+func throttleDCC(ctx context.Context, start time.Time, n, rate int64) {
+	if rate <= 0 {
+		return
+	}
+
+	wait := time.Duration(float64(n)/float64(rate)*float64(time.Second)) - time.Since(start)
+	if wait <= 0 {
+		return
+	}
+
+	select {
+	case <-time.After(wait):
+	case <-ctx.Done():
+	}
+}
+
+// sendDCC sends the file from r (which starts at offset) over conn, and
+// waits for the receiver to acknowledge it.
+func sendDCC(ctx context.Context, conn net.Conn, r io.Reader, offset, size int64, opts DCCOptions) (int64, error) {
+	defer closeOnDone(ctx, conn)()
+
+	// The receiver acknowledges the (32-bit truncated) position it has
+	// received up to, and closes the connection once done.
+	acked := make(chan struct{})
+	go func() {
+		defer close(acked)
+
+		var ack [4]byte
+		for {
+			if _, err := io.ReadFull(conn, ack[:]); err != nil {
+				return
+			}
+
+			if binary.BigEndian.Uint32(ack[:]) == uint32(size) {
+				return
+			}
+		}
+	}()
+
+	var sent int64
+	start := time.Now()
+	buf := make([]byte, dccBufferSize)
+
+	for {
+		n, err := r.Read(buf)
+		if n > 0 {
+			if _, werr := conn.Write(buf[:n]); werr != nil {
+				return sent, ctxErr(ctx, werr)
+			}
+
+			sent += int64(n)
+			if opts.Progress != nil {
+				opts.Progress(offset+sent, size)
+			}
+			throttleDCC(ctx, start, sent, opts.Rate)
+		}
+
+		if err == io.EOF {
+			break
+		}
+
+		if err != nil {
+			return sent, err
+		}
+	}
+
+	if size == 0 {
+		return sent, nil
+	}
+
+	select {
+	case <-acked:
+	case <-ctx.Done():
+		return sent, ctx.Err()
+	}
+
+	return sent, nil
+}
+
+// receiveDCC receives a file (from offset) over conn, and writes it to w,
+// acknowledging the received data.
+func receiveDCC(ctx context.Context, conn net.Conn, w io.Writer, offset, size int64, opts DCCOptions) (int64, error) {
+	defer closeOnDone(ctx, conn)()
+
+	var received int64
+	var ack [4]byte
+	start := time.Now()
+	buf := make([]byte, dccBufferSize)
+
+	for size == 0 || offset+received < size {
+		n, err := conn.Read(buf)
+		if size > 0 && int64(n) > size-(offset+received) {
+			// Anything sent past the offered size isn't part of the file.
+			n = int(size - (offset + received))
+		}
+
+		if n > 0 {
+			if _, werr := w.Write(buf[:n]); werr != nil {
+				return received, werr
+			}
+
+			received += int64(n)
+
+			// Errors are caught by the next read, if the sender is still
+			// sending.
+			binary.BigEndian.PutUint32(ack[:], uint32(offset+received))
+			conn.Write(ack[:])
+
+			if opts.Progress != nil {
+				opts.Progress(offset+received, size)
+			}
+			throttleDCC(ctx, start, received, opts.Rate)
+		}
+
+		if err == io.EOF {
+			if size == 0 || offset+received >= size {
+				break
+			}
+
+			return received, io.ErrUnexpectedEOF
+		}
+
+		if err != nil {
+			return received, ctxErr(ctx, err)
+		}
+	}
+
+	return received, nil
+}
+
+// ctxErr returns the error of ctx if it is done (e.g. as the connection was
+// closed because of it), otherwise err.
+func ctxErr(ctx context.Context, err error) error {
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+
+	return err
+}
+
+// handleCTCPDCC passes DCC SEND offers to Config.HandleDCCOffer.
+func handleCTCPDCC(client *Client, ctcp CTCPEvent) {
+	if ctcp.Reply || client.Config.HandleDCCOffer == nil {
+		return
+	}
+
+	offer, err := ParseDCCSend(ctcp)
+	if err != nil {
+		return
+	}
+
+	// Replies to our passive offers aren't offers.
+	client.DCC.mu.Lock()
+	_, pending := client.DCC.tokens[offer.Token]
+	client.DCC.mu.Unlock()
+
+	if pending {
+		return
+	}
+
+	client.Config.HandleDCCOffer(offer)
+}
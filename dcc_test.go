@@ -0,0 +1,291 @@
+// Copyright (c) Liam Stanley <me@liamstanley.io>. All rights reserved. Use
+// of this source code is governed by the MIT license that can be found in
+// the LICENSE file.
+
+package girc
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"net"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+func TestParseDCCSend(t *testing.T) {
+	source := &Source{Name: "other"}
+
+	tests := []struct {
+		text string
+		want *DCCOffer
+	}{
+		{text: "SEND file.txt 2130706433 5000 1024", want: &DCCOffer{
+			Source: source, Filename: "file.txt", IP: net.IPv4(127, 0, 0, 1).To4(), Port: 5000, Size: 1024,
+		}},
+		{text: `SEND "my file.txt" 2130706433 5000 1024`, want: &DCCOffer{
+			Source: source, Filename: "my file.txt", IP: net.IPv4(127, 0, 0, 1).To4(), Port: 5000, Size: 1024,
+		}},
+		{text: "SEND ../../etc/passwd ::1 5000 0", want: &DCCOffer{
+			Source: source, Filename: "passwd", IP: net.ParseIP("::1"), Port: 5000,
+		}},
+		{text: "SEND file.txt 0 0 1024 abc", want: &DCCOffer{
+			Source: source, Filename: "file.txt", IP: net.IPv4(0, 0, 0, 0).To4(), Size: 1024, Token: "abc",
+		}},
+		{text: "SEND file.txt 0 0 1024", want: nil},
+		{text: "SEND .. 2130706433 5000 1024", want: nil},
+		{text: "SEND ../.. 2130706433 5000 1024", want: nil},
+		{text: "SEND . 2130706433 5000 1024", want: nil},
+		{text: "SEND / 2130706433 5000 1024", want: nil},
+		{text: `SEND "" 2130706433 5000 1024`, want: nil},
+		{text: "SEND file.txt 2130706433 70000 1024", want: nil},
+		{text: "SEND file.txt 2130706433 5000", want: nil},
+		{text: "CHAT chat 2130706433 5000", want: nil},
+	}
+
+	for _, tt := range tests {
+		got, err := ParseDCCSend(CTCPEvent{Source: source, Command: CTCP_DCC, Text: tt.text})
+		if tt.want == nil {
+			if err == nil {
+				t.Errorf("ParseDCCSend(%q) = %#v, want error", tt.text, got)
+			}
+			continue
+		}
+
+		if err != nil || !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("ParseDCCSend(%q) = %#v, %v, want %#v", tt.text, got, err, tt.want)
+		}
+	}
+}
+
+// dccArgs returns the arguments of a DCC message sent by the client.
+func dccArgs(t *testing.T, line string) []string {
+	t.Helper()
+
+	i := strings.IndexByte(line, ctcpDelim)
+	if i < 0 {
+		t.Fatalf("not a CTCP message: %q", line)
+	}
+
+	return strings.Fields(strings.Trim(line[i:], "\x01"))
+}
+
+// dccResult is the result of a DCC transfer.
+type dccResult struct {
+	n   int64
+	err error
+}
+
+func TestDCCSend(t *testing.T) {
+	c, server := mockClient(t, Config{AllowFlood: true})
+	defer c.Stop()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	data := bytes.Repeat([]byte("0123456789"), 10000)
+	opts := DCCOptions{IP: net.IPv4(127, 0, 0, 1), ListenAddr: "127.0.0.1:0"}
+
+	for _, passive := range []bool{false, true} {
+		opts.Passive = passive
+		results := make(chan dccResult, 1)
+
+		go func() {
+			n, err := c.DCC.Send(ctx, "other", "/path/to/file.txt", bytes.NewReader(data), int64(len(data)), opts)
+			results <- dccResult{n, err}
+		}()
+
+		// DCC SEND file.txt <ip> <port> <size> [token]
+		args := dccArgs(t, server.expect("PRIVMSG other :\x01DCC SEND file.txt "))
+		size := strconv.Itoa(len(data))
+		if len(args) < 6 || args[5] != size {
+			t.Fatalf("unexpected offer: %q", args)
+		}
+
+		// Resume from offset 5.
+		port := args[4]
+		resume := "DCC RESUME file.txt " + port + " 5"
+		if passive {
+			resume += " " + args[6]
+		}
+		server.send(":other!user@host PRIVMSG nick :\x01" + resume + "\x01")
+		server.expect("PRIVMSG other :\x01" + strings.Replace(resume, "RESUME", "ACCEPT", 1) + "\x01")
+
+		var conn net.Conn
+		var err error
+		if passive {
+			if port != "0" {
+				t.Fatalf("passive offer with port %s", port)
+			}
+
+			ln, err := net.Listen("tcp", "127.0.0.1:0")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			lport := strconv.Itoa(ln.Addr().(*net.TCPAddr).Port)
+			server.send(":other!user@host PRIVMSG nick :\x01DCC SEND file.txt 2130706433 " + lport + " " + size + " " + args[6] + "\x01")
+			conn, err = ln.Accept()
+			ln.Close()
+		} else {
+			conn, err = net.Dial("tcp", "127.0.0.1:"+port)
+		}
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		received, err := ioutil.ReadAll(io.LimitReader(conn, int64(len(data)-5)))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var ack [4]byte
+		binary.BigEndian.PutUint32(ack[:], uint32(len(data)))
+		conn.Write(ack[:])
+
+		res := <-results
+		conn.Close()
+
+		if res.err != nil || res.n != int64(len(data)-5) || !bytes.Equal(received, data[5:]) {
+			t.Fatalf("passive %t: Send() = %d, %v, received %d bytes", passive, res.n, res.err, len(received))
+		}
+	}
+}
+
+func TestDCCAccept(t *testing.T) {
+	offers := make(chan *DCCOffer, 1)
+	c, server := mockClient(t, Config{AllowFlood: true, HandleDCCOffer: func(offer *DCCOffer) { offers <- offer }})
+	defer c.Stop()
+
+	server.send(":irc.example.com 001 nick :Welcome")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	data := bytes.Repeat([]byte("0123456789"), 10000)
+	size := strconv.Itoa(len(data))
+
+	for _, passive := range []bool{false, true} {
+		var ln net.Listener
+		if passive {
+			server.send(":other!user@host PRIVMSG nick :\x01DCC SEND \"a file.txt\" 0 0 " + size + " tok\x01")
+		} else {
+			var err error
+			if ln, err = net.Listen("tcp", "127.0.0.1:0"); err != nil {
+				t.Fatal(err)
+			}
+
+			port := strconv.Itoa(ln.Addr().(*net.TCPAddr).Port)
+			server.send(":other!user@host PRIVMSG nick :\x01DCC SEND \"a file.txt\" 2130706433 " + port + " " + size + "\x01")
+		}
+
+		var offer *DCCOffer
+		select {
+		case offer = <-offers:
+		case <-time.After(2 * time.Second):
+			t.Fatal("HandleDCCOffer() wasn't called")
+		}
+
+		if offer.Filename != "a file.txt" || offer.Passive() != passive || offer.Size != int64(len(data)) {
+			t.Fatalf("unexpected offer: %#v", offer)
+		}
+
+		var progress int64
+		buf := bytes.NewBuffer(append([]byte(nil), data[:5]...))
+		results := make(chan dccResult, 1)
+
+		go func() {
+			n, err := c.DCC.Accept(ctx, offer, buf, DCCOptions{
+				IP:         net.IPv4(127, 0, 0, 1),
+				ListenAddr: "127.0.0.1:0",
+				Offset:     5,
+				Progress:   func(transferred, total int64) { progress = transferred },
+			})
+			results <- dccResult{n, err}
+		}()
+
+		line := server.expect("PRIVMSG other :\x01DCC RESUME \"a file.txt\" ")
+		args := dccArgs(t, line)
+		accept := strings.Replace(strings.Trim(line[strings.IndexByte(line, ctcpDelim):], "\x01"), "RESUME", "ACCEPT", 1)
+		server.send(":other!user@host PRIVMSG nick :\x01" + accept + "\x01")
+
+		var conn net.Conn
+		var err error
+		if passive {
+			if args[len(args)-1] != "tok" {
+				t.Fatalf("unexpected resume: %q", line)
+			}
+
+			// DCC SEND "a file.txt" <ip> <port> <size> tok
+			args = dccArgs(t, server.expect("PRIVMSG other :\x01DCC SEND \"a file.txt\" "))
+			conn, err = net.Dial("tcp", "127.0.0.1:"+args[len(args)-3])
+		} else {
+			conn, err = ln.Accept()
+			ln.Close()
+		}
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		go func() {
+			conn.Write(data[5:])
+			io.Copy(ioutil.Discard, conn)
+		}()
+
+		res := <-results
+		conn.Close()
+
+		if res.err != nil || res.n != int64(len(data)-5) || !bytes.Equal(buf.Bytes(), data) || progress != int64(len(data)) {
+			t.Fatalf("passive %t: Accept() = %d, %v, progress %d", passive, res.n, res.err, progress)
+		}
+	}
+}
+
+func TestReceiveDCCOverrun(t *testing.T) {
+	local, remote := net.Pipe()
+	defer local.Close()
+	defer remote.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	go func() {
+		// The extra data past the offered size arrives in the same read.
+		remote.Write([]byte("0123456789extra"))
+		io.Copy(ioutil.Discard, remote)
+	}()
+
+	var buf bytes.Buffer
+	n, err := receiveDCC(ctx, local, &buf, 2, 12, DCCOptions{})
+	if err != nil || n != 10 || buf.String() != "0123456789" {
+		t.Fatalf("receiveDCC() = %d, %v, wrote %q, want only the offered size", n, err, buf.String())
+	}
+}
+
+func TestDCCAwaitCasemapping(t *testing.T) {
+	c := New(Config{Server: "irc.example.com", Nick: "nick", User: "user"})
+	c.state.mu.Lock()
+	c.state.isupport.Casemapping = CaseMappingASCII
+	c.state.mu.Unlock()
+
+	var got []string
+	remove := c.DCC.await("a[b]", dccResume, func(filename string, args []string) {
+		got = append(got, filename)
+	})
+	defer remove()
+
+	c.RunHandlers(ParseEvent(":a{b}!user@host PRIVMSG nick :\x01DCC RESUME other.txt 5000 5\x01"))
+	c.RunHandlers(ParseEvent(":A[B]!user@host PRIVMSG nick :\x01DCC RESUME file.txt 5000 5\x01"))
+
+	if len(got) != 1 || got[0] != "file.txt" {
+		t.Fatalf("await() got %q, want only file.txt from A[B] with ascii", got)
+	}
+}
@@ -0,0 +1,203 @@
+// Copyright (c) Liam Stanley <me@liamstanley.io>. All rights reserved. Use
+// of this source code is governed by the MIT license that can be found in
+// the LICENSE file.
+
+package girc
+
+import (
+	"errors"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+const (
+	// defaultDialTimeout is the timeout of each connection attempt, if
+	// Config.DialTimeout isn't set.
+	defaultDialTimeout = 5 * time.Second
+	// happyDelay is how long a connection attempt gets before the next
+	// address is tried alongside it (the "Connection Attempt Delay" of RFC
+	// 8305).
+	happyDelay = 250 * time.Millisecond
+)
+
+// lookupSRV and lookupIPAddr resolve SRV records and addresses. They're
+// replaced in tests.
+var (
+	lookupSRV    = net.DefaultResolver.LookupSRV
+	lookupIPAddr = net.DefaultResolver.LookupIPAddr
+)
+
+// dialTimeout returns the timeout of each connection attempt.
+func dialTimeout(conf Config) time.Duration {
+	if conf.DialTimeout <= 0 {
+		return defaultDialTimeout
+	}
+
+	return conf.DialTimeout
+}
+
+// srvAddrs returns the "host:port" pairs listed in the _ircs._tcp (with
+// Config.SSL) or _irc._tcp SRV records of Config.Server, in the order they
+// should be tried. Nil if there are none, in which case Config.Server and
+// Config.Port should be used.
+func srvAddrs(conf Config) ([]string, error) {
+	service := "irc"
+	if conf.SSL {
+		service = "ircs"
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), dialTimeout(conf))
+	defer cancel()
+
+	_, records, err := lookupSRV(ctx, service, "tcp", conf.Server)
+	if err != nil {
+		// No records (or no SRV support by the resolver) isn't an error.
+		return nil, nil
+	}
+
+	// A single "." target means the service is decidedly not available
+	// (RFC 2782).
+	if len(records) == 1 && records[0].Target == "." {
+		return nil, errors.New("_" + service + "._tcp." + conf.Server + " is not available")
+	}
+
+	// The records are already sorted by priority, and randomized by
+	// weight.
+	addrs := make([]string, 0, len(records))
+	for _, record := range records {
+		addrs = append(addrs, net.JoinHostPort(strings.TrimSuffix(record.Target, "."), strconv.Itoa(int(record.Port))))
+	}
+
+	return addrs, nil
+}
+
+// happyDialer is the default Dialer, which connects to every address a host
+// resolves to, alternating between IPv6 and IPv4 addresses, starting a new
+// attempt every happyDelay (or as soon as the previous one failed) until
+// one succeeds. This is "Happy Eyeballs" (RFC 8305), so connecting doesn't
+// hang on a broken address family, or on an unreachable address.
+type happyDialer struct {
+	// dialer makes each connection attempt, with its Timeout applying to
+	// each attempt.
+	dialer *net.Dialer
+}
+
+// DialContext implements Dialer.
+func (d *happyDialer) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, err
+	}
+
+	if net.ParseIP(host) != nil {
+		return d.dialer.DialContext(ctx, network, address)
+	}
+
+	ips, err := lookupIPAddr(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+
+	addrs := interleaveAddrs(ips)
+	if len(addrs) == 0 {
+		return nil, errors.New("no addresses found for " + host)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	type result struct {
+		conn net.Conn
+		err  error
+	}
+	results := make(chan result, len(addrs))
+
+	var started, failed int
+	var next <-chan time.Time
+
+	start := func() {
+		go func(addr string) {
+			conn, err := d.dialer.DialContext(ctx, network, net.JoinHostPort(addr, port))
+			results <- result{conn, err}
+		}(addrs[started].String())
+
+		started++
+		next = nil
+		if started < len(addrs) {
+			next = time.After(happyDelay)
+		}
+	}
+
+	// discard closes the connections of the attempts still in progress
+	// once they're done, if they still succeed.
+	discard := func() {
+		go func(pending int) {
+			for i := 0; i < pending; i++ {
+				if r := <-results; r.conn != nil {
+					r.conn.Close()
+				}
+			}
+		}(started - failed)
+	}
+
+	start()
+
+	var firstErr error
+	for {
+		select {
+		case <-next:
+			start()
+		case r := <-results:
+			if r.err == nil {
+				failed++ // Not pending anymore.
+				discard()
+				return r.conn, nil
+			}
+
+			if firstErr == nil {
+				firstErr = r.err
+			}
+
+			failed++
+			if failed == len(addrs) {
+				return nil, firstErr
+			}
+
+			if started < len(addrs) {
+				start()
+			}
+		case <-ctx.Done():
+			discard()
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// interleaveAddrs orders addresses alternating between IPv6 and IPv4,
+// starting with IPv6, keeping the order of addresses of the same family.
+func interleaveAddrs(ips []net.IPAddr) []net.IPAddr {
+	var v6, v4 []net.IPAddr
+	for _, ip := range ips {
+		if ip.IP.To4() != nil {
+			v4 = append(v4, ip)
+		} else {
+			v6 = append(v6, ip)
+		}
+	}
+
+	addrs := make([]net.IPAddr, 0, len(ips))
+	for i := 0; i < len(v6) || i < len(v4); i++ {
+		if i < len(v6) {
+			addrs = append(addrs, v6[i])
+		}
+		if i < len(v4) {
+			addrs = append(addrs, v4[i])
+		}
+	}
+
+	return addrs
+}
@@ -0,0 +1,207 @@
+// Copyright (c) Liam Stanley <me@liamstanley.io>. All rights reserved. Use
+// of this source code is governed by the MIT license that can be found in
+// the LICENSE file.
+
+package girc
+
+import (
+	"crypto/tls"
+	"errors"
+	"net"
+	"reflect"
+	"strconv"
+	"syscall"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+func TestInterleaveAddrs(t *testing.T) {
+	ips := []net.IPAddr{
+		{IP: net.ParseIP("192.0.2.1")},
+		{IP: net.ParseIP("192.0.2.2")},
+		{IP: net.ParseIP("192.0.2.3")},
+		{IP: net.ParseIP("2001:db8::1")},
+	}
+
+	var got []string
+	for _, ip := range interleaveAddrs(ips) {
+		got = append(got, ip.String())
+	}
+
+	want := []string{"2001:db8::1", "192.0.2.1", "192.0.2.2", "192.0.2.3"}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("interleaveAddrs() = %q, want %q", got, want)
+	}
+}
+
+// listen starts a listener on the IPv4 loopback, which accepts (and closes)
+// connections, returning its port.
+func listen(t *testing.T) (port int, stop func()) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			conn.Close()
+		}
+	}()
+
+	return listener.Addr().(*net.TCPAddr).Port, func() { listener.Close() }
+}
+
+func TestHappyDialer(t *testing.T) {
+	port, stop := listen(t)
+	defer stop()
+
+	// Connecting to the first address hangs, and the IPv6 loopback isn't
+	// listening.
+	defer func(lookup func(context.Context, string) ([]net.IPAddr, error)) { lookupIPAddr = lookup }(lookupIPAddr)
+	lookupIPAddr = func(ctx context.Context, host string) ([]net.IPAddr, error) {
+		return []net.IPAddr{
+			{IP: net.ParseIP("192.0.2.1")},
+			{IP: net.ParseIP("127.0.0.1")},
+			{IP: net.ParseIP("::1")},
+		}, nil
+	}
+
+	hang := make(chan struct{})
+	defer close(hang)
+
+	dialer := &happyDialer{dialer: &net.Dialer{
+		Timeout: time.Minute,
+		Control: func(network, address string, c syscall.RawConn) error {
+			if host, _, _ := net.SplitHostPort(address); host == "192.0.2.1" {
+				<-hang
+				return errors.New("unreachable")
+			}
+
+			return nil
+		},
+	}}
+
+	start := time.Now()
+	conn, err := dialer.DialContext(context.Background(), "tcp", net.JoinHostPort("irc.example.com", strconv.Itoa(port)))
+	if err != nil {
+		t.Fatalf("DialContext() returned error: %s", err)
+	}
+	defer conn.Close()
+
+	if took := time.Since(start); took > 5*time.Second {
+		t.Fatalf("DialContext() took %s", took)
+	}
+
+	if addr := conn.RemoteAddr().(*net.TCPAddr); !addr.IP.Equal(net.ParseIP("127.0.0.1")) {
+		t.Fatalf("DialContext() connected to %s, want 127.0.0.1", addr)
+	}
+
+	// All attempts failing returns an error.
+	stop()
+	lookupIPAddr = func(ctx context.Context, host string) ([]net.IPAddr, error) {
+		return []net.IPAddr{{IP: net.ParseIP("127.0.0.1")}}, nil
+	}
+
+	if conn, err = dialer.DialContext(context.Background(), "tcp", net.JoinHostPort("irc.example.com", strconv.Itoa(port))); err == nil {
+		conn.Close()
+		t.Fatal("DialContext() without a listener returned no error")
+	}
+}
+
+func TestSRV(t *testing.T) {
+	port, stop := listen(t)
+	defer stop()
+
+	var lookups []string
+	defer func(lookup func(context.Context, string, string, string) (string, []*net.SRV, error)) {
+		lookupSRV = lookup
+	}(lookupSRV)
+	lookupSRV = func(ctx context.Context, service, proto, name string) (string, []*net.SRV, error) {
+		lookups = append(lookups, "_"+service+"._"+proto+"."+name)
+
+		if name == "unavailable.example.com" {
+			return "", []*net.SRV{{Target: "."}}, nil
+		}
+
+		// The first server is down.
+		return "", []*net.SRV{
+			{Target: "localhost.invalid.", Port: uint16(port), Priority: 10},
+			{Target: "127.0.0.1.", Port: uint16(port), Priority: 20},
+		}, nil
+	}
+
+	conf := Config{Server: "irc.example.com", Port: 6697, SSL: true, SRV: true}
+	conn, err := dial(conf, "irc.example.com:6697")
+	if err != nil {
+		t.Fatalf("dial() returned error: %s", err)
+	}
+	conn.Close()
+
+	if want := []string{"_ircs._tcp.irc.example.com"}; !reflect.DeepEqual(lookups, want) {
+		t.Fatalf("looked up %q, want %q", lookups, want)
+	}
+
+	if conn.RemoteAddr().(*net.TCPAddr).Port != port {
+		t.Fatalf("dial() connected to %s, want port %d", conn.RemoteAddr(), port)
+	}
+
+	conf.Server = "unavailable.example.com"
+	if _, err = dial(conf, "unavailable.example.com:6697"); err == nil {
+		t.Fatal("dial() to an unavailable service returned no error")
+	}
+}
+
+func TestSRVServerName(t *testing.T) {
+	defer func(lookup func(context.Context, string, string, string) (string, []*net.SRV, error)) {
+		lookupSRV = lookup
+	}(lookupSRV)
+	lookupSRV = func(ctx context.Context, service, proto, name string) (string, []*net.SRV, error) {
+		return "", []*net.SRV{{Target: "irc1.example.net.", Port: 6697}}, nil
+	}
+
+	local, remote := net.Pipe()
+	defer remote.Close()
+
+	// Only the ServerName sent by the client is of interest, so the
+	// handshake is aborted.
+	names := make(chan string, 1)
+	go func() {
+		server := tls.Server(remote, &tls.Config{
+			GetConfigForClient: func(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+				names <- hello.ServerName
+				return nil, errors.New("no certificate")
+			},
+		})
+		server.Handshake()
+		server.Close()
+	}()
+
+	dialer := &mockDialer{conn: local}
+	conf := Config{Server: "irc.example.com", Port: 6697, Nick: "nick", User: "user", SSL: true, SRV: true, Dialer: dialer}
+	conn, err := newConn(conf, "irc.example.com:6697")
+	if err != nil {
+		t.Fatalf("newConn() returned error: %s", err)
+	}
+	defer conn.Close()
+
+	if dialer.address != "irc1.example.net:6697" {
+		t.Fatalf("connected to %q, want the target of the SRV record", dialer.address)
+	}
+
+	conn.sock.(*tls.Conn).Handshake()
+
+	select {
+	case name := <-names:
+		if name != "irc.example.com" {
+			t.Fatalf("ServerName = %q, want Config.Server", name)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("no handshake was started")
+	}
+}
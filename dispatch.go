@@ -0,0 +1,116 @@
+// Copyright (c) Liam Stanley <me@liamstanley.io>. All rights reserved. Use
+// of this source code is governed by the MIT license that can be found in
+// the LICENSE file.
+
+package girc
+
+import (
+	"hash/fnv"
+	"sync"
+
+	"golang.org/x/net/context"
+)
+
+// dispatchQueueSize is the amount of events which may be waiting for each
+// worker, before the events of all targets are held back.
+const dispatchQueueSize = 64
+
+// parallelExecLoop is execLoop with Config.ParallelDispatch: events are
+// handed out to workers by their target (see dispatchKey), so events for
+// the same target are always handled by the same worker, in order. Events
+// without a target wait for all workers to finish, and are then handled
+// by the loop itself.
+func (c *Client) parallelExecLoop(ctx context.Context) {
+	var pending sync.WaitGroup
+	workers := make([]chan *Event, c.Config.ParallelDispatch)
+
+	for i := range workers {
+		workers[i] = make(chan *Event, dispatchQueueSize)
+
+		go func(queue chan *Event) {
+			for event := range queue {
+				// Once the loop is stopped, drop what's left.
+				if ctx.Err() == nil {
+					c.dispatch(event)
+				}
+				pending.Done()
+			}
+		}(workers[i])
+	}
+
+	defer func() {
+		for i := range workers {
+			close(workers[i])
+		}
+	}()
+
+	for {
+		select {
+		case event := <-c.rx:
+			for _, e := range c.receive(event) {
+				key := c.dispatchKey(e)
+				if key == "" {
+					pending.Wait()
+					c.dispatch(e)
+					continue
+				}
+
+				hash := fnv.New32a()
+				hash.Write([]byte(key))
+
+				pending.Add(1)
+				select {
+				case workers[hash.Sum32()%uint32(len(workers))] <- e:
+				case <-ctx.Done():
+					pending.Done()
+					return
+				}
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// dispatchKey returns the target which the handling of event is ordered by
+// with Config.ParallelDispatch, i.e. the (casemapped) channel, or the user
+// which sent us a private message. Empty if the event may affect the state
+// of more than one target (e.g. NICK or QUIT), in which case it's ordered
+// relative to all events.
+func (c *Client) dispatchKey(event *Event) string {
+	switch event.Command {
+	case PRIVMSG, NOTICE, TAGMSG, TOPIC, JOIN, PART, KICK, MODE:
+		if event.Source == nil {
+			return ""
+		}
+	case USERNOTICE, USERSTATE, ROOMSTATE, CLEARCHAT, CLEARMSG:
+		// Twitch sends these from the server, for a channel.
+	default:
+		return ""
+	}
+
+	// Old servers may send the channel of a JOIN as the trailing
+	// parameter.
+	target := event.Trailing
+	if len(event.Params) > 0 {
+		target = event.Params[0]
+	}
+
+	c.state.mu.RLock()
+	defer c.state.mu.RUnlock()
+
+	if IsValidChannel(target) {
+		return c.state.toLower(target)
+	}
+
+	// Private messages are ordered by the user which sent them. Nicknames
+	// can't be valid channel names, so they never share a key with one.
+	switch event.Command {
+	case PRIVMSG, NOTICE, TAGMSG:
+		if event.Source != nil && event.Source.Ident != "" {
+			return c.state.toLower(event.Source.Name)
+		}
+	}
+
+	return ""
+}
@@ -0,0 +1,87 @@
+// Copyright (c) Liam Stanley <me@liamstanley.io>. All rights reserved. Use
+// of this source code is governed by the MIT license that can be found in
+// the LICENSE file.
+
+package girc
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestParallelDispatch(t *testing.T) {
+	c, server := mockClient(t, Config{ParallelDispatch: 4})
+	defer c.Stop()
+
+	release := make(chan struct{})
+	fast := make(chan string, 20)
+	pings := make(chan struct{}, 1)
+
+	c.Handlers.Add(PRIVMSG, func(c *Client, e Event) {
+		switch e.Params[0] {
+		case "#slow":
+			<-release
+		case "#fast":
+			fast <- e.Trailing
+		}
+	})
+	c.Handlers.Add(PING, func(c *Client, e Event) {
+		pings <- struct{}{}
+	})
+
+	server.send(":other!user@host PRIVMSG #slow :blocking")
+	for i := 0; i < 10; i++ {
+		server.send(fmt.Sprintf(":other!user@host PRIVMSG #fast :%d", i))
+	}
+
+	// Messages to #fast are handled while #slow is blocked, in order.
+	for i := 0; i < 10; i++ {
+		select {
+		case got := <-fast:
+			if got != fmt.Sprint(i) {
+				t.Fatalf("got message %s, want %d", got, i)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatal("messages to #fast were held back by the handler of #slow")
+		}
+	}
+
+	// Events without a target wait for all others.
+	server.send("PING :sync")
+	select {
+	case <-pings:
+		t.Fatal("PING was handled while #slow was still being handled")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	close(release)
+	server.expect("PONG sync")
+	<-pings
+}
+
+func TestDispatchKey(t *testing.T) {
+	c := New(Config{Server: "irc.example.com", Nick: "nick", User: "user"})
+
+	tests := []struct {
+		raw  string
+		want string
+	}{
+		{raw: ":nick!user@host PRIVMSG #Channel :hello", want: "#channel"},
+		{raw: ":nick!user@host NOTICE #channel :hello", want: "#channel"},
+		{raw: ":nick!user@host JOIN :#Channel", want: "#channel"},
+		{raw: ":nick!user@host KICK #channel other :bye", want: "#channel"},
+		{raw: ":Other!user@host PRIVMSG nick :hello", want: "other"},
+		{raw: ":irc.example.com NOTICE nick :server notice", want: ""},
+		{raw: ":nick!user@host MODE nick +i", want: ""},
+		{raw: ":nick!user@host NICK other", want: ""},
+		{raw: ":nick!user@host QUIT :bye", want: ""},
+		{raw: ":irc.example.com 353 nick = #channel :nick", want: ""},
+	}
+
+	for _, tt := range tests {
+		if got := c.dispatchKey(ParseEvent(tt.raw)); got != tt.want {
+			t.Errorf("dispatchKey(%q) = %q, want %q", tt.raw, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,263 @@
+// Copyright (c) Liam Stanley <me@liamstanley.io>. All rights reserved. Use
+// of this source code is governed by the MIT license that can be found in
+// the LICENSE file.
+
+package girc
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+const (
+	// capEchoMessage is the IRCv3 echo-message capability.
+	capEchoMessage = "echo-message"
+	// capLabeledResponse is the IRCv3 labeled-response capability.
+	capLabeledResponse = "labeled-response"
+	// echoTimeout is how long we wait for a message to be echoed back,
+	// before forgetting about it.
+	echoTimeout = 2 * time.Minute
+)
+
+// ErrEchoUnsupported is returned when a message is sent with a confirmation
+// callback, but the echo-message capability hasn't been negotiated.
+var ErrEchoUnsupported = errors.New("server does not support echo-message")
+
+// EchoConfirmation confirms that the server has accepted a message we sent,
+// and delivered it to its target. See Client.SendEcho.
+type EchoConfirmation struct {
+	// MsgID is the ID the server assigned to the message (the "msgid" tag),
+	// if any.
+	MsgID string
+	// Time is when the server received the message. See Event.Timestamp.
+	Time time.Time
+	// Event is the message, as echoed back by the server.
+	Event *Event
+}
+
+// pendingEcho is a message we sent, which the server hasn't yet echoed back.
+type pendingEcho struct {
+	label   string
+	command string
+	target  string
+	text    string
+	sent    time.Time
+	fn      func(EchoConfirmation)
+}
+
+// echoTracker keeps track of the messages which are waiting to be echoed
+// back by the server.
+type echoTracker struct {
+	mu      sync.Mutex
+	pending []*pendingEcho
+}
+
+// reset forgets about all pending messages, e.g. when reconnecting.
+func (t *echoTracker) reset() {
+	t.mu.Lock()
+	t.pending = nil
+	t.mu.Unlock()
+}
+
+// add starts waiting for a message to be echoed back, forgetting about
+// messages which have been waiting for longer than echoTimeout.
+func (t *echoTracker) add(p *pendingEcho) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	i := 0
+	for i < len(t.pending) && time.Since(t.pending[i].sent) > echoTimeout {
+		i++
+	}
+	t.pending = append(t.pending[i:], p)
+}
+
+// match finds and removes the message which e is the echo of. Messages are
+// matched by label if possible, otherwise by their content (comparing
+// targets with casemapping), oldest first.
+func (t *echoTracker) match(casemapping string, e *Event) *pendingEcho {
+	label, labeled := e.Tag("label")
+	target := ToLower(casemapping, e.Params[0])
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for i, p := range t.pending {
+		if labeled || p.label != "" {
+			if p.label != label {
+				continue
+			}
+		} else if p.command != e.Command || ToLower(casemapping, p.target) != target || p.text != e.Trailing {
+			continue
+		}
+
+		t.pending = append(t.pending[:i], t.pending[i+1:]...)
+		return p
+	}
+
+	return nil
+}
+
+// SendEcho sends a PRIVMSG or NOTICE, and calls fn once the server has
+// echoed it back to us, with the ID and time the server assigned to the
+// message. This requires the echo-message capability, otherwise
+// ErrEchoUnsupported is returned. If the labeled-response capability is
+// available, the echo is matched using a label, otherwise it is matched by
+// its content. fn is not called if the message is never echoed back (e.g.
+// if the server refused it). fn is called from the event handler goroutine,
+// so it should not block.
+//
+// Note that with echo-message, all messages we send (not just those sent
+// with SendEcho) are delivered back to our handlers, with us as the source.
+func (c *Client) SendEcho(event *Event, fn func(EchoConfirmation)) error {
+	if event.Command != PRIVMSG && event.Command != NOTICE {
+		return errors.New("only PRIVMSG and NOTICE are echoed: " + event.Command)
+	}
+
+	if len(event.Params) == 0 {
+		return &ErrInvalidTarget{}
+	}
+
+	if !c.HasCapability(capEchoMessage) {
+		return ErrEchoUnsupported
+	}
+
+	event = event.Copy()
+	p := &pendingEcho{
+		command: event.Command,
+		target:  event.Params[0],
+		text:    event.Trailing,
+		sent:    time.Now(),
+		fn:      fn,
+	}
+
+	if c.HasCapability(capLabeledResponse) {
+		p.label = randomRef(10)
+		if event.Tags == nil {
+			event.Tags = Tags{}
+		}
+		event.Tags["label"] = p.label
+	}
+
+	c.echoes.add(p)
+	c.Send(event)
+
+	return nil
+}
+
+// MessageEcho is like Commands.Message, however fn is called once the
+// server has echoed back each of the messages sent. See Client.SendEcho.
+func (cmd *Commands) MessageEcho(target, message string, fn func(EchoConfirmation)) (int, error) {
+	if !cmd.c.HasCapability(capEchoMessage) {
+		return 0, ErrEchoUnsupported
+	}
+
+	return cmd.sendWrapped(PRIVMSG, target, message, nil, fn)
+}
+
+// NoticeEcho is like Commands.Notice, however fn is called once the server
+// has echoed back each of the notices sent. See Client.SendEcho.
+func (cmd *Commands) NoticeEcho(target, message string, fn func(EchoConfirmation)) (int, error) {
+	if !cmd.c.HasCapability(capEchoMessage) {
+		return 0, ErrEchoUnsupported
+	}
+
+	return cmd.sendWrapped(NOTICE, target, message, nil, fn)
+}
+
+// messageErrors are the replies which mean that the server refused a
+// message, where params[1] is the target.
+var messageErrors = []string{
+	ERR_NOSUCHNICK, ERR_NOSUCHCHANNEL, ERR_CANNOTSENDTOCHAN, ERR_TOOMANYTARGETS,
+}
+
+// MessageSync is like Commands.MessageEcho, however it waits until the server
+// has echoed back all of the messages sent, returning their confirmations,
+// or until ctx is done. If the server refuses the message, e.g. because the
+// user doesn't exist (ERR_NOSUCHNICK) or we can't send to the channel
+// (ERR_CANNOTSENDTOCHAN), an ErrNumeric is returned, which can be checked
+// with errors.Is (e.g. against ErrNoSuchNick).
+func (cmd *Commands) MessageSync(ctx context.Context, target, message string) ([]EchoConfirmation, error) {
+	c := cmd.c
+	if !c.HasCapability(capEchoMessage) {
+		return nil, ErrEchoUnsupported
+	}
+	id := c.toLower(target)
+
+	var mu sync.Mutex
+	var confirmations []EchoConfirmation
+	echoed := make(chan struct{}, 1)
+	failed := make(chan error, 1)
+
+	cuid := c.Handlers.sregisterOwned(ALLEVENTS, HandlerFunc(func(client *Client, e Event) {
+		if len(e.Params) < 2 || client.toLower(e.Params[1]) != id {
+			return
+		}
+
+		for _, cmd := range messageErrors {
+			if e.Command != cmd {
+				continue
+			}
+
+			select {
+			case failed <- &ErrNumeric{Code: e.Command, Target: e.Params[1], Reason: e.Trailing}:
+			default:
+				// Already failed.
+			}
+			return
+		}
+	}))
+	defer c.Handlers.Remove(cuid)
+
+	lines, err := cmd.sendWrapped(PRIVMSG, target, message, nil, func(echo EchoConfirmation) {
+		mu.Lock()
+		confirmations = append(confirmations, echo)
+		mu.Unlock()
+
+		select {
+		case echoed <- struct{}{}:
+		default:
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		mu.Lock()
+		done := len(confirmations) >= lines
+		echoes := append([]EchoConfirmation(nil), confirmations...)
+		mu.Unlock()
+
+		if done {
+			return echoes, nil
+		}
+
+		select {
+		case <-echoed:
+		case err = <-failed:
+			return nil, err
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// handleEcho matches messages echoed back by the server (see echo-message)
+// with the messages we sent with Client.SendEcho.
+func handleEcho(c *Client, e Event) {
+	if len(e.Params) == 0 || !c.isSelf(e.Source) {
+		return
+	}
+
+	p := c.echoes.match(c.casemapping(), &e)
+	if p == nil || p.fn == nil {
+		return
+	}
+
+	msgid, _ := e.Tag("msgid")
+	p.fn(EchoConfirmation{MsgID: msgid, Time: e.Timestamp, Event: e.Copy()})
+}
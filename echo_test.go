@@ -0,0 +1,126 @@
+// Copyright (c) Liam Stanley <me@liamstanley.io>. All rights reserved. Use
+// of this source code is governed by the MIT license that can be found in
+// the LICENSE file.
+
+package girc
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMessageEcho(t *testing.T) {
+	c, server := mockClient(t, Config{RateBurst: 10})
+	defer c.Stop()
+
+	if _, err := c.Commands.MessageEcho("#channel", "hello", nil); err != ErrEchoUnsupported {
+		t.Fatalf("MessageEcho() without the capability returned %v", err)
+	}
+
+	c.state.mu.Lock()
+	c.state.enabledCap = []string{capEchoMessage}
+	c.state.mu.Unlock()
+
+	confirmations := make(chan EchoConfirmation, 2)
+	confirm := func(echo EchoConfirmation) { confirmations <- echo }
+
+	// Matched by content.
+	if _, err := c.Commands.MessageEcho("#channel", "first", confirm); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Commands.NoticeEcho("#channel", "second", confirm); err != nil {
+		t.Fatal(err)
+	}
+	server.expect("PRIVMSG #channel :first")
+	server.expect("NOTICE #channel :second")
+
+	// Messages from others, and unrelated messages from us, are ignored.
+	server.send(":other!user@host PRIVMSG #channel :first")
+	server.send(":nick!user@host PRIVMSG #channel :unrelated")
+	server.send("@msgid=b;time=2020-01-01T00:00:01.000Z :nick!user@host NOTICE #Channel :second")
+	server.send("@msgid=a;time=2020-01-01T00:00:00.000Z :nick!user@host PRIVMSG #channel :first")
+
+	for _, want := range []string{"b", "a"} {
+		select {
+		case echo := <-confirmations:
+			if echo.MsgID != want || echo.Event == nil || echo.Time.IsZero() {
+				t.Fatalf("unexpected confirmation: %#v, want msgid %q", echo, want)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatalf("confirmation for msgid %q never received", want)
+		}
+	}
+
+	// Matched by label.
+	c.state.mu.Lock()
+	c.state.enabledCap = []string{capEchoMessage, capLabeledResponse}
+	c.state.mu.Unlock()
+
+	if err := c.SendEcho(&Event{Command: PRIVMSG, Params: []string{"#channel"}, Trailing: "labeled"}, confirm); err != nil {
+		t.Fatal(err)
+	}
+
+	sent := ParseEvent(server.expect("@label="))
+	label, _ := sent.Tags.Get("label")
+	server.send("@label=" + label + ";msgid=c :nick!user@host PRIVMSG #channel :labeled")
+
+	select {
+	case echo := <-confirmations:
+		if echo.MsgID != "c" {
+			t.Fatalf("unexpected confirmation: %#v", echo)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("confirmation for labeled message never received")
+	}
+
+	c.echoes.mu.Lock()
+	defer c.echoes.mu.Unlock()
+
+	if n := len(c.echoes.pending); n != 0 {
+		t.Fatalf("%d messages still pending", n)
+	}
+}
+
+func TestEchoTrackerCasemapping(t *testing.T) {
+	tracker := &echoTracker{}
+	tracker.add(&pendingEcho{command: PRIVMSG, target: "#a[b]", text: "hello", sent: time.Now()})
+
+	if p := tracker.match(CaseMappingASCII, ParseEvent(":nick PRIVMSG #A{B} :hello")); p != nil {
+		t.Fatalf("match(#A{B}) = %#v with ascii, want nil", p)
+	}
+
+	if p := tracker.match(CaseMappingASCII, ParseEvent(":nick PRIVMSG #A[B] :hello")); p == nil {
+		t.Fatal("match(#A[B]) = nil with ascii, want the pending message")
+	}
+
+	tracker.add(&pendingEcho{command: PRIVMSG, target: "#a[b]", text: "hello", sent: time.Now()})
+	if p := tracker.match(CaseMappingRFC1459, ParseEvent(":nick PRIVMSG #A{B} :hello")); p == nil {
+		t.Fatal("match(#A{B}) = nil with rfc1459, want the pending message")
+	}
+}
+
+func TestMessageEchoPartial(t *testing.T) {
+	c, server := mockClient(t, Config{RateBurst: 10})
+	defer c.Stop()
+
+	c.state.mu.Lock()
+	c.state.enabledCap = []string{capEchoMessage}
+	c.state.mu.Unlock()
+
+	// The capability is removed once the first line is sent.
+	c.AddSendHook(func(event *Event) (*Event, error) {
+		if event.Command == PRIVMSG {
+			c.state.mu.Lock()
+			c.state.enabledCap = nil
+			c.state.mu.Unlock()
+		}
+
+		return event, nil
+	})
+
+	sent, err := c.Commands.MessageEcho("#channel", "first\nsecond", func(EchoConfirmation) {})
+	if sent != 1 || err != ErrEchoUnsupported {
+		t.Fatalf("MessageEcho() = %d, %v, want 1 message sent before failing", sent, err)
+	}
+	server.expect("PRIVMSG #channel :first")
+}
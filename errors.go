@@ -0,0 +1,96 @@
+// Copyright (c) Liam Stanley <me@liamstanley.io>. All rights reserved. Use
+// of this source code is governed by the MIT license that can be found in
+// the LICENSE file.
+
+package girc
+
+import "errors"
+
+// Errors for the error numerics commands most commonly fail with. The
+// errors returned for failed commands (e.g. ErrJoinFailed, ErrWhoisFailed
+// and ErrNumeric) wrap these, so the reason can be checked with errors.Is,
+// regardless of the command:
+//
+//	if errors.Is(err, girc.ErrBannedFromChan) {
+//		// ...
+//	}
+var (
+	ErrNoSuchNick        = errors.New("no such nick/channel")                    // ERR_NOSUCHNICK
+	ErrNoSuchServer      = errors.New("no such server")                          // ERR_NOSUCHSERVER
+	ErrNoSuchChannel     = errors.New("no such channel")                         // ERR_NOSUCHCHANNEL
+	ErrWasNoSuchNick     = errors.New("there was no such nickname")              // ERR_WASNOSUCHNICK
+	ErrCannotSendToChan  = errors.New("cannot send to channel")                  // ERR_CANNOTSENDTOCHAN
+	ErrTooManyChannels   = errors.New("joined too many channels")                // ERR_TOOMANYCHANNELS
+	ErrTooManyTargets    = errors.New("too many targets")                        // ERR_TOOMANYTARGETS
+	ErrErroneousNickname = errors.New("erroneous nickname")                      // ERR_ERRONEUSNICKNAME
+	ErrNicknameInUse     = errors.New("nickname is already in use")              // ERR_NICKNAMEINUSE
+	ErrUnavailResource   = errors.New("nick/channel is temporarily unavailable") // ERR_UNAVAILRESOURCE
+	ErrUserNotInChannel  = errors.New("user is not on channel")                  // ERR_USERNOTINCHANNEL
+	ErrNotOnChannel      = errors.New("not on channel")                          // ERR_NOTONCHANNEL
+	ErrUserOnChannel     = errors.New("user is already on channel")              // ERR_USERONCHANNEL
+	ErrNeedMoreParams    = errors.New("not enough parameters")                   // ERR_NEEDMOREPARAMS
+	ErrChannelIsFull     = errors.New("channel is full")                         // ERR_CHANNELISFULL
+	ErrUnknownMode       = errors.New("unknown mode")                            // ERR_UNKNOWNMODE
+	ErrInviteOnlyChan    = errors.New("channel is invite only")                  // ERR_INVITEONLYCHAN
+	ErrBannedFromChan    = errors.New("banned from channel")                     // ERR_BANNEDFROMCHAN
+	ErrBadChannelKey     = errors.New("bad channel key")                         // ERR_BADCHANNELKEY
+	ErrBadChanMask       = errors.New("bad channel mask")                        // ERR_BADCHANMASK
+	ErrNoChanModes       = errors.New("channel doesn't support modes")           // ERR_NOCHANMODES
+	ErrBanListFull       = errors.New("channel list is full")                    // ERR_BANLISTFULL
+	ErrNoPrivileges      = errors.New("not an IRC operator")                     // ERR_NOPRIVILEGES
+	ErrChanOPrivsNeeded  = errors.New("not a channel operator")                  // ERR_CHANOPRIVSNEEDED
+)
+
+// numericErrors maps error numerics to their error.
+var numericErrors = map[string]error{
+	ERR_NOSUCHNICK:       ErrNoSuchNick,
+	ERR_NOSUCHSERVER:     ErrNoSuchServer,
+	ERR_NOSUCHCHANNEL:    ErrNoSuchChannel,
+	ERR_WASNOSUCHNICK:    ErrWasNoSuchNick,
+	ERR_CANNOTSENDTOCHAN: ErrCannotSendToChan,
+	ERR_TOOMANYCHANNELS:  ErrTooManyChannels,
+	ERR_TOOMANYTARGETS:   ErrTooManyTargets,
+	ERR_ERRONEUSNICKNAME: ErrErroneousNickname,
+	ERR_NICKNAMEINUSE:    ErrNicknameInUse,
+	ERR_UNAVAILRESOURCE:  ErrUnavailResource,
+	ERR_USERNOTINCHANNEL: ErrUserNotInChannel,
+	ERR_NOTONCHANNEL:     ErrNotOnChannel,
+	ERR_USERONCHANNEL:    ErrUserOnChannel,
+	ERR_NEEDMOREPARAMS:   ErrNeedMoreParams,
+	ERR_CHANNELISFULL:    ErrChannelIsFull,
+	ERR_UNKNOWNMODE:      ErrUnknownMode,
+	ERR_INVITEONLYCHAN:   ErrInviteOnlyChan,
+	ERR_BANNEDFROMCHAN:   ErrBannedFromChan,
+	ERR_BADCHANNELKEY:    ErrBadChannelKey,
+	ERR_BADCHANMASK:      ErrBadChanMask,
+	ERR_NOCHANMODES:      ErrNoChanModes,
+	ERR_BANLISTFULL:      ErrBanListFull,
+	ERR_NOPRIVILEGES:     ErrNoPrivileges,
+	ERR_CHANOPRIVSNEEDED: ErrChanOPrivsNeeded,
+}
+
+// NumericError returns the error for an error numeric (e.g. ErrNoSuchNick
+// for ERR_NOSUCHNICK), or nil if it has none.
+func NumericError(code string) error {
+	return numericErrors[code]
+}
+
+// ErrNumeric is returned when the server replies to a command with an error
+// numeric, which isn't specific to the command. It wraps the error for the
+// numeric, if any (see NumericError), for use with errors.Is.
+type ErrNumeric struct {
+	// Code is the numeric the server responded with.
+	Code string
+	// Target is the target (e.g. the nickname or channel) the error is
+	// about.
+	Target string
+	// Reason is the reason the server supplied, if any.
+	Reason string
+}
+
+func (e *ErrNumeric) Error() string {
+	return e.Target + " (" + e.Code + "): " + e.Reason
+}
+
+// Unwrap returns the error for the numeric, if any.
+func (e *ErrNumeric) Unwrap() error { return NumericError(e.Code) }
@@ -0,0 +1,103 @@
+// Copyright (c) Liam Stanley <me@liamstanley.io>. All rights reserved. Use
+// of this source code is governed by the MIT license that can be found in
+// the LICENSE file.
+
+package girc
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+func TestNumericErrors(t *testing.T) {
+	tests := []struct {
+		err  error
+		want error
+	}{
+		{err: &ErrJoinFailed{Channel: "#channel", Code: ERR_BANNEDFROMCHAN}, want: ErrBannedFromChan},
+		{err: &ErrJoinFailed{Channel: "#channel", Code: ERR_INVITEONLYCHAN}, want: ErrInviteOnlyChan},
+		{err: &ErrWhoisFailed{Nick: "nick", Code: ERR_NOSUCHNICK}, want: ErrNoSuchNick},
+		{err: &ErrModeListFailed{Channel: "#channel", Code: ERR_CHANOPRIVSNEEDED}, want: ErrChanOPrivsNeeded},
+		{err: &ErrNumeric{Target: "#channel", Code: ERR_CANNOTSENDTOCHAN}, want: ErrCannotSendToChan},
+	}
+
+	for _, tt := range tests {
+		if !errors.Is(tt.err, tt.want) {
+			t.Errorf("errors.Is(%v, %v) = false", tt.err, tt.want)
+		}
+
+		if errors.Is(tt.err, ErrNicknameInUse) {
+			t.Errorf("errors.Is(%v, %v) = true", tt.err, ErrNicknameInUse)
+		}
+	}
+
+	if err := NumericError(RPL_WELCOME); err != nil {
+		t.Errorf("NumericError(RPL_WELCOME) = %v, want nil", err)
+	}
+}
+
+func TestMessageSync(t *testing.T) {
+	c, server := mockClient(t, Config{})
+	defer c.Stop()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	if _, err := c.Commands.MessageSync(ctx, "#channel", "hello"); err != ErrEchoUnsupported {
+		t.Fatalf("MessageSync() without the capability returned %v", err)
+	}
+
+	c.state.mu.Lock()
+	c.state.enabledCap = []string{capEchoMessage}
+	c.state.mu.Unlock()
+
+	type result struct {
+		echoes []EchoConfirmation
+		err    error
+	}
+	results := make(chan result, 1)
+
+	go func() {
+		echoes, err := c.Commands.MessageSync(ctx, "#channel", "hello")
+		results <- result{echoes, err}
+	}()
+	server.expect("PRIVMSG #channel :hello")
+	server.send("@msgid=a :nick!user@host PRIVMSG #channel :hello")
+
+	if r := <-results; r.err != nil || len(r.echoes) != 1 || r.echoes[0].MsgID != "a" {
+		t.Fatalf("MessageSync() = %#v, %v", r.echoes, r.err)
+	}
+
+	go func() {
+		echoes, err := c.Commands.MessageSync(ctx, "ghost", "hello")
+		results <- result{echoes, err}
+	}()
+	server.expect("PRIVMSG ghost :hello")
+	server.send(":irc.example.com 401 nick Ghost :No such nick/channel")
+
+	r := <-results
+	var numeric *ErrNumeric
+	if !errors.Is(r.err, ErrNoSuchNick) || !errors.As(r.err, &numeric) || numeric.Target != "Ghost" {
+		t.Fatalf("MessageSync() to a missing user returned %v", r.err)
+	}
+
+	// With ascii, errors for a{b} aren't for a[b].
+	c.state.mu.Lock()
+	c.state.isupport.Casemapping = CaseMappingASCII
+	c.state.mu.Unlock()
+
+	go func() {
+		echoes, err := c.Commands.MessageSync(ctx, "a[b]", "hello")
+		results <- result{echoes, err}
+	}()
+	server.expect("PRIVMSG a[b] :hello")
+	server.send(":irc.example.com 401 nick a{b} :No such nick/channel")
+	server.send("@msgid=b :nick!user@host PRIVMSG A[B] :hello")
+
+	if r := <-results; r.err != nil || len(r.echoes) != 1 || r.echoes[0].MsgID != "b" {
+		t.Fatalf("MessageSync() = %#v, %v", r.echoes, r.err)
+	}
+}
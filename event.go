@@ -7,7 +7,13 @@ package girc
 import (
 	"bytes"
 	"fmt"
+	"io"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/context"
 )
 
 const (
@@ -33,22 +39,99 @@ func cutCRFunc(r rune) bool {
 //                   CR or LF>
 //    <crlf>     :: CR LF
 type Event struct {
-	Source        *Source  // The source of the event.
-	Tags          Tags     // IRCv3 style message tags. Only use if network supported.
-	Command       string   // the IRC command, e.g. JOIN, PRIVMSG, KILL.
-	Params        []string // parameters to the command. Commonly nickname, channel, etc.
-	Trailing      string   // any trailing data. e.g. with a PRIVMSG, this is the message text.
-	EmptyTrailing bool     // if true, trailing prefix (:) will be added even if Event.Trailing is empty.
-	Sensitive     bool     // if the message is sensitive (e.g. and should not be logged).
+	Source        *Source   // The source of the event.
+	Tags          Tags      // IRCv3 style message tags. Only use if network supported. See Event.Tag.
+	RawTags       string    // the escaped tags as received, without the "@", if tags are parsed lazily (see Config.LazyTags). Encoded instead of Tags if Tags is nil.
+	Command       string    // the IRC command, e.g. JOIN, PRIVMSG, KILL.
+	Params        []string  // parameters to the command. Commonly nickname, channel, etc.
+	Trailing      string    // any trailing data. e.g. with a PRIVMSG, this is the message text.
+	EmptyTrailing bool      // if true, trailing prefix (:) will be added even if Event.Trailing is empty.
+	Sensitive     bool      // if the message is sensitive (e.g. and should not be logged).
+	Batch         *Batch    // the IRCv3 batch the event was received in, if any. See Batch.
+	Timestamp     time.Time // when the event happened, from the server-time tag if available, otherwise when it was received.
+	Replayed      bool      // if the event was missed, and is replayed from history (see Config.Backfill). Replayed events only go through user handlers.
+
+	// ctx carries the trace span of the event, if tracing is enabled. See
+	// Event.Context.
+	ctx context.Context
+	// sent ends tracing sending the event, until it has been sent. See
+	// Tracer.StartSend.
+	sent func(err error)
+	// self is true if the event is from ourselves. See Event.IsSelf.
+	self bool
+}
+
+// ErrParse is returned by ParseEventStrict when a message is invalid.
+type ErrParse struct {
+	// Line is the invalid message.
+	Line string
+	// Reason is why the message is invalid.
+	Reason string
+}
+
+func (e *ErrParse) Error() string {
+	return "invalid message " + strconv.Quote(e.Line) + ": " + e.Reason
 }
 
 // ParseEvent takes a string and attempts to create a Event struct.
+// Malformed messages are repaired where possible, e.g. invalid tags are
+// dropped. See ParseEventStrict to reject them instead.
 //
 // Returns nil if the Event is invalid.
 func ParseEvent(raw string) (e *Event) {
+	e, _ = parseEvent(raw, false, false)
+	return e
+}
+
+// ParseEventStrict is much like ParseEvent, however follows the message
+// format exactly (as validated by the irc-parser-tests corpus, see
+// https://github.com/ircdocs/parser-tests), returning an *ErrParse
+// instead of repairing malformed messages: messages containing NUL, CR or
+// LF (other than the line ending), invalid or empty tags, empty sources,
+// and commands which aren't letters or a three digit numeric are
+// rejected. See Config.StrictParsing.
+func ParseEventStrict(raw string) (*Event, error) {
+	return parseEvent(raw, true, false)
+}
+
+// parseEvent parses raw, repairing malformed messages unless strict is
+// true. The error is only set in strict mode. If lazy is true, only
+// Event.RawTags is set, not Event.Tags.
+func parseEvent(raw string, strict, lazy bool) (e *Event, err error) {
+	line := raw
+
+	// invalid returns the error for the message, in strict mode.
+	invalid := func(reason string) (*Event, error) {
+		if !strict {
+			return nil, nil
+		}
+
+		return nil, &ErrParse{Line: line, Reason: reason}
+	}
+
+	if strict {
+		// Only the line ending is removed.
+		raw = strings.TrimSuffix(raw, "\n")
+		raw = strings.TrimSuffix(raw, "\r")
+
+		if strings.IndexAny(raw, "\x00\r\n") > -1 {
+			return invalid("contains NUL, CR or LF")
+		}
+	} else {
+		raw = strings.TrimFunc(raw, cutCRFunc)
+
+		// Only the first line is parsed, if there are multiple.
+		if i := strings.IndexAny(raw, "\r\n"); i > -1 {
+			raw = raw[:i]
+		}
+
+		// Ignore leading spaces, so tags or a source after them are found.
+		raw = strings.TrimLeft(raw, string(eventSpace))
+	}
+
 	// Ignore empty events.
-	if raw = strings.TrimFunc(raw, cutCRFunc); len(raw) < 2 {
-		return nil
+	if len(raw) == 0 {
+		return invalid("empty message")
 	}
 
 	i, j := 0, 0
@@ -59,11 +142,30 @@ func ParseEvent(raw string) (e *Event) {
 		i = strings.IndexByte(raw, eventSpace)
 
 		if i < 2 {
-			return nil
+			return invalid("empty or unterminated tags")
 		}
 
-		e.Tags = ParseTags(raw[1:i])
-		raw = raw[i+1:]
+		if strict {
+			if err = checkTags(raw[1:i]); err != nil {
+				return invalid(err.Error())
+			}
+		}
+
+		if lazy {
+			e.RawTags = raw[1:i]
+		} else {
+			e.Tags = ParseTags(raw[1:i])
+		}
+
+		if tag, ok := e.Tag("time"); ok {
+			e.Timestamp, _ = parseTagTime(tag)
+		}
+		raw = strings.TrimLeft(raw[i+1:], string(eventSpace))
+		i = 0
+
+		if raw == "" {
+			return invalid("no command")
+		}
 	}
 
 	if raw[0] == messagePrefix {
@@ -72,7 +174,7 @@ func ParseEvent(raw string) (e *Event) {
 
 		// Prefix string must not be empty if the indicator is present.
 		if i < 2 {
-			return nil
+			return invalid("empty or unterminated source")
 		}
 
 		e.Source = ParseSource(raw[1:i])
@@ -81,44 +183,108 @@ func ParseEvent(raw string) (e *Event) {
 		i++
 	}
 
+	// Skip any extra spaces before the command.
+	for i < len(raw) && raw[i] == eventSpace {
+		i++
+	}
+
 	// Find end of command.
 	j = i + strings.IndexByte(raw[i:], eventSpace)
-
-	// Extract command.
 	if j < i {
-		e.Command = strings.ToUpper(raw[i:])
-		return e
+		j = len(raw)
+	}
+
+	if i == j {
+		return invalid("no command")
+	}
+
+	// Commands starting with "@" would be mistaken for tags when encoded.
+	if raw[i] == prefixTag {
+		return invalid("invalid command")
+	}
+
+	if strict && !validCommand(raw[i:j]) {
+		return invalid("invalid command")
 	}
 
 	e.Command = strings.ToUpper(raw[i:j])
-	// Skip space after command.
-	j++
 
-	// Find prefix for trailer.
-	i = strings.IndexByte(raw[j:], messagePrefix)
+	// Parameters are separated by one or more spaces. Only a colon at the
+	// start of a parameter starts the trailing parameter, e.g.
+	// "TARGMAX=JOIN: :text" is a regular parameter, followed by the
+	// trailing one.
+	for j < len(raw) {
+		if raw[j] == eventSpace {
+			j++
+			continue
+		}
+
+		if raw[j] == messagePrefix {
+			e.Trailing = raw[j+1:]
 
-	if i < 0 || raw[j+i-1] != eventSpace {
-		// No trailing argument.
-		e.Params = strings.Split(raw[j:], string(eventSpace))
-		return e
+			// We need to re-encode the trailing argument even if it was
+			// empty.
+			e.EmptyTrailing = len(e.Trailing) <= 0
+			break
+		}
+
+		end := strings.IndexByte(raw[j:], eventSpace)
+		if end < 0 {
+			end = len(raw) - j
+		}
+
+		e.Params = append(e.Params, raw[j:j+end])
+		j += end
 	}
 
-	// Compensate for index on substring.
-	i = i + j
+	return e, nil
+}
 
-	// Check if we need to parse arguments.
-	if i > j {
-		e.Params = strings.Split(raw[j:i-1], string(eventSpace))
+// validCommand returns true if cmd is a valid command: one or more letters,
+// or a three digit numeric.
+func validCommand(cmd string) bool {
+	if len(cmd) == 3 && isFmtDigit(cmd[0]) && isFmtDigit(cmd[1]) && isFmtDigit(cmd[2]) {
+		return true
 	}
 
-	e.Trailing = raw[i+1:]
+	if cmd == "" {
+		return false
+	}
 
-	// We need to re-encode the trailing argument even if it was empty.
-	if len(e.Trailing) <= 0 {
-		e.EmptyTrailing = true
+	for i := 0; i < len(cmd); i++ {
+		// A-Z, a-z
+		if (cmd[i] < 0x41 || cmd[i] > 0x5A) && (cmd[i] < 0x61 || cmd[i] > 0x7A) {
+			return false
+		}
 	}
 
-	return e
+	return true
+}
+
+// Tag returns the unescaped value of the tag key of the event, like
+// Tags.Get, however also works for events received with Config.LazyTags,
+// without parsing all of their tags.
+func (e *Event) Tag(key string) (value string, ok bool) {
+	if e.Tags != nil {
+		return e.Tags.Get(key)
+	}
+
+	if value, ok = rawTag(e.RawTags, key); ok {
+		value = unescapeTag(value)
+	}
+
+	return value, ok
+}
+
+// LoadTags parses the raw tags of the event (see Event.RawTags) into
+// Event.Tags, if they haven't been parsed already, and returns them. This
+// is only needed for events received with Config.LazyTags.
+func (e *Event) LoadTags() Tags {
+	if e.Tags == nil && e.RawTags != "" {
+		e.Tags = ParseTags(e.RawTags)
+	}
+
+	return e.Tags
 }
 
 // Copy makes a deep copy of a given event, for use with allowing untrusted
@@ -131,11 +297,13 @@ func (e *Event) Copy() *Event {
 
 	// Copy Source field, as it's a pointer and needs to be dereferenced.
 	if e.Source != nil {
+		newEvent.Source = &Source{}
 		*newEvent.Source = *e.Source
 	}
 
 	// Copy Params in order to dereference as well.
 	if e.Params != nil {
+		newEvent.Params = make([]string, len(e.Params))
 		copy(newEvent.Params, e.Params)
 	}
 
@@ -155,6 +323,9 @@ func (e *Event) Len() (length int) {
 	if e.Tags != nil {
 		// Include tags and trailing space.
 		length = e.Tags.Len() + 1
+	} else if e.RawTags != "" {
+		// Include the tag prefix and trailing space.
+		length = len(e.RawTags) + 2
 	}
 	if e.Source != nil {
 		// Include prefix and trailing space.
@@ -169,6 +340,11 @@ func (e *Event) Len() (length int) {
 		for i := 0; i < len(e.Params); i++ {
 			length += len(e.Params[i])
 		}
+
+		if e.lastParamTrailing() {
+			// Include the trailing prefix.
+			length++
+		}
 	}
 
 	if len(e.Trailing) > 0 || e.EmptyTrailing {
@@ -179,18 +355,54 @@ func (e *Event) Len() (length int) {
 	return
 }
 
-// Bytes returns a []byte representation of event. Strips all newlines and
-// carriage returns.
-//
-// Per RFC2812 section 2.3, messages should not exceed 512 characters in
-// length. This method forces that limit by discarding any characters
-// exceeding the length limit.
-func (e *Event) Bytes() []byte {
-	buffer := new(bytes.Buffer)
+// lastParamTrailing returns true if the last parameter of the event must
+// be sent as the trailing parameter, as it's empty, contains spaces or
+// starts with a colon, and there is no Trailing.
+func (e *Event) lastParamTrailing() bool {
+	if len(e.Params) == 0 || len(e.Trailing) > 0 || e.EmptyTrailing {
+		return false
+	}
+
+	last := e.Params[len(e.Params)-1]
+	return last == "" || last[0] == messagePrefix || strings.IndexByte(last, eventSpace) > -1
+}
+
+// maxPooledBuffer is the maximum capacity of the buffers which are returned
+// to eventBuffers, so the pool doesn't hold on to unusually large buffers.
+const maxPooledBuffer = 4096
+
+// eventBuffers are the buffers events are encoded into. See Event.WriteTo.
+var eventBuffers = sync.Pool{
+	New: func() interface{} { return new(bytes.Buffer) },
+}
+
+// getEventBuffer returns an empty buffer from eventBuffers.
+func getEventBuffer() *bytes.Buffer {
+	buffer := eventBuffers.Get().(*bytes.Buffer)
+	buffer.Reset()
+
+	return buffer
+}
+
+// putEventBuffer returns buffer to eventBuffers.
+func putEventBuffer(buffer *bytes.Buffer) {
+	if buffer.Cap() <= maxPooledBuffer {
+		eventBuffers.Put(buffer)
+	}
+}
+
+// encode appends the representation of event to buffer. See Bytes.
+func (e *Event) encode(buffer *bytes.Buffer) {
+	start := buffer.Len()
 
 	// Tags.
-	if e.Tags != nil {
+	if len(e.Tags) > 0 {
 		e.Tags.writeTo(buffer)
+		buffer.WriteByte(eventSpace)
+	} else if e.Tags == nil && e.RawTags != "" {
+		buffer.WriteByte(prefixTag)
+		buffer.WriteString(e.RawTags)
+		buffer.WriteByte(eventSpace)
 	}
 
 	// Event prefix.
@@ -204,9 +416,14 @@ func (e *Event) Bytes() []byte {
 	buffer.WriteString(e.Command)
 
 	// Space separated list of arguments.
-	if len(e.Params) > 0 {
+	last := len(e.Params) - 1
+	trailing := e.lastParamTrailing()
+	for i := 0; i <= last; i++ {
 		buffer.WriteByte(eventSpace)
-		buffer.WriteString(strings.Join(e.Params, string(eventSpace)))
+		if i == last && trailing {
+			buffer.WriteByte(messagePrefix)
+		}
+		buffer.WriteString(e.Params[i])
 	}
 
 	if len(e.Trailing) > 0 || e.EmptyTrailing {
@@ -216,32 +433,65 @@ func (e *Event) Bytes() []byte {
 	}
 
 	// We need the limit the buffer length.
-	if buffer.Len() > (maxLength) {
-		if e.Tags != nil {
-			// regular message, max tag length, and the splitting space.
-			buffer.Truncate(maxLength + maxTagLength + 1)
-		} else {
-			buffer.Truncate(maxLength)
-		}
+	limit := maxLength
+	if len(e.Tags) > 0 || (e.Tags == nil && e.RawTags != "") {
+		// regular message, max tag length, and the splitting space.
+		limit = maxLength + maxTagLength + 1
+	}
+	if buffer.Len()-start > limit {
+		buffer.Truncate(start + limit)
 	}
-
-	out := buffer.Bytes()
 
 	// Strip newlines and carriage returns.
+	out := buffer.Bytes()[start:]
+	n := 0
 	for i := 0; i < len(out); i++ {
-		if out[i] == 0x0A || out[i] == 0x0D {
-			out = append(out[:i], out[i+1:]...)
-			i-- // Decrease the index so we can pick up where we left off.
+		if out[i] != 0x0A && out[i] != 0x0D {
+			out[n] = out[i]
+			n++
 		}
 	}
+	buffer.Truncate(start + n)
+}
+
+// Bytes returns a []byte representation of event. Strips all newlines and
+// carriage returns.
+//
+// Per RFC2812 section 2.3, messages should not exceed 512 characters in
+// length. This method forces that limit by discarding any characters
+// exceeding the length limit.
+func (e *Event) Bytes() []byte {
+	buffer := getEventBuffer()
+	defer putEventBuffer(buffer)
+
+	e.encode(buffer)
+
+	out := make([]byte, buffer.Len())
+	copy(out, buffer.Bytes())
 
 	return out
 }
 
+// WriteTo writes the representation of event (see Bytes, without a line
+// ending) to w, implementing io.WriterTo. The event is encoded into a
+// pooled buffer, so unlike Bytes, it doesn't allocate for every event. The
+// client uses it to write events to the connection.
+func (e *Event) WriteTo(w io.Writer) (n int64, err error) {
+	buffer := getEventBuffer()
+	defer putEventBuffer(buffer)
+
+	e.encode(buffer)
+	return buffer.WriteTo(w)
+}
+
 // String returns a string representation of this event. Strips all newlines
 // and carriage returns.
 func (e *Event) String() string {
-	return string(e.Bytes())
+	buffer := getEventBuffer()
+	defer putEventBuffer(buffer)
+
+	e.encode(buffer)
+	return buffer.String()
 }
 
 // Pretty returns a prettified string of the event. If the event doesn't
@@ -278,7 +528,7 @@ func (e *Event) Pretty() (out string, ok bool) {
 	}
 
 	if (e.Command == PRIVMSG || e.Command == NOTICE) && len(e.Params) > 0 {
-		if ctcp := decodeCTCP(e); ctcp != nil {
+		if ctcp := DecodeCTCP(e); ctcp != nil {
 			if ctcp.Reply {
 				return
 			}
@@ -394,6 +644,15 @@ func (e *Event) IsFromUser() bool {
 	return true
 }
 
+// IsSelf returns true if the event is from ourselves, by our nickname or
+// account (see the account-tag capability), e.g. our own messages echoed
+// back by the server (see the echo-message capability), or those sent by
+// our other clients of a bouncer (see znc.in/self-message). Only set for
+// events received by a client with tracking enabled.
+func (e *Event) IsSelf() bool {
+	return e.self
+}
+
 // StripAction returns the stripped version of the action encoding from a
 // PRIVMSG ACTION (/me).
 func (e *Event) StripAction() string {
@@ -5,8 +5,13 @@
 package girc
 
 import (
+	"bufio"
+	"bytes"
+	"io/ioutil"
 	"reflect"
+	"strings"
 	"testing"
+	"time"
 )
 
 func mockEvent() *Event {
@@ -74,3 +79,232 @@ func TestParseSource(t *testing.T) {
 		}
 	}
 }
+
+func TestParseEventTags(t *testing.T) {
+	tests := []struct {
+		raw  string
+		want *Event
+	}{
+		{raw: "@batch=ref PRIVMSG #channel :hello", want: &Event{
+			Tags: Tags{"batch": "ref"}, Command: PRIVMSG, Params: []string{"#channel"}, Trailing: "hello",
+		}},
+		{raw: "@batch=ref;draft/multiline-concat :nick!user@host PRIVMSG #channel :hello", want: &Event{
+			Tags:    Tags{"batch": "ref", "draft/multiline-concat": ""},
+			Source:  &Source{Name: "nick", Ident: "user", Host: "host"},
+			Command: PRIVMSG, Params: []string{"#channel"}, Trailing: "hello",
+		}},
+		{raw: "@time=2011-10-19T16:40:51.620Z :nick!user@host JOIN #channel", want: &Event{
+			Tags:      Tags{"time": "2011-10-19T16:40:51.620Z"},
+			Source:    &Source{Name: "nick", Ident: "user", Host: "host"},
+			Command:   JOIN,
+			Params:    []string{"#channel"},
+			Timestamp: time.Date(2011, 10, 19, 16, 40, 51, 620000000, time.UTC),
+		}},
+		{raw: "@time=invalid JOIN #channel", want: &Event{
+			Tags: Tags{"time": "invalid"}, Command: JOIN, Params: []string{"#channel"},
+		}},
+	}
+
+	for _, tt := range tests {
+		got := ParseEvent(tt.raw)
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("ParseEvent(%q) = %#v, want %#v", tt.raw, got, tt.want)
+		}
+
+		if got != nil && got.String() != tt.raw && len(got.Tags) == 1 {
+			t.Errorf("ParseEvent(%q).String() = %q", tt.raw, got.String())
+		}
+	}
+}
+
+func TestParseEventStrict(t *testing.T) {
+	tests := []struct {
+		raw   string
+		valid bool
+	}{
+		{raw: "@a=b;c :nick!user@host PRIVMSG #channel :hello\r\n", valid: true},
+		{raw: ":irc.example.com 001 nick :Welcome", valid: true},
+		{raw: "PING", valid: true},
+		{raw: "PRIVMSG #channel :hel\x00lo"},
+		{raw: "PRIVMSG #channel :hel\rlo"},
+		{raw: "@a=b; PRIVMSG #channel :hello"},
+		{raw: "@=b PRIVMSG #channel"},
+		{raw: "@a=b"},
+		{raw: ": PRIVMSG #channel :hello"},
+		{raw: ":nick 01 #channel"},
+		{raw: ":nick PRIV-MSG #channel"},
+		{raw: "\r\n"},
+	}
+
+	for _, tt := range tests {
+		e, err := ParseEventStrict(tt.raw)
+		if tt.valid && (err != nil || e == nil) {
+			t.Errorf("ParseEventStrict(%q) = %v, %v, want event", tt.raw, e, err)
+		}
+
+		if _, ok := err.(*ErrParse); !tt.valid && !ok {
+			t.Errorf("ParseEventStrict(%q) = %v, %v, want *ErrParse", tt.raw, e, err)
+		}
+	}
+
+	// The lenient parser repairs what it can.
+	if e := ParseEvent("@a=b; PRIVMSG #channel :hello"); e == nil || len(e.Tags) != 1 {
+		t.Errorf("ParseEvent() didn't drop the empty tag: %#v", e)
+	}
+}
+
+func TestEventLastParamTrailing(t *testing.T) {
+	tests := []struct {
+		event *Event
+		want  string
+	}{
+		{event: &Event{Command: PRIVMSG, Params: []string{"#channel", "hello world"}}, want: "PRIVMSG #channel :hello world"},
+		{event: &Event{Command: AWAY, Params: []string{""}}, want: "AWAY :"},
+		{event: &Event{Command: PRIVMSG, Params: []string{"#channel", ":)"}}, want: "PRIVMSG #channel ::)"},
+		{event: &Event{Command: MODE, Params: []string{"#channel", "+o", "nick"}}, want: "MODE #channel +o nick"},
+		{event: &Event{Command: PRIVMSG, Params: []string{"#channel", "a b"}, Trailing: "c"}, want: "PRIVMSG #channel a b :c"},
+	}
+
+	for _, tt := range tests {
+		if got := tt.event.String(); got != tt.want {
+			t.Errorf("%#v.String() = %q, want %q", tt.event, got, tt.want)
+		}
+
+		if tt.event.Len() != len(tt.want) {
+			t.Errorf("%#v.Len() = %d, want %d", tt.event, tt.event.Len(), len(tt.want))
+		}
+	}
+}
+
+func TestParseEventMalformed(t *testing.T) {
+	tests := []string{"", "\r\n", "@a=b ", "@a ", "@ :", "@", ":", ": ", ":src", ":src ", "@a=b :src ", "   "}
+
+	for _, raw := range tests {
+		if e := ParseEvent(raw); e != nil {
+			t.Errorf("ParseEvent(%q) = %#v, want nil", raw, e)
+		}
+
+		if _, err := ParseEventStrict(raw); err == nil {
+			t.Errorf("ParseEventStrict(%q) returned no error", raw)
+		}
+	}
+}
+
+func TestEventWriteTo(t *testing.T) {
+	long := strings.Repeat("a", 1000)
+
+	tests := []struct {
+		name  string
+		event *Event
+		want  int
+	}{
+		{name: "simple", event: mockEvent(), want: len(":nick!user@host.com PRIVMSG #channel :1 2 3")},
+		{name: "newlines", event: &Event{Command: PRIVMSG, Params: []string{"#channel"}, Trailing: "a\r\nb"}, want: len("PRIVMSG #channel :ab")},
+		{name: "long", event: &Event{Command: PRIVMSG, Params: []string{"#channel"}, Trailing: long}, want: maxLength},
+		{name: "tags", event: &Event{Tags: Tags{"a": "b"}, Command: PRIVMSG, Params: []string{"#channel"}, Trailing: long[:600]}, want: len("@a=b PRIVMSG #channel :") + 600},
+	}
+
+	for _, tt := range tests {
+		buf := &bytes.Buffer{}
+		n, err := tt.event.WriteTo(buf)
+		if err != nil || int(n) != buf.Len() {
+			t.Errorf("%s: WriteTo() = %d, %v, but wrote %d bytes", tt.name, n, err, buf.Len())
+		}
+
+		if buf.Len() != tt.want {
+			t.Errorf("%s: WriteTo() wrote %d bytes, want %d", tt.name, buf.Len(), tt.want)
+		}
+
+		if !bytes.Equal(buf.Bytes(), tt.event.Bytes()) || buf.String() != tt.event.String() {
+			t.Errorf("%s: WriteTo() wrote %q, but Bytes() = %q", tt.name, buf.String(), tt.event.Bytes())
+		}
+	}
+}
+
+func BenchmarkEventBytes(b *testing.B) {
+	e := mockEvent()
+	b.ReportAllocs()
+
+	for i := 0; i < b.N; i++ {
+		e.Bytes()
+	}
+}
+
+func BenchmarkEventWriteTo(b *testing.B) {
+	e := mockEvent()
+	w := bufio.NewWriter(ioutil.Discard)
+	b.ReportAllocs()
+
+	for i := 0; i < b.N; i++ {
+		e.WriteTo(w)
+	}
+}
+
+func TestLazyTags(t *testing.T) {
+	raw := "@time=2011-10-19T16:40:51.620Z;+draft/reply=a\\sb;k :nick!user@host PRIVMSG #channel :hello"
+
+	e, _ := parseEvent(raw, false, true)
+	if e.Tags != nil || e.RawTags != "time=2011-10-19T16:40:51.620Z;+draft/reply=a\\sb;k" {
+		t.Fatalf("parseEvent() with lazy tags = %#v", e)
+	}
+
+	if e.Timestamp != time.Date(2011, 10, 19, 16, 40, 51, 620000000, time.UTC) {
+		t.Errorf("Timestamp = %s, want the time tag", e.Timestamp)
+	}
+	if value, ok := e.Tag("+draft/reply"); !ok || value != "a b" {
+		t.Errorf("Tag(+draft/reply) = %q, %v", value, ok)
+	}
+	if _, ok := e.Tag("missing"); ok {
+		t.Error("Tag(missing) returned a value")
+	}
+
+	if got := e.String(); got != raw || e.Len() != len(raw) {
+		t.Errorf("String() = %q, Len() = %d, want %q", got, e.Len(), raw)
+	}
+
+	if tags := e.LoadTags(); !reflect.DeepEqual(tags, ParseEvent(raw).Tags) {
+		t.Errorf("LoadTags() = %#v", tags)
+	}
+}
+
+func TestLazyTagsClient(t *testing.T) {
+	c, server := mockClient(t, Config{LazyTags: true})
+	defer c.Stop()
+
+	events := make(chan Event, 1)
+	c.Handlers.Add(PRIVMSG, func(c *Client, e Event) {
+		events <- e
+	})
+
+	server.send("@account=acc;msgid=1 :other!user@host PRIVMSG #channel :hello")
+
+	select {
+	case e := <-events:
+		if e.Tags != nil || e.RawTags != "account=acc;msgid=1" {
+			t.Fatalf("handler was passed %#v, want lazily parsed tags", e)
+		}
+		if msgid, _ := e.Tag("msgid"); msgid != "1" {
+			t.Fatalf("Tag(msgid) = %q, want %q", msgid, "1")
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("handler never called")
+	}
+}
+
+func BenchmarkParseEventTags(b *testing.B) {
+	raw := "@time=2011-10-19T16:40:51.620Z;account=nick;msgid=abc :nick!user@host PRIVMSG #channel :hello"
+	b.ReportAllocs()
+
+	for i := 0; i < b.N; i++ {
+		ParseEvent(raw)
+	}
+}
+
+func BenchmarkParseEventLazyTags(b *testing.B) {
+	raw := "@time=2011-10-19T16:40:51.620Z;account=nick;msgid=abc :nick!user@host PRIVMSG #channel :hello"
+	b.ReportAllocs()
+
+	for i := 0; i < b.N; i++ {
+		parseEvent(raw, false, true)
+	}
+}
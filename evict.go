@@ -0,0 +1,166 @@
+// Copyright (c) Liam Stanley <me@liamstanley.io>. All rights reserved. Use
+// of this source code is governed by the MIT license that can be found in
+// the LICENSE file.
+
+package girc
+
+import (
+	"sort"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+// stateLimits are the limits on the amount of tracked users. See
+// Config.MaxChannelUsers, Config.MaxUsers and Config.UserTTL.
+type stateLimits struct {
+	channelUsers int
+	users        int
+	ttl          time.Duration
+}
+
+// StateStats are statistics about the tracked state. See Client.StateStats.
+type StateStats struct {
+	// Channels is the amount of tracked channels.
+	Channels int
+	// Users is the amount of tracked users, counting a user once for each
+	// channel they are tracked in.
+	Users int
+	// Evicted is the amount of users which were removed from state since
+	// connecting, due to Config.MaxChannelUsers, Config.MaxUsers or
+	// Config.UserTTL.
+	Evicted uint64
+}
+
+// StateStats returns statistics about the tracked state, e.g. to monitor
+// memory usage on large networks. Panics if tracking is disabled.
+func (c *Client) StateStats() StateStats {
+	c.panicIfNotTracking()
+
+	c.state.mu.RLock()
+	defer c.state.mu.RUnlock()
+
+	return StateStats{
+		Channels: len(c.state.channels),
+		Users:    c.state.userCount(),
+		Evicted:  c.state.evicted,
+	}
+}
+
+// trackedUser is a user in a specific channel, used to find which users
+// to evict.
+type trackedUser struct {
+	channel *Channel
+	key     string
+	user    *User
+}
+
+// userCount returns the amount of tracked users, across all channels.
+// Always use state.mu for transaction.
+func (s *state) userCount() (count int) {
+	for _, channel := range s.channels {
+		count += len(channel.users)
+	}
+
+	return count
+}
+
+// evictionTarget returns the amount of users to keep once limit has been
+// exceeded. Users are evicted in batches (down to 90% of the limit), so
+// the cost of finding the least recently active users is spread out.
+func evictionTarget(limit int) int {
+	return limit - (limit+9)/10
+}
+
+// enforceLimits evicts the least recently active users, if the channel or
+// total user limits have been exceeded after adding user to channel. Our own
+// user, and the added user, are never evicted. Always use state.mu for
+// transaction.
+func (s *state) enforceLimits(channel *Channel, user *User) {
+	if s.limits.channelUsers > 0 && len(channel.users) > s.limits.channelUsers {
+		s.evict(s.trackedUsers(channel, user), len(channel.users)-evictionTarget(s.limits.channelUsers))
+	}
+
+	if s.limits.users > 0 {
+		if count := s.userCount(); count > s.limits.users {
+			s.evict(s.trackedUsers(nil, user), count-evictionTarget(s.limits.users))
+		}
+	}
+}
+
+// evictInactive evicts all users which haven't been active within the
+// configured TTL. Always use state.mu for transaction.
+func (s *state) evictInactive() {
+	if s.limits.ttl <= 0 {
+		return
+	}
+
+	users := s.trackedUsers(nil, nil)
+	deadline := time.Now().Add(-s.limits.ttl)
+
+	i := sort.Search(len(users), func(i int) bool { return !users[i].user.lastActive().Before(deadline) })
+	s.evict(users, i)
+}
+
+// trackedUsers returns the users in the channel (or all channels, if nil),
+// excluding ourselves and keep, ordered from least to most recently active.
+// Always use state.mu for transaction.
+func (s *state) trackedUsers(channel *Channel, keep *User) []trackedUser {
+	channels := s.channels
+	if channel != nil {
+		channels = map[string]*Channel{channel.Name: channel}
+	}
+
+	self := s.toLower(s.nick)
+
+	var users []trackedUser
+	for _, ch := range channels {
+		for key, user := range ch.users {
+			if key != self && user != keep {
+				users = append(users, trackedUser{channel: ch, key: key, user: user})
+			}
+		}
+	}
+
+	sort.Slice(users, func(i, j int) bool { return users[i].user.lastActive().Before(users[j].user.lastActive()) })
+
+	return users
+}
+
+// evict removes the first n users. Always use state.mu for transaction.
+func (s *state) evict(users []trackedUser, n int) {
+	if n > len(users) {
+		n = len(users)
+	}
+
+	for i := 0; i < n; i++ {
+		delete(users[i].channel.users, users[i].key)
+		s.evicted++
+	}
+}
+
+// evictLoop periodically evicts inactive users. See Config.UserTTL.
+func (c *Client) evictLoop(ctx context.Context) {
+	if c.Config.UserTTL <= 0 || c.Config.disableTracking {
+		return
+	}
+
+	interval := time.Minute
+	if c.Config.UserTTL < interval {
+		interval = c.Config.UserTTL
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			c.state.mu.Lock()
+			c.state.evictInactive()
+			c.state.mu.Unlock()
+		}
+	}
+}
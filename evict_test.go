@@ -0,0 +1,77 @@
+// Copyright (c) Liam Stanley <me@liamstanley.io>. All rights reserved. Use
+// of this source code is governed by the MIT license that can be found in
+// the LICENSE file.
+
+package girc
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestStateLimits(t *testing.T) {
+	c := New(Config{
+		Server: "irc.example.com", Nick: "nick", User: "user",
+		MaxChannelUsers: 10, MaxUsers: 15, UserTTL: time.Hour,
+	})
+
+	c.state.mu.Lock()
+	c.state.nick = "nick"
+	c.state.createUserIfNotExists("#one", "nick").setActive(time.Now().Add(-24 * time.Hour))
+
+	// The least recently active users are evicted first.
+	for i := 0; i < 10; i++ {
+		c.state.createUserIfNotExists("#one", fmt.Sprintf("user%d", i)).setActive(time.Now().Add(time.Duration(i-10) * time.Minute))
+	}
+	c.state.mu.Unlock()
+
+	stats := c.StateStats()
+	if stats.Users != 9 || stats.Evicted != 2 {
+		t.Fatalf("StateStats() = %+v, want 9 users and 2 evicted", stats)
+	}
+
+	channel := c.Lookup("#one")
+	for _, nick := range []string{"nick", "user2", "user9"} {
+		if channel.Lookup(nick) == nil {
+			t.Fatalf("%s was evicted", nick)
+		}
+	}
+	if channel.Lookup("user1") != nil {
+		t.Fatal("user1 wasn't evicted")
+	}
+
+	// Total limit, across channels.
+	c.state.mu.Lock()
+	for i := 0; i < 7; i++ {
+		c.state.createUserIfNotExists("#two", fmt.Sprintf("other%d", i))
+	}
+	c.state.mu.Unlock()
+
+	// Evicted down to 90% of the limit.
+	if stats = c.StateStats(); stats.Users != 13 || stats.Channels != 2 || stats.Evicted != 5 {
+		t.Fatalf("StateStats() = %+v, want 13 users in 2 channels, and 5 evicted", stats)
+	}
+	if c.Lookup("#one").Lookup("user4") != nil || c.Lookup("#one").Lookup("user5") == nil {
+		t.Fatal("unexpected users evicted from #one")
+	}
+
+	// Inactive users.
+	c.state.mu.Lock()
+	c.state.evictInactive()
+	c.state.mu.Unlock()
+
+	if stats = c.StateStats(); stats.Users != 13 {
+		t.Fatalf("StateStats() = %+v, want no inactive users evicted", stats)
+	}
+
+	c.state.mu.Lock()
+	c.state.limits.ttl = 3 * time.Minute
+	c.state.evictInactive()
+	c.state.mu.Unlock()
+
+	// user8, user9, other0 to other6, and ourselves.
+	if stats = c.StateStats(); stats.Users != 10 {
+		t.Fatalf("StateStats() = %+v, want 10 users after evicting inactive users", stats)
+	}
+}
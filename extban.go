@@ -0,0 +1,197 @@
+// Copyright (c) Liam Stanley <me@liamstanley.io>. All rights reserved. Use
+// of this source code is governed by the MIT license that can be found in
+// the LICENSE file.
+
+package girc
+
+import "strings"
+
+// ExtBanKind is the meaning of an extended ban type, which differs between
+// servers. See ISupport.ExtBan.
+type ExtBanKind int
+
+const (
+	// ExtBanUnknown is an extended ban type which isn't known.
+	ExtBanUnknown ExtBanKind = iota
+	// ExtBanAccount matches users logged in to a matching account (e.g.
+	// "$a:account"), or any logged in user without a value.
+	ExtBanAccount
+	// ExtBanRealname matches users with a matching realname (e.g.
+	// "$r:*bot*").
+	ExtBanRealname
+	// ExtBanChannel matches users in a channel (e.g. "$c:#channel").
+	ExtBanChannel
+	// ExtBanQuiet mutes (rather than bans) users matching a mask (e.g.
+	// "~q:*!*@host").
+	ExtBanQuiet
+)
+
+// extBanTypes are the types of each kind of extended ban, keyed by the
+// extended ban prefix, as it differs between servers.
+var extBanTypes = map[string]map[ExtBanKind]string{
+	// Charybdis, Solanum, ircd-seven.
+	"$": {ExtBanAccount: "a", ExtBanRealname: "r", ExtBanChannel: "c"},
+	// UnrealIRCd.
+	"~": {ExtBanAccount: "a", ExtBanRealname: "r", ExtBanChannel: "c", ExtBanQuiet: "q"},
+	// InspIRCd, which doesn't use a prefix.
+	"": {ExtBanAccount: "R", ExtBanRealname: "r", ExtBanChannel: "j", ExtBanQuiet: "m"},
+}
+
+// ExtBan is a parsed extended ban. See ISupport.ParseExtBan.
+type ExtBan struct {
+	// Kind is the meaning of the type, if known.
+	Kind ExtBanKind
+	// Type is the type of the extended ban, e.g. "a".
+	Type string
+	// Negated is true if the extended ban matches users which don't match
+	// it otherwise (e.g. "$~a", matching users which aren't logged in).
+	Negated bool
+	// Value is the value being matched (e.g. the account), which may be
+	// empty.
+	Value string
+}
+
+// ExtBan returns an extended ban of the given kind, matching value (e.g.
+// "$a:account" for ExtBanAccount, or "R:account" on InspIRCd). value may be
+// empty for kinds which support it. ok is false if the server doesn't
+// support the kind of extended ban.
+func (i ISupport) ExtBan(kind ExtBanKind, value string) (mask string, ok bool) {
+	if i.ExtBanTypes == "" {
+		return "", false
+	}
+
+	typ := extBanTypes[i.ExtBanPrefix][kind]
+	if typ == "" || !strings.Contains(i.ExtBanTypes, typ) {
+		return "", false
+	}
+
+	mask = i.ExtBanPrefix + typ
+	if value != "" {
+		mask += ":" + value
+	}
+
+	return mask, true
+}
+
+// ParseExtBan parses an extended ban, e.g. "$a:account". ok is false if the
+// mask isn't an extended ban supported by the server (e.g. a regular
+// "nick!ident@host" mask).
+func (i ISupport) ParseExtBan(mask string) (ban ExtBan, ok bool) {
+	if i.ExtBanTypes == "" || !strings.HasPrefix(mask, i.ExtBanPrefix) {
+		return ban, false
+	}
+
+	rest := mask[len(i.ExtBanPrefix):]
+
+	// Only extended bans with a prefix can be negated, as "~" is also the
+	// prefix of unverified idents.
+	if i.ExtBanPrefix != "" && len(rest) > 0 && rest[0] == 0x7E { // ~
+		ban.Negated = true
+		rest = rest[1:]
+	}
+
+	if len(rest) < 1 || !strings.Contains(i.ExtBanTypes, rest[:1]) {
+		return ban, false
+	}
+
+	ban.Type = rest[:1]
+	rest = rest[1:]
+
+	switch {
+	case rest == "" && i.ExtBanPrefix != "":
+	case len(rest) > 0 && rest[0] == 0x3A: // :
+		ban.Value = rest[1:]
+	default:
+		return ban, false
+	}
+
+	for kind, typ := range extBanTypes[i.ExtBanPrefix] {
+		if typ == ban.Type {
+			ban.Kind = kind
+			break
+		}
+	}
+
+	return ban, true
+}
+
+// MatchBan returns true if the (extended) ban mask matches the user. Only
+// extended bans of a known kind, which can be matched with what's known
+// about the user, are supported: ExtBanChannel and ExtBanUnknown never
+// match. Masks are compared with the casemapping of the server. See
+// Client.MatchBans to also match ExtBanChannel.
+func (i ISupport) MatchBan(user *User, mask string) bool {
+	return i.matchBan(user, mask, nil)
+}
+
+// matchBan returns true if the (extended) ban mask matches the user, using
+// inChannel (if not nil) to check if the user is in a channel.
+func (i ISupport) matchBan(user *User, mask string, inChannel func(channel string) bool) bool {
+	if user == nil {
+		return false
+	}
+
+	ban, ok := i.ParseExtBan(mask)
+	if !ok {
+		return matchMask(i.Casemapping, &Source{Name: user.Nick, Ident: user.Ident, Host: user.Host}, mask)
+	}
+
+	var match bool
+	switch ban.Kind {
+	case ExtBanAccount:
+		account := user.Extras.Account
+		if account == "*" {
+			account = ""
+		}
+
+		if ban.Value == "" {
+			match = account != ""
+		} else {
+			match = account != "" && Glob(ToLower(i.Casemapping, account), ToLower(i.Casemapping, ban.Value))
+		}
+	case ExtBanRealname:
+		match = Glob(ToLower(i.Casemapping, user.Extras.Name), ToLower(i.Casemapping, ban.Value))
+	case ExtBanChannel:
+		if inChannel == nil || ban.Value == "" {
+			return false
+		}
+
+		match = inChannel(ban.Value)
+	case ExtBanQuiet:
+		match = ban.Value != "" && i.matchBan(user, ban.Value, inChannel)
+	default:
+		return false
+	}
+
+	return match != ban.Negated
+}
+
+// MatchBans returns the first ban which matches the user, e.g. from
+// Channel.Bans(), as with ISupport.MatchBan. This can be used to check for
+// ban evasion. Users in channels are matched against ExtBanChannel bans
+// using the tracked state.
+func (c *Client) MatchBans(user *User, bans []ModeListEntry) (ban ModeListEntry, ok bool) {
+	c.state.mu.RLock()
+	defer c.state.mu.RUnlock()
+
+	var inChannel func(channel string) bool
+	if !c.Config.disableTracking && user != nil {
+		inChannel = func(channel string) bool {
+			ch := c.state.lookupChannel(channel)
+			if ch == nil {
+				return false
+			}
+
+			_, ok := ch.users[c.state.toLower(user.Nick)]
+			return ok
+		}
+	}
+
+	for _, entry := range bans {
+		if c.state.isupport.matchBan(user, entry.Mask, inChannel) {
+			return entry, true
+		}
+	}
+
+	return ban, false
+}
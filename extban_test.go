@@ -0,0 +1,154 @@
+// Copyright (c) Liam Stanley <me@liamstanley.io>. All rights reserved. Use
+// of this source code is governed by the MIT license that can be found in
+// the LICENSE file.
+
+package girc
+
+import "testing"
+
+// extBanISupport returns an ISupport with the given EXTBAN token.
+func extBanISupport(token string) ISupport {
+	is := newISupport()
+	is.parse("EXTBAN=" + token)
+
+	return is
+}
+
+func TestExtBan(t *testing.T) {
+	tests := []struct {
+		token string
+		kind  ExtBanKind
+		value string
+		want  string
+	}{
+		{token: "$,ajrxz", kind: ExtBanAccount, value: "acct", want: "$a:acct"},
+		{token: "$,ajrxz", kind: ExtBanAccount, want: "$a"},
+		{token: "$,ajrxz", kind: ExtBanChannel, value: "#chan"},
+		{token: "$,ajrxz", kind: ExtBanQuiet, value: "*!*@host"},
+		{token: "~,acfjmnpqrtCGOST", kind: ExtBanQuiet, value: "*!*@host", want: "~q:*!*@host"},
+		{token: "~,acfjmnpqrtCGOST", kind: ExtBanChannel, value: "#chan", want: "~c:#chan"},
+		{token: ",ACNOQRSTUcjmprsz", kind: ExtBanAccount, value: "acct", want: "R:acct"},
+		{token: ",ACNOQRSTUcjmprsz", kind: ExtBanQuiet, value: "*!*@host", want: "m:*!*@host"},
+		{token: "", kind: ExtBanAccount, value: "acct"},
+	}
+
+	for _, tt := range tests {
+		got, ok := extBanISupport(tt.token).ExtBan(tt.kind, tt.value)
+		if got != tt.want || ok != (tt.want != "") {
+			t.Errorf("ExtBan(%d, %q) with EXTBAN=%s = %q, %t, want %q", tt.kind, tt.value, tt.token, got, ok, tt.want)
+		}
+	}
+}
+
+func TestParseExtBan(t *testing.T) {
+	tests := []struct {
+		token string
+		mask  string
+		want  ExtBan
+		ok    bool
+	}{
+		{token: "$,ajrxz", mask: "$a:acct", want: ExtBan{Kind: ExtBanAccount, Type: "a", Value: "acct"}, ok: true},
+		{token: "$,ajrxz", mask: "$~a", want: ExtBan{Kind: ExtBanAccount, Type: "a", Negated: true}, ok: true},
+		{token: "$,ajrxz", mask: "$x:*!*@*#*bot*", want: ExtBan{Kind: ExtBanUnknown, Type: "x", Value: "*!*@*#*bot*"}, ok: true},
+		{token: "$,ajrxz", mask: "$q:acct"},
+		{token: "$,ajrxz", mask: "*!*@host"},
+		{token: "~,acfjmnpqrtCGOST", mask: "~q:*!*@host", want: ExtBan{Kind: ExtBanQuiet, Type: "q", Value: "*!*@host"}, ok: true},
+		{token: "~,acfjmnpqrtCGOST", mask: "~abc"},
+		{token: ",ACNOQRSTUcjmprsz", mask: "R:acct", want: ExtBan{Kind: ExtBanAccount, Type: "R", Value: "acct"}, ok: true},
+		{token: ",ACNOQRSTUcjmprsz", mask: "j:#chan", want: ExtBan{Kind: ExtBanChannel, Type: "j", Value: "#chan"}, ok: true},
+		{token: ",ACNOQRSTUcjmprsz", mask: "r"},
+		{token: ",ACNOQRSTUcjmprsz", mask: "nick!*@*"},
+		{token: "", mask: "$a:acct"},
+	}
+
+	for _, tt := range tests {
+		got, ok := extBanISupport(tt.token).ParseExtBan(tt.mask)
+		if ok != tt.ok || (ok && got != tt.want) {
+			t.Errorf("ParseExtBan(%q) with EXTBAN=%s = %#v, %t, want %#v, %t", tt.mask, tt.token, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestMatchBan(t *testing.T) {
+	user := &User{Nick: "Nick", Ident: "~ident", Host: "host.example.com"}
+	user.Extras.Account = "Acct"
+	user.Extras.Name = "Some Bot"
+
+	anon := &User{Nick: "anon", Ident: "anon", Host: "other.example.com"}
+	anon.Extras.Account = "*"
+
+	tests := []struct {
+		token string
+		user  *User
+		mask  string
+		want  bool
+	}{
+		{token: "$,ajrxz", user: user, mask: "*!*@host.example.com", want: true},
+		{token: "$,ajrxz", user: user, mask: "nick!*@*", want: true},
+		{token: "$,ajrxz", user: user, mask: "*!*@other.example.com", want: false},
+		{token: "$,ajrxz", user: user, mask: "$a:acct", want: true},
+		{token: "$,ajrxz", user: user, mask: "$a:ac*", want: true},
+		{token: "$,ajrxz", user: user, mask: "$a:other", want: false},
+		{token: "$,ajrxz", user: user, mask: "$a", want: true},
+		{token: "$,ajrxz", user: anon, mask: "$a", want: false},
+		{token: "$,ajrxz", user: anon, mask: "$~a", want: true},
+		{token: "$,ajrxz", user: user, mask: "$~a", want: false},
+		{token: "$,ajrxz", user: user, mask: "$r:*bot*", want: true},
+		{token: "$,ajrxz", user: user, mask: "$x:*", want: false},
+		{token: "~,acfjmnpqrtCGOST", user: user, mask: "~q:*!*@*.example.com", want: true},
+		{token: "~,acfjmnpqrtCGOST", user: user, mask: "~q:~a:acct", want: true},
+		{token: "~,acfjmnpqrtCGOST", user: anon, mask: "~q:~a:acct", want: false},
+		{token: ",ACNOQRSTUcjmprsz", user: user, mask: "R:acct", want: true},
+		{token: ",ACNOQRSTUcjmprsz", user: user, mask: "m:nick!*@*", want: true},
+		{token: "$,ajrxz", user: nil, mask: "*", want: false},
+	}
+
+	for _, tt := range tests {
+		if got := extBanISupport(tt.token).MatchBan(tt.user, tt.mask); got != tt.want {
+			t.Errorf("MatchBan(%v, %q) with EXTBAN=%s = %t, want %t", tt.user, tt.mask, tt.token, got, tt.want)
+		}
+	}
+
+	// With ascii, account a[b] isn't A{B}.
+	is := extBanISupport("$,ajrxz")
+	user.Extras.Account = "a[b]"
+	if !is.MatchBan(user, "$a:A{B}") {
+		t.Error("MatchBan($a:A{B}) for account a[b] = false with rfc1459, want true")
+	}
+
+	is.parse("CASEMAPPING=ascii")
+	if is.MatchBan(user, "$a:A{B}") {
+		t.Error("MatchBan($a:A{B}) for account a[b] = true with ascii, want false")
+	}
+	if !is.MatchBan(user, "$a:A[B]") {
+		t.Error("MatchBan($a:A[B]) for account a[b] = false with ascii, want true")
+	}
+}
+
+func TestClientMatchBans(t *testing.T) {
+	c, server := mockClient(t, Config{})
+	defer c.Stop()
+
+	server.send(":irc.example.com 001 nick :Welcome")
+	server.send(":irc.example.com 005 nick EXTBAN=$,acjrxz :are supported by this server")
+	server.send(":nick!user@host JOIN #chan")
+	server.send(":bad!~bad@evil.example.com JOIN #chan")
+	server.send(":bad!~bad@evil.example.com JOIN #bad")
+	server.send("PING :sync")
+	server.expect("PONG sync")
+
+	user, ok := c.LookupUser("bad")
+	if !ok {
+		t.Fatal("user wasn't tracked")
+	}
+
+	bans := []ModeListEntry{{Mask: "*!*@good.example.com"}, {Mask: "$c:#bad"}, {Mask: "*!*@evil.example.com"}}
+
+	if ban, ok := c.MatchBans(user, bans); !ok || ban.Mask != "$c:#bad" {
+		t.Errorf("MatchBans() = %q, %t, want %q", ban.Mask, ok, "$c:#bad")
+	}
+
+	if ban, ok := c.MatchBans(user, bans[:1]); ok {
+		t.Errorf("MatchBans() = %q, want no match", ban.Mask)
+	}
+}
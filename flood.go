@@ -0,0 +1,287 @@
+// Copyright (c) Liam Stanley <me@liamstanley.io>. All rights reserved. Use
+// of this source code is governed by the MIT license that can be found in
+// the LICENSE file.
+
+package girc
+
+import (
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	// defaultFloodWindow is the default value of FloodLimit.Window.
+	defaultFloodWindow = 10 * time.Second
+	// maxFloodKeys is the maximum amount of users tracked for flood
+	// detection, after which those who haven't sent anything within the
+	// window are forgotten.
+	maxFloodKeys = 500
+)
+
+// FloodAction is what's done about a user who exceeded the limits of a
+// FloodGuard. Actions can be combined, e.g. FloodIgnore | FloodKick.
+type FloodAction int
+
+const (
+	// FloodReport only dispatches FLOOD_DETECTED.
+	FloodReport FloodAction = 0
+	// FloodIgnore adds the host of the user to Client.Ignores (see
+	// FloodGuard.IgnoreFor).
+	FloodIgnore FloodAction = 1
+	// FloodKick kicks the user from the channel they flooded, if we're an
+	// operator (or half-operator) in it. Requires tracking to be enabled.
+	FloodKick FloodAction = 2
+)
+
+// FloodLimit are the thresholds for the messages a user may send within a
+// sliding window. A threshold of 0 disables it, so a zero FloodLimit
+// disables flood detection.
+type FloodLimit struct {
+	// Messages is the maximum amount of messages.
+	Messages int
+	// Bytes is the maximum amount of text, in bytes.
+	Bytes int
+	// Repeats is the maximum amount of messages which are the same as the
+	// previous one.
+	Repeats int
+	// Window is the duration of the sliding window. Defaults to 10
+	// seconds.
+	Window time.Duration
+}
+
+// window returns the duration of the sliding window.
+func (l FloodLimit) window() time.Duration {
+	if l.Window <= 0 {
+		return defaultFloodWindow
+	}
+
+	return l.Window
+}
+
+// disabled returns true if all thresholds are disabled.
+func (l FloodLimit) disabled() bool {
+	return l.Messages <= 0 && l.Bytes <= 0 && l.Repeats <= 0
+}
+
+// FloodStats are what a user sent within the window of a FloodLimit, when
+// they exceeded it.
+type FloodStats struct {
+	// Channel is the channel which was flooded, or empty for private
+	// messages.
+	Channel string
+	// Messages is the amount of messages, Bytes the amount of text (in
+	// bytes), and Repeats the amount of messages which were the same as the
+	// previous one.
+	Messages int
+	Bytes    int
+	Repeats  int
+	// Window is the duration of the sliding window.
+	Window time.Duration
+}
+
+// FloodGuard configures detecting users flooding us, or the channels we're
+// in, with messages (PRIVMSG and NOTICE, including CTCP), and what's done
+// about them. Messages are counted per user (by host) and channel, within a
+// sliding window. FLOOD_DETECTED is dispatched for every user exceeding a
+// limit, after which they're counted from scratch. Messages from ignored
+// users aren't counted.
+type FloodGuard struct {
+	// Limit is the limit of private messages, and of channels which aren't
+	// in Channels.
+	Limit FloodLimit
+	// Channels are the limits of specific channels. A zero FloodLimit
+	// disables flood detection in the channel.
+	Channels map[string]FloodLimit
+	// Action, if set, decides what's done about a user who exceeded a limit.
+	// Defaults to FloodReport.
+	Action func(source *Source, stats FloodStats) FloodAction
+	// IgnoreFor is how long users are ignored for, with FloodIgnore. If 0,
+	// they're ignored until removed from Client.Ignores.
+	IgnoreFor time.Duration
+	// KickReason is the reason users are kicked with, with FloodKick.
+	// Defaults to "Flooding".
+	KickReason string
+}
+
+// kickReason returns the reason users are kicked with.
+func (g *FloodGuard) kickReason() string {
+	if g.KickReason == "" {
+		return "Flooding"
+	}
+
+	return g.KickReason
+}
+
+// floodTracker tracks the recent messages of each user, for flood
+// detection.
+type floodTracker struct {
+	mu      sync.Mutex
+	senders map[string]*floodSender
+}
+
+// floodSender is what a user sent to a channel (or us) within the window.
+type floodSender struct {
+	times []time.Time
+	sizes []int
+	// last is the last message, and repeats the amount of messages within
+	// the window which were the same as the one before.
+	last    string
+	repeats []time.Time
+}
+
+// prune forgets the messages before since.
+func (s *floodSender) prune(since time.Time) {
+	i := 0
+	for i < len(s.times) && s.times[i].Before(since) {
+		i++
+	}
+	s.times, s.sizes = s.times[i:], s.sizes[i:]
+
+	i = 0
+	for i < len(s.repeats) && s.repeats[i].Before(since) {
+		i++
+	}
+	s.repeats = s.repeats[i:]
+}
+
+// limit returns the limit of channel, or of private messages if channel is
+// empty. Channels are compared with the casemapping of the server.
+func (g *FloodGuard) limit(c *Client, channel string) FloodLimit {
+	if channel == "" || g.Channels == nil {
+		return g.Limit
+	}
+
+	c.state.mu.RLock()
+	defer c.state.mu.RUnlock()
+
+	id := c.state.toLower(channel)
+	for name, limit := range g.Channels {
+		if c.state.toLower(name) == id {
+			return limit
+		}
+	}
+
+	return g.Limit
+}
+
+// count counts a message from source (to channel, which is empty for
+// private messages) at t, against limit, returning the stats if it was
+// exceeded.
+func (f *floodTracker) count(c *Client, limit FloodLimit, source *Source, channel, text string, t time.Time) (stats FloodStats, exceeded bool) {
+	window := limit.window()
+
+	c.state.mu.RLock()
+	key := c.state.toLower(channel) + " " + limitKey(c.state.isupport.Casemapping, source)
+	c.state.mu.RUnlock()
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.senders == nil {
+		f.senders = make(map[string]*floodSender)
+	}
+
+	s := f.senders[key]
+	if s == nil {
+		if len(f.senders) >= maxFloodKeys {
+			for k, other := range f.senders {
+				if len(other.times) == 0 || t.Sub(other.times[len(other.times)-1]) > window {
+					delete(f.senders, k)
+				}
+			}
+		}
+
+		s = &floodSender{}
+		f.senders[key] = s
+	}
+
+	s.prune(t.Add(-window))
+	s.times = append(s.times, t)
+	s.sizes = append(s.sizes, len(text))
+	if len(s.times) > 1 && text == s.last {
+		s.repeats = append(s.repeats, t)
+	}
+	s.last = text
+
+	stats = FloodStats{Channel: channel, Messages: len(s.times), Repeats: len(s.repeats), Window: window}
+	for _, size := range s.sizes {
+		stats.Bytes += size
+	}
+
+	exceeded = (limit.Messages > 0 && stats.Messages > limit.Messages) ||
+		(limit.Bytes > 0 && stats.Bytes > limit.Bytes) ||
+		(limit.Repeats > 0 && stats.Repeats > limit.Repeats)
+	if exceeded {
+		// Count them from scratch, so they're only reported again once they
+		// exceed the limit again.
+		delete(f.senders, key)
+	}
+
+	return stats, exceeded
+}
+
+// handleFlood counts incoming messages against Config.FloodGuard, and acts
+// on the users which exceed its limits.
+func handleFlood(c *Client, e Event) {
+	g := c.Config.FloodGuard
+	if g == nil || e.Source == nil || e.Source.IsServer() || len(e.Params) < 1 || e.IsSelf() || c.Ignores.ignored(&e) {
+		return
+	}
+
+	var channel string
+	if IsValidChannel(e.Params[0]) {
+		channel = e.Params[0]
+	}
+
+	limit := g.limit(c, channel)
+	if limit.disabled() {
+		return
+	}
+
+	stats, exceeded := c.floods.count(c, limit, e.Source, channel, e.Trailing, e.Timestamp)
+	if !exceeded {
+		return
+	}
+
+	source := *e.Source
+	action := FloodReport
+	if g.Action != nil {
+		action = g.Action(&source, stats)
+	}
+
+	if action&FloodIgnore != 0 {
+		mask := "*!*@" + source.Host
+		if source.Host == "" {
+			mask = source.Name
+		}
+
+		c.Ignores.Add(mask)
+		if g.IgnoreFor > 0 {
+			time.AfterFunc(g.IgnoreFor, func() {
+				c.Ignores.Remove(mask)
+			})
+		}
+	}
+
+	if action&FloodKick != 0 && channel != "" && !c.Config.disableTracking {
+		var opped bool
+		nick := c.GetNick()
+		c.state.mu.RLock()
+		if user := c.state.lookupChannelUser(channel, nick); user != nil {
+			opped = user.Perms.IsAdmin() || user.Perms.HalfOp
+		}
+		c.state.mu.RUnlock()
+
+		if opped {
+			c.Commands.Kick(channel, source.Name, g.kickReason())
+		}
+	}
+
+	c.RunHandlers(&Event{
+		Command:  FLOOD_DETECTED,
+		Source:   &source,
+		Params:   []string{e.Params[0], strconv.Itoa(stats.Messages), strconv.Itoa(stats.Bytes), strconv.Itoa(stats.Repeats)},
+		Trailing: strconv.Itoa(int(action)),
+	})
+}
@@ -0,0 +1,157 @@
+// Copyright (c) Liam Stanley <me@liamstanley.io>. All rights reserved. Use
+// of this source code is governed by the MIT license that can be found in
+// the LICENSE file.
+
+package girc
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestFloodTrackerCount(t *testing.T) {
+	c := New(Config{Server: "irc.example.com", Nick: "nick", User: "user"})
+	now := time.Now()
+	src := &Source{Name: "nick", Ident: "user", Host: "host"}
+
+	tests := []struct {
+		name     string
+		limit    FloodLimit
+		messages []string
+		// gap is the delay between messages.
+		gap      time.Duration
+		exceeded int // index of the message exceeding the limit, or -1.
+	}{
+		{"messages", FloodLimit{Messages: 3}, []string{"a", "b", "c", "d"}, time.Second, 3},
+		{"window", FloodLimit{Messages: 3, Window: 5 * time.Second}, []string{"a", "b", "c", "d", "e"}, 2 * time.Second, -1},
+		{"bytes", FloodLimit{Bytes: 10}, []string{"hello", "world", "!"}, time.Second, 2},
+		{"repeats", FloodLimit{Repeats: 1}, []string{"spam", "spam", "other", "spam", "spam"}, time.Second, 4},
+	}
+
+	for _, tt := range tests {
+		f := &floodTracker{}
+		exceeded := -1
+		for i, text := range tt.messages {
+			stats, ok := f.count(c, tt.limit, src, "#channel", text, now.Add(time.Duration(i)*tt.gap))
+			if ok {
+				if stats.Channel != "#channel" || stats.Window != tt.limit.window() {
+					t.Errorf("%s: stats = %+v", tt.name, stats)
+				}
+
+				exceeded = i
+				break
+			}
+		}
+
+		if exceeded != tt.exceeded {
+			t.Errorf("%s: exceeded at message %d, want %d", tt.name, exceeded, tt.exceeded)
+		}
+	}
+}
+
+func TestFloodGuardLimit(t *testing.T) {
+	c := New(Config{Server: "irc.example.com", Nick: "nick", User: "user"})
+	g := &FloodGuard{
+		Limit:    FloodLimit{Messages: 5},
+		Channels: map[string]FloodLimit{"#Quiet": {Messages: 2}, "#spam": {}, "#a[b]": {Messages: 3}},
+	}
+
+	if limit := g.limit(c, "#quiet"); limit.Messages != 2 {
+		t.Errorf("limit(#quiet) = %+v, want the channel limit", limit)
+	}
+
+	if limit := g.limit(c, "#spam"); !limit.disabled() {
+		t.Errorf("limit(#spam) = %+v, want it disabled", limit)
+	}
+
+	if limit := g.limit(c, "#other"); limit.Messages != 5 {
+		t.Errorf("limit(#other) = %+v, want the default limit", limit)
+	}
+
+	if limit := g.limit(c, ""); limit.Messages != 5 {
+		t.Errorf("limit() = %+v, want the default limit", limit)
+	}
+
+	if limit := g.limit(c, "#a{b}"); limit.Messages != 3 {
+		t.Errorf("limit(#a{b}) = %+v, want the limit of #a[b] with rfc1459", limit)
+	}
+
+	// With ascii, these are different channels.
+	c.state.mu.Lock()
+	c.state.isupport.Casemapping = CaseMappingASCII
+	c.state.mu.Unlock()
+
+	if limit := g.limit(c, "#a{b}"); limit.Messages != 5 {
+		t.Errorf("limit(#a{b}) = %+v, want the default limit with ascii", limit)
+	}
+
+	f := &floodTracker{}
+	src := &Source{Name: "nick", Ident: "user", Host: "host"}
+	now := time.Now()
+	for _, channel := range []string{"#a[b]", "#a{b}", "#A[B]"} {
+		if _, exceeded := f.count(c, FloodLimit{Messages: 2}, src, channel, "text", now); exceeded {
+			t.Errorf("count(%s) exceeded the limit, want #a[b] and #a{b} counted separately", channel)
+		}
+	}
+}
+
+func TestFloodGuard(t *testing.T) {
+	var mu sync.Mutex
+	var got []FloodStats
+	c, server := mockClient(t, Config{AllowFlood: true, FloodGuard: &FloodGuard{
+		Limit: FloodLimit{Messages: 2},
+		Action: func(source *Source, stats FloodStats) FloodAction {
+			mu.Lock()
+			got = append(got, stats)
+			mu.Unlock()
+			return FloodIgnore | FloodKick
+		},
+	}})
+	defer c.Stop()
+
+	detected := make(chan Event, 5)
+	c.Handlers.Add(FLOOD_DETECTED, func(c *Client, e Event) {
+		detected <- e
+	})
+
+	server.send(":nick!user@host JOIN #channel")
+	server.send(":irc.example.com 353 nick = #channel :@nick other")
+	server.send(":irc.example.com 366 nick #channel :End of /NAMES list.")
+	for i := 0; i < 3; i++ {
+		server.send(":other!user@spam.host PRIVMSG #channel :spam")
+	}
+	server.expect("KICK #channel other :Flooding")
+
+	select {
+	case e := <-detected:
+		if e.Source.Name != "other" || len(e.Params) != 4 || e.Params[0] != "#channel" || e.Params[1] != "3" || e.Trailing != "3" {
+			t.Errorf("FLOOD_DETECTED = %q", e.String())
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("timed out waiting for FLOOD_DETECTED")
+	}
+
+	mu.Lock()
+	if len(got) != 1 || got[0].Messages != 3 || got[0].Repeats != 2 {
+		t.Errorf("Action was called with %+v", got)
+	}
+	mu.Unlock()
+
+	if !c.Ignores.Match(&Source{Name: "other", Ident: "user", Host: "spam.host"}) {
+		t.Errorf("Ignores = %q, want the flooding host", c.Ignores.List())
+	}
+
+	// Ignored users are no longer counted.
+	for i := 0; i < 3; i++ {
+		server.send(":other!user@spam.host PRIVMSG nick :spam")
+	}
+	server.send("PING :sync")
+	server.expect("PONG sync")
+
+	mu.Lock()
+	if len(got) != 1 {
+		t.Errorf("Action was called %d times, want once", len(got))
+	}
+	mu.Unlock()
+}
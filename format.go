@@ -7,6 +7,7 @@ package girc
 import (
 	"bytes"
 	"strings"
+	"unicode/utf8"
 )
 
 type ircFmtCode struct {
@@ -41,66 +42,34 @@ var codes = []*ircFmtCode{
 }
 
 // Format takes format strings like "{red}" and turns them into the resulting
-// ASCII format/color codes for IRC.
+// ASCII format/color codes for IRC. Besides the names of the colors and
+// formatting codes (e.g. "{bold}", "{b}" or "{reset}"), it supports:
 //
-// For example:
+//   {u}                   underline (as with {underline} and {ul})
+//   {strike}              strikethrough
+//   {mono}                monospace
+//   {default}             the default color
+//   {4} or {04}           a color by number (0 to 98, see Color)
+//   {red,black} {4,1}     a foreground and background color
+//
+// Placeholders are case-insensitive, and unknown placeholders are left as
+// they are. For example:
 //
 //   client.Message("#channel", Format("{red}{bold}Hello World{c}"))
 func Format(text string) string {
-	for i := 0; i < len(codes); i++ {
-		for a := 0; a < len(codes[i].aliases); a++ {
-			text = strings.Replace(text, "{"+codes[i].aliases[a]+"}", codes[i].val, -1)
-		}
-
-		// makes parsing small strings slightly slower, but helps longer
-		// strings.
-		var more bool
-		for c := 0; c < len(text); c++ {
-			if text[c] == 0x7B {
-				more = true
-				break
-			}
-		}
-		if !more {
-			return text
-		}
-	}
-
-	return text
+	return replaceFmt(text, false)
 }
 
 // StripFormat strips all "{fmt}" formatting strings from the input text.
 // See Format() for more information.
 func StripFormat(text string) string {
-	for i := 0; i < len(codes); i++ {
-		for a := 0; a < len(codes[i].aliases); a++ {
-			text = strings.Replace(text, "{"+codes[i].aliases[a]+"}", "", -1)
-		}
-
-		// makes parsing small strings slightly slower, but helps longer
-		// strings.
-		var more bool
-		for c := 0; c < len(text); c++ {
-			if text[c] == 0x7B {
-				more = true
-				break
-			}
-		}
-		if !more {
-			return text
-		}
-	}
-
-	return text
+	return replaceFmt(text, true)
 }
 
-// StripRaw tries to strip all ASCII format codes that are used for IRC.
+// StripRaw tries to strip all ASCII format codes that are used for IRC,
+// including CTCP delimiters. See TrimFmt.
 func StripRaw(text string) string {
-	for i := 0; i < len(codes); i++ {
-		text = strings.Replace(text, codes[i].val, "", -1)
-	}
-
-	return text
+	return strings.Replace(TrimFmt(text), "\x01", "", -1)
 }
 
 // IsValidChannel validates if channel is an RFC complaint channel or not.
@@ -241,15 +210,51 @@ func IsValidUser(name string) bool {
 // 1459. This will do things like replace an "A" with an "a", "[]" with "{}",
 // and so forth. Useful to compare two nicknames.
 func ToRFC1459(input string) (out string) {
+	return ToLower(CaseMappingRFC1459, input)
+}
+
+// Casemappings supported by ToLower, as advertised by the server with the
+// CASEMAPPING ISUPPORT token.
+const (
+	CaseMappingASCII         = "ascii"          // only A-Z are uppercase variants of a-z
+	CaseMappingRFC1459       = "rfc1459"        // like ascii, but "[]\^" are also uppercase variants of "{}|~"
+	CaseMappingRFC1459Strict = "rfc1459-strict" // like rfc1459, but without "^" and "~"
+)
+
+// ToLower converts a string to lowercase using the given casemapping (see
+// CaseMappingASCII, CaseMappingRFC1459 and CaseMappingRFC1459Strict). Two
+// nicknames or channel names are equal if they are equal after conversion.
+// Unknown (or empty) casemappings are treated as CaseMappingRFC1459, which
+// is the default for IRC.
+func ToLower(casemapping, input string) string {
+	// Upper bound of the characters to convert (from "A").
+	var max byte
+	switch casemapping {
+	case CaseMappingASCII:
+		max = 0x5A // Z.
+	case CaseMappingRFC1459Strict:
+		max = 0x5D // ].
+	default:
+		max = 0x5E // ^.
+	}
+
+	var out []byte
 	for i := 0; i < len(input); i++ {
-		if input[i] >= 65 && input[i] <= 94 {
-			out += string(rune(input[i]) + 32)
-		} else {
-			out += string(input[i])
+		if input[i] < 0x41 || input[i] > max {
+			continue
+		}
+
+		if out == nil {
+			out = []byte(input)
 		}
+		out[i] += 0x20
+	}
+
+	if out == nil {
+		return input
 	}
 
-	return out
+	return string(out)
 }
 
 const globChar = "*"
@@ -297,3 +302,43 @@ func Glob(input, match string) bool {
 	// Check suffix last.
 	return trailingGlob || strings.HasSuffix(input, parts[last])
 }
+
+// wrapMessage splits text into lines no longer than max bytes, splitting on
+// newlines, and on spaces where possible. Words longer than max are split
+// without breaking apart UTF-8 characters. Empty lines are dropped.
+func wrapMessage(text string, max int) (lines []string) {
+	if max < 1 {
+		max = 1
+	}
+
+	for _, line := range strings.Split(text, "\n") {
+		line = strings.TrimRight(line, "\r")
+
+		for len(line) > max {
+			i := strings.LastIndexByte(line[:max+1], 0x20)
+			if i > 0 {
+				lines = append(lines, line[:i])
+				line = line[i+1:]
+				continue
+			}
+
+			// No space to split on, so split within the word.
+			i = max
+			for i > 0 && !utf8.RuneStart(line[i]) {
+				i--
+			}
+			if i == 0 {
+				i = max
+			}
+
+			lines = append(lines, line[:i])
+			line = line[i:]
+		}
+
+		if line != "" {
+			lines = append(lines, line)
+		}
+	}
+
+	return lines
+}
@@ -5,6 +5,7 @@
 package girc
 
 import (
+	"reflect"
 	"strings"
 	"testing"
 )
@@ -74,6 +75,8 @@ func TestFormat(t *testing.T) {
 		{name: "partial", args: args{text: "{redtest{c}"}, want: "{redtest\x03"},
 		{name: "inside", args: args{text: "{re{c}d}test{c}"}, want: "{re\x03d}test\x03"},
 		{name: "nothing", args: args{text: "this is a test."}, want: "this is a test."},
+		{name: "colors", args: args{text: "{Red,Black}test{12}"}, want: "\x0304,01test\x0312"},
+		{name: "unknown", args: args{text: "{100}test{foo}"}, want: "{100}test{foo}"},
 	}
 
 	for _, tt := range tests {
@@ -122,6 +125,7 @@ func TestStripRaw(t *testing.T) {
 		{name: "partial", args: args{text: "{redtest{c}"}, want: "{redtest"},
 		{name: "inside", args: args{text: "{re{c}d}test{c}"}, want: "{red}test"},
 		{name: "nothing", args: args{text: "this is a test."}, want: "this is a test."},
+		{name: "colors", args: args{text: "{red,black}test{4}1{b}{ctcp}"}, want: "test1"},
 	}
 
 	for _, tt := range tests {
@@ -151,6 +155,8 @@ func TestIsValidNick(t *testing.T) {
 		{name: "long", args: args{nick: "test123456789AZBKASDLASMDLKM"}, want: true},
 		{name: "index 0 dash", args: args{nick: "-test"}, want: false},
 		{name: "index 0 numeric", args: args{nick: "0test"}, want: false},
+		{name: "caret", args: args{nick: "test^"}, want: false},
+		{name: "index 0 caret", args: args{nick: "^test"}, want: false},
 	}
 	for _, tt := range tests {
 		if got := IsValidNick(tt.args.nick); got != tt.want {
@@ -239,6 +245,50 @@ func TestToRFC1459(t *testing.T) {
 	return
 }
 
+func TestWrapMessage(t *testing.T) {
+	cases := []struct {
+		in   string
+		max  int
+		want []string
+	}{
+		{"", 10, nil},
+		{"short", 10, []string{"short"}},
+		{"exactly 10", 10, []string{"exactly 10"}},
+		{"hello there world", 11, []string{"hello there", "world"}},
+		{"hello  there", 6, []string{"hello ", "there"}},
+		{"line one\r\nline two\n\n", 20, []string{"line one", "line two"}},
+		{"abcdefghij", 4, []string{"abcd", "efgh", "ij"}},
+		{"aééé", 4, []string{"aé", "éé"}},
+	}
+
+	for _, tt := range cases {
+		if got := wrapMessage(tt.in, tt.max); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("wrapMessage(%q, %d) = %q, want %q", tt.in, tt.max, got, tt.want)
+		}
+	}
+}
+
+func TestToLower(t *testing.T) {
+	cases := []struct {
+		casemapping string
+		in          string
+		want        string
+	}{
+		{CaseMappingASCII, "", ""},
+		{CaseMappingASCII, "Nick[]\\^", "nick[]\\^"},
+		{CaseMappingRFC1459, "Nick[]\\^", "nick{}|~"},
+		{CaseMappingRFC1459Strict, "Nick[]\\^", "nick{}|^"},
+		{"", "#Chan[1]", "#chan{1}"},
+		{"unknown", "ABC~", "abc~"},
+	}
+
+	for _, tt := range cases {
+		if got := ToLower(tt.casemapping, tt.in); got != tt.want {
+			t.Errorf("ToLower(%q, %q) = %q, want %q", tt.casemapping, tt.in, got, tt.want)
+		}
+	}
+}
+
 func BenchmarkGlob(b *testing.B) {
 	for i := 0; i < b.N; i++ {
 		if !Glob("*quick*fox*dog", "The quick brown fox jumped over the lazy dog") {
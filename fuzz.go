@@ -0,0 +1,30 @@
+// Copyright (c) Liam Stanley <me@liamstanley.io>. All rights reserved. Use
+// of this source code is governed by the MIT license that can be found in
+// the LICENSE file.
+
+//go:build gofuzz
+// +build gofuzz
+
+package girc
+
+// Fuzz is the entry point for go-fuzz (see https://github.com/dvyukov/go-fuzz),
+// fuzzing the parsers of events, tags and sources. See fuzz_test.go for the
+// native fuzz targets (go test -fuzz).
+func Fuzz(data []byte) int {
+	raw := string(data)
+
+	ParseTags(raw)
+	ParseSource(raw)
+
+	if e := ParseEvent(raw); e != nil {
+		e.String()
+		e.Tags.Get("time")
+	}
+
+	// Prioritize valid events.
+	if _, err := ParseEventStrict(raw); err == nil {
+		return 1
+	}
+
+	return 0
+}
@@ -0,0 +1,109 @@
+// Copyright (c) Liam Stanley <me@liamstanley.io>. All rights reserved. Use
+// of this source code is governed by the MIT license that can be found in
+// the LICENSE file.
+
+//go:build go1.18
+// +build go1.18
+
+package girc
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// fuzzSeeds are the initial inputs of the parser fuzz targets.
+var fuzzSeeds = []string{
+	"PING :irc.example.com",
+	":nick!user@host PRIVMSG #channel :hello world",
+	"@time=2011-10-19T16:40:51.620Z;account=nick :nick!user@host JOIN #channel account :Real Name",
+	"@a=b\\\\and\\nk;c=72\\s45;d=gh\\:764 foo",
+	":irc.example.com 005 nick TARGMAX=JOIN: :are supported",
+	":irc.example.com 432  #momo :Erroneous Nickname",
+	"@ :",
+	"@",
+	":",
+	": ",
+	"@a ",
+	"@a= :b",
+	"nick!@",
+}
+
+func FuzzParseEvent(f *testing.F) {
+	for _, seed := range fuzzSeeds {
+		f.Add(seed)
+	}
+
+	f.Fuzz(func(t *testing.T, raw string) {
+		e := ParseEvent(raw)
+		strict, err := ParseEventStrict(raw)
+
+		if err == nil && strict == nil {
+			t.Fatalf("ParseEventStrict(%q) returned neither an event nor an error", raw)
+		}
+		if err == nil && e == nil {
+			t.Fatalf("ParseEventStrict(%q) accepted an event ParseEvent() rejected", raw)
+		}
+
+		if e == nil || e.Len() > maxLength {
+			return
+		}
+
+		// Events must be parsed the same after being encoded.
+		out := e.String()
+		again := ParseEvent(out)
+		if again == nil {
+			t.Fatalf("ParseEvent(%q) = nil, encoded from ParseEvent(%q)", out, raw)
+		}
+
+		if again.Command != e.Command || !reflect.DeepEqual(again.Source, e.Source) || !reflect.DeepEqual(eventArgs(again), eventArgs(e)) {
+			t.Fatalf("ParseEvent(%q) = %#v, encoded from ParseEvent(%q) = %#v", out, again, raw, e)
+		}
+	})
+}
+
+func FuzzParseTags(f *testing.F) {
+	for _, seed := range []string{"a=b;c;d=", "@a=b\\", "+draft/reply=1;;=", "a=\\\\\\s;a=b"} {
+		f.Add(seed)
+	}
+
+	f.Fuzz(func(t *testing.T, raw string) {
+		tags := ParseTags(raw)
+		raw = strings.TrimPrefix(raw, "@")
+
+		for key := range tags {
+			if !validTag(key) || !validTagValue(tags[key]) {
+				t.Fatalf("ParseTags(%q) returned invalid tag %q=%q", raw, key, tags[key])
+			}
+
+			// Lazily parsed tags must have the same values.
+			if value, ok := rawTag(raw, key); !ok || value != tags[key] {
+				t.Fatalf("rawTag(%q, %q) = %q, %v, want %q", raw, key, value, ok, tags[key])
+			}
+
+			tags.Get(key)
+		}
+
+		if checkTags(raw) == nil && len(tags) == 0 {
+			t.Fatalf("checkTags(%q) accepted tags ParseTags() dropped", raw)
+		}
+	})
+}
+
+func FuzzParseSource(f *testing.F) {
+	for _, seed := range []string{"nick!user@host", "irc.example.com", "nick@host", "nick!user", "!@", "@!"} {
+		f.Add(seed)
+	}
+
+	f.Fuzz(func(t *testing.T, raw string) {
+		src := ParseSource(raw)
+		if src == nil {
+			return
+		}
+
+		if src.Len() != len(src.String()) {
+			t.Fatalf("ParseSource(%q).Len() = %d, but String() = %q", raw, src.Len(), src.String())
+		}
+	})
+}
@@ -0,0 +1,102 @@
+// Copyright (c) Liam Stanley <me@liamstanley.io>. All rights reserved. Use
+// of this source code is governed by the MIT license that can be found in
+// the LICENSE file.
+
+// Package gircotel traces girc clients with OpenTelemetry. Each dispatch of
+// an incoming event is traced as a span (with the command, source and
+// channel as attributes), with a child span for each handler. Sending an
+// event is traced as a span, which is a child of the span in the context
+// passed to Client.SendContext, if any:
+//
+//	client := girc.New(girc.Config{
+//		Server: "irc.example.com",
+//		Port:   6667,
+//		Nick:   "bot",
+//		User:   "bot",
+//		Tracer: gircotel.New(otel.GetTracerProvider()),
+//	})
+//
+//	client.Handlers.Add(girc.PRIVMSG, func(c *girc.Client, e girc.Event) {
+//		// Traced as part of handling the PRIVMSG.
+//		c.SendContext(e.Context(), &girc.Event{Command: girc.PRIVMSG, Params: []string{"#channel"}, Trailing: "pong"})
+//	})
+package gircotel
+
+import (
+	"github.com/lrstanley/girc"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	"golang.org/x/net/context"
+)
+
+// tracerName is the instrumentation name of the tracer.
+const tracerName = "github.com/lrstanley/girc/gircotel"
+
+// tracer implements girc.Tracer with an OpenTelemetry tracer.
+type tracer struct {
+	tracer trace.Tracer
+}
+
+// New returns a girc.Tracer (see girc.Config.Tracer) which traces with a
+// tracer of provider.
+func New(provider trace.TracerProvider) girc.Tracer {
+	return &tracer{tracer: provider.Tracer(tracerName)}
+}
+
+// eventAttributes returns the span attributes describing event.
+func eventAttributes(event *girc.Event) []attribute.KeyValue {
+	attrs := []attribute.KeyValue{attribute.String("irc.command", event.Command)}
+
+	if event.Source != nil {
+		attrs = append(attrs, attribute.String("irc.source", event.Source.Name))
+	}
+	if len(event.Params) > 0 && girc.IsValidChannel(event.Params[0]) {
+		attrs = append(attrs, attribute.String("irc.channel", event.Params[0]))
+	}
+
+	return attrs
+}
+
+// StartDispatch implements girc.Tracer, starting the span of the dispatch of
+// event.
+func (t *tracer) StartDispatch(ctx context.Context, event *girc.Event) (context.Context, func()) {
+	ctx, span := t.tracer.Start(
+		ctx, "dispatch "+event.Command,
+		trace.WithSpanKind(trace.SpanKindConsumer),
+		trace.WithAttributes(eventAttributes(event)...),
+	)
+
+	return ctx, func() { span.End() }
+}
+
+// StartHandler implements girc.Tracer, starting the span of a handler of
+// event, which is a child of the span of its dispatch.
+func (t *tracer) StartHandler(ctx context.Context, event *girc.Event, id string) (context.Context, func()) {
+	ctx, span := t.tracer.Start(
+		ctx, "handler "+event.Command,
+		trace.WithAttributes(attribute.String("irc.command", event.Command), attribute.String("irc.handler", id)),
+	)
+
+	return ctx, func() { span.End() }
+}
+
+// StartSend implements girc.Tracer, starting the span of sending event,
+// which is a child of the span in ctx, if any. The error of events which
+// couldn't be sent is recorded on the span.
+func (t *tracer) StartSend(ctx context.Context, event *girc.Event) func(err error) {
+	_, span := t.tracer.Start(
+		ctx, "send "+event.Command,
+		trace.WithSpanKind(trace.SpanKindProducer),
+		trace.WithAttributes(eventAttributes(event)...),
+	)
+
+	return func(err error) {
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+		}
+
+		span.End()
+	}
+}
@@ -0,0 +1,101 @@
+// Copyright (c) Liam Stanley <me@liamstanley.io>. All rights reserved. Use
+// of this source code is governed by the MIT license that can be found in
+// the LICENSE file.
+
+package gircotel
+
+import (
+	"testing"
+
+	"github.com/lrstanley/girc"
+	"github.com/lrstanley/girc/girctest"
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+// spanAttr returns the value of the attribute of span with the given key.
+func spanAttr(span sdktrace.ReadOnlySpan, key string) string {
+	for _, attr := range span.Attributes() {
+		if attr.Key == attribute.Key(key) {
+			return attr.Value.AsString()
+		}
+	}
+
+	return ""
+}
+
+func TestTracing(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+
+	c, server := girctest.NewClient(t, girc.Config{Tracer: New(provider)})
+	defer c.Stop()
+
+	c.Handlers.Add(girc.PRIVMSG, func(c *girc.Client, e girc.Event) {
+		if e.Trailing == "ping" {
+			c.SendContext(e.Context(), &girc.Event{Command: girc.PRIVMSG, Params: []string{"#channel"}, Trailing: "pong"})
+		}
+	})
+	c.Handlers.Add(girc.PRIVMSG, func(c *girc.Client, e girc.Event) {
+		if e.Trailing == "ping" {
+			c.Commands.Message("#channel", "pong 2")
+		}
+	})
+
+	server.Register()
+	server.Reply(":other!user@host PRIVMSG #channel :ping")
+	server.ExpectPrefix("PRIVMSG #channel :pong")
+	server.ExpectPrefix("PRIVMSG #channel :pong")
+	server.Sync()
+
+	var dispatch sdktrace.ReadOnlySpan
+	var handlers, sends []sdktrace.ReadOnlySpan
+	for _, span := range recorder.Ended() {
+		switch {
+		case span.Name() == "dispatch PRIVMSG":
+			dispatch = span
+		case span.Name() == "handler PRIVMSG":
+			handlers = append(handlers, span)
+		case span.Name() == "send PRIVMSG":
+			sends = append(sends, span)
+		}
+	}
+
+	if dispatch == nil {
+		t.Fatal("no span for the dispatch of PRIVMSG")
+	}
+	if spanAttr(dispatch, "irc.source") != "other" || spanAttr(dispatch, "irc.channel") != "#channel" {
+		t.Fatalf("dispatch span has attributes %v", dispatch.Attributes())
+	}
+
+	if len(handlers) == 0 {
+		t.Fatal("no spans for the handlers of PRIVMSG")
+	}
+	for _, span := range handlers {
+		if span.Parent().SpanID() != dispatch.SpanContext().SpanID() {
+			t.Fatalf("handler span %s isn't a child of the dispatch span", spanAttr(span, "irc.handler"))
+		}
+	}
+
+	if len(sends) != 2 {
+		t.Fatalf("got %d send spans, want 2", len(sends))
+	}
+	var children int
+	for _, span := range sends {
+		if parent := span.Parent(); parent.IsValid() {
+			// Sent with SendContext, so a child of the handler's span.
+			if parent.TraceID() != dispatch.SpanContext().TraceID() {
+				t.Fatal("send span with a parent isn't part of the trace of the dispatch")
+			}
+			children++
+		} else if len(span.Links()) > 0 {
+			// Sent with Send, which isn't related to any dispatch.
+			t.Fatalf("send span without a parent has links %v", span.Links())
+		}
+	}
+
+	if children != 1 {
+		t.Fatalf("got %d send spans which are part of the trace of the dispatch, want 1", children)
+	}
+}
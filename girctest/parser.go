@@ -0,0 +1,184 @@
+// Copyright (c) Liam Stanley <me@liamstanley.io>. All rights reserved. Use
+// of this source code is governed by the MIT license that can be found in
+// the LICENSE file.
+
+package girctest
+
+import (
+	"encoding/json"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/lrstanley/girc"
+)
+
+// MessageAtoms are the parts of a message, in the irc-parser-tests corpus
+// (https://github.com/ircdocs/parser-tests), a set of test cases for IRC
+// message parsers shared between IRC libraries. The corpus is distributed
+// as YAML, and is read here in JSON form (converted as is, e.g. with yq),
+// so girc doesn't need a YAML parser. A nil Source means the message has
+// no source.
+type MessageAtoms struct {
+	Tags   map[string]string `json:"tags"`
+	Source *string           `json:"source"`
+	Verb   string            `json:"verb"`
+	Params []string          `json:"params"`
+}
+
+// SplitTest is a test from msg-split in the irc-parser-tests corpus: Input
+// must be parsed into Atoms.
+type SplitTest struct {
+	Input string       `json:"input"`
+	Atoms MessageAtoms `json:"atoms"`
+}
+
+// JoinTest is a test from msg-join in the irc-parser-tests corpus: Atoms
+// must be encoded as one of Matches.
+type JoinTest struct {
+	Desc    string       `json:"desc"`
+	Atoms   MessageAtoms `json:"atoms"`
+	Matches []string     `json:"matches"`
+}
+
+// UserhostTest is a test from userhost-split in the irc-parser-tests
+// corpus: Source must be parsed into Atoms.
+type UserhostTest struct {
+	Source string `json:"source"`
+	Atoms  struct {
+		Nick string `json:"nick"`
+		User string `json:"user"`
+		Host string `json:"host"`
+	} `json:"atoms"`
+}
+
+// readTests reads the tests of a corpus file into tests.
+func readTests(r io.Reader, tests interface{}) error {
+	file := struct {
+		Tests interface{} `json:"tests"`
+	}{Tests: tests}
+
+	return json.NewDecoder(r).Decode(&file)
+}
+
+// ReadSplitTests reads the tests of msg-split, in JSON form.
+func ReadSplitTests(r io.Reader) (tests []SplitTest, err error) {
+	err = readTests(r, &tests)
+	return tests, err
+}
+
+// ReadJoinTests reads the tests of msg-join, in JSON form.
+func ReadJoinTests(r io.Reader) (tests []JoinTest, err error) {
+	err = readTests(r, &tests)
+	return tests, err
+}
+
+// ReadUserhostTests reads the tests of userhost-split, in JSON form.
+func ReadUserhostTests(r io.Reader) (tests []UserhostTest, err error) {
+	err = readTests(r, &tests)
+	return tests, err
+}
+
+// eventParams returns the params of event, with the trailing parameter (if
+// any) as the last one, as the corpus doesn't distinguish them.
+func eventParams(event *girc.Event) []string {
+	params := append([]string(nil), event.Params...)
+	if event.Trailing != "" || event.EmptyTrailing {
+		params = append(params, event.Trailing)
+	}
+
+	return params
+}
+
+// RunSplitTests parses the input of each test with parse (e.g.
+// girc.ParseEventStrict), failing t if it doesn't match the atoms of the
+// test. Commands are compared case insensitively, as girc uppercases them.
+func RunSplitTests(t testing.TB, tests []SplitTest, parse func(raw string) (*girc.Event, error)) {
+	t.Helper()
+
+	for _, tt := range tests {
+		event, err := parse(tt.Input)
+		if err != nil {
+			t.Errorf("parse(%q) returned error: %s", tt.Input, err)
+			continue
+		}
+		if event == nil {
+			t.Errorf("parse(%q) returned no event", tt.Input)
+			continue
+		}
+
+		if !strings.EqualFold(event.Command, tt.Atoms.Verb) {
+			t.Errorf("parse(%q).Command = %q, want %q", tt.Input, event.Command, tt.Atoms.Verb)
+		}
+
+		switch {
+		case tt.Atoms.Source == nil && event.Source != nil:
+			t.Errorf("parse(%q).Source = %q, want none", tt.Input, event.Source)
+		case tt.Atoms.Source != nil && (event.Source == nil || event.Source.String() != *tt.Atoms.Source):
+			t.Errorf("parse(%q).Source = %v, want %q", tt.Input, event.Source, *tt.Atoms.Source)
+		}
+
+		if params := eventParams(event); len(params) != len(tt.Atoms.Params) || (len(params) > 0 && !reflect.DeepEqual(params, tt.Atoms.Params)) {
+			t.Errorf("parse(%q) has params %q, want %q", tt.Input, params, tt.Atoms.Params)
+		}
+
+		if len(event.Tags) != len(tt.Atoms.Tags) {
+			t.Errorf("parse(%q).Tags = %q, want %q", tt.Input, event.Tags, tt.Atoms.Tags)
+			continue
+		}
+		for key, want := range tt.Atoms.Tags {
+			if got, ok := event.Tags.Get(key); !ok || got != want {
+				t.Errorf("parse(%q).Tags.Get(%q) = %q, %v, want %q", tt.Input, key, got, ok, want)
+			}
+		}
+	}
+}
+
+// RunJoinTests encodes the atoms of each test as an event (see
+// girc.Event.String), failing t if it doesn't match one of the matches of
+// the test.
+func RunJoinTests(t testing.TB, tests []JoinTest) {
+	t.Helper()
+
+	for _, tt := range tests {
+		event := &girc.Event{Command: tt.Atoms.Verb, Params: tt.Atoms.Params}
+		if tt.Atoms.Source != nil {
+			event.Source = girc.ParseSource(*tt.Atoms.Source)
+		}
+
+		if tt.Atoms.Tags != nil {
+			event.Tags = girc.Tags{}
+			for key, value := range tt.Atoms.Tags {
+				if err := event.Tags.Set(key, value); err != nil {
+					t.Errorf("%s: unable to set tag: %s", tt.Desc, err)
+				}
+			}
+		}
+
+		got := event.String()
+
+		var found bool
+		for _, match := range tt.Matches {
+			found = found || got == match
+		}
+
+		if !found {
+			t.Errorf("%s: encoded as %q, want one of %q", tt.Desc, got, tt.Matches)
+		}
+	}
+}
+
+// RunUserhostTests parses the source of each test with girc.ParseSource,
+// failing t if it doesn't match the atoms of the test.
+func RunUserhostTests(t testing.TB, tests []UserhostTest) {
+	t.Helper()
+
+	for _, tt := range tests {
+		src := girc.ParseSource(tt.Source)
+
+		if src.Name != tt.Atoms.Nick || src.Ident != tt.Atoms.User || src.Host != tt.Atoms.Host {
+			t.Errorf("ParseSource(%q) = %#v, want nick %q, user %q and host %q", tt.Source, src, tt.Atoms.Nick, tt.Atoms.User, tt.Atoms.Host)
+		}
+	}
+}
@@ -0,0 +1,62 @@
+// Copyright (c) Liam Stanley <me@liamstanley.io>. All rights reserved. Use
+// of this source code is governed by the MIT license that can be found in
+// the LICENSE file.
+
+package girctest
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/lrstanley/girc"
+)
+
+// openTestdata opens the given file in testdata.
+func openTestdata(t *testing.T, name string) *os.File {
+	f, err := os.Open(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return f
+}
+
+func TestMsgSplit(t *testing.T) {
+	f := openTestdata(t, "msg-split.json")
+	defer f.Close()
+
+	tests, err := ReadSplitTests(f)
+	if err != nil {
+		t.Fatalf("unable to read tests: %s", err)
+	}
+
+	RunSplitTests(t, tests, girc.ParseEventStrict)
+	RunSplitTests(t, tests, func(raw string) (*girc.Event, error) {
+		return girc.ParseEvent(raw), nil
+	})
+}
+
+func TestMsgJoin(t *testing.T) {
+	f := openTestdata(t, "msg-join.json")
+	defer f.Close()
+
+	tests, err := ReadJoinTests(f)
+	if err != nil {
+		t.Fatalf("unable to read tests: %s", err)
+	}
+
+	RunJoinTests(t, tests)
+}
+
+func TestUserhostSplit(t *testing.T) {
+	f := openTestdata(t, "userhost-split.json")
+	defer f.Close()
+
+	tests, err := ReadUserhostTests(f)
+	if err != nil {
+		t.Fatalf("unable to read tests: %s", err)
+	}
+
+	RunUserhostTests(t, tests)
+}
@@ -0,0 +1,681 @@
+// Copyright (c) Liam Stanley <me@liamstanley.io>. All rights reserved. Use
+// of this source code is governed by the MIT license that can be found in
+// the LICENSE file.
+
+// Package girctest provides a scriptable, in-memory IRC server, for testing
+// clients (e.g. bots) built with girc, without a real IRC server:
+//
+//	func TestGreeter(t *testing.T) {
+//		client, server := girctest.NewClient(t, girc.Config{})
+//		defer client.Stop()
+//
+//		client.Handlers.Add(girc.JOIN, func(c *girc.Client, e girc.Event) {
+//			c.Commands.Message(e.Params[0], "hello, "+e.Source.Name+"!")
+//		})
+//
+//		server.Register()
+//		server.Reply(":other!user@host JOIN #channel")
+//		server.Expect("PRIVMSG #channel :hello, other!")
+//	}
+package girctest
+
+import (
+	"bufio"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/lrstanley/girc"
+	"golang.org/x/net/context"
+)
+
+// DefaultTimeout is how long the server waits for lines from the client, if
+// Server.Timeout isn't set.
+const DefaultTimeout = 2 * time.Second
+
+// saslChunkSize is the maximum length of the base64 encoded data sent in a
+// single AUTHENTICATE line.
+const saslChunkSize = 400
+
+// syncPrefix is the prefix of the tokens of the PINGs sent by Sync.
+const syncPrefix = "girctest-"
+
+// line is a line sent by the client.
+type line struct {
+	raw   string
+	event *girc.Event
+}
+
+// Server is an in-memory IRC server, which a girc client connects to using
+// the Dialer of the server (see NewClient). Tests script the server, reading
+// lines sent by the client (see Expect), and replying to them (see Reply).
+// Lines are read from the client as soon as they're sent, so the client
+// never blocks on a slow test.
+type Server struct {
+	// Name is the name of the server, used as the source of the replies
+	// sent by the canned flows (e.g. Register). Defaults to
+	// "irc.example.com".
+	Name string
+	// ISupport are the tokens sent in RPL_ISUPPORT by Welcome. Defaults to
+	// a few tokens which are sent by most servers.
+	ISupport []string
+	// Timeout is how long to wait for lines from the client before failing
+	// the test. Defaults to DefaultTimeout.
+	Timeout time.Duration
+
+	t testing.TB
+
+	mu     sync.Mutex
+	conn   net.Conn
+	nick   string
+	lines  []line
+	next   int           // index of the first line not yet read by Expect.
+	notify chan struct{} // signaled when lines are added.
+	syncs  int
+	synced chan string // tokens of PONGs sent in reply to Sync.
+}
+
+// NewServer returns a new in-memory server, which fails t when the client
+// doesn't behave as expected. Use the Dialer of the server to connect to
+// it, or use NewClient.
+func NewServer(t testing.TB) *Server {
+	return &Server{
+		t:      t,
+		notify: make(chan struct{}, 1),
+		synced: make(chan string, 16),
+	}
+}
+
+// NewClient connects a new client, with the given config, to a new
+// in-memory server. Server, Port, Nick and User default to
+// "irc.example.com", 6667, "nick" and "user" if not set. The caller should
+// stop the client (see Client.Stop) when done.
+func NewClient(t testing.TB, conf girc.Config) (*girc.Client, *Server) {
+	t.Helper()
+	s := NewServer(t)
+
+	if conf.Server == "" {
+		conf.Server, conf.Port = "irc.example.com", 6667
+	}
+	if conf.Nick == "" {
+		conf.Nick = "nick"
+	}
+	if conf.User == "" {
+		conf.User = "user"
+	}
+	conf.Dialer = s.Dialer()
+
+	c := girc.New(conf)
+	if err := c.Connect(); err != nil {
+		t.Fatalf("unable to connect to girctest server: %s", err)
+	}
+
+	return c, s
+}
+
+// dialer connects clients to a Server.
+type dialer struct {
+	s *Server
+}
+
+// DialContext connects to the server, regardless of the network and
+// address. Each call creates a new connection, replacing the previous one
+// (e.g. when the client reconnects).
+func (d *dialer) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	local, remote := net.Pipe()
+
+	d.s.mu.Lock()
+	if d.s.conn != nil {
+		d.s.conn.Close()
+	}
+	d.s.conn = remote
+	d.s.mu.Unlock()
+
+	go d.s.readLoop(remote)
+	return local, nil
+}
+
+// Dialer returns the dialer which connects clients to the server. See
+// girc.Config.Dialer.
+func (s *Server) Dialer() girc.Dialer {
+	return &dialer{s: s}
+}
+
+// name returns the name of the server.
+func (s *Server) name() string {
+	if s.Name == "" {
+		return "irc.example.com"
+	}
+
+	return s.Name
+}
+
+// timeout returns how long to wait for lines from the client.
+func (s *Server) timeout() time.Duration {
+	if s.Timeout == 0 {
+		return DefaultTimeout
+	}
+
+	return s.Timeout
+}
+
+// readLoop reads the lines sent by the client over conn, until it's
+// closed.
+func (s *Server) readLoop(conn net.Conn) {
+	r := bufio.NewReader(conn)
+
+	for {
+		raw, err := r.ReadString(0x0A) // \n
+		if err != nil {
+			return
+		}
+
+		raw = strings.TrimRight(raw, "\r\n")
+		event := girc.ParseEvent(raw)
+		if event == nil {
+			continue
+		}
+
+		// PONGs in reply to Sync aren't recorded.
+		if event.Command == girc.PONG {
+			token := event.Trailing
+			if token == "" && len(event.Params) > 0 {
+				token = event.Params[len(event.Params)-1]
+			}
+
+			if strings.HasPrefix(token, syncPrefix) {
+				s.synced <- token
+				continue
+			}
+		}
+
+		s.mu.Lock()
+		s.lines = append(s.lines, line{raw: raw, event: event})
+		s.mu.Unlock()
+
+		select {
+		case s.notify <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// nextLine returns the next line sent by the client which wasn't read yet,
+// waiting until the deadline for one to be sent.
+func (s *Server) nextLine(deadline <-chan time.Time) (line, bool) {
+	for {
+		s.mu.Lock()
+		if s.next < len(s.lines) {
+			l := s.lines[s.next]
+			s.next++
+			s.mu.Unlock()
+			return l, true
+		}
+		s.mu.Unlock()
+
+		select {
+		case <-s.notify:
+		case <-deadline:
+			return line{}, false
+		}
+	}
+}
+
+// unread makes the last line returned by nextLine the next one again.
+func (s *Server) unread() {
+	s.mu.Lock()
+	s.next--
+	s.mu.Unlock()
+}
+
+// expect reads lines sent by the client until one matches, failing the
+// test if none is sent in time. Lines which don't match are skipped.
+func (s *Server) expect(desc string, match func(l line) bool) *girc.Event {
+	s.t.Helper()
+	deadline := time.After(s.timeout())
+
+	for {
+		l, ok := s.nextLine(deadline)
+		if !ok {
+			s.t.Fatalf("girctest: never received %s from client", desc)
+			return nil
+		}
+
+		if match(l) {
+			return l.event.Copy()
+		}
+	}
+}
+
+// Expect reads lines sent by the client until the given line is found
+// (e.g. "PRIVMSG #channel :hello"), skipping any others, and failing the
+// test if it isn't sent in time. Lines are compared as sent, including
+// tags, without the trailing CRLF.
+func (s *Server) Expect(raw string) *girc.Event {
+	s.t.Helper()
+	return s.expect(strconv.Quote(raw), func(l line) bool { return l.raw == raw })
+}
+
+// ExpectPrefix is much like Expect, however reads lines until one starting
+// with prefix is found.
+func (s *Server) ExpectPrefix(prefix string) *girc.Event {
+	s.t.Helper()
+	return s.expect(fmt.Sprintf("line with prefix %q", prefix), func(l line) bool {
+		return strings.HasPrefix(l.raw, prefix)
+	})
+}
+
+// ExpectCommand is much like Expect, however reads lines until an event
+// with the given command (e.g. girc.PRIVMSG) is found.
+func (s *Server) ExpectCommand(command string) *girc.Event {
+	s.t.Helper()
+	return s.expect(command, func(l line) bool { return l.event.Command == command })
+}
+
+// Reply writes the given raw lines (without the trailing CRLF) to the
+// client, failing the test if they can't be written in time.
+func (s *Server) Reply(lines ...string) {
+	s.t.Helper()
+
+	s.mu.Lock()
+	conn := s.conn
+	s.mu.Unlock()
+
+	if conn == nil {
+		s.t.Fatal("girctest: no client is connected")
+		return
+	}
+
+	for _, raw := range lines {
+		conn.SetWriteDeadline(time.Now().Add(s.timeout()))
+
+		if _, err := conn.Write([]byte(raw + "\r\n")); err != nil {
+			s.t.Fatalf("girctest: unable to send %q to client: %s", raw, err)
+			return
+		}
+	}
+}
+
+// Replyf is much like Reply, however formats a single line, according to a
+// format specifier.
+func (s *Server) Replyf(format string, a ...interface{}) {
+	s.t.Helper()
+	s.Reply(fmt.Sprintf(format, a...))
+}
+
+// Sync waits until the client has processed all lines sent to it so far,
+// and sent everything it was going to send in response, by sending a PING
+// and waiting for its PONG. The PONG isn't recorded.
+func (s *Server) Sync() {
+	s.t.Helper()
+
+	s.mu.Lock()
+	s.syncs++
+	token := syncPrefix + strconv.Itoa(s.syncs)
+	s.mu.Unlock()
+
+	s.Reply("PING :" + token)
+	deadline := time.After(s.timeout())
+
+	for {
+		select {
+		case got := <-s.synced:
+			if got == token {
+				return
+			}
+		case <-deadline:
+			s.t.Fatal("girctest: client never replied to PING")
+			return
+		}
+	}
+}
+
+// Close closes the connection to the client, as if the server
+// disconnected it.
+func (s *Server) Close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.conn != nil {
+		s.conn.Close()
+		s.conn = nil
+	}
+}
+
+// Nick returns the nickname the client registered with (see Register).
+func (s *Server) Nick() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.nick
+}
+
+// defaultISupport are the RPL_ISUPPORT tokens sent by Welcome, unless
+// Server.ISupport is set.
+var defaultISupport = []string{
+	"NETWORK=girctest", "CASEMAPPING=rfc1459", "CHANTYPES=#&",
+	"PREFIX=(ov)@+", "CHANMODES=beI,k,l,imnpst", "NICKLEN=30",
+}
+
+// Welcome sends the replies which complete registration: RPL_WELCOME,
+// RPL_YOURHOST, RPL_CREATED, RPL_MYINFO, RPL_ISUPPORT (see
+// Server.ISupport), and the MOTD. The client's nickname is taken from the
+// NICK it sent during registration, if Register wasn't used.
+func (s *Server) Welcome() {
+	s.t.Helper()
+
+	nick := s.Nick()
+	if nick == "" {
+		nick = s.registeredNick()
+	}
+
+	isupport := s.ISupport
+	if isupport == nil {
+		isupport = defaultISupport
+	}
+
+	name := s.name()
+	s.Reply(
+		":"+name+" 001 "+nick+" :Welcome to the girctest IRC network "+nick,
+		":"+name+" 002 "+nick+" :Your host is "+name+", running version girctest",
+		":"+name+" 003 "+nick+" :This server was created today",
+		":"+name+" 004 "+nick+" "+name+" girctest iow imnpstbeIkl bkloveI",
+		":"+name+" 005 "+nick+" "+strings.Join(isupport, " ")+" :are supported by this server",
+		":"+name+" 375 "+nick+" :- "+name+" Message of the day -",
+		":"+name+" 372 "+nick+" :- This is a girctest server.",
+		":"+name+" 376 "+nick+" :End of /MOTD command.",
+	)
+}
+
+// registeredNick returns the last nickname sent with NICK by the client,
+// or "*" if none was sent.
+func (s *Server) registeredNick() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i := len(s.lines) - 1; i >= 0; i-- {
+		if e := s.lines[i].event; e.Command == girc.NICK && len(e.Params) > 0 {
+			return e.Params[0]
+		}
+	}
+
+	return "*"
+}
+
+// Register completes the registration of the client: it reads the NICK
+// and USER sent by the client, negotiates the given capabilities (see
+// Negotiate), completes SASL authentication if the client starts it (see
+// SASL, the client is logged in to an account named after its nickname),
+// and sends the welcome burst (see Welcome). If no capabilities are given,
+// capability negotiation is left unanswered, like a server which doesn't
+// support it. Returns the nickname of the client.
+func (s *Server) Register(caps ...string) string {
+	s.t.Helper()
+
+	n := newNegotiation(s, caps)
+	deadline := time.After(s.timeout())
+
+	// Capability negotiation (and SASL) may happen before, or after, NICK
+	// and USER are sent.
+	var nick string
+	var user bool
+	var auth *girc.Event
+	for nick == "" || !user || (len(caps) > 0 && !n.ended) {
+		l, ok := s.nextLine(deadline)
+		if !ok {
+			s.t.Fatal("girctest: client never registered")
+			return ""
+		}
+
+		e := l.event
+		switch {
+		case e.Command == girc.NICK && len(e.Params) > 0:
+			nick = e.Params[0]
+		case e.Command == girc.USER:
+			user = true
+		case e.Command == girc.AUTHENTICATE && len(caps) > 0:
+			auth = e
+		case len(caps) > 0:
+			n.handle(e)
+		}
+
+		if auth != nil && nick != "" {
+			s.authenticate(auth)
+			s.login(nick, nick)
+			auth = nil
+		}
+	}
+
+	s.mu.Lock()
+	s.nick = nick
+	s.mu.Unlock()
+
+	s.Welcome()
+	return nick
+}
+
+// negotiation answers the capability negotiation of the client.
+type negotiation struct {
+	s       *Server
+	caps    []string
+	offered map[string]bool
+	acked   []string
+	ended   bool
+}
+
+// newNegotiation returns a negotiation which offers caps to the client.
+func newNegotiation(s *Server, caps []string) *negotiation {
+	n := &negotiation{s: s, caps: caps, offered: make(map[string]bool, len(caps))}
+
+	for _, c := range caps {
+		if i := strings.IndexByte(c, 0x3D); i > -1 { // =
+			c = c[:i]
+		}
+		n.offered[c] = true
+	}
+
+	return n
+}
+
+// handle answers e, if it's part of capability negotiation: CAP LS is
+// answered with the offered capabilities, and requests for them are
+// acknowledged (requests for others are rejected).
+func (n *negotiation) handle(e *girc.Event) {
+	n.s.t.Helper()
+
+	if e.Command != girc.CAP || len(e.Params) == 0 {
+		return
+	}
+
+	switch e.Params[0] {
+	case girc.CAP_LS:
+		n.s.Reply(":" + n.s.name() + " CAP * LS :" + strings.Join(n.caps, " "))
+	case girc.CAP_END:
+		n.ended = true
+	case girc.CAP_REQ:
+		requested := strings.Fields(e.Trailing)
+
+		// Requests are all or nothing.
+		reply := girc.CAP_ACK
+		for _, name := range requested {
+			if !n.offered[strings.TrimPrefix(name, "-")] {
+				reply = girc.CAP_NAK
+			}
+		}
+		if reply == girc.CAP_ACK {
+			n.acked = append(n.acked, requested...)
+		}
+
+		n.s.Reply(":" + n.s.name() + " CAP * " + reply + " :" + e.Trailing)
+	}
+}
+
+// Negotiate answers the CAP LS sent by the client with the given
+// capabilities (e.g. "sasl=PLAIN", "account-tag"), and acknowledges the
+// requests for them (requests for other capabilities are rejected), until
+// the client ends negotiation with CAP END, or starts SASL authentication
+// (see SASL). Returns the acknowledged capabilities.
+func (s *Server) Negotiate(caps ...string) (acked []string) {
+	s.t.Helper()
+
+	n := newNegotiation(s, caps)
+	n.handle(s.ExpectPrefix("CAP LS"))
+	deadline := time.After(s.timeout())
+
+	for !n.ended {
+		l, ok := s.nextLine(deadline)
+		if !ok {
+			s.t.Fatal("girctest: client never ended capability negotiation")
+			return n.acked
+		}
+
+		if l.event.Command == girc.AUTHENTICATE {
+			s.unread()
+			break
+		}
+
+		n.handle(l.event)
+	}
+
+	return n.acked
+}
+
+// authenticate reads the rest of the SASL exchange started by the client
+// with start (a single response to an empty challenge), returning the
+// mechanism and the decoded response.
+func (s *Server) authenticate(start *girc.Event) (mech string, response []byte) {
+	s.t.Helper()
+
+	if len(start.Params) == 0 {
+		s.t.Fatalf("girctest: client sent AUTHENTICATE without a mechanism: %s", start)
+		return "", nil
+	}
+	mech = start.Params[0]
+
+	s.Reply("AUTHENTICATE +")
+
+	var encoded string
+	for {
+		e := s.ExpectCommand(girc.AUTHENTICATE)
+		if len(e.Params) == 0 || e.Params[0] == "*" {
+			s.t.Fatalf("girctest: client aborted SASL authentication: %s", e)
+			return mech, nil
+		}
+
+		if e.Params[0] != "+" {
+			encoded += e.Params[0]
+		}
+		if len(e.Params[0]) < saslChunkSize {
+			break
+		}
+	}
+
+	response, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		s.t.Fatalf("girctest: client sent invalid SASL response %q: %s", encoded, err)
+	}
+
+	return mech, response
+}
+
+// login tells the client with the given nickname that it's logged in to
+// account.
+func (s *Server) login(nick, account string) {
+	s.t.Helper()
+
+	s.Reply(
+		":"+s.name()+" 900 "+nick+" "+nick+"!user@host "+account+" :You are now logged in as "+account,
+		":"+s.name()+" 903 "+nick+" :SASL authentication successful",
+	)
+}
+
+// SASL answers the SASL authentication started by the client, logging it
+// in to account. Only mechanisms which send a single response to an empty
+// challenge (e.g. PLAIN and EXTERNAL) are supported. Returns the mechanism
+// and the (decoded) response of the client, e.g. "user\x00user\x00pass"
+// for PLAIN.
+func (s *Server) SASL(account string) (mech string, response []byte) {
+	s.t.Helper()
+
+	mech, response = s.authenticate(s.ExpectCommand(girc.AUTHENTICATE))
+	s.login(s.registeredNick(), account)
+
+	return mech, response
+}
+
+// SASLFail is much like SASL, however rejects the credentials of the
+// client (with ERR_SASLFAIL).
+func (s *Server) SASLFail() (mech string, response []byte) {
+	s.t.Helper()
+
+	mech, response = s.authenticate(s.ExpectCommand(girc.AUTHENTICATE))
+	s.Reply(":" + s.name() + " 904 " + s.registeredNick() + " :SASL authentication failed")
+
+	return mech, response
+}
+
+// Sent returns copies of all events sent by the client so far, including
+// those already read with Expect. Use Sync first to make sure the client
+// has sent everything in response to the lines sent to it.
+func (s *Server) Sent() []*girc.Event {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	events := make([]*girc.Event, len(s.lines))
+	for i := range s.lines {
+		events[i] = s.lines[i].event.Copy()
+	}
+
+	return events
+}
+
+// SentCommand is much like Sent, however only returns the events with the
+// given command (e.g. girc.PRIVMSG).
+func (s *Server) SentCommand(command string) (events []*girc.Event) {
+	for _, e := range s.Sent() {
+		if e.Command == command {
+			events = append(events, e)
+		}
+	}
+
+	return events
+}
+
+// sent returns true if the client has sent a line matching match.
+func (s *Server) sent(match func(l line) bool) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i := range s.lines {
+		if match(s.lines[i]) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// AssertSent fails the test unless the client has sent the given line (at
+// any point, see Sent). Unlike Expect, it doesn't wait for it to be sent.
+func (s *Server) AssertSent(raw string) {
+	s.t.Helper()
+
+	if !s.sent(func(l line) bool { return l.raw == raw }) {
+		s.t.Errorf("girctest: client never sent %q", raw)
+	}
+}
+
+// AssertNotSent fails the test if the client has sent a line starting with
+// prefix (at any point, see Sent).
+func (s *Server) AssertNotSent(prefix string) {
+	s.t.Helper()
+
+	if s.sent(func(l line) bool { return strings.HasPrefix(l.raw, prefix) }) {
+		s.t.Errorf("girctest: client sent a line starting with %q", prefix)
+	}
+}
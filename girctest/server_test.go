@@ -0,0 +1,104 @@
+// Copyright (c) Liam Stanley <me@liamstanley.io>. All rights reserved. Use
+// of this source code is governed by the MIT license that can be found in
+// the LICENSE file.
+
+package girctest
+
+import (
+	"testing"
+	"time"
+
+	"github.com/lrstanley/girc"
+)
+
+func TestRegister(t *testing.T) {
+	registered := make(chan struct{}, 1)
+	c, server := NewClient(t, girc.Config{Nick: "bot"})
+	defer c.Stop()
+
+	c.Handlers.Add(girc.REGISTERED, func(c *girc.Client, e girc.Event) {
+		registered <- struct{}{}
+	})
+
+	if nick := server.Register(); nick != "bot" {
+		t.Fatalf("Register() = %q, want %q", nick, "bot")
+	}
+
+	select {
+	case <-registered:
+	case <-time.After(DefaultTimeout):
+		t.Fatal("client never registered")
+	}
+
+	server.Sync()
+	if network := c.NetworkName(); network != "girctest" {
+		t.Fatalf("NetworkName() = %q, want %q", network, "girctest")
+	}
+}
+
+func TestRegisterSASL(t *testing.T) {
+	c, server := NewClient(t, girc.Config{SASL: &girc.SASLPlain{User: "user", Pass: "pass"}})
+	defer c.Stop()
+
+	server.Register("sasl=PLAIN", "account-notify")
+	server.Sync()
+
+	if !c.HasCapability("sasl") || !c.HasCapability("account-notify") {
+		t.Fatal("client didn't enable the offered capabilities")
+	}
+	if account := c.GetAccount(); account != "nick" {
+		t.Fatalf("GetAccount() = %q, want %q", account, "nick")
+	}
+
+	server.AssertSent("AUTHENTICATE PLAIN")
+	server.AssertSent("CAP END")
+}
+
+func TestSASLFail(t *testing.T) {
+	errs := make(chan error, 1)
+	c, server := NewClient(t, girc.Config{
+		SASL:        &girc.SASLPlain{User: "user", Pass: "pass"},
+		HandleError: func(err error) { errs <- err },
+	})
+	defer c.Stop()
+
+	server.Negotiate("sasl")
+	mech, response := server.SASLFail()
+	if mech != "PLAIN" || string(response) != "user\x00user\x00pass" {
+		t.Fatalf("SASLFail() = %q, %q", mech, response)
+	}
+
+	select {
+	case err := <-errs:
+		if _, ok := err.(*girc.ErrSASLFailed); !ok {
+			t.Fatalf("HandleError() called with %#v, wanted *girc.ErrSASLFailed", err)
+		}
+	case <-time.After(DefaultTimeout):
+		t.Fatal("HandleError() not called after SASL failure")
+	}
+}
+
+func TestExpectAndSent(t *testing.T) {
+	c, server := NewClient(t, girc.Config{})
+	defer c.Stop()
+
+	c.Handlers.Add(girc.PRIVMSG, func(c *girc.Client, e girc.Event) {
+		if e.Trailing == "!ping" {
+			c.Commands.Message(e.Params[0], "pong")
+		}
+	})
+
+	server.Register()
+	server.Reply(":other!user@host PRIVMSG #channel :!ping", ":other!user@host PRIVMSG #channel :!help")
+
+	if e := server.Expect("PRIVMSG #channel :pong"); e.Trailing != "pong" {
+		t.Fatalf("Expect() = %s", e)
+	}
+
+	server.Sync()
+	if sent := server.SentCommand(girc.PRIVMSG); len(sent) != 1 {
+		t.Fatalf("SentCommand(PRIVMSG) = %v, want a single event", sent)
+	}
+	server.AssertSent("NICK nick")
+	server.AssertNotSent("PONG")
+}
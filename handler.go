@@ -8,40 +8,127 @@ import (
 	"fmt"
 	"log"
 	"math/rand"
+	"reflect"
 	"runtime"
 	"runtime/debug"
 	"strings"
 	"sync"
 	"time"
+
+	"golang.org/x/net/context"
 )
 
 // RunHandlers manually runs handlers for a given event.
 func (c *Client) RunHandlers(event *Event) {
+	for _, e := range c.receive(event) {
+		c.dispatch(e)
+	}
+}
+
+// receive prepares an event for dispatch, returning the events to dispatch,
+// in order, as events within batches may be held back until the batch has
+// been closed.
+func (c *Client) receive(event *Event) []*Event {
 	if event == nil {
-		return
+		return nil
+	}
+
+	if event.Timestamp.IsZero() {
+		event.Timestamp = time.Now()
+	}
+
+	// Log the event. Credentials may be received too, e.g. our own NickServ
+	// IDENTIFY echoed back (see the echo-message capability).
+	if c.isSensitive(event) {
+		event.Sensitive = true
+	}
+	c.debug.Print("< " + StripRaw(c.Redact(event).String()))
+
+	// Events within batches are either annotated with the batch, or
+	// collected until the batch has been closed. See Batch.
+	return c.batches.process(c, event)
+}
+
+// dispatch runs all handlers for a given event.
+func (c *Client) dispatch(event *Event) {
+	if tracer := c.Config.Tracer; tracer != nil {
+		defer traceDispatch(tracer, event)()
 	}
 
-	// Log the event.
-	c.debug.Print("< " + StripRaw(event.String()))
 	if c.Config.Out != nil {
 		if pretty, ok := event.Pretty(); ok {
 			fmt.Fprintln(c.Config.Out, StripRaw(pretty))
 		}
 	}
 
+	event.self = c.isSelfEvent(event)
+
+	// Events from ignored sources only go through internal handlers.
+	ignored := c.Ignores.ignored(event) || (c.Config.IgnoreBots && isBotEvent(event))
+
+	// Our own messages go to user handlers as SELF_MESSAGE, if enabled.
+	if c.Config.SelfMessages && event.self && !ignored &&
+		(event.Command == PRIVMSG || event.Command == NOTICE || event.Command == TAGMSG) {
+		c.Handlers.exec(ALLEVENTS, true, c, event.Copy())
+		c.Handlers.exec(event.Command, true, c, event.Copy())
+
+		event = selfMessage(event)
+	}
+
 	// Regular wildcard handlers.
-	c.Handlers.exec(ALLEVENTS, c, event.Copy())
+	c.Handlers.exec(ALLEVENTS, ignored, c, event.Copy())
 
 	// Then regular handlers.
-	c.Handlers.exec(event.Command, c, event.Copy())
+	c.Handlers.exec(event.Command, ignored, c, event.Copy())
 
-	// Check if it's a CTCP.
-	if ctcp := decodeCTCP(event.Copy()); ctcp != nil {
+	if ignored || event.Replayed {
+		return
+	}
+
+	// Check if it's a CTCP. Messages we sent ourselves (see echo-message)
+	// aren't CTCP requests.
+	if ctcp := DecodeCTCP(event.Copy()); ctcp != nil && !c.isSelf(ctcp.Source) {
 		// Execute it.
 		c.CTCP.call(c, ctcp)
 	}
 }
 
+// isSelfEvent returns true if the event is from ourselves, by nickname or
+// account. See Event.IsSelf.
+func (c *Client) isSelfEvent(event *Event) bool {
+	if event.Source == nil || event.Source.IsServer() || c.Config.disableTracking {
+		return false
+	}
+
+	if c.isSelf(event.Source) {
+		return true
+	}
+
+	account, ok := event.Tag("account")
+	if !ok || account == "" || account == "*" {
+		return false
+	}
+
+	c.state.mu.RLock()
+	defer c.state.mu.RUnlock()
+
+	return c.state.account != "" && c.state.toLower(account) == c.state.toLower(c.state.account)
+}
+
+// selfMessage returns the SELF_MESSAGE event for our own message. See
+// Config.SelfMessages.
+func selfMessage(event *Event) *Event {
+	e := event.Copy()
+	e.Command = SELF_MESSAGE
+	if len(event.Params) > 0 {
+		e.Params = []string{event.Params[0], event.Command}
+	} else {
+		e.Params = []string{"", event.Command}
+	}
+
+	return e
+}
+
 // Handler is lower level implementation of a handler. See
 // Caller.AddHandler()
 type Handler interface {
@@ -61,8 +148,6 @@ func (f HandlerFunc) Execute(client *Client, event Event) {
 type Caller struct {
 	// mu is the mutex that should be used when accessing handlers.
 	mu sync.RWMutex
-	// wg is the waitgroup which is used to execute all handlers concurrently.
-	wg sync.WaitGroup
 
 	// external/internal keys are of structure:
 	//   map[COMMAND][CUID]Handler
@@ -72,6 +157,10 @@ type Caller struct {
 	external map[string]map[string]Handler
 	// internal is a map of internally used handlers for the client.
 	internal map[string]map[string]Handler
+	// owned are the cuids of the external handlers which are managed by the
+	// client (e.g. those waiting for replies, or added by plugins), rather
+	// than added by the user. See Caller.ClearUser.
+	owned map[string]struct{}
 	// debug is the clients logger used for debugging.
 	debug *log.Logger
 }
@@ -81,6 +170,7 @@ func newCaller(debugOut *log.Logger) *Caller {
 	c := &Caller{
 		external: map[string]map[string]Handler{},
 		internal: map[string]map[string]Handler{},
+		owned:    map[string]struct{}{},
 		debug:    debugOut,
 	}
 
@@ -134,13 +224,21 @@ const letterBytes = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ"
 
 // cuid generates a unique UID string for each handler for ease of removal.
 func (c *Caller) cuid(cmd string, n int) (cuid, uid string) {
+	uid = randomRef(n)
+
+	return cmd + ":" + uid, uid
+}
+
+// randomRef returns a random string of letters of length n, e.g. for use as
+// a batch reference tag.
+func randomRef(n int) string {
 	b := make([]byte, n)
 
 	for i := range b {
 		b[i] = letterBytes[rand.Int63()%int64(len(letterBytes))]
 	}
 
-	return cmd + ":" + string(b), string(b)
+	return string(b)
 }
 
 // cuidToID allows easy mapping between a generated cuid and the caller
@@ -159,37 +257,63 @@ type execStack struct {
 	cuid string
 }
 
+// Handler priorities, see HandlerInfo.Priority. Handlers for an event are
+// executed in order of priority (lowest first), each priority once those
+// with the previous one have returned. Handlers with the same priority are
+// executed concurrently, in no specific order.
+const (
+	PriorityInternal = 0 // internal handlers, e.g. tracking the state
+	PriorityUser     = 1 // user (external) handlers
+)
+
 // exec executes all handlers pertaining to specified event. Internal first,
-// then external.
+// then external (unless internalOnly is true), once the internal handlers
+// have returned, so they see the updated state.
 //
-// Please note that there is no specific order/priority for which the
-// handler types themselves or the handlers are executed.
-func (c *Caller) exec(command string, client *Client, event *Event) {
-	// Build a stack of handlers which can be executed concurrently.
-	var stack []execStack
+// Please note that there is no specific order/priority in which handlers of
+// the same type are executed.
+func (c *Caller) exec(command string, internalOnly bool, client *Client, event *Event) {
+	// Build stacks of handlers which can be executed concurrently.
+	var internal, external []execStack
 
 	c.mu.RLock()
-	// Get internal handlers first.
-	if _, ok := c.internal[command]; ok {
+	// Get internal handlers first. Replayed events already happened, so
+	// they mustn't affect the state.
+	if _, ok := c.internal[command]; ok && !event.Replayed {
 		for cuid := range c.internal[command] {
-			stack = append(stack, execStack{c.internal[command][cuid], cuid})
+			internal = append(internal, execStack{c.internal[command][cuid], cuid})
 		}
 	}
 
 	// Aaand then external handlers.
-	if _, ok := c.external[command]; ok {
+	if _, ok := c.external[command]; ok && !internalOnly {
 		for cuid := range c.external[command] {
-			stack = append(stack, execStack{c.external[command][cuid], cuid})
+			external = append(external, execStack{c.external[command][cuid], cuid})
 		}
 	}
 	c.mu.RUnlock()
 
+	c.execStack(command, client, event, internal)
+	c.execStack(command, client, event, external)
+}
+
+// execStack executes the handlers in stack concurrently, and waits for them
+// to return.
+func (c *Caller) execStack(command string, client *Client, event *Event, stack []execStack) {
+	if len(stack) == 0 {
+		return
+	}
+
 	// Run all handlers concurrently across the same event. This should
 	// still help prevent mis-ordered events, while speeding up the
-	// execution speed.
-	c.wg.Add(len(stack))
+	// execution speed. The waitgroup is local to this execution, as events
+	// may be dispatched from more than one goroutine at a time.
+	var wg sync.WaitGroup
+	wg.Add(len(stack))
 	for i := 0; i < len(stack); i++ {
 		go func(index int) {
+			defer wg.Done()
+
 			c.debug.Printf("executing handler %s for event %s", stack[index].cuid, command)
 			start := time.Now()
 
@@ -198,28 +322,118 @@ func (c *Caller) exec(command string, client *Client, event *Event) {
 				defer recoverHandlerPanic(client, event, stack[index].cuid, 3)
 			}
 
-			stack[index].Execute(client, *event)
+			e := *event
+			if tracer := client.Config.Tracer; tracer != nil && event.ctx != nil {
+				defer traceHandler(tracer, &e, stack[index].cuid)()
+			}
+
+			stack[index].Execute(client, e)
 
 			c.debug.Printf("execution of %s took %s", stack[index].cuid, time.Since(start))
-			c.wg.Done()
 		}(i)
 	}
 
 	// Wait for all of the handlers to complete. Not doing this may cause
 	// new events from becoming ahead of older handlers.
-	c.wg.Wait()
+	wg.Wait()
 }
 
 // ClearAll clears all external handlers currently setup within the client.
-// This ignores internal handlers.
+// This ignores internal handlers. Note that this includes the handlers
+// managed by the client, e.g. those of plugins (see Caller.ClearUser).
 func (c *Caller) ClearAll() {
 	c.mu.Lock()
 	c.external = map[string]map[string]Handler{}
+	c.owned = map[string]struct{}{}
 	c.mu.Unlock()
 
 	c.debug.Print("cleared all external handlers")
 }
 
+// ClearUser clears all handlers added by the user (e.g. with Caller.Add()),
+// leaving the internal handlers the client needs to function, and the
+// handlers it manages: those of plugins (see Client.Plugins) and pools (see
+// Pool), and those waiting for replies to commands (e.g. Commands.Whois).
+// This is useful when reloading the handlers of a bot.
+func (c *Caller) ClearUser() {
+	c.mu.Lock()
+	for cmd := range c.external {
+		for uid := range c.external[cmd] {
+			if _, ok := c.owned[cmd+":"+uid]; !ok {
+				delete(c.external[cmd], uid)
+			}
+		}
+
+		if len(c.external[cmd]) == 0 {
+			delete(c.external, cmd)
+		}
+	}
+	c.mu.Unlock()
+
+	c.debug.Print("cleared all user handlers")
+}
+
+// HandlerInfo describes a registered handler. See Caller.List().
+type HandlerInfo struct {
+	// CUID is the handler uid, which can be used with Caller.Remove().
+	CUID string
+	// Command is the command (or event) which the handler is registered
+	// for.
+	Command string
+	// Name is the name of the function (for handlers added with
+	// Caller.Add()), or the type of the handler (for those added with
+	// Caller.AddHandler()).
+	Name string
+	// Internal is true if the handler is one of the clients internal
+	// handlers, rather than one added by the user.
+	Internal bool
+	// Priority is the priority the handler is executed with, e.g.
+	// PriorityInternal or PriorityUser. Handlers with a lower priority are
+	// executed first.
+	Priority int
+}
+
+// List returns all registered handlers (both internal and user handlers),
+// grouped by the command they are registered for. Note that handlers for
+// the same command with the same priority have no order between them, as
+// they are executed concurrently.
+func (c *Caller) List() map[string][]HandlerInfo {
+	out := make(map[string][]HandlerInfo)
+
+	c.mu.RLock()
+	for cmd := range c.internal {
+		for uid := range c.internal[cmd] {
+			out[cmd] = append(out[cmd], HandlerInfo{
+				CUID: cmd + ":" + uid, Command: cmd, Name: handlerName(c.internal[cmd][uid]), Internal: true,
+				Priority: PriorityInternal,
+			})
+		}
+	}
+
+	for cmd := range c.external {
+		for uid := range c.external[cmd] {
+			out[cmd] = append(out[cmd], HandlerInfo{
+				CUID: cmd + ":" + uid, Command: cmd, Name: handlerName(c.external[cmd][uid]),
+				Priority: PriorityUser,
+			})
+		}
+	}
+	c.mu.RUnlock()
+
+	return out
+}
+
+// handlerName returns a descriptive name for a handler.
+func handlerName(handler Handler) string {
+	if fn, ok := handler.(HandlerFunc); ok {
+		if f := runtime.FuncForPC(reflect.ValueOf(fn).Pointer()); f != nil {
+			return f.Name()
+		}
+	}
+
+	return fmt.Sprintf("%T", handler)
+}
+
 // clearInternal clears all internal handlers currently setup within the
 // client.
 func (c *Caller) clearInternal() {
@@ -237,6 +451,9 @@ func (c *Caller) Clear(cmd string) {
 
 	c.mu.Lock()
 	if _, ok := c.external[cmd]; ok {
+		for uid := range c.external[cmd] {
+			delete(c.owned, cmd+":"+uid)
+		}
 		delete(c.external, cmd)
 	}
 	c.mu.Unlock()
@@ -274,6 +491,7 @@ func (c *Caller) remove(cuid string) (success bool) {
 	}
 
 	delete(c.external[cmd], uid)
+	delete(c.owned, cuid)
 	c.debug.Printf("removed handler %s", cuid)
 
 	// Assume success.
@@ -290,6 +508,26 @@ func (c *Caller) sregister(internal bool, cmd string, handler Handler) (cuid str
 	return cuid
 }
 
+// sregisterOwned is like Caller.sregister(), for an external handler which is
+// managed by the client (e.g. one waiting for replies), rather than the
+// user. See Caller.ClearUser().
+func (c *Caller) sregisterOwned(cmd string, handler Handler) (cuid string) {
+	c.mu.Lock()
+	cuid = c.registerOwned(cmd, handler)
+	c.mu.Unlock()
+
+	return cuid
+}
+
+// registerOwned is like Caller.sregisterOwned(), but unsafe (you must lock
+// c.mu yourself!)
+func (c *Caller) registerOwned(cmd string, handler Handler) (cuid string) {
+	cuid = c.register(false, cmd, handler)
+	c.owned[cuid] = struct{}{}
+
+	return cuid
+}
+
 // register will register a handler in the internal tracker. Unsafe (you
 // must lock c.mu yourself!)
 func (c *Caller) register(internal bool, cmd string, handler Handler) (cuid string) {
@@ -410,6 +648,76 @@ func (c *Caller) AddTmp(cmd string, deadline time.Duration, handler func(client
 	return cuid, done
 }
 
+// Expect waits for the first event for which match returns true, or until
+// ctx is done (in which case ctx.Err() is returned). A temporary handler is
+// registered for the duration of the call, and removed before returning.
+// This is useful for flows like sending a query to the server, and waiting
+// for the reply, e.g.:
+//
+//	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+//	defer cancel()
+//
+//	c.Commands.SendRaw("WHOIS nick")
+//	e, err := c.Expect(ctx, func(e girc.Event) bool {
+//		return e.Command == girc.RPL_WHOISUSER && len(e.Params) > 1 && e.Params[1] == "nick"
+//	})
+//
+// Note that match is called for every incoming event (from all goroutines
+// dispatching events), so it should be fast, and must not block.
+func (c *Client) Expect(ctx context.Context, match func(e Event) bool) (Event, error) {
+	result := make(chan Event, 1)
+
+	cuid := c.Handlers.sregisterOwned(ALLEVENTS, HandlerFunc(func(client *Client, event Event) {
+		if !match(event) {
+			return
+		}
+
+		select {
+		case result <- event:
+		default:
+			// Already matched.
+		}
+	}))
+	defer c.Handlers.Remove(cuid)
+
+	select {
+	case event := <-result:
+		return event, nil
+	case <-ctx.Done():
+		return Event{}, ctx.Err()
+	}
+}
+
+// ExpectCommand is like Expect, but waits for the first event with any of the
+// given commands (e.g. RPL_WHOISUSER or ERR_NOSUCHNICK).
+func (c *Client) ExpectCommand(ctx context.Context, commands ...string) (Event, error) {
+	want := make([]string, len(commands))
+	for i := 0; i < len(commands); i++ {
+		want[i] = strings.ToUpper(commands[i])
+	}
+
+	return c.Expect(ctx, func(e Event) bool {
+		for i := 0; i < len(want); i++ {
+			if e.Command == want[i] {
+				return true
+			}
+		}
+
+		return false
+	})
+}
+
+// ExpectNumeric is like ExpectCommand, but with numeric replies supplied as
+// integers, e.g. 311 for RPL_WHOISUSER.
+func (c *Client) ExpectNumeric(ctx context.Context, numerics ...int) (Event, error) {
+	commands := make([]string, len(numerics))
+	for i := 0; i < len(numerics); i++ {
+		commands[i] = fmt.Sprintf("%03d", numerics[i])
+	}
+
+	return c.ExpectCommand(ctx, commands...)
+}
+
 // recoverHandlerPanic is used to catch all handler panics, and re-route
 // them if necessary.
 func recoverHandlerPanic(client *Client, event *Event, id string, skip int) {
@@ -443,12 +751,20 @@ func recoverHandlerPanic(client *Client, event *Event, id string, skip int) {
 // applicable), filename, line in file where panic occurred, the call
 // trace, and original event.
 type HandlerError struct {
-	Event  Event
-	ID     string
-	File   string
-	Line   int
-	Panic  interface{}
-	Stack  []byte
+	// Event is the event which was being handled when the panic occurred.
+	Event Event
+	// ID is the cuid of the handler which panicked (see Caller.Add()), or a
+	// description of the handler type (e.g. "goroutine" or "ctcp-version").
+	ID string
+	// File is the file in which the panic occurred.
+	File string
+	// Line is the line in File at which the panic occurred.
+	Line int
+	// Panic is the value which was passed to panic().
+	Panic interface{}
+	// Stack is the stack trace of the goroutine which panicked.
+	Stack []byte
+	// callOk is true if File and Line could be determined.
 	callOk bool
 }
 
@@ -472,7 +788,11 @@ func (e *HandlerError) String() string {
 // catch-all for panics. This will log the error, and the call trace to the
 // debug log (see Config.Debug), or os.Stdout if Config.Debug is unset.
 func DefaultRecoverHandler(client *Client, err *HandlerError) {
-	if client.Config.Debug == nil {
+	client.confmu.RLock()
+	debug := client.Config.Debug
+	client.confmu.RUnlock()
+
+	if debug == nil {
 		fmt.Println(err.Error())
 		fmt.Println(err.String())
 		return
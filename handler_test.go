@@ -0,0 +1,256 @@
+// Copyright (c) Liam Stanley <me@liamstanley.io>. All rights reserved. Use
+// of this source code is governed by the MIT license that can be found in
+// the LICENSE file.
+
+package girc
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+func TestExpect(t *testing.T) {
+	c, server := mockClient(t, Config{})
+	defer c.Stop()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	go func() {
+		// Wait for Expect to register its handler.
+		for c.Handlers.Count(ALLEVENTS) == 0 {
+			time.Sleep(time.Millisecond)
+		}
+
+		server.send(":irc.example.com 312 nick other irc.example.com :Server info")
+		server.send(":irc.example.com 311 nick other user example.com * :Real Name")
+	}()
+
+	e, err := c.ExpectNumeric(ctx, 311, 401)
+	if err != nil {
+		t.Fatalf("ExpectNumeric() returned error: %s", err)
+	}
+
+	if e.Command != RPL_WHOISUSER || e.Params[1] != "other" {
+		t.Fatalf("ExpectNumeric() returned %q, want RPL_WHOISUSER", e.String())
+	}
+
+	if n := c.Handlers.Count(ALLEVENTS); n != 0 {
+		t.Fatalf("Expect() left %d handlers registered", n)
+	}
+
+	// And a timeout.
+	ctx, cancel = context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	commands := []string{"notacommand"}
+	if _, err = c.ExpectCommand(ctx, commands...); err != context.DeadlineExceeded {
+		t.Fatalf("ExpectCommand() returned %v, want context.DeadlineExceeded", err)
+	}
+
+	if commands[0] != "notacommand" {
+		t.Fatalf("ExpectCommand() modified the given commands: %q", commands)
+	}
+}
+
+func TestRecoverFunc(t *testing.T) {
+	errs := make(chan *HandlerError, 1)
+
+	c, server := mockClient(t, Config{
+		RecoverFunc: func(c *Client, err *HandlerError) { errs <- err },
+	})
+	defer c.Stop()
+
+	c.Handlers.Add(PRIVMSG, func(c *Client, e Event) {
+		panic("handler failure")
+	})
+
+	server.send(":other!user@example.com PRIVMSG nick :hello")
+
+	select {
+	case err := <-errs:
+		if err.Panic != "handler failure" || err.Event.Command != PRIVMSG || len(err.Stack) == 0 {
+			t.Fatalf("RecoverFunc() called with unexpected error: %#v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("RecoverFunc() not called after handler panic")
+	}
+
+	// The client should still be processing events.
+	server.send("PING :still-alive")
+	server.expect("PONG")
+}
+
+type namedHandler struct{}
+
+func (namedHandler) Execute(c *Client, e Event) {}
+
+func listHandler(c *Client, e Event) {}
+
+func TestCallerList(t *testing.T) {
+	c := New(Config{Server: "irc.example.com", Nick: "nick", User: "user"})
+
+	fnCUID := c.Handlers.Add(PRIVMSG, listHandler)
+	typeCUID := c.Handlers.AddHandler(PRIVMSG, namedHandler{})
+
+	var internal int
+	found := map[string]HandlerInfo{}
+
+	for cmd, handlers := range c.Handlers.List() {
+		for _, info := range handlers {
+			if info.Command != cmd {
+				t.Fatalf("List() returned %#v under %q", info, cmd)
+			}
+
+			if info.Internal {
+				if info.Priority != PriorityInternal {
+					t.Fatalf("List() returned priority %d for internal handler %#v", info.Priority, info)
+				}
+
+				internal++
+				continue
+			}
+
+			if info.Priority != PriorityUser {
+				t.Fatalf("List() returned priority %d for user handler %#v", info.Priority, info)
+			}
+
+			found[info.CUID] = info
+		}
+	}
+
+	if internal == 0 {
+		t.Fatal("List() returned no internal handlers")
+	}
+
+	if name := found[fnCUID].Name; !strings.HasSuffix(name, ".listHandler") {
+		t.Fatalf("List() returned name %q for function handler", name)
+	}
+
+	if name := found[typeCUID].Name; name != "girc.namedHandler" {
+		t.Fatalf("List() returned name %q for Handler type", name)
+	}
+
+	// Handlers managed by the client (e.g. waiting for a reply) are kept.
+	owned := c.Handlers.sregisterOwned(ALLEVENTS, namedHandler{})
+
+	c.Handlers.ClearUser()
+	if c.Handlers.Len() != 1 {
+		t.Fatalf("ClearUser() left %d handlers, want 1", c.Handlers.Len())
+	}
+
+	for _, handlers := range c.Handlers.List() {
+		for _, info := range handlers {
+			if !info.Internal && info.CUID != owned {
+				t.Fatalf("List() returned %#v after ClearUser()", info)
+			}
+		}
+	}
+
+	if !c.Handlers.Remove(owned) {
+		t.Fatal("ClearUser() removed a handler managed by the client")
+	}
+}
+
+func TestHandlerPriority(t *testing.T) {
+	c, server := mockClient(t, Config{})
+	defer c.Stop()
+
+	// User handlers are executed once the internal handlers have returned,
+	// so they see the updated state.
+	joined := make(chan bool, 1)
+	c.Handlers.Add(JOIN, func(c *Client, e Event) {
+		joined <- c.IsInChannel("#channel")
+	})
+
+	server.send(":nick!user@host JOIN #channel")
+
+	select {
+	case ok := <-joined:
+		if !ok {
+			t.Fatal("user handler was executed before the state was updated")
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("timed out waiting for the JOIN handler")
+	}
+}
+
+func TestSelfMessages(t *testing.T) {
+	c, server := mockClient(t, Config{SelfMessages: true})
+	defer c.Stop()
+
+	c.state.mu.Lock()
+	c.state.account = "acct"
+	c.state.mu.Unlock()
+
+	type message struct {
+		command, text string
+		params        []string
+		self          bool
+	}
+	received := make(chan message, 10)
+	record := func(c *Client, e Event) {
+		received <- message{command: e.Command, text: e.Trailing, params: e.Params, self: e.IsSelf()}
+	}
+	c.Handlers.Add(PRIVMSG, record)
+	c.Handlers.Add(SELF_MESSAGE, record)
+
+	tests := []struct {
+		line string
+		want message
+	}{
+		{
+			line: ":other!user@host PRIVMSG #channel :theirs",
+			want: message{command: PRIVMSG, text: "theirs", params: []string{"#channel"}},
+		},
+		{
+			line: ":nick!user@host PRIVMSG #channel :mine",
+			want: message{command: SELF_MESSAGE, text: "mine", params: []string{"#channel", PRIVMSG}, self: true},
+		},
+		{
+			line: "@account=acct :othernick!user@host PRIVMSG other :from another session",
+			want: message{command: SELF_MESSAGE, text: "from another session", params: []string{"other", PRIVMSG}, self: true},
+		},
+		{
+			line: "@account=someone :another!user@host PRIVMSG #channel :not ours",
+			want: message{command: PRIVMSG, text: "not ours", params: []string{"#channel"}},
+		},
+	}
+
+	for _, tt := range tests {
+		server.send(tt.line)
+
+		select {
+		case got := <-received:
+			if got.command != tt.want.command || got.text != tt.want.text || got.self != tt.want.self ||
+				strings.Join(got.params, " ") != strings.Join(tt.want.params, " ") {
+				t.Errorf("%q was dispatched as %+v, want %+v", tt.line, got, tt.want)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatalf("%q was never dispatched", tt.line)
+		}
+	}
+}
+
+func TestIsSelfCasemapping(t *testing.T) {
+	c := New(Config{Server: "irc.example.com", Nick: "a[b]", User: "user"})
+
+	if !c.isSelf(&Source{Name: "A{B}"}) {
+		t.Error("isSelf(A{B}) = false, want true with rfc1459")
+	}
+
+	c.state.mu.Lock()
+	c.state.isupport.Casemapping = CaseMappingASCII
+	c.state.mu.Unlock()
+
+	if c.isSelf(&Source{Name: "a{b}"}) {
+		t.Error("isSelf(a{b}) = true, want false with ascii")
+	}
+
+	if !c.isSelf(&Source{Name: "A[B]"}) {
+		t.Error("isSelf(A[B]) = false, want true with ascii")
+	}
+}
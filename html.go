@@ -0,0 +1,494 @@
+// Copyright (c) Liam Stanley <me@liamstanley.io>. All rights reserved. Use
+// of this source code is governed by the MIT license that can be found in
+// the LICENSE file.
+
+package girc
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// htmlColor returns the CSS color of c.
+func htmlColor(c Color) string {
+	r, g, b, _ := c.RGB()
+	return fmt.Sprintf("#%02x%02x%02x", r, g, b)
+}
+
+// htmlStyle returns the CSS style which applies state, or an empty string
+// if state has no formatting.
+func htmlStyle(state fmtState) string {
+	var style []string
+
+	if state.bold {
+		style = append(style, "font-weight:bold")
+	}
+	if state.italic {
+		style = append(style, "font-style:italic")
+	}
+
+	switch {
+	case state.underline && state.strikethrough:
+		style = append(style, "text-decoration:underline line-through")
+	case state.underline:
+		style = append(style, "text-decoration:underline")
+	case state.strikethrough:
+		style = append(style, "text-decoration:line-through")
+	}
+
+	if state.monospace {
+		style = append(style, "font-family:monospace")
+	}
+
+	fg, bg := state.fg, state.bg
+	if state.reverse {
+		fg, bg = bg, fg
+	}
+	if fg != ColorDefault {
+		style = append(style, "color:"+htmlColor(fg))
+	}
+	if bg != ColorDefault {
+		style = append(style, "background-color:"+htmlColor(bg))
+	}
+
+	return strings.Join(style, ";")
+}
+
+// urlPrefixes are the prefixes of the URLs which are linked by FmtToHTML.
+var urlPrefixes = []string{"https://", "http://", "www."}
+
+// findURL returns the start and end of the first URL in text, or -1 if
+// there's none.
+func findURL(text string) (start, end int) {
+	lower := strings.ToLower(text)
+
+	for offset := 0; offset < len(lower); {
+		start = -1
+		var prefix string
+		for _, p := range urlPrefixes {
+			if i := strings.Index(lower[offset:], p); i > -1 && (start < 0 || offset+i < start) {
+				start, prefix = offset+i, p
+			}
+		}
+
+		if start < 0 {
+			return -1, -1
+		}
+
+		end = start
+		for end < len(text) && !strings.ContainsRune(" \t\r\n<>\"", rune(text[end])) {
+			end++
+		}
+
+		// Trailing punctuation is most likely part of the sentence, as is a
+		// closing parenthesis without an opening one.
+		for end > start {
+			last := text[end-1]
+			if strings.IndexByte(".,;:!?'", last) > -1 || (last == 0x29 && // )
+				strings.Count(text[start:end], "(") < strings.Count(text[start:end], ")")) {
+				end--
+				continue
+			}
+			break
+		}
+
+		// URLs must not be part of a word, and must have more than a prefix.
+		if (start == 0 || !isWordByte(text[start-1])) && end > start+len(prefix) {
+			return start, end
+		}
+
+		offset = start + len(prefix)
+	}
+
+	return -1, -1
+}
+
+// isWordByte returns true if b is an ASCII letter or digit.
+func isWordByte(b byte) bool {
+	return isFmtDigit(b) || (b >= 0x41 && b <= 0x5A) || (b >= 0x61 && b <= 0x7A) // A-Z, a-z
+}
+
+// writeHTMLText writes text, escaped, with links for any URLs.
+func writeHTMLText(out *bytes.Buffer, text string) {
+	for {
+		start, end := findURL(text)
+		if start < 0 {
+			break
+		}
+
+		out.WriteString(html.EscapeString(text[:start]))
+
+		url := text[start:end]
+		href := url
+		if strings.HasPrefix(strings.ToLower(url), "www.") {
+			href = "http://" + url
+		}
+
+		out.WriteString(`<a href="` + html.EscapeString(href) + `" rel="nofollow noopener">`)
+		out.WriteString(html.EscapeString(url))
+		out.WriteString("</a>")
+
+		text = text[end:]
+	}
+
+	out.WriteString(html.EscapeString(text))
+}
+
+// FmtToHTML converts the formatting codes of text (e.g. of an incoming
+// message) to HTML, as spans with inline styles, and links any URLs. All
+// text is escaped, so the result is safe to embed in a page, e.g. by a
+// bridge relaying messages to web or Matrix clients. Colors use the mIRC
+// palette (see Color.RGB). See HTMLToFmt for the inverse.
+func FmtToHTML(text string) string {
+	var out bytes.Buffer
+
+	walkFmt(text, func(state fmtState, run string) {
+		style := htmlStyle(state)
+		if style == "" {
+			writeHTMLText(&out, run)
+			return
+		}
+
+		out.WriteString(`<span style="` + style + `">`)
+		writeHTMLText(&out, run)
+		out.WriteString("</span>")
+	})
+
+	return out.String()
+}
+
+// htmlColorNames are the CSS color names supported by HTMLToFmt.
+var htmlColorNames = map[string]Color{
+	"white": ColorWhite, "black": ColorBlack, "navy": ColorBlue,
+	"blue": ColorLightBlue, "green": ColorGreen, "red": ColorRed,
+	"maroon": ColorBrown, "brown": ColorBrown, "purple": ColorPurple,
+	"orange": ColorOrange, "olive": ColorOrange, "yellow": ColorYellow,
+	"lime": ColorLightGreen, "teal": ColorTeal, "cyan": ColorCyan,
+	"aqua": ColorCyan, "fuchsia": ColorPink, "magenta": ColorPink,
+	"pink": ColorPink, "grey": ColorGrey, "gray": ColorGrey,
+	"silver": ColorLightGrey, "lightgrey": ColorLightGrey, "lightgray": ColorLightGrey,
+}
+
+// parseHTMLColor returns the nearest color to a CSS color, which is either
+// a hex color ("#f00" or "#ff0000") or one of htmlColorNames.
+func parseHTMLColor(value string) (Color, bool) {
+	value = strings.ToLower(strings.TrimSpace(value))
+
+	if c, ok := htmlColorNames[value]; ok {
+		return c, true
+	}
+
+	if !strings.HasPrefix(value, "#") {
+		return 0, false
+	}
+
+	hex := value[1:]
+	if len(hex) == 3 {
+		hex = string([]byte{hex[0], hex[0], hex[1], hex[1], hex[2], hex[2]})
+	}
+
+	rgb, err := strconv.ParseUint(hex, 16, 32)
+	if err != nil || len(hex) != 6 {
+		return 0, false
+	}
+
+	return nearestColor(uint8(rgb>>16), uint8(rgb>>8), uint8(rgb), ColorDefault), true
+}
+
+// applyHTMLStyle applies the supported properties of a CSS style attribute
+// to state.
+func applyHTMLStyle(state fmtState, style string) fmtState {
+	for _, decl := range strings.Split(style, ";") {
+		i := strings.IndexByte(decl, 0x3A) // :
+		if i < 0 {
+			continue
+		}
+
+		prop := strings.ToLower(strings.TrimSpace(decl[:i]))
+		value := strings.ToLower(strings.TrimSpace(decl[i+1:]))
+
+		switch prop {
+		case "font-weight":
+			weight, _ := strconv.Atoi(value)
+			state.bold = value == "bold" || value == "bolder" || weight >= 600
+		case "font-style":
+			state.italic = value == "italic" || value == "oblique"
+		case "text-decoration", "text-decoration-line":
+			state.underline = strings.Contains(value, "underline")
+			state.strikethrough = strings.Contains(value, "line-through")
+		case "font-family":
+			state.monospace = strings.Contains(value, "monospace")
+		case "color":
+			if c, ok := parseHTMLColor(value); ok {
+				state.fg = c
+			}
+		case "background-color", "background":
+			if c, ok := parseHTMLColor(value); ok {
+				state.bg = c
+			}
+		}
+	}
+
+	return state
+}
+
+// htmlBlocks are the elements which are on their own lines.
+var htmlBlocks = map[string]bool{
+	"p": true, "div": true, "pre": true, "blockquote": true, "li": true,
+	"ul": true, "ol": true, "h1": true, "h2": true, "h3": true, "h4": true,
+	"h5": true, "h6": true, "tr": true, "table": true, "hr": true,
+}
+
+// htmlSkipped are the elements whose content is dropped, including the
+// reply fallback of Matrix messages.
+var htmlSkipped = map[string]bool{
+	"script": true, "style": true, "head": true, "title": true, "mx-reply": true,
+}
+
+// htmlVoid are the elements which never have an end tag.
+var htmlVoid = map[string]bool{
+	"br": true, "hr": true, "img": true, "input": true, "meta": true, "link": true, "wbr": true,
+}
+
+// htmlElement is an open element, as tracked by HTMLToFmt.
+type htmlElement struct {
+	tag   string
+	state fmtState
+	// href is the link of an "a" element, and text its text so far.
+	href string
+	text bytes.Buffer
+}
+
+// applyHTMLElement returns the formatting of the content of an element,
+// with the given tag and attributes, within state.
+func applyHTMLElement(state fmtState, tag string, attrs map[string]string) fmtState {
+	switch tag {
+	case "b", "strong":
+		state.bold = true
+	case "i", "em", "cite":
+		state.italic = true
+	case "u", "ins":
+		state.underline = true
+	case "s", "strike", "del":
+		state.strikethrough = true
+	case "code", "tt", "pre", "kbd", "samp":
+		state.monospace = true
+	case "h1", "h2", "h3", "h4", "h5", "h6", "th":
+		state.bold = true
+	}
+
+	// Matrix uses the data-mx-* attributes, as it doesn't allow styles.
+	for _, attr := range []string{"color", "data-mx-color"} {
+		if c, ok := parseHTMLColor(attrs[attr]); ok {
+			state.fg = c
+		}
+	}
+	if c, ok := parseHTMLColor(attrs["data-mx-bg-color"]); ok {
+		state.bg = c
+	}
+
+	if style, ok := attrs["style"]; ok {
+		state = applyHTMLStyle(state, style)
+	}
+
+	return state
+}
+
+// HTMLToFmt converts HTML (e.g. of a message from a web or Matrix client) to
+// text with formatting codes, so it can be sent to IRC. Bold, italic,
+// underline, strikethrough, monospace (code) and colors are converted, from
+// elements (like <b>), inline styles, and the data-mx-color attributes used
+// by Matrix. Links whose text isn't the URL are written as "text (URL)".
+// Line breaks and blocks (like paragraphs) are converted to newlines, so
+// the result may need to be split into several messages. Other elements are
+// dropped, keeping their text, except scripts, styles and Matrix reply
+// fallbacks (<mx-reply>), which are dropped entirely. Colors are
+// approximated by the nearest color. See FmtToHTML for the inverse.
+func HTMLToFmt(text string) string {
+	var out bytes.Buffer
+	last := defaultFmtState
+	stack := []*htmlElement{{state: defaultFmtState}}
+	skip := 0
+	pre := 0
+
+	// write writes text with the formatting of the innermost element.
+	write := func(text string) {
+		top := stack[len(stack)-1]
+		out.WriteString(fmtTransition(last, top.state))
+		last = top.state
+		out.WriteString(text)
+
+		for _, el := range stack {
+			if el.tag == "a" {
+				el.text.WriteString(text)
+			}
+		}
+	}
+
+	// newline ends the current line, if any.
+	newline := func() {
+		if bytes.HasSuffix(out.Bytes(), []byte(" ")) {
+			out.Truncate(out.Len() - 1)
+		}
+
+		if out.Len() > 0 && !bytes.HasSuffix(out.Bytes(), []byte("\n")) {
+			if last != defaultFmtState {
+				out.WriteByte(fmtReset)
+				last = defaultFmtState
+			}
+			out.WriteByte(0x0A) // \n
+		}
+	}
+
+	tokens := html.NewTokenizer(strings.NewReader(text))
+	for {
+		tt := tokens.Next()
+		if tt == html.ErrorToken {
+			// Either the end of the text, or invalid HTML.
+			break
+		}
+
+		token := tokens.Token()
+		tag := token.Data
+
+		switch tt {
+		case html.TextToken:
+			if skip > 0 {
+				continue
+			}
+
+			content := token.Data
+			if pre == 0 {
+				content = collapseSpace(content)
+
+				// Don't start lines with whitespace, nor repeat it.
+				if out.Len() == 0 || bytes.HasSuffix(out.Bytes(), []byte("\n")) || bytes.HasSuffix(out.Bytes(), []byte(" ")) {
+					content = strings.TrimLeft(content, " ")
+				}
+			}
+
+			if content != "" {
+				write(content)
+			}
+		case html.StartTagToken, html.SelfClosingTagToken:
+			if htmlSkipped[tag] {
+				if tt == html.StartTagToken {
+					skip++
+				}
+				continue
+			}
+			if skip > 0 {
+				continue
+			}
+
+			if tag == "br" {
+				newline()
+				continue
+			}
+
+			if htmlBlocks[tag] {
+				newline()
+			}
+			if tag == "li" {
+				write("- ")
+			}
+
+			if htmlVoid[tag] || tt == html.SelfClosingTagToken {
+				continue
+			}
+
+			attrs := make(map[string]string)
+			for _, attr := range token.Attr {
+				attrs[attr.Key] = attr.Val
+			}
+
+			el := &htmlElement{tag: tag, state: applyHTMLElement(stack[len(stack)-1].state, tag, attrs)}
+			if tag == "a" {
+				el.href = attrs["href"]
+			}
+			if tag == "pre" {
+				pre++
+			}
+
+			stack = append(stack, el)
+		case html.EndTagToken:
+			if htmlSkipped[tag] {
+				if skip > 0 {
+					skip--
+				}
+				continue
+			}
+			if skip > 0 {
+				continue
+			}
+
+			// Close the innermost element with the tag, and any elements
+			// (which were left open) within it.
+			for i := len(stack) - 1; i > 0; i-- {
+				if stack[i].tag != tag {
+					continue
+				}
+
+				el := stack[i]
+				stack = stack[:i]
+
+				if tag == "pre" && pre > 0 {
+					pre--
+				}
+
+				if tag == "a" {
+					href := el.href
+					if strings.HasPrefix(href, "mailto:") {
+						href = href[len("mailto:"):]
+					}
+
+					if href != "" && strings.TrimSpace(el.text.String()) != href && !strings.HasPrefix(href, "#") {
+						write(" (" + href + ")")
+					}
+				}
+				break
+			}
+
+			if htmlBlocks[tag] {
+				newline()
+			}
+		}
+	}
+
+	result := strings.TrimRight(out.String(), "\n ")
+	if last != defaultFmtState && !strings.HasSuffix(result, string(fmtReset)) {
+		result += string(fmtReset)
+	}
+
+	return result
+}
+
+// collapseSpace replaces each run of whitespace in text with a single
+// space, as browsers do.
+func collapseSpace(text string) string {
+	var out bytes.Buffer
+	space := false
+
+	for i := 0; i < len(text); i++ {
+		switch text[i] {
+		case 0x20, 0x09, 0x0A, 0x0D, 0x0C: // space, \t, \n, \r, \f
+			space = true
+		default:
+			if space {
+				out.WriteByte(0x20)
+				space = false
+			}
+			out.WriteByte(text[i])
+		}
+	}
+
+	if space {
+		out.WriteByte(0x20)
+	}
+
+	return out.String()
+}
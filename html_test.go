@@ -0,0 +1,109 @@
+// Copyright (c) Liam Stanley <me@liamstanley.io>. All rights reserved. Use
+// of this source code is governed by the MIT license that can be found in
+// the LICENSE file.
+
+package girc
+
+import "testing"
+
+func TestFmtToHTML(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		want string
+	}{
+		{name: "plain", text: "this is a test.", want: "this is a test."},
+		{name: "escape", text: "<script>alert(\"x\")</script> & co", want: "&lt;script&gt;alert(&#34;x&#34;)&lt;/script&gt; &amp; co"},
+		{name: "bold", text: "\x02bold\x02 text", want: `<span style="font-weight:bold">bold</span> text`},
+		{
+			name: "mixed", text: "\x1d\x1f\x1eall\x0f",
+			want: `<span style="font-style:italic;text-decoration:underline line-through">all</span>`,
+		},
+		{name: "color", text: "\x0304,01red\x03 x", want: `<span style="color:#ff0000;background-color:#000000">red</span> x`},
+		{name: "reverse", text: "\x0304\x16rev", want: `<span style="background-color:#ff0000">rev</span>`},
+		{name: "mono", text: "\x11code", want: `<span style="font-family:monospace">code</span>`},
+		{
+			name: "url", text: "see https://example.com/a?b=1&c=2.",
+			want: `see <a href="https://example.com/a?b=1&amp;c=2" rel="nofollow noopener">https://example.com/a?b=1&amp;c=2</a>.`,
+		},
+		{
+			name: "www", text: "(www.example.com)",
+			want: `(<a href="http://www.example.com" rel="nofollow noopener">www.example.com</a>)`,
+		},
+		{
+			name: "parens", text: "https://en.wikipedia.org/wiki/Go_(language)",
+			want: `<a href="https://en.wikipedia.org/wiki/Go_(language)" rel="nofollow noopener">https://en.wikipedia.org/wiki/Go_(language)</a>`,
+		},
+		{
+			name: "quote", text: `https://x.org/"onmouseover="alert(1)`,
+			want: `<a href="https://x.org/" rel="nofollow noopener">https://x.org/</a>&#34;onmouseover=&#34;alert(1)`,
+		},
+		{name: "not url", text: "http:// awwww.x", want: "http:// awwww.x"},
+		{
+			name: "formatted url", text: "\x02http://x.org\x02",
+			want: `<span style="font-weight:bold"><a href="http://x.org" rel="nofollow noopener">http://x.org</a></span>`,
+		},
+	}
+
+	for _, tt := range tests {
+		if got := FmtToHTML(tt.text); got != tt.want {
+			t.Errorf("%s: FmtToHTML(%q) = %q, want %q", tt.name, tt.text, got, tt.want)
+		}
+	}
+}
+
+func TestHTMLToFmt(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		want string
+	}{
+		{name: "plain", text: "this is a test.", want: "this is a test."},
+		{name: "entities", text: "a &amp; b &lt;3", want: "a & b <3"},
+		{name: "bold", text: "<b>bold</b> <strong>text</strong>", want: "\x02bold\x0f \x02text\x0f"},
+		{name: "nested", text: "<b>a<i>b</i>c</b>d", want: "\x02a\x1db\x1dc\x0fd"},
+		{name: "unclosed", text: "<b>a<i>b</b>c", want: "\x02a\x1db\x0fc"},
+		{name: "code", text: "run <code>make</code>", want: "run \x11make\x0f"},
+		{name: "strike", text: "<del>no</del>", want: "\x1eno\x0f"},
+		{name: "style", text: `<span style="color: #f00; font-weight: 700">x</span>y`, want: "\x02\x0304,99x\x0fy"},
+		{name: "matrix color", text: `<font data-mx-color="#00ff00" data-mx-bg-color="#000000">x</font>`, want: "\x0356,01x\x0f"},
+		{name: "font color", text: `<font color="red">x</font>`, want: "\x0304,99x\x0f"},
+		{name: "link", text: `<a href="https://example.com">example</a>!`, want: "example (https://example.com)!"},
+		{name: "bare link", text: `<a href="https://example.com">https://example.com</a>`, want: "https://example.com"},
+		{name: "mailto", text: `<a href="mailto:a@example.com">a@example.com</a>`, want: "a@example.com"},
+		{name: "br", text: "line 1<br>line 2<br/>", want: "line 1\nline 2"},
+		{name: "blocks", text: "<p>one</p><p>two <b>2</b></p>", want: "one\ntwo \x022\x0f"},
+		{name: "list", text: "<ul><li>a</li><li>b</li></ul>", want: "- a\n- b"},
+		{name: "whitespace", text: "  a \n\t b  <b> c </b> ", want: "a b \x02c\x0f"},
+		{name: "pre", text: "<pre>a\n  b</pre>", want: "\x11a\n  b\x0f"},
+		{name: "skipped", text: "<mx-reply><blockquote>quoted</blockquote></mx-reply>reply<script>x()</script>", want: "reply"},
+	}
+
+	for _, tt := range tests {
+		if got := HTMLToFmt(tt.text); got != tt.want {
+			t.Errorf("%s: HTMLToFmt(%q) = %q, want %q", tt.name, tt.text, got, tt.want)
+		}
+	}
+}
+
+func TestHTMLRoundTrip(t *testing.T) {
+	text := Fmt("{b}{red,black}alert{r}: {u}disk{u} {mono}full{r} see http://x.org")
+	got := HTMLToFmt(FmtToHTML(text))
+
+	if TrimFmt(got) != TrimFmt(text) {
+		t.Fatalf("round trip changed the text: %q", got)
+	}
+
+	var want, have []fmtState
+	walkFmt(text, func(state fmtState, run string) { want = append(want, state) })
+	walkFmt(got, func(state fmtState, run string) { have = append(have, state) })
+
+	if len(want) != len(have) {
+		t.Fatalf("round trip = %q, want runs like %q", got, text)
+	}
+	for i := range want {
+		if want[i] != have[i] {
+			t.Errorf("run %d: state = %+v, want %+v", i, have[i], want[i])
+		}
+	}
+}
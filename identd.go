@@ -0,0 +1,190 @@
+// Copyright (c) Liam Stanley <me@liamstanley.io>. All rights reserved. Use
+// of this source code is governed by the MIT license that can be found in
+// the LICENSE file.
+
+package girc
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// defaultIdentdAddr is the address the identd listens on, if Identd.Addr
+	// isn't set.
+	defaultIdentdAddr = ":113"
+	// identdTimeout is how long an ident lookup has to send its query.
+	identdTimeout = 10 * time.Second
+)
+
+// Identd configures the built-in ident (RFC 1413) responder, which answers
+// the ident lookup the server makes while we're connecting. Some networks
+// refuse (or restrict) clients without ident. See Config.Identd.
+type Identd struct {
+	// Addr is the address (e.g. "0.0.0.0:113", or ":113" for every
+	// interface) to listen on. Ident lookups are always made on port 113,
+	// which generally requires privileges to listen on, so it can also be
+	// forwarded to another port. Defaults to ":113".
+	Addr string
+	// Ident is the username to answer lookups with. Defaults to
+	// Config.User.
+	Ident string
+	// System is the operating system to answer lookups with. Defaults to
+	// "UNIX".
+	System string
+}
+
+// identd is a running ident responder, which answers lookups for the
+// connection it's told about with expect.
+type identd struct {
+	listener net.Listener
+	ident    string
+	system   string
+
+	mu sync.Mutex
+	// local and remote are the ports of our connection to the server, or 0
+	// if not yet (or not) known.
+	local, remote int
+}
+
+// newIdentd starts listening for ident lookups, answering them with ident.
+func newIdentd(conf *Identd, ident string) (*identd, error) {
+	addr := conf.Addr
+	if addr == "" {
+		addr = defaultIdentdAddr
+	}
+
+	if conf.Ident != "" {
+		ident = conf.Ident
+	}
+
+	system := conf.System
+	if system == "" {
+		system = "UNIX"
+	}
+
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	d := &identd{listener: listener, ident: ident, system: system}
+	go d.serve()
+
+	return d, nil
+}
+
+// expect sets the connection to the server which lookups are answered for.
+// Until it's known, every lookup is answered.
+func (d *identd) expect(conn net.Conn) {
+	local, ok := conn.LocalAddr().(*net.TCPAddr)
+	if !ok {
+		return
+	}
+
+	remote, ok := conn.RemoteAddr().(*net.TCPAddr)
+	if !ok {
+		return
+	}
+
+	d.mu.Lock()
+	d.local, d.remote = local.Port, remote.Port
+	d.mu.Unlock()
+}
+
+// serve accepts lookups until the listener is closed.
+func (d *identd) serve() {
+	for {
+		conn, err := d.listener.Accept()
+		if err != nil {
+			return
+		}
+
+		go d.answer(conn)
+	}
+}
+
+// answer replies to a single lookup, which is a "<local port> , <remote
+// port>" query, from our point of view.
+func (d *identd) answer(conn net.Conn) {
+	defer conn.Close()
+
+	conn.SetDeadline(time.Now().Add(identdTimeout))
+
+	line, err := bufio.NewReader(conn).ReadString(0x0A) // \n
+	if err != nil {
+		return
+	}
+
+	parts := strings.Split(strings.TrimSpace(line), ",")
+	if len(parts) != 2 {
+		return
+	}
+
+	local, lerr := strconv.Atoi(strings.TrimSpace(parts[0]))
+	remote, rerr := strconv.Atoi(strings.TrimSpace(parts[1]))
+	if lerr != nil || rerr != nil || local < 1 || local > 65535 || remote < 1 || remote > 65535 {
+		fmt.Fprintf(conn, "%s , %s : ERROR : INVALID-PORT\r\n", strings.TrimSpace(parts[0]), strings.TrimSpace(parts[1]))
+		return
+	}
+
+	d.mu.Lock()
+	known := d.local != 0
+	ours := d.local == local && d.remote == remote
+	d.mu.Unlock()
+
+	if known && !ours {
+		fmt.Fprintf(conn, "%d , %d : ERROR : NO-USER\r\n", local, remote)
+		return
+	}
+
+	fmt.Fprintf(conn, "%d , %d : USERID : %s : %s\r\n", local, remote, d.system, d.ident)
+}
+
+// close stops listening for lookups.
+func (d *identd) close() error {
+	return d.listener.Close()
+}
+
+// startIdentd starts the identd, if enabled with Config.Identd. c.cmux
+// must be held.
+func (c *Client) startIdentd() error {
+	c.stopIdentd()
+
+	if c.Config.Identd == nil {
+		return nil
+	}
+
+	d, err := newIdentd(c.Config.Identd, c.Config.User)
+	if err != nil {
+		return fmt.Errorf("unable to start identd: %s", err)
+	}
+
+	c.debug.Printf("identd listening on %s", d.listener.Addr())
+	c.identd = d
+
+	return nil
+}
+
+// stopIdentd stops the identd, if it's running. c.cmux must be held.
+func (c *Client) stopIdentd() {
+	if c.identd == nil {
+		return
+	}
+
+	c.identd.close()
+	c.identd = nil
+}
+
+// handleIdentdRegistered stops the identd once we've registered, as the
+// server won't look up our ident again.
+func handleIdentdRegistered(c *Client, e Event) {
+	c.cmux.Lock()
+	c.stopIdentd()
+	c.cmux.Unlock()
+}
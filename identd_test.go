@@ -0,0 +1,118 @@
+// Copyright (c) Liam Stanley <me@liamstanley.io>. All rights reserved. Use
+// of this source code is governed by the MIT license that can be found in
+// the LICENSE file.
+
+package girc
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// identQuery makes an ident lookup to addr, returning the reply.
+func identQuery(t *testing.T, addr, query string) (string, error) {
+	t.Helper()
+
+	conn, err := net.DialTimeout("tcp", addr, time.Second)
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+
+	conn.SetDeadline(time.Now().Add(2 * time.Second))
+	fmt.Fprintf(conn, "%s\r\n", query)
+
+	reply, err := bufio.NewReader(conn).ReadString(0x0A) // \n
+	return strings.TrimRight(reply, "\r\n"), err
+}
+
+func TestIdentdAnswer(t *testing.T) {
+	d, err := newIdentd(&Identd{Addr: "127.0.0.1:0"}, "user")
+	if err != nil {
+		t.Fatalf("unable to start identd: %s", err)
+	}
+	defer d.close()
+
+	addr := d.listener.Addr().String()
+
+	tests := []struct {
+		query string
+		want  string
+	}{
+		{query: "6193, 23", want: "6193 , 23 : USERID : UNIX : user"},
+		{query: "6195 ,6667", want: "6195 , 6667 : USERID : UNIX : user"},
+		{query: "0, 6667", want: "0 , 6667 : ERROR : INVALID-PORT"},
+		{query: "abc, 6667", want: "abc , 6667 : ERROR : INVALID-PORT"},
+	}
+
+	for _, tt := range tests {
+		got, err := identQuery(t, addr, tt.query)
+		if err != nil {
+			t.Fatalf("lookup %q failed: %s", tt.query, err)
+		}
+
+		if got != tt.want {
+			t.Errorf("lookup %q = %q, want %q", tt.query, got, tt.want)
+		}
+	}
+
+	// Once the connection is known, only lookups for it are answered.
+	d.mu.Lock()
+	d.local, d.remote = 6193, 6667
+	d.mu.Unlock()
+	for query, want := range map[string]string{
+		"6193, 6667": "6193 , 6667 : USERID : UNIX : user",
+		"6194, 6667": "6194 , 6667 : ERROR : NO-USER",
+	} {
+		got, err := identQuery(t, addr, query)
+		if err != nil {
+			t.Fatalf("lookup %q failed: %s", query, err)
+		}
+
+		if got != want {
+			t.Errorf("lookup %q = %q, want %q", query, got, want)
+		}
+	}
+}
+
+func TestIdentdLifetime(t *testing.T) {
+	c, server := mockClient(t, Config{Identd: &Identd{Addr: "127.0.0.1:0", Ident: "ident"}})
+	defer c.Stop()
+
+	c.cmux.Lock()
+	if c.identd == nil {
+		c.cmux.Unlock()
+		t.Fatal("identd wasn't started when connecting")
+	}
+	addr := c.identd.listener.Addr().String()
+	c.cmux.Unlock()
+
+	got, err := identQuery(t, addr, "6193, 6667")
+	if err != nil {
+		t.Fatalf("lookup failed: %s", err)
+	}
+
+	if want := "6193 , 6667 : USERID : UNIX : ident"; got != want {
+		t.Errorf("lookup = %q, want %q", got, want)
+	}
+
+	server.send(":irc.example.com 001 nick :Welcome")
+	server.send("PING :sync")
+	server.expect("PONG sync")
+
+	c.cmux.Lock()
+	running := c.identd != nil
+	c.cmux.Unlock()
+
+	if running {
+		t.Error("identd wasn't stopped once registered")
+	}
+
+	if _, err := identQuery(t, addr, "6193, 6667"); err == nil {
+		t.Error("identd still answers lookups once registered")
+	}
+}
@@ -0,0 +1,214 @@
+// Copyright (c) Liam Stanley <me@liamstanley.io>. All rights reserved. Use
+// of this source code is governed by the MIT license that can be found in
+// the LICENSE file.
+
+package girc
+
+import (
+	"bufio"
+	"io"
+	"strings"
+	"sync"
+)
+
+// defaultIgnoreCommands are the commands which are ignored by default. CTCP
+// queries and replies are sent using PRIVMSG and NOTICE, so are included.
+var defaultIgnoreCommands = []string{PRIVMSG, NOTICE}
+
+// Ignores manages a list of masks (e.g. "*!*@spam.host" or "nick!*@*"), which
+// incoming events are ignored from. Events from an ignored source still go
+// through the internal handlers (so state tracking isn't affected), however
+// user handlers and CTCP handlers are not executed. Only events with one of
+// the ignored commands (see SetCommands) are ignored, which defaults to
+// PRIVMSG and NOTICE (and therefore CTCP).
+//
+// Ignores implements io.WriterTo and io.ReaderFrom, so the list can easily
+// be saved and loaded. Alternatively, see List and Add.
+type Ignores struct {
+	mu sync.RWMutex
+	// masks are the masks being ignored, as supplied.
+	masks []string
+	// commands are the commands which are ignored.
+	commands map[string]struct{}
+	// casemapping returns the casemapping masks are compared with, if set
+	// (see ToLower).
+	casemapping func() string
+}
+
+// newIgnores returns a new, empty, ignore list, comparing masks with the
+// casemapping returned by casemapping (if not nil).
+func newIgnores(casemapping func() string) *Ignores {
+	i := &Ignores{casemapping: casemapping}
+	i.SetCommands(defaultIgnoreCommands...)
+
+	return i
+}
+
+// Add adds the given masks to the ignore list. Masks are of the form
+// "nick!ident@host", and may contain the "*" glob character. Masks without
+// an ident and host (e.g. "nick") only match the nickname. Masks are
+// compared case-insensitively, using the casemapping of the server.
+func (i *Ignores) Add(masks ...string) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	for _, mask := range masks {
+		mask = strings.TrimSpace(mask)
+		if mask == "" || i.index(mask) > -1 {
+			continue
+		}
+
+		i.masks = append(i.masks, mask)
+	}
+}
+
+// Remove removes the given mask from the ignore list, returning true if it
+// was in the list.
+func (i *Ignores) Remove(mask string) bool {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	index := i.index(mask)
+	if index < 0 {
+		return false
+	}
+
+	i.masks = append(i.masks[:index], i.masks[index+1:]...)
+	return true
+}
+
+// index returns the index of the mask in the list, or -1. Not concurrency
+// safe.
+func (i *Ignores) index(mask string) int {
+	casemapping := i.mapping()
+	for j := 0; j < len(i.masks); j++ {
+		if ToLower(casemapping, i.masks[j]) == ToLower(casemapping, mask) {
+			return j
+		}
+	}
+
+	return -1
+}
+
+// Clear removes all masks from the ignore list.
+func (i *Ignores) Clear() {
+	i.mu.Lock()
+	i.masks = nil
+	i.mu.Unlock()
+}
+
+// List returns the masks which are being ignored.
+func (i *Ignores) List() []string {
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+
+	return append([]string(nil), i.masks...)
+}
+
+// SetCommands sets which commands (e.g. PRIVMSG, NOTICE, INVITE) are
+// ignored from sources matching the ignore list.
+func (i *Ignores) SetCommands(commands ...string) {
+	i.mu.Lock()
+	i.commands = make(map[string]struct{}, len(commands))
+	for j := 0; j < len(commands); j++ {
+		i.commands[strings.ToUpper(commands[j])] = struct{}{}
+	}
+	i.mu.Unlock()
+}
+
+// Match returns true if the source matches any of the masks in the ignore
+// list.
+func (i *Ignores) Match(src *Source) bool {
+	if src == nil {
+		return false
+	}
+
+	casemapping := i.mapping()
+
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+
+	for j := 0; j < len(i.masks); j++ {
+		if matchMask(casemapping, src, i.masks[j]) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// ignored returns true if the event should be ignored.
+func (i *Ignores) ignored(e *Event) bool {
+	if e.Source == nil {
+		return false
+	}
+
+	i.mu.RLock()
+	_, ok := i.commands[e.Command]
+	i.mu.RUnlock()
+
+	return ok && i.Match(e.Source)
+}
+
+// mapping returns the casemapping masks are compared with.
+func (i *Ignores) mapping() string {
+	if i.casemapping == nil {
+		return ""
+	}
+
+	return i.casemapping()
+}
+
+// matchMask returns true if the source matches a (potentially globbed)
+// "nick!ident@host" mask, compared using casemapping (see ToLower).
+func matchMask(casemapping string, src *Source, mask string) bool {
+	mask = ToLower(casemapping, mask)
+
+	if strings.IndexByte(mask, prefixIdent) < 0 && strings.IndexByte(mask, prefixHost) < 0 {
+		return Glob(ToLower(casemapping, src.Name), mask)
+	}
+
+	full := ToLower(casemapping, src.Name+string(prefixIdent)+src.Ident+string(prefixHost)+src.Host)
+
+	return Glob(full, mask)
+}
+
+// WriteTo writes the ignore list to w, one mask per line.
+func (i *Ignores) WriteTo(w io.Writer) (n int64, err error) {
+	var written int
+
+	for _, mask := range i.List() {
+		written, err = io.WriteString(w, mask+"\n")
+		n += int64(written)
+		if err != nil {
+			return n, err
+		}
+	}
+
+	return n, nil
+}
+
+// ReadFrom reads masks (one per line) from r, adding them to the ignore
+// list. Empty lines, and lines starting with "#", are skipped.
+func (i *Ignores) ReadFrom(r io.Reader) (n int64, err error) {
+	var masks []string
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		n += int64(len(scanner.Bytes())) + 1
+
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		masks = append(masks, line)
+	}
+
+	if err = scanner.Err(); err != nil {
+		return n, err
+	}
+
+	i.Add(masks...)
+	return n, nil
+}
@@ -0,0 +1,112 @@
+// Copyright (c) Liam Stanley <me@liamstanley.io>. All rights reserved. Use
+// of this source code is governed by the MIT license that can be found in
+// the LICENSE file.
+
+package girc
+
+import (
+	"bytes"
+	"reflect"
+	"sync/atomic"
+	"testing"
+)
+
+func TestMatchMask(t *testing.T) {
+	src := &Source{Name: "Spammer", Ident: "~spam", Host: "spam.host"}
+
+	tests := []struct {
+		mask string
+		want bool
+	}{
+		{mask: "*!*@spam.host", want: true},
+		{mask: "*!*@SPAM.HOST", want: true},
+		{mask: "spammer", want: true},
+		{mask: "spam*", want: true},
+		{mask: "spammer!~spam@*", want: true},
+		{mask: "*!*@other.host", want: false},
+		{mask: "other", want: false},
+		{mask: "other!*@*", want: false},
+	}
+
+	for _, tt := range tests {
+		if got := matchMask(CaseMappingRFC1459, src, tt.mask); got != tt.want {
+			t.Errorf("matchMask(%q) = %t, want %t", tt.mask, got, tt.want)
+		}
+	}
+}
+
+func TestIgnoresCasemapping(t *testing.T) {
+	casemapping := CaseMappingRFC1459
+	i := newIgnores(func() string { return casemapping })
+	i.Add("a[b]")
+
+	if !i.Match(&Source{Name: "A{B}"}) {
+		t.Error("Match(A{B}) = false, want true with rfc1459")
+	}
+
+	casemapping = CaseMappingASCII
+	if i.Match(&Source{Name: "a{b}"}) {
+		t.Error("Match(a{b}) = true, want false with ascii")
+	}
+
+	if !i.Match(&Source{Name: "A[B]"}) {
+		t.Error("Match(A[B]) = false, want true with ascii")
+	}
+
+	if i.Add("a{b}"); len(i.List()) != 2 {
+		t.Errorf("List() = %q, want a[b] and a{b} with ascii", i.List())
+	}
+}
+
+func TestIgnoresPersistence(t *testing.T) {
+	i := newIgnores(nil)
+	i.Add("*!*@spam.host", "badnick", "*!*@SPAM.HOST")
+
+	if got := i.List(); !reflect.DeepEqual(got, []string{"*!*@spam.host", "badnick"}) {
+		t.Fatalf("List() = %v, want duplicates removed", got)
+	}
+
+	var buf bytes.Buffer
+	if _, err := i.WriteTo(&buf); err != nil {
+		t.Fatalf("WriteTo() returned error: %s", err)
+	}
+
+	loaded := newIgnores(nil)
+	buf.WriteString("\n# comment\n")
+	if _, err := loaded.ReadFrom(&buf); err != nil {
+		t.Fatalf("ReadFrom() returned error: %s", err)
+	}
+
+	if !reflect.DeepEqual(loaded.List(), i.List()) {
+		t.Fatalf("ReadFrom() loaded %v, want %v", loaded.List(), i.List())
+	}
+
+	if !loaded.Remove("BADNICK") || loaded.Remove("badnick") {
+		t.Fatal("Remove() did not remove mask exactly once")
+	}
+}
+
+func TestIgnoredDispatch(t *testing.T) {
+	c := New(Config{Server: "irc.example.com", Nick: "nick", User: "user"})
+	c.Ignores.Add("*!*@spam.host")
+
+	var called int32
+	c.Handlers.Add(PRIVMSG, func(c *Client, e Event) { atomic.AddInt32(&called, 1) })
+	c.Handlers.Add(JOIN, func(c *Client, e Event) { atomic.AddInt32(&called, 1) })
+
+	c.RunHandlers(ParseEvent(":spammer!spam@spam.host PRIVMSG #channel :buy things"))
+	if atomic.LoadInt32(&called) != 0 {
+		t.Fatal("handler executed for ignored source")
+	}
+
+	c.RunHandlers(ParseEvent(":friend!user@friend.host PRIVMSG #channel :hello"))
+	if atomic.LoadInt32(&called) != 1 {
+		t.Fatal("handler not executed for source which isn't ignored")
+	}
+
+	// JOIN isn't ignored by default.
+	c.RunHandlers(ParseEvent(":spammer!spam@spam.host JOIN #channel"))
+	if atomic.LoadInt32(&called) != 2 {
+		t.Fatal("handler not executed for command which isn't ignored")
+	}
+}
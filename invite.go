@@ -0,0 +1,110 @@
+// Copyright (c) Liam Stanley <me@liamstanley.io>. All rights reserved. Use
+// of this source code is governed by the MIT license that can be found in
+// the LICENSE file.
+
+package girc
+
+import "time"
+
+// maxPendingInvites is the maximum amount of pending invites which are
+// tracked. Once exceeded, the oldest invites are forgotten.
+const maxPendingInvites = 50
+
+// Invite is an invitation of a user to a channel. See USER_INVITED.
+type Invite struct {
+	// Inviter is the user who sent the invite.
+	Inviter *Source
+	// Invitee is the nickname of the user who was invited.
+	Invitee string
+	// Channel is the channel the user was invited to.
+	Channel string
+	// Time is when the invite was sent.
+	Time time.Time
+}
+
+// Copy returns a deep copy of the invite.
+func (i Invite) Copy() Invite {
+	if i.Inviter != nil {
+		inviter := *i.Inviter
+		i.Inviter = &inviter
+	}
+
+	return i
+}
+
+// PendingInvites returns the invites we have received (oldest first), for
+// channels which we haven't joined since. Panics if tracking is disabled.
+func (c *Client) PendingInvites() []Invite {
+	c.panicIfNotTracking()
+
+	c.state.mu.RLock()
+	defer c.state.mu.RUnlock()
+
+	invites := make([]Invite, len(c.state.invites))
+	for i := 0; i < len(c.state.invites); i++ {
+		invites[i] = c.state.invites[i].Copy()
+	}
+
+	return invites
+}
+
+// removeInvites forgets about pending invites to channel, e.g. once we have
+// joined it. Always use state.mu for transaction.
+func (s *state) removeInvites(channel string) {
+	channel = s.toLower(channel)
+
+	invites := s.invites[:0]
+	for _, invite := range s.invites {
+		if s.toLower(invite.Channel) != channel {
+			invites = append(invites, invite)
+		}
+	}
+	s.invites = invites
+}
+
+// handleINVITE handles invites to us, and (with the invite-notify capability)
+// invites of other users to channels we're in. Invites to us are tracked
+// until we join the channel (see Client.PendingInvites), and joined if
+// accepted by Config.AcceptInvites.
+func handleINVITE(c *Client, e Event) {
+	if e.Source == nil || len(e.Params) < 1 {
+		return
+	}
+
+	invite := Invite{Inviter: e.Source, Invitee: e.Params[0], Time: e.Timestamp}
+	if len(e.Params) > 1 {
+		invite.Channel = e.Params[1]
+	} else {
+		// Some older servers send the channel as trailing.
+		invite.Channel = e.Trailing
+	}
+
+	if !IsValidChannel(invite.Channel) {
+		return
+	}
+
+	self := c.isSelf(&Source{Name: invite.Invitee})
+
+	if self {
+		c.state.mu.Lock()
+		c.state.removeInvites(invite.Channel)
+		c.state.invites = append(c.state.invites, invite.Copy())
+		if len(c.state.invites) > maxPendingInvites {
+			c.state.invites = c.state.invites[len(c.state.invites)-maxPendingInvites:]
+		}
+		c.state.mu.Unlock()
+	}
+
+	c.RunHandlers(&Event{
+		Source:    e.Source,
+		Tags:      e.Tags,
+		RawTags:   e.RawTags,
+		Command:   USER_INVITED,
+		Params:    []string{invite.Invitee, invite.Channel},
+		Timestamp: e.Timestamp,
+	})
+
+	if self && c.Config.AcceptInvites != nil && c.Config.AcceptInvites(invite.Copy()) {
+		c.Commands.Join(invite.Channel)
+	}
+}
@@ -0,0 +1,73 @@
+// Copyright (c) Liam Stanley <me@liamstanley.io>. All rights reserved. Use
+// of this source code is governed by the MIT license that can be found in
+// the LICENSE file.
+
+package girc
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestInvite(t *testing.T) {
+	c, server := mockClient(t, Config{
+		AcceptInvites: func(invite Invite) bool { return invite.Inviter.Name == "friend" },
+	})
+	defer c.Stop()
+
+	events := make(chan Event, 5)
+	c.Handlers.Add(USER_INVITED, func(c *Client, e Event) { events <- e })
+
+	expectInvite := func(inviter, invitee, channel string) {
+		t.Helper()
+
+		select {
+		case e := <-events:
+			if e.Source.Name != inviter || !reflect.DeepEqual(e.Params, []string{invitee, channel}) {
+				t.Fatalf("unexpected USER_INVITED event: %q", e.String())
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatalf("never received USER_INVITED for %s", channel)
+		}
+	}
+
+	server.send(":stranger!user@host INVITE nick #spam")
+	expectInvite("stranger", "nick", "#spam")
+	server.send(":friend!user@host INVITE nick :#friends")
+	expectInvite("friend", "nick", "#friends")
+	server.expect("JOIN #friends")
+
+	// invite-notify.
+	server.send(":friend!user@host INVITE other #friends")
+	expectInvite("friend", "other", "#friends")
+
+	invites := c.PendingInvites()
+	if len(invites) != 2 || invites[0].Channel != "#spam" || invites[1].Channel != "#friends" || invites[1].Inviter.Name != "friend" {
+		t.Fatalf("unexpected pending invites: %#v", invites)
+	}
+
+	server.send(":nick!user@host JOIN #friends")
+	server.send(":irc.example.com PING :sync")
+	server.expect("PONG")
+
+	if invites = c.PendingInvites(); len(invites) != 1 || invites[0].Channel != "#spam" {
+		t.Fatalf("unexpected pending invites after join: %#v", invites)
+	}
+
+	// With ascii, "n{1}" isn't us, unlike with rfc1459.
+	c.state.mu.Lock()
+	c.state.isupport.Casemapping = CaseMappingASCII
+	c.state.mu.Unlock()
+
+	server.send(":irc.example.com 001 nick :Welcome")
+	server.send(":nick!user@host NICK n[1]")
+	server.send(":stranger!user@host INVITE n{1} #other")
+	expectInvite("stranger", "n{1}", "#other")
+	server.send(":stranger!user@host INVITE N[1] #upper")
+	expectInvite("stranger", "N[1]", "#upper")
+
+	if invites = c.PendingInvites(); len(invites) != 2 || invites[1].Channel != "#upper" {
+		t.Fatalf("unexpected pending invites with ascii: %#v", invites)
+	}
+}
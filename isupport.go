@@ -0,0 +1,374 @@
+// Copyright (c) Liam Stanley <me@liamstanley.io>. All rights reserved. Use
+// of this source code is governed by the MIT license that can be found in
+// the LICENSE file.
+
+package girc
+
+import (
+	"strconv"
+	"strings"
+)
+
+// ISupport is the parsed set of RPL_ISUPPORT (also known as RPL_PROTOCTL)
+// tokens which the server has advertised, which describe the features and
+// limits of the server. Fields which the server hasn't advertised contain
+// the commonly used defaults (or zero values, if there is no sensible
+// default). Tokens which aren't parsed into a field are still available
+// through Raw. See Client.ISupport.
+type ISupport struct {
+	// Network is the name of the network (NETWORK), e.g. "EsperNet".
+	Network string
+	// Casemapping is the casemapping (CASEMAPPING) used by the server to
+	// compare nicknames and channel names. See ToLower.
+	Casemapping string
+	// ChanTypes are the supported channel prefixes (CHANTYPES), e.g. "#&".
+	ChanTypes string
+	// PrefixModes are the channel user modes (PREFIX) which the server
+	// supports, ordered from highest to lowest rank, e.g. "ov".
+	PrefixModes string
+	// PrefixSymbols are the symbols (PREFIX) for each of the PrefixModes,
+	// e.g. "@+".
+	PrefixSymbols string
+	// ChanModes are the supported channel modes (CHANMODES), grouped by the
+	// type of mode.
+	ChanModes struct {
+		// A are modes which add or remove an entry from a list (e.g. bans),
+		// and always have a parameter.
+		A string
+		// B are modes which change a setting, and always have a parameter.
+		B string
+		// C are modes which change a setting, and only have a parameter when
+		// set.
+		C string
+		// D are modes which change a setting, and never have a parameter.
+		D string
+	}
+	// Excepts is the ban exception mode character (EXCEPTS), if supported.
+	Excepts string
+	// Invex is the invite exception mode character (INVEX), if supported.
+	Invex string
+	// StatusMsg are the channel user prefix symbols (STATUSMSG) which can
+	// be used to message only users with that prefix, e.g. "@+".
+	StatusMsg string
+	// Bot is the user mode which marks users as bots (BOT), if supported.
+	// See Config.Bot.
+	Bot string
+	// EList are the supported LIST search extensions (ELIST), e.g. "CTU".
+	// See Commands.List.
+	EList string
+	// ExtBanPrefix is the prefix of extended bans (EXTBAN), e.g. "$" or
+	// "~". InspIRCd doesn't use a prefix. See ExtBan.
+	ExtBanPrefix string
+	// ExtBanTypes are the supported extended ban types (EXTBAN), e.g.
+	// "ajrxz", or empty if extended bans aren't supported.
+	ExtBanTypes string
+
+	// NickLen is the maximum nickname length (NICKLEN).
+	NickLen int
+	// ChannelLen is the maximum channel name length (CHANNELLEN).
+	ChannelLen int
+	// TopicLen is the maximum topic length (TOPICLEN).
+	TopicLen int
+	// KickLen is the maximum kick reason length (KICKLEN).
+	KickLen int
+	// AwayLen is the maximum away message length (AWAYLEN).
+	AwayLen int
+	// Modes is the maximum amount of channel modes with a parameter which
+	// can be sent in a single MODE command (MODES). 0 means no limit.
+	Modes int
+	// Monitor is the maximum amount of targets in the MONITOR list
+	// (MONITOR), 0 meaning no limit. Only valid if the server has advertised
+	// MONITOR (see Raw).
+	Monitor int
+	// ChatHistory is the maximum amount of messages which can be requested
+	// in a single CHATHISTORY query (CHATHISTORY), 0 meaning no limit. See
+	// Commands.ChatHistory.
+	ChatHistory int
+
+	// TargMax is the maximum amount of targets for each command (TARGMAX,
+	// falling back to MAXTARGETS), keyed by command. A limit of 0 means
+	// there is no limit. Commands not in the map have an unknown limit.
+	TargMax map[string]int
+	// MaxList is the maximum amount of entries for each list mode (MAXLIST),
+	// keyed by mode character.
+	MaxList map[string]int
+	// ChanLimit is the maximum amount of channels which can be joined for
+	// each channel prefix (CHANLIMIT), keyed by prefix. A limit of 0 means
+	// there is no limit.
+	ChanLimit map[string]int
+
+	// Raw contains all advertised tokens, as they were sent (with escapes
+	// decoded). Tokens without a value have an empty string value.
+	Raw map[string]string
+}
+
+// newISupport returns an ISupport with the commonly used defaults.
+func newISupport() ISupport {
+	i := ISupport{
+		Casemapping: CaseMappingRFC1459,
+		ChanTypes:   "#&",
+		TargMax:     make(map[string]int),
+		MaxList:     make(map[string]int),
+		ChanLimit:   make(map[string]int),
+		Raw:         make(map[string]string),
+	}
+
+	i.PrefixModes, i.PrefixSymbols = parsePrefixes(DefaultPrefixes)
+	i.setChanModes(ModeDefaults)
+
+	return i
+}
+
+// Copy returns a deep copy of the ISupport.
+func (i ISupport) Copy() ISupport {
+	i.TargMax = copyIntMap(i.TargMax)
+	i.MaxList = copyIntMap(i.MaxList)
+	i.ChanLimit = copyIntMap(i.ChanLimit)
+
+	raw := make(map[string]string, len(i.Raw))
+	for k, v := range i.Raw {
+		raw[k] = v
+	}
+	i.Raw = raw
+
+	return i
+}
+
+// copyIntMap returns a copy of a map of int values.
+func copyIntMap(in map[string]int) map[string]int {
+	out := make(map[string]int, len(in))
+	for k, v := range in {
+		out[k] = v
+	}
+
+	return out
+}
+
+// Get returns the raw value of a token, and if it was advertised.
+func (i ISupport) Get(token string) (value string, ok bool) {
+	value, ok = i.Raw[strings.ToUpper(token)]
+	return value, ok
+}
+
+// parse parses a single token (e.g. "NICKLEN=30", "WHOX" or "-WHOX") from a
+// RPL_ISUPPORT event.
+func (i *ISupport) parse(raw string) {
+	if len(raw) < 1 {
+		return
+	}
+
+	// Negated tokens remove a previously advertised token.
+	if raw[0] == 0x2D { // -
+		name := strings.ToUpper(raw[1:])
+		delete(i.Raw, name)
+		i.reset(name, newISupport())
+		return
+	}
+
+	var name, value string
+	if j := strings.IndexByte(raw, 0x3D); j > -1 { // =
+		name, value = raw[:j], unescapeISupport(raw[j+1:])
+	} else {
+		name = raw
+	}
+
+	name = strings.ToUpper(name)
+	if name == "" {
+		return
+	}
+
+	i.Raw[name] = value
+	i.set(name, value)
+}
+
+// reset resets the typed field of a token to its value in defaults.
+func (i *ISupport) reset(name string, defaults ISupport) {
+	switch name {
+	case "NETWORK":
+		i.Network = defaults.Network
+	case "CASEMAPPING":
+		i.Casemapping = defaults.Casemapping
+	case "CHANTYPES":
+		i.ChanTypes = defaults.ChanTypes
+	case "PREFIX":
+		i.PrefixModes, i.PrefixSymbols = defaults.PrefixModes, defaults.PrefixSymbols
+	case "CHANMODES":
+		i.ChanModes = defaults.ChanModes
+	case "EXCEPTS":
+		i.Excepts = ""
+	case "INVEX":
+		i.Invex = ""
+	case "STATUSMSG":
+		i.StatusMsg = ""
+	case "ELIST":
+		i.EList = ""
+	case "BOT":
+		i.Bot = ""
+	case "EXTBAN":
+		i.ExtBanPrefix, i.ExtBanTypes = "", ""
+	case "NICKLEN", "MAXNICKLEN":
+		i.NickLen = 0
+	case "CHANNELLEN":
+		i.ChannelLen = 0
+	case "TOPICLEN":
+		i.TopicLen = 0
+	case "KICKLEN":
+		i.KickLen = 0
+	case "AWAYLEN":
+		i.AwayLen = 0
+	case "MODES":
+		i.Modes = 0
+	case "MONITOR":
+		i.Monitor = 0
+	case "CHATHISTORY":
+		i.ChatHistory = 0
+	case "TARGMAX", "MAXTARGETS":
+		i.TargMax = make(map[string]int)
+	case "MAXLIST":
+		i.MaxList = make(map[string]int)
+	case "CHANLIMIT":
+		i.ChanLimit = make(map[string]int)
+	}
+}
+
+// set updates the typed field of a token.
+func (i *ISupport) set(name, value string) {
+	switch name {
+	case "NETWORK":
+		i.Network = value
+	case "CASEMAPPING":
+		i.Casemapping = strings.ToLower(value)
+	case "CHANTYPES":
+		i.ChanTypes = value
+	case "PREFIX":
+		if value == "" {
+			// No channel user modes are supported.
+			i.PrefixModes, i.PrefixSymbols = "", ""
+		} else if isValidUserPrefix(value) {
+			i.PrefixModes, i.PrefixSymbols = parsePrefixes(value)
+		}
+	case "CHANMODES":
+		if IsValidChannelMode(value) {
+			i.setChanModes(value)
+		}
+	case "EXCEPTS":
+		i.Excepts = value
+		if i.Excepts == "" {
+			i.Excepts = ModeException
+		}
+	case "INVEX":
+		i.Invex = value
+		if i.Invex == "" {
+			i.Invex = ModeInviteException
+		}
+	case "STATUSMSG":
+		i.StatusMsg = value
+	case "ELIST":
+		i.EList = strings.ToUpper(value)
+	case "BOT":
+		i.Bot = value
+	case "EXTBAN":
+		// The prefix and types are separated by a comma, e.g. "$,ajrxz",
+		// or ",ACRjmrz" without a prefix.
+		if j := strings.IndexByte(value, 0x2C); j > -1 { // ,
+			i.ExtBanPrefix, i.ExtBanTypes = value[:j], value[j+1:]
+		}
+	case "NICKLEN", "MAXNICKLEN":
+		i.NickLen, _ = strconv.Atoi(value)
+	case "CHANNELLEN":
+		i.ChannelLen, _ = strconv.Atoi(value)
+	case "TOPICLEN":
+		i.TopicLen, _ = strconv.Atoi(value)
+	case "KICKLEN":
+		i.KickLen, _ = strconv.Atoi(value)
+	case "AWAYLEN":
+		i.AwayLen, _ = strconv.Atoi(value)
+	case "MODES":
+		i.Modes, _ = strconv.Atoi(value)
+	case "MONITOR":
+		i.Monitor, _ = strconv.Atoi(value)
+	case "CHATHISTORY":
+		i.ChatHistory, _ = strconv.Atoi(value)
+	case "TARGMAX":
+		i.TargMax = parseISupportLimits(value, true)
+	case "MAXTARGETS":
+		// Only used if the server doesn't support the newer TARGMAX.
+		if _, ok := i.Raw["TARGMAX"]; ok {
+			return
+		}
+
+		limit, _ := strconv.Atoi(value)
+		i.TargMax = map[string]int{PRIVMSG: limit, NOTICE: limit}
+	case "MAXLIST":
+		// Each entry applies to a group of modes, e.g. "beI:100".
+		i.MaxList = make(map[string]int)
+		for modes, limit := range parseISupportLimits(value, false) {
+			for j := 0; j < len(modes); j++ {
+				i.MaxList[string(modes[j])] = limit
+			}
+		}
+	case "CHANLIMIT":
+		// Each entry applies to a group of prefixes, e.g. "#&:20".
+		i.ChanLimit = make(map[string]int)
+		for prefixes, limit := range parseISupportLimits(value, false) {
+			for j := 0; j < len(prefixes); j++ {
+				i.ChanLimit[string(prefixes[j])] = limit
+			}
+		}
+	}
+}
+
+// setChanModes splits a CHANMODES value into its mode groups.
+func (i *ISupport) setChanModes(value string) {
+	split := strings.SplitN(value, ",", 4)
+	for len(split) < 4 {
+		split = append(split, "")
+	}
+
+	i.ChanModes.A, i.ChanModes.B, i.ChanModes.C, i.ChanModes.D = split[0], split[1], split[2], split[3]
+}
+
+// parseISupportLimits parses a "key:limit,key:limit" list, as used by
+// TARGMAX, MAXLIST and CHANLIMIT. A missing limit is stored as 0.
+func parseISupportLimits(value string, upper bool) map[string]int {
+	out := make(map[string]int)
+
+	for _, entry := range strings.Split(value, ",") {
+		j := strings.IndexByte(entry, 0x3A) // :
+		if j < 1 {
+			continue
+		}
+
+		key := entry[:j]
+		if upper {
+			key = strings.ToUpper(key)
+		}
+
+		out[key], _ = strconv.Atoi(entry[j+1:])
+	}
+
+	return out
+}
+
+// unescapeISupport decodes the "\xHH" escapes which are used within
+// ISUPPORT values, e.g. for spaces in the network name.
+func unescapeISupport(value string) string {
+	if strings.IndexByte(value, 0x5C) < 0 { // \
+		return value
+	}
+
+	var out []byte
+	for i := 0; i < len(value); i++ {
+		if value[i] == 0x5C && i+3 < len(value) && value[i+1] == 0x78 { // \x
+			if b, err := strconv.ParseUint(value[i+2:i+4], 16, 8); err == nil {
+				out = append(out, byte(b))
+				i += 3
+				continue
+			}
+		}
+
+		out = append(out, value[i])
+	}
+
+	return string(out)
+}
@@ -0,0 +1,72 @@
+// Copyright (c) Liam Stanley <me@liamstanley.io>. All rights reserved. Use
+// of this source code is governed by the MIT license that can be found in
+// the LICENSE file.
+
+package girc
+
+import "testing"
+
+func TestISupport(t *testing.T) {
+	c := New(Config{Server: "irc.example.com", Nick: "nick", User: "user"})
+
+	defaults := c.ISupport()
+	if defaults.Casemapping != CaseMappingRFC1459 || defaults.PrefixModes != "ov" || defaults.ChanModes.A != "beI" {
+		t.Fatalf("unexpected defaults: %#v", defaults)
+	}
+
+	for _, line := range []string{
+		`:irc.example.com 005 nick NETWORK=Example\x20Net CASEMAPPING=ascii CHANTYPES=# PREFIX=(qaohv)~&@%+ CHANMODES=beI,k,l,imnpst EXCEPTS INVEX=J :are supported by this server`,
+		`:irc.example.com 005 nick NICKLEN=30 TARGMAX=PRIVMSG:4,NOTICE:4,JOIN: MAXTARGETS=1 MAXLIST=beI:100,q:10 CHANLIMIT=#:25 MONITOR=100 CHATHISTORY=100 EXTBAN=$,ajrxz WHOX :are supported by this server`,
+		`:irc.example.com 005 nick -WHOX -MONITOR :are supported by this server`,
+	} {
+		handleISUPPORT(c, *ParseEvent(line))
+	}
+
+	is := c.ISupport()
+
+	checks := []struct {
+		name      string
+		got, want interface{}
+	}{
+		{"Network", is.Network, "Example Net"},
+		{"Casemapping", is.Casemapping, CaseMappingASCII},
+		{"ChanTypes", is.ChanTypes, "#"},
+		{"PrefixModes", is.PrefixModes, "qaohv"},
+		{"PrefixSymbols", is.PrefixSymbols, "~&@%+"},
+		{"ChanModes.B", is.ChanModes.B, "k"},
+		{"ChanModes.D", is.ChanModes.D, "imnpst"},
+		{"Excepts", is.Excepts, "e"},
+		{"Invex", is.Invex, "J"},
+		{"NickLen", is.NickLen, 30},
+		{"TargMax[PRIVMSG]", is.TargMax[PRIVMSG], 4},
+		{"TargMax[JOIN]", is.TargMax[JOIN], 0},
+		{"len(TargMax)", len(is.TargMax), 3},
+		{"MaxList[I]", is.MaxList["I"], 100},
+		{"MaxList[q]", is.MaxList["q"], 10},
+		{"ChanLimit[#]", is.ChanLimit["#"], 25},
+		{"Monitor", is.Monitor, 0},
+		{"ChatHistory", is.ChatHistory, 100},
+		{"ExtBanPrefix", is.ExtBanPrefix, "$"},
+		{"ExtBanTypes", is.ExtBanTypes, "ajrxz"},
+	}
+
+	for _, check := range checks {
+		if check.got != check.want {
+			t.Errorf("ISupport.%s = %#v, want %#v", check.name, check.got, check.want)
+		}
+	}
+
+	if _, ok := is.Get("WHOX"); ok {
+		t.Error("negated WHOX token still advertised")
+	}
+
+	if value, ok := c.GetServerOption("NETWORK"); !ok || value != "Example Net" {
+		t.Errorf("GetServerOption(NETWORK) = %q, %t", value, ok)
+	}
+
+	// Copies shouldn't affect state.
+	is.TargMax[PRIVMSG] = 100
+	if c.ISupport().TargMax[PRIVMSG] != 4 {
+		t.Error("ISupport() returned a shallow copy")
+	}
+}
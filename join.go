@@ -0,0 +1,213 @@
+// Copyright (c) Liam Stanley <me@liamstanley.io>. All rights reserved. Use
+// of this source code is governed by the MIT license that can be found in
+// the LICENSE file.
+
+package girc
+
+import (
+	"strings"
+	"sync"
+
+	"golang.org/x/net/context"
+)
+
+// joinErrors are the replies which mean that joining a channel failed,
+// where params[1] is the channel.
+var joinErrors = []string{
+	ERR_NOSUCHCHANNEL, ERR_TOOMANYCHANNELS, ERR_UNAVAILRESOURCE,
+	ERR_CHANNELISFULL, ERR_INVITEONLYCHAN, ERR_BANNEDFROMCHAN,
+	ERR_BADCHANNELKEY, ERR_BADCHANMASK, ERR_NOCHANMODES,
+}
+
+// isJoinError returns true if command is one of joinErrors.
+func isJoinError(command string) bool {
+	for _, cmd := range joinErrors {
+		if cmd == command {
+			return true
+		}
+	}
+
+	return false
+}
+
+// ErrJoinFailed is returned by Commands.JoinSync when the server refuses to
+// let us join a channel, e.g. because it's invite only (ERR_INVITEONLYCHAN)
+// or we're banned (ERR_BANNEDFROMCHAN).
+type ErrJoinFailed struct {
+	// Channel is the channel we attempted to join.
+	Channel string
+	// Code is the numeric the server responded with.
+	Code string
+	// Reason is the reason the server supplied, if any.
+	Reason string
+}
+
+func (e *ErrJoinFailed) Error() string {
+	return "unable to join " + e.Channel + " (" + e.Code + "): " + e.Reason
+}
+
+// Unwrap returns the error for Code, if any (see NumericError).
+func (e *ErrJoinFailed) Unwrap() error { return NumericError(e.Code) }
+
+// channelKeys tracks the keys we've joined channels with, across
+// connections.
+type channelKeys struct {
+	mu   sync.RWMutex
+	keys map[string]string
+}
+
+// record records the keys of an outgoing JOIN, keyed by channel with
+// casemapping.
+func (k *channelKeys) record(casemapping string, event *Event) {
+	if len(event.Params) < 2 {
+		return
+	}
+
+	channels := strings.Split(event.Params[0], ",")
+	keys := strings.Split(event.Params[1], ",")
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	if k.keys == nil {
+		k.keys = make(map[string]string)
+	}
+
+	for i := 0; i < len(channels) && i < len(keys); i++ {
+		if keys[i] != "" {
+			k.keys[ToLower(casemapping, channels[i])] = keys[i]
+		}
+	}
+}
+
+// get returns the key channel was joined with, if any, comparing channels
+// with casemapping.
+func (k *channelKeys) get(casemapping, channel string) string {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+
+	return k.keys[ToLower(casemapping, channel)]
+}
+
+// ChannelKey returns the key of channel: the key set on the channel (+k) if
+// known, otherwise the key we last joined it with (e.g. with
+// Commands.JoinKey), which is remembered across reconnects. Returns an
+// empty string if no key is known.
+func (c *Client) ChannelKey(channel string) string {
+	if !c.Config.disableTracking {
+		c.state.mu.RLock()
+		ch := c.state.lookupChannel(channel)
+		var key string
+		if ch != nil {
+			key = ch.Modes.Key()
+		}
+		c.state.mu.RUnlock()
+
+		if key != "" {
+			return key
+		}
+	}
+
+	return c.keys.get(c.casemapping(), channel)
+}
+
+// handleLINKCHANNEL handles the server forwarding our join to another
+// channel (ERR_LINKCHANNEL), e.g. because the channel is full. We join the
+// target channel instead, so the original channel is no longer tracked, nor
+// rejoined. Dispatches CHANNEL_FORWARDED.
+func handleLINKCHANNEL(c *Client, e Event) {
+	// <client> <channel> <target> :Forwarding to another channel
+	if len(e.Params) < 3 {
+		return
+	}
+
+	from, to := e.Params[1], e.Params[2]
+
+	c.state.mu.Lock()
+	if c.state.lookupChannel(from) != nil {
+		c.state.deleteChannel(from)
+	}
+	c.state.removeInvites(from)
+	c.state.mu.Unlock()
+
+	c.finishRejoin(from)
+
+	c.RunHandlers(&Event{Command: CHANNEL_FORWARDED, Params: []string{from, to}, Trailing: e.Trailing})
+}
+
+// JoinSync joins channel, using key if it's not empty, and waits until the
+// join has completed (RPL_ENDOFNAMES), or until ctx is done. If the server
+// forwards us to another channel (see CHANNEL_FORWARDED), JoinSync waits
+// until we've joined that channel instead. If the server refuses the join,
+// an ErrJoinFailed is returned, which can be checked with errors.Is (e.g.
+// against ErrBannedFromChan or ErrInviteOnlyChan).
+func (cmd *Commands) JoinSync(ctx context.Context, channel, key string) error {
+	if !IsValidChannel(channel) {
+		return &ErrInvalidTarget{Target: channel}
+	}
+
+	c := cmd.c
+	id := c.toLower(channel)
+
+	var mu sync.Mutex
+	var joined bool
+	forwarded := id
+	done := make(chan error, 1)
+
+	finish := func(err error) {
+		select {
+		case done <- err:
+		default:
+			// Already finished.
+		}
+	}
+
+	cuid := c.Handlers.sregisterOwned(ALLEVENTS, HandlerFunc(func(client *Client, e Event) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		switch {
+		case e.Command == ERR_LINKCHANNEL:
+			if len(e.Params) > 2 && client.toLower(e.Params[1]) == id {
+				forwarded = client.toLower(e.Params[2])
+			}
+		case e.Command == JOIN:
+			// Without tracking, we can't tell who joined, but we don't see
+			// others joining before we've joined.
+			self := client.Config.disableTracking || client.isSelf(e.Source)
+			if len(e.Params) > 0 && client.toLower(e.Params[0]) == forwarded && self {
+				joined = true
+			}
+		case e.Command == RPL_ENDOFNAMES:
+			// Ignore the replies to NAMES queries sent before we've joined.
+			if joined && len(e.Params) > 1 && client.toLower(e.Params[1]) == forwarded {
+				finish(nil)
+			}
+		case isJoinError(e.Command):
+			if len(e.Params) < 2 || client.toLower(e.Params[1]) != forwarded {
+				return
+			}
+
+			name := channel
+			if forwarded != id {
+				name = e.Params[1]
+			}
+
+			finish(&ErrJoinFailed{Channel: name, Code: e.Command, Reason: e.Trailing})
+		}
+	}))
+	defer c.Handlers.Remove(cuid)
+
+	if key != "" {
+		cmd.JoinKey(channel, key)
+	} else {
+		cmd.Join(channel)
+	}
+
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
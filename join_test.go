@@ -0,0 +1,135 @@
+// Copyright (c) Liam Stanley <me@liamstanley.io>. All rights reserved. Use
+// of this source code is governed by the MIT license that can be found in
+// the LICENSE file.
+
+package girc
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+func TestJoinSync(t *testing.T) {
+	c, server := mockClient(t, Config{RateBurst: 10})
+	defer c.Stop()
+
+	server.send(":irc.example.com 001 nick :Welcome")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	results := make(chan error, 1)
+	go func() { results <- c.Commands.JoinSync(ctx, "#Secret", "key") }()
+
+	server.expect("JOIN #Secret key")
+	server.send(":irc.example.com 475 nick #secret :Cannot join channel (+k)")
+
+	want := &ErrJoinFailed{Channel: "#Secret", Code: ERR_BADCHANNELKEY, Reason: "Cannot join channel (+k)"}
+	if err := <-results; !reflect.DeepEqual(err, want) {
+		t.Fatalf("JoinSync() = %v, want %v", err, want)
+	}
+
+	go func() { results <- c.Commands.JoinSync(ctx, "#channel", "") }()
+
+	server.expect("JOIN #channel")
+	// The end of a NAMES reply from before we've joined.
+	server.send(":irc.example.com 366 nick #channel :End of /NAMES list.")
+	server.send(":nick!user@host JOIN #channel")
+	server.send(":irc.example.com 353 nick = #channel :nick other")
+	server.send(":irc.example.com 366 nick #channel :End of /NAMES list.")
+
+	if err := <-results; err != nil {
+		t.Fatalf("JoinSync() = %v, want nil", err)
+	}
+
+	if channel, ok := c.LookupChannel("#channel"); !ok || channel.Len() != 2 {
+		t.Fatal("JoinSync() returned before the channel was synced")
+	}
+
+	if key := c.ChannelKey("#secret"); key != "key" {
+		t.Fatalf("ChannelKey() = %q, want %q", key, "key")
+	}
+
+	// With ascii, #a{b} isn't #a[b].
+	c.state.mu.Lock()
+	c.state.isupport.Casemapping = CaseMappingASCII
+	c.state.mu.Unlock()
+
+	go func() { results <- c.Commands.JoinSync(ctx, "#a[b]", "other") }()
+
+	server.expect("JOIN #a[b] other")
+	server.send(":irc.example.com 475 nick #a{b} :Cannot join channel (+k)")
+	server.send(":nick!user@host JOIN #A[B]")
+	server.send(":irc.example.com 366 nick #A[B] :End of /NAMES list.")
+
+	if err := <-results; err != nil {
+		t.Fatalf("JoinSync() = %v, want nil", err)
+	}
+
+	if key := c.ChannelKey("#A{B}"); key != "" {
+		t.Fatalf("ChannelKey(#A{B}) = %q, want no key with ascii", key)
+	}
+
+	if key := c.ChannelKey("#A[B]"); key != "other" {
+		t.Fatalf("ChannelKey(#A[B]) = %q, want %q", key, "other")
+	}
+}
+
+func TestJoinSyncForwarded(t *testing.T) {
+	c, server := mockClient(t, Config{RateBurst: 10})
+	defer c.Stop()
+
+	events := make(chan Event, 3)
+	c.Handlers.Add(CHANNEL_FORWARDED, func(c *Client, e Event) { events <- e })
+
+	server.send(":irc.example.com 001 nick :Welcome")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	results := make(chan error, 1)
+	go func() { results <- c.Commands.JoinSync(ctx, "#channel", "") }()
+
+	server.expect("JOIN #channel")
+	server.send(":irc.example.com 470 nick #channel ##overflow :Forwarding to another channel")
+	server.send(":nick!user@host JOIN ##overflow")
+	server.send(":irc.example.com 366 nick ##overflow :End of /NAMES list.")
+
+	if err := <-results; err != nil {
+		t.Fatalf("JoinSync() = %v, want nil", err)
+	}
+
+	select {
+	case e := <-events:
+		if want := "CHANNEL_FORWARDED #channel ##overflow :Forwarding to another channel"; e.String() != want {
+			t.Fatalf("got %q, want %q", e.String(), want)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("CHANNEL_FORWARDED wasn't dispatched")
+	}
+
+	if channels := c.Channels(); !reflect.DeepEqual(channels, []string{"##overflow"}) {
+		t.Fatalf("Channels() = %q, want [##overflow]", channels)
+	}
+
+	// With ascii, a forward from #a{b} isn't one from #a[b].
+	c.state.mu.Lock()
+	c.state.isupport.Casemapping = CaseMappingASCII
+	c.state.mu.Unlock()
+
+	go func() { results <- c.Commands.JoinSync(ctx, "#a[b]", "") }()
+
+	server.expect("JOIN #a[b]")
+	server.send(":irc.example.com 470 nick #a{b} ##other :Forwarding to another channel")
+	server.send(":irc.example.com 470 nick #A[B] ##full[1] :Forwarding to another channel")
+	server.send(":irc.example.com 473 nick ##full{1} :Cannot join channel (+i)")
+	server.send(":nick!user@host JOIN ##FULL[1]")
+	server.send(":irc.example.com 366 nick ##full[1] :End of /NAMES list.")
+
+	if err := <-results; err != nil {
+		t.Fatalf("JoinSync() = %v, want nil", err)
+	}
+}
@@ -0,0 +1,200 @@
+// Copyright (c) Liam Stanley <me@liamstanley.io>. All rights reserved. Use
+// of this source code is governed by the MIT license that can be found in
+// the LICENSE file.
+
+package girc
+
+import (
+	"strconv"
+	"strings"
+	"sync"
+
+	"golang.org/x/net/context"
+)
+
+// ListEntry is a single channel returned by a LIST query (RPL_LIST).
+type ListEntry struct {
+	// Channel is the name of the channel.
+	Channel string
+	// Users is the amount of visible users in the channel.
+	Users int
+	// Topic is the topic of the channel. Some servers prefix this with the
+	// channel modes, e.g. "[+nt] topic".
+	Topic string
+}
+
+// ErrListFailed is returned when the server refuses a LIST query, e.g. when
+// it would return too many channels (ERR_TOOMANYMATCHES), or when LIST is
+// rate limited (RPL_TRYAGAIN).
+type ErrListFailed struct {
+	// Code is the numeric the server responded with.
+	Code string
+	// Reason is the reason the server supplied, if any.
+	Reason string
+}
+
+func (e *ErrListFailed) Error() string {
+	return "list failed (" + e.Code + "): " + e.Reason
+}
+
+// Unwrap returns the error for Code, if any (see NumericError).
+func (e *ErrListFailed) Unwrap() error { return NumericError(e.Code) }
+
+// ErrListUnsupported is returned when a LIST pattern requires a search
+// extension which the server hasn't advertised with ELIST.
+type ErrListUnsupported struct {
+	// Pattern is the pattern which was supplied.
+	Pattern string
+	// Extension is the ELIST extension the pattern requires, e.g. "U".
+	Extension string
+}
+
+func (e *ErrListUnsupported) Error() string {
+	return "server does not support ELIST " + e.Extension + ", required by: " + e.Pattern
+}
+
+// List sends a LIST query to the server, and waits for all replies (until
+// RPL_LISTEND), or until ctx is done. Supply no patterns to list the entire
+// server (warning, that may mean LOTS of channels!). Patterns can be channel
+// names, or (if supported by the server, see ISupport.EList) one of the
+// following search extensions:
+//
+//	"#chan*"  channels matching the mask (M)
+//	"!#chan*" channels not matching the mask (N)
+//	">10"     channels with more than 10 users (U)
+//	"<10"     channels with less than 10 users (U)
+//	"C>60"    channels created more than 60 minutes ago (C)
+//	"C<60"    channels created less than 60 minutes ago (C)
+//	"T>60"    channels whose topic changed more than 60 minutes ago (T)
+//	"T<60"    channels whose topic changed less than 60 minutes ago (T)
+//
+// If the server doesn't support a required extension, ErrListUnsupported is
+// returned. Multiple patterns are sent in as few LIST queries as possible.
+func (cmd *Commands) List(ctx context.Context, patterns ...string) ([]ListEntry, error) {
+	var entries []ListEntry
+
+	err := cmd.ListFunc(ctx, func(entry ListEntry) {
+		entries = append(entries, entry)
+	}, patterns...)
+	if err != nil {
+		return nil, err
+	}
+
+	return entries, nil
+}
+
+// ListFunc is like List, however each channel is passed to fn as it is
+// received, rather than being collected. This is useful for large networks,
+// which may return many thousands of channels. fn is called from the event
+// handler goroutine, so it should not block.
+func (cmd *Commands) ListFunc(ctx context.Context, fn func(entry ListEntry), patterns ...string) error {
+	c := cmd.c
+
+	c.state.mu.RLock()
+	elist := c.state.isupport.EList
+	c.state.mu.RUnlock()
+
+	for i := 0; i < len(patterns); i++ {
+		ext := elistExtension(patterns[i])
+		if ext == "" {
+			if !IsValidChannel(patterns[i]) {
+				return &ErrInvalidTarget{Target: patterns[i]}
+			}
+			continue
+		}
+
+		if !strings.Contains(elist, ext) {
+			return &ErrListUnsupported{Pattern: patterns[i], Extension: ext}
+		}
+	}
+
+	// We can LIST multiple patterns at once, however we need to ensure that
+	// we are not exceeding the line length. (see maxLength)
+	queries := chunkJoin(patterns, ",", maxLength-len(LIST)-1)
+	if len(queries) == 0 {
+		queries = []string{""}
+	}
+
+	var mu sync.Mutex
+	var finished bool
+	pending := len(queries)
+	done := make(chan error, 1)
+
+	finish := func(err error) {
+		finished = true
+		done <- err
+	}
+
+	cuid := c.Handlers.sregisterOwned(ALLEVENTS, HandlerFunc(func(client *Client, e Event) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		if finished {
+			return
+		}
+
+		switch e.Command {
+		case RPL_LIST:
+			if len(e.Params) < 3 {
+				return
+			}
+
+			users, _ := strconv.Atoi(e.Params[2])
+			fn(ListEntry{Channel: e.Params[1], Users: users, Topic: e.Trailing})
+		case RPL_LISTEND:
+			if pending--; pending == 0 {
+				finish(nil)
+			}
+		case ERR_TOOMANYMATCHES, RPL_TRYAGAIN:
+			if len(e.Params) > 1 && strings.ToUpper(e.Params[1]) == LIST {
+				finish(&ErrListFailed{Code: e.Command, Reason: e.Trailing})
+			}
+		}
+	}))
+	defer c.Handlers.Remove(cuid)
+
+	for _, query := range queries {
+		if query == "" {
+			c.Send(&Event{Command: LIST})
+			continue
+		}
+
+		c.Send(&Event{Command: LIST, Params: []string{query}})
+	}
+
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		mu.Lock()
+		finished = true
+		mu.Unlock()
+
+		return ctx.Err()
+	}
+}
+
+// elistExtension returns the ELIST extension required by a LIST pattern, or
+// an empty string if the pattern is a plain channel name.
+func elistExtension(pattern string) string {
+	if pattern == "" {
+		return ""
+	}
+
+	switch pattern[0] {
+	case 0x3E, 0x3C: // > and <
+		return "U"
+	case 0x21: // !
+		return "N"
+	case 0x43, 0x63, 0x54, 0x74: // C and T
+		if len(pattern) > 1 && (pattern[1] == 0x3E || pattern[1] == 0x3C) {
+			return strings.ToUpper(pattern[:1])
+		}
+	}
+
+	if strings.ContainsAny(pattern, "*?") {
+		return "M"
+	}
+
+	return ""
+}
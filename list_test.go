@@ -0,0 +1,105 @@
+// Copyright (c) Liam Stanley <me@liamstanley.io>. All rights reserved. Use
+// of this source code is governed by the MIT license that can be found in
+// the LICENSE file.
+
+package girc
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+func TestList(t *testing.T) {
+	c, server := mockClient(t, Config{RateBurst: 10})
+	defer c.Stop()
+
+	server.send(":irc.example.com 001 nick :Welcome")
+	server.send(":irc.example.com 005 nick ELIST=MU :are supported by this server")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	type result struct {
+		entries []ListEntry
+		err     error
+	}
+	results := make(chan result, 1)
+
+	list := func(patterns ...string) {
+		go func() {
+			entries, err := c.Commands.List(ctx, patterns...)
+			results <- result{entries, err}
+		}()
+	}
+
+	// Wait for ISUPPORT to be processed.
+	for c.ISupport().EList == "" {
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	list()
+	server.expect("LIST")
+	server.send(":irc.example.com 321 nick Channel :Users  Name")
+	server.send(":irc.example.com 322 nick #channel 12 :[+nt] a topic")
+	server.send(":irc.example.com 322 nick #other 3 :")
+	server.send(":irc.example.com 323 nick :End of /LIST")
+
+	want := []ListEntry{
+		{Channel: "#channel", Users: 12, Topic: "[+nt] a topic"},
+		{Channel: "#other", Users: 3},
+	}
+
+	res := <-results
+	if res.err != nil || !reflect.DeepEqual(res.entries, want) {
+		t.Fatalf("List() = %v, %v, want %v", res.entries, res.err, want)
+	}
+
+	list(">10", "#chan*")
+	server.expect("LIST >10,#chan*")
+	server.send(":irc.example.com 322 nick #channel 12 :[+nt] a topic")
+	server.send(":irc.example.com 323 nick :End of /LIST")
+
+	res = <-results
+	if res.err != nil || !reflect.DeepEqual(res.entries, want[:1]) {
+		t.Fatalf("List() = %v, %v, want %v", res.entries, res.err, want[:1])
+	}
+
+	list("*")
+	server.expect("LIST *")
+	server.send(":irc.example.com 416 nick LIST :output too large, truncated")
+
+	res = <-results
+	if _, ok := res.err.(*ErrListFailed); !ok {
+		t.Fatalf("List() returned %v, want ErrListFailed", res.err)
+	}
+
+	if _, err := c.Commands.List(ctx, "T<60"); err == nil {
+		t.Fatal("List() with an unsupported ELIST pattern returned no error")
+	} else if _, ok := err.(*ErrListUnsupported); !ok {
+		t.Fatalf("List() returned %v, want ErrListUnsupported", err)
+	}
+}
+
+func TestElistExtension(t *testing.T) {
+	tests := []struct {
+		pattern string
+		want    string
+	}{
+		{pattern: "#channel", want: ""},
+		{pattern: "#chan*", want: "M"},
+		{pattern: "!#chan*", want: "N"},
+		{pattern: ">10", want: "U"},
+		{pattern: "<10", want: "U"},
+		{pattern: "C>60", want: "C"},
+		{pattern: "t<60", want: "T"},
+	}
+
+	for _, tt := range tests {
+		if got := elistExtension(tt.pattern); got != tt.want {
+			t.Errorf("elistExtension(%q) = %q, want %q", tt.pattern, got, tt.want)
+		}
+	}
+}
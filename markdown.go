@@ -0,0 +1,314 @@
+// Copyright (c) Liam Stanley <me@liamstanley.io>. All rights reserved. Use
+// of this source code is governed by the MIT license that can be found in
+// the LICENSE file.
+
+package girc
+
+import (
+	"bytes"
+	"strings"
+)
+
+const (
+	mdEscape = 0x5C // \
+	mdCode   = 0x60 // `
+	mdStar   = 0x2A // *
+	mdUnder  = 0x5F // _
+	mdTilde  = 0x7E // ~
+	mdBang   = 0x21 // !
+	mdOpen   = 0x5B // [
+	mdClose  = 0x5D // ]
+	mdLParen = 0x28 // (
+	mdRParen = 0x29 // )
+	mdLAngle = 0x3C // <
+	mdRAngle = 0x3E // >
+)
+
+// mdPunctuation are the characters which may be escaped with a backslash.
+const mdPunctuation = "\\`*_{}[]()#+-.!~<>|"
+
+// Markdown renders Markdown (e.g. a message template shared with Slack or
+// Discord outputs) as text with formatting codes. It's best-effort, and
+// supports the subset of Markdown which makes sense on IRC:
+//
+//	**bold** or __bold__          bold
+//	*italic* or _italic_          italic
+//	~~strikethrough~~             strikethrough
+//	`code`, and ``` blocks        monospace
+//	[text](url), ![alt](url)      "text (url)"
+//	<url>                         url
+//	# heading                     bold
+//	- item, * item or + item      "• item"
+//
+// Backslash escapes (e.g. "\*") are supported, and horizontal rules are
+// dropped. Newlines are kept, as chat services treat them as line breaks.
+// Long lines are split by Commands.Message, which continues the formatting
+// on each line. For example:
+//
+//	client.Commands.Message("#channel", girc.Markdown("**Build failed:** see [the log](https://ci.example.com/1)"))
+func Markdown(text string) string {
+	var out []string
+	fenced := false
+
+	for _, line := range strings.Split(strings.Replace(text, "\r\n", "\n", -1), "\n") {
+		trimmed := strings.TrimSpace(line)
+
+		if strings.HasPrefix(trimmed, "```") {
+			fenced = !fenced
+			continue
+		}
+
+		if fenced {
+			if line != "" {
+				out = append(out, string(fmtMonospace)+line+string(fmtMonospace))
+			}
+			continue
+		}
+
+		if isMarkdownRule(trimmed) {
+			continue
+		}
+
+		out = append(out, markdownLine(line))
+	}
+
+	return strings.Join(out, "\n")
+}
+
+// markdownLine renders a line which isn't part of a code block.
+func markdownLine(line string) string {
+	trimmed := strings.TrimLeft(line, " \t")
+	indent := line[:len(line)-len(trimmed)]
+
+	// Headings.
+	if level := len(trimmed) - len(strings.TrimLeft(trimmed, "#")); level > 0 && level <= 6 &&
+		(len(trimmed) == level || trimmed[level] == 0x20) {
+		heading := strings.TrimSpace(strings.TrimRight(trimmed[level:], "#"))
+		if heading == "" {
+			return ""
+		}
+
+		return string(fmtBold) + markdownInline(heading) + string(fmtBold)
+	}
+
+	// Unordered list items.
+	if len(trimmed) > 1 && strings.IndexByte("-*+", trimmed[0]) > -1 && trimmed[1] == 0x20 {
+		return indent + "• " + markdownInline(strings.TrimLeft(trimmed[2:], " "))
+	}
+
+	return indent + markdownInline(trimmed)
+}
+
+// isMarkdownRule returns true if line is a horizontal rule, e.g. "---".
+func isMarkdownRule(line string) bool {
+	line = strings.Replace(line, " ", "", -1)
+	if len(line) < 3 {
+		return false
+	}
+
+	return strings.Count(line, line[:1]) == len(line) && strings.IndexByte("-*_", line[0]) > -1
+}
+
+// markdownInline renders the inline formatting (emphasis, code and links)
+// of text.
+func markdownInline(text string) string {
+	var out bytes.Buffer
+
+	for i := 0; i < len(text); i++ {
+		ch := text[i]
+
+		switch {
+		case ch == mdEscape && i+1 < len(text) && strings.IndexByte(mdPunctuation, text[i+1]) > -1:
+			out.WriteByte(text[i+1])
+			i++
+		case ch == mdCode:
+			n := len(text[i:]) - len(strings.TrimLeft(text[i:], "`"))
+			delim := text[i : i+n]
+
+			end := strings.Index(text[i+n:], delim)
+			if end < 0 {
+				out.WriteString(delim)
+				i += n - 1
+				continue
+			}
+
+			code := text[i+n : i+n+end]
+			if len(code) > 1 && code[0] == 0x20 && code[len(code)-1] == 0x20 {
+				code = code[1 : len(code)-1]
+			}
+
+			out.WriteByte(fmtMonospace)
+			out.WriteString(code)
+			out.WriteByte(fmtMonospace)
+			i += n + end + n - 1
+		case ch == mdStar || ch == mdUnder || ch == mdTilde:
+			delim, code := markdownDelim(text[i:])
+			if code == 0 || (ch == mdUnder && i > 0 && isWordByte(text[i-1])) {
+				out.WriteByte(ch)
+				continue
+			}
+
+			end := markdownClose(text, i+len(delim), delim)
+			if end < 0 {
+				// Write the whole delimiter, so "**" isn't taken as two "*".
+				out.WriteString(delim)
+				i += len(delim) - 1
+				continue
+			}
+
+			out.WriteByte(code)
+			out.WriteString(markdownInline(text[i+len(delim) : end]))
+			out.WriteByte(code)
+			i = end + len(delim) - 1
+		case ch == mdBang && i+1 < len(text) && text[i+1] == mdOpen:
+			label, url, n := markdownLink(text[i+1:])
+			if n == 0 {
+				out.WriteByte(ch)
+				continue
+			}
+
+			writeMarkdownLink(&out, label, url)
+			i += n
+		case ch == mdOpen:
+			label, url, n := markdownLink(text[i:])
+			if n == 0 {
+				out.WriteByte(ch)
+				continue
+			}
+
+			writeMarkdownLink(&out, label, url)
+			i += n - 1
+		case ch == mdLAngle:
+			end := strings.IndexByte(text[i:], mdRAngle)
+			url := ""
+			if end > 0 {
+				url = text[i+1 : i+end]
+			}
+
+			if start, stop := findURL(url); start != 0 || stop != len(url) {
+				out.WriteByte(ch)
+				continue
+			}
+
+			out.WriteString(url)
+			i += end
+		default:
+			out.WriteByte(ch)
+		}
+	}
+
+	return out.String()
+}
+
+// markdownDelim returns the emphasis delimiter at the start of text, and the
+// formatting code it stands for. code is 0 if there's no delimiter.
+func markdownDelim(text string) (delim string, code byte) {
+	switch {
+	case strings.HasPrefix(text, "**") || strings.HasPrefix(text, "__"):
+		return text[:2], fmtBold
+	case strings.HasPrefix(text, "~~"):
+		return text[:2], fmtStrikethrough
+	case text[0] == mdStar || text[0] == mdUnder:
+		return text[:1], fmtItalic
+	}
+
+	return "", 0
+}
+
+// markdownClose returns the index of the delimiter closing emphasis which
+// was opened with delim, with its content starting at start. Returns -1 if
+// the emphasis isn't closed.
+func markdownClose(text string, start int, delim string) int {
+	// Emphasis can't start with whitespace.
+	if start >= len(text) || text[start] == 0x20 {
+		return -1
+	}
+
+	for i := start + 1; i+len(delim) <= len(text); i++ {
+		switch {
+		case text[i] == mdEscape:
+			i++
+			continue
+		case text[i] == mdCode:
+			// Delimiters within code don't count.
+			n := len(text[i:]) - len(strings.TrimLeft(text[i:], "`"))
+			if end := strings.Index(text[i+n:], text[i:i+n]); end > -1 {
+				i += n + end + n - 1
+			}
+			continue
+		case !strings.HasPrefix(text[i:], delim):
+			continue
+		}
+
+		end := i + len(delim)
+		if len(delim) == 1 && end < len(text) && text[end] == delim[0] {
+			// Part of a double delimiter, e.g. "*a **b** c*", so skip to the
+			// end of its content if it's opening.
+			if text[i-1] == 0x20 {
+				if closing := markdownClose(text, end+1, delim+delim); closing > -1 {
+					end = closing + 1
+				}
+			}
+
+			i = end
+			continue
+		}
+
+		// Closing delimiters can't follow whitespace.
+		if text[i-1] == 0x20 || delim[0] == mdUnder && end < len(text) && isWordByte(text[end]) {
+			continue
+		}
+
+		return i
+	}
+
+	return -1
+}
+
+// markdownLink parses a link, "[label](url)", at the start of text.
+// Returns the length of the link, or 0 if text doesn't start with one.
+func markdownLink(text string) (label, url string, n int) {
+	end := strings.IndexByte(text, mdClose)
+	if end < 0 || end+1 >= len(text) || text[end+1] != mdLParen {
+		return "", "", 0
+	}
+
+	// URLs may contain balanced parentheses.
+	depth := 0
+	for i := end + 2; i < len(text); i++ {
+		switch text[i] {
+		case mdLParen:
+			depth++
+		case mdRParen:
+			if depth > 0 {
+				depth--
+				continue
+			}
+
+			url = strings.TrimSpace(text[end+2 : i])
+			// Drop the title, e.g. [label](url "title").
+			if j := strings.IndexByte(url, 0x20); j > -1 {
+				url = url[:j]
+			}
+
+			return text[1:end], strings.Trim(url, "<>"), i + 1
+		}
+	}
+
+	return "", "", 0
+}
+
+// writeMarkdownLink writes a link as "label (url)", or only the URL if it's
+// the same as the label.
+func writeMarkdownLink(out *bytes.Buffer, label, url string) {
+	rendered := markdownInline(label)
+
+	switch {
+	case url == "" || url == label:
+		out.WriteString(rendered)
+	case label == "":
+		out.WriteString(url)
+	default:
+		out.WriteString(rendered + " (" + url + ")")
+	}
+}
@@ -0,0 +1,42 @@
+// Copyright (c) Liam Stanley <me@liamstanley.io>. All rights reserved. Use
+// of this source code is governed by the MIT license that can be found in
+// the LICENSE file.
+
+package girc
+
+import "testing"
+
+func TestMarkdown(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		want string
+	}{
+		{name: "plain", text: "this is a test.", want: "this is a test."},
+		{name: "bold", text: "**bold** and __bold__", want: "\x02bold\x02 and \x02bold\x02"},
+		{name: "italic", text: "*italic* and _italic_", want: "\x1ditalic\x1d and \x1ditalic\x1d"},
+		{name: "nested", text: "*a **b** c*", want: "\x1da \x02b\x02 c\x1d"},
+		{name: "strike", text: "~~no~~ ~yes~", want: "\x1eno\x1e ~yes~"},
+		{name: "code", text: "run `make *all*` now", want: "run \x11make *all*\x11 now"},
+		{name: "double code", text: "``a ` b``", want: "\x11a ` b\x11"},
+		{name: "unclosed", text: "2 * 3 = 6, **oops", want: "2 * 3 = 6, **oops"},
+		{name: "snake case", text: "some_var_name and _it_", want: "some_var_name and \x1dit\x1d"},
+		{name: "escape", text: `\*not italic\*`, want: "*not italic*"},
+		{name: "link", text: "see [the **log**](https://x.org/a_(b))!", want: "see the \x02log\x02 (https://x.org/a_(b))!"},
+		{name: "bare link", text: "[https://x.org](https://x.org)", want: "https://x.org"},
+		{name: "title", text: `[x](https://x.org "title")`, want: "x (https://x.org)"},
+		{name: "image", text: "![logo](https://x.org/logo.png)", want: "logo (https://x.org/logo.png)"},
+		{name: "autolink", text: "<https://x.org> <b>", want: "https://x.org <b>"},
+		{name: "not link", text: "[a] (b)", want: "[a] (b)"},
+		{name: "heading", text: "## Status ##\n#channel", want: "\x02Status\x02\n#channel"},
+		{name: "list", text: "- one\n  * two\n+ three", want: "• one\n  • two\n• three"},
+		{name: "rule", text: "a\n---\nb", want: "a\nb"},
+		{name: "fenced", text: "```go\nfmt.Println(\"*hi*\")\n\n```\nafter", want: "\x11fmt.Println(\"*hi*\")\x11\nafter"},
+	}
+
+	for _, tt := range tests {
+		if got := Markdown(tt.text); got != tt.want {
+			t.Errorf("%s: Markdown(%q) = %q, want %q", tt.name, tt.text, got, tt.want)
+		}
+	}
+}
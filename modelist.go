@@ -0,0 +1,256 @@
+// Copyright (c) Liam Stanley <me@liamstanley.io>. All rights reserved. Use
+// of this source code is governed by the MIT license that can be found in
+// the LICENSE file.
+
+package girc
+
+import (
+	"strconv"
+	"sync"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+// ModeListEntry is a single entry of a channel list mode, like a ban, ban
+// exception, or invite exception.
+type ModeListEntry struct {
+	// Mask is the mask being matched, e.g. "*!*@example.com".
+	Mask string
+	// SetBy is who set the entry, which is either a nickname or a full
+	// "nick!user@host" mask, depending on the server. May be empty if
+	// unknown.
+	SetBy string
+	// SetAt is when the entry was set. May be zero if unknown.
+	SetAt time.Time
+}
+
+// ErrModeListFailed is returned when the server refuses to send a channel
+// list mode, e.g. when we aren't an operator in the channel, and the server
+// only allows operators to view the list.
+type ErrModeListFailed struct {
+	// Channel is the channel the list was requested for.
+	Channel string
+	// Mode is the list mode which was requested, e.g. "b".
+	Mode string
+	// Code is the numeric the server responded with.
+	Code string
+	// Reason is the reason the server supplied, if any.
+	Reason string
+}
+
+func (e *ErrModeListFailed) Error() string {
+	return "unable to fetch +" + e.Mode + " list for " + e.Channel + " (" + e.Code + "): " + e.Reason
+}
+
+// Unwrap returns the error for Code, if any (see NumericError).
+func (e *ErrModeListFailed) Unwrap() error { return NumericError(e.Code) }
+
+// Bans returns the known bans (+b) of the channel. This is only complete
+// once the list has been fetched (see Client.FetchBanList), after which it
+// is kept up to date with MODE changes.
+func (c *Channel) Bans() []ModeListEntry {
+	return c.ModeList(ModeBan)
+}
+
+// Exceptions returns the known ban exceptions (+e) of the channel. See Bans
+// and Client.FetchExceptList for more info.
+func (c *Channel) Exceptions() []ModeListEntry {
+	return c.ModeList(ModeException)
+}
+
+// InviteExceptions returns the known invite exceptions (+I) of the channel.
+// See Bans and Client.FetchInviteList for more info.
+func (c *Channel) InviteExceptions() []ModeListEntry {
+	return c.ModeList(ModeInviteException)
+}
+
+// ModeList returns the known entries of the given list mode (e.g. "b").
+func (c *Channel) ModeList(mode string) []ModeListEntry {
+	if len(mode) != 1 {
+		return nil
+	}
+
+	return append([]ModeListEntry(nil), c.lists[mode[0]]...)
+}
+
+// addListEntry adds (or replaces) an entry to a list mode. Masks are compared
+// with casemapping. Always use state.mu for transaction.
+func (c *Channel) addListEntry(casemapping string, mode byte, entry ModeListEntry) {
+	if c.lists == nil {
+		c.lists = make(map[byte][]ModeListEntry)
+	}
+
+	c.removeListEntry(casemapping, mode, entry.Mask)
+	c.lists[mode] = append(c.lists[mode], entry)
+}
+
+// removeListEntry removes an entry from a list mode. Masks are compared with
+// casemapping. Always use state.mu for transaction.
+func (c *Channel) removeListEntry(casemapping string, mode byte, mask string) {
+	list := c.lists[mode]
+	mask = ToLower(casemapping, mask)
+
+	for i := 0; i < len(list); i++ {
+		if ToLower(casemapping, list[i].Mask) == mask {
+			c.lists[mode] = append(list[:i:i], list[i+1:]...)
+			return
+		}
+	}
+}
+
+// listMode returns the list mode character for a list numeric (e.g.
+// RPL_BANLIST or RPL_ENDOFBANLIST), taking EXCEPTS and INVEX into account.
+func (s *state) listMode(numeric string) byte {
+	switch numeric {
+	case RPL_EXCEPTLIST, RPL_ENDOFEXCEPTLIST:
+		if mode := s.isupport.Excepts; len(mode) == 1 {
+			return mode[0]
+		}
+
+		return ModeException[0]
+	case RPL_INVITELIST, RPL_ENDOFINVITELIST:
+		if mode := s.isupport.Invex; len(mode) == 1 {
+			return mode[0]
+		}
+
+		return ModeInviteException[0]
+	}
+
+	return ModeBan[0]
+}
+
+// parseModeListEntry parses a RPL_BANLIST, RPL_EXCEPTLIST or RPL_INVITELIST
+// numeric, returning the channel and entry.
+func parseModeListEntry(e Event) (channel string, entry ModeListEntry, ok bool) {
+	// <nick> <channel> <mask> [<setter> <timestamp>].
+	if len(e.Params) < 3 {
+		return "", entry, false
+	}
+
+	entry.Mask = e.Params[2]
+
+	if len(e.Params) >= 5 {
+		entry.SetBy = e.Params[3]
+
+		if ts, err := strconv.ParseInt(e.Params[4], 10, 64); err == nil {
+			entry.SetAt = time.Unix(ts, 0)
+		}
+	}
+
+	return e.Params[1], entry, true
+}
+
+// handleModeList handles the entries of list modes (e.g. RPL_BANLIST), and
+// the end of those lists, replacing the tracked list once it has ended.
+func handleModeList(c *Client, e Event) {
+	if len(e.Params) < 2 {
+		return
+	}
+
+	c.state.mu.Lock()
+	defer c.state.mu.Unlock()
+
+	channel := c.state.lookupChannel(e.Params[1])
+	if channel == nil {
+		return
+	}
+
+	mode := c.state.listMode(e.Command)
+	if channel.listBuf == nil {
+		channel.listBuf = make(map[byte][]ModeListEntry)
+	}
+
+	switch e.Command {
+	case RPL_ENDOFBANLIST, RPL_ENDOFEXCEPTLIST, RPL_ENDOFINVITELIST:
+		if channel.lists == nil {
+			channel.lists = make(map[byte][]ModeListEntry)
+		}
+
+		channel.lists[mode] = channel.listBuf[mode]
+		delete(channel.listBuf, mode)
+	default:
+		if _, entry, ok := parseModeListEntry(e); ok {
+			channel.listBuf[mode] = append(channel.listBuf[mode], entry)
+		}
+	}
+}
+
+// FetchBanList requests the ban list (+b) of the channel from the server,
+// and waits for it to be received (or ctx to be done). If tracking is
+// enabled, the list is also stored in state, see Channel.Bans. If the
+// server refuses to send the list, ErrModeListFailed is returned.
+func (c *Client) FetchBanList(ctx context.Context, channel string) ([]ModeListEntry, error) {
+	return c.fetchModeList(ctx, channel, RPL_BANLIST, RPL_ENDOFBANLIST)
+}
+
+// FetchExceptList is like FetchBanList, but for ban exceptions (+e).
+func (c *Client) FetchExceptList(ctx context.Context, channel string) ([]ModeListEntry, error) {
+	return c.fetchModeList(ctx, channel, RPL_EXCEPTLIST, RPL_ENDOFEXCEPTLIST)
+}
+
+// FetchInviteList is like FetchBanList, but for invite exceptions (+I).
+func (c *Client) FetchInviteList(ctx context.Context, channel string) ([]ModeListEntry, error) {
+	return c.fetchModeList(ctx, channel, RPL_INVITELIST, RPL_ENDOFINVITELIST)
+}
+
+// fetchModeList requests a list mode of a channel, collecting all entries
+// (list numerics) until the end numeric is received.
+func (c *Client) fetchModeList(ctx context.Context, channel, list, end string) ([]ModeListEntry, error) {
+	if !IsValidChannel(channel) {
+		return nil, &ErrInvalidTarget{Target: channel}
+	}
+
+	c.state.mu.RLock()
+	mode := string(c.state.listMode(list))
+	id := c.state.toLower(channel)
+	c.state.mu.RUnlock()
+
+	var mu sync.Mutex
+	var entries []ModeListEntry
+	done := make(chan error, 1)
+
+	cuid := c.Handlers.sregisterOwned(ALLEVENTS, HandlerFunc(func(client *Client, e Event) {
+		if len(e.Params) < 2 || client.toLower(e.Params[1]) != id {
+			return
+		}
+
+		var err error
+		switch e.Command {
+		case list:
+			if _, entry, ok := parseModeListEntry(e); ok {
+				mu.Lock()
+				entries = append(entries, entry)
+				mu.Unlock()
+			}
+			return
+		case end:
+		case ERR_NOSUCHCHANNEL, ERR_NOTONCHANNEL, ERR_CHANOPRIVSNEEDED:
+			err = &ErrModeListFailed{Channel: channel, Mode: mode, Code: e.Command, Reason: e.Trailing}
+		default:
+			return
+		}
+
+		select {
+		case done <- err:
+		default:
+			// Already finished.
+		}
+	}))
+	defer c.Handlers.Remove(cuid)
+
+	c.Send(&Event{Command: MODE, Params: []string{channel, "+" + mode}})
+
+	select {
+	case err := <-done:
+		if err != nil {
+			return nil, err
+		}
+
+		mu.Lock()
+		defer mu.Unlock()
+		return entries, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
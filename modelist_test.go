@@ -0,0 +1,98 @@
+// Copyright (c) Liam Stanley <me@liamstanley.io>. All rights reserved. Use
+// of this source code is governed by the MIT license that can be found in
+// the LICENSE file.
+
+package girc
+
+import (
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+func TestFetchBanList(t *testing.T) {
+	c, server := mockClient(t, Config{RateBurst: 10})
+	defer c.Stop()
+
+	server.send(":irc.example.com 001 nick :Welcome")
+	server.send(":nick!user@host JOIN #channel")
+	server.expect("MODE #channel")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	type result struct {
+		entries []ModeListEntry
+		err     error
+	}
+	results := make(chan result, 1)
+
+	go func() {
+		entries, err := c.FetchBanList(ctx, "#Channel")
+		results <- result{entries, err}
+	}()
+
+	server.expect("MODE #Channel +b")
+	server.send(":irc.example.com 367 nick #channel *!*@spam op!user@host 1500000000")
+	server.send(":irc.example.com 367 nick #other *!*@elsewhere")
+	server.send(":irc.example.com 367 nick #channel *!*@abuse")
+	server.send(":irc.example.com 368 nick #channel :End of Channel Ban List")
+
+	res := <-results
+	if res.err != nil {
+		t.Fatalf("FetchBanList() returned error: %s", res.err)
+	}
+
+	want := []ModeListEntry{
+		{Mask: "*!*@spam", SetBy: "op!user@host", SetAt: time.Unix(1500000000, 0)},
+		{Mask: "*!*@abuse"},
+	}
+
+	if len(res.entries) != len(want) {
+		t.Fatalf("FetchBanList() returned %v, want %v", res.entries, want)
+	}
+
+	for i := range want {
+		if res.entries[i].Mask != want[i].Mask || res.entries[i].SetBy != want[i].SetBy || !res.entries[i].SetAt.Equal(want[i].SetAt) {
+			t.Errorf("FetchBanList()[%d] = %v, want %v", i, res.entries[i], want[i])
+		}
+	}
+
+	// Keep the list updated with MODE changes.
+	server.send(":op!user@host MODE #channel -b+b *!*@spam *!*@new")
+
+	go func() {
+		entries, err := c.FetchExceptList(ctx, "#channel")
+		results <- result{entries, err}
+	}()
+
+	server.expect("MODE #channel +e")
+	server.send(":irc.example.com 482 nick #channel :You're not a channel operator")
+
+	res = <-results
+	if _, ok := res.err.(*ErrModeListFailed); !ok {
+		t.Fatalf("FetchExceptList() returned %v, want ErrModeListFailed", res.err)
+	}
+
+	bans := c.Lookup("#channel").Bans()
+	if len(bans) != 2 || bans[0].Mask != "*!*@abuse" || bans[1].Mask != "*!*@new" || bans[1].SetBy != "op!user@host" {
+		t.Fatalf("Channel.Bans() = %v, want *!*@abuse and *!*@new", bans)
+	}
+}
+
+func TestModeListCasemapping(t *testing.T) {
+	ch := &Channel{}
+	ch.addListEntry(CaseMappingRFC1459, 'b', ModeListEntry{Mask: "*!*@a[b]"})
+	ch.removeListEntry(CaseMappingRFC1459, 'b', "*!*@A{B}")
+	if len(ch.lists['b']) != 0 {
+		t.Fatalf("lists = %v, want *!*@a[b] removed with rfc1459", ch.lists['b'])
+	}
+
+	ch.addListEntry(CaseMappingASCII, 'b', ModeListEntry{Mask: "*!*@a[b]"})
+	ch.addListEntry(CaseMappingASCII, 'b', ModeListEntry{Mask: "*!*@a{b}"})
+	ch.removeListEntry(CaseMappingASCII, 'b', "*!*@A[B]")
+	if len(ch.lists['b']) != 1 || ch.lists['b'][0].Mask != "*!*@a{b}" {
+		t.Fatalf("lists = %v, want only *!*@a{b} with ascii", ch.lists['b'])
+	}
+}
@@ -0,0 +1,152 @@
+// Copyright (c) Liam Stanley <me@liamstanley.io>. All rights reserved. Use
+// of this source code is governed by the MIT license that can be found in
+// the LICENSE file.
+
+package girc
+
+import (
+	"errors"
+	"strings"
+)
+
+// defaultModes is the amount of channel modes with a parameter which can be
+// sent in a single MODE command, if the server doesn't advertise MODES (RFC
+// 2812).
+const defaultModes = 3
+
+// ErrQuietUnsupported is returned by Commands.Quiet and Commands.Unquiet
+// when the server supports neither a quiet list mode (+q), nor a quiet
+// extended ban (e.g. "~q:").
+var ErrQuietUnsupported = errors.New("server does not support quiets")
+
+// KickBan bans user from channel, with a ban mask in the given style (see
+// Client.BanMask), and kicks them with the given reason, which may be
+// empty. The ban is set first, so the user is unable to rejoin.
+func (cmd *Commands) KickBan(channel string, user *User, reason string, style BanStyle) error {
+	if !IsValidChannel(channel) {
+		return &ErrInvalidTarget{Target: channel}
+	}
+
+	if user == nil || !IsValidNick(user.Nick) {
+		var nick string
+		if user != nil {
+			nick = user.Nick
+		}
+
+		return &ErrInvalidTarget{Target: nick}
+	}
+
+	cmd.c.Send(&Event{Command: MODE, Params: []string{channel, "+" + ModeBan, cmd.c.BanMask(user, style)}})
+
+	return cmd.Kick(channel, user.Nick, reason)
+}
+
+// Unban removes every ban of channel which matches user (see
+// Client.MatchBans), as known from the tracked ban list of the channel (see
+// Channel.Bans and Client.FetchBanList), returning the masks which were
+// removed. Panics if tracking is disabled.
+func (cmd *Commands) Unban(channel string, user *User) (masks []string, err error) {
+	cmd.c.panicIfNotTracking()
+
+	if !IsValidChannel(channel) {
+		return nil, &ErrInvalidTarget{Target: channel}
+	}
+
+	if user == nil {
+		return nil, nil
+	}
+
+	cmd.c.state.mu.RLock()
+	var bans []ModeListEntry
+	if ch := cmd.c.state.lookupChannel(channel); ch != nil {
+		bans = ch.Bans()
+	}
+	cmd.c.state.mu.RUnlock()
+
+	for len(bans) > 0 {
+		ban, ok := cmd.c.MatchBans(user, bans)
+		if !ok {
+			break
+		}
+
+		masks = append(masks, ban.Mask)
+		for i := 0; i < len(bans); i++ {
+			if bans[i].Mask == ban.Mask {
+				bans = append(bans[:i:i], bans[i+1:]...)
+				break
+			}
+		}
+	}
+
+	cmd.setListModes(channel, "-", ModeBan, masks)
+
+	return masks, nil
+}
+
+// Quiet mutes mask (e.g. "*!*@example.com", see Client.BanMask) in channel,
+// preventing matching users from speaking, without removing them from the
+// channel. The quiet list mode (+q) is used where supported, falling back
+// to the quiet extended ban (e.g. "+b ~q:mask"). If neither is supported,
+// ErrQuietUnsupported is returned.
+func (cmd *Commands) Quiet(channel, mask string) error {
+	return cmd.quiet(channel, mask, "+")
+}
+
+// Unquiet removes a quiet for mask set with Quiet. See Quiet for more info.
+func (cmd *Commands) Unquiet(channel, mask string) error {
+	return cmd.quiet(channel, mask, "-")
+}
+
+// quiet adds or removes a quiet.
+func (cmd *Commands) quiet(channel, mask, action string) error {
+	if !IsValidChannel(channel) {
+		return &ErrInvalidTarget{Target: channel}
+	}
+
+	if mask == "" {
+		return &ErrInvalidTarget{Target: mask}
+	}
+
+	cmd.c.state.mu.RLock()
+	isupport := cmd.c.state.isupport
+	cmd.c.state.mu.RUnlock()
+
+	// On servers with the +q list mode (e.g. Solanum), q isn't a channel
+	// user mode (e.g. owner).
+	mode := ModeQuiet
+	if !strings.Contains(isupport.ChanModes.A, ModeQuiet) || strings.Contains(isupport.PrefixModes, ModeQuiet) {
+		extban, ok := isupport.ExtBan(ExtBanQuiet, mask)
+		if !ok {
+			return ErrQuietUnsupported
+		}
+
+		mode, mask = ModeBan, extban
+	}
+
+	cmd.c.Send(&Event{Command: MODE, Params: []string{channel, action + mode, mask}})
+	return nil
+}
+
+// setListModes adds (action "+") or removes (action "-") masks from a list
+// mode of channel, using as few MODE commands as the server allows.
+func (cmd *Commands) setListModes(channel, action, mode string, masks []string) {
+	cmd.c.state.mu.RLock()
+	limit := cmd.c.state.isupport.Modes
+	if _, ok := cmd.c.state.isupport.Get("MODES"); !ok {
+		limit = defaultModes
+	}
+	cmd.c.state.mu.RUnlock()
+
+	for len(masks) > 0 {
+		n := len(masks)
+		if limit > 0 && n > limit {
+			n = limit
+		}
+
+		cmd.c.Send(&Event{
+			Command: MODE,
+			Params:  append([]string{channel, action + strings.Repeat(mode, n)}, masks[:n]...),
+		})
+		masks = masks[n:]
+	}
+}
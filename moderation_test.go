@@ -0,0 +1,87 @@
+// Copyright (c) Liam Stanley <me@liamstanley.io>. All rights reserved. Use
+// of this source code is governed by the MIT license that can be found in
+// the LICENSE file.
+
+package girc
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestKickBanUnban(t *testing.T) {
+	c, server := mockClient(t, Config{AllowFlood: true})
+	defer c.Stop()
+
+	server.send(":irc.example.com 001 nick :Welcome")
+	server.send(":irc.example.com 005 nick EXTBAN=$,acjrxz MODES=2 :are supported by this server")
+	server.send(":nick!user@host JOIN #chan")
+	server.send(":bad!~bad@evil.example.com JOIN #chan")
+	server.send(":irc.example.com 352 nick #chan ~bad evil.example.com irc.example.com bad H :0 Bad")
+	server.send("PING :sync")
+	server.expect("PONG sync")
+
+	user, ok := c.LookupUser("bad")
+	if !ok {
+		t.Fatal("user wasn't tracked")
+	}
+
+	if err := c.Commands.KickBan("#chan", user, "bye", BanHost); err != nil {
+		t.Fatalf("KickBan() = %s", err)
+	}
+	server.expect("MODE #chan +b *!*@evil.example.com")
+	server.expect("KICK #chan bad :bye")
+
+	if err := c.Commands.KickBan("#chan", nil, "", BanHost); err == nil {
+		t.Error("KickBan() without a user didn't fail")
+	}
+
+	server.send(":nick!user@host MODE #chan +bbbb *!*@evil.example.com *!*@good.example.com bad!*@* $c:#other")
+	server.send("PING :sync")
+	server.expect("PONG sync")
+
+	masks, err := c.Commands.Unban("#chan", user)
+	if err != nil {
+		t.Fatalf("Unban() = %s", err)
+	}
+
+	if want := []string{"*!*@evil.example.com", "bad!*@*"}; !reflect.DeepEqual(masks, want) {
+		t.Errorf("Unban() = %q, want %q", masks, want)
+	}
+	server.expect("MODE #chan -bb *!*@evil.example.com bad!*@*")
+}
+
+func TestQuiet(t *testing.T) {
+	tests := []struct {
+		isupport string
+		want     string
+		err      error
+	}{
+		{isupport: "CHANMODES=eIbq,k,flj,CFLMPQScgimnprstuz", want: "MODE #chan +q *!*@host"},
+		{isupport: "PREFIX=(qaohv)~&@%+ CHANMODES=beI,kLf,l,psmntirzMQNRTOVKDdGPZSCc EXTBAN=~,acfjmnpqrtCGOST", want: "MODE #chan +b ~q:*!*@host"},
+		{isupport: "PREFIX=(qaohv)~&@%+", err: ErrQuietUnsupported},
+	}
+
+	for _, tt := range tests {
+		c, server := mockClient(t, Config{AllowFlood: true})
+
+		server.send(":irc.example.com 005 nick " + tt.isupport + " :are supported by this server")
+		server.send("PING :sync")
+		server.expect("PONG sync")
+
+		if err := c.Commands.Quiet("#chan", "*!*@host"); err != tt.err {
+			t.Errorf("Quiet() with %s = %v, want %v", tt.isupport, err, tt.err)
+		}
+
+		if tt.want != "" {
+			server.expect(tt.want)
+
+			if err := c.Commands.Unquiet("#chan", "*!*@host"); err != nil {
+				t.Errorf("Unquiet() with %s = %v", tt.isupport, err)
+			}
+			server.expect(tt.want[:11] + "-" + tt.want[12:])
+		}
+
+		c.Stop()
+	}
+}
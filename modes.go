@@ -4,7 +4,11 @@
 
 package girc
 
-import "strings"
+import (
+	"strconv"
+	"strings"
+	"time"
+)
 
 // CMode represents a single step of a given mode change.
 type CMode struct {
@@ -86,7 +90,7 @@ func (c *CModes) String() string {
 	return out + args
 }
 
-// HasMode checks if the CModes state has a given mode. E.g. "m", or "I".
+// HasMode checks if the CModes state has a given mode. E.g. "m", or "k".
 func (c *CModes) HasMode(mode string) bool {
 	for i := 0; i < len(c.modes); i++ {
 		if string(c.modes[i].name) == mode {
@@ -113,6 +117,12 @@ func (c *CModes) Get(mode string) (args string, ok bool) {
 	return "", false
 }
 
+// isList checks to see if the mode is a list mode (CHANMODES type A), e.g.
+// "b".
+func (c *CModes) isList(mode byte) bool {
+	return strings.IndexByte(c.modesListArgs, mode) > -1
+}
+
 // hasArg checks to see if the mode supports arguments. What ones support this?:
 //   A = Mode that adds or removes a nick or address to a list. Always has a parameter.
 //   B = Mode that changes a setting and always has a parameter.
@@ -149,49 +159,55 @@ func (c *CModes) hasArg(set bool, mode byte) (hasArgs, isSetting bool) {
 	return false, true
 }
 
-// Apply merges two state changes, or one state change into a state of modes.
-// For example, the latter would mean applying an incoming MODE with the modes
-// stored for a channel.
-func (c *CModes) Apply(modes []CMode) {
-	var new []CMode
+// Key returns the channel key (+k), if one is set.
+func (c *CModes) Key() string {
+	key, _ := c.Get(ModeKey)
+	return key
+}
 
-	for j := 0; j < len(c.modes); j++ {
-		isin := false
-		for i := 0; i < len(modes); i++ {
-			if !modes[i].setting {
-				continue
-			}
-			if c.modes[j].name == modes[i].name && modes[i].add {
-				new = append(new, modes[i])
-				isin = true
-				break
-			}
-		}
+// Limit returns the channel user limit (+l), or 0 if no limit is set.
+func (c *CModes) Limit() int {
+	raw, ok := c.Get(ModeLimit)
+	if !ok {
+		return 0
+	}
 
-		if !isin {
-			new = append(new, c.modes[j])
-		}
+	limit, err := strconv.Atoi(raw)
+	if err != nil || limit < 0 {
+		return 0
 	}
 
+	return limit
+}
+
+// Apply merges two state changes, or one state change into a state of modes.
+// For example, the latter would mean applying an incoming MODE with the modes
+// stored for a channel. Only settings (CHANMODES type B, C and D) are stored;
+// list modes (type A) and user prefix modes (PREFIX) are ignored. Adding a
+// setting which is already set (e.g. a new +k) replaces the existing one,
+// and removing a setting removes it, regardless of its arguments.
+func (c *CModes) Apply(modes []CMode) {
+	var applied []CMode
+	applied = append(applied, c.modes...)
+
 	for i := 0; i < len(modes); i++ {
-		if !modes[i].setting || !modes[i].add {
+		if !modes[i].setting {
 			continue
 		}
 
-		isin := false
-		for j := 0; j < len(new); j++ {
-			if modes[i].name == new[j].name {
-				isin = true
+		for j := 0; j < len(applied); j++ {
+			if applied[j].name == modes[i].name {
+				applied = append(applied[:j], applied[j+1:]...)
 				break
 			}
 		}
 
-		if !isin {
-			new = append(new, modes[i])
+		if modes[i].add {
+			applied = append(applied, modes[i])
 		}
 	}
 
-	c.modes = new
+	c.modes = applied
 }
 
 // Parse parses a set of flags and args, returning the necessary list of
@@ -343,17 +359,37 @@ func handleMODE(c *Client, e Event) {
 	}
 
 	modes := channel.Modes.Parse(flags, args)
+	if e.Command == RPL_CHANNELMODEIS {
+		// RPL_CHANNELMODEIS is the full list of current settings, rather
+		// than a change to them.
+		channel.Modes.modes = nil
+	}
 	channel.Modes.Apply(modes)
 
-	// Loop through and update users modes as necessary.
+	// Loop through and update users modes and mode lists as necessary.
 	for i := 0; i < len(modes); i++ {
 		if modes[i].setting || len(modes[i].args) == 0 {
 			continue
 		}
 
-		users := c.state.lookupUsers("nick", modes[i].args)
-		for j := 0; j < len(users); j++ {
-			users[j].Perms.setFromMode(modes[i])
+		if channel.Modes.isList(modes[i].name) {
+			if !modes[i].add {
+				channel.removeListEntry(c.state.isupport.Casemapping, modes[i].name, modes[i].args)
+				continue
+			}
+
+			entry := ModeListEntry{Mask: modes[i].args, SetAt: time.Now()}
+			if e.Source != nil {
+				entry.SetBy = e.Source.String()
+			}
+			channel.addListEntry(c.state.isupport.Casemapping, modes[i].name, entry)
+			continue
+		}
+
+		// Only update the user in this channel, as permissions are
+		// per-channel.
+		if user := channel.Lookup(modes[i].args); user != nil {
+			user.Perms.setFromMode(modes[i])
 		}
 	}
 
@@ -363,27 +399,23 @@ func handleMODE(c *Client, e Event) {
 // chanModes returns the ISUPPORT list of server-supported channel modes,
 // alternatively falling back to ModeDefaults.
 func (s *state) chanModes() string {
-	if modes, ok := s.serverOptions["CHANMODES"]; ok && IsValidChannelMode(modes) {
-		return modes
-	}
+	modes := s.isupport.ChanModes
 
-	return ModeDefaults
+	return modes.A + "," + modes.B + "," + modes.C + "," + modes.D
 }
 
 // userPrefixes returns the ISUPPORT list of server-supported user prefixes.
 // This includes mode characters, as well as user prefix symbols. Falls back
 // to DefaultPrefixes if not server-supported.
 func (s *state) userPrefixes() string {
-	if prefix, ok := s.serverOptions["PREFIX"]; ok && isValidUserPrefix(prefix) {
-		return prefix
-	}
-
-	return DefaultPrefixes
+	return "(" + s.isupport.PrefixModes + ")" + s.isupport.PrefixSymbols
 }
 
 // UserPerms contains all channel-based user permissions. The minimum op, and
 // voice should be supported on all networks. This also supports non-rfc
-// Owner, Admin, and HalfOp, if the network has support for it.
+// Owner, Admin, and HalfOp, if the network has support for it. Any other
+// (network-specific) prefixes the server advertises in PREFIX can be checked
+// with HasMode.
 type UserPerms struct {
 	// Owner (non-rfc) indicates that the user has full permissions to the
 	// channel. More than one user can have owner permission.
@@ -400,6 +432,9 @@ type UserPerms struct {
 	// Voice indicates the user has voice permissions, commonly given to known
 	// users, with very light trust, or to indicate a user is active.
 	Voice bool
+
+	// modes are the channel user mode characters (e.g. "ov") the user has.
+	modes string
 }
 
 // IsAdmin indicates that the user has banning abilities, and are likely a
@@ -412,44 +447,50 @@ func (m UserPerms) IsAdmin() bool {
 	return false
 }
 
+// IsOp indicates that the user has operator (+o) permissions.
+func (m UserPerms) IsOp() bool {
+	return m.Op
+}
+
+// IsVoice indicates that the user has voice (+v) permissions.
+func (m UserPerms) IsVoice() bool {
+	return m.Voice
+}
+
 // IsTrusted indicates that the user at least has modes set upon them, higher
 // than a regular joining user.
 func (m UserPerms) IsTrusted() bool {
-	if m.IsAdmin() || m.HalfOp || m.Voice {
+	if m.IsAdmin() || m.HalfOp || m.Voice || m.modes != "" {
 		return true
 	}
 
 	return false
 }
 
+// HasMode checks if the user has the given channel user mode character.
+// E.g. 'o', or 'Y' on networks which have custom prefixes.
+func (m UserPerms) HasMode(mode rune) bool {
+	return strings.ContainsRune(m.modes, mode)
+}
+
 // reset resets the modes of a user.
 func (m *UserPerms) reset() {
-	m.Owner = false
-	m.Admin = false
-	m.Op = false
-	m.HalfOp = false
-	m.Voice = false
+	*m = UserPerms{}
 }
 
-// set translates raw prefix characters into proper permissions. Only
+// set translates raw prefix symbols (e.g. "@+") into proper permissions,
+// using the symbol to mode mappings in userPrefixes (ISUPPORT PREFIX). Only
 // use this function when you have a session lock.
-func (m *UserPerms) set(prefix string, append bool) {
+func (m *UserPerms) set(userPrefixes, prefix string, append bool) {
 	if !append {
 		m.reset()
 	}
 
+	modes, symbols := parsePrefixes(userPrefixes)
+
 	for i := 0; i < len(prefix); i++ {
-		switch string(prefix[i]) {
-		case OwnerPrefix:
-			m.Owner = true
-		case AdminPrefix:
-			m.Admin = true
-		case OperatorPrefix:
-			m.Op = true
-		case HalfOperatorPrefix:
-			m.HalfOp = true
-		case VoicePrefix:
-			m.Voice = true
+		if j := strings.IndexByte(symbols, prefix[i]); j > -1 {
+			m.setMode(modes[j], true)
 		}
 	}
 }
@@ -457,28 +498,38 @@ func (m *UserPerms) set(prefix string, append bool) {
 // setFromMode sets user-permissions based on channel user mode chars. E.g.
 // "o" being oper, "v" being voice, etc.
 func (m *UserPerms) setFromMode(mode CMode) {
-	switch string(mode.name) {
+	m.setMode(mode.name, mode.add)
+}
+
+// setMode adds or removes a single channel user mode character.
+func (m *UserPerms) setMode(mode byte, add bool) {
+	switch string(mode) {
 	case ModeOwner:
-		m.Owner = mode.add
+		m.Owner = add
 	case ModeAdmin:
-		m.Admin = mode.add
+		m.Admin = add
 	case ModeOperator:
-		m.Op = mode.add
+		m.Op = add
 	case ModeHalfOperator:
-		m.HalfOp = mode.add
+		m.HalfOp = add
 	case ModeVoice:
-		m.Voice = mode.add
+		m.Voice = add
+	}
+
+	i := strings.IndexByte(m.modes, mode)
+	if add && i < 0 {
+		m.modes += string(mode)
+	} else if !add && i > -1 {
+		m.modes = m.modes[:i] + m.modes[i+1:]
 	}
 }
 
-// parseUserPrefix parses a raw mode line, like "@user" or "@+user".
-func parseUserPrefix(raw string) (modes, nick string, success bool) {
+// parseUserPrefix parses a raw mode line, like "@user" or "@+user" (with
+// multi-prefix). symbols are the prefix symbols supported by the server.
+func parseUserPrefix(symbols, raw string) (modes, nick string, success bool) {
 	for i := 0; i < len(raw); i++ {
-		char := string(raw[i])
-
-		if char == OwnerPrefix || char == AdminPrefix || char == HalfOperatorPrefix ||
-			char == OperatorPrefix || char == VoicePrefix {
-			modes += char
+		if strings.IndexByte(symbols, raw[i]) > -1 {
+			modes += string(raw[i])
 			continue
 		}
 
@@ -0,0 +1,144 @@
+// Copyright (c) Liam Stanley <me@liamstanley.io>. All rights reserved. Use
+// of this source code is governed by the MIT license that can be found in
+// the LICENSE file.
+
+package girc
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCModesApply(t *testing.T) {
+	cases := []struct {
+		steps [][]string
+		want  string
+		key   string
+		limit int
+	}{
+		{steps: [][]string{{"+nt"}}, want: "+nt"},
+		{steps: [][]string{{"+ntk", "secret"}}, want: "+ntk secret", key: "secret"},
+		{steps: [][]string{{"+kl", "secret", "25"}, {"-k", "secret"}}, want: "+l 25", limit: 25},
+		{steps: [][]string{{"+l", "25"}, {"+l", "50"}}, want: "+l 50", limit: 50},
+		{steps: [][]string{{"+l", "25"}, {"-l"}}, want: ""},
+		{steps: [][]string{{"+k", "old"}, {"+k", "new"}}, want: "+k new", key: "new"},
+		// List and prefix modes aren't stored, but do consume arguments.
+		{steps: [][]string{{"+bmo", "*!*@host", "nick"}}, want: "+m"},
+		{steps: [][]string{{"+ov-m+s", "nick1", "nick2"}, {"+m-s"}}, want: "+m"},
+		// Unknown modes are assumed to have no arguments (type D).
+		{steps: [][]string{{"+Zl", "10"}}, want: "+Zl 10", limit: 10},
+	}
+
+	for _, tt := range cases {
+		modes := NewCModes(ModeDefaults, "ov")
+
+		for _, step := range tt.steps {
+			modes.Apply(modes.Parse(step[0], step[1:]))
+		}
+
+		if got := modes.String(); got != tt.want {
+			t.Errorf("%v: String() = %q, want %q", tt.steps, got, tt.want)
+		}
+
+		if got := modes.Key(); got != tt.key {
+			t.Errorf("%v: Key() = %q, want %q", tt.steps, got, tt.key)
+		}
+
+		if got := modes.Limit(); got != tt.limit {
+			t.Errorf("%v: Limit() = %d, want %d", tt.steps, got, tt.limit)
+		}
+	}
+}
+
+func TestHandleMODE(t *testing.T) {
+	c := New(Config{Server: "irc.example.com", Nick: "nick", User: "user"})
+
+	c.state.mu.Lock()
+	c.state.isupport.parse("CHANMODES=beI,kf,lj,imnpst")
+	c.state.isupport.parse("PREFIX=(qov)~@+")
+	c.state.createUserIfNotExists("#channel", "other")
+	c.state.mu.Unlock()
+
+	for _, line := range []string{
+		":irc.example.com 324 nick #channel +ntf #overflow",
+		":op!user@host MODE #channel +jqk 3:5 other key",
+		":op!user@host MODE #channel -n+b-f *!*@spam #overflow",
+	} {
+		handleMODE(c, *ParseEvent(line))
+	}
+
+	channel := c.Lookup("#channel")
+	if channel == nil {
+		t.Fatal("channel not tracked")
+	}
+
+	if got, want := channel.Modes.String(), "+tjk 3:5 key"; got != want {
+		t.Errorf("Modes.String() = %q, want %q", got, want)
+	}
+
+	if !channel.Modes.HasMode("j") || channel.Modes.HasMode("n") || channel.Modes.HasMode("b") {
+		t.Errorf("unexpected modes: %q", channel.Modes.String())
+	}
+
+	if got := channel.Modes.Key(); got != "key" {
+		t.Errorf("Modes.Key() = %q, want %q", got, "key")
+	}
+
+	if user := channel.Lookup("other"); user == nil || !user.Perms.Owner {
+		t.Errorf("expected other to be owner, got %#v", user)
+	}
+}
+
+func TestUserPerms(t *testing.T) {
+	c := New(Config{Server: "irc.example.com", Nick: "nick", User: "user"})
+
+	c.state.mu.Lock()
+	c.state.isupport.parse("PREFIX=(Yqohv)!~@%+")
+	c.state.mu.Unlock()
+
+	for _, line := range []string{
+		":irc.example.com 353 nick = #one :!@other ~nick %+half plain",
+		":irc.example.com 353 nick = #two :@other",
+		":op!user@host MODE #one -o+v other other",
+		":op!user@host MODE #two +Y other",
+	} {
+		handleNAMES(c, *ParseEvent(line))
+		handleMODE(c, *ParseEvent(line))
+	}
+
+	cases := []struct {
+		channel, nick string
+		modes         string
+		op, voice     bool
+		trusted       bool
+	}{
+		{channel: "#one", nick: "other", modes: "Yv", voice: true, trusted: true},
+		{channel: "#one", nick: "nick", modes: "q", trusted: true},
+		{channel: "#one", nick: "half", modes: "hv", voice: true, trusted: true},
+		{channel: "#one", nick: "plain"},
+		{channel: "#two", nick: "other", modes: "oY", op: true, trusted: true},
+	}
+
+	for _, tt := range cases {
+		channel := c.Lookup(tt.channel)
+		if channel == nil {
+			t.Fatalf("channel %s not tracked", tt.channel)
+		}
+
+		user := channel.Lookup(tt.nick)
+		if user == nil {
+			t.Errorf("%s: user %s not tracked", tt.channel, tt.nick)
+			continue
+		}
+
+		for _, mode := range "Yqohv" {
+			if got, want := user.Perms.HasMode(mode), strings.ContainsRune(tt.modes, mode); got != want {
+				t.Errorf("%s: %s HasMode(%q) = %t, want %t", tt.channel, tt.nick, mode, got, want)
+			}
+		}
+
+		if user.Perms.IsOp() != tt.op || user.Perms.IsVoice() != tt.voice || user.Perms.IsTrusted() != tt.trusted {
+			t.Errorf("%s: %s has unexpected permissions: %#v", tt.channel, tt.nick, user.Perms)
+		}
+	}
+}
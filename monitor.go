@@ -0,0 +1,376 @@
+// Copyright (c) Liam Stanley <me@liamstanley.io>. All rights reserved. Use
+// of this source code is governed by the MIT license that can be found in
+// the LICENSE file.
+
+package girc
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+// monitorISONInterval is how often ISON is sent to check the presence of
+// monitored users, on servers which don't support MONITOR.
+const monitorISONInterval = 60 * time.Second
+
+// monitorLineLen is the maximum length of the list of nicknames sent in a
+// single MONITOR or ISON command.
+const monitorLineLen = 400
+
+// Monitor tracks the presence (online/offline) of a list of nicknames, e.g.
+// friends or admins, without needing to poll with WHOIS. It uses MONITOR if
+// the server supports it, and falls back to polling with ISON otherwise.
+// MONITOR_ONLINE and MONITOR_OFFLINE events are emitted when the presence
+// of a monitored user is first known, or changes.
+//
+// The list persists across reconnects, and is re-sent to the server once
+// registered, on each connection.
+type Monitor struct {
+	c  *Client
+	mu sync.RWMutex
+	// targets are the monitored users, keyed by nickname, lowercased with
+	// the casemapping of the server.
+	targets map[string]*monitorTarget
+	// isonQueries are the nicknames of sent ISON queries, which haven't been
+	// replied to yet, in the order they were sent.
+	isonQueries [][]string
+	// synced is true once the monitor list has been sent for the current
+	// connection.
+	synced bool
+}
+
+// monitorTarget is the presence state of a monitored user.
+type monitorTarget struct {
+	// nick is the nickname, as it was added.
+	nick string
+	// known is true once the presence of the user has been received.
+	known bool
+	// online is true if the user is online.
+	online bool
+}
+
+// newMonitor returns a new, empty, monitor list.
+func newMonitor(c *Client) *Monitor {
+	return &Monitor{c: c, targets: make(map[string]*monitorTarget)}
+}
+
+// Add adds the given nicknames to the monitor list, and lets the server know
+// if connected.
+func (m *Monitor) Add(nicks ...string) {
+	var added []string
+
+	m.mu.Lock()
+	for _, nick := range nicks {
+		key := m.key(nick)
+		if !IsValidNick(nick) || m.targets[key] != nil {
+			continue
+		}
+
+		m.targets[key] = &monitorTarget{nick: nick}
+		added = append(added, nick)
+	}
+	m.mu.Unlock()
+
+	if len(added) == 0 || !m.ready() {
+		return
+	}
+
+	if m.supported() {
+		m.send("+", added)
+		return
+	}
+
+	m.ison(added)
+}
+
+// Remove removes the given nicknames from the monitor list.
+func (m *Monitor) Remove(nicks ...string) {
+	var removed []string
+
+	m.mu.Lock()
+	for _, nick := range nicks {
+		key := m.key(nick)
+		if m.targets[key] == nil {
+			continue
+		}
+
+		delete(m.targets, key)
+		removed = append(removed, nick)
+	}
+	m.mu.Unlock()
+
+	if len(removed) > 0 && m.ready() && m.supported() {
+		m.send("-", removed)
+	}
+}
+
+// Clear removes all nicknames from the monitor list.
+func (m *Monitor) Clear() {
+	m.mu.Lock()
+	m.targets = make(map[string]*monitorTarget)
+	m.mu.Unlock()
+
+	if m.ready() && m.supported() {
+		m.c.Send(&Event{Command: MONITOR, Params: []string{"C"}})
+	}
+}
+
+// List returns the nicknames in the monitor list.
+func (m *Monitor) List() []string {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	out := make([]string, 0, len(m.targets))
+	for _, target := range m.targets {
+		out = append(out, target.nick)
+	}
+
+	return out
+}
+
+// key returns the key of nick in the monitor list, lowercased with the
+// casemapping of the server.
+func (m *Monitor) key(nick string) string {
+	m.c.state.mu.RLock()
+	defer m.c.state.mu.RUnlock()
+
+	return m.c.state.toLower(nick)
+}
+
+// has returns true if nick is in the monitor list.
+func (m *Monitor) has(nick string) bool {
+	key := m.key(nick)
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return m.targets[key] != nil
+}
+
+// IsOnline returns if the monitored user is online. known is false if the
+// user isn't in the monitor list, or their presence isn't known yet.
+func (m *Monitor) IsOnline(nick string) (online, known bool) {
+	key := m.key(nick)
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	target := m.targets[key]
+	if target == nil {
+		return false, false
+	}
+
+	return target.online, target.known
+}
+
+// States returns the presence of all monitored users whose presence is
+// known, keyed by nickname, with true meaning online.
+func (m *Monitor) States() map[string]bool {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	out := make(map[string]bool, len(m.targets))
+	for _, target := range m.targets {
+		if target.known {
+			out[target.nick] = target.online
+		}
+	}
+
+	return out
+}
+
+// ready returns true if we're connected, and registered with the server.
+func (m *Monitor) ready() bool {
+	if !m.c.IsConnected() {
+		return false
+	}
+
+	m.c.state.mu.RLock()
+	defer m.c.state.mu.RUnlock()
+
+	return m.c.state.registered
+}
+
+// supported returns true if the server supports MONITOR.
+func (m *Monitor) supported() bool {
+	m.c.state.mu.RLock()
+	defer m.c.state.mu.RUnlock()
+
+	_, ok := m.c.state.isupport.Raw[MONITOR]
+	return ok
+}
+
+// send sends a MONITOR command, with as many nicknames per line as fit.
+func (m *Monitor) send(action string, nicks []string) {
+	for _, chunk := range chunkJoin(nicks, ",", monitorLineLen) {
+		m.c.Send(&Event{Command: MONITOR, Params: []string{action, chunk}})
+	}
+}
+
+// ison sends an ISON query for the given nicknames.
+func (m *Monitor) ison(nicks []string) {
+	for _, chunk := range chunkJoin(nicks, " ", monitorLineLen) {
+		m.mu.Lock()
+		m.isonQueries = append(m.isonQueries, strings.Split(chunk, " "))
+		m.mu.Unlock()
+
+		m.c.Send(&Event{Command: ISON, Params: strings.Split(chunk, " ")})
+	}
+}
+
+// chunkJoin joins items with sep, in chunks no longer than max (unless a
+// single item is longer).
+func chunkJoin(items []string, sep string, max int) (chunks []string) {
+	var chunk string
+	for _, item := range items {
+		if chunk != "" && len(chunk)+len(sep)+len(item) > max {
+			chunks = append(chunks, chunk)
+			chunk = ""
+		}
+
+		if chunk != "" {
+			chunk += sep
+		}
+		chunk += item
+	}
+
+	if chunk != "" {
+		chunks = append(chunks, chunk)
+	}
+
+	return chunks
+}
+
+// sync resets the known presence of all monitored users, and sends the
+// monitor list to the server, once per connection. This is done once
+// registration has finished (at the end of the MOTD), as ISUPPORT has been
+// received by then, so the list is also re-keyed with the casemapping of the
+// server.
+func (m *Monitor) sync() {
+	m.c.state.mu.RLock()
+	casemapping := m.c.state.isupport.Casemapping
+	m.c.state.mu.RUnlock()
+
+	m.mu.Lock()
+	if m.synced {
+		m.mu.Unlock()
+		return
+	}
+	m.synced = true
+
+	targets := make(map[string]*monitorTarget, len(m.targets))
+	nicks := make([]string, 0, len(m.targets))
+	for _, target := range m.targets {
+		target.known, target.online = false, false
+		targets[ToLower(casemapping, target.nick)] = target
+		nicks = append(nicks, target.nick)
+	}
+	m.targets = targets
+	m.isonQueries = nil
+	m.mu.Unlock()
+
+	if len(nicks) == 0 {
+		return
+	}
+
+	if m.supported() {
+		m.send("+", nicks)
+		return
+	}
+
+	m.ison(nicks)
+}
+
+// handleMonitorRegistered lets the monitor list be sent again, once
+// registration has finished on the new connection.
+func handleMonitorRegistered(c *Client, e Event) {
+	c.Monitor.mu.Lock()
+	c.Monitor.synced = false
+	c.Monitor.mu.Unlock()
+}
+
+// loop periodically sends ISON queries, if the server doesn't support
+// MONITOR.
+func (m *Monitor) loop(ctx context.Context) {
+	ticker := time.NewTicker(monitorISONInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if !m.ready() || m.supported() {
+				continue
+			}
+
+			if nicks := m.List(); len(nicks) > 0 {
+				m.ison(nicks)
+			}
+		}
+	}
+}
+
+// update sets the presence of a monitored user, emitting MONITOR_ONLINE or
+// MONITOR_OFFLINE if it changed.
+func (m *Monitor) update(src *Source, online bool) {
+	key := m.key(src.Name)
+
+	m.mu.Lock()
+	target := m.targets[key]
+	if target == nil || (target.known && target.online == online) {
+		m.mu.Unlock()
+		return
+	}
+
+	target.known, target.online = true, online
+	m.mu.Unlock()
+
+	command := MONITOR_OFFLINE
+	if online {
+		command = MONITOR_ONLINE
+	}
+
+	m.c.RunHandlers(&Event{Command: command, Source: src})
+}
+
+// handleMONITOR handles the RPL_MONONLINE and RPL_MONOFFLINE replies.
+func handleMONITOR(c *Client, e Event) {
+	for _, target := range strings.Split(e.Trailing, ",") {
+		src := ParseSource(strings.TrimSpace(target))
+		if src == nil || src.Name == "" {
+			continue
+		}
+
+		c.Monitor.update(src, e.Command == RPL_MONONLINE)
+	}
+}
+
+// handleISON handles RPL_ISON replies to the ISON queries sent by the
+// monitor.
+func handleISON(c *Client, e Event) {
+	m := c.Monitor
+
+	m.mu.Lock()
+	if len(m.isonQueries) == 0 {
+		// Not one of ours.
+		m.mu.Unlock()
+		return
+	}
+
+	query := m.isonQueries[0]
+	m.isonQueries = m.isonQueries[1:]
+	m.mu.Unlock()
+
+	online := make(map[string]bool)
+	for _, nick := range strings.Fields(e.Trailing) {
+		online[m.key(nick)] = true
+	}
+
+	for _, nick := range query {
+		m.update(&Source{Name: nick}, online[m.key(nick)])
+	}
+}
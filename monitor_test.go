@@ -0,0 +1,127 @@
+// Copyright (c) Liam Stanley <me@liamstanley.io>. All rights reserved. Use
+// of this source code is governed by the MIT license that can be found in
+// the LICENSE file.
+
+package girc
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMonitor(t *testing.T) {
+	cases := []struct {
+		name     string
+		isupport string
+		sync     string
+		add      string
+		online   string
+		offline  string
+	}{
+		{
+			name:     "monitor",
+			isupport: ":irc.example.com 005 nick MONITOR=100 :are supported by this server",
+			sync:     "MONITOR + friend",
+			add:      "MONITOR + admin",
+			online:   ":irc.example.com 730 nick :admin!user@example.com",
+			offline:  ":irc.example.com 731 nick :friend",
+		},
+		{
+			name:     "ison",
+			isupport: ":irc.example.com 005 nick NICKLEN=30 :are supported by this server",
+			sync:     "ISON friend",
+			add:      "ISON admin",
+			online:   ":irc.example.com 303 nick :Admin",
+			offline:  ":irc.example.com 303 nick :",
+		},
+	}
+
+	for _, tt := range cases {
+		c, server := mockClient(t, Config{RateBurst: 10})
+
+		events := make(chan Event, 10)
+		c.Handlers.Add(MONITOR_ONLINE, func(c *Client, e Event) { events <- e })
+		c.Handlers.Add(MONITOR_OFFLINE, func(c *Client, e Event) { events <- e })
+
+		c.Monitor.Add("friend")
+
+		server.send(":irc.example.com 001 nick :Welcome")
+		server.send(tt.isupport)
+		server.send(":irc.example.com 376 nick :End of /MOTD command.")
+
+		if line := server.expect(tt.sync); line != tt.sync {
+			t.Fatalf("%s: got %q, want %q", tt.name, line, tt.sync)
+		}
+
+		// Either a reply to the ISON query, or a MONITOR notification.
+		server.send(tt.offline)
+
+		c.Monitor.Add("admin")
+		server.expect(tt.add)
+		server.send(tt.online)
+
+		for _, want := range []struct{ command, nick string }{{MONITOR_OFFLINE, "friend"}, {MONITOR_ONLINE, "admin"}} {
+			select {
+			case e := <-events:
+				if e.Command != want.command || e.Source == nil || ToRFC1459(e.Source.Name) != want.nick {
+					t.Errorf("%s: got %q, want %s for %s", tt.name, e.String(), want.command, want.nick)
+				}
+			case <-time.After(2 * time.Second):
+				t.Fatalf("%s: timed out waiting for %s", tt.name, want.command)
+			}
+		}
+
+		if online, known := c.Monitor.IsOnline("ADMIN"); !online || !known {
+			t.Errorf("%s: IsOnline(ADMIN) = %t, %t", tt.name, online, known)
+		}
+
+		if states := c.Monitor.States(); len(states) != 2 || states["friend"] || !states["admin"] {
+			t.Errorf("%s: States() = %v", tt.name, states)
+		}
+
+		// The MOTD requested later on doesn't re-send the list.
+		server.send(":irc.example.com 376 nick :End of /MOTD command.")
+		server.send("PING :sync")
+		if line := server.expect(""); line != "PONG sync" {
+			t.Errorf("%s: got %q after the MOTD, want nothing", tt.name, line)
+		}
+
+		if states := c.Monitor.States(); len(states) != 2 {
+			t.Errorf("%s: States() = %v after the MOTD", tt.name, states)
+		}
+
+		c.Stop()
+	}
+}
+
+func TestMonitorCasemapping(t *testing.T) {
+	c, server := mockClient(t, Config{})
+	defer c.Stop()
+
+	c.Monitor.Add("a[b]")
+
+	server.send(":irc.example.com 001 nick :Welcome")
+	server.send(":irc.example.com 005 nick MONITOR=100 CASEMAPPING=ascii :are supported by this server")
+	server.send(":irc.example.com 376 nick :End of /MOTD command.")
+	server.expect("MONITOR + a[b]")
+
+	// With ascii, these are different nicknames.
+	c.Monitor.Add("a{b}")
+	server.expect("MONITOR + a{b}")
+
+	if list := c.Monitor.List(); len(list) != 2 {
+		t.Fatalf("List() = %q, want both nicknames", list)
+	}
+
+	server.send(":irc.example.com 730 nick :A[B]!user@example.com")
+	server.send("PING :sync")
+	server.expect("PONG sync")
+
+	if online, known := c.Monitor.IsOnline("a[b]"); !online || !known {
+		t.Errorf("IsOnline(a[b]) = %t, %t", online, known)
+	}
+
+	if _, known := c.Monitor.IsOnline("a{b}"); known {
+		t.Error("IsOnline(a{b}) is known, want it unknown")
+	}
+}
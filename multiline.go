@@ -0,0 +1,204 @@
+// Copyright (c) Liam Stanley <me@liamstanley.io>. All rights reserved. Use
+// of this source code is governed by the MIT license that can be found in
+// the LICENSE file.
+
+package girc
+
+import (
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	// capMultiline is the IRCv3 draft/multiline capability.
+	capMultiline = "draft/multiline"
+	// multilineBatchType is the type of multiline batches.
+	multilineBatchType = "draft/multiline"
+	// multilineConcatTag marks a line of a multiline batch which is joined to
+	// the previous line without a newline.
+	multilineConcatTag = "draft/multiline-concat"
+)
+
+// multilinePart is a single line of a multiline message.
+type multilinePart struct {
+	text string
+	// concat is true if the line is a continuation of the previous line,
+	// rather than a new line.
+	concat bool
+}
+
+// MessageMultiline sends a (possibly long, multi-line) PRIVMSG to target.
+// If the draft/multiline capability has been negotiated, the message is
+// sent as a multiline batch, which the server delivers (to clients which
+// support it) as a single message, with lines which had to be wrapped
+// joined back together. Otherwise, this falls back to Message.
+func (cmd *Commands) MessageMultiline(target, text string) error {
+	if !IsValidNick(target) && !IsValidChannel(target) {
+		return &ErrInvalidTarget{Target: target}
+	}
+
+	if !cmd.c.HasCapability(capMultiline) || !cmd.c.HasCapability("batch") {
+		_, err := cmd.Message(target, text)
+		return err
+	}
+
+	maxBytes, maxLines := cmd.c.multilineLimits()
+	parts := splitMultiline(text, cmd.c.maxMessageLen(PRIVMSG, target))
+	cmd.c.Typing.sent(target)
+
+	// Send as few batches as the limits of the server allow.
+	for len(parts) > 0 {
+		n, size := 0, 0
+		for n < len(parts) {
+			partSize := len(parts[n].text)
+			if n > 0 && !parts[n].concat {
+				// Include the newline.
+				partSize++
+			}
+
+			if n > 0 && ((maxBytes > 0 && size+partSize > maxBytes) || (maxLines > 0 && n >= maxLines)) {
+				break
+			}
+
+			size += partSize
+			n++
+		}
+
+		cmd.c.sendMultiline(PRIVMSG, target, parts[:n])
+
+		// The next batch can't start with a concatenated line.
+		parts = parts[n:]
+		if len(parts) > 0 {
+			parts[0].concat = false
+		}
+	}
+
+	return nil
+}
+
+// sendMultiline sends a single multiline batch.
+func (c *Client) sendMultiline(command, target string, parts []multilinePart) {
+	ref := randomRef(10)
+
+	c.Send(&Event{Command: BATCH, Params: []string{"+" + ref, multilineBatchType, target}})
+	for _, part := range parts {
+		tags := Tags{"batch": ref}
+		if part.concat {
+			tags[multilineConcatTag] = ""
+		}
+
+		c.Send(&Event{Tags: tags, Command: command, Params: []string{target}, Trailing: part.text, EmptyTrailing: true})
+	}
+	c.Send(&Event{Command: BATCH, Params: []string{"-" + ref}})
+}
+
+// multilineLimits returns the max-bytes and max-lines values of the
+// draft/multiline capability. 0 means no limit.
+func (c *Client) multilineLimits() (maxBytes, maxLines int) {
+	c.state.mu.RLock()
+	defer c.state.mu.RUnlock()
+
+	for _, value := range c.state.capValues[capMultiline] {
+		if i := strings.IndexByte(value, 0x3D); i > -1 { // =
+			switch value[:i] {
+			case "max-bytes":
+				maxBytes, _ = strconv.Atoi(value[i+1:])
+			case "max-lines":
+				maxLines, _ = strconv.Atoi(value[i+1:])
+			}
+		}
+	}
+
+	return maxBytes, maxLines
+}
+
+// splitMultiline splits text into lines no longer than max bytes. Lines are
+// split on newlines, and lines which are too long are wrapped, with the
+// wrapped parts marked as concatenated. Unlike wrapMessage, spaces are kept
+// when wrapping, as concatenated lines are joined without a separator.
+func splitMultiline(text string, max int) (parts []multilinePart) {
+	if max < 1 {
+		max = 1
+	}
+
+	for _, line := range strings.Split(text, "\n") {
+		line = strings.TrimRight(line, "\r")
+
+		concat := false
+		for len(line) > max {
+			i := strings.LastIndexByte(line[:max], 0x20) + 1
+			if i <= 0 {
+				// No space to split on, so split within the word.
+				i = max
+				for i > 0 && !utf8.RuneStart(line[i]) {
+					i--
+				}
+				if i == 0 {
+					i = max
+				}
+			}
+
+			parts = append(parts, multilinePart{text: line[:i], concat: concat})
+			line, concat = line[i:], true
+		}
+
+		parts = append(parts, multilinePart{text: line, concat: concat})
+	}
+
+	// Drop trailing empty lines.
+	for len(parts) > 0 && parts[len(parts)-1].text == "" {
+		parts = parts[:len(parts)-1]
+	}
+
+	if len(parts) == 0 {
+		return nil
+	}
+
+	return parts
+}
+
+// reassembleMultiline returns the single message which a multiline batch
+// represents, with the tags of the BATCH event (e.g. msgid and time)
+// applied. Returns nil if the batch contains no messages.
+func reassembleMultiline(b *Batch) *Event {
+	var message *Event
+
+	for _, e := range b.Events {
+		if e.Command != PRIVMSG && e.Command != NOTICE {
+			continue
+		}
+
+		if message == nil {
+			message = e.Copy()
+			message.LoadTags()
+			message.Batch = b.Parent
+			message.Timestamp = b.Timestamp
+			delete(message.Tags, "batch")
+			delete(message.Tags, multilineConcatTag)
+			continue
+		}
+
+		if _, concat := e.Tag(multilineConcatTag); !concat {
+			message.Trailing += "\n"
+		}
+		message.Trailing += e.Trailing
+	}
+
+	if message == nil {
+		return nil
+	}
+
+	for k, v := range b.Tags {
+		if message.Tags == nil {
+			message.Tags = Tags{}
+		}
+		message.Tags[k] = v
+	}
+
+	if b.Parent != nil {
+		message.Tags["batch"] = b.Parent.Ref
+	}
+
+	return message
+}
@@ -0,0 +1,130 @@
+// Copyright (c) Liam Stanley <me@liamstanley.io>. All rights reserved. Use
+// of this source code is governed by the MIT license that can be found in
+// the LICENSE file.
+
+package girc
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSplitMultiline(t *testing.T) {
+	cases := []struct {
+		in   string
+		max  int
+		want []multilinePart
+	}{
+		{"", 10, nil},
+		{"one\ntwo", 10, []multilinePart{{text: "one"}, {text: "two"}}},
+		{"one\n\nthree\n", 10, []multilinePart{{text: "one"}, {text: ""}, {text: "three"}}},
+		{"hello there world", 12, []multilinePart{{text: "hello there "}, {text: "world", concat: true}}},
+		{"abcdefghij", 4, []multilinePart{{text: "abcd"}, {text: "efgh", concat: true}, {text: "ij", concat: true}}},
+	}
+
+	for _, tt := range cases {
+		if got := splitMultiline(tt.in, tt.max); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("splitMultiline(%q, %d) = %+v, want %+v", tt.in, tt.max, got, tt.want)
+		}
+	}
+}
+
+func TestMessageMultiline(t *testing.T) {
+	c, server := mockClient(t, Config{RateBurst: 20})
+	defer c.Stop()
+
+	// Without the capability, the message is split into multiple messages.
+	if err := c.Commands.MessageMultiline("#channel", "one\ntwo"); err != nil {
+		t.Fatal(err)
+	}
+	server.expect("PRIVMSG #channel :one")
+	server.expect("PRIVMSG #channel :two")
+
+	c.state.mu.Lock()
+	c.state.enabledCap = []string{"batch", capMultiline}
+	c.state.capValues[capMultiline] = []string{"max-bytes=4096", "max-lines=2"}
+	c.state.mu.Unlock()
+
+	long := strings.Repeat("word ", maxLength/5)
+	if err := c.Commands.MessageMultiline("#channel", "first\n"+long); err != nil {
+		t.Fatal(err)
+	}
+
+	// The long line is wrapped, and exceeds max-lines, so a second batch is
+	// needed.
+	var batches [][]multilinePart
+	for len(batches) < 2 {
+		start := ParseEvent(server.expect("BATCH +"))
+		if len(start.Params) != 3 || start.Params[1] != multilineBatchType || start.Params[2] != "#channel" {
+			t.Fatalf("unexpected batch start: %q", start)
+		}
+		ref := start.Params[0][1:]
+
+		var parts []multilinePart
+		for {
+			e := ParseEvent(server.expect(""))
+			if e.Command == BATCH {
+				if e.Params[0] != "-"+ref {
+					t.Fatalf("unexpected batch end: %q", e)
+				}
+				break
+			}
+
+			if batch, _ := e.Tags.Get("batch"); batch != ref || e.Command != PRIVMSG {
+				t.Fatalf("line %q isn't in batch %q", e, ref)
+			}
+
+			_, concat := e.Tags.Get(multilineConcatTag)
+			parts = append(parts, multilinePart{text: e.Trailing, concat: concat})
+		}
+
+		batches = append(batches, parts)
+	}
+
+	if len(batches[0]) != 2 || batches[0][0] != (multilinePart{text: "first"}) || batches[0][1].concat {
+		t.Fatalf("unexpected first batch: %+v", batches[0])
+	}
+	if len(batches[1]) != 1 || batches[1][0].concat {
+		t.Fatalf("unexpected second batch: %+v", batches[1])
+	}
+	if got := batches[0][1].text + batches[1][0].text; got != long {
+		t.Fatalf("sent text = %q, want %q", got, long)
+	}
+}
+
+func TestMultilineReassembly(t *testing.T) {
+	c := New(Config{Server: "irc.example.com", Nick: "nick", User: "user"})
+
+	var messages []*Event
+	c.Handlers.Add(PRIVMSG, func(c *Client, e Event) {
+		messages = append(messages, e.Copy())
+	})
+
+	for _, line := range []string{
+		"@msgid=abc :other!user@host BATCH +ref draft/multiline #channel",
+		"@batch=ref :other!user@host PRIVMSG #channel :hello",
+		"@batch=ref :other!user@host PRIVMSG #channel :how is ",
+		"@batch=ref;draft/multiline-concat :other!user@host PRIVMSG #channel :everyone?",
+		":other!user@host PRIVMSG #channel :unrelated",
+		":other!user@host BATCH -ref",
+	} {
+		c.RunHandlers(ParseEvent(line))
+	}
+
+	if len(messages) != 2 || messages[0].Trailing != "unrelated" {
+		t.Fatalf("unexpected messages: %q", messages)
+	}
+
+	e := messages[1]
+	if e.Trailing != "hello\nhow is everyone?" || e.Source.Name != "other" || e.Params[0] != "#channel" {
+		t.Fatalf("unexpected reassembled message: %#v", e)
+	}
+
+	if msgid, _ := e.Tags.Get("msgid"); msgid != "abc" {
+		t.Fatalf("reassembled message has msgid %q, want abc", msgid)
+	}
+	if _, ok := e.Tags.Get("batch"); ok {
+		t.Fatal("reassembled message still has batch tag")
+	}
+}
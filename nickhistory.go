@@ -0,0 +1,78 @@
+// Copyright (c) Liam Stanley <me@liamstanley.io>. All rights reserved. Use
+// of this source code is governed by the MIT license that can be found in
+// the LICENSE file.
+
+package girc
+
+import "time"
+
+const (
+	// maxUserNickHistory is the amount of nickname changes kept for each
+	// user (see User.NickHistory).
+	maxUserNickHistory = 10
+	// maxNickChanges is the amount of nickname changes kept across all
+	// users, used by Client.ResolveNick.
+	maxNickChanges = 1000
+)
+
+// NickChange is a single nickname change of a user.
+type NickChange struct {
+	// Old and New are the nickname before and after the change.
+	Old string
+	New string
+	// Time is when the change was seen.
+	Time time.Time
+}
+
+// appendNickChange returns a new slice with change appended to history,
+// dropping the oldest changes if there are more than max.
+func appendNickChange(history []NickChange, change NickChange, max int) []NickChange {
+	if len(history) >= max {
+		history = history[len(history)-max+1:]
+	}
+
+	out := make([]NickChange, len(history), len(history)+1)
+	copy(out, history)
+
+	return append(out, change)
+}
+
+// ResolveNick returns the current nickname of the user who was using nick
+// at the given time, by following the nickname changes seen since then.
+// This is useful for e.g. log annotations, to answer "who was X five
+// minutes ago". ok is true if the resolved user is currently tracked. Only
+// the most recent 1000 nickname changes are kept. Panics if tracking is
+// disabled.
+func (c *Client) ResolveNick(nick string, at time.Time) (current string, ok bool) {
+	c.panicIfNotTracking()
+
+	c.state.mu.RLock()
+	defer c.state.mu.RUnlock()
+
+	current = c.state.resolveNick(nick, at)
+
+	users := c.state.lookupUsers("nick", current)
+	if len(users) > 0 {
+		// Use the nickname as it is tracked, rather than as supplied.
+		current = users[0].Nick
+	}
+
+	return current, len(users) > 0
+}
+
+// resolveNick returns the nickname of the user who was using nick at the
+// given time, by following the nickname changes seen since then. Always use
+// state.mu for transaction.
+func (s *state) resolveNick(nick string, at time.Time) string {
+	for _, change := range s.nickChanges {
+		if change.Time.Before(at) {
+			continue
+		}
+
+		if s.toLower(change.Old) == s.toLower(nick) {
+			nick = change.New
+		}
+	}
+
+	return nick
+}
@@ -0,0 +1,65 @@
+// Copyright (c) Liam Stanley <me@liamstanley.io>. All rights reserved. Use
+// of this source code is governed by the MIT license that can be found in
+// the LICENSE file.
+
+package girc
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestResolveNick(t *testing.T) {
+	c := New(Config{Server: "irc.example.com", Nick: "nick", User: "user"})
+
+	start := time.Now()
+	c.RunHandlers(ParseEvent(":irc.example.com 353 nick = #channel :nick first"))
+	c.RunHandlers(ParseEvent(":first!user@host NICK second"))
+	time.Sleep(time.Millisecond)
+
+	middle := time.Now()
+	c.RunHandlers(ParseEvent(":second!user@host NICK Third"))
+
+	tests := []struct {
+		nick string
+		at   time.Time
+		want string
+		ok   bool
+	}{
+		{nick: "first", at: start, want: "Third", ok: true},
+		{nick: "SECOND", at: middle, want: "Third", ok: true},
+		{nick: "third", at: time.Now(), want: "Third", ok: true},
+		// first was only renamed before middle.
+		{nick: "first", at: middle, want: "first", ok: false},
+		{nick: "nick", at: start, want: "nick", ok: true},
+	}
+
+	for _, tt := range tests {
+		if got, ok := c.ResolveNick(tt.nick, tt.at); got != tt.want || ok != tt.ok {
+			t.Errorf("ResolveNick(%q) = %q, %t, want %q, %t", tt.nick, got, ok, tt.want, tt.ok)
+		}
+	}
+
+	user, _ := c.LookupUser("third")
+	if len(user.NickHistory) != 2 || user.NickHistory[0].Old != "first" || user.NickHistory[1].New != "Third" {
+		t.Fatalf("NickHistory = %+v, want first -> second -> Third", user.NickHistory)
+	}
+
+	// The history is bounded.
+	nick := "Third"
+	for i := 0; i < maxUserNickHistory+5; i++ {
+		next := fmt.Sprintf("nick%d", i)
+		c.RunHandlers(ParseEvent(":" + nick + "!user@host NICK " + next))
+		nick = next
+	}
+
+	user, _ = c.LookupUser(nick)
+	if len(user.NickHistory) != maxUserNickHistory || user.NickHistory[maxUserNickHistory-1].New != nick {
+		t.Fatalf("NickHistory has %d entries, want the last %d", len(user.NickHistory), maxUserNickHistory)
+	}
+
+	if got, _ := c.ResolveNick("first", start); got != nick {
+		t.Fatalf("ResolveNick() = %q, want %q", got, nick)
+	}
+}
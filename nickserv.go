@@ -0,0 +1,164 @@
+// Copyright (c) Liam Stanley <me@liamstanley.io>. All rights reserved. Use
+// of this source code is governed by the MIT license that can be found in
+// the LICENSE file.
+
+package girc
+
+import "strings"
+
+// defaultNickServ is the default nickname of the NickServ service.
+const defaultNickServ = "NickServ"
+
+var (
+	// nickServSuccess are (lowercase) parts of the notices which NickServ
+	// sends once we've identified, for the common services packages (e.g.
+	// Atheme, Anope and DALnet).
+	nickServSuccess = []string{
+		"you are now identified",
+		"you are now logged in",
+		"you are now recognized",
+		"password accepted",
+		"you are already identified",
+		"you are already logged in",
+	}
+	// nickServFailure are (lowercase) parts of the notices which NickServ
+	// sends when identification fails.
+	nickServFailure = []string{
+		"invalid password",
+		"password incorrect",
+		"password authentication failed",
+		"is not a registered nickname",
+		"isn't registered",
+		"is not registered",
+		"access denied",
+	}
+)
+
+// NickServ configures automatic identification with NickServ, for networks
+// which don't support SASL (see Config.SASL). Identification is attempted
+// once connected, as well as after regaining our nickname, unless we're
+// already logged in. The outcome is dispatched as an IDENTIFIED or
+// IDENTIFY_FAILED event.
+type NickServ struct {
+	// Password is the password to identify with.
+	Password string
+	// Account is the account to identify as. If empty, we identify as our
+	// current nickname, which is only attempted while we're using
+	// Config.Nick.
+	Account string
+	// Service is the nickname of the NickServ service. Defaults to
+	// "NickServ".
+	Service string
+	// Command, if set, is sent instead of the IDENTIFY message, e.g.
+	// "PRIVMSG NickServ :IDENTIFY account password" for services which use
+	// a different syntax. It is never logged.
+	Command string
+}
+
+// service returns the nickname of the NickServ service.
+func (n *NickServ) service() string {
+	if n.Service == "" {
+		return defaultNickServ
+	}
+
+	return n.Service
+}
+
+// identify identifies with NickServ, if enabled with Config.NickServ and we
+// aren't already logged in.
+func (c *Client) identify() {
+	ns := c.Config.NickServ
+	if ns == nil {
+		return
+	}
+
+	c.state.mu.Lock()
+	if c.state.account != "" || (ns.Account == "" && c.state.toLower(c.state.nick) != c.state.toLower(c.configNick())) {
+		c.state.mu.Unlock()
+		return
+	}
+	c.state.identifying = true
+	c.state.mu.Unlock()
+
+	if ns.Command != "" {
+		event := ParseEvent(ns.Command)
+		if event == nil {
+			c.debug.Print("invalid nickserv command")
+			return
+		}
+		event.Sensitive = true
+
+		c.Send(event)
+		return
+	}
+
+	text := "IDENTIFY " + ns.Password
+	if ns.Account != "" {
+		text = "IDENTIFY " + ns.Account + " " + ns.Password
+	}
+
+	c.Send(&Event{Command: PRIVMSG, Params: []string{ns.service()}, Trailing: text, Sensitive: true})
+}
+
+// identified dispatches IDENTIFIED or IDENTIFY_FAILED, if we're waiting for
+// the outcome of identification.
+func (c *Client) identified(ok bool, account, reason string) {
+	c.state.mu.Lock()
+	pending := c.state.identifying
+	c.state.identifying = false
+	c.state.mu.Unlock()
+
+	if !pending {
+		return
+	}
+
+	if ok {
+		c.RunHandlers(&Event{Command: IDENTIFIED, Params: []string{account}})
+		return
+	}
+
+	c.RunHandlers(&Event{Command: IDENTIFY_FAILED, Trailing: reason})
+}
+
+// handleNickServ identifies with NickServ once connected.
+func handleNickServ(c *Client, e Event) {
+	c.identify()
+}
+
+// handleNickServNotice detects the outcome of identification, from the
+// notices of NickServ.
+func handleNickServNotice(c *Client, e Event) {
+	ns := c.Config.NickServ
+	if ns == nil || e.Source == nil || c.toLower(e.Source.Name) != c.toLower(ns.service()) {
+		return
+	}
+
+	text := strings.ToLower(StripRaw(e.Trailing))
+
+	for _, success := range nickServSuccess {
+		if strings.Contains(text, success) {
+			account := ns.Account
+			if account == "" {
+				account = c.GetNick()
+			}
+
+			c.identified(true, account, "")
+			return
+		}
+	}
+
+	for _, failure := range nickServFailure {
+		if strings.Contains(text, failure) {
+			c.identified(false, "", StripRaw(e.Trailing))
+			return
+		}
+	}
+}
+
+// handleNickServLogin treats logging in (RPL_LOGGEDIN), which most services
+// send along with their notice, as the outcome of identification.
+func handleNickServLogin(c *Client, e Event) {
+	if c.Config.NickServ != nil && len(e.Params) > 0 {
+		c.identified(true, e.Params[0], "")
+	}
+}
@@ -0,0 +1,110 @@
+// Copyright (c) Liam Stanley <me@liamstanley.io>. All rights reserved. Use
+// of this source code is governed by the MIT license that can be found in
+// the LICENSE file.
+
+package girc
+
+import (
+	"testing"
+	"time"
+)
+
+func TestNickServ(t *testing.T) {
+	tests := []struct {
+		name     string
+		nickserv *NickServ
+		identify string
+		notice   string
+		want     string
+	}{
+		{
+			name:     "atheme",
+			nickserv: &NickServ{Password: "secret"},
+			identify: "PRIVMSG NickServ :IDENTIFY secret",
+			notice:   ":NickServ!NickServ@services. NOTICE nick :You are now identified for \x02nick\x02.",
+			want:     "IDENTIFIED nick",
+		},
+		{
+			name:     "anope failure",
+			nickserv: &NickServ{Password: "wrong", Account: "account"},
+			identify: "PRIVMSG NickServ :IDENTIFY account wrong",
+			notice:   ":NickServ!service@services. NOTICE nick :Password incorrect.",
+			want:     "IDENTIFY_FAILED :Password incorrect.",
+		},
+		{
+			name:     "custom command",
+			nickserv: &NickServ{Service: "AuthServ", Command: "PRIVMSG AuthServ@services.example.com :AUTH account secret"},
+			identify: "PRIVMSG AuthServ@services.example.com :AUTH account secret",
+			notice:   ":AuthServ!AuthServ@services. NOTICE nick :I recognize you. Password accepted.",
+			want:     "IDENTIFIED nick",
+		},
+	}
+
+	for _, tt := range tests {
+		c, server := mockClient(t, Config{NickServ: tt.nickserv})
+
+		events := make(chan Event, 1)
+		for _, cmd := range []string{IDENTIFIED, IDENTIFY_FAILED} {
+			c.Handlers.Add(cmd, func(c *Client, e Event) { events <- e })
+		}
+
+		server.send(":irc.example.com 001 nick :Welcome")
+		server.send(":irc.example.com 422 nick :MOTD File is missing")
+		server.expect(tt.identify)
+		server.send(tt.notice)
+
+		select {
+		case e := <-events:
+			if e.String() != tt.want {
+				t.Errorf("%s: got %q, want %q", tt.name, e.String(), tt.want)
+			}
+		case <-time.After(2 * time.Second):
+			t.Errorf("%s: never received %q", tt.name, tt.want)
+		}
+
+		c.Stop()
+	}
+}
+
+func TestNickServCasemapping(t *testing.T) {
+	c, server := mockClient(t, Config{Nick: "nick[1]", User: "user", NickServ: &NickServ{Password: "secret", Service: "Nick[Serv]"}})
+	defer c.Stop()
+
+	events := make(chan Event, 2)
+	for _, cmd := range []string{IDENTIFIED, IDENTIFY_FAILED} {
+		c.Handlers.Add(cmd, func(c *Client, e Event) { events <- e })
+	}
+
+	server.send(":irc.example.com 001 NICK[1] :Welcome")
+	server.send(":irc.example.com 005 NICK[1] CASEMAPPING=ascii :are supported by this server")
+	server.send(":irc.example.com 422 NICK[1] :MOTD File is missing")
+	server.expect("PRIVMSG Nick[Serv] :IDENTIFY secret")
+
+	// With ascii, nick{serv} isn't NickServ.
+	server.send(":nick{serv}!service@services. NOTICE NICK[1] :Password incorrect.")
+	server.send(":NICK[SERV]!service@services. NOTICE NICK[1] :Password accepted.")
+
+	select {
+	case e := <-events:
+		if e.Command != IDENTIFIED {
+			t.Fatalf("got %q, want IDENTIFIED", e.String())
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("never received IDENTIFIED")
+	}
+}
+
+func TestNickServLoggedIn(t *testing.T) {
+	c, server := mockClient(t, Config{NickServ: &NickServ{Password: "secret"}})
+	defer c.Stop()
+
+	// Already logged in, e.g. with SASL or a certificate.
+	server.send(":irc.example.com 900 nick nick!user@host account :You are now logged in as account")
+	server.send(":irc.example.com 001 nick :Welcome")
+	server.send(":irc.example.com 422 nick :MOTD File is missing")
+	server.send("PING :sync")
+
+	if line := server.expect("P"); line != "PONG sync" {
+		t.Fatalf("unexpected line while logged in: %q", line)
+	}
+}
@@ -0,0 +1,555 @@
+// Copyright (c) Liam Stanley <me@liamstanley.io>. All rights reserved. Use
+// of this source code is governed by the MIT license that can be found in
+// the LICENSE file.
+
+package girc
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrOperUnsupported is returned by the operator commands (e.g.
+// Commands.GLine or Commands.SAJoin) when the server software doesn't
+// support the command.
+var ErrOperUnsupported = errors.New("command is not supported by the server")
+
+// ircdFlavor is the family of server software, for operator commands and
+// server notices which differ between servers.
+type ircdFlavor int
+
+const (
+	flavorUnknown  ircdFlavor = iota
+	flavorTS6                 // Solanum, Charybdis, ircd-seven, ratbox, hybrid.
+	flavorInspIRCd            // InspIRCd.
+	flavorUnreal              // UnrealIRCd.
+)
+
+// flavor returns the family of the server software, from the version sent
+// in RPL_MYINFO. Always use state.mu for transaction.
+func (s *state) flavor() ircdFlavor {
+	version := strings.ToLower(s.serverVersion)
+
+	switch {
+	case strings.Contains(version, "inspircd"):
+		return flavorInspIRCd
+	case strings.Contains(version, "unreal"):
+		return flavorUnreal
+	case strings.Contains(version, "solanum"), strings.Contains(version, "charybdis"),
+		strings.Contains(version, "seven"), strings.Contains(version, "ratbox"),
+		strings.Contains(version, "hybrid"):
+		return flavorTS6
+	}
+
+	return flavorUnknown
+}
+
+// Server ban types, as used by Commands.KLine, Commands.GLine and
+// Commands.ZLine, and ServerBan.
+const (
+	ServerBanKLine = "K" // user@host ban on the server
+	ServerBanGLine = "G" // network-wide user@host ban
+	ServerBanZLine = "Z" // ip ban, also known as a D-line
+)
+
+// ParseBanDuration parses the duration of a server ban, as used by operators
+// (e.g. "1d12h", "30m", or "2w"), supporting the units y, w, d, h, m and s.
+// A number without a unit is in minutes, as with KLINE on most servers. An
+// empty duration, "0" and "perm" mean the ban is permanent, which is a
+// duration of 0.
+func ParseBanDuration(raw string) (time.Duration, error) {
+	raw = strings.ToLower(strings.TrimSpace(raw))
+	if raw == "" || raw == "perm" || raw == "permanent" {
+		return 0, nil
+	}
+
+	if n, err := strconv.Atoi(raw); err == nil {
+		if n < 0 {
+			return 0, fmt.Errorf("invalid ban duration %q", raw)
+		}
+
+		return time.Duration(n) * time.Minute, nil
+	}
+
+	var duration time.Duration
+	var n int
+	var digits bool
+	for i := 0; i < len(raw); i++ {
+		if raw[i] >= 0x30 && raw[i] <= 0x39 { // 0-9
+			n = n*10 + int(raw[i]-0x30)
+			digits = true
+			continue
+		}
+
+		var unit time.Duration
+		switch raw[i] {
+		case 0x79: // y
+			unit = 365 * 24 * time.Hour
+		case 0x77: // w
+			unit = 7 * 24 * time.Hour
+		case 0x64: // d
+			unit = 24 * time.Hour
+		case 0x68: // h
+			unit = time.Hour
+		case 0x6D: // m
+			unit = time.Minute
+		case 0x73: // s
+			unit = time.Second
+		default:
+			return 0, fmt.Errorf("invalid ban duration %q", raw)
+		}
+
+		if !digits {
+			return 0, fmt.Errorf("invalid ban duration %q", raw)
+		}
+
+		duration += time.Duration(n) * unit
+		n, digits = 0, false
+	}
+
+	if digits {
+		return 0, fmt.Errorf("invalid ban duration %q", raw)
+	}
+
+	return duration, nil
+}
+
+// Kill sends a KILL query to the server, disconnecting nick from the network
+// with reason. Requires operator privileges.
+func (cmd *Commands) Kill(nick, reason string) error {
+	if !IsValidNick(nick) {
+		return &ErrInvalidTarget{Target: nick}
+	}
+
+	cmd.c.Send(&Event{Command: KILL, Params: []string{nick}, Trailing: reason})
+	return nil
+}
+
+// KLine bans a user@host mask (e.g. "*@192.0.2.1") from the server for
+// duration (0 meaning permanently, see ParseBanDuration), with reason.
+// Requires operator privileges.
+func (cmd *Commands) KLine(mask string, duration time.Duration, reason string) error {
+	return cmd.serverBan(ServerBanKLine, mask, duration, reason)
+}
+
+// UnKLine removes a ban placed with KLine.
+func (cmd *Commands) UnKLine(mask string) error {
+	return cmd.removeServerBan(ServerBanKLine, mask)
+}
+
+// GLine bans a user@host mask from the network, as with KLine. Returns
+// ErrOperUnsupported on servers without G-lines (e.g. Solanum, where
+// KLINE is usually propagated to the network), or if the server software
+// isn't known.
+func (cmd *Commands) GLine(mask string, duration time.Duration, reason string) error {
+	return cmd.serverBan(ServerBanGLine, mask, duration, reason)
+}
+
+// UnGLine removes a ban placed with GLine.
+func (cmd *Commands) UnGLine(mask string) error {
+	return cmd.removeServerBan(ServerBanGLine, mask)
+}
+
+// ZLine bans an ip (e.g. "192.0.2.1", or "*@192.0.2.1") before the ident
+// and host lookups of connecting users, as with KLine. This is a D-line on
+// Solanum.
+func (cmd *Commands) ZLine(mask string, duration time.Duration, reason string) error {
+	return cmd.serverBan(ServerBanZLine, mask, duration, reason)
+}
+
+// UnZLine removes a ban placed with ZLine.
+func (cmd *Commands) UnZLine(mask string) error {
+	return cmd.removeServerBan(ServerBanZLine, mask)
+}
+
+// serverBanCommand returns the command which places the given type of
+// server ban, and the mask in the format it expects.
+func (cmd *Commands) serverBanCommand(typ, mask string) (command, out string, flavor ircdFlavor, err error) {
+	if mask == "" || strings.ContainsAny(mask, " \r\n") {
+		return "", "", flavor, &ErrInvalidTarget{Target: mask}
+	}
+
+	cmd.c.state.mu.RLock()
+	flavor = cmd.c.state.flavor()
+	cmd.c.state.mu.RUnlock()
+
+	command, out = typ+"LINE", mask
+	if typ == ServerBanZLine && flavor != flavorUnreal {
+		out = strings.TrimPrefix(mask, "*@")
+	}
+
+	if flavor == flavorTS6 || flavor == flavorUnknown {
+		switch typ {
+		case ServerBanGLine:
+			return "", "", flavor, ErrOperUnsupported
+		case ServerBanZLine:
+			command = "DLINE"
+		}
+	}
+
+	return command, out, flavor, nil
+}
+
+// serverBan places a server ban.
+func (cmd *Commands) serverBan(typ, mask string, duration time.Duration, reason string) error {
+	command, mask, flavor, err := cmd.serverBanCommand(typ, mask)
+	if err != nil {
+		return err
+	}
+
+	if duration < 0 {
+		duration = 0
+	}
+
+	var params []string
+	switch flavor {
+	case flavorInspIRCd, flavorUnreal:
+		// KLINE <mask> <seconds> :<reason>
+		params = []string{mask, strconv.Itoa(int((duration + time.Second - 1) / time.Second))}
+	default:
+		// KLINE [<minutes>] <mask> :<reason>
+		if duration > 0 {
+			params = append(params, strconv.Itoa(int((duration+time.Minute-1)/time.Minute)))
+		}
+		params = append(params, mask)
+	}
+
+	if reason == "" {
+		reason = "No reason"
+	}
+
+	cmd.c.Send(&Event{Command: command, Params: params, Trailing: reason})
+	return nil
+}
+
+// removeServerBan removes a server ban.
+func (cmd *Commands) removeServerBan(typ, mask string) error {
+	command, mask, flavor, err := cmd.serverBanCommand(typ, mask)
+	if err != nil {
+		return err
+	}
+
+	switch flavor {
+	case flavorInspIRCd:
+		cmd.c.Send(&Event{Command: command, Params: []string{mask}})
+	case flavorUnreal:
+		cmd.c.Send(&Event{Command: command, Params: []string{"-" + mask}})
+	default:
+		cmd.c.Send(&Event{Command: "UN" + command, Params: []string{mask}})
+	}
+
+	return nil
+}
+
+// saSupported returns ErrOperUnsupported if the server is known to not
+// support the SAJOIN, SAMODE and SANICK commands.
+func (cmd *Commands) saSupported() error {
+	cmd.c.state.mu.RLock()
+	flavor := cmd.c.state.flavor()
+	cmd.c.state.mu.RUnlock()
+
+	if flavor == flavorTS6 {
+		return ErrOperUnsupported
+	}
+
+	return nil
+}
+
+// SAJoin forces nick to join channels. Supported by InspIRCd and
+// UnrealIRCd, otherwise ErrOperUnsupported is returned. Requires operator
+// privileges.
+func (cmd *Commands) SAJoin(nick string, channels ...string) error {
+	if !IsValidNick(nick) {
+		return &ErrInvalidTarget{Target: nick}
+	}
+
+	for i := 0; i < len(channels); i++ {
+		if !IsValidChannel(channels[i]) {
+			return &ErrInvalidTarget{Target: channels[i]}
+		}
+	}
+
+	if len(channels) == 0 {
+		return nil
+	}
+
+	if err := cmd.saSupported(); err != nil {
+		return err
+	}
+
+	cmd.c.Send(&Event{Command: SAJOIN, Params: []string{nick, strings.Join(channels, ",")}})
+	return nil
+}
+
+// SAMode forces a mode change (e.g. "+o" with args "nick") on channel,
+// regardless of our channel permissions. See SAJoin for more info.
+func (cmd *Commands) SAMode(channel, modes string, args ...string) error {
+	if !IsValidChannel(channel) {
+		return &ErrInvalidTarget{Target: channel}
+	}
+
+	if err := cmd.saSupported(); err != nil {
+		return err
+	}
+
+	cmd.c.Send(&Event{Command: SAMODE, Params: append([]string{channel, modes}, args...)})
+	return nil
+}
+
+// SANick forces nick to change their nickname to newNick. See SAJoin for
+// more info.
+func (cmd *Commands) SANick(nick, newNick string) error {
+	if !IsValidNick(nick) {
+		return &ErrInvalidTarget{Target: nick}
+	}
+
+	if !IsValidNick(newNick) {
+		return &ErrInvalidTarget{Target: newNick}
+	}
+
+	if err := cmd.saSupported(); err != nil {
+		return err
+	}
+
+	cmd.c.Send(&Event{Command: SANICK, Params: []string{nick, newNick}})
+	return nil
+}
+
+// ServerBan is a server ban (e.g. a K-line) which was placed or removed by
+// an operator, as announced in a server notice. See SERVER_BAN_ADDED,
+// SERVER_BAN_REMOVED and ParseServerBan.
+type ServerBan struct {
+	// Type is the type of ban, e.g. ServerBanKLine. D-lines are reported as
+	// ServerBanZLine.
+	Type string
+	// Mask is the banned mask, e.g. "*@192.0.2.1".
+	Mask string
+	// SetBy is the operator who placed or removed the ban, as reported by
+	// the server (a nickname, or a "nick!user@host" mask).
+	SetBy string
+	// Duration is the duration of the ban, 0 if it's permanent (or when it
+	// was removed).
+	Duration time.Duration
+	// Reason is the reason of the ban, if known.
+	Reason string
+}
+
+// ParseServerBan returns the server ban of a SERVER_BAN_ADDED or
+// SERVER_BAN_REMOVED event.
+func ParseServerBan(e Event) (ban ServerBan, ok bool) {
+	if (e.Command != SERVER_BAN_ADDED && e.Command != SERVER_BAN_REMOVED) || len(e.Params) < 3 {
+		return ban, false
+	}
+
+	ban = ServerBan{Type: e.Params[0], Mask: e.Params[1], SetBy: e.Params[2], Reason: e.Trailing}
+	if len(e.Params) > 3 {
+		seconds, _ := strconv.Atoi(e.Params[3])
+		ban.Duration = time.Duration(seconds) * time.Second
+	}
+
+	return ban, true
+}
+
+// serverBanType returns the type of a server ban from its name in server
+// notices, e.g. "K" (of "K-Line") or "Global Z".
+func serverBanType(name string) (typ string, ok bool) {
+	name = strings.ToUpper(strings.TrimPrefix(name, "Global "))
+	if len(name) != 1 || name[0] < 0x41 || name[0] > 0x5A { // A-Z
+		return "", false
+	}
+
+	if name == "D" {
+		return ServerBanZLine, true
+	}
+
+	return name, true
+}
+
+// parseTS6BanNotice parses a Solanum server ban notice, e.g.:
+//
+//	nick!user@host{oper} added temporary 60 min. K-Line for [*@192.0.2.1] [reason]
+//	nick!user@host{oper} has removed the temporary K-Line for: [*@192.0.2.1]
+func parseTS6BanNotice(text string) (ban ServerBan, added, ok bool) {
+	i := strings.IndexByte(text, 0x20) // space
+	if i < 0 {
+		return ban, false, false
+	}
+
+	ban.SetBy, text = text[:i], text[i+1:]
+	if j := strings.IndexByte(ban.SetBy, 0x7B); j > 0 { // {
+		ban.SetBy = ban.SetBy[:j]
+	}
+
+	if strings.HasPrefix(text, "has removed the ") {
+		text = strings.TrimPrefix(text[16:], "temporary ")
+
+		i = strings.Index(text, "-Line for: [")
+		if i < 0 {
+			return ban, false, false
+		}
+
+		ban.Type, ban.Mask = text[:i], strings.TrimSuffix(text[i+12:], "]")
+		return ban, false, true
+	}
+
+	if !strings.HasPrefix(text, "added ") {
+		return ban, false, false
+	}
+	text = text[6:]
+
+	if strings.HasPrefix(text, "temporary ") {
+		// "temporary <minutes> min. "
+		fields := strings.SplitN(text[10:], " ", 3)
+		if len(fields) < 3 {
+			return ban, false, false
+		}
+
+		minutes, err := strconv.Atoi(fields[0])
+		if err != nil {
+			return ban, false, false
+		}
+
+		ban.Duration, text = time.Duration(minutes)*time.Minute, fields[2]
+	}
+
+	i = strings.Index(text, "-Line for [")
+	if i < 0 {
+		return ban, false, false
+	}
+	ban.Type, text = text[:i], text[i+11:]
+
+	i = strings.IndexByte(text, 0x5D) // ]
+	if i < 0 {
+		return ban, false, false
+	}
+	ban.Mask, text = text[:i], strings.TrimSpace(text[i+1:])
+
+	ban.Reason = strings.TrimSuffix(strings.TrimPrefix(text, "["), "]")
+	return ban, true, true
+}
+
+// parseInspIRCdBanNotice parses an InspIRCd server ban notice, e.g.:
+//
+//	nick added timed K-line for *@192.0.2.1, expires in 1h (on ...): reason
+//	nick added permanent K-line for *@192.0.2.1: reason
+//	nick removed K-line on *@192.0.2.1: reason
+func parseInspIRCdBanNotice(text string) (ban ServerBan, added, ok bool) {
+	i := strings.IndexByte(text, 0x20) // space
+	if i < 0 {
+		return ban, false, false
+	}
+	ban.SetBy, text = text[:i], text[i+1:]
+
+	var sep string
+	switch {
+	case strings.HasPrefix(text, "added timed "):
+		text, sep, added = text[12:], "-line for ", true
+	case strings.HasPrefix(text, "added permanent "):
+		text, sep, added = text[16:], "-line for ", true
+	case strings.HasPrefix(text, "removed "):
+		text, sep = text[8:], "-line on "
+	default:
+		return ban, false, false
+	}
+
+	i = strings.Index(text, sep)
+	if i < 0 {
+		return ban, false, false
+	}
+	ban.Type, text = text[:i], text[i+len(sep):]
+
+	// The mask is followed by the expiry of timed bans, and the reason.
+	end := strings.Index(text, ": ")
+	if end < 0 {
+		ban.Mask = text
+		return ban, added, true
+	}
+	ban.Reason = text[end+2:]
+	text = text[:end]
+
+	if i = strings.Index(text, ", expires in "); i >= 0 {
+		expiry := text[i+13:]
+		if j := strings.Index(expiry, " (on "); j >= 0 {
+			expiry = expiry[:j]
+		}
+
+		ban.Duration, _ = ParseBanDuration(expiry)
+		text = text[:i]
+	}
+
+	ban.Mask = text
+	return ban, added, true
+}
+
+// parseUnrealBanNotice parses an UnrealIRCd server ban notice, e.g.:
+//
+//	K-Line added: '*@192.0.2.1' [reason: reason] [by: nick!user@host] [duration: 1h]
+//	K-Line removed: '*@192.0.2.1' [reason: reason] [by: nick!user@host] [set at: ...]
+func parseUnrealBanNotice(text string) (ban ServerBan, added, ok bool) {
+	// Newer versions prefix notices with their subsystem, e.g. "[tkl] ".
+	if strings.HasPrefix(text, "[") {
+		if i := strings.Index(text, "] "); i >= 0 {
+			text = text[i+2:]
+		}
+	}
+
+	i := strings.Index(text, "-Line ")
+	if i < 0 {
+		return ban, false, false
+	}
+	ban.Type, text = text[:i], text[i+6:]
+
+	switch {
+	case strings.HasPrefix(text, "added: '"):
+		text, added = text[8:], true
+	case strings.HasPrefix(text, "removed: '"):
+		text = text[10:]
+	default:
+		return ban, false, false
+	}
+
+	i = strings.IndexByte(text, 0x27) // '
+	if i < 0 {
+		return ban, false, false
+	}
+	ban.Mask, text = text[:i], text[i+1:]
+
+	// The remainder are "[key: value]" fields.
+	for {
+		start := strings.Index(text, " [")
+		if start < 0 {
+			break
+		}
+		text = text[start+2:]
+
+		end := strings.Index(text, "]")
+		sep := strings.Index(text, ": ")
+		if end < 0 || sep < 0 || sep > end {
+			break
+		}
+
+		// The reason may contain brackets, so it ends at the next field.
+		if text[:sep] == "reason" {
+			if next := strings.Index(text, "] [by: "); next >= 0 {
+				end = next
+			}
+		}
+
+		key, value := text[:sep], text[sep+2:end]
+		text = text[end+1:]
+
+		switch key {
+		case "reason":
+			ban.Reason = value
+		case "by":
+			ban.SetBy = value
+		case "duration":
+			ban.Duration, _ = ParseBanDuration(value)
+		}
+	}
+
+	return ban, added, true
+}
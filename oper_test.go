@@ -0,0 +1,180 @@
+// Copyright (c) Liam Stanley <me@liamstanley.io>. All rights reserved. Use
+// of this source code is governed by the MIT license that can be found in
+// the LICENSE file.
+
+package girc
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseBanDuration(t *testing.T) {
+	tests := []struct {
+		raw     string
+		want    time.Duration
+		wantErr bool
+	}{
+		{raw: "", want: 0},
+		{raw: "0", want: 0},
+		{raw: "perm", want: 0},
+		{raw: "60", want: time.Hour},
+		{raw: "1d12h", want: 36 * time.Hour},
+		{raw: "2W", want: 14 * 24 * time.Hour},
+		{raw: "1y", want: 365 * 24 * time.Hour},
+		{raw: "90s", want: 90 * time.Second},
+		{raw: "1h30m", want: 90 * time.Minute},
+		{raw: "-5", wantErr: true},
+		{raw: "h", wantErr: true},
+		{raw: "1h30", wantErr: true},
+		{raw: "1x", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		got, err := ParseBanDuration(tt.raw)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ParseBanDuration(%q) error = %v, wantErr %t", tt.raw, err, tt.wantErr)
+			continue
+		}
+
+		if got != tt.want {
+			t.Errorf("ParseBanDuration(%q) = %s, want %s", tt.raw, got, tt.want)
+		}
+	}
+}
+
+func TestServerBanNotices(t *testing.T) {
+	tests := []struct {
+		text  string
+		want  ServerBan
+		added bool
+		ok    bool
+	}{
+		{
+			text:  "*** Notice -- oper!oper@staff/oper{oper} added temporary 60 min. K-Line for [*@192.0.2.1] [spamming|oper note]",
+			want:  ServerBan{Type: "K", Mask: "*@192.0.2.1", SetBy: "oper!oper@staff/oper", Duration: time.Hour, Reason: "spamming|oper note"},
+			added: true, ok: true,
+		},
+		{
+			text:  "*** Notice -- oper!oper@staff/oper{oper} added D-Line for [192.0.2.0/24] [abuse]",
+			want:  ServerBan{Type: "Z", Mask: "192.0.2.0/24", SetBy: "oper!oper@staff/oper", Reason: "abuse"},
+			added: true, ok: true,
+		},
+		{
+			text: "*** Notice -- oper!oper@staff/oper{oper} has removed the temporary K-Line for: [*@192.0.2.1]",
+			want: ServerBan{Type: "K", Mask: "*@192.0.2.1", SetBy: "oper!oper@staff/oper"},
+			ok:   true,
+		},
+		{
+			text:  "*** XLINE: oper added timed G-line for *@192.0.2.1, expires in 1d2h (on Fri Oct 16 2026 12:00:00): ban evasion: again",
+			want:  ServerBan{Type: "G", Mask: "*@192.0.2.1", SetBy: "oper", Duration: 26 * time.Hour, Reason: "ban evasion: again"},
+			added: true, ok: true,
+		},
+		{
+			text:  "*** XLINE: oper added permanent Z-line for 192.0.2.1: abuse",
+			want:  ServerBan{Type: "Z", Mask: "192.0.2.1", SetBy: "oper", Reason: "abuse"},
+			added: true, ok: true,
+		},
+		{
+			text: "*** XLINE: oper removed K-line on *@192.0.2.1: abuse",
+			want: ServerBan{Type: "K", Mask: "*@192.0.2.1", SetBy: "oper", Reason: "abuse"},
+			ok:   true,
+		},
+		{
+			text:  "*** G-Line added: '*@192.0.2.1' [reason: spam [bot]] [by: oper!oper@staff] [duration: 1h]",
+			want:  ServerBan{Type: "G", Mask: "*@192.0.2.1", SetBy: "oper!oper@staff", Duration: time.Hour, Reason: "spam [bot]"},
+			added: true, ok: true,
+		},
+		{
+			text: "*** [tkl] Global Z-Line removed: '*@192.0.2.1' [reason: abuse] [by: oper!oper@staff] [set at: 2026-10-16]",
+			want: ServerBan{Type: "Z", Mask: "*@192.0.2.1", SetBy: "oper!oper@staff", Reason: "abuse"},
+			ok:   true,
+		},
+		{text: "*** Notice -- Client connecting: nick (user@host) [192.0.2.1]"},
+		{text: "*** Looking up your hostname..."},
+		{text: "K-Line added: '*@192.0.2.1'"},
+	}
+
+	for _, tt := range tests {
+		notice, ok := parseServerNotice(DefaultServerNotices, tt.text)
+		ok = ok && (notice.Kind == ServerNoticeBanAdded || notice.Kind == ServerNoticeBanRemoved)
+		got, added := notice.Ban, notice.Kind == ServerNoticeBanAdded
+		if ok != tt.ok || (ok && (got != tt.want || added != tt.added)) {
+			t.Errorf("parseServerNotice(%q) = %#v, %t, %t, want %#v, %t, %t", tt.text, got, added, ok, tt.want, tt.added, tt.ok)
+		}
+	}
+}
+
+func TestServerBanEvents(t *testing.T) {
+	c, server := mockClient(t, Config{})
+	defer c.Stop()
+
+	bans := make(chan Event, 2)
+	c.Handlers.Add(SERVER_BAN_ADDED, func(c *Client, e Event) { bans <- e })
+	c.Handlers.Add(SERVER_BAN_REMOVED, func(c *Client, e Event) { bans <- e })
+
+	server.send(":irc.example.com NOTICE nick :*** XLINE: oper added timed K-line for *@192.0.2.1, expires in 1h (on Fri Oct 16 2026): abuse")
+	server.send(":spoof!spoof@host NOTICE nick :*** XLINE: oper removed K-line on *@192.0.2.2: abuse")
+	server.send(":irc.example.com NOTICE nick :*** XLINE: oper removed K-line on *@192.0.2.1: abuse")
+
+	want := []ServerBan{
+		{Type: "K", Mask: "*@192.0.2.1", SetBy: "oper", Duration: time.Hour, Reason: "abuse"},
+		{Type: "K", Mask: "*@192.0.2.1", SetBy: "oper", Reason: "abuse"},
+	}
+
+	for i, w := range want {
+		select {
+		case e := <-bans:
+			if got, ok := ParseServerBan(e); !ok || got != w {
+				t.Errorf("ban %d = %#v, %t, want %#v", i, got, ok, w)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatalf("ban %d never dispatched", i)
+		}
+	}
+}
+
+func TestOperCommands(t *testing.T) {
+	tests := []struct {
+		version string
+		run     func(cmd *Commands) error
+		want    string
+		err     error
+	}{
+		{version: "solanum-1.0", run: func(cmd *Commands) error { return cmd.KLine("*@192.0.2.1", 90*time.Second, "abuse") }, want: "KLINE 2 *@192.0.2.1 :abuse"},
+		{version: "solanum-1.0", run: func(cmd *Commands) error { return cmd.KLine("*@192.0.2.1", 0, "") }, want: "KLINE *@192.0.2.1 :No reason"},
+		{version: "solanum-1.0", run: func(cmd *Commands) error { return cmd.UnKLine("*@192.0.2.1") }, want: "UNKLINE *@192.0.2.1"},
+		{version: "solanum-1.0", run: func(cmd *Commands) error { return cmd.ZLine("*@192.0.2.1", time.Hour, "abuse") }, want: "DLINE 60 192.0.2.1 :abuse"},
+		{version: "solanum-1.0", run: func(cmd *Commands) error { return cmd.UnZLine("192.0.2.1") }, want: "UNDLINE 192.0.2.1"},
+		{version: "solanum-1.0", run: func(cmd *Commands) error { return cmd.GLine("*@192.0.2.1", 0, "abuse") }, err: ErrOperUnsupported},
+		{version: "solanum-1.0", run: func(cmd *Commands) error { return cmd.SAJoin("bad", "#chan") }, err: ErrOperUnsupported},
+		{version: "InspIRCd-3", run: func(cmd *Commands) error { return cmd.GLine("*@192.0.2.1", time.Hour, "abuse") }, want: "GLINE *@192.0.2.1 3600 :abuse"},
+		{version: "InspIRCd-3", run: func(cmd *Commands) error { return cmd.UnGLine("*@192.0.2.1") }, want: "GLINE *@192.0.2.1"},
+		{version: "InspIRCd-3", run: func(cmd *Commands) error { return cmd.ZLine("*@192.0.2.1", 0, "abuse") }, want: "ZLINE 192.0.2.1 0 :abuse"},
+		{version: "InspIRCd-3", run: func(cmd *Commands) error { return cmd.SAJoin("bad", "#a", "#b") }, want: "SAJOIN bad #a,#b"},
+		{version: "UnrealIRCd-6.1.0", run: func(cmd *Commands) error { return cmd.UnKLine("*@192.0.2.1") }, want: "KLINE -*@192.0.2.1"},
+		{version: "UnrealIRCd-6.1.0", run: func(cmd *Commands) error { return cmd.SAMode("#chan", "+o", "nick") }, want: "SAMODE #chan +o nick"},
+		{version: "UnrealIRCd-6.1.0", run: func(cmd *Commands) error { return cmd.SANick("bad", "good") }, want: "SANICK bad good"},
+		{version: "UnrealIRCd-6.1.0", run: func(cmd *Commands) error { return cmd.Kill("bad", "abuse") }, want: "KILL bad :abuse"},
+		{version: "UnrealIRCd-6.1.0", run: func(cmd *Commands) error { return cmd.KLine("bad mask", 0, "") }, err: &ErrInvalidTarget{Target: "bad mask"}},
+	}
+
+	for _, tt := range tests {
+		c, server := mockClient(t, Config{})
+
+		server.send(":irc.example.com 004 nick irc.example.com " + tt.version + " iosw biklmnopstv")
+		server.send("PING :sync")
+		server.expect("PONG sync")
+
+		err := tt.run(c.Commands)
+		if (err == nil) != (tt.err == nil) || (err != nil && err.Error() != tt.err.Error()) {
+			t.Errorf("with %s: error = %v, want %v", tt.version, err, tt.err)
+		}
+
+		if tt.want != "" {
+			server.expect(tt.want)
+		}
+
+		c.Stop()
+	}
+}
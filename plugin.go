@@ -0,0 +1,268 @@
+// Copyright (c) Liam Stanley <me@liamstanley.io>. All rights reserved. Use
+// of this source code is governed by the MIT license that can be found in
+// the LICENSE file.
+
+package girc
+
+import (
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+// Plugin is a bundle of functionality (e.g. handlers and background tasks)
+// which can be loaded into, and unloaded from, a client at runtime, without
+// reconnecting. See Client.Plugins and Bundle.
+type Plugin interface {
+	// Name returns the unique name of the plugin.
+	Name() string
+	// Attach adds the functionality of the plugin to the client. If it
+	// returns an error, Detach is called to undo any partial changes.
+	Attach(c *Client) error
+	// Detach removes everything Attach added to the client.
+	Detach(c *Client) error
+}
+
+// ErrPluginLoaded is returned by Plugins.Load when a plugin with the same
+// name is already loaded.
+type ErrPluginLoaded struct {
+	Name string
+}
+
+func (e *ErrPluginLoaded) Error() string { return "plugin already loaded: " + e.Name }
+
+// ErrPluginNotLoaded is returned by Plugins.Unload when no plugin with the
+// given name is loaded.
+type ErrPluginNotLoaded struct {
+	Name string
+}
+
+func (e *ErrPluginNotLoaded) Error() string { return "plugin not loaded: " + e.Name }
+
+// ErrInvalidInterval is returned when a periodic function (e.g. a
+// BundleTicker) is given an interval which isn't positive.
+var ErrInvalidInterval = errors.New("interval must be positive")
+
+// Plugins manages the plugins loaded into a client.
+type Plugins struct {
+	c *Client
+	// mu serializes loading and unloading, so plugins are attached and
+	// detached one at a time.
+	mu     sync.Mutex
+	loaded map[string]Plugin
+}
+
+// newPlugins returns a new, empty, set of plugins for c.
+func newPlugins(c *Client) *Plugins {
+	return &Plugins{c: c, loaded: make(map[string]Plugin)}
+}
+
+// Load attaches the plugin to the client. If attaching fails, the plugin is
+// detached again, and the error is returned.
+func (p *Plugins) Load(plugin Plugin) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return p.load(plugin)
+}
+
+// load is much like Load, however is NOT concurrency safe. Lock Plugins.mu
+// on your own.
+func (p *Plugins) load(plugin Plugin) error {
+	name := plugin.Name()
+	if _, ok := p.loaded[name]; ok {
+		return &ErrPluginLoaded{Name: name}
+	}
+
+	if err := plugin.Attach(p.c); err != nil {
+		plugin.Detach(p.c)
+		return err
+	}
+
+	p.loaded[name] = plugin
+	p.c.debug.Printf("loaded plugin %s", name)
+
+	return nil
+}
+
+// Unload detaches the plugin with the given name from the client. The
+// plugin is unloaded even if detaching returns an error.
+func (p *Plugins) Unload(name string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return p.unload(name)
+}
+
+// unload is much like Unload, however is NOT concurrency safe. Lock
+// Plugins.mu on your own.
+func (p *Plugins) unload(name string) error {
+	plugin, ok := p.loaded[name]
+	if !ok {
+		return &ErrPluginNotLoaded{Name: name}
+	}
+
+	delete(p.loaded, name)
+	p.c.debug.Printf("unloaded plugin %s", name)
+
+	return plugin.Detach(p.c)
+}
+
+// Reload replaces the loaded plugin with the same name (if any) with
+// plugin, e.g. to apply a new configuration.
+func (p *Plugins) Reload(plugin Plugin) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if _, ok := p.loaded[plugin.Name()]; ok {
+		if err := p.unload(plugin.Name()); err != nil {
+			return err
+		}
+	}
+
+	return p.load(plugin)
+}
+
+// Get returns the loaded plugin with the given name, if any.
+func (p *Plugins) Get(name string) (plugin Plugin, ok bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	plugin, ok = p.loaded[name]
+	return plugin, ok
+}
+
+// List returns the names of the loaded plugins, sorted.
+func (p *Plugins) List() []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	names := make([]string, 0, len(p.loaded))
+	for name := range p.loaded {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// BundleHandler is a handler of a Bundle, for the given command.
+type BundleHandler struct {
+	Command string
+	Handler Handler
+}
+
+// BundleTicker is a function of a Bundle which is called every interval
+// while we're connected. As with handlers, panics are passed to
+// Config.RecoverFunc, if set.
+type BundleTicker struct {
+	Every time.Duration
+	Func  func(c *Client)
+}
+
+// Bundle is a Plugin made of handlers and tickers. All of its handlers are
+// registered (and removed) at once, so no event is handled by only some of
+// them. For example:
+//
+//	client.Plugins.Load(&girc.Bundle{
+//		PluginName: "greeter",
+//		Handlers: []girc.BundleHandler{
+//			{Command: girc.JOIN, Handler: girc.HandlerFunc(greet)},
+//		},
+//		Tickers: []girc.BundleTicker{
+//			{Every: time.Hour, Func: announce},
+//		},
+//	})
+type Bundle struct {
+	PluginName string
+	Handlers   []BundleHandler
+	Tickers    []BundleTicker
+
+	mu     sync.Mutex
+	cuids  []string
+	cancel context.CancelFunc
+}
+
+// Name implements Plugin.
+func (b *Bundle) Name() string {
+	return b.PluginName
+}
+
+// Attach implements Plugin, registering the handlers and starting the
+// tickers of the bundle.
+func (b *Bundle) Attach(c *Client) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for _, ticker := range b.Tickers {
+		if ticker.Every <= 0 {
+			return ErrInvalidInterval
+		}
+	}
+
+	c.Handlers.mu.Lock()
+	for _, h := range b.Handlers {
+		b.cuids = append(b.cuids, c.Handlers.registerOwned(h.Command, h.Handler))
+	}
+	c.Handlers.mu.Unlock()
+
+	var ctx context.Context
+	ctx, b.cancel = context.WithCancel(context.Background())
+
+	for _, ticker := range b.Tickers {
+		go runBundleTicker(ctx, c, b.PluginName, ticker)
+	}
+
+	return nil
+}
+
+// Detach implements Plugin, removing the handlers and stopping the tickers
+// of the bundle.
+func (b *Bundle) Detach(c *Client) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	c.Handlers.mu.Lock()
+	for _, cuid := range b.cuids {
+		c.Handlers.remove(cuid)
+	}
+	c.Handlers.mu.Unlock()
+	b.cuids = nil
+
+	if b.cancel != nil {
+		b.cancel()
+		b.cancel = nil
+	}
+
+	return nil
+}
+
+// runBundleTicker calls the function of ticker (of the bundle with the given
+// name) every interval while we're connected, until ctx is done.
+func runBundleTicker(ctx context.Context, c *Client, name string, ticker BundleTicker) {
+	t := time.NewTicker(ticker.Every)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+			if c.IsConnected() {
+				runTickerFunc(c, name, ticker.Func)
+			}
+		}
+	}
+}
+
+// runTickerFunc calls fn, passing panics to Config.RecoverFunc if set.
+func runTickerFunc(c *Client, name string, fn func(c *Client)) {
+	if c.Config.RecoverFunc != nil {
+		defer recoverHandlerPanic(c, &Event{}, name, 3)
+	}
+
+	fn(c)
+}
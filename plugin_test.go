@@ -0,0 +1,108 @@
+// Copyright (c) Liam Stanley <me@liamstanley.io>. All rights reserved. Use
+// of this source code is governed by the MIT license that can be found in
+// the LICENSE file.
+
+package girc
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestPlugins(t *testing.T) {
+	panics := make(chan *HandlerError, 1)
+	c, server := mockClient(t, Config{RecoverFunc: func(c *Client, e *HandlerError) {
+		select {
+		case panics <- e:
+		default:
+		}
+	}})
+	defer c.Stop()
+
+	server.send(":irc.example.com 001 nick :Welcome")
+
+	handled := make(chan string, 10)
+	ticked := make(chan struct{}, 10)
+
+	bundle := &Bundle{
+		PluginName: "greeter",
+		Handlers: []BundleHandler{
+			{Command: PRIVMSG, Handler: HandlerFunc(func(c *Client, e Event) { handled <- e.Trailing })},
+		},
+		Tickers: []BundleTicker{
+			{Every: 10 * time.Millisecond, Func: func(c *Client) { ticked <- struct{}{} }},
+		},
+	}
+
+	if err := c.Plugins.Load(bundle); err != nil {
+		t.Fatalf("Load() returned error: %s", err)
+	}
+
+	if _, ok := c.Plugins.Load(bundle).(*ErrPluginLoaded); !ok {
+		t.Fatal("Load() of an already loaded plugin didn't return ErrPluginLoaded")
+	}
+
+	if names := c.Plugins.List(); !reflect.DeepEqual(names, []string{"greeter"}) {
+		t.Fatalf("List() = %q, want [greeter]", names)
+	}
+
+	server.send(":other!user@host PRIVMSG nick :hello")
+
+	select {
+	case text := <-handled:
+		if text != "hello" {
+			t.Fatalf("handler received %q, want hello", text)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("handler of plugin wasn't executed")
+	}
+
+	select {
+	case <-ticked:
+	case <-time.After(2 * time.Second):
+		t.Fatal("ticker of plugin wasn't called")
+	}
+
+	if err := c.Plugins.Unload("greeter"); err != nil {
+		t.Fatalf("Unload() returned error: %s", err)
+	}
+
+	if _, ok := c.Plugins.Unload("greeter").(*ErrPluginNotLoaded); !ok {
+		t.Fatal("Unload() of a plugin which isn't loaded didn't return ErrPluginNotLoaded")
+	}
+
+	server.send(":other!user@host PRIVMSG nick :ignored")
+	server.send("PING :sync")
+	server.expect("PONG sync")
+
+	if len(handled) > 0 || c.Handlers.Count(PRIVMSG) != 0 {
+		t.Fatal("handler of plugin was executed after unloading it")
+	}
+
+	// Panics of tickers are recovered, as with handlers.
+	err := c.Plugins.Load(&Bundle{
+		PluginName: "panics",
+		Tickers:    []BundleTicker{{Every: 10 * time.Millisecond, Func: func(c *Client) { panic("oops") }}},
+	})
+	if err != nil {
+		t.Fatalf("Load() returned error: %s", err)
+	}
+
+	select {
+	case e := <-panics:
+		if e.Panic != "oops" || e.ID != "panics" {
+			t.Fatalf("unexpected panic: %v (%s)", e.Panic, e.ID)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("panic of ticker wasn't passed to RecoverFunc")
+	}
+
+	if err := c.Plugins.Unload("panics"); err != nil {
+		t.Fatalf("Unload() returned error: %s", err)
+	}
+
+	if err := c.Plugins.Load(&Bundle{PluginName: "invalid", Tickers: []BundleTicker{{}}}); err != ErrInvalidInterval {
+		t.Fatalf("Load() with an invalid ticker returned %v, want ErrInvalidInterval", err)
+	}
+}
@@ -0,0 +1,280 @@
+// Copyright (c) Liam Stanley <me@liamstanley.io>. All rights reserved. Use
+// of this source code is governed by the MIT license that can be found in
+// the LICENSE file.
+
+package girc
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Pool manages a group of clients, e.g. one per network, or one per user
+// relayed by a bridge. Handlers added to the pool run for the events of
+// every client in it (including those added later), clients connecting to
+// the same server share a rate limit, and the clients can be connected and
+// disconnected together.
+type Pool struct {
+	mu      sync.RWMutex
+	clients map[string]*Client
+	// handlers are the handlers added to every client, by ID.
+	handlers map[string]*poolHandler
+	// limiters are the rate limiters shared by the clients connecting to
+	// each server, by lowercased hostname.
+	limiters map[string]*tokenBucket
+	// id is the ID of the last handler added.
+	id int
+}
+
+// PoolHandlerFunc handles an event of a client in a pool, where name is the
+// name the client was added to the pool with. See Pool.Handle.
+type PoolHandlerFunc func(name string, client *Client, event Event)
+
+// poolHandler is a handler added to every client of a pool.
+type poolHandler struct {
+	cmd string
+	fn  PoolHandlerFunc
+	// cuids are the IDs of the handler on each client, by name.
+	cuids map[string]string
+}
+
+// ErrPoolClientExists is returned by Pool.Add when a client with the same
+// name is already in the pool.
+type ErrPoolClientExists struct {
+	Name string
+}
+
+func (e *ErrPoolClientExists) Error() string { return "client already in pool: " + e.Name }
+
+// ErrPool is returned by Pool.Connect when some of the clients were unable
+// to connect.
+type ErrPool struct {
+	// Errors are the errors of the clients which failed, by name.
+	Errors map[string]error
+}
+
+func (e *ErrPool) Error() string {
+	names := make([]string, 0, len(e.Errors))
+	for name := range e.Errors {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	failed := make([]string, len(names))
+	for i := 0; i < len(names); i++ {
+		failed[i] = fmt.Sprintf("%s: %s", names[i], e.Errors[names[i]])
+	}
+
+	return "unable to connect: " + strings.Join(failed, "; ")
+}
+
+// NewPool returns a new, empty, pool.
+func NewPool() *Pool {
+	return &Pool{
+		clients:  make(map[string]*Client),
+		handlers: make(map[string]*poolHandler),
+		limiters: make(map[string]*tokenBucket),
+	}
+}
+
+// Add adds a client to the pool, under a unique name (e.g. the name of the
+// network). The handlers of the pool are added to the client, and unless
+// Config.AllowFlood is set, it shares its rate limit with the other clients
+// of the pool connecting to the same server, starting with its next
+// connection. The shared limit is that of the first client added for the
+// server (see Config.RateBurst and Config.RateInterval).
+func (p *Pool) Add(name string, client *Client) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if _, ok := p.clients[name]; ok {
+		return &ErrPoolClientExists{Name: name}
+	}
+	p.clients[name] = client
+
+	for _, h := range p.handlers {
+		h.cuids[name] = client.Handlers.sregisterOwned(h.cmd, HandlerFunc(h.handler(name)))
+	}
+
+	if !client.allowFlood() {
+		client.confmu.RLock()
+		burst, interval := client.Config.RateBurst, client.Config.RateInterval
+		client.confmu.RUnlock()
+
+		server, _ := client.configServer()
+		host := strings.ToLower(server)
+		limiter := p.limiters[host]
+		if limiter == nil {
+			limiter = newTokenBucket(burst, interval)
+			p.limiters[host] = limiter
+		}
+
+		client.cmux.Lock()
+		client.limiter = limiter
+		client.cmux.Unlock()
+	}
+
+	return nil
+}
+
+// Remove removes a client from the pool (without disconnecting it),
+// removing the handlers of the pool from it, and returns it. Returns nil if
+// no client with the name is in the pool.
+func (p *Pool) Remove(name string) *Client {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	client, ok := p.clients[name]
+	if !ok {
+		return nil
+	}
+	delete(p.clients, name)
+
+	for _, h := range p.handlers {
+		client.Handlers.Remove(h.cuids[name])
+		delete(h.cuids, name)
+	}
+
+	client.cmux.Lock()
+	client.limiter = nil
+	client.cmux.Unlock()
+
+	return client
+}
+
+// Get returns the client with the given name.
+func (p *Pool) Get(name string) (client *Client, ok bool) {
+	p.mu.RLock()
+	client, ok = p.clients[name]
+	p.mu.RUnlock()
+
+	return client, ok
+}
+
+// Names returns the (sorted) names of the clients in the pool.
+func (p *Pool) Names() []string {
+	p.mu.RLock()
+	names := make([]string, 0, len(p.clients))
+	for name := range p.clients {
+		names = append(names, name)
+	}
+	p.mu.RUnlock()
+
+	sort.Strings(names)
+	return names
+}
+
+// Handle adds a handler for cmd (or ALLEVENTS) to every client of the pool,
+// including those added later. The handler is given the name of the client
+// the event is from. Returns the ID of the handler, for use with
+// RemoveHandler.
+func (p *Pool) Handle(cmd string, fn PoolHandlerFunc) (id string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.id++
+	id = "pool:" + strconv.Itoa(p.id)
+
+	h := &poolHandler{cmd: cmd, fn: fn, cuids: make(map[string]string)}
+	for name, client := range p.clients {
+		h.cuids[name] = client.Handlers.sregisterOwned(cmd, HandlerFunc(h.handler(name)))
+	}
+	p.handlers[id] = h
+
+	return id
+}
+
+// RemoveHandler removes a handler added with Handle from every client of
+// the pool. Returns false if the handler wasn't found.
+func (p *Pool) RemoveHandler(id string) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	h, ok := p.handlers[id]
+	if !ok {
+		return false
+	}
+	delete(p.handlers, id)
+
+	for name, cuid := range h.cuids {
+		if client, ok := p.clients[name]; ok {
+			client.Handlers.Remove(cuid)
+		}
+	}
+
+	return true
+}
+
+// handler returns the handler for the client with the given name.
+func (h *poolHandler) handler(name string) func(client *Client, event Event) {
+	return func(client *Client, event Event) {
+		h.fn(name, client, event)
+	}
+}
+
+// Connect connects every client of the pool which isn't connected yet, at
+// the same time, and waits until they have. If some of them fail, ErrPool
+// is returned with the error of each of them.
+func (p *Pool) Connect() error {
+	p.mu.RLock()
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	errs := make(map[string]error)
+	for name, client := range p.clients {
+		if client.IsConnected() {
+			continue
+		}
+
+		wg.Add(1)
+		go func(name string, client *Client) {
+			defer wg.Done()
+
+			if err := client.Connect(); err != nil {
+				mu.Lock()
+				errs[name] = err
+				mu.Unlock()
+			}
+		}(name, client)
+	}
+	p.mu.RUnlock()
+
+	wg.Wait()
+
+	if len(errs) > 0 {
+		return &ErrPool{Errors: errs}
+	}
+
+	return nil
+}
+
+// Quit disconnects every connected client of the pool, with the given quit
+// message (if not empty).
+func (p *Pool) Quit(message string) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	for _, client := range p.clients {
+		if !client.IsConnected() {
+			continue
+		}
+
+		if message == "" {
+			client.Quit()
+		} else {
+			client.QuitWithMessage(message)
+		}
+	}
+}
+
+// Stop stops every client of the pool. See Client.Stop.
+func (p *Pool) Stop() {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	for _, client := range p.clients {
+		client.Stop()
+	}
+}
@@ -0,0 +1,96 @@
+// Copyright (c) Liam Stanley <me@liamstanley.io>. All rights reserved. Use
+// of this source code is governed by the MIT license that can be found in
+// the LICENSE file.
+
+package girc
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestPool(t *testing.T) {
+	a, serverA := mockClient(t, Config{})
+	defer a.Stop()
+	b, serverB := mockClient(t, Config{})
+	defer b.Stop()
+
+	p := NewPool()
+	if err := p.Add("a", a); err != nil {
+		t.Fatalf("Add(a) failed: %s", err)
+	}
+
+	var mu sync.Mutex
+	var got []string
+	received := make(chan struct{}, 10)
+	id := p.Handle(PRIVMSG, func(name string, client *Client, e Event) {
+		mu.Lock()
+		got = append(got, name+":"+e.Trailing)
+		mu.Unlock()
+		received <- struct{}{}
+	})
+
+	// Handlers are also added to clients added later.
+	if err := p.Add("b", b); err != nil {
+		t.Fatalf("Add(b) failed: %s", err)
+	}
+
+	var exists *ErrPoolClientExists
+	if err := p.Add("a", b); !errors.As(err, &exists) {
+		t.Errorf("Add() with a duplicate name returned %v", err)
+	}
+
+	if names := p.Names(); len(names) != 2 || names[0] != "a" || names[1] != "b" {
+		t.Errorf("Names() = %q", names)
+	}
+
+	if a.limiter == nil || a.limiter != b.limiter {
+		t.Error("clients of the same server don't share a rate limiter")
+	}
+
+	serverA.send(":other!user@host PRIVMSG #channel :from a")
+	serverB.send(":other!user@host PRIVMSG #channel :from b")
+	for i := 0; i < 2; i++ {
+		select {
+		case <-received:
+		case <-time.After(2 * time.Second):
+			t.Fatal("timed out waiting for events")
+		}
+	}
+
+	mu.Lock()
+	if len(got) != 2 || (got[0] != "a:from a" && got[1] != "a:from a") || (got[0] != "b:from b" && got[1] != "b:from b") {
+		t.Errorf("events = %q", got)
+	}
+	mu.Unlock()
+
+	if p.Remove("b") != b || b.limiter != nil {
+		t.Error("Remove(b) didn't remove the client")
+	}
+
+	if !p.RemoveHandler(id) || p.RemoveHandler(id) {
+		t.Error("RemoveHandler() didn't remove the handler once")
+	}
+
+	serverA.send(":other!user@host PRIVMSG #channel :again")
+	serverB.send(":other!user@host PRIVMSG #channel :again")
+	serverA.send("PING :sync")
+	serverA.expect("PONG sync")
+	serverB.send("PING :sync")
+	serverB.expect("PONG sync")
+
+	mu.Lock()
+	if len(got) != 2 {
+		t.Errorf("handlers still ran after being removed: %q", got)
+	}
+	mu.Unlock()
+}
+
+func TestErrPool(t *testing.T) {
+	err := &ErrPool{Errors: map[string]error{"b": errors.New("refused"), "a": errors.New("timeout")}}
+	if want := "unable to connect: a: timeout; b: refused"; err.Error() != want {
+		t.Errorf("Error() = %q, want %q", err.Error(), want)
+	}
+}
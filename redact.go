@@ -0,0 +1,248 @@
+// Copyright (c) Liam Stanley <me@liamstanley.io>. All rights reserved. Use
+// of this source code is governed by the MIT license that can be found in
+// the LICENSE file.
+
+package girc
+
+import "strings"
+
+// redactedText replaces the sensitive parts of redacted events.
+const redactedText = "***"
+
+// redactServices are the services (e.g. NickServ) which messages with
+// credentials are sent to. QuakeNet's Q is usually addressed as
+// "Q@CServe.quakenet.org".
+var redactServices = []string{"nickserv", "q", "authserv", "userserv"}
+
+// redactServiceCommands are the services commands which take credentials.
+var redactServiceCommands = []string{
+	"IDENTIFY", "ID", "LOGIN", "AUTH", "REGISTER", "GHOST", "REGAIN",
+	"RECOVER", "RELEASE", "GROUP", "SET",
+}
+
+// saslMechanisms are the well known SASL mechanisms, which AUTHENTICATE
+// starts with. Anything else sent with AUTHENTICATE may be credentials.
+var saslMechanisms = []string{
+	"PLAIN", "EXTERNAL", "SCRAM-SHA-1", "SCRAM-SHA-256", "SCRAM-SHA-512",
+	"ECDSA-NIST256P-CHALLENGE",
+}
+
+// RedactMatcher returns true if the event contains sensitive data (e.g.
+// credentials) which must not be logged. See Config.Redact.
+type RedactMatcher func(event *Event) bool
+
+// eventArgs returns the params of event, with its trailing parameter (if
+// any) as the last one.
+func eventArgs(event *Event) []string {
+	args := append([]string(nil), event.Params...)
+	if event.Trailing != "" || event.EmptyTrailing {
+		args = append(args, event.Trailing)
+	}
+
+	return args
+}
+
+// setEventArgs sets the params of event from args, with the last one as the
+// trailing parameter if it was before, or if it must be.
+func setEventArgs(event *Event, args []string) {
+	trailing := event.Trailing != "" || event.EmptyTrailing
+	if len(args) > 0 && strings.IndexByte(args[len(args)-1], eventSpace) > -1 {
+		trailing = true
+	}
+
+	if !trailing || len(args) == 0 {
+		event.Params = args
+		event.Trailing = ""
+		return
+	}
+
+	event.Params = args[:len(args)-1]
+	event.Trailing = args[len(args)-1]
+}
+
+// serviceText returns the text of a message to services, e.g. the text of
+// "PRIVMSG NickServ :IDENTIFY password" or "NS IDENTIFY password", and the
+// index of the argument it starts at. ok is false if event isn't one.
+func serviceText(event *Event, service string) (text string, start int, ok bool) {
+	switch event.Command {
+	case PRIVMSG, NOTICE:
+		if len(event.Params) < 1 {
+			return "", 0, false
+		}
+
+		target := event.Params[0]
+		if i := strings.IndexByte(target, 0x40); i > -1 { // @
+			target = target[:i]
+		}
+
+		found := service != "" && ToRFC1459(target) == ToRFC1459(service)
+		for i := 0; i < len(redactServices) && !found; i++ {
+			found = ToRFC1459(target) == redactServices[i]
+		}
+		if !found {
+			return "", 0, false
+		}
+
+		return strings.Join(eventArgs(event)[1:], " "), 1, true
+	case "NICKSERV", "NS":
+		return strings.Join(eventArgs(event), " "), 0, true
+	}
+
+	return "", 0, false
+}
+
+// isServiceLogin returns true if text is a services command which takes
+// credentials, e.g. "IDENTIFY password".
+func isServiceLogin(text string) bool {
+	word := strings.ToUpper(strings.TrimSpace(text))
+	if i := strings.IndexByte(word, eventSpace); i > -1 {
+		word = word[:i]
+	}
+
+	for _, cmd := range redactServiceCommands {
+		if word == cmd {
+			return true
+		}
+	}
+
+	return false
+}
+
+// sensitiveEvent returns true if event is known to contain credentials:
+// PASS, WEBIRC, OPER and AUTHENTICATE (other than mechanism names, which
+// aren't sensitive), outgoing JOIN with keys, and logins sent to services (e.g.
+// NickServ IDENTIFY). service is the name of the NickServ service, if not
+// one of the usual names.
+func sensitiveEvent(event *Event, service string) bool {
+	switch event.Command {
+	case PASS, WEBIRC, OPER:
+		return true
+	case AUTHENTICATE:
+		args := eventArgs(event)
+		if len(args) == 0 || args[0] == "+" || args[0] == "*" {
+			return false
+		}
+
+		for _, mech := range saslMechanisms {
+			if args[0] == mech {
+				return false
+			}
+		}
+
+		return true
+	case JOIN:
+		// Only our own JOINs have keys. Others may have an account and real
+		// name instead (see the extended-join capability).
+		return event.Source == nil && len(eventArgs(event)) > 1
+	}
+
+	text, _, ok := serviceText(event, service)
+	return ok && isServiceLogin(text)
+}
+
+// redactEvent returns a copy of event with its sensitive parts replaced,
+// keeping enough of the event (e.g. the command, and the target of
+// messages) to be useful in logs.
+func redactEvent(event *Event, service string) *Event {
+	out := event.Copy()
+	out.Sensitive = true
+	args := eventArgs(out)
+
+	// redact replaces the arguments from the given index.
+	redact := func(from int) {
+		for i := from; i < len(args); i++ {
+			args[i] = redactedText
+		}
+	}
+
+	switch out.Command {
+	case PASS, AUTHENTICATE:
+		redact(0)
+	case WEBIRC:
+		// WEBIRC <password> <gateway> <hostname> <ip>
+		if len(args) > 0 {
+			args[0] = redactedText
+		}
+	case OPER:
+		// OPER <name> <password>
+		redact(1)
+	case JOIN:
+		// JOIN <channels> <keys>
+		redact(1)
+	default:
+		if text, start, ok := serviceText(out, service); ok {
+			// Keep the services command, e.g. "IDENTIFY ***".
+			word := strings.TrimSpace(text)
+			if i := strings.IndexByte(word, eventSpace); i > -1 {
+				word = word[:i]
+			}
+
+			args = append(args[:start], word+" "+redactedText)
+			break
+		}
+
+		// Unknown events are only identified by their command, and target
+		// (if any).
+		if len(args) > 1 {
+			redact(1)
+		} else {
+			redact(0)
+		}
+	}
+
+	setEventArgs(out, args)
+	return out
+}
+
+// Redacted returns a copy of the event with the sensitive parts (e.g.
+// passwords) replaced with "***", if it's known to be sensitive: if it's
+// marked as sensitive (see Event.Sensitive), or is PASS, WEBIRC, OPER,
+// AUTHENTICATE, JOIN with keys, or a login sent to services (e.g. NickServ
+// IDENTIFY). Otherwise, the event itself is returned. Use Client.Redact to
+// also apply Config.Redact. Useful to log events in send hooks or handlers.
+func (e *Event) Redacted() *Event {
+	if !e.Sensitive && !sensitiveEvent(e, "") {
+		return e
+	}
+
+	return redactEvent(e, "")
+}
+
+// nickServService returns the name of the configured NickServ service, if
+// any.
+func (c *Client) nickServService() string {
+	if c.Config.NickServ == nil {
+		return ""
+	}
+
+	return c.Config.NickServ.service()
+}
+
+// isSensitive returns true if event contains sensitive data, according to
+// the built-in rules (see Event.Redacted), and Config.Redact.
+func (c *Client) isSensitive(event *Event) bool {
+	if event.Sensitive || sensitiveEvent(event, c.nickServService()) {
+		return true
+	}
+
+	for _, match := range c.Config.Redact {
+		if match(event) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Redact is much like Event.Redacted, however also redacts the events
+// matched by Config.Redact, and messages to the configured NickServ
+// service (see Config.NickServ). Events sent by, and received by, the
+// client are already marked as sensitive where needed, and are redacted in
+// the debug log (see Config.Debug).
+func (c *Client) Redact(event *Event) *Event {
+	if !c.isSensitive(event) {
+		return event
+	}
+
+	return redactEvent(event, c.nickServService())
+}
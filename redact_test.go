@@ -0,0 +1,137 @@
+// Copyright (c) Liam Stanley <me@liamstanley.io>. All rights reserved. Use
+// of this source code is governed by the MIT license that can be found in
+// the LICENSE file.
+
+package girc
+
+import (
+	"bytes"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestEventRedacted(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{in: "PASS :hunter2", want: "PASS :***"},
+		{in: "PASS hunter2", want: "PASS ***"},
+		{in: "OPER admin hunter2", want: "OPER admin ***"},
+		{in: "WEBIRC hunter2 gateway host.example.com 127.0.0.1", want: "WEBIRC *** gateway host.example.com 127.0.0.1"},
+		{in: "AUTHENTICATE PLAIN", want: "AUTHENTICATE PLAIN"},
+		{in: "AUTHENTICATE +", want: "AUTHENTICATE +"},
+		{in: "AUTHENTICATE dXNlcgB1c2VyAGh1bnRlcjI=", want: "AUTHENTICATE ***"},
+		{in: "JOIN #a,#b key", want: "JOIN #a,#b ***"},
+		{in: "JOIN #a", want: "JOIN #a"},
+		{in: ":nick!user@host JOIN #a account :Real Name", want: ":nick!user@host JOIN #a account :Real Name"},
+		{in: "PRIVMSG NickServ :IDENTIFY account hunter2", want: "PRIVMSG NickServ :IDENTIFY ***"},
+		{in: "PRIVMSG nickserv :ghost nick hunter2", want: "PRIVMSG nickserv :ghost ***"},
+		{in: "PRIVMSG NickServ :INFO nick", want: "PRIVMSG NickServ :INFO nick"},
+		{in: "PRIVMSG Q@CServe.quakenet.org :AUTH account hunter2", want: "PRIVMSG Q@CServe.quakenet.org :AUTH ***"},
+		{in: "NS IDENTIFY hunter2", want: "NS :IDENTIFY ***"},
+		{in: "PRIVMSG #channel :identify yourself", want: "PRIVMSG #channel :identify yourself"},
+	}
+
+	for _, tt := range tests {
+		event := ParseEvent(tt.in)
+		if got := event.Redacted().String(); got != tt.want {
+			t.Errorf("Redacted(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+
+		if event.String() != ParseEvent(tt.in).String() {
+			t.Errorf("Redacted(%q) modified the event", tt.in)
+		}
+	}
+
+	sensitive := &Event{Command: PRIVMSG, Params: []string{"Bot"}, Trailing: "secret stuff", Sensitive: true}
+	if got := sensitive.Redacted().String(); got != "PRIVMSG Bot :***" {
+		t.Errorf("Redacted() of a sensitive event = %q", got)
+	}
+}
+
+func TestClientRedact(t *testing.T) {
+	c := New(Config{
+		Server:   "irc.example.com",
+		Nick:     "nick",
+		User:     "user",
+		NickServ: &NickServ{Service: "AuthBot", Password: "hunter2"},
+		Redact: []RedactMatcher{func(e *Event) bool {
+			return e.Command == PRIVMSG && strings.HasPrefix(e.Trailing, "!login ")
+		}},
+	})
+
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{in: "PRIVMSG AuthBot :IDENTIFY hunter2", want: "PRIVMSG AuthBot :IDENTIFY ***"},
+		{in: "PRIVMSG Bot :!login hunter2", want: "PRIVMSG Bot :***"},
+		{in: "PRIVMSG Bot :!help", want: "PRIVMSG Bot :!help"},
+	}
+
+	for _, tt := range tests {
+		if got := c.Redact(ParseEvent(tt.in)).String(); got != tt.want {
+			t.Errorf("Redact(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+// lockedBuffer is a bytes.Buffer which is safe for concurrent use.
+type lockedBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *lockedBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *lockedBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+func TestRedactDebug(t *testing.T) {
+	debug := &lockedBuffer{}
+	var hooked []*Event
+	var mu sync.Mutex
+
+	c, server := mockClient(t, Config{ServerPass: "hunter2", Debug: debug})
+	defer c.Stop()
+
+	c.AddSendHook(func(e *Event) (*Event, error) {
+		mu.Lock()
+		hooked = append(hooked, e.Copy())
+		mu.Unlock()
+		return e, nil
+	})
+
+	server.expect("PASS hunter2")
+	c.Commands.Message("NickServ", "IDENTIFY hunter2")
+	server.expect("PRIVMSG NickServ :IDENTIFY hunter2")
+
+	// Echoed back by the server.
+	server.send(":nick!user@host PRIVMSG NickServ :IDENTIFY hunter2")
+	server.send("PING :sync")
+	server.expect("PONG sync")
+
+	if out := debug.String(); strings.Contains(out, "hunter2") {
+		t.Fatalf("password was logged:\n%s", out)
+	}
+	if out := debug.String(); !strings.Contains(out, "> PASS ***") || !strings.Contains(out, "< :nick!user@host PRIVMSG NickServ :IDENTIFY ***") {
+		t.Fatalf("expected redacted lines to be logged:\n%s", out)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	for _, e := range hooked {
+		if e.Command == PRIVMSG && !e.Sensitive {
+			t.Fatalf("send hook was passed an unmarked sensitive event: %s", e.Redacted())
+		}
+	}
+}
@@ -0,0 +1,59 @@
+// Copyright (c) Liam Stanley <me@liamstanley.io>. All rights reserved. Use
+// of this source code is governed by the MIT license that can be found in
+// the LICENSE file.
+
+package girc
+
+import "strconv"
+
+// handleREDIR follows the redirect of the server to another server
+// (RPL_REDIR, sometimes called RPL_BOUNCE), if enabled with
+// Config.Redirects: Config.Server and Config.Port are updated, and we
+// reconnect to the new server. The redirect is ignored once Config.Redirects
+// redirects were followed without registering.
+func handleREDIR(c *Client, e Event) {
+	if len(e.Params) < 3 || c.Config.WebSocketURL != "" {
+		return
+	}
+
+	host := e.Params[1]
+	port, err := strconv.Atoi(e.Params[2])
+	if err != nil || host == "" || port < 1 || port > 65535 {
+		return
+	}
+
+	c.cmux.Lock()
+	if c.redirects >= c.Config.Redirects {
+		c.cmux.Unlock()
+		c.debug.Printf("not following redirect to %s:%d", host, port)
+		return
+	}
+	c.redirects++
+	c.cmux.Unlock()
+
+	// Stop the current connection first, so the server closing it isn't
+	// taken as an unexpected disconnect.
+	old := c.Server()
+	c.cleanup(false)
+
+	c.confmu.Lock()
+	c.Config.Server, c.Config.Port = host, port
+	c.confmu.Unlock()
+
+	c.debug.Printf("redirected from %s to %s", old, c.Server())
+	c.RunHandlers(&Event{Command: SERVER_REDIRECTED, Params: []string{old, c.Server()}, Trailing: e.Trailing})
+
+	go func() {
+		if err := c.reconnect(true); err != nil && c.Config.HandleError != nil {
+			c.Config.HandleError(err)
+		}
+	}()
+}
+
+// handleRedirected resets the amount of redirects which were followed, once
+// we've registered.
+func handleRedirected(c *Client, e Event) {
+	c.cmux.Lock()
+	c.redirects = 0
+	c.cmux.Unlock()
+}
@@ -0,0 +1,83 @@
+// Copyright (c) Liam Stanley <me@liamstanley.io>. All rights reserved. Use
+// of this source code is governed by the MIT license that can be found in
+// the LICENSE file.
+
+package girc
+
+import (
+	"bufio"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestRedirect(t *testing.T) {
+	c, server := mockClient(t, Config{Redirects: 1})
+	defer c.Stop()
+
+	redirected := make(chan Event, 1)
+	c.Handlers.Add(SERVER_REDIRECTED, func(c *Client, e Event) { redirected <- e })
+
+	// The next connection is to the server we're redirected to.
+	dialer := c.Config.Dialer.(*mockDialer)
+	local, remote := net.Pipe()
+	dialer.conn = local
+	next := &mockServer{t: t, conn: remote, r: bufio.NewReader(remote)}
+
+	// Server may be called while the redirect is followed.
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		for {
+			select {
+			case <-done:
+				return
+			default:
+				c.Server()
+			}
+		}
+	}()
+
+	server.send(":irc.example.com 010 * irc2.example.com 6697 :Server full, use another server")
+
+	select {
+	case e := <-redirected:
+		want := "SERVER_REDIRECTED irc.example.com:6667 irc2.example.com:6697 :Server full, use another server"
+		if e.String() != want {
+			t.Fatalf("got %q, want %q", e.String(), want)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("never received SERVER_REDIRECTED")
+	}
+
+	next.expect("NICK nick")
+	if dialer.address != "irc2.example.com:6697" {
+		t.Fatalf("reconnected to %q, want irc2.example.com:6697", dialer.address)
+	}
+
+	if server := c.Server(); server != "irc2.example.com:6697" {
+		t.Fatalf("Server() = %q, want irc2.example.com:6697", server)
+	}
+
+	// Only one redirect is followed in a row.
+	next.send(":irc2.example.com 010 * irc3.example.com 6697 :Server full, use another server")
+	next.send("PING :sync")
+	next.expect("PONG sync")
+
+	if server := c.Server(); server != "irc2.example.com:6697" {
+		t.Fatalf("followed more than Config.Redirects redirects, to %q", server)
+	}
+}
+
+func TestRedirectDisabled(t *testing.T) {
+	c, server := mockClient(t, Config{})
+	defer c.Stop()
+
+	server.send(":irc.example.com 010 * irc2.example.com 6697 :Server full, use another server")
+	server.send("PING :sync")
+	server.expect("PONG sync")
+
+	if server := c.Server(); server != "irc.example.com:6667" {
+		t.Fatalf("followed redirect to %q while disabled", server)
+	}
+}
@@ -0,0 +1,174 @@
+// Copyright (c) Liam Stanley <me@liamstanley.io>. All rights reserved. Use
+// of this source code is governed by the MIT license that can be found in
+// the LICENSE file.
+
+package girc
+
+import (
+	"sync"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+// defaultRegainInterval is the default interval between attempts to regain
+// our nickname.
+const defaultRegainInterval = 1 * time.Minute
+
+// NickRegain configures reclaiming Config.Nick, when it was in use when we
+// registered (see Config.AltNicks). While we're using another nickname, the
+// preferred one is watched with Client.Monitor (MONITOR, or ISON if the
+// server doesn't support it), and we change back to it as soon as it's
+// free, as well as every Interval. NICK_REGAINED is dispatched once we have
+// regained it. Changing our nickname to anything else stops the attempts,
+// until the next connection. Requires tracking to be enabled.
+type NickRegain struct {
+	// Disable disables regaining our nickname.
+	Disable bool
+	// Interval is the interval between attempts. Defaults to 1 minute.
+	Interval time.Duration
+	// Command, if set, is the NickServ command used to disconnect whoever is
+	// using our nickname, e.g. "GHOST", "RECOVER" or "REGAIN". It's sent as
+	// "<Command> <nick> <password>" instead of NICK while they're online,
+	// and requires Config.NickServ with a password.
+	Command string
+}
+
+// interval returns the interval between attempts to regain our nickname.
+func (r NickRegain) interval() time.Duration {
+	if r.Interval <= 0 {
+		return defaultRegainInterval
+	}
+
+	return r.Interval
+}
+
+// nickRegain tracks the attempts to regain our nickname, across
+// connections.
+type nickRegain struct {
+	mu sync.Mutex
+	// monitored is true if we added Config.Nick to Client.Monitor, and need
+	// to remove it once regained.
+	monitored bool
+}
+
+// regainNick attempts to change back to Config.Nick, if we're using another
+// nickname because it was in use when we registered.
+func (c *Client) regainNick() {
+	if c.Config.NickRegain.Disable || c.Config.disableTracking || !c.IsConnected() {
+		return
+	}
+
+	c.state.mu.RLock()
+	regaining := c.state.registered && c.state.regaining
+	c.state.mu.RUnlock()
+
+	if !regaining {
+		return
+	}
+
+	nick := c.configNick()
+
+	c.regain.mu.Lock()
+	add := !c.regain.monitored && !c.Monitor.has(nick)
+	if add {
+		c.regain.monitored = true
+	}
+	c.regain.mu.Unlock()
+
+	if add {
+		c.Monitor.Add(nick)
+	}
+
+	// Ask services to disconnect the user using our nickname, unless they're
+	// known to be gone already. We change back to it once they're gone (see
+	// handleRegainFreed).
+	command := c.Config.NickRegain.Command
+	ns := c.Config.NickServ
+	if online, known := c.Monitor.IsOnline(nick); command != "" && ns != nil && ns.Password != "" && (online || !known) {
+		c.Send(&Event{
+			Command:   PRIVMSG,
+			Params:    []string{ns.service()},
+			Trailing:  command + " " + nick + " " + ns.Password,
+			Sensitive: true,
+		})
+		return
+	}
+
+	c.Send(&Event{Command: NICK, Params: []string{nick}})
+}
+
+// stopRegain stops watching Config.Nick, if it was added to Client.Monitor
+// to regain it.
+func (c *Client) stopRegain() {
+	c.regain.mu.Lock()
+	monitored := c.regain.monitored
+	c.regain.monitored = false
+	c.regain.mu.Unlock()
+
+	if monitored {
+		c.Monitor.Remove(c.configNick())
+	}
+}
+
+// regainLoop periodically attempts to regain our nickname. See
+// Config.NickRegain.
+func (c *Client) regainLoop(ctx context.Context) {
+	if c.Config.NickRegain.Disable || c.Config.disableTracking {
+		return
+	}
+
+	ticker := time.NewTicker(c.Config.NickRegain.interval())
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			c.regainNick()
+		}
+	}
+}
+
+// handleRegain attempts to regain our nickname once connected.
+func handleRegain(c *Client, e Event) {
+	c.regainNick()
+}
+
+// handleRegainFreed attempts to regain our nickname as soon as the user
+// using it quits, changes nickname, or goes offline (see Client.Monitor).
+func handleRegainFreed(c *Client, e Event) {
+	if e.Source == nil || c.toLower(e.Source.Name) != c.toLower(c.configNick()) || c.isSelf(e.Source) {
+		return
+	}
+
+	c.regainNick()
+}
+
+// handleRegained dispatches NICK_REGAINED once our nickname has changed back
+// to Config.Nick, and identifies with NickServ if enabled.
+func handleRegained(c *Client, e Event) {
+	if len(e.Params) < 2 {
+		return
+	}
+
+	c.state.mu.Lock()
+	regaining := c.state.regaining
+	c.state.regaining = false
+	c.state.mu.Unlock()
+
+	if !regaining {
+		return
+	}
+
+	c.stopRegain()
+
+	if c.toLower(e.Params[1]) != c.toLower(c.configNick()) {
+		// Changed to another nickname, e.g. by the user.
+		return
+	}
+
+	c.RunHandlers(&Event{Command: NICK_REGAINED, Params: []string{e.Params[0], e.Params[1]}})
+	c.identify()
+}
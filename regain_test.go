@@ -0,0 +1,88 @@
+// Copyright (c) Liam Stanley <me@liamstanley.io>. All rights reserved. Use
+// of this source code is governed by the MIT license that can be found in
+// the LICENSE file.
+
+package girc
+
+import (
+	"testing"
+	"time"
+)
+
+func TestNickRegain(t *testing.T) {
+	c, server := mockClient(t, Config{
+		AllowFlood: true,
+		NickServ:   &NickServ{Password: "secret"},
+		NickRegain: NickRegain{Command: "GHOST"},
+	})
+	defer c.Stop()
+
+	events := make(chan Event, 1)
+	c.Handlers.Add(NICK_REGAINED, func(c *Client, e Event) { events <- e })
+
+	server.send(":irc.example.com 433 * nick :Nickname is already in use")
+	server.expect("NICK nick_")
+	server.send(":irc.example.com 001 nick_ :Welcome")
+	server.send(":irc.example.com 005 nick_ MONITOR=100 :are supported by this server")
+	server.send(":irc.example.com 422 nick_ :MOTD File is missing")
+
+	server.expect("MONITOR + nick")
+	server.expect("PRIVMSG NickServ :GHOST nick secret")
+
+	// Change back once the ghost has been disconnected.
+	server.send(":irc.example.com 731 nick_ :nick")
+	if line := server.expect("NICK "); line != "NICK nick" {
+		t.Fatalf("unexpected line once offline: %q", line)
+	}
+
+	server.send(":nick_!user@host NICK nick")
+	server.expect("MONITOR - nick")
+	server.expect("PRIVMSG NickServ :IDENTIFY secret")
+
+	select {
+	case e := <-events:
+		if e.String() != "NICK_REGAINED nick_ nick" {
+			t.Fatalf("unexpected event: %q", e.String())
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("NICK_REGAINED wasn't dispatched")
+	}
+}
+
+func TestNickRegainDisabled(t *testing.T) {
+	c, server := mockClient(t, Config{NickRegain: NickRegain{Disable: true}})
+	defer c.Stop()
+
+	server.send(":irc.example.com 433 * nick :Nickname is already in use")
+	server.expect("NICK nick_")
+	server.send(":irc.example.com 001 nick_ :Welcome")
+	server.send(":irc.example.com 422 nick_ :MOTD File is missing")
+	server.send("PING :sync")
+
+	if line := server.expect(""); line != "PONG sync" {
+		t.Fatalf("unexpected line while disabled: %q", line)
+	}
+}
+
+func TestNickRegainedCasemapping(t *testing.T) {
+	c := New(Config{Server: "irc.example.com", Nick: "nick[1]", User: "user"})
+	c.state.mu.Lock()
+	c.state.isupport.Casemapping = CaseMappingASCII
+	c.state.mu.Unlock()
+
+	var got []string
+	c.Handlers.Add(NICK_REGAINED, func(c *Client, e Event) { got = append(got, e.String()) })
+
+	for _, nick := range []string{"NICK{1}", "NICK[1]"} {
+		c.state.mu.Lock()
+		c.state.regaining = true
+		c.state.mu.Unlock()
+
+		c.RunHandlers(&Event{Command: SELF_NICK_CHANGED, Params: []string{"nick_", nick}})
+	}
+
+	// With ascii, NICK{1} isn't our nickname.
+	if len(got) != 1 || got[0] != "NICK_REGAINED nick_ NICK[1]" {
+		t.Fatalf("NICK_REGAINED = %q, want only NICK[1] with ascii", got)
+	}
+}
@@ -0,0 +1,213 @@
+// Copyright (c) Liam Stanley <me@liamstanley.io>. All rights reserved. Use
+// of this source code is governed by the MIT license that can be found in
+// the LICENSE file.
+
+package girc
+
+import (
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	// defaultRejoinDelay is the default delay before attempting to rejoin a
+	// channel.
+	defaultRejoinDelay = 5 * time.Second
+	// defaultRejoinAttempts is the default amount of attempts to rejoin a
+	// channel.
+	defaultRejoinAttempts = 5
+)
+
+// Rejoin configures rejoining channels automatically after being kicked
+// from them. If joining fails (e.g. because we're banned, or the channel has
+// been closed), it's retried until MaxAttempts have been made. REJOINED is
+// dispatched once we're back in the channel, and REJOIN_FAILED once we've
+// given up. The channel key (if known) is used to rejoin. Requires tracking
+// to be enabled.
+type Rejoin struct {
+	// Delay is the delay before each attempt. Defaults to 5 seconds.
+	Delay time.Duration
+	// MaxAttempts is the maximum amount of attempts to rejoin a channel.
+	// Defaults to 5.
+	MaxAttempts int
+}
+
+// delay returns the delay before each attempt to rejoin a channel.
+func (r *Rejoin) delay() time.Duration {
+	if r.Delay <= 0 {
+		return defaultRejoinDelay
+	}
+
+	return r.Delay
+}
+
+// maxAttempts returns the maximum amount of attempts to rejoin a channel.
+func (r *Rejoin) maxAttempts() int {
+	if r.MaxAttempts <= 0 {
+		return defaultRejoinAttempts
+	}
+
+	return r.MaxAttempts
+}
+
+// rejoinTracker tracks the channels which are being rejoined.
+type rejoinTracker struct {
+	mu       sync.Mutex
+	channels map[string]*rejoinChannel
+}
+
+// rejoinChannel is a channel which is being rejoined.
+type rejoinChannel struct {
+	name, key string
+	// attempts is the amount of JOINs sent so far.
+	attempts int
+	// timer is the timer of the next attempt, if one is scheduled.
+	timer *time.Timer
+}
+
+// scheduleRejoin schedules an attempt to rejoin channel, using key if set
+// (or the key we last joined it with), if enabled with Config.RejoinOnKick.
+func (c *Client) scheduleRejoin(channel, key string) {
+	conf := c.Config.RejoinOnKick
+	if conf == nil {
+		return
+	}
+
+	casemapping := c.casemapping()
+	id := ToLower(casemapping, channel)
+
+	c.rejoin.mu.Lock()
+	defer c.rejoin.mu.Unlock()
+
+	if c.rejoin.channels == nil {
+		c.rejoin.channels = make(map[string]*rejoinChannel)
+	}
+
+	rc := c.rejoin.channels[id]
+	if rc == nil {
+		rc = &rejoinChannel{name: channel}
+		c.rejoin.channels[id] = rc
+	}
+
+	if key == "" {
+		key = c.keys.get(casemapping, channel)
+	}
+
+	if key != "" {
+		rc.key = key
+	}
+
+	if rc.timer != nil {
+		rc.timer.Stop()
+	}
+
+	rc.timer = time.AfterFunc(conf.delay(), func() {
+		c.rejoin.mu.Lock()
+		if c.rejoin.channels[id] != rc {
+			// Cancelled in the meantime.
+			c.rejoin.mu.Unlock()
+			return
+		}
+
+		rc.timer = nil
+		rc.attempts++
+		params := []string{rc.name}
+		if rc.key != "" {
+			params = append(params, rc.key)
+		}
+		c.rejoin.mu.Unlock()
+
+		if !c.IsConnected() {
+			c.cancelRejoins()
+			return
+		}
+
+		c.Send(&Event{Command: JOIN, Params: params, Sensitive: len(params) > 1})
+	})
+}
+
+// finishRejoin stops rejoining channel, returning the attempts which were
+// made, or false if the channel wasn't being rejoined.
+func (c *Client) finishRejoin(channel string) (attempts int, ok bool) {
+	id := c.toLower(channel)
+
+	c.rejoin.mu.Lock()
+	defer c.rejoin.mu.Unlock()
+
+	rc := c.rejoin.channels[id]
+	if rc == nil {
+		return 0, false
+	}
+
+	if rc.timer != nil {
+		rc.timer.Stop()
+	}
+	delete(c.rejoin.channels, id)
+
+	return rc.attempts, true
+}
+
+// cancelRejoins stops rejoining all channels, e.g. once disconnected.
+func (c *Client) cancelRejoins() {
+	c.rejoin.mu.Lock()
+	defer c.rejoin.mu.Unlock()
+
+	for id, rc := range c.rejoin.channels {
+		if rc.timer != nil {
+			rc.timer.Stop()
+		}
+		delete(c.rejoin.channels, id)
+	}
+}
+
+// handleRejoinJoin dispatches REJOINED once we're back in a channel which
+// we were rejoining.
+func handleRejoinJoin(c *Client, e Event) {
+	if len(e.Params) < 1 || !c.isSelf(e.Source) {
+		return
+	}
+
+	attempts, ok := c.finishRejoin(e.Params[0])
+	if !ok {
+		return
+	}
+
+	c.RunHandlers(&Event{Command: REJOINED, Params: []string{e.Params[0], strconv.Itoa(attempts)}})
+}
+
+// handleRejoinError retries rejoining a channel after failing to join it,
+// dispatching REJOIN_FAILED once all attempts have been made.
+func handleRejoinError(c *Client, e Event) {
+	if len(e.Params) < 2 || c.Config.RejoinOnKick == nil {
+		return
+	}
+
+	channel := e.Params[1]
+	id := c.toLower(channel)
+
+	c.rejoin.mu.Lock()
+	rc := c.rejoin.channels[id]
+	pending := rc != nil && rc.timer == nil
+	retry := pending && rc.attempts < c.Config.RejoinOnKick.maxAttempts()
+	c.rejoin.mu.Unlock()
+
+	if !pending {
+		// Not being rejoined, or the attempt hasn't been made yet.
+		return
+	}
+
+	if retry {
+		c.scheduleRejoin(channel, "")
+		return
+	}
+
+	if _, ok := c.finishRejoin(channel); ok {
+		c.RunHandlers(&Event{Command: REJOIN_FAILED, Params: []string{channel}, Trailing: e.Trailing})
+	}
+}
+
+// handleRejoinDisconnect stops rejoining channels once disconnected.
+func handleRejoinDisconnect(c *Client, e Event) {
+	c.cancelRejoins()
+}
@@ -0,0 +1,88 @@
+// Copyright (c) Liam Stanley <me@liamstanley.io>. All rights reserved. Use
+// of this source code is governed by the MIT license that can be found in
+// the LICENSE file.
+
+package girc
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRejoinOnKick(t *testing.T) {
+	tests := []struct {
+		name        string
+		maxAttempts int
+		want        string
+	}{
+		{name: "rejoined", maxAttempts: 2, want: "REJOINED #channel 2"},
+		{name: "failed", maxAttempts: 1, want: "REJOIN_FAILED #channel :Cannot join channel (+b)"},
+	}
+
+	for _, tt := range tests {
+		c, server := mockClient(t, Config{
+			AllowFlood:   true,
+			RejoinOnKick: &Rejoin{Delay: 10 * time.Millisecond, MaxAttempts: tt.maxAttempts},
+		})
+
+		events := make(chan Event, 1)
+		for _, cmd := range []string{REJOINED, REJOIN_FAILED} {
+			c.Handlers.Add(cmd, func(c *Client, e Event) { events <- e })
+		}
+
+		server.send(":irc.example.com 001 nick :Welcome")
+		server.send(":nick!user@host JOIN #channel")
+		server.send(":irc.example.com 324 nick #channel +k secret")
+		server.send(":op!user@host KICK #channel nick :Bye")
+		server.expect("JOIN #channel secret")
+
+		server.send(":irc.example.com 474 nick #channel :Cannot join channel (+b)")
+		if tt.maxAttempts > 1 {
+			server.expect("JOIN #channel secret")
+			server.send(":nick!user@host JOIN #channel")
+		}
+
+		select {
+		case e := <-events:
+			if e.String() != tt.want {
+				t.Errorf("%s: got %q, want %q", tt.name, e.String(), tt.want)
+			}
+		case <-time.After(2 * time.Second):
+			t.Errorf("%s: never received %q", tt.name, tt.want)
+		}
+
+		c.Stop()
+	}
+}
+
+func TestRejoinCasemapping(t *testing.T) {
+	c, server := mockClient(t, Config{
+		AllowFlood:   true,
+		RejoinOnKick: &Rejoin{Delay: 10 * time.Millisecond, MaxAttempts: 1},
+	})
+	defer c.Stop()
+
+	events := make(chan Event, 2)
+	for _, cmd := range []string{REJOINED, REJOIN_FAILED} {
+		c.Handlers.Add(cmd, func(c *Client, e Event) { events <- e })
+	}
+
+	server.send(":irc.example.com 001 nick :Welcome")
+	server.send(":irc.example.com 005 nick CASEMAPPING=ascii :are supported by this server")
+	server.send(":nick!user@host JOIN #a[b]")
+	server.send(":op!user@host KICK #a[b] nick :Bye")
+	server.expect("JOIN #a[b]")
+
+	// With ascii, #a{b} isn't the channel being rejoined.
+	server.send(":irc.example.com 474 nick #a{b} :Cannot join channel (+b)")
+	server.send(":nick!user@host JOIN #A[B]")
+
+	select {
+	case e := <-events:
+		if want := "REJOINED #A[B] 1"; e.String() != want {
+			t.Fatalf("got %q, want %q", e.String(), want)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("never received REJOINED")
+	}
+}
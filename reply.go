@@ -0,0 +1,78 @@
+// Copyright (c) Liam Stanley <me@liamstanley.io>. All rights reserved. Use
+// of this source code is governed by the MIT license that can be found in
+// the LICENSE file.
+
+package girc
+
+import "errors"
+
+const (
+	// replyTag is the client tag which marks a message as a reply to another
+	// message, by msgid. See https://ircv3.net/specs/client-tags/reply.
+	replyTag = "+draft/reply"
+	// reactTag is the client tag which carries a reaction to another
+	// message. See https://ircv3.net/specs/client-tags/react.
+	reactTag = "+draft/react"
+)
+
+// ErrMessageTagsUnsupported is returned when sending client tags (e.g. a
+// typing notification, or a reaction), if the server doesn't support the
+// message-tags capability.
+var ErrMessageTagsUnsupported = errors.New("server does not support message-tags")
+
+// ReplyTo returns the msgid of the message the event is a reply (or reaction)
+// to, if any.
+func (e *Event) ReplyTo() (msgid string, ok bool) {
+	if msgid, ok = e.Tag(replyTag); ok {
+		return msgid, ok
+	}
+
+	return e.Tag("+reply")
+}
+
+// Reaction returns the reaction (e.g. an emoji) the event carries, if any.
+// See ReplyTo for the message which was reacted to.
+func (e *Event) Reaction() (reaction string, ok bool) {
+	if reaction, ok = e.Tag(reactTag); ok {
+		return reaction, ok
+	}
+
+	return e.Tag("+react")
+}
+
+// Reply sends a PRIVMSG to target, as a reply to the message with the given
+// msgid (see Event.ReplyTo), which clients can use to thread conversations.
+// Long messages are split like with Message, with each message marked as a
+// reply. If the server doesn't support message-tags, the message is sent
+// as a regular message.
+func (cmd *Commands) Reply(target, msgid, message string) (int, error) {
+	var tags Tags
+	if msgid != "" && cmd.c.HasCapability("message-tags") {
+		tags = Tags{replyTag: tagEncoder.Replace(msgid)}
+	}
+
+	return cmd.sendWrapped(PRIVMSG, target, message, tags, nil)
+}
+
+// React sends a reaction (e.g. an emoji) to the message with the given
+// msgid, which was sent to target (either a channel, or a user for private
+// messages). Reactions require the message-tags capability, otherwise
+// ErrMessageTagsUnsupported is returned.
+func (cmd *Commands) React(target, msgid, reaction string) error {
+	if !IsValidNick(target) && !IsValidChannel(target) {
+		return &ErrInvalidTarget{Target: target}
+	}
+
+	if !cmd.c.HasCapability("message-tags") {
+		return ErrMessageTagsUnsupported
+	}
+
+	tags := Tags{}
+	if err := tags.Set(reactTag, reaction); err != nil {
+		return err
+	}
+	tags[replyTag] = tagEncoder.Replace(msgid)
+
+	cmd.c.Send(&Event{Tags: tags, Command: TAGMSG, Params: []string{target}})
+	return nil
+}
@@ -0,0 +1,72 @@
+// Copyright (c) Liam Stanley <me@liamstanley.io>. All rights reserved. Use
+// of this source code is governed by the MIT license that can be found in
+// the LICENSE file.
+
+package girc
+
+import "testing"
+
+func TestEventReplyTo(t *testing.T) {
+	tests := []struct {
+		raw      string
+		msgid    string
+		reaction string
+	}{
+		{raw: ":nick!user@host PRIVMSG #channel :hello"},
+		{raw: "@+draft/reply=abc :nick!user@host PRIVMSG #channel :hello", msgid: "abc"},
+		{raw: "@+reply=abc :nick!user@host PRIVMSG #channel :hello", msgid: "abc"},
+		{raw: "@+draft/reply=abc;+draft/react=👍 :nick!user@host TAGMSG #channel", msgid: "abc", reaction: "👍"},
+	}
+
+	for _, tt := range tests {
+		e := ParseEvent(tt.raw)
+
+		if msgid, ok := e.ReplyTo(); msgid != tt.msgid || ok != (tt.msgid != "") {
+			t.Errorf("ParseEvent(%q).ReplyTo() = %q, %t", tt.raw, msgid, ok)
+		}
+
+		if reaction, ok := e.Reaction(); reaction != tt.reaction || ok != (tt.reaction != "") {
+			t.Errorf("ParseEvent(%q).Reaction() = %q, %t", tt.raw, reaction, ok)
+		}
+	}
+}
+
+func TestReplyReact(t *testing.T) {
+	c, server := mockClient(t, Config{RateBurst: 10})
+	defer c.Stop()
+
+	// Without message-tags, replies are sent as regular messages.
+	if _, err := c.Commands.Reply("#channel", "abc", "hello"); err != nil {
+		t.Fatal(err)
+	}
+	if line := server.expect("PRIVMSG"); line != "PRIVMSG #channel :hello" {
+		t.Fatalf("unexpected line: %q", line)
+	}
+
+	if err := c.Commands.React("#channel", "abc", "👍"); err != ErrMessageTagsUnsupported {
+		t.Fatalf("React() without message-tags returned %v", err)
+	}
+
+	c.state.mu.Lock()
+	c.state.enabledCap = []string{"message-tags"}
+	c.state.mu.Unlock()
+
+	if _, err := c.Commands.Reply("#channel", "abc", "hello"); err != nil {
+		t.Fatal(err)
+	}
+	if line := server.expect("@"); line != "@+draft/reply=abc PRIVMSG #channel :hello" {
+		t.Fatalf("unexpected line: %q", line)
+	}
+
+	if err := c.Commands.React("#channel", "abc", "👍"); err != nil {
+		t.Fatal(err)
+	}
+
+	e := ParseEvent(server.expect("@"))
+	if msgid, _ := e.ReplyTo(); e.Command != TAGMSG || msgid != "abc" {
+		t.Fatalf("unexpected reaction: %q", e)
+	}
+	if reaction, _ := e.Reaction(); reaction != "👍" {
+		t.Fatalf("reaction = %q, want 👍", reaction)
+	}
+}
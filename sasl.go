@@ -0,0 +1,278 @@
+// Copyright (c) Liam Stanley <me@liamstanley.io>. All rights reserved. Use
+// of this source code is governed by the MIT license that can be found in
+// the LICENSE file.
+
+package girc
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+)
+
+// saslChunkSize is the maximum length of each AUTHENTICATE payload. Longer
+// payloads must be split into multiple AUTHENTICATE commands.
+const saslChunkSize = 400
+
+// SASLMech is a representation of what a SASL mechanism should support.
+// Implement this to plug in custom mechanisms (e.g. OAUTHBEARER) without
+// needing to handle the AUTHENTICATE exchange itself -- the client takes
+// care of base64 encoding/decoding, and splitting/joining payloads which
+// are longer than a single AUTHENTICATE line. See SASLPlain, SASLExternal
+// and SASLScram for examples.
+type SASLMech interface {
+	// Name returns the uppercase version of the SASL mechanism name, e.g.
+	// "PLAIN". This is used to check if the server supports the mechanism,
+	// and is sent to the server to start the exchange.
+	Name() string
+	// Next is called for each decoded challenge sent by the server, and
+	// returns the (not encoded) response to send. The first call of each
+	// exchange is with the server's initial challenge (usually empty),
+	// which mechanisms with multiple steps should use to reset any state
+	// left over from a previous exchange. done should be true when the
+	// mechanism doesn't expect any further challenges. If err is non-nil,
+	// the exchange is aborted.
+	Next(challenge []byte) (response []byte, done bool, err error)
+}
+
+// SASLPlain contains the user and password needed for PLAIN SASL
+// authentication.
+type SASLPlain struct {
+	// User is the account name to authenticate as.
+	User string
+	// Pass is the password for the account.
+	Pass string
+}
+
+// Name identifies what type of SASL this implements.
+func (sasl *SASLPlain) Name() string {
+	return "PLAIN"
+}
+
+// Next encodes the plain user+password into a SASL PLAIN implementation.
+// See https://tools.ietf.org/rfc/rfc4616.txt for more info.
+func (sasl *SASLPlain) Next(challenge []byte) (response []byte, done bool, err error) {
+	if len(challenge) != 0 {
+		return nil, true, errors.New("unexpected challenge for PLAIN")
+	}
+
+	response = []byte(sasl.User)
+
+	response = append(response, 0x0)
+	response = append(response, []byte(sasl.User)...)
+	response = append(response, 0x0)
+	response = append(response, []byte(sasl.Pass)...)
+
+	return response, true, nil
+}
+
+// SASLExternal implements the "EXTERNAL" SASL mechanism, which is used to
+// authenticate using the TLS client certificate (see Config.TLSConfig) which
+// was used to connect, often referred to as CertFP.
+type SASLExternal struct {
+	// Identity is an optional authorization identity. Leave empty to use
+	// the identity associated with the client certificate.
+	Identity string
+}
+
+// Name identifies what type of SASL this implements.
+func (sasl *SASLExternal) Name() string {
+	return "EXTERNAL"
+}
+
+// Next returns the authorization identity, if any.
+func (sasl *SASLExternal) Next(challenge []byte) (response []byte, done bool, err error) {
+	return []byte(sasl.Identity), true, nil
+}
+
+// ErrSASLFailed is returned (via Config.HandleError) when SASL
+// authentication fails, and registration with the server was aborted.
+type ErrSASLFailed struct {
+	// Mechanism is the SASL mechanism which was being used.
+	Mechanism string
+	// Code is the numeric the server responded with, e.g. ERR_SASLFAIL.
+	Code string
+	// Reason is the reason the server supplied, if any.
+	Reason string
+	// Err is the error returned from the mechanism, if the mechanism is
+	// what caused the exchange to be aborted.
+	Err error
+}
+
+func (e *ErrSASLFailed) Error() string {
+	if e.Err != nil {
+		return fmt.Sprintf("sasl %s authentication failed (%s): %s", e.Mechanism, e.Code, e.Err)
+	}
+
+	return fmt.Sprintf("sasl %s authentication failed (%s): %s", e.Mechanism, e.Code, e.Reason)
+}
+
+// splitSASL splits an encoded SASL response into AUTHENTICATE sized chunks.
+// If the final chunk is exactly saslChunkSize long, a trailing "+" is added
+// to let the server know there is no more data.
+func splitSASL(encoded string) (out []string) {
+	if encoded == "" {
+		return []string{"+"}
+	}
+
+	for len(encoded) > saslChunkSize {
+		out = append(out, encoded[:saslChunkSize])
+		encoded = encoded[saslChunkSize:]
+	}
+
+	out = append(out, encoded)
+
+	if len(encoded) == saslChunkSize {
+		out = append(out, "+")
+	}
+
+	return out
+}
+
+// startSASL begins the AUTHENTICATE exchange with the server, after the
+// "sasl" capability has been acknowledged.
+func startSASL(c *Client) {
+	c.state.mu.Lock()
+	c.state.sasl = saslState{}
+	c.state.mu.Unlock()
+
+	c.write(&Event{Command: AUTHENTICATE, Params: []string{c.Config.SASL.Name()}})
+}
+
+// handleSASL handles the AUTHENTICATE exchange with the server. Challenges
+// longer than a single AUTHENTICATE line are joined before they are passed
+// to the mechanism.
+func handleSASL(c *Client, e Event) {
+	if c.Config.SASL == nil || len(e.Params) != 1 {
+		return
+	}
+
+	c.state.mu.Lock()
+	if e.Params[0] != "+" {
+		c.state.sasl.buf += e.Params[0]
+
+		if len(e.Params[0]) == saslChunkSize {
+			// More of the challenge to come.
+			c.state.mu.Unlock()
+			return
+		}
+	}
+
+	encoded := c.state.sasl.buf
+	finished := c.state.sasl.done
+	c.state.sasl.buf = ""
+	c.state.mu.Unlock()
+
+	if finished {
+		abortSASL(c, errors.New("server sent challenge after mechanism finished"))
+		return
+	}
+
+	challenge, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		abortSASL(c, fmt.Errorf("unable to decode challenge: %s", err))
+		return
+	}
+
+	response, done, err := c.Config.SASL.Next(challenge)
+	if err != nil {
+		abortSASL(c, err)
+		return
+	}
+
+	c.state.mu.Lock()
+	c.state.sasl.done = done
+	c.state.mu.Unlock()
+
+	chunks := splitSASL(base64.StdEncoding.EncodeToString(response))
+	for i := 0; i < len(chunks); i++ {
+		c.write(&Event{Command: AUTHENTICATE, Params: []string{chunks[i]}, Sensitive: true})
+	}
+}
+
+// abortSASL lets the server know we're aborting the exchange, because of
+// err. The server will respond with ERR_SASLABORTED.
+func abortSASL(c *Client, err error) {
+	c.debug.Printf("aborting sasl: %s", err)
+
+	c.state.mu.Lock()
+	c.state.sasl.err = err
+	c.state.mu.Unlock()
+
+	c.write(&Event{Command: AUTHENTICATE, Params: []string{"*"}})
+}
+
+// handleSASLResult handles the numerics which are sent at the end of a SASL
+// exchange. On success, CAP negotiation is ended so registration can
+// continue. On failure, registration is aborted.
+func handleSASLResult(c *Client, e Event) {
+	c.state.mu.RLock()
+	registered := c.state.registered
+	err := c.state.sasl.err
+	c.state.mu.RUnlock()
+
+	switch e.Command {
+	case RPL_SASLSUCCESS, ERR_SASLALREADY:
+		// If SASL was enabled after registration (see handleCAPNew), CAP
+		// negotiation has already ended.
+		if !registered {
+			endCAP(c)
+		}
+	case ERR_SASLFAIL, ERR_SASLTOOLONG, ERR_SASLABORTED:
+		var name string
+		if c.Config.SASL != nil {
+			name = c.Config.SASL.Name()
+		}
+
+		if registered {
+			// We're already connected, so there is nothing to abort.
+			c.debug.Printf("sasl authentication failed: %s", e.Trailing)
+			return
+		}
+
+		c.abort(&ErrSASLFailed{Mechanism: name, Code: e.Command, Reason: e.Trailing, Err: err})
+	}
+}
+
+// handleLOGGEDIN tracks the account we're logged in as, from RPL_LOGGEDIN
+// and RPL_LOGGEDOUT, which are sent when we log in or out (e.g. with SASL,
+// or through services), emitting AUTHENTICATED or DEAUTHENTICATED.
+func handleLOGGEDIN(c *Client, e Event) {
+	var account string
+	if e.Command == RPL_LOGGEDIN {
+		if len(e.Params) < 3 {
+			return
+		}
+
+		account = e.Params[2]
+	}
+
+	c.loggedIn(account)
+}
+
+// loggedIn updates the account we're logged in as, dispatching
+// DEAUTHENTICATED and AUTHENTICATED if it changed. An empty account means
+// we've logged out.
+func (c *Client) loggedIn(account string) {
+	c.state.mu.Lock()
+	previous := c.state.account
+	c.state.account = account
+
+	users := c.state.lookupUsers("nick", c.state.nick)
+	for i := 0; i < len(users); i++ {
+		users[i].Extras.Account = account
+	}
+	c.state.mu.Unlock()
+
+	if account == previous {
+		return
+	}
+
+	if previous != "" {
+		c.RunHandlers(&Event{Command: DEAUTHENTICATED, Params: []string{previous}})
+	}
+
+	if account != "" {
+		c.RunHandlers(&Event{Command: AUTHENTICATED, Params: []string{account}})
+	}
+}
@@ -0,0 +1,196 @@
+// Copyright (c) Liam Stanley <me@liamstanley.io>. All rights reserved. Use
+// of this source code is governed by the MIT license that can be found in
+// the LICENSE file.
+
+package girc
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"hash"
+	"strconv"
+	"strings"
+)
+
+// scramGS2Header is the GS2 header used by SASLScram. Channel binding is
+// not supported.
+const scramGS2Header = "n,,"
+
+// scramNonce generates a new random client nonce.
+var scramNonce = func() string {
+	b := make([]byte, 18)
+	if _, err := rand.Read(b); err != nil {
+		panic("girc: unable to generate scram nonce: " + err.Error())
+	}
+
+	return base64.RawStdEncoding.EncodeToString(b)
+}
+
+// SASLScram implements the SCRAM-SHA-256 SASL mechanism (or SCRAM-SHA-1 if
+// SHA1 is set), as per RFC 5802 and RFC 7677. SCRAM never sends the password
+// to the server, and verifies that the server also knows the password,
+// which makes it a good choice on networks which disable PLAIN over
+// non-TLS connections.
+//
+// Note that passwords are not normalized with SASLprep, so passwords
+// containing non-ASCII characters may not authenticate correctly.
+type SASLScram struct {
+	// User is the account name to authenticate as.
+	User string
+	// Pass is the password for the account.
+	Pass string
+	// SHA1 uses SCRAM-SHA-1 rather than SCRAM-SHA-256. Only use this if the
+	// network does not support SCRAM-SHA-256.
+	SHA1 bool
+
+	// nonce is the client nonce for the current exchange.
+	nonce string
+	// clientFirstBare is the client-first-message-bare for the current
+	// exchange, which is needed to compute the auth message.
+	clientFirstBare string
+	// serverSignature is the signature we expect the server to send in the
+	// server-final-message.
+	serverSignature []byte
+}
+
+// Name identifies what type of SASL this implements.
+func (sasl *SASLScram) Name() string {
+	if sasl.SHA1 {
+		return "SCRAM-SHA-1"
+	}
+
+	return "SCRAM-SHA-256"
+}
+
+// hash returns the hash function used by the selected mechanism.
+func (sasl *SASLScram) hash() func() hash.Hash {
+	if sasl.SHA1 {
+		return sha1.New
+	}
+
+	return sha256.New
+}
+
+// Next handles each step of the SCRAM exchange. An error is returned
+// (aborting the exchange) if the server sends an invalid message, an error,
+// or if the server signature can't be verified.
+func (sasl *SASLScram) Next(challenge []byte) (response []byte, done bool, err error) {
+	// Server is ready for the client-first-message.
+	if len(challenge) == 0 {
+		sasl.nonce = scramNonce()
+		sasl.clientFirstBare = "n=" + scramEscape(sasl.User) + ",r=" + sasl.nonce
+		sasl.serverSignature = nil
+
+		return []byte(scramGS2Header + sasl.clientFirstBare), false, nil
+	}
+
+	attrs := scramAttrs(string(challenge))
+
+	if reason, ok := attrs['e']; ok {
+		return nil, true, fmt.Errorf("scram: server error: %s", reason)
+	}
+
+	// server-final-message.
+	if verifier, ok := attrs['v']; ok {
+		signature, err := base64.StdEncoding.DecodeString(verifier)
+		if err != nil || sasl.serverSignature == nil || !hmac.Equal(signature, sasl.serverSignature) {
+			return nil, true, errors.New("scram: unable to verify server signature")
+		}
+
+		return nil, true, nil
+	}
+
+	// Otherwise, it must be the server-first-message.
+	nonce, salt64, iter := attrs['r'], attrs['s'], attrs['i']
+	if sasl.nonce == "" || !strings.HasPrefix(nonce, sasl.nonce) || len(nonce) == len(sasl.nonce) {
+		return nil, true, errors.New("scram: invalid server nonce")
+	}
+
+	salt, err := base64.StdEncoding.DecodeString(salt64)
+	if err != nil {
+		return nil, true, fmt.Errorf("scram: invalid salt: %s", err)
+	}
+
+	iterations, err := strconv.Atoi(iter)
+	if err != nil || iterations < 1 {
+		return nil, true, fmt.Errorf("scram: invalid iteration count %q", iter)
+	}
+
+	h := sasl.hash()
+	final := "c=" + base64.StdEncoding.EncodeToString([]byte(scramGS2Header)) + ",r=" + nonce
+	authMessage := []byte(sasl.clientFirstBare + "," + string(challenge) + "," + final)
+
+	salted := scramHi(h, []byte(sasl.Pass), salt, iterations)
+	clientKey := scramHMAC(h, salted, []byte("Client Key"))
+	storedKey := h()
+	storedKey.Write(clientKey)
+	clientSignature := scramHMAC(h, storedKey.Sum(nil), authMessage)
+
+	proof := make([]byte, len(clientKey))
+	for i := 0; i < len(clientKey); i++ {
+		proof[i] = clientKey[i] ^ clientSignature[i]
+	}
+
+	sasl.serverSignature = scramHMAC(h, scramHMAC(h, salted, []byte("Server Key")), authMessage)
+
+	return []byte(final + ",p=" + base64.StdEncoding.EncodeToString(proof)), false, nil
+}
+
+// scramEscape escapes a username for use within a SCRAM message.
+func scramEscape(name string) string {
+	return strings.NewReplacer("=", "=3D", ",", "=2C").Replace(name)
+}
+
+// scramAttrs parses the "a=value,b=value" attributes of a SCRAM message.
+func scramAttrs(raw string) map[byte]string {
+	attrs := make(map[byte]string)
+
+	parts := strings.Split(raw, ",")
+	for i := 0; i < len(parts); i++ {
+		if len(parts[i]) < 2 || parts[i][1] != prefixTagValue {
+			continue
+		}
+
+		attrs[parts[i][0]] = parts[i][2:]
+	}
+
+	return attrs
+}
+
+// scramHMAC computes HMAC(key, data) with the given hash.
+func scramHMAC(h func() hash.Hash, key, data []byte) []byte {
+	mac := hmac.New(h, key)
+	mac.Write(data)
+
+	return mac.Sum(nil)
+}
+
+// scramHi is the Hi() function from RFC 5802, which is PBKDF2 with the
+// output length of the hash.
+func scramHi(h func() hash.Hash, pass, salt []byte, iterations int) []byte {
+	mac := hmac.New(h, pass)
+
+	mac.Write(salt)
+	mac.Write([]byte{0, 0, 0, 1})
+	u := mac.Sum(nil)
+
+	out := make([]byte, len(u))
+	copy(out, u)
+
+	for i := 1; i < iterations; i++ {
+		mac.Reset()
+		mac.Write(u)
+		u = mac.Sum(u[:0])
+
+		for j := 0; j < len(out); j++ {
+			out[j] ^= u[j]
+		}
+	}
+
+	return out
+}
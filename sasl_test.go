@@ -0,0 +1,275 @@
+// Copyright (c) Liam Stanley <me@liamstanley.io>. All rights reserved. Use
+// of this source code is governed by the MIT license that can be found in
+// the LICENSE file.
+
+package girc
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSASLPlain(t *testing.T) {
+	sasl := &SASLPlain{User: "user", Pass: "pass"}
+
+	if _, _, err := sasl.Next([]byte("invalid")); err == nil {
+		t.Fatal("SASLPlain.Next() with invalid challenge returned no error")
+	}
+
+	got, done, err := sasl.Next(nil)
+	if err != nil || !done || string(got) != "user\x00user\x00pass" {
+		t.Fatalf("SASLPlain.Next() = %q, %t, %v, want %q, true, nil", got, done, err, "user\x00user\x00pass")
+	}
+}
+
+func TestSplitSASL(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		want []string
+	}{
+		{name: "empty", in: "", want: []string{"+"}},
+		{name: "short", in: "abcd", want: []string{"abcd"}},
+		{name: "exact", in: strings.Repeat("a", 400), want: []string{strings.Repeat("a", 400), "+"}},
+		{name: "long", in: strings.Repeat("a", 500), want: []string{strings.Repeat("a", 400), strings.Repeat("a", 100)}},
+	}
+
+	for _, tt := range tests {
+		if got := splitSASL(tt.in); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: splitSASL() = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestSASLRegistration(t *testing.T) {
+	sasl := &SASLPlain{User: "user", Pass: "pass"}
+	c, server := mockClient(t, Config{SASL: sasl})
+	defer c.Stop()
+
+	server.expect("CAP LS 302")
+	server.send(":irc.example.com CAP * LS :sasl=EXTERNAL,PLAIN")
+	server.expect("CAP REQ :sasl")
+	server.send(":irc.example.com CAP * ACK :sasl")
+	server.expect("AUTHENTICATE PLAIN")
+	server.send("AUTHENTICATE +")
+	server.expect("AUTHENTICATE " + base64.StdEncoding.EncodeToString([]byte("user\x00user\x00pass")))
+	server.send(":irc.example.com 903 nick :SASL authentication successful")
+	server.expect("CAP END")
+}
+
+func TestSASLReauth(t *testing.T) {
+	c, server := mockClient(t, Config{SASL: &SASLPlain{User: "user", Pass: "pass"}})
+	defer c.Stop()
+
+	events := make(chan Event, 10)
+	for _, cmd := range []string{AUTHENTICATED, DEAUTHENTICATED} {
+		c.Handlers.Add(cmd, func(c *Client, e Event) { events <- e })
+	}
+
+	expectEvent := func(command, account string) {
+		t.Helper()
+
+		select {
+		case e := <-events:
+			if e.Command != command || len(e.Params) == 0 || e.Params[0] != account {
+				t.Fatalf("received %q, want %s %s", e.String(), command, account)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatalf("never received %s %s", command, account)
+		}
+	}
+
+	authenticate := func() {
+		t.Helper()
+
+		server.expect("AUTHENTICATE PLAIN")
+		server.send("AUTHENTICATE +")
+		server.expect("AUTHENTICATE " + base64.StdEncoding.EncodeToString([]byte("user\x00user\x00pass")))
+		server.send(":irc.example.com 900 nick nick!user@host account :You are now logged in as account")
+		server.send(":irc.example.com 903 nick :SASL authentication successful")
+	}
+
+	server.expect("CAP LS 302")
+	server.send(":irc.example.com CAP * LS :cap-notify")
+	server.expect("CAP REQ :cap-notify")
+	server.send(":irc.example.com CAP * ACK :cap-notify")
+	server.expect("CAP END")
+	server.send(":irc.example.com 001 nick :Welcome to the network")
+
+	// Services came back, so sasl is available after registration.
+	server.send(":irc.example.com CAP nick NEW :sasl=PLAIN")
+	server.expect("CAP REQ :sasl")
+	server.send(":irc.example.com CAP nick ACK :sasl")
+	authenticate()
+	expectEvent(AUTHENTICATED, "account")
+
+	if account := c.GetAccount(); account != "account" {
+		t.Fatalf("GetAccount() = %q, want %q", account, "account")
+	}
+
+	server.send(":irc.example.com 901 nick nick!user@host :You are now logged out")
+	expectEvent(DEAUTHENTICATED, "account")
+
+	// sasl is already enabled, but we're no longer logged in.
+	server.send(":irc.example.com CAP nick NEW :sasl=EXTERNAL,PLAIN")
+	authenticate()
+	expectEvent(AUTHENTICATED, "account")
+}
+
+func TestSASLFailure(t *testing.T) {
+	errs := make(chan error, 1)
+	c, server := mockClient(t, Config{
+		SASL:        &SASLPlain{User: "user", Pass: "pass"},
+		HandleError: func(err error) { errs <- err },
+	})
+	defer c.Stop()
+
+	server.expect("CAP LS 302")
+	server.send(":irc.example.com CAP * LS :sasl")
+	server.expect("CAP REQ :sasl")
+	server.send(":irc.example.com CAP * ACK :sasl")
+	server.expect("AUTHENTICATE PLAIN")
+	server.send(":irc.example.com 904 nick :SASL authentication failed")
+
+	select {
+	case err := <-errs:
+		if serr, ok := err.(*ErrSASLFailed); !ok || serr.Code != ERR_SASLFAIL {
+			t.Fatalf("HandleError() called with %#v, wanted *ErrSASLFailed", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("HandleError() not called after SASL failure")
+	}
+}
+
+// saslRecorder is a custom SASLMech which records the challenges it was
+// sent.
+type saslRecorder struct {
+	challenges [][]byte
+	err        error
+}
+
+func (sasl *saslRecorder) Name() string { return "X-RECORD" }
+
+func (sasl *saslRecorder) Next(challenge []byte) ([]byte, bool, error) {
+	sasl.challenges = append(sasl.challenges, challenge)
+	return bytes.Repeat([]byte("r"), 300), len(sasl.challenges) == 2, sasl.err
+}
+
+func TestSASLCustomMech(t *testing.T) {
+	sasl := &saslRecorder{}
+	c, server := mockClient(t, Config{SASL: sasl})
+	defer c.Stop()
+
+	server.expect("CAP LS 302")
+	server.send(":irc.example.com CAP * LS :sasl=X-RECORD")
+	server.expect("CAP REQ :sasl")
+	server.send(":irc.example.com CAP * ACK :sasl")
+	server.expect("AUTHENTICATE X-RECORD")
+	server.send("AUTHENTICATE +")
+
+	// 300 bytes encodes to exactly 400 characters, so a "+" must follow.
+	response := base64.StdEncoding.EncodeToString(bytes.Repeat([]byte("r"), 300))
+	server.expect("AUTHENTICATE " + response)
+	server.expect("AUTHENTICATE +")
+
+	// Send a challenge which is split across multiple lines.
+	challenge := bytes.Repeat([]byte("c"), 450)
+	encoded := base64.StdEncoding.EncodeToString(challenge)
+	server.send("AUTHENTICATE " + encoded[:400])
+	server.send("AUTHENTICATE " + encoded[400:])
+	server.expect("AUTHENTICATE " + response)
+	server.send(":irc.example.com 903 nick :SASL authentication successful")
+	server.expect("CAP END")
+
+	if len(sasl.challenges) != 2 || len(sasl.challenges[0]) != 0 || !bytes.Equal(sasl.challenges[1], challenge) {
+		t.Fatalf("SASLMech.Next() called with unexpected challenges: %q", sasl.challenges)
+	}
+}
+
+func TestSASLMechError(t *testing.T) {
+	errs := make(chan error, 1)
+	c, server := mockClient(t, Config{
+		SASL:        &saslRecorder{err: errors.New("no token")},
+		HandleError: func(err error) { errs <- err },
+	})
+	defer c.Stop()
+
+	server.expect("CAP LS 302")
+	server.send(":irc.example.com CAP * LS :sasl")
+	server.expect("CAP REQ :sasl")
+	server.send(":irc.example.com CAP * ACK :sasl")
+	server.expect("AUTHENTICATE X-RECORD")
+	server.send("AUTHENTICATE +")
+	server.expect("AUTHENTICATE *")
+	server.send(":irc.example.com 906 nick :SASL authentication aborted")
+
+	select {
+	case err := <-errs:
+		if serr, ok := err.(*ErrSASLFailed); !ok || serr.Code != ERR_SASLABORTED || serr.Err == nil {
+			t.Fatalf("HandleError() called with %#v, wanted *ErrSASLFailed with mechanism error", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("HandleError() not called after mechanism error")
+	}
+}
+
+func TestSASLScram(t *testing.T) {
+	tests := []struct {
+		name        string
+		sasl        *SASLScram
+		nonce       string
+		serverFirst string
+		clientFinal string
+		serverFinal string
+	}{
+		{
+			// RFC 5802, section 5.
+			name:        "sha1",
+			sasl:        &SASLScram{User: "user", Pass: "pencil", SHA1: true},
+			nonce:       "fyko+d2lbbFgONRv9qkxdawL",
+			serverFirst: "r=fyko+d2lbbFgONRv9qkxdawL3rfcNHYJY1ZVvWVs7j,s=QSXCR+Q6sek8bf92,i=4096",
+			clientFinal: "c=biws,r=fyko+d2lbbFgONRv9qkxdawL3rfcNHYJY1ZVvWVs7j,p=v0X8v3Bz2T0CJGbJQyF0X+HI4Ts=",
+			serverFinal: "v=rmF9pqV8S7suAoZWja4dJRkFsKQ=",
+		},
+		{
+			// RFC 7677, section 3.
+			name:        "sha256",
+			sasl:        &SASLScram{User: "user", Pass: "pencil"},
+			nonce:       "rOprNGfwEbeRWgbNEkqO",
+			serverFirst: "r=rOprNGfwEbeRWgbNEkqO%hvYDpWUa2RaTCAfuxFIlj)hNlF$k0,s=W22ZaJ0SNY7soEsUEjb6gQ==,i=4096",
+			clientFinal: "c=biws,r=rOprNGfwEbeRWgbNEkqO%hvYDpWUa2RaTCAfuxFIlj)hNlF$k0,p=dHzbZapWIk4jUhN+Ute9ytag9zjfMHgsqmmiz7AndVQ=",
+			serverFinal: "v=6rriTRBi23WpRR/wtup+mMhUZUn/dB5nLTJRsjl95G4=",
+		},
+	}
+
+	defer func(orig func() string) { scramNonce = orig }(scramNonce)
+
+	for _, tt := range tests {
+		scramNonce = func() string { return tt.nonce }
+
+		got, done, err := tt.sasl.Next(nil)
+		if want := "n,,n=user,r=" + tt.nonce; err != nil || done || string(got) != want {
+			t.Errorf("%s: client-first = %q, %v, want %q", tt.name, got, err, want)
+			continue
+		}
+
+		got, done, err = tt.sasl.Next([]byte(tt.serverFirst))
+		if err != nil || done || string(got) != tt.clientFinal {
+			t.Errorf("%s: client-final = %q, %v, want %q", tt.name, got, err, tt.clientFinal)
+			continue
+		}
+
+		if _, _, err = tt.sasl.Next([]byte("v=aW52YWxpZA==")); err == nil {
+			t.Errorf("%s: invalid server signature accepted", tt.name)
+		}
+
+		if got, done, err = tt.sasl.Next([]byte(tt.serverFinal)); err != nil || !done || len(got) != 0 {
+			t.Errorf("%s: valid server signature rejected: %q, %t, %v", tt.name, got, done, err)
+		}
+	}
+}
@@ -0,0 +1,317 @@
+// Copyright (c) Liam Stanley <me@liamstanley.io>. All rights reserved. Use
+// of this source code is governed by the MIT license that can be found in
+// the LICENSE file.
+
+package girc
+
+import (
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+// Job is a function scheduled with Client.Schedule or Client.ScheduleCron.
+type Job struct {
+	id     string
+	cancel context.CancelFunc
+}
+
+// Stop stops the job. It doesn't wait for a run which is in progress.
+func (j *Job) Stop() {
+	j.cancel()
+}
+
+// scheduler tracks the jobs of the current connection.
+type scheduler struct {
+	mu   sync.Mutex
+	next int
+	jobs map[string]*Job
+}
+
+// add starts tracking a new job, with the given cancel function.
+func (s *scheduler) add(cancel context.CancelFunc) *Job {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.jobs == nil {
+		s.jobs = make(map[string]*Job)
+	}
+
+	s.next++
+	job := &Job{id: "schedule-" + strconv.Itoa(s.next)}
+	job.cancel = func() {
+		cancel()
+
+		s.mu.Lock()
+		delete(s.jobs, job.id)
+		s.mu.Unlock()
+	}
+	s.jobs[job.id] = job
+
+	return job
+}
+
+// stopAll stops all jobs, e.g. once disconnected.
+func (s *scheduler) stopAll() {
+	s.mu.Lock()
+	jobs := s.jobs
+	s.jobs = nil
+	s.mu.Unlock()
+
+	for _, job := range jobs {
+		job.cancel()
+	}
+}
+
+// Schedule calls fn every interval while we're connected, until the
+// returned job is stopped, or we disconnect (or quit). Jobs don't survive
+// reconnects, so schedule them from a CONNECTED handler. As with handlers,
+// panics are passed to Config.RecoverFunc, if set. Returns
+// ErrNotConnected if we're not connected.
+func (c *Client) Schedule(every time.Duration, fn func(c *Client)) (*Job, error) {
+	if every <= 0 {
+		return nil, ErrInvalidInterval
+	}
+
+	return c.schedule(fn, func(now time.Time) time.Time { return now.Add(every) })
+}
+
+// ScheduleCron is much like Schedule, however fn is called at the times
+// matching a cron expression (see ParseCron), in local time.
+func (c *Client) ScheduleCron(expr string, fn func(c *Client)) (*Job, error) {
+	cron, err := ParseCron(expr)
+	if err != nil {
+		return nil, err
+	}
+
+	return c.schedule(fn, cron.Next)
+}
+
+// schedule calls fn at the times returned by next, which is passed the
+// current time.
+func (c *Client) schedule(fn func(c *Client), next func(now time.Time) time.Time) (*Job, error) {
+	if !c.IsConnected() {
+		return nil, ErrNotConnected
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	job := c.jobs.add(cancel)
+
+	go func() {
+		for {
+			now := time.Now()
+			at := next(now)
+			if at.IsZero() {
+				job.Stop()
+				return
+			}
+
+			timer := time.NewTimer(at.Sub(now))
+
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				return
+			case <-timer.C:
+				if c.IsConnected() {
+					c.runJob(job, fn)
+				}
+			}
+		}
+	}()
+
+	return job, nil
+}
+
+// runJob calls fn, passing panics to Config.RecoverFunc if set.
+func (c *Client) runJob(job *Job, fn func(c *Client)) {
+	if c.Config.RecoverFunc != nil {
+		defer recoverHandlerPanic(c, &Event{}, job.id, 3)
+	}
+
+	fn(c)
+}
+
+// ErrInvalidCron is returned by ParseCron when a cron expression is invalid.
+type ErrInvalidCron struct {
+	// Expr is the cron expression.
+	Expr string
+	// Reason is why the expression is invalid.
+	Reason string
+}
+
+func (e *ErrInvalidCron) Error() string {
+	return "invalid cron expression " + strconv.Quote(e.Expr) + ": " + e.Reason
+}
+
+// cronMacros are the supported shorthands for common cron expressions.
+var cronMacros = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+// cronFields are the bounds of the fields of a cron expression.
+var cronFields = []struct {
+	name     string
+	min, max int
+}{
+	{"minute", 0, 59},
+	{"hour", 0, 23},
+	{"day of month", 1, 31},
+	{"month", 1, 12},
+	{"day of week", 0, 7},
+}
+
+// CronSchedule is a parsed cron expression. See ParseCron.
+type CronSchedule struct {
+	minute, hour, dom, month, dow uint64
+	// domAny and dowAny are true if the day of month or day of week is "*".
+	domAny, dowAny bool
+}
+
+// ParseCron parses a standard five field cron expression ("minute hour
+// day-of-month month day-of-week"), e.g. "*/15 9-17 * * 1-5". Fields may be
+// "*", a number, a range ("1-5"), a list ("1,3,5"), and may have a step
+// ("*/15" or "0-30/10"). Sunday is both 0 and 7. The shorthands @hourly,
+// @daily (or @midnight), @weekly, @monthly and @yearly (or @annually) are
+// also supported. As with cron, if both the day of month and day of week
+// are restricted, either may match.
+func ParseCron(expr string) (*CronSchedule, error) {
+	spec := strings.TrimSpace(expr)
+	if macro, ok := cronMacros[strings.ToLower(spec)]; ok {
+		spec = macro
+	}
+
+	fields := strings.Fields(spec)
+	if len(fields) != len(cronFields) {
+		return nil, &ErrInvalidCron{Expr: expr, Reason: "expected 5 fields"}
+	}
+
+	var sets [5]uint64
+	for i, field := range fields {
+		set, err := parseCronField(field, cronFields[i].min, cronFields[i].max)
+		if err != "" {
+			return nil, &ErrInvalidCron{Expr: expr, Reason: cronFields[i].name + ": " + err}
+		}
+		sets[i] = set
+	}
+
+	// Sunday is both 0 and 7.
+	if sets[4]&(1<<7) != 0 {
+		sets[4] |= 1
+	}
+
+	return &CronSchedule{
+		minute: sets[0], hour: sets[1], dom: sets[2], month: sets[3], dow: sets[4],
+		domAny: fields[2] == "*", dowAny: fields[4] == "*",
+	}, nil
+}
+
+// parseCronField parses a field of a cron expression into a bit set of the
+// values it matches, returning the reason if it's invalid.
+func parseCronField(field string, min, max int) (set uint64, err string) {
+	for _, part := range strings.Split(field, ",") {
+		step := 1
+		if i := strings.IndexByte(part, 0x2F); i > -1 { // /
+			n, perr := strconv.Atoi(part[i+1:])
+			if perr != nil || n < 1 {
+				return 0, "invalid step " + strconv.Quote(part[i+1:])
+			}
+			step, part = n, part[:i]
+		}
+
+		lo, hi := min, max
+		switch i := strings.IndexByte(part, 0x2D); { // -
+		case part == "*":
+		case i > -1:
+			var lerr, herr error
+			lo, lerr = strconv.Atoi(part[:i])
+			hi, herr = strconv.Atoi(part[i+1:])
+			if lerr != nil || herr != nil {
+				return 0, "invalid range " + strconv.Quote(part)
+			}
+		default:
+			n, perr := strconv.Atoi(part)
+			if perr != nil {
+				return 0, "invalid value " + strconv.Quote(part)
+			}
+			lo, hi = n, n
+			if step > 1 {
+				// "5/15" means starting at 5.
+				hi = max
+			}
+		}
+
+		if lo < min || hi > max || lo > hi {
+			return 0, "value out of range " + strconv.Quote(part)
+		}
+
+		for n := lo; n <= hi; n += step {
+			set |= 1 << uint(n)
+		}
+	}
+
+	return set, ""
+}
+
+// cronMaxYears is how far ahead CronSchedule.Next searches, so expressions
+// which never match (e.g. "0 0 31 2 *") don't search forever.
+const cronMaxYears = 5
+
+// Next returns the first time after t which matches the schedule, or the
+// zero time if there's none within the next few years.
+func (s *CronSchedule) Next(t time.Time) time.Time {
+	t = t.Truncate(time.Minute).Add(time.Minute)
+	limit := t.AddDate(cronMaxYears, 0, 0)
+
+	for t.Before(limit) {
+		if s.month&(1<<uint(t.Month())) == 0 {
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, t.Location())
+			continue
+		}
+
+		if !s.matchDay(t) {
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location())
+			continue
+		}
+
+		if s.hour&(1<<uint(t.Hour())) == 0 {
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, t.Location())
+			continue
+		}
+
+		if s.minute&(1<<uint(t.Minute())) == 0 {
+			t = t.Add(time.Minute)
+			continue
+		}
+
+		return t
+	}
+
+	return time.Time{}
+}
+
+// matchDay returns true if the day of t matches the schedule.
+func (s *CronSchedule) matchDay(t time.Time) bool {
+	dom := s.dom&(1<<uint(t.Day())) != 0
+	dow := s.dow&(1<<uint(t.Weekday())) != 0
+
+	switch {
+	case s.domAny && s.dowAny:
+		return true
+	case s.domAny:
+		return dow
+	case s.dowAny:
+		return dom
+	default:
+		return dom || dow
+	}
+}
@@ -0,0 +1,102 @@
+// Copyright (c) Liam Stanley <me@liamstanley.io>. All rights reserved. Use
+// of this source code is governed by the MIT license that can be found in
+// the LICENSE file.
+
+package girc
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseCron(t *testing.T) {
+	// A Wednesday.
+	now := time.Date(2024, time.January, 10, 12, 34, 56, 0, time.UTC)
+
+	tests := []struct {
+		expr string
+		want time.Time
+	}{
+		{expr: "* * * * *", want: time.Date(2024, time.January, 10, 12, 35, 0, 0, time.UTC)},
+		{expr: "*/15 * * * *", want: time.Date(2024, time.January, 10, 12, 45, 0, 0, time.UTC)},
+		{expr: "5/15 * * * *", want: time.Date(2024, time.January, 10, 12, 35, 0, 0, time.UTC)},
+		{expr: "0 9-17 * * 1-5", want: time.Date(2024, time.January, 10, 13, 0, 0, 0, time.UTC)},
+		{expr: "30 8 * * 6,7", want: time.Date(2024, time.January, 13, 8, 30, 0, 0, time.UTC)},
+		{expr: "0 0 1 */3 *", want: time.Date(2024, time.April, 1, 0, 0, 0, 0, time.UTC)},
+		{expr: "0 0 29 2 *", want: time.Date(2024, time.February, 29, 0, 0, 0, 0, time.UTC)},
+		// Either the day of month or week.
+		{expr: "0 0 15 * 4", want: time.Date(2024, time.January, 11, 0, 0, 0, 0, time.UTC)},
+		{expr: "@daily", want: time.Date(2024, time.January, 11, 0, 0, 0, 0, time.UTC)},
+		{expr: "@hourly", want: time.Date(2024, time.January, 10, 13, 0, 0, 0, time.UTC)},
+		{expr: "0 0 31 2 *", want: time.Time{}},
+	}
+
+	for _, tt := range tests {
+		cron, err := ParseCron(tt.expr)
+		if err != nil {
+			t.Errorf("ParseCron(%q) returned error: %s", tt.expr, err)
+			continue
+		}
+
+		if got := cron.Next(now); !got.Equal(tt.want) {
+			t.Errorf("ParseCron(%q).Next() = %s, want %s", tt.expr, got, tt.want)
+		}
+	}
+
+	for _, expr := range []string{"", "* * * *", "60 * * * *", "* * 0 * *", "*/0 * * * *", "5-1 * * * *", "a * * * *", "@often"} {
+		if _, err := ParseCron(expr); err == nil {
+			t.Errorf("ParseCron(%q) didn't return an error", expr)
+		}
+	}
+}
+
+func TestSchedule(t *testing.T) {
+	panics := make(chan *HandlerError, 1)
+	c, server := mockClient(t, Config{RecoverFunc: func(c *Client, e *HandlerError) { panics <- e }})
+	defer c.Stop()
+
+	server.send(":irc.example.com 001 nick :Welcome")
+
+	runs := make(chan struct{}, 10)
+	job, err := c.Schedule(10*time.Millisecond, func(c *Client) { runs <- struct{}{} })
+	if err != nil {
+		t.Fatalf("Schedule() returned error: %s", err)
+	}
+
+	for i := 0; i < 2; i++ {
+		select {
+		case <-runs:
+		case <-time.After(2 * time.Second):
+			t.Fatal("scheduled function wasn't called")
+		}
+	}
+
+	job.Stop()
+
+	if _, err = c.Schedule(10*time.Millisecond, func(c *Client) { panic("oops") }); err != nil {
+		t.Fatalf("Schedule() returned error: %s", err)
+	}
+
+	select {
+	case e := <-panics:
+		if e.Panic != "oops" {
+			t.Fatalf("unexpected panic: %v", e.Panic)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("panic wasn't passed to RecoverFunc")
+	}
+
+	c.Quit()
+
+	c.jobs.mu.Lock()
+	n := len(c.jobs.jobs)
+	c.jobs.mu.Unlock()
+
+	if n != 0 {
+		t.Fatalf("%d jobs still scheduled after disconnecting", n)
+	}
+
+	if _, err = c.Schedule(time.Second, func(c *Client) {}); err != ErrNotConnected {
+		t.Fatalf("Schedule() while disconnected returned %v, want ErrNotConnected", err)
+	}
+}
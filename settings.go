@@ -0,0 +1,190 @@
+// Copyright (c) Liam Stanley <me@liamstanley.io>. All rights reserved. Use
+// of this source code is governed by the MIT license that can be found in
+// the LICENSE file.
+
+package girc
+
+import (
+	"io"
+	"io/ioutil"
+	"log"
+	"time"
+)
+
+// configNick returns Config.Nick, which may be changed at runtime.
+func (c *Client) configNick() string {
+	c.confmu.RLock()
+	defer c.confmu.RUnlock()
+
+	return c.Config.Nick
+}
+
+// configName returns Config.Name, which may be changed at runtime.
+func (c *Client) configName() string {
+	c.confmu.RLock()
+	defer c.confmu.RUnlock()
+
+	return c.Config.Name
+}
+
+// configServer returns Config.Server and Config.Port, which may be changed
+// at runtime by a redirect (see Config.Redirects).
+func (c *Client) configServer() (server string, port int) {
+	c.confmu.RLock()
+	defer c.confmu.RUnlock()
+
+	return c.Config.Server, c.Config.Port
+}
+
+// allowFlood returns Config.AllowFlood, which may be changed at runtime.
+func (c *Client) allowFlood() bool {
+	c.confmu.RLock()
+	defer c.confmu.RUnlock()
+
+	return c.Config.AllowFlood
+}
+
+// SetNick changes Config.Nick, which is the nickname used when connecting,
+// and which is regained if it's in use (see Config.NickRegain). If
+// connected, the nickname is also changed right away.
+func (c *Client) SetNick(nick string) error {
+	if !IsValidNick(nick) {
+		return &ErrInvalidTarget{Target: nick}
+	}
+
+	c.confmu.Lock()
+	c.Config.Nick = nick
+	c.confmu.Unlock()
+
+	if c.IsConnected() {
+		return c.Commands.Nick(nick)
+	}
+
+	return nil
+}
+
+// SetName changes Config.Name, our "realname". If connected, and the server
+// supports the setname capability, it's also changed right away (see
+// Commands.SetName), otherwise once reconnected. applied is true if it was
+// changed right away.
+func (c *Client) SetName(name string) (applied bool) {
+	c.confmu.Lock()
+	c.Config.Name = name
+	c.confmu.Unlock()
+
+	if !c.IsConnected() {
+		return false
+	}
+
+	return c.Commands.SetName(name) == nil
+}
+
+// SetAutoJoin changes Config.AutoJoin, the channels which are joined once
+// connected. If connected, the channels we aren't in yet are joined right
+// away. Channels which were removed aren't parted.
+func (c *Client) SetAutoJoin(channels ...string) error {
+	for i := 0; i < len(channels); i++ {
+		if !IsValidChannel(channels[i]) {
+			return &ErrInvalidTarget{Target: channels[i]}
+		}
+	}
+
+	c.confmu.Lock()
+	c.Config.AutoJoin = append([]string(nil), channels...)
+	c.confmu.Unlock()
+
+	c.state.mu.RLock()
+	registered := c.state.registered
+	c.state.mu.RUnlock()
+
+	if registered && c.IsConnected() {
+		c.autoJoin()
+	}
+
+	return nil
+}
+
+// SetAllowFlood changes Config.AllowFlood, which disables the rate limit of
+// outbound messages. It applies right away.
+func (c *Client) SetAllowFlood(allow bool) {
+	c.confmu.Lock()
+	c.Config.AllowFlood = allow
+	c.confmu.Unlock()
+}
+
+// SetRateLimit changes Config.RateBurst and Config.RateInterval, the rate
+// limit of outbound messages. It applies right away, including to the rate
+// limit shared by the clients of a pool (see Pool), if the client is in one.
+// A burst less than 1, or an interval which isn't positive, is set to the
+// default.
+func (c *Client) SetRateLimit(burst int, interval time.Duration) {
+	if burst < 1 {
+		burst = defaultRateBurst
+	}
+
+	if interval <= 0 {
+		interval = defaultRateInterval
+	}
+
+	c.confmu.Lock()
+	c.Config.RateBurst, c.Config.RateInterval = burst, interval
+	c.confmu.Unlock()
+
+	c.cmux.Lock()
+	if c.limiter != nil {
+		c.limiter.set(burst, interval)
+	}
+
+	if c.conn != nil && c.conn.limiter != c.limiter {
+		c.conn.limiter.set(burst, interval)
+	}
+	c.cmux.Unlock()
+}
+
+// SetDebug changes Config.Debug, where the debug log is written to. If nil,
+// the debug log is discarded. It applies right away.
+func (c *Client) SetDebug(w io.Writer) {
+	c.confmu.Lock()
+	c.Config.Debug = w
+	c.confmu.Unlock()
+
+	if w == nil {
+		c.debug.SetOutput(ioutil.Discard)
+		return
+	}
+
+	c.debug.SetPrefix("debug:")
+	c.debug.SetFlags(log.Ltime | log.Lshortfile)
+	c.debug.SetOutput(w)
+}
+
+// autoJoin joins the channels of Config.AutoJoin which we aren't in yet,
+// with the key we last joined them with, if any.
+func (c *Client) autoJoin() {
+	c.confmu.RLock()
+	channels := append([]string(nil), c.Config.AutoJoin...)
+	c.confmu.RUnlock()
+
+	var join []string
+	for _, channel := range channels {
+		if !c.Config.disableTracking && c.IsInChannel(channel) {
+			continue
+		}
+
+		if key := c.keys.get(c.casemapping(), channel); key != "" {
+			c.Commands.JoinKey(channel, key)
+			continue
+		}
+
+		join = append(join, channel)
+	}
+
+	if len(join) > 0 {
+		c.Commands.Join(join...)
+	}
+}
+
+// handleAutoJoin joins the channels of Config.AutoJoin once connected.
+func handleAutoJoin(c *Client, e Event) {
+	c.autoJoin()
+}
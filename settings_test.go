@@ -0,0 +1,120 @@
+// Copyright (c) Liam Stanley <me@liamstanley.io>. All rights reserved. Use
+// of this source code is governed by the MIT license that can be found in
+// the LICENSE file.
+
+package girc
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+// syncBuffer is a bytes.Buffer which is safe for concurrent use.
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) Len() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.buf.Len()
+}
+
+func TestClientSetters(t *testing.T) {
+	c, server := mockClient(t, Config{AllowFlood: true})
+	defer c.Stop()
+
+	if err := c.SetNick("new nick"); err == nil {
+		t.Error("SetNick() accepted an invalid nickname")
+	}
+
+	if err := c.SetNick("newnick"); err != nil {
+		t.Fatalf("SetNick() failed: %s", err)
+	}
+	server.expect("NICK newnick")
+
+	if nick := c.configNick(); nick != "newnick" {
+		t.Errorf("Config.Nick = %q, want newnick", nick)
+	}
+
+	if c.SetName("without setname") {
+		t.Error("SetName() applied without the setname capability")
+	}
+
+	c.state.mu.Lock()
+	c.state.enabledCap = []string{"setname"}
+	c.state.registered = true
+	c.state.mu.Unlock()
+
+	if !c.SetName("new name") {
+		t.Error("SetName() wasn't applied with the setname capability")
+	}
+	server.expect("SETNAME :new name")
+
+	c.keys.record(CaseMappingRFC1459, &Event{Command: JOIN, Params: []string{"#secret", "key"}})
+	if err := c.SetAutoJoin("#a", "#secret", "#b"); err != nil {
+		t.Fatalf("SetAutoJoin() failed: %s", err)
+	}
+	server.expect("JOIN #secret key")
+	server.expect("JOIN #a,#b")
+
+	c.SetRateLimit(10, time.Second)
+	c.cmux.Lock()
+	limiter := c.conn.limiter
+	c.cmux.Unlock()
+
+	limiter.mu.Lock()
+	if limiter.burst != 10 || limiter.interval != time.Second {
+		t.Errorf("rate limit = %d per %s, want 10 per 1s", limiter.burst, limiter.interval)
+	}
+	limiter.mu.Unlock()
+
+	debug := &syncBuffer{}
+	c.SetDebug(debug)
+	server.send("PING :sync")
+	server.expect("PONG sync")
+
+	if debug.Len() == 0 {
+		t.Error("nothing was written to the debug log set with SetDebug()")
+	}
+}
+
+func TestClientSettersConnecting(t *testing.T) {
+	local, remote := net.Pipe()
+	defer remote.Close()
+	go io.Copy(ioutil.Discard, remote)
+
+	c := New(Config{Server: "irc.example.com", Port: 6667, Nick: "nick", User: "user", Dialer: &mockDialer{conn: local}})
+	defer c.Stop()
+
+	// The config may be changed while connecting (run with -race).
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+
+		for i := 0; i < 10; i++ {
+			c.SetRateLimit(i+1, time.Second)
+			c.SetAllowFlood(i%2 == 0)
+			c.SetDebug(nil)
+		}
+	}()
+
+	if err := c.Connect(); err != nil {
+		t.Fatalf("Connect() returned error: %s", err)
+	}
+	<-done
+}
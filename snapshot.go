@@ -0,0 +1,170 @@
+// Copyright (c) Liam Stanley <me@liamstanley.io>. All rights reserved. Use
+// of this source code is governed by the MIT license that can be found in
+// the LICENSE file.
+
+package girc
+
+import (
+	"sort"
+	"strings"
+	"time"
+)
+
+// StateSnapshot is a deep copy of the tracked state of a client, which can
+// be serialized (e.g. with encoding/json), and restored with
+// Client.ImportState. This is useful for bouncer-like applications, which
+// need to persist state across restarts.
+type StateSnapshot struct {
+	// Nick, Ident and Host are our own nickname, ident and host.
+	Nick  string `json:"nick"`
+	Ident string `json:"ident"`
+	Host  string `json:"host"`
+	// Channels are the channels we're in.
+	Channels []ChannelSnapshot `json:"channels"`
+	// Capabilities are the enabled IRCv3 capabilities.
+	Capabilities []string `json:"capabilities"`
+	// ISupport are the raw ISUPPORT tokens the server has advertised. See
+	// ISupport.Raw.
+	ISupport map[string]string `json:"isupport"`
+	// ServerName and ServerVersion are the server name and software version
+	// sent in RPL_MYINFO.
+	ServerName    string `json:"server_name"`
+	ServerVersion string `json:"server_version"`
+	// MOTD is the servers message of the day.
+	MOTD string `json:"motd"`
+}
+
+// ChannelSnapshot is the snapshot of a single channel. See StateSnapshot.
+type ChannelSnapshot struct {
+	Name      string    `json:"name"`
+	Topic     string    `json:"topic"`
+	TopicBy   string    `json:"topic_by"`
+	TopicTime time.Time `json:"topic_time"`
+	Joined    time.Time `json:"joined"`
+	// Modes are the channel modes, as returned by CModes.String. E.g.
+	// "+ntk key".
+	Modes string `json:"modes"`
+	// Lists are the list mode entries (e.g. bans), keyed by mode character.
+	Lists map[string][]ModeListEntry `json:"lists"`
+	// Users are the users within the channel.
+	Users []UserSnapshot `json:"users"`
+}
+
+// UserSnapshot is the snapshot of a single user in a channel. See
+// StateSnapshot.
+type UserSnapshot struct {
+	User
+	// Modes are the channel user modes of the user, e.g. "ov". These
+	// replace User.Perms when the snapshot is imported.
+	Modes string `json:"modes"`
+}
+
+// ExportState returns a deep copy of the tracked state, which can be
+// serialized and restored with ImportState. Panics if tracking is disabled.
+func (c *Client) ExportState() *StateSnapshot {
+	c.panicIfNotTracking()
+
+	c.state.mu.RLock()
+	defer c.state.mu.RUnlock()
+
+	s := c.state
+	snap := &StateSnapshot{
+		Nick:          s.nick,
+		Ident:         s.ident,
+		Host:          s.host,
+		Capabilities:  append([]string(nil), s.enabledCap...),
+		ISupport:      s.isupport.Copy().Raw,
+		ServerName:    s.serverName,
+		ServerVersion: s.serverVersion,
+		MOTD:          s.motd,
+	}
+
+	for _, channel := range s.channels {
+		cs := ChannelSnapshot{
+			Name:      channel.Name,
+			Topic:     channel.Topic,
+			TopicBy:   channel.TopicBy,
+			TopicTime: channel.TopicTime,
+			Joined:    channel.Joined,
+			Modes:     channel.Modes.String(),
+			Lists:     make(map[string][]ModeListEntry, len(channel.lists)),
+		}
+
+		for mode, list := range channel.lists {
+			cs.Lists[string(mode)] = append([]ModeListEntry(nil), list...)
+		}
+
+		for _, user := range channel.users {
+			cs.Users = append(cs.Users, UserSnapshot{User: *user.Copy(), Modes: user.Perms.modes})
+		}
+		sort.Slice(cs.Users, func(i, j int) bool { return cs.Users[i].Nick < cs.Users[j].Nick })
+
+		snap.Channels = append(snap.Channels, cs)
+	}
+	sort.Slice(snap.Channels, func(i, j int) bool { return snap.Channels[i].Name < snap.Channels[j].Name })
+
+	return snap
+}
+
+// ImportState replaces the tracked state with a snapshot returned by
+// ExportState. As the state is reset when connecting, this should be used
+// once connected (e.g. from a REGISTERED handler); anything the server sends
+// afterwards (e.g. JOINs when re-attaching to a bouncer) updates the
+// imported state as usual. Panics if tracking is disabled.
+func (c *Client) ImportState(snap *StateSnapshot) {
+	c.panicIfNotTracking()
+
+	c.state.mu.Lock()
+	defer c.state.mu.Unlock()
+
+	s := c.state
+	s.nick, s.ident, s.host = snap.Nick, snap.Ident, snap.Host
+	s.enabledCap = append([]string(nil), snap.Capabilities...)
+	s.serverName, s.serverVersion = snap.ServerName, snap.ServerVersion
+	s.motd = snap.MOTD
+
+	// Restore ISUPPORT first, as it affects how channels are tracked.
+	s.isupport = newISupport()
+	for name, value := range snap.ISupport {
+		name = strings.ToUpper(name)
+		s.isupport.Raw[name] = value
+		s.isupport.set(name, value)
+	}
+
+	s.channels = make(map[string]*Channel)
+	for _, cs := range snap.Channels {
+		channel := s.createChanIfNotExists(cs.Name)
+		if channel == nil {
+			continue
+		}
+
+		channel.Topic, channel.TopicBy, channel.TopicTime = cs.Topic, cs.TopicBy, cs.TopicTime
+		if !cs.Joined.IsZero() {
+			channel.Joined = cs.Joined
+		}
+
+		if fields := strings.Fields(cs.Modes); len(fields) > 0 {
+			channel.Modes.Apply(channel.Modes.Parse(fields[0], fields[1:]))
+		}
+
+		for mode, list := range cs.Lists {
+			if len(mode) == 1 {
+				channel.lists[mode[0]] = append([]ModeListEntry(nil), list...)
+			}
+		}
+
+		for _, us := range cs.Users {
+			user := s.createUserIfNotExists(channel.Name, us.Nick)
+			if user == nil {
+				continue
+			}
+
+			*user = us.User
+			user.track()
+			user.Perms = UserPerms{}
+			for i := 0; i < len(us.Modes); i++ {
+				user.Perms.setMode(us.Modes[i], true)
+			}
+		}
+	}
+}
@@ -0,0 +1,67 @@
+// Copyright (c) Liam Stanley <me@liamstanley.io>. All rights reserved. Use
+// of this source code is governed by the MIT license that can be found in
+// the LICENSE file.
+
+package girc
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+)
+
+func TestStateSnapshot(t *testing.T) {
+	c := New(Config{Server: "irc.example.com", Nick: "nick", User: "user"})
+
+	for _, line := range []string{
+		":irc.example.com 005 nick NETWORK=Example PREFIX=(qov)~@+ CHANMODES=beI,k,l,imnpst :are supported by this server",
+		":irc.example.com 353 nick = #one :~nick @+other",
+		":irc.example.com 353 nick = #two :nick other",
+		":irc.example.com 324 nick #one +ntk secret",
+		":irc.example.com 332 nick #one :the topic",
+		":irc.example.com 367 nick #one *!*@spam op 1500000000",
+		":irc.example.com 368 nick #one :End of Channel Ban List",
+	} {
+		e := ParseEvent(line)
+		c.RunHandlers(e)
+	}
+
+	raw, err := json.Marshal(c.ExportState())
+	if err != nil {
+		t.Fatalf("unable to marshal snapshot: %s", err)
+	}
+
+	var snap StateSnapshot
+	if err = json.Unmarshal(raw, &snap); err != nil {
+		t.Fatalf("unable to unmarshal snapshot: %s", err)
+	}
+
+	restored := New(Config{Server: "irc.example.com", Nick: "nick", User: "user"})
+	restored.ImportState(&snap)
+
+	again, err := json.Marshal(restored.ExportState())
+	if err != nil {
+		t.Fatalf("unable to marshal snapshot: %s", err)
+	}
+
+	if !bytes.Equal(raw, again) {
+		t.Fatalf("restored state differs:\n%s\n%s", raw, again)
+	}
+
+	channel := restored.Lookup("#one")
+	if channel == nil {
+		t.Fatal("channel #one not restored")
+	}
+
+	if channel.Topic != "the topic" || channel.Modes.Key() != "secret" || len(channel.Bans()) != 1 {
+		t.Errorf("channel #one restored incorrectly: %#v", channel)
+	}
+
+	if user := channel.Lookup("other"); user == nil || !user.Perms.IsOp() || !user.Perms.HasMode('v') {
+		t.Errorf("user other restored incorrectly: %#v", user)
+	}
+
+	if restored.NetworkName() != "Example" {
+		t.Errorf("NetworkName() = %q, want %q", restored.NetworkName(), "Example")
+	}
+}
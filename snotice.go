@@ -0,0 +1,424 @@
+// Copyright (c) Liam Stanley <me@liamstanley.io>. All rights reserved. Use
+// of this source code is governed by the MIT license that can be found in
+// the LICENSE file.
+
+package girc
+
+import (
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Kinds of server notices, see ServerNotice.
+const (
+	ServerNoticeConnect = "connect" // a user connected to the network
+	ServerNoticeQuit    = "quit"    // a user disconnected from the network
+	ServerNoticeOper    = "oper"    // a user became an operator
+	ServerNoticeKill    = "kill"    // a user was killed by an operator
+
+	ServerNoticeBanAdded   = "ban-added"   // an operator placed a server ban
+	ServerNoticeBanRemoved = "ban-removed" // an operator removed a server ban
+)
+
+// ServerNotice is a parsed server notice (which are sent to operators,
+// depending on their snomask), about a user or a server ban. See SNOTICE,
+// ParseServerNotice, SERVER_BAN_ADDED and SERVER_BAN_REMOVED.
+type ServerNotice struct {
+	// Kind is the kind of notice, e.g. ServerNoticeConnect.
+	Kind string
+	// User is the user the notice is about. Ident and Host may be empty, if
+	// the notice doesn't include them. Empty for ServerNoticeBanAdded and
+	// ServerNoticeBanRemoved.
+	User Source
+	// IP is the ip address of the user, if included in the notice.
+	IP string
+	// By is the operator who killed the user, for ServerNoticeKill, or the
+	// name of the operator account (or type) for ServerNoticeOper, if
+	// included in the notice.
+	By string
+	// Reason is the quit or kill reason, if any.
+	Reason string
+	// Ban is the server ban, for ServerNoticeBanAdded and
+	// ServerNoticeBanRemoved.
+	Ban ServerBan
+}
+
+// ServerNoticeParser parses the text of a server notice (without the
+// leading "*** "), e.g. "Notice -- Client exiting: nick (user@host) [Quit]
+// [192.0.2.1]". ok is false if the parser doesn't support the notice. See
+// Config.ServerNotices.
+type ServerNoticeParser func(text string) (notice ServerNotice, ok bool)
+
+// DefaultServerNotices are the built-in server notice parsers, which
+// support the connect, quit, oper, kill and server ban notices of Solanum
+// (and Charybdis), InspIRCd and UnrealIRCd.
+var DefaultServerNotices = []ServerNoticeParser{
+	parseSolanumNotice,
+	parseInspIRCdNotice,
+	parseUnrealNotice,
+}
+
+// ParseServerNotice returns the server notice of a SNOTICE event.
+func ParseServerNotice(e Event) (notice ServerNotice, ok bool) {
+	if e.Command != SNOTICE || e.Source == nil || len(e.Params) < 3 {
+		return notice, false
+	}
+
+	return ServerNotice{
+		Kind:   e.Params[0],
+		User:   *e.Source,
+		IP:     e.Params[1],
+		By:     e.Params[2],
+		Reason: e.Trailing,
+	}, true
+}
+
+// parseServerNotice parses the text of a server notice (including the
+// leading "*** ") with the first of parsers which supports it.
+func parseServerNotice(parsers []ServerNoticeParser, text string) (notice ServerNotice, ok bool) {
+	if !strings.HasPrefix(text, "*** ") {
+		return notice, false
+	}
+
+	for _, parse := range parsers {
+		if notice, ok = parse(text[4:]); !ok {
+			continue
+		}
+
+		switch notice.Kind {
+		case ServerNoticeBanAdded, ServerNoticeBanRemoved:
+			ok = notice.Ban.Mask != ""
+		default:
+			ok = notice.User.Name != ""
+		}
+
+		if ok {
+			return notice, true
+		}
+	}
+
+	return ServerNotice{}, false
+}
+
+// handleServerNotice dispatches SNOTICE, SERVER_BAN_ADDED or
+// SERVER_BAN_REMOVED for the server notices which are supported by
+// Config.ServerNotices.
+func handleServerNotice(c *Client, e Event) {
+	if e.Source == nil || !e.Source.IsServer() {
+		return
+	}
+
+	parsers := c.Config.ServerNotices
+	if parsers == nil {
+		parsers = DefaultServerNotices
+	}
+
+	notice, ok := parseServerNotice(parsers, e.Trailing)
+	if !ok {
+		return
+	}
+
+	ban := notice.Ban
+	switch notice.Kind {
+	case ServerNoticeBanAdded:
+		c.RunHandlers(&Event{
+			Command:  SERVER_BAN_ADDED,
+			Params:   []string{ban.Type, ban.Mask, ban.SetBy, strconv.Itoa(int(ban.Duration / time.Second))},
+			Trailing: ban.Reason,
+		})
+	case ServerNoticeBanRemoved:
+		c.RunHandlers(&Event{Command: SERVER_BAN_REMOVED, Params: []string{ban.Type, ban.Mask, ban.SetBy}, Trailing: ban.Reason})
+	default:
+		user := notice.User
+		c.RunHandlers(&Event{
+			Command:  SNOTICE,
+			Source:   &user,
+			Params:   []string{notice.Kind, notice.IP, notice.By},
+			Trailing: notice.Reason,
+		})
+	}
+}
+
+// banNotice returns the server notice of a server ban, as returned by the
+// server ban parsers (e.g. parseTS6BanNotice).
+func banNotice(ban ServerBan, added, ok bool) (notice ServerNotice, _ bool) {
+	if !ok {
+		return notice, false
+	}
+
+	if ban.Type, ok = serverBanType(ban.Type); !ok || ban.Mask == "" {
+		return notice, false
+	}
+
+	notice.Kind, notice.Ban = ServerNoticeBanRemoved, ban
+	if added {
+		notice.Kind = ServerNoticeBanAdded
+	}
+
+	return notice, true
+}
+
+// cutNotice returns the text before and after the first sep in text.
+func cutNotice(text, sep string) (before, after string, ok bool) {
+	if i := strings.Index(text, sep); i >= 0 {
+		return text[:i], text[i+len(sep):], true
+	}
+
+	return text, "", false
+}
+
+// parseNoticeUser parses a "nick (user@host) " prefix, returning the
+// remainder.
+func parseNoticeUser(text string) (user Source, rest string, ok bool) {
+	nick, rest, ok := cutNotice(text, " (")
+	if !ok || nick == "" || strings.IndexByte(nick, 0x20) >= 0 { // space
+		return user, text, false
+	}
+
+	mask, rest, ok := cutNotice(rest, ")")
+	if !ok {
+		return user, text, false
+	}
+
+	user = Source{Name: nick}
+	user.Ident, user.Host, ok = cutNotice(mask, "@")
+	if !ok {
+		return user, text, false
+	}
+
+	return user, strings.TrimPrefix(rest, " "), true
+}
+
+// parseNoticeHostmask parses a "nick!user@host" mask.
+func parseNoticeHostmask(mask string) (user Source, ok bool) {
+	src := ParseSource(mask)
+	if src == nil || !src.IsHostmask() {
+		return user, false
+	}
+
+	return *src, true
+}
+
+// noticeField returns the text enclosed in open and close at the start of
+// text (e.g. "[192.0.2.1]"), returning the remainder.
+func noticeField(text string, open, close byte) (field, rest string, ok bool) {
+	if len(text) < 2 || text[0] != open {
+		return "", text, false
+	}
+
+	i := strings.IndexByte(text, close)
+	if i < 0 {
+		return "", text, false
+	}
+
+	return text[1:i], strings.TrimPrefix(text[i+1:], " "), true
+}
+
+// parseSolanumNotice parses the server notices of Solanum (and Charybdis),
+// e.g.:
+//
+//	Notice -- Client connecting: nick (user@host) [192.0.2.1] {class} <account> [realname]
+//	Notice -- Client exiting: nick (user@host) [reason] [192.0.2.1]
+//	Notice -- opername (nick!user@host) is now an operator
+//	Notice -- Received KILL message for nick!user@host. From oper Path: server!oper (reason)
+//
+// See parseTS6BanNotice for the server ban notices.
+func parseSolanumNotice(text string) (notice ServerNotice, ok bool) {
+	if !strings.HasPrefix(text, "Notice -- ") {
+		return notice, false
+	}
+	text = text[10:]
+
+	var rest string
+	switch {
+	case strings.HasPrefix(text, "Client connecting: "):
+		notice.Kind = ServerNoticeConnect
+		if notice.User, rest, ok = parseNoticeUser(text[19:]); !ok {
+			return notice, false
+		}
+
+		notice.IP, _, _ = noticeField(rest, 0x5B, 0x5D) // [ ]
+	case strings.HasPrefix(text, "Client exiting: "):
+		notice.Kind = ServerNoticeQuit
+		if notice.User, rest, ok = parseNoticeUser(text[16:]); !ok {
+			return notice, false
+		}
+
+		// The reason may contain brackets, so the ip is the last field.
+		if i := strings.LastIndex(rest, "] ["); i >= 0 && strings.HasPrefix(rest, "[") && strings.HasSuffix(rest, "]") {
+			notice.Reason, notice.IP = rest[1:i], rest[i+3:len(rest)-1]
+		}
+	case strings.HasPrefix(text, "Received KILL message for "):
+		notice.Kind = ServerNoticeKill
+
+		target, rest, found := cutNotice(text[26:], ". From ")
+		if !found {
+			return notice, false
+		}
+
+		if notice.User, ok = parseNoticeHostmask(target); !ok {
+			notice.User = Source{Name: target}
+		}
+
+		notice.By, rest, _ = cutNotice(rest, " ")
+		if _, reason, found := cutNotice(rest, " ("); found {
+			notice.Reason = strings.TrimSuffix(reason, ")")
+		}
+	case strings.HasSuffix(text, " is now an operator"):
+		notice.Kind = ServerNoticeOper
+
+		name, mask, found := cutNotice(strings.TrimSuffix(text, " is now an operator"), " (")
+		if !found {
+			return notice, false
+		}
+		mask = strings.TrimSuffix(mask, ")")
+
+		// Solanum includes the name of the oper block, Charybdis only the
+		// user@host of the nick.
+		if notice.User, ok = parseNoticeHostmask(mask); ok {
+			notice.By = name
+		} else if notice.User, _, ok = parseNoticeUser(name + " (" + mask + ")"); !ok {
+			return notice, false
+		}
+	default:
+		return banNotice(parseTS6BanNotice(text))
+	}
+
+	return notice, true
+}
+
+// parseInspIRCdNotice parses the server notices of InspIRCd, e.g.:
+//
+//	CONNECT: Client connecting on port 6697 (class main): nick!user@host (192.0.2.1) [realname]
+//	QUIT: Client exiting: nick!user@host (192.0.2.1) [reason]
+//	OPER: nick (user@host) is now a server operator of type NetAdmin (using oper 'name')
+//	KILL: Local kill by oper: nick!user@host (reason)
+//
+// See parseInspIRCdBanNotice for the server ban notices.
+func parseInspIRCdNotice(text string) (notice ServerNotice, ok bool) {
+	snomask, text, found := cutNotice(text, ": ")
+	if !found {
+		return notice, false
+	}
+
+	switch strings.TrimPrefix(snomask, "REMOTE") {
+	case "CONNECT", "QUIT":
+		notice.Kind = ServerNoticeConnect
+		if strings.HasSuffix(snomask, "QUIT") {
+			notice.Kind = ServerNoticeQuit
+		}
+
+		// "Client connecting on port 6697 (class main): " etc.
+		if !strings.HasPrefix(text, "Client ") {
+			return notice, false
+		}
+
+		var mask, rest string
+		if _, rest, found = cutNotice(text, ": "); !found {
+			return notice, false
+		}
+
+		mask, rest, _ = cutNotice(rest, " ")
+		if notice.User, ok = parseNoticeHostmask(mask); !ok {
+			return notice, false
+		}
+
+		notice.IP, rest, _ = noticeField(rest, 0x28, 0x29) // ( )
+		if notice.Kind == ServerNoticeQuit && strings.HasPrefix(rest, "[") && strings.HasSuffix(rest, "]") {
+			notice.Reason = rest[1 : len(rest)-1]
+		}
+	case "OPER":
+		notice.Kind = ServerNoticeOper
+
+		var rest string
+		if notice.User, rest, ok = parseNoticeUser(text); !ok || !strings.HasPrefix(rest, "is now a") {
+			return notice, false
+		}
+
+		if _, name, found := cutNotice(rest, "(using oper '"); found {
+			notice.By = strings.TrimSuffix(name, "')")
+		}
+	case "KILL":
+		notice.Kind = ServerNoticeKill
+
+		_, rest, found := cutNotice(text, "kill by ")
+		if !found {
+			return notice, false
+		}
+
+		notice.By, rest, _ = cutNotice(rest, ": ")
+
+		var mask string
+		mask, rest, _ = cutNotice(rest, " (")
+		if notice.User, ok = parseNoticeHostmask(mask); !ok {
+			notice.User = Source{Name: mask}
+		}
+		notice.Reason = strings.TrimSuffix(rest, ")")
+	case "XLINE":
+		return banNotice(parseInspIRCdBanNotice(text))
+	default:
+		return notice, false
+	}
+
+	return notice, true
+}
+
+// parseUnrealNotice parses the server notices of UnrealIRCd, e.g.:
+//
+//	Client connecting: nick (user@host) [192.0.2.1] {class} [secure]
+//	Client exiting: nick (user@host) [192.0.2.1] (reason)
+//	nick (user@host) [opername] is now an operator
+//	Received KILL message for nick (user@host) from oper: reason
+//
+// See parseUnrealBanNotice for the server ban notices.
+func parseUnrealNotice(text string) (notice ServerNotice, ok bool) {
+	// Newer versions prefix notices with their subsystem, e.g. "[connect] ".
+	if strings.HasPrefix(text, "[") {
+		if _, rest, found := cutNotice(text, "] "); found {
+			text = rest
+		}
+	}
+
+	var rest string
+	switch {
+	case strings.HasPrefix(text, "Client connecting: "):
+		notice.Kind = ServerNoticeConnect
+		if notice.User, rest, ok = parseNoticeUser(text[19:]); !ok {
+			return notice, false
+		}
+
+		notice.IP, _, _ = noticeField(rest, 0x5B, 0x5D) // [ ]
+	case strings.HasPrefix(text, "Client exiting: "):
+		notice.Kind = ServerNoticeQuit
+		if notice.User, rest, ok = parseNoticeUser(text[16:]); !ok {
+			return notice, false
+		}
+
+		notice.IP, rest, _ = noticeField(rest, 0x5B, 0x5D) // [ ]
+		if strings.HasPrefix(rest, "(") && strings.HasSuffix(rest, ")") {
+			notice.Reason = rest[1 : len(rest)-1]
+		}
+	case strings.HasPrefix(text, "Received KILL message for "):
+		notice.Kind = ServerNoticeKill
+		if notice.User, rest, ok = parseNoticeUser(text[26:]); !ok {
+			return notice, false
+		}
+
+		if !strings.HasPrefix(rest, "from ") {
+			return notice, false
+		}
+		notice.By, notice.Reason, _ = cutNotice(rest[5:], ": ")
+	case strings.HasSuffix(text, " is now an operator"):
+		notice.Kind = ServerNoticeOper
+		if notice.User, rest, ok = parseNoticeUser(text); !ok {
+			return notice, false
+		}
+
+		notice.By, _, _ = noticeField(rest, 0x5B, 0x5D) // [ ]
+	default:
+		return banNotice(parseUnrealBanNotice(text))
+	}
+
+	return notice, true
+}
@@ -0,0 +1,165 @@
+// Copyright (c) Liam Stanley <me@liamstanley.io>. All rights reserved. Use
+// of this source code is governed by the MIT license that can be found in
+// the LICENSE file.
+
+package girc
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestServerNoticeParsers(t *testing.T) {
+	user := Source{Name: "nick", Ident: "~user", Host: "host.example.com"}
+
+	tests := []struct {
+		parse ServerNoticeParser
+		text  string
+		want  ServerNotice
+		ok    bool
+	}{
+		// Solanum.
+		{
+			parse: parseSolanumNotice,
+			text:  "Notice -- Client connecting: nick (~user@host.example.com) [192.0.2.1] {users} <*> [Real Name]",
+			want:  ServerNotice{Kind: ServerNoticeConnect, User: user, IP: "192.0.2.1"},
+			ok:    true,
+		},
+		{
+			parse: parseSolanumNotice,
+			text:  "Notice -- Client exiting: nick (~user@host.example.com) [Quit: [bye] now] [192.0.2.1]",
+			want:  ServerNotice{Kind: ServerNoticeQuit, User: user, IP: "192.0.2.1", Reason: "Quit: [bye] now"},
+			ok:    true,
+		},
+		{
+			parse: parseSolanumNotice,
+			text:  "Notice -- admin (nick!~user@host.example.com) is now an operator",
+			want:  ServerNotice{Kind: ServerNoticeOper, User: user, By: "admin"},
+			ok:    true,
+		},
+		{
+			parse: parseSolanumNotice,
+			text:  "Notice -- nick (~user@host.example.com) is now an operator",
+			want:  ServerNotice{Kind: ServerNoticeOper, User: user},
+			ok:    true,
+		},
+		{
+			parse: parseSolanumNotice,
+			text:  "Notice -- Received KILL message for nick!~user@host.example.com. From oper Path: irc.example.com!oper (spamming (again))",
+			want:  ServerNotice{Kind: ServerNoticeKill, User: user, By: "oper", Reason: "spamming (again)"},
+			ok:    true,
+		},
+		{parse: parseSolanumNotice, text: "Notice -- Nick change: From old to new [user@host]"},
+		{parse: parseSolanumNotice, text: "Client connecting: nick (~user@host.example.com) [192.0.2.1]"},
+
+		// InspIRCd.
+		{
+			parse: parseInspIRCdNotice,
+			text:  "CONNECT: Client connecting on port 6697 (class main): nick!~user@host.example.com (192.0.2.1) [Real Name]",
+			want:  ServerNotice{Kind: ServerNoticeConnect, User: user, IP: "192.0.2.1"},
+			ok:    true,
+		},
+		{
+			parse: parseInspIRCdNotice,
+			text:  "REMOTEQUIT: Client exiting on server irc2.example.com: nick!~user@host.example.com (192.0.2.1) [Quit: bye]",
+			want:  ServerNotice{Kind: ServerNoticeQuit, User: user, IP: "192.0.2.1", Reason: "Quit: bye"},
+			ok:    true,
+		},
+		{
+			parse: parseInspIRCdNotice,
+			text:  "OPER: nick (~user@host.example.com) is now a server operator of type NetAdmin (using oper 'admin')",
+			want:  ServerNotice{Kind: ServerNoticeOper, User: user, By: "admin"},
+			ok:    true,
+		},
+		{
+			parse: parseInspIRCdNotice,
+			text:  "KILL: Local kill by oper: nick!~user@host.example.com (spamming)",
+			want:  ServerNotice{Kind: ServerNoticeKill, User: user, By: "oper", Reason: "spamming"},
+			ok:    true,
+		},
+		{
+			parse: parseInspIRCdNotice,
+			text:  "XLINE: oper added permanent K-line for *@192.0.2.1: abuse",
+			want:  ServerNotice{Kind: ServerNoticeBanAdded, Ban: ServerBan{Type: "K", Mask: "*@192.0.2.1", SetBy: "oper", Reason: "abuse"}},
+			ok:    true,
+		},
+		{parse: parseInspIRCdNotice, text: "XLINE: oper added permanent Shun for *@192.0.2.1: abuse"},
+
+		// UnrealIRCd.
+		{
+			parse: parseUnrealNotice,
+			text:  "Client connecting: nick (~user@host.example.com) [192.0.2.1] {clients} [secure TLSv1.3]",
+			want:  ServerNotice{Kind: ServerNoticeConnect, User: user, IP: "192.0.2.1"},
+			ok:    true,
+		},
+		{
+			parse: parseUnrealNotice,
+			text:  "[connect] Client exiting: nick (~user@host.example.com) [192.0.2.1] (Quit: bye)",
+			want:  ServerNotice{Kind: ServerNoticeQuit, User: user, IP: "192.0.2.1", Reason: "Quit: bye"},
+			ok:    true,
+		},
+		{
+			parse: parseUnrealNotice,
+			text:  "nick (~user@host.example.com) [admin] is now an operator",
+			want:  ServerNotice{Kind: ServerNoticeOper, User: user, By: "admin"},
+			ok:    true,
+		},
+		{
+			parse: parseUnrealNotice,
+			text:  "Received KILL message for nick (~user@host.example.com) from oper: spamming",
+			want:  ServerNotice{Kind: ServerNoticeKill, User: user, By: "oper", Reason: "spamming"},
+			ok:    true,
+		},
+		{
+			parse: parseUnrealNotice,
+			text:  "G-Line added: '*@192.0.2.1' [reason: abuse]",
+			want:  ServerNotice{Kind: ServerNoticeBanAdded, Ban: ServerBan{Type: "G", Mask: "*@192.0.2.1", Reason: "abuse"}},
+			ok:    true,
+		},
+		{parse: parseUnrealNotice, text: "Q-Line added: '' [reason: abuse]"},
+	}
+
+	for _, tt := range tests {
+		got, ok := tt.parse(tt.text)
+		if ok != tt.ok || (ok && got != tt.want) {
+			t.Errorf("parsing %q = %#v, %t, want %#v, %t", tt.text, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestServerNoticeEvents(t *testing.T) {
+	custom := func(text string) (notice ServerNotice, ok bool) {
+		if !strings.HasPrefix(text, "Custom connect: ") {
+			return notice, false
+		}
+
+		return ServerNotice{Kind: ServerNoticeConnect, User: Source{Name: text[16:]}}, true
+	}
+
+	c, server := mockClient(t, Config{ServerNotices: append(DefaultServerNotices, custom)})
+	defer c.Stop()
+
+	notices := make(chan Event, 3)
+	c.Handlers.Add(SNOTICE, func(c *Client, e Event) { notices <- e })
+
+	server.send(":irc.example.com NOTICE nick :*** Notice -- Client exiting: bad (~bad@evil.example.com) [Killed] [192.0.2.1]")
+	server.send(":spoof!spoof@host NOTICE nick :*** Notice -- Client exiting: spoofed (~bad@evil.example.com) [Killed] [192.0.2.1]")
+	server.send(":irc.example.com NOTICE nick :*** Custom connect: other")
+
+	want := []ServerNotice{
+		{Kind: ServerNoticeQuit, User: Source{Name: "bad", Ident: "~bad", Host: "evil.example.com"}, IP: "192.0.2.1", Reason: "Killed"},
+		{Kind: ServerNoticeConnect, User: Source{Name: "other"}},
+	}
+
+	for i, w := range want {
+		select {
+		case e := <-notices:
+			if got, ok := ParseServerNotice(e); !ok || got != w {
+				t.Errorf("notice %d = %#v, %t, want %#v", i, got, ok, w)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatalf("notice %d never dispatched", i)
+		}
+	}
+}
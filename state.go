@@ -6,8 +6,8 @@ package girc
 
 import (
 	"fmt"
-	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -19,6 +19,22 @@ type state struct {
 	mu sync.RWMutex
 	// nick, ident, and host are the internal trackers for our user.
 	nick, ident, host string
+	// account is the account we're logged in as (RPL_LOGGEDIN), if any.
+	account string
+	// identifying is true while we're waiting for NickServ to respond to
+	// our identification. See Config.NickServ.
+	identifying bool
+	// authenticating is true while we're authenticating with services. See
+	// Config.ServiceAuth.
+	authenticating bool
+	// registered is true once the server has accepted our registration.
+	registered bool
+	// nickAttempts is the amount of nicknames which were rejected during
+	// registration.
+	nickAttempts int
+	// regaining is true while we're trying to regain Config.Nick, which was
+	// in use when we registered. See Config.NickRegain.
+	regaining bool
 	// channels represents all channels we're active in.
 	channels map[string]*Channel
 	// enabledCap are the capabilities which are enabled for this connection.
@@ -27,12 +43,39 @@ type state struct {
 	// last capability check. These will get sent once we have received the
 	// last capability list command from the server.
 	tmpCap []string
-	// serverOptions are the standard capabilities and configurations
-	// supported by the server at connection time. This also includes
-	// RPL_ISUPPORT entries.
-	serverOptions map[string]string
+	// capValues are the values of the capabilities advertised by the server
+	// in CAP LS, e.g. "max-bytes=4096" for draft/multiline.
+	capValues map[string][]string
+	// isupport are the RPL_ISUPPORT tokens supported by the server.
+	isupport ISupport
+	// serverName and serverVersion are the server name and software
+	// version, as sent in RPL_MYINFO.
+	serverName, serverVersion string
 	// motd is the servers message of the day.
 	motd string
+	// sasl is the state of the current SASL exchange, if any.
+	sasl saslState
+	// limits are the limits on the amount of tracked users, and evicted the
+	// amount of users which were evicted due to them.
+	limits  stateLimits
+	evicted uint64
+	// nickChanges are the most recent nickname changes, oldest first, up to
+	// maxNickChanges. See Client.ResolveNick.
+	nickChanges []NickChange
+	// invites are the invites we have received, for channels we haven't
+	// joined since, oldest first. See Client.PendingInvites.
+	invites []Invite
+}
+
+// saslState tracks an in-progress SASL exchange.
+type saslState struct {
+	// buf is the (encoded) challenge received so far, for challenges which
+	// are split across multiple AUTHENTICATE lines.
+	buf string
+	// done is true once the mechanism has sent its last response.
+	done bool
+	// err is the error the mechanism aborted with, if any.
+	err error
 }
 
 // User represents an IRC user and the state attached to them.
@@ -57,6 +100,22 @@ type User struct {
 	// Only usable if from state, not in past.
 	LastActive time.Time
 
+	// Away is true if the user is marked as away, in which case AwayMsg is
+	// the away message they set (if known). This is kept up to date with
+	// away-notify if the server supports it, and otherwise with WHO replies
+	// (see Config.WhoRefreshInterval) and RPL_AWAY.
+	Away    bool
+	AwayMsg string
+
+	// IsBot is true if the user has marked themselves as a bot (see
+	// Config.Bot). This is known from the bot tag of their messages, WHO
+	// replies, and WHOIS.
+	IsBot bool
+
+	// NickHistory are the most recent nickname changes of the user, oldest
+	// first, up to the last 10 changes. Only usable if from state.
+	NickHistory []NickChange
+
 	// Perms are the user permissions applied to this user that affect the given
 	// channel. This supports non-rfc style modes like Admin, Owner, and HalfOp.
 	// If you want to easily check if a user has permissions equal or greater
@@ -75,12 +134,31 @@ type User struct {
 		// could also be something like Undernet). May also be empty if
 		// unsupported by the server/tracking is disabled.
 		Account string
-		// Away refers to the away status of the user. An empty string
-		// indicates that they are active, otherwise the string is what they
-		// set as their away message. May also be empty if unsupported by the
-		// server/tracking is disabled.
-		Away string
+		// Twitch is the information Twitch attaches to the messages of the
+		// user in the channel, with Config.TwitchMode.
+		Twitch TwitchUser
 	}
+
+	// active is the time the user was last active, in unix nanoseconds, for
+	// users tracked in state. It's updated atomically, so messages only need
+	// a read lock on the state; LastActive of copies is set from it.
+	active *int64
+
+	// whois is the cached result of a WHOIS query, which was received at
+	// whoisAt. See Config.WhoisCacheTTL.
+	whois   *Whois
+	whoisAt time.Time
+}
+
+// Copy returns a deep copy of the user.
+func (u *User) Copy() *User {
+	nu := &User{}
+	*nu = *u
+	nu.LastActive, nu.active = u.lastActive(), nil
+	nu.NickHistory = append([]NickChange(nil), u.NickHistory...)
+	nu.Extras.Twitch = u.Extras.Twitch.Copy()
+
+	return nu
 }
 
 // Message returns an event which can be used to send a response to the user
@@ -109,6 +187,49 @@ func (u *User) MessageTof(channel, format string, a ...interface{}) *Event {
 	return u.MessageTo(channel, fmt.Sprintf(format, a...))
 }
 
+// track makes the user's activity safe to update while only holding a read
+// lock on the state, starting from LastActive. Always use state.mu for
+// transaction.
+func (u *User) track() {
+	var active int64
+	if !u.LastActive.IsZero() {
+		active = u.LastActive.UnixNano()
+	}
+
+	u.active = &active
+}
+
+// lastActive returns the time the user was last active.
+func (u *User) lastActive() time.Time {
+	if u.active == nil {
+		return u.LastActive
+	}
+
+	active := atomic.LoadInt64(u.active)
+	if active == 0 {
+		return time.Time{}
+	}
+
+	return time.Unix(0, active)
+}
+
+// setActive sets the time the user was last active. Users tracked in state
+// (see User.track) may be updated while only holding a read lock on the
+// state.
+func (u *User) setActive(t time.Time) {
+	if u.active == nil {
+		u.LastActive = t
+		return
+	}
+
+	var active int64
+	if !t.IsZero() {
+		active = t.UnixNano()
+	}
+
+	atomic.StoreInt64(u.active, active)
+}
+
 // Lifetime represents the amount of time that has passed since we have first
 // seen the user.
 func (u *User) Lifetime() time.Duration {
@@ -118,7 +239,7 @@ func (u *User) Lifetime() time.Duration {
 // Active represents the the amount of time that has passed since we have
 // last seen the user.
 func (u *User) Active() time.Duration {
-	return time.Since(u.LastActive)
+	return time.Since(u.lastActive())
 }
 
 // IsActive returns true if they were active within the last 30 minutes.
@@ -133,6 +254,12 @@ type Channel struct {
 	Name string
 	// Topic of the channel.
 	Topic string
+	// TopicBy is who set the topic, which is either a nickname or a full
+	// "nick!user@host" mask, depending on the server. May be empty if
+	// unknown.
+	TopicBy string
+	// TopicTime is when the topic was set. May be zero if unknown.
+	TopicTime time.Time
 	// users represents the users that we can currently see within the
 	// channel.
 	users map[string]*User
@@ -140,22 +267,52 @@ type Channel struct {
 	Joined time.Time
 	// Modes are the known channel modes that the bot has captured.
 	Modes CModes
+	// RoomState are the chat settings of the channel, on Twitch (see
+	// Config.TwitchMode).
+	RoomState TwitchRoomState
+
+	// casemapping is the server casemapping (see ToLower) used for the
+	// user keys.
+	casemapping string
+	// pendingSync are the replies (see syncNames and syncWho) which are
+	// still expected after joining the channel.
+	pendingSync int
+
+	// lists are the known entries of each list mode (e.g. bans), keyed by
+	// mode character.
+	lists map[byte][]ModeListEntry
+	// listBuf are list entries which are being received from the server,
+	// and will replace lists once the list has ended.
+	listBuf map[byte][]ModeListEntry
 }
 
+// Replies which are expected after joining a channel, before it's synced.
+const (
+	syncNames = 1 << iota // RPL_ENDOFNAMES.
+	syncWho               // RPL_ENDOFWHO.
+)
+
 // Copy returns a deep copy of a given channel.
 func (c *Channel) Copy() *Channel {
 	nc := &Channel{}
 	*nc = *c
 
 	// Copy the users.
-	nc.users = make(map[string]*User)
+	nc.users = make(map[string]*User, len(c.users))
 	for k, v := range c.users {
-		nc.users[k] = v
+		nc.users[k] = v.Copy()
 	}
 
 	// And modes.
 	nc.Modes = c.Modes.Copy()
 
+	// And mode lists.
+	nc.lists = make(map[byte][]ModeListEntry, len(c.lists))
+	for k, v := range c.lists {
+		nc.lists[k] = append([]ModeListEntry(nil), v...)
+	}
+	nc.listBuf = nil
+
 	return nc
 }
 
@@ -178,8 +335,8 @@ func (c *Channel) NickList() []string {
 	out := make([]string, len(c.users))
 
 	var index int
-	for k := range c.users {
-		out[index] = k
+	for _, u := range c.users {
+		out[index] = u.Nick
 
 		index++
 	}
@@ -195,15 +352,9 @@ func (c *Channel) Len() int {
 // Lookup looks up a user in a channel based on a given nickname. If the
 // user wasn't found, user is nil.
 func (c *Channel) Lookup(nick string) *User {
-	for k, v := range c.users {
-		if ToRFC1459(k) == ToRFC1459(nick) {
-			// No need to have a copy, as if one has access to a channel,
-			// should already have a full copy.
-			return v
-		}
-	}
-
-	return nil
+	// No need to have a copy, as if one has access to a channel, should
+	// already have a full copy.
+	return c.users[ToLower(c.casemapping, nick)]
 }
 
 // Message returns an event which can be used to send a response to the channel.
@@ -224,16 +375,63 @@ func (c *Channel) Lifetime() time.Duration {
 	return time.Since(c.Joined)
 }
 
-// newState returns a clean client state.
-func newState() *state {
-	s := &state{}
+// newState returns a clean client state, with the user limits from conf.
+func newState(conf Config) *state {
+	s := &state{limits: stateLimits{
+		channelUsers: conf.MaxChannelUsers,
+		users:        conf.MaxUsers,
+		ttl:          conf.UserTTL,
+	}}
 
 	s.channels = make(map[string]*Channel)
-	s.serverOptions = make(map[string]string)
+	s.capValues = make(map[string][]string)
+	s.isupport = newISupport()
 
 	return s
 }
 
+// toLower converts a nickname or channel name to lowercase, using the
+// casemapping of the server. Always use state.mu for transaction.
+func (s *state) toLower(name string) string {
+	return ToLower(s.isupport.Casemapping, name)
+}
+
+// hasCap returns true if the capability is enabled. Always use state.mu for
+// transaction.
+func (s *state) hasCap(name string) bool {
+	for i := 0; i < len(s.enabledCap); i++ {
+		if s.enabledCap[i] == name {
+			return true
+		}
+	}
+
+	return false
+}
+
+// addCap marks the capability as enabled, returning false if it already
+// was. Always use state.mu for transaction.
+func (s *state) addCap(name string) bool {
+	if s.hasCap(name) {
+		return false
+	}
+
+	s.enabledCap = append(s.enabledCap, name)
+	return true
+}
+
+// removeCap marks the capability as disabled, returning false if it wasn't
+// enabled. Always use state.mu for transaction.
+func (s *state) removeCap(name string) bool {
+	for i := 0; i < len(s.enabledCap); i++ {
+		if s.enabledCap[i] == name {
+			s.enabledCap = append(s.enabledCap[:i], s.enabledCap[i+1:]...)
+			return true
+		}
+	}
+
+	return false
+}
+
 // createChanIfNotExists creates the channel in state, if not already done.
 // Always use state.mu for transaction.
 func (s *state) createChanIfNotExists(name string) (channel *Channel) {
@@ -245,13 +443,15 @@ func (s *state) createChanIfNotExists(name string) (channel *Channel) {
 	supported := s.chanModes()
 	prefixes, _ := parsePrefixes(s.userPrefixes())
 
-	name = strings.ToLower(name)
+	name = s.toLower(name)
 	if _, ok := s.channels[name]; !ok {
 		channel = &Channel{
-			Name:   name,
-			users:  make(map[string]*User),
-			Joined: time.Now(),
-			Modes:  NewCModes(supported, prefixes),
+			Name:        name,
+			users:       make(map[string]*User),
+			Joined:      time.Now(),
+			Modes:       NewCModes(supported, prefixes),
+			lists:       make(map[byte][]ModeListEntry),
+			casemapping: s.isupport.Casemapping,
 		}
 		s.channels[name] = channel
 	} else {
@@ -281,7 +481,45 @@ func (s *state) lookupChannel(name string) *Channel {
 		return nil
 	}
 
-	return s.channels[strings.ToLower(name)]
+	return s.channels[s.toLower(name)]
+}
+
+// lookupChannelUser returns a reference to the user with the given nick in
+// the given channel, or nil if they aren't tracked in it. Always use
+// state.mu for transaction.
+func (s *state) lookupChannelUser(channelName, nick string) *User {
+	channel := s.lookupChannel(channelName)
+	if channel == nil {
+		return nil
+	}
+
+	return channel.users[s.toLower(nick)]
+}
+
+// renameChannel moves a tracked channel (including its users, modes and
+// topic) to a new name, returning true if the channel was tracked. Always
+// use state.mu for transaction.
+func (s *state) renameChannel(from, to string) bool {
+	if !IsValidChannel(to) {
+		return false
+	}
+
+	channel := s.lookupChannel(from)
+	if channel == nil {
+		return false
+	}
+
+	delete(s.channels, channel.Name)
+	channel.Name = s.toLower(to)
+	s.channels[channel.Name] = channel
+
+	for i := 0; i < len(s.invites); i++ {
+		if s.toLower(s.invites[i].Channel) == s.toLower(from) {
+			s.invites[i].Channel = to
+		}
+	}
+
+	return true
 }
 
 // createUserIfNotExists creates the channel and user in state, if not already
@@ -296,66 +534,144 @@ func (s *state) createUserIfNotExists(channelName, nick string) (user *User) {
 		return nil
 	}
 
-	if _, ok := channel.users[nick]; ok {
-		channel.users[nick].LastActive = time.Now()
-		return channel.users[nick]
+	key := s.toLower(nick)
+	if _, ok := channel.users[key]; ok {
+		channel.users[key].setActive(time.Now())
+		return channel.users[key]
 	}
 
 	user = &User{Nick: nick, FirstSeen: time.Now(), LastActive: time.Now()}
-	channel.users[nick] = user
+	user.track()
+	channel.users[key] = user
+	s.enforceLimits(channel, user)
 
 	return user
 }
 
-// deleteUser removes the user from channel state. Always use state.mu for
-// transaction.
-func (s *state) deleteUser(nick string) {
+// deleteUser removes the user from channel state, returning the channels
+// they were removed from. Always use state.mu for transaction.
+func (s *state) deleteUser(nick string) (channels []string) {
 	if !IsValidNick(nick) {
-		return
+		return nil
 	}
 
+	nick = s.toLower(nick)
 	for k := range s.channels {
-		// Check to see if they're in this channel.
 		if _, ok := s.channels[k].users[nick]; !ok {
 			continue
 		}
 
 		delete(s.channels[k].users, nick)
+		channels = append(channels, s.channels[k].Name)
 	}
+
+	return channels
 }
 
-// renameUser renames the user in state, in all locations where relevant.
-// Always use state.mu for transaction.
-func (s *state) renameUser(from, to string) {
+// deleteChannelUser removes the user from a single channel, returning the
+// removed user, or nil if they weren't in the channel. Always use state.mu
+// for transaction.
+func (s *state) deleteChannelUser(channelName, nick string) *User {
+	channel := s.lookupChannel(channelName)
+	if channel == nil {
+		return nil
+	}
+
+	key := s.toLower(nick)
+	user, ok := channel.users[key]
+	if !ok {
+		return nil
+	}
+
+	delete(channel.users, key)
+	return user
+}
+
+// renameUser renames the user in state, in all locations where relevant,
+// returning true if the user was tracked in any channel. Always use state.mu
+// for transaction.
+func (s *state) renameUser(from, to string) (renamed bool) {
 	if !IsValidNick(from) || !IsValidNick(to) {
-		return
+		return false
 	}
 
 	// Update our nickname.
-	if from == s.nick {
+	if s.toLower(from) == s.toLower(s.nick) {
 		s.nick = to
 	}
 
+	fromKey, toKey := s.toLower(from), s.toLower(to)
+	change := NickChange{Old: from, New: to, Time: time.Now()}
+
 	for k := range s.channels {
 		// Check to see if they're in this channel.
-		if _, ok := s.channels[k].users[from]; !ok {
+		if _, ok := s.channels[k].users[fromKey]; !ok {
 			continue
 		}
 
 		// Take the actual reference to the pointer.
-		source := *s.channels[k].users[from]
+		source := *s.channels[k].users[fromKey]
 
 		// Update the nick field (as we not only have a key, but a matching
 		// struct field).
 		source.Nick = to
-		source.LastActive = time.Now()
+		source.setActive(change.Time)
+		source.NickHistory = appendNickChange(source.NickHistory, change, maxUserNickHistory)
 
 		// Delete the old reference.
-		delete(s.channels[k].users, from)
+		delete(s.channels[k].users, fromKey)
 
 		// In with the new.
-		s.channels[k].users[to] = &source
+		s.channels[k].users[toKey] = &source
+		renamed = true
+	}
+
+	if renamed {
+		s.nickChanges = appendNickChange(s.nickChanges, change, maxNickChanges)
+	}
+
+	return renamed
+}
+
+// usersByNick returns the user with the given nick in each channel they're
+// tracked in. Unlike lookupUsers, this doesn't need to scan all users.
+// Always use state.mu for transaction.
+func (s *state) usersByNick(nick string) (users []*User) {
+	key := s.toLower(nick)
+	for _, channel := range s.channels {
+		if user, ok := channel.users[key]; ok {
+			users = append(users, user)
+		}
+	}
+
+	return users
+}
+
+// updateUsers applies update to each user with the given nick, if stale
+// returns true for any of them. stale is called while holding a read lock,
+// so events which don't change the state (the vast majority, e.g. a tag
+// which is the same for every message of a user) don't contend with
+// lookups.
+func (c *Client) updateUsers(nick string, stale func(user *User) bool, update func(user *User)) {
+	c.state.mu.RLock()
+	users := c.state.usersByNick(nick)
+
+	var changed bool
+	for i := 0; i < len(users) && !changed; i++ {
+		changed = stale(users[i])
+	}
+	c.state.mu.RUnlock()
+
+	if !changed {
+		return
+	}
+
+	c.state.mu.Lock()
+	users = c.state.usersByNick(nick)
+	for i := 0; i < len(users); i++ {
+		update(users[i])
 	}
+	c.state.mu.Unlock()
 }
 
 // lookupUsers returns a slice of references to users matching a given
@@ -367,17 +683,17 @@ func (s *state) lookupUsers(matchType, toMatch string) []*User {
 		for u := range s.channels[c].users {
 			switch matchType {
 			case "nick":
-				if ToRFC1459(s.channels[c].users[u].Nick) == ToRFC1459(toMatch) {
+				if s.toLower(s.channels[c].users[u].Nick) == s.toLower(toMatch) {
 					users = append(users, s.channels[c].users[u])
 					continue
 				}
 			case "ident":
-				if ToRFC1459(s.channels[c].users[u].Ident) == ToRFC1459(toMatch) {
+				if s.toLower(s.channels[c].users[u].Ident) == s.toLower(toMatch) {
 					users = append(users, s.channels[c].users[u])
 					continue
 				}
 			case "account":
-				if ToRFC1459(s.channels[c].users[u].Extras.Account) == ToRFC1459(toMatch) {
+				if s.toLower(s.channels[c].users[u].Extras.Account) == s.toLower(toMatch) {
 					users = append(users, s.channels[c].users[u])
 					continue
 				}
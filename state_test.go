@@ -0,0 +1,127 @@
+// Copyright (c) Liam Stanley <me@liamstanley.io>. All rights reserved. Use
+// of this source code is governed by the MIT license that can be found in
+// the LICENSE file.
+
+package girc
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+// busyClient returns a client tracking the given amount of channels, each
+// with the given amount of users.
+func busyClient(channels, users int) *Client {
+	c := New(Config{Server: "irc.example.com", Nick: "nick", User: "user"})
+
+	c.state.mu.Lock()
+	for i := 0; i < channels; i++ {
+		for j := 0; j < users; j++ {
+			c.state.createUserIfNotExists(fmt.Sprintf("#channel%d", i), fmt.Sprintf("user%d", j))
+		}
+	}
+	c.state.mu.Unlock()
+
+	return c
+}
+
+func TestUserActivity(t *testing.T) {
+	c := busyClient(2, 10)
+	source := &Source{Name: "USER1", Ident: "user", Host: "host"}
+
+	c.state.mu.Lock()
+	for _, user := range c.state.usersByNick("user1") {
+		user.setActive(time.Now().Add(-time.Hour))
+	}
+	c.state.mu.Unlock()
+
+	if user, _ := c.LookupUser("user1"); user.IsActive() {
+		t.Fatalf("user1 active %s ago, want inactive", user.Active())
+	}
+
+	// Messages only take a read lock to update the activity of their
+	// source, so this must be safe alongside lookups.
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				updateLastActive(c, Event{Source: source, Command: PRIVMSG, Params: []string{"#channel0"}, Trailing: "hi"})
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				c.LookupUser("user1")
+				c.Lookup("#channel1").Users()
+			}
+		}()
+	}
+	wg.Wait()
+
+	user, _ := c.LookupUser("user1")
+	if !user.IsActive() || user.Active() > time.Minute {
+		t.Fatalf("user1 active %s ago, want active", user.Active())
+	}
+
+	// Copies are snapshots, which aren't updated by later messages.
+	last := user.LastActive
+	time.Sleep(time.Millisecond)
+	updateLastActive(c, Event{Source: source, Command: PRIVMSG})
+	if !user.LastActive.Equal(last) {
+		t.Fatal("copy of user1 was updated after it was looked up")
+	}
+}
+
+func TestUpdateUsers(t *testing.T) {
+	c := busyClient(3, 5)
+
+	var updates int
+	update := func(user *User) {
+		updates++
+		user.Extras.Account = "acc"
+	}
+	stale := func(user *User) bool { return user.Extras.Account != "acc" }
+
+	c.updateUsers("USER2", stale, update)
+	if updates != 3 {
+		t.Fatalf("updateUsers() updated %d users, want 3", updates)
+	}
+
+	// Nothing is stale anymore, so the write lock isn't taken at all.
+	c.updateUsers("user2", stale, update)
+	if updates != 3 {
+		t.Fatalf("updateUsers() updated users which weren't stale")
+	}
+
+	if users := c.LookupUsersByAccount("acc"); len(users) != 1 || users[0].Nick != "user2" {
+		t.Fatalf("LookupUsersByAccount() = %v", users)
+	}
+}
+
+// BenchmarkBusyNetwork measures the throughput of messages on a busy
+// network (updating the activity of their source), alongside lookups by
+// handlers.
+func BenchmarkBusyNetwork(b *testing.B) {
+	c := busyClient(50, 200)
+	events := make([]Event, 200)
+	for i := range events {
+		events[i] = Event{Source: &Source{Name: fmt.Sprintf("user%d", i), Ident: "user", Host: "host"}, Command: PRIVMSG, Params: []string{"#channel0"}, Trailing: "hi"}
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	b.RunParallel(func(pb *testing.PB) {
+		var i int
+		for pb.Next() {
+			e := events[i%len(events)]
+			updateLastActive(c, e)
+			c.LookupUser(e.Source.Name)
+			i++
+		}
+	})
+}
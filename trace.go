@@ -0,0 +1,92 @@
+// Copyright (c) Liam Stanley <me@liamstanley.io>. All rights reserved. Use
+// of this source code is governed by the MIT license that can be found in
+// the LICENSE file.
+
+package girc
+
+import (
+	"errors"
+
+	"golang.org/x/net/context"
+)
+
+// errSendDropped is passed to the tracer for events which were queued, but
+// never sent, e.g. because we disconnected first.
+var errSendDropped = errors.New("event dropped before being sent")
+
+// Tracer traces the dispatch of incoming events, the handlers they're
+// passed to, and sending events. Implementations carry their spans in the
+// returned contexts. See Config.Tracer, and the gircotel package, which
+// traces with OpenTelemetry.
+type Tracer interface {
+	// StartDispatch is called before the handlers of event are run, with
+	// the context of event. The returned context is the context of the
+	// event while it's dispatched, and end is called once all of its
+	// handlers have returned.
+	StartDispatch(ctx context.Context, event *Event) (_ context.Context, end func())
+	// StartHandler is called before the handler with the given id is passed
+	// event, with the context returned by StartDispatch. The returned
+	// context is the context of the event passed to the handler.
+	StartHandler(ctx context.Context, event *Event, id string) (_ context.Context, end func())
+	// StartSend is called before event is queued to be sent, with the
+	// context passed to Client.SendContext (context.Background() for
+	// Client.Send). end is called once the event was written, with the
+	// error if it couldn't be (e.g. because we disconnected first).
+	StartSend(ctx context.Context, event *Event) (end func(err error))
+}
+
+// Context returns the context of the event, which carries the trace span of
+// the handler it was passed to, if tracing is enabled (see Config.Tracer).
+// Pass it to Client.SendContext so the events sent in response are traced
+// as part of handling this one. Never nil.
+func (e *Event) Context() context.Context {
+	if e.ctx == nil {
+		return context.Background()
+	}
+
+	return e.ctx
+}
+
+// traceDispatch starts tracing the dispatch of event, returning the
+// function which ends it.
+func traceDispatch(tracer Tracer, event *Event) (end func()) {
+	event.ctx, end = tracer.StartDispatch(event.Context(), event)
+	return end
+}
+
+// traceHandler starts tracing a handler of event, returning the function
+// which ends it. The context of event is replaced with that of the handler.
+func traceHandler(tracer Tracer, event *Event, cuid string) (end func()) {
+	event.ctx, end = tracer.StartHandler(event.Context(), event, cuid)
+	return end
+}
+
+// traceSend starts tracing sending event, as part of the trace in the
+// context of the event (see Client.SendContext), if any. Tracing is ended by
+// endSend.
+func traceSend(tracer Tracer, event *Event) {
+	event.sent = tracer.StartSend(event.Context(), event)
+}
+
+// endSend ends tracing sending event (if traced), passing err if the event
+// couldn't be sent.
+func endSend(event *Event, err error) {
+	if event.sent == nil {
+		return
+	}
+
+	event.sent(err)
+	event.sent = nil
+}
+
+// SendContext is much like Send, however if tracing is enabled (see
+// Config.Tracer), sending the event is traced as part of the trace in ctx,
+// e.g. the context of the event being handled (see Event.Context):
+//
+//	client.Handlers.Add(girc.PRIVMSG, func(c *girc.Client, e girc.Event) {
+//		c.SendContext(e.Context(), &girc.Event{Command: girc.PRIVMSG, Params: []string{"#channel"}, Trailing: "pong"})
+//	})
+func (c *Client) SendContext(ctx context.Context, event *Event) {
+	event.ctx = ctx
+	c.write(event)
+}
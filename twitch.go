@@ -0,0 +1,364 @@
+// Copyright (c) Liam Stanley <me@liamstanley.io>. All rights reserved. Use
+// of this source code is governed by the MIT license that can be found in
+// the LICENSE file.
+
+package girc
+
+import (
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Twitch commands and capabilities :: https://dev.twitch.tv/docs/irc
+const (
+	CLEARCHAT       = "CLEARCHAT"
+	CLEARMSG        = "CLEARMSG"
+	GLOBALUSERSTATE = "GLOBALUSERSTATE"
+	HOSTTARGET      = "HOSTTARGET"
+	RECONNECT       = "RECONNECT"
+	ROOMSTATE       = "ROOMSTATE"
+	USERNOTICE      = "USERNOTICE"
+	USERSTATE       = "USERSTATE"
+	WHISPER         = "WHISPER"
+
+	TwitchCapMembership = "twitch.tv/membership"
+	TwitchCapTags       = "twitch.tv/tags"
+	TwitchCapCommands   = "twitch.tv/commands"
+)
+
+// twitchCaps are the capabilities requested with Config.TwitchMode.
+var twitchCaps = []string{TwitchCapMembership, TwitchCapTags, TwitchCapCommands}
+
+// TwitchUser is what's known about a user from the tags Twitch attaches to
+// their messages, and to USERSTATE for ourselves. See User.Extras.Twitch.
+type TwitchUser struct {
+	// ID is the Twitch user ID of the user.
+	ID string
+	// DisplayName is the name of the user as shown in chat, which may
+	// differ from their nickname (their login) in case, or entirely (for
+	// localized names).
+	DisplayName string
+	// Color is the color of the users name, as "#RRGGBB". Empty if the
+	// user never set one.
+	Color string
+	// Badges are the badges of the user in the channel, and their version
+	// (e.g. "subscriber" and "12", for a 12 month subscriber).
+	Badges map[string]string
+	// EmoteSets are the IDs of the emote sets the user may use. Only known
+	// for ourselves (from USERSTATE).
+	EmoteSets []string
+	// Mod is true if the user is a moderator of the channel.
+	Mod bool
+	// Subscriber is true if the user is subscribed to the channel.
+	Subscriber bool
+}
+
+// Copy returns a deep copy of the Twitch user information.
+func (u TwitchUser) Copy() TwitchUser {
+	if u.Badges != nil {
+		badges := make(map[string]string, len(u.Badges))
+		for badge, version := range u.Badges {
+			badges[badge] = version
+		}
+		u.Badges = badges
+	}
+
+	u.EmoteSets = append([]string(nil), u.EmoteSets...)
+
+	return u
+}
+
+// HasBadge returns true if the user has the given badge (e.g. "vip" or
+// "broadcaster").
+func (u TwitchUser) HasBadge(badge string) bool {
+	_, ok := u.Badges[badge]
+	return ok
+}
+
+// DecodeTwitchUser returns the Twitch user information in the tags of the
+// event (e.g. a PRIVMSG, USERNOTICE or USERSTATE), or nil if the event has
+// none (see TwitchCapTags).
+func DecodeTwitchUser(e *Event) *TwitchUser {
+	if _, ok := e.Tag("badges"); !ok {
+		if _, ok = e.Tag("display-name"); !ok {
+			return nil
+		}
+	}
+
+	user := &TwitchUser{}
+	user.ID, _ = e.Tag("user-id")
+	user.DisplayName, _ = e.Tag("display-name")
+	user.Color, _ = e.Tag("color")
+
+	if badges, _ := e.Tag("badges"); badges != "" {
+		user.Badges = make(map[string]string)
+		for _, badge := range strings.Split(badges, ",") {
+			if i := strings.IndexByte(badge, 0x2F); i > 0 { // /
+				user.Badges[badge[:i]] = badge[i+1:]
+			} else if badge != "" {
+				user.Badges[badge] = ""
+			}
+		}
+	}
+
+	if sets, _ := e.Tag("emote-sets"); sets != "" {
+		user.EmoteSets = strings.Split(sets, ",")
+	}
+
+	user.Mod = twitchFlag(e, "mod") || user.HasBadge("moderator")
+	user.Subscriber = twitchFlag(e, "subscriber") || user.HasBadge("subscriber")
+
+	return user
+}
+
+// TwitchUserNotice is a USERNOTICE, which Twitch sends for events in a
+// channel, such as subscriptions, gifted subscriptions and raids.
+type TwitchUserNotice struct {
+	// Channel is the channel the notice is for.
+	Channel string
+	// Login is the login (nickname) of the user who caused the notice.
+	Login string
+	// User is the Twitch information of the user who caused the notice.
+	User TwitchUser
+	// Type is the type of notice (the msg-id tag), e.g. "sub", "resub",
+	// "subgift" or "raid".
+	Type string
+	// SystemMsg is the message Twitch shows for the notice, e.g. "nick
+	// subscribed for 12 months!".
+	SystemMsg string
+	// Message is the message the user attached to the notice, if any.
+	Message string
+	// Params are the parameters of the notice (the msg-param-* tags), e.g.
+	// "cumulative-months" for a resub, keyed without the "msg-param-"
+	// prefix.
+	Params map[string]string
+}
+
+// DecodeTwitchUserNotice decodes a USERNOTICE, returning nil if the event
+// isn't one.
+func DecodeTwitchUserNotice(e *Event) *TwitchUserNotice {
+	if e.Command != USERNOTICE || len(e.Params) < 1 {
+		return nil
+	}
+
+	notice := &TwitchUserNotice{Channel: e.Params[0], Message: e.Trailing, Params: make(map[string]string)}
+	notice.Login, _ = e.Tag("login")
+	notice.Type, _ = e.Tag("msg-id")
+	notice.SystemMsg, _ = e.Tag("system-msg")
+
+	if user := DecodeTwitchUser(e); user != nil {
+		notice.User = *user
+	}
+
+	tags := e.Tags
+	if tags == nil {
+		tags = ParseTags(e.RawTags)
+	}
+
+	for key, value := range tags {
+		if strings.HasPrefix(key, "msg-param-") {
+			notice.Params[key[len("msg-param-"):]] = unescapeTag(value)
+		}
+	}
+
+	return notice
+}
+
+// TwitchClearChat is a CLEARCHAT, which Twitch sends when the messages of a
+// user are removed from a channel, as they were timed out or banned, or when
+// all messages of the channel are removed.
+type TwitchClearChat struct {
+	// Channel is the channel which was cleared.
+	Channel string
+	// Login is the login (nickname) of the user whose messages were
+	// removed. Empty if all messages of the channel were removed.
+	Login string
+	// Duration is how long the user was timed out for. Zero if the user
+	// was banned, or all messages were removed.
+	Duration time.Duration
+}
+
+// Banned returns true if the user was banned, rather than timed out.
+func (c *TwitchClearChat) Banned() bool {
+	return c.Login != "" && c.Duration == 0
+}
+
+// DecodeTwitchClearChat decodes a CLEARCHAT, returning nil if the event
+// isn't one.
+func DecodeTwitchClearChat(e *Event) *TwitchClearChat {
+	if e.Command != CLEARCHAT || len(e.Params) < 1 {
+		return nil
+	}
+
+	msg := &TwitchClearChat{Channel: e.Params[0], Login: e.Trailing}
+	if len(e.Params) > 1 {
+		msg.Login = e.Params[1]
+	}
+
+	if duration, ok := e.Tag("ban-duration"); ok {
+		if seconds, err := strconv.Atoi(duration); err == nil {
+			msg.Duration = time.Duration(seconds) * time.Second
+		}
+	}
+
+	return msg
+}
+
+// TwitchClearMsg is a CLEARMSG, which Twitch sends when a single message is
+// removed from a channel.
+type TwitchClearMsg struct {
+	// Channel is the channel the message was removed from.
+	Channel string
+	// Login is the login (nickname) of the user who sent the message.
+	Login string
+	// MsgID is the ID of the message which was removed (see the msgid tag
+	// of messages).
+	MsgID string
+	// Message is the message which was removed.
+	Message string
+}
+
+// DecodeTwitchClearMsg decodes a CLEARMSG, returning nil if the event isn't
+// one.
+func DecodeTwitchClearMsg(e *Event) *TwitchClearMsg {
+	if e.Command != CLEARMSG || len(e.Params) < 1 {
+		return nil
+	}
+
+	msg := &TwitchClearMsg{Channel: e.Params[0], Message: e.Trailing}
+	msg.Login, _ = e.Tag("login")
+	msg.MsgID, _ = e.Tag("target-msg-id")
+
+	return msg
+}
+
+// TwitchRoomState are the chat settings of a Twitch channel, from ROOMSTATE.
+// See Channel.RoomState.
+type TwitchRoomState struct {
+	// RoomID is the Twitch ID of the channel.
+	RoomID string
+	// EmoteOnly is true if only messages with just emotes are allowed.
+	EmoteOnly bool
+	// FollowersOnly is true if only followers may chat, and only once they
+	// have been following for FollowersFor.
+	FollowersOnly bool
+	FollowersFor  time.Duration
+	// Unique is true if messages must be unique (also known as r9k).
+	Unique bool
+	// Slow is how long users must wait between messages. Zero if slow mode
+	// is disabled.
+	Slow time.Duration
+	// SubsOnly is true if only subscribers may chat.
+	SubsOnly bool
+}
+
+// apply updates the room state with the settings in the tags of a
+// ROOMSTATE. Twitch sends all settings when we join a channel, and only
+// the changed ones afterwards.
+func (r *TwitchRoomState) apply(e *Event) {
+	if id, ok := e.Tag("room-id"); ok {
+		r.RoomID = id
+	}
+	if _, ok := e.Tag("emote-only"); ok {
+		r.EmoteOnly = twitchFlag(e, "emote-only")
+	}
+	if _, ok := e.Tag("r9k"); ok {
+		r.Unique = twitchFlag(e, "r9k")
+	}
+	if _, ok := e.Tag("subs-only"); ok {
+		r.SubsOnly = twitchFlag(e, "subs-only")
+	}
+
+	if value, ok := e.Tag("followers-only"); ok {
+		// -1 means disabled, otherwise it's the amount of minutes.
+		minutes, err := strconv.Atoi(value)
+		r.FollowersOnly = err == nil && minutes >= 0
+		r.FollowersFor = 0
+		if r.FollowersOnly {
+			r.FollowersFor = time.Duration(minutes) * time.Minute
+		}
+	}
+
+	if value, ok := e.Tag("slow"); ok {
+		seconds, _ := strconv.Atoi(value)
+		r.Slow = time.Duration(seconds) * time.Second
+	}
+}
+
+// DecodeTwitchRoomState decodes the settings in a ROOMSTATE, returning nil
+// if the event isn't one. Settings which weren't changed are left at their
+// zero value; see Channel.RoomState for the complete room state.
+func DecodeTwitchRoomState(e *Event) *TwitchRoomState {
+	if e.Command != ROOMSTATE {
+		return nil
+	}
+
+	state := &TwitchRoomState{}
+	state.apply(e)
+
+	return state
+}
+
+// twitchFlag returns true if the tag of the event is set to "1".
+func twitchFlag(e *Event, key string) bool {
+	value, _ := e.Tag(key)
+	return value == "1"
+}
+
+// handleTwitchUser keeps User.Extras.Twitch up to date from the tags of
+// messages, and of USERSTATE for ourselves. Badges differ between channels,
+// so only the user in the channel of the message is updated.
+func handleTwitchUser(c *Client, e Event) {
+	if len(e.Params) < 1 || !IsValidChannel(e.Params[0]) {
+		return
+	}
+
+	info := DecodeTwitchUser(&e)
+	if info == nil {
+		return
+	}
+
+	nick := c.GetNick()
+	if e.Command != USERSTATE {
+		if e.Source == nil {
+			return
+		}
+		nick = e.Source.Name
+	}
+
+	// The tags of a user rarely change, so only take the write lock if
+	// they did.
+	c.state.mu.RLock()
+	user := c.state.lookupChannelUser(e.Params[0], nick)
+	// Only USERSTATE has our emote sets.
+	if user != nil && info.EmoteSets == nil {
+		info.EmoteSets = user.Extras.Twitch.EmoteSets
+	}
+	stale := user != nil && !reflect.DeepEqual(user.Extras.Twitch, *info)
+	c.state.mu.RUnlock()
+
+	if !stale {
+		return
+	}
+
+	c.state.mu.Lock()
+	if user = c.state.lookupChannelUser(e.Params[0], nick); user != nil {
+		user.Extras.Twitch = *info
+	}
+	c.state.mu.Unlock()
+}
+
+// handleROOMSTATE keeps Channel.RoomState up to date.
+func handleROOMSTATE(c *Client, e Event) {
+	if len(e.Params) < 1 {
+		return
+	}
+
+	c.state.mu.Lock()
+	if channel := c.state.lookupChannel(e.Params[0]); channel != nil {
+		channel.RoomState.apply(&e)
+	}
+	c.state.mu.Unlock()
+}
@@ -0,0 +1,109 @@
+// Copyright (c) Liam Stanley <me@liamstanley.io>. All rights reserved. Use
+// of this source code is governed by the MIT license that can be found in
+// the LICENSE file.
+
+package girc
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDecodeTwitch(t *testing.T) {
+	e := ParseEvent(`@badge-info=subscriber/14;badges=subscriber/12,premium/1;color=#FF0000;display-name=Viewer;login=viewer;mod=0;msg-id=resub;msg-param-cumulative-months=14;msg-param-sub-plan=Prime;subscriber=1;system-msg=Viewer\ssubscribed\sfor\s14\smonths!;user-id=123 :tmi.twitch.tv USERNOTICE #channel :hello`)
+
+	notice := DecodeTwitchUserNotice(e)
+	if notice == nil {
+		t.Fatal("DecodeTwitchUserNotice() = nil")
+	}
+	if notice.Channel != "#channel" || notice.Login != "viewer" || notice.Type != "resub" || notice.Message != "hello" || notice.SystemMsg != "Viewer subscribed for 14 months!" {
+		t.Fatalf("DecodeTwitchUserNotice() = %+v", notice)
+	}
+	if !reflect.DeepEqual(notice.Params, map[string]string{"cumulative-months": "14", "sub-plan": "Prime"}) {
+		t.Fatalf("DecodeTwitchUserNotice().Params = %v", notice.Params)
+	}
+	if user := notice.User; user.ID != "123" || user.DisplayName != "Viewer" || user.Color != "#FF0000" || !user.Subscriber || user.Mod || user.Badges["subscriber"] != "12" || !user.HasBadge("premium") {
+		t.Fatalf("DecodeTwitchUserNotice().User = %+v", user)
+	}
+
+	if DecodeTwitchUserNotice(ParseEvent(":nick!user@host PRIVMSG #channel :hello")) != nil {
+		t.Fatal("DecodeTwitchUserNotice() decoded a PRIVMSG")
+	}
+
+	clearTests := []struct {
+		raw    string
+		login  string
+		dur    time.Duration
+		banned bool
+	}{
+		{raw: "@ban-duration=600 :tmi.twitch.tv CLEARCHAT #channel :viewer", login: "viewer", dur: 10 * time.Minute},
+		{raw: ":tmi.twitch.tv CLEARCHAT #channel :viewer", login: "viewer", banned: true},
+		{raw: ":tmi.twitch.tv CLEARCHAT #channel", login: ""},
+	}
+	for _, tt := range clearTests {
+		got := DecodeTwitchClearChat(ParseEvent(tt.raw))
+		if got == nil || got.Channel != "#channel" || got.Login != tt.login || got.Duration != tt.dur || got.Banned() != tt.banned {
+			t.Errorf("DecodeTwitchClearChat(%q) = %+v", tt.raw, got)
+		}
+	}
+
+	msg := DecodeTwitchClearMsg(ParseEvent("@login=viewer;target-msg-id=abc :tmi.twitch.tv CLEARMSG #channel :spam"))
+	if msg == nil || msg.Login != "viewer" || msg.MsgID != "abc" || msg.Message != "spam" {
+		t.Fatalf("DecodeTwitchClearMsg() = %+v", msg)
+	}
+
+	state := DecodeTwitchRoomState(ParseEvent("@emote-only=0;followers-only=10;r9k=1;room-id=1;slow=30;subs-only=0 :tmi.twitch.tv ROOMSTATE #channel"))
+	want := &TwitchRoomState{RoomID: "1", FollowersOnly: true, FollowersFor: 10 * time.Minute, Unique: true, Slow: 30 * time.Second}
+	if !reflect.DeepEqual(state, want) {
+		t.Fatalf("DecodeTwitchRoomState() = %+v, want %+v", state, want)
+	}
+}
+
+func TestTwitchMode(t *testing.T) {
+	c, server := mockClient(t, Config{TwitchMode: true, Nick: "bot", User: "bot"})
+	defer c.Stop()
+
+	server.send(":tmi.twitch.tv CAP * LS :twitch.tv/membership twitch.tv/tags twitch.tv/commands")
+	req := server.expect("CAP REQ")
+	for _, name := range twitchCaps {
+		if !strings.Contains(req, name) {
+			t.Fatalf("%q didn't request %s", req, name)
+		}
+	}
+
+	server.send(":bot!bot@bot.tmi.twitch.tv JOIN #channel")
+	server.send("@badge-info=;badges=moderator/1;color=;display-name=Bot;emote-sets=0,300;mod=1;subscriber=0;user-type=mod :tmi.twitch.tv USERSTATE #channel")
+	server.send("@emote-only=0;followers-only=-1;r9k=0;room-id=1;slow=0;subs-only=0 :tmi.twitch.tv ROOMSTATE #channel")
+	server.send("@slow=10 :tmi.twitch.tv ROOMSTATE #channel")
+	server.send(":viewer!viewer@viewer.tmi.twitch.tv JOIN #channel")
+	server.send("@badges=vip/1;color=#00FF00;display-name=Viewer;user-id=123 :viewer!viewer@viewer.tmi.twitch.tv PRIVMSG #channel :hello")
+	server.send("PING :sync")
+
+	// Twitch doesn't support WHO or MODE, so neither may be sent.
+	for {
+		line := server.expect("")
+		if strings.HasPrefix(line, "WHO ") || strings.HasPrefix(line, "MODE ") {
+			t.Fatalf("sent %q in Twitch mode", line)
+		}
+		if line == "PONG sync" {
+			break
+		}
+	}
+
+	channel := c.Lookup("#channel")
+	if channel.RoomState.RoomID != "1" || channel.RoomState.Slow != 10*time.Second || channel.RoomState.FollowersOnly {
+		t.Fatalf("RoomState = %+v", channel.RoomState)
+	}
+
+	viewer := channel.Lookup("viewer")
+	if viewer == nil || viewer.Extras.Twitch.DisplayName != "Viewer" || !viewer.Extras.Twitch.HasBadge("vip") {
+		t.Fatalf("viewer = %+v", viewer)
+	}
+
+	self := channel.Lookup("bot")
+	if self == nil || !self.Extras.Twitch.Mod || !reflect.DeepEqual(self.Extras.Twitch.EmoteSets, []string{"0", "300"}) {
+		t.Fatalf("our user = %+v", self)
+	}
+}
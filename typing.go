@@ -0,0 +1,181 @@
+// Copyright (c) Liam Stanley <me@liamstanley.io>. All rights reserved. Use
+// of this source code is governed by the MIT license that can be found in
+// the LICENSE file.
+
+package girc
+
+import (
+	"sync"
+	"time"
+)
+
+// Typing notification states. See Typing.
+const (
+	TypingActive = "active" // the user is typing
+	TypingPaused = "paused" // the user has typed something, but stopped typing
+	TypingDone   = "done"   // the user has stopped typing, and cleared their input
+)
+
+const (
+	// typingTag is the client tag used for typing notifications.
+	typingTag = "+typing"
+	// typingInterval is how often an active typing notification is sent,
+	// while typing. Clients shouldn't send them more often than every 3
+	// seconds, and consider them expired after 6 seconds.
+	typingInterval = 3 * time.Second
+)
+
+// Typing sends typing notifications (see
+// https://ircv3.net/specs/client-tags/typing), which let other users know
+// that we are typing a message to them. Typing notifications require the
+// message-tags capability.
+//
+// Typing notifications from other users are dispatched as USER_TYPING
+// events.
+type Typing struct {
+	c  *Client
+	mu sync.Mutex
+	// active are the targets we are typing to, keyed by target converted to
+	// lowercase with the casemapping of the server, with a timer which
+	// refreshes the notification.
+	active map[string]*time.Timer
+}
+
+// newTyping returns a new Typing, not typing to anyone.
+func newTyping(c *Client) *Typing {
+	return &Typing{c: c, active: make(map[string]*time.Timer)}
+}
+
+// Start lets target (a channel or user) know that we are typing. The
+// notification is refreshed every 3 seconds, until Pause or Done is called
+// for the target, a message is sent to it (e.g. with Commands.Message), or
+// we disconnect. Calling Start again while typing to target does nothing.
+func (t *Typing) Start(target string) error {
+	if err := t.check(target); err != nil {
+		return err
+	}
+
+	key := t.c.toLower(target)
+
+	t.mu.Lock()
+	if _, ok := t.active[key]; ok {
+		t.mu.Unlock()
+		return nil
+	}
+
+	var refresh func()
+	refresh = func() {
+		t.mu.Lock()
+		defer t.mu.Unlock()
+
+		if _, ok := t.active[key]; !ok {
+			return
+		}
+
+		if !t.c.IsConnected() {
+			delete(t.active, key)
+			return
+		}
+
+		t.send(target, TypingActive)
+		t.active[key] = time.AfterFunc(typingInterval, refresh)
+	}
+
+	t.send(target, TypingActive)
+	t.active[key] = time.AfterFunc(typingInterval, refresh)
+	t.mu.Unlock()
+
+	return nil
+}
+
+// Pause lets target know that we have stopped typing, however haven't
+// cleared what we've typed.
+func (t *Typing) Pause(target string) error {
+	return t.stop(target, TypingPaused)
+}
+
+// Done lets target know that we have stopped typing, and have cleared what
+// we've typed. Note that sending a message to target (with Commands.Message
+// and the like) implicitly ends the typing notification, so this only needs
+// to be called if no message is being sent.
+func (t *Typing) Done(target string) error {
+	return t.stop(target, TypingDone)
+}
+
+// stop stops refreshing the typing notification for target, and sends state.
+func (t *Typing) stop(target, state string) error {
+	if err := t.check(target); err != nil {
+		return err
+	}
+
+	t.sent(target)
+	t.send(target, state)
+
+	return nil
+}
+
+// sent stops refreshing the typing notification for target, without sending
+// anything, as a message was sent to target which implicitly ends it.
+func (t *Typing) sent(target string) {
+	key := t.c.toLower(target)
+
+	t.mu.Lock()
+	if timer, ok := t.active[key]; ok {
+		timer.Stop()
+		delete(t.active, key)
+	}
+	t.mu.Unlock()
+}
+
+// reset stops refreshing all typing notifications, e.g. when reconnecting.
+func (t *Typing) reset() {
+	t.mu.Lock()
+	for key, timer := range t.active {
+		timer.Stop()
+		delete(t.active, key)
+	}
+	t.mu.Unlock()
+}
+
+// check returns an error if we're unable to send typing notifications to
+// target.
+func (t *Typing) check(target string) error {
+	if !IsValidNick(target) && !IsValidChannel(target) {
+		return &ErrInvalidTarget{Target: target}
+	}
+
+	if !t.c.HasCapability("message-tags") {
+		return ErrMessageTagsUnsupported
+	}
+
+	return nil
+}
+
+// send sends a typing notification.
+func (t *Typing) send(target, state string) {
+	t.c.Send(&Event{Tags: Tags{typingTag: state}, Command: TAGMSG, Params: []string{target}})
+}
+
+// handleTyping emits USER_TYPING events for typing notifications of other
+// users.
+func handleTyping(c *Client, e Event) {
+	state, ok := e.Tag(typingTag)
+	if !ok || e.Source == nil || len(e.Params) == 0 || c.isSelf(e.Source) {
+		return
+	}
+
+	switch state {
+	case TypingActive, TypingPaused, TypingDone:
+	default:
+		return
+	}
+
+	c.RunHandlers(&Event{
+		Source:    e.Source,
+		Tags:      e.Tags,
+		RawTags:   e.RawTags,
+		Command:   USER_TYPING,
+		Params:    []string{e.Params[0], state},
+		Timestamp: e.Timestamp,
+	})
+}
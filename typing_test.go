@@ -0,0 +1,102 @@
+// Copyright (c) Liam Stanley <me@liamstanley.io>. All rights reserved. Use
+// of this source code is governed by the MIT license that can be found in
+// the LICENSE file.
+
+package girc
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestTyping(t *testing.T) {
+	c, server := mockClient(t, Config{RateBurst: 10})
+	defer c.Stop()
+
+	if err := c.Typing.Start("#channel"); err != ErrMessageTagsUnsupported {
+		t.Fatalf("Start() without the capability returned %v", err)
+	}
+
+	c.state.mu.Lock()
+	c.state.enabledCap = []string{"message-tags"}
+	c.state.mu.Unlock()
+
+	if err := c.Typing.Start("#channel"); err != nil {
+		t.Fatal(err)
+	}
+	// Already typing, so nothing is sent.
+	if err := c.Typing.Start("#Channel"); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Typing.Done("#channel"); err != nil {
+		t.Fatal(err)
+	}
+
+	server.expect("@+typing=active TAGMSG #channel")
+	if line := server.expect(""); line != "@+typing=done TAGMSG #channel" {
+		t.Fatalf("unexpected line after typing started: %q", line)
+	}
+
+	c.Typing.mu.Lock()
+	n := len(c.Typing.active)
+	c.Typing.mu.Unlock()
+	if n != 0 {
+		t.Fatalf("still typing to %d targets", n)
+	}
+
+	// With ascii, #a[b] and #A{B} are different targets. Sending a message
+	// ends the notification.
+	c.state.mu.Lock()
+	c.state.isupport.Casemapping = CaseMappingASCII
+	c.state.mu.Unlock()
+
+	for _, target := range []string{"#a[b]", "#A{B}"} {
+		if err := c.Typing.Start(target); err != nil {
+			t.Fatal(err)
+		}
+		server.expect("@+typing=active TAGMSG " + target)
+	}
+
+	if _, err := c.Commands.Message("#A[B]", "hello"); err != nil {
+		t.Fatal(err)
+	}
+	server.expect("PRIVMSG #A[B] :hello")
+
+	c.Typing.mu.Lock()
+	_, ok := c.Typing.active["#a{b}"]
+	n = len(c.Typing.active)
+	c.Typing.mu.Unlock()
+	if n != 1 || !ok {
+		t.Fatalf("still typing to %d targets, want only #A{B}", n)
+	}
+}
+
+func TestHandleTyping(t *testing.T) {
+	c := New(Config{Server: "irc.example.com", Nick: "nick", User: "user"})
+
+	var events []*Event
+	c.Handlers.Add(USER_TYPING, func(c *Client, e Event) {
+		events = append(events, e.Copy())
+	})
+
+	for _, line := range []string{
+		"@+typing=active :other!user@host TAGMSG #channel",
+		"@+typing=paused :other!user@host TAGMSG nick",
+		"@+typing=active :nick!user@host TAGMSG #channel",
+		"@+typing=invalid :other!user@host TAGMSG #channel",
+		"@+react=x :other!user@host TAGMSG #channel",
+	} {
+		c.RunHandlers(ParseEvent(line))
+	}
+
+	if len(events) != 2 {
+		t.Fatalf("got %d USER_TYPING events, want 2: %q", len(events), events)
+	}
+
+	if events[0].Source.Name != "other" || !reflect.DeepEqual(events[0].Params, []string{"#channel", TypingActive}) {
+		t.Fatalf("unexpected event: %q", events[0])
+	}
+	if !reflect.DeepEqual(events[1].Params, []string{"nick", TypingPaused}) {
+		t.Fatalf("unexpected event: %q", events[1])
+	}
+}
@@ -0,0 +1,156 @@
+// Copyright (c) Liam Stanley <me@liamstanley.io>. All rights reserved. Use
+// of this source code is governed by the MIT license that can be found in
+// the LICENSE file.
+
+package girc
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+
+	"golang.org/x/net/websocket"
+)
+
+// wsSubprotocol is the IRCv3 WebSocket subprotocol for text frames. See
+// http://ircv3.net/specs/extensions/websocket for more information.
+const wsSubprotocol = "text.ircv3.net"
+
+// parseWebSocketURL validates a ws:// or wss:// url, returning the parsed
+// url, as well as the "host:port" pair which should be dialed.
+func parseWebSocketURL(raw string) (uri *url.URL, addr string, err error) {
+	uri, err = url.Parse(raw)
+	if err != nil {
+		return nil, "", fmt.Errorf("invalid websocket url %q: %s", raw, err)
+	}
+
+	if uri.Scheme != "ws" && uri.Scheme != "wss" {
+		return nil, "", fmt.Errorf("invalid websocket url %q: scheme must be ws or wss", raw)
+	}
+
+	if uri.Host == "" {
+		return nil, "", fmt.Errorf("invalid websocket url %q: missing host", raw)
+	}
+
+	addr = uri.Host
+	if _, _, err = net.SplitHostPort(addr); err != nil {
+		if uri.Scheme == "wss" {
+			addr = net.JoinHostPort(uri.Host, "443")
+		} else {
+			addr = net.JoinHostPort(uri.Host, "80")
+		}
+	}
+
+	return uri, addr, nil
+}
+
+// dialWebSocket connects to Config.WebSocketURL (using the same Dialer and
+// Proxy settings as regular connections), and performs the WebSocket
+// handshake, returning a connection which reads and writes regular IRC
+// lines.
+func dialWebSocket(conf Config) (net.Conn, error) {
+	uri, addr, err := parseWebSocketURL(conf.WebSocketURL)
+	if err != nil {
+		return nil, err
+	}
+
+	wsConf, err := websocket.NewConfig(uri.String(), "http://"+uri.Host)
+	if err != nil {
+		return nil, fmt.Errorf("invalid websocket url %q: %s", conf.WebSocketURL, err)
+	}
+
+	wsConf.Protocol = []string{wsSubprotocol}
+	wsConf.Header = http.Header{}
+
+	for key := range conf.WebSocketHeader {
+		if http.CanonicalHeaderKey(key) == "Origin" {
+			if wsConf.Origin, err = url.Parse(conf.WebSocketHeader.Get(key)); err != nil {
+				return nil, fmt.Errorf("invalid websocket origin %q: %s", conf.WebSocketHeader.Get(key), err)
+			}
+
+			continue
+		}
+
+		wsConf.Header[key] = conf.WebSocketHeader[key]
+	}
+
+	conn, err := dial(conf, addr)
+	if err != nil {
+		return nil, err
+	}
+
+	if uri.Scheme == "wss" {
+		host, _, _ := net.SplitHostPort(addr)
+
+		conn, err = tlsHandshake(conn, conf.TLSConfig, host, true)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	ws, err := websocket.NewClient(wsConf, conn)
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("unable to complete websocket handshake with %q: %s", conf.WebSocketURL, err)
+	}
+
+	return &wsConn{Conn: ws}, nil
+}
+
+// wsConn adapts a WebSocket connection into a line-based net.Conn. Each
+// text frame contains exactly one IRC message, without the trailing CR-LF,
+// so reads have the line ending appended, and writes are split into one
+// frame per line.
+type wsConn struct {
+	*websocket.Conn
+
+	// rbuf is the remaining, unread part of the last received frame.
+	rbuf []byte
+	// wbuf is data written which doesn't yet contain a full line.
+	wbuf []byte
+}
+
+// Read reads the next frame (if necessary), appending a line ending to it.
+func (c *wsConn) Read(p []byte) (n int, err error) {
+	if len(c.rbuf) == 0 {
+		var frame string
+		if err = websocket.Message.Receive(c.Conn, &frame); err != nil {
+			return 0, err
+		}
+
+		c.rbuf = append([]byte(frame), endline...)
+	}
+
+	n = copy(p, c.rbuf)
+	c.rbuf = c.rbuf[n:]
+
+	return n, nil
+}
+
+// Write buffers p until it contains a full line, and then sends each full
+// line as a separate text frame.
+func (c *wsConn) Write(p []byte) (n int, err error) {
+	c.wbuf = append(c.wbuf, p...)
+
+	for {
+		i := bytes.IndexByte(c.wbuf, delim)
+		if i < 0 {
+			break
+		}
+
+		line := bytes.TrimRight(c.wbuf[:i], "\r")
+		c.wbuf = c.wbuf[i+1:]
+
+		if len(line) == 0 {
+			continue
+		}
+
+		if err = websocket.Message.Send(c.Conn, string(line)); err != nil {
+			return 0, err
+		}
+	}
+
+	return len(p), nil
+}
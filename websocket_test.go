@@ -0,0 +1,100 @@
+// Copyright (c) Liam Stanley <me@liamstanley.io>. All rights reserved. Use
+// of this source code is governed by the MIT license that can be found in
+// the LICENSE file.
+
+package girc
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"golang.org/x/net/websocket"
+)
+
+func TestParseWebSocketURL(t *testing.T) {
+	tests := []struct {
+		name     string
+		raw      string
+		wantAddr string
+		wantErr  bool
+	}{
+		{name: "ws default port", raw: "ws://irc.example.com/webirc", wantAddr: "irc.example.com:80"},
+		{name: "wss default port", raw: "wss://irc.example.com/webirc", wantAddr: "irc.example.com:443"},
+		{name: "explicit port", raw: "wss://irc.example.com:8097/", wantAddr: "irc.example.com:8097"},
+		{name: "bad scheme", raw: "https://irc.example.com/", wantErr: true},
+		{name: "missing host", raw: "ws:///webirc", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		_, addr, err := parseWebSocketURL(tt.raw)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: parseWebSocketURL() error = %v, wantErr %v", tt.name, err, tt.wantErr)
+			continue
+		}
+
+		if addr != tt.wantAddr {
+			t.Errorf("%s: parseWebSocketURL() addr = %q, want %q", tt.name, addr, tt.wantAddr)
+		}
+	}
+}
+
+func TestWebSocketConn(t *testing.T) {
+	var header http.Header
+	frames := make(chan string, 5)
+
+	server := httptest.NewServer(websocket.Handler(func(ws *websocket.Conn) {
+		header = ws.Request().Header
+
+		var frame string
+		for i := 0; i < 2; i++ {
+			if err := websocket.Message.Receive(ws, &frame); err != nil {
+				return
+			}
+			frames <- frame
+		}
+
+		websocket.Message.Send(ws, ":irc.example.com PONG irc.example.com :1234")
+	}))
+	defer server.Close()
+
+	conf := Config{
+		Nick:            "nick",
+		User:            "user",
+		WebSocketURL:    "ws" + strings.TrimPrefix(server.URL, "http") + "/webirc",
+		WebSocketHeader: http.Header{"Authorization": []string{"Bearer token"}},
+	}
+
+	conn, err := newConn(conf, "")
+	if err != nil {
+		t.Fatalf("newConn() over websocket returned error: %s", err)
+	}
+	defer conn.Close()
+
+	if err = conn.encode(&Event{Command: PING, Params: []string{"1234"}}); err != nil {
+		t.Fatalf("encode() over websocket returned error: %s", err)
+	}
+	if err = conn.encode(&Event{Command: NICK, Params: []string{"nick"}}); err != nil {
+		t.Fatalf("encode() over websocket returned error: %s", err)
+	}
+
+	for _, want := range []string{"PING 1234", "NICK nick"} {
+		if got := <-frames; got != want {
+			t.Fatalf("websocket frame = %q, want %q", got, want)
+		}
+	}
+
+	if got := header.Get("Authorization"); got != "Bearer token" {
+		t.Fatalf("websocket handshake Authorization header = %q, want %q", got, "Bearer token")
+	}
+
+	event, err := conn.decode()
+	if err != nil {
+		t.Fatalf("decode() over websocket returned error: %s", err)
+	}
+
+	if event.Command != PONG || event.Trailing != "1234" {
+		t.Fatalf("decode() over websocket = %q, want PONG event", event.String())
+	}
+}
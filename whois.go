@@ -0,0 +1,225 @@
+// Copyright (c) Liam Stanley <me@liamstanley.io>. All rights reserved. Use
+// of this source code is governed by the MIT license that can be found in
+// the LICENSE file.
+
+package girc
+
+import (
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+// Whois is the result of a WHOIS query. See Commands.Whois. Fields are only
+// set if the server sent the relevant reply.
+type Whois struct {
+	// Nick, Ident, Host and Name are the users nickname, ident, host and
+	// "realname" (RPL_WHOISUSER).
+	Nick  string
+	Ident string
+	Host  string
+	Name  string
+	// Server is the server the user is connected to, and ServerInfo the
+	// description of that server (RPL_WHOISSERVER).
+	Server     string
+	ServerInfo string
+	// Operator is true if the user is an IRC operator (RPL_WHOISOPERATOR).
+	Operator bool
+	// Idle is how long the user has been idle, and SignOn when they
+	// connected, if the server supplied it (RPL_WHOISIDLE).
+	Idle   time.Duration
+	SignOn time.Time
+	// Channels are the channels the user is in, which we are able to see,
+	// with the channel user prefix (e.g. "@") stripped (RPL_WHOISCHANNELS).
+	Channels []string
+	// Account is the account the user is logged in as (RPL_WHOISACCOUNT).
+	Account string
+	// Secure is true if the user is using a secure connection, e.g. TLS
+	// (RPL_WHOISSECURE).
+	Secure bool
+	// Away is the away message of the user, if they are away (RPL_AWAY).
+	Away string
+	// Bot is true if the user is marked as a bot (RPL_WHOISBOT).
+	Bot bool
+}
+
+// Copy returns a deep copy of the WHOIS result.
+func (w *Whois) Copy() *Whois {
+	nw := &Whois{}
+	*nw = *w
+	nw.Channels = append([]string(nil), w.Channels...)
+
+	return nw
+}
+
+// ErrWhoisFailed is returned when a WHOIS query fails, e.g. because the user
+// doesn't exist (ERR_NOSUCHNICK).
+type ErrWhoisFailed struct {
+	// Nick is the nickname which was queried.
+	Nick string
+	// Code is the numeric the server responded with.
+	Code string
+	// Reason is the reason the server supplied, if any.
+	Reason string
+}
+
+func (e *ErrWhoisFailed) Error() string {
+	return "whois for " + e.Nick + " failed (" + e.Code + "): " + e.Reason
+}
+
+// Unwrap returns the error for Code, if any (see NumericError).
+func (e *ErrWhoisFailed) Unwrap() error { return NumericError(e.Code) }
+
+// Whois sends a WHOIS query to the server, targeted at a specific user, and
+// waits for all replies (until RPL_ENDOFWHOIS), or until ctx is done. As
+// WHOIS is a bit slower, you may want to use WHO for brief user info. If
+// Config.WhoisCacheTTL is set, the result is cached on the tracked user. If
+// the user doesn't exist, ErrWhoisFailed is returned, which wraps
+// ErrNoSuchNick (see errors.Is).
+func (cmd *Commands) Whois(ctx context.Context, nick string) (*Whois, error) {
+	if !IsValidNick(nick) {
+		return nil, &ErrInvalidTarget{Target: nick}
+	}
+
+	c := cmd.c
+	if w := c.cachedWhois(nick); w != nil {
+		return w, nil
+	}
+
+	c.state.mu.RLock()
+	symbols := c.state.isupport.PrefixSymbols
+	id := c.state.toLower(nick)
+	c.state.mu.RUnlock()
+
+	var mu sync.Mutex
+	w := &Whois{Nick: nick}
+	done := make(chan error, 1)
+
+	cuid := c.Handlers.sregisterOwned(ALLEVENTS, HandlerFunc(func(client *Client, e Event) {
+		if len(e.Params) < 2 || client.toLower(e.Params[1]) != id {
+			return
+		}
+
+		var err error
+		mu.Lock()
+		switch e.Command {
+		case RPL_WHOISUSER:
+			w.Nick, w.Name = e.Params[1], e.Trailing
+			if len(e.Params) > 3 {
+				w.Ident, w.Host = e.Params[2], e.Params[3]
+			}
+		case RPL_WHOISSERVER:
+			if len(e.Params) > 2 {
+				w.Server = e.Params[2]
+			}
+			w.ServerInfo = e.Trailing
+		case RPL_WHOISOPERATOR:
+			w.Operator = true
+		case RPL_WHOISIDLE:
+			if len(e.Params) > 2 {
+				idle, _ := strconv.Atoi(e.Params[2])
+				w.Idle = time.Duration(idle) * time.Second
+			}
+			if len(e.Params) > 3 {
+				if signon, err := strconv.ParseInt(e.Params[3], 10, 64); err == nil {
+					w.SignOn = time.Unix(signon, 0)
+				}
+			}
+		case RPL_WHOISCHANNELS:
+			for _, channel := range strings.Fields(e.Trailing) {
+				w.Channels = append(w.Channels, strings.TrimLeft(channel, symbols))
+			}
+		case RPL_WHOISACCOUNT:
+			if len(e.Params) > 2 {
+				w.Account = e.Params[2]
+			}
+		case RPL_WHOISSECURE:
+			w.Secure = true
+		case RPL_WHOISBOT:
+			w.Bot = true
+		case RPL_AWAY:
+			w.Away = e.Trailing
+		case RPL_ENDOFWHOIS:
+		case ERR_NOSUCHNICK, ERR_NOSUCHSERVER:
+			err = &ErrWhoisFailed{Nick: nick, Code: e.Command, Reason: e.Trailing}
+		default:
+			mu.Unlock()
+			return
+		}
+		mu.Unlock()
+
+		if e.Command != RPL_ENDOFWHOIS && err == nil {
+			return
+		}
+
+		select {
+		case done <- err:
+		default:
+			// Already finished.
+		}
+	}))
+	defer c.Handlers.Remove(cuid)
+
+	c.Send(&Event{Command: WHOIS, Params: []string{nick}})
+
+	select {
+	case err := <-done:
+		if err != nil {
+			return nil, err
+		}
+
+		mu.Lock()
+		result := w.Copy()
+		mu.Unlock()
+
+		c.cacheWhois(result)
+		return result.Copy(), nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// cachedWhois returns the cached WHOIS result of a tracked user, if caching
+// is enabled, and the result hasn't expired.
+func (c *Client) cachedWhois(nick string) *Whois {
+	if c.Config.WhoisCacheTTL <= 0 {
+		return nil
+	}
+
+	c.state.mu.RLock()
+	defer c.state.mu.RUnlock()
+
+	for _, user := range c.state.lookupUsers("nick", nick) {
+		if user.whois != nil && time.Since(user.whoisAt) < c.Config.WhoisCacheTTL {
+			w := user.whois.Copy()
+			w.Nick = user.Nick
+			return w
+		}
+	}
+
+	return nil
+}
+
+// cacheWhois updates the tracked user with the WHOIS result, caching it if
+// enabled.
+func (c *Client) cacheWhois(w *Whois) {
+	c.state.mu.Lock()
+
+	for _, user := range c.state.lookupUsers("nick", w.Nick) {
+		if w.Ident != "" {
+			user.Ident, user.Host, user.Extras.Name = w.Ident, w.Host, w.Name
+		}
+		user.Extras.Account = w.Account
+		user.IsBot = w.Bot
+
+		if c.Config.WhoisCacheTTL > 0 {
+			user.whois, user.whoisAt = w.Copy(), time.Now()
+		}
+	}
+	c.state.mu.Unlock()
+
+	c.updateAway(w.Nick, w.Away != "", w.Away, true)
+}
@@ -0,0 +1,124 @@
+// Copyright (c) Liam Stanley <me@liamstanley.io>. All rights reserved. Use
+// of this source code is governed by the MIT license that can be found in
+// the LICENSE file.
+
+package girc
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+func TestWhois(t *testing.T) {
+	c, server := mockClient(t, Config{RateBurst: 10, WhoisCacheTTL: time.Minute})
+	defer c.Stop()
+
+	server.send(":irc.example.com 001 nick :Welcome")
+	server.send(":nick!user@host JOIN #channel")
+	server.expect("MODE #channel")
+	server.send(":other!old@host JOIN #channel")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	type result struct {
+		whois *Whois
+		err   error
+	}
+	results := make(chan result, 1)
+
+	go func() {
+		w, err := c.Commands.Whois(ctx, "OTHER")
+		results <- result{w, err}
+	}()
+
+	server.expect("WHOIS OTHER")
+	server.send(":irc.example.com 311 nick other ident example.com * :Other User")
+	server.send(":irc.example.com 319 nick other :@#channel +#other")
+	server.send(":irc.example.com 319 nick someone :#unrelated")
+	server.send(":irc.example.com 312 nick other irc.example.com :Example server")
+	server.send(":irc.example.com 301 nick other :gone fishing")
+	server.send(":irc.example.com 313 nick other :is an IRC Operator")
+	server.send(":irc.example.com 671 nick other :is using a secure connection")
+	server.send(":irc.example.com 317 nick other 120 1500000000 :seconds idle, signon time")
+	server.send(":irc.example.com 330 nick other account :is logged in as")
+	server.send(":irc.example.com 318 nick other :End of /WHOIS list.")
+
+	res := <-results
+	if res.err != nil {
+		t.Fatalf("Whois() returned error: %s", res.err)
+	}
+
+	want := &Whois{
+		Nick:       "other",
+		Ident:      "ident",
+		Host:       "example.com",
+		Name:       "Other User",
+		Server:     "irc.example.com",
+		ServerInfo: "Example server",
+		Operator:   true,
+		Idle:       120 * time.Second,
+		SignOn:     time.Unix(1500000000, 0),
+		Channels:   []string{"#channel", "#other"},
+		Account:    "account",
+		Secure:     true,
+		Away:       "gone fishing",
+	}
+
+	if !reflect.DeepEqual(res.whois, want) {
+		t.Fatalf("Whois() = %#v, want %#v", res.whois, want)
+	}
+
+	user, ok := c.LookupUser("other")
+	if !ok || user.Ident != "ident" || user.Extras.Account != "account" || !user.Away || user.AwayMsg != "gone fishing" {
+		t.Fatalf("LookupUser() = %#v, want user updated from WHOIS", user)
+	}
+
+	// Cached, so no query is sent.
+	w, err := c.Commands.Whois(ctx, "other")
+	if err != nil || !reflect.DeepEqual(w, want) {
+		t.Fatalf("cached Whois() = %#v, %v, want %#v", w, err, want)
+	}
+
+	go func() {
+		w, err := c.Commands.Whois(ctx, "missing")
+		results <- result{w, err}
+	}()
+
+	server.expect("WHOIS missing")
+	server.send(":irc.example.com 401 nick missing :No such nick/channel")
+	server.send(":irc.example.com 318 nick missing :End of /WHOIS list.")
+
+	res = <-results
+	if _, ok := res.err.(*ErrWhoisFailed); !ok {
+		t.Fatalf("Whois() returned %v, want ErrWhoisFailed", res.err)
+	}
+
+	if _, err := c.Commands.Whois(ctx, "invalid nick"); err == nil {
+		t.Fatal("Whois() with an invalid nick returned no error")
+	}
+
+	// With ascii, replies for case variants beyond A-Z are for someone else.
+	c.state.mu.Lock()
+	c.state.isupport.Casemapping = CaseMappingASCII
+	c.state.mu.Unlock()
+
+	go func() {
+		w, err := c.Commands.Whois(ctx, "a[b]")
+		results <- result{w, err}
+	}()
+
+	server.expect("WHOIS a[b]")
+	server.send(":irc.example.com 311 nick a{b} other example.com * :Someone Else")
+	server.send(":irc.example.com 318 nick a{b} :End of /WHOIS list.")
+	server.send(":irc.example.com 311 nick A[B] ident example.com * :Real Name")
+	server.send(":irc.example.com 318 nick A[B] :End of /WHOIS list.")
+
+	res = <-results
+	if res.err != nil || res.whois.Nick != "A[B]" || res.whois.Name != "Real Name" {
+		t.Fatalf("Whois() = %#v, %v, want the reply for A[B]", res.whois, res.err)
+	}
+}
@@ -0,0 +1,158 @@
+// Copyright (c) Liam Stanley <me@liamstanley.io>. All rights reserved. Use
+// of this source code is governed by the MIT license that can be found in
+// the LICENSE file.
+
+package girc
+
+import (
+	"strconv"
+	"sync"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+// Whowas is a previous user of a nickname. See Commands.Whowas and
+// Client.LookupNickHistory. Fields are only set if known.
+type Whowas struct {
+	// Nick, Ident, Host and Name are the nickname, ident, host and
+	// "realname" of the user, when they were using the nickname
+	// (RPL_WHOWASUSER).
+	Nick  string
+	Ident string
+	Host  string
+	Name  string
+	// Server is the server the user was connected to (RPL_WHOISSERVER).
+	Server string
+	// SignOff is when the user stopped using the nickname, as sent by the
+	// server (RPL_WHOISSERVER). The format differs between servers.
+	SignOff string
+	// Account is the account the user was logged in as (RPL_WHOISACCOUNT).
+	Account string
+	// NewNick is the nickname the user changed to, and Time is when, if we
+	// saw them changing nickname ourselves.
+	NewNick string
+	Time    time.Time
+}
+
+// Whowas sends a WHOWAS query to the server, for the previous users of
+// nick, and waits for all replies (until RPL_ENDOFWHOWAS), or until ctx is
+// done. The results are the most recent first, up to count (or all the
+// server keeps, if count is 0). If the server doesn't know of any previous
+// users, ErrNumeric is returned, which wraps ErrWasNoSuchNick (see
+// errors.Is).
+func (cmd *Commands) Whowas(ctx context.Context, nick string, count int) ([]Whowas, error) {
+	if !IsValidNick(nick) {
+		return nil, &ErrInvalidTarget{Target: nick}
+	}
+
+	c := cmd.c
+	id := c.toLower(nick)
+
+	var mu sync.Mutex
+	var results []Whowas
+	done := make(chan error, 1)
+
+	cuid := c.Handlers.sregisterOwned(ALLEVENTS, HandlerFunc(func(client *Client, e Event) {
+		if len(e.Params) < 2 || client.toLower(e.Params[1]) != id {
+			return
+		}
+
+		var err error
+		mu.Lock()
+		switch e.Command {
+		case RPL_WHOWASUSER:
+			// Every user starts with RPL_WHOWASUSER, followed by the other
+			// replies about them.
+			w := Whowas{Nick: e.Params[1], Name: e.Trailing}
+			if len(e.Params) > 3 {
+				w.Ident, w.Host = e.Params[2], e.Params[3]
+			}
+			results = append(results, w)
+		case RPL_WHOISSERVER:
+			if len(results) > 0 {
+				if len(e.Params) > 2 {
+					results[len(results)-1].Server = e.Params[2]
+				}
+				results[len(results)-1].SignOff = e.Trailing
+			}
+		case RPL_WHOISACCOUNT:
+			if len(results) > 0 && len(e.Params) > 2 {
+				results[len(results)-1].Account = e.Params[2]
+			}
+		case RPL_ENDOFWHOWAS:
+		case ERR_WASNOSUCHNICK:
+			err = &ErrNumeric{Code: e.Command, Target: nick, Reason: e.Trailing}
+		default:
+			mu.Unlock()
+			return
+		}
+		mu.Unlock()
+
+		if e.Command != RPL_ENDOFWHOWAS && err == nil {
+			return
+		}
+
+		select {
+		case done <- err:
+		default:
+			// Already finished.
+		}
+	}))
+	defer c.Handlers.Remove(cuid)
+
+	params := []string{nick}
+	if count > 0 {
+		params = append(params, strconv.Itoa(count))
+	}
+	c.Send(&Event{Command: WHOWAS, Params: params})
+
+	select {
+	case err := <-done:
+		if err != nil {
+			return nil, err
+		}
+
+		mu.Lock()
+		defer mu.Unlock()
+
+		if count > 0 && len(results) > count {
+			results = results[:count]
+		}
+
+		return append([]Whowas(nil), results...), nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// LookupNickHistory returns the previous users of nick, the most recent
+// first: from the nickname changes we've seen ourselves (see ResolveNick),
+// with the details of those users we're still tracking, or if we haven't
+// seen anyone change from nick, from WHOWAS (see Commands.Whowas). Panics
+// if tracking is disabled.
+func (c *Client) LookupNickHistory(ctx context.Context, nick string) ([]Whowas, error) {
+	c.panicIfNotTracking()
+
+	c.state.mu.RLock()
+	var results []Whowas
+	for i := len(c.state.nickChanges) - 1; i >= 0; i-- {
+		change := c.state.nickChanges[i]
+		if c.state.toLower(change.Old) != c.state.toLower(nick) {
+			continue
+		}
+
+		w := Whowas{Nick: change.Old, NewNick: change.New, Time: change.Time}
+		if user := mergeUsers(c.state.usersByNick(c.state.resolveNick(change.New, change.Time))); user != nil {
+			w.Ident, w.Host, w.Name, w.Account = user.Ident, user.Host, user.Extras.Name, user.Extras.Account
+		}
+		results = append(results, w)
+	}
+	c.state.mu.RUnlock()
+
+	if len(results) > 0 {
+		return results, nil
+	}
+
+	return c.Commands.Whowas(ctx, nick, 0)
+}
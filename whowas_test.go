@@ -0,0 +1,138 @@
+// Copyright (c) Liam Stanley <me@liamstanley.io>. All rights reserved. Use
+// of this source code is governed by the MIT license that can be found in
+// the LICENSE file.
+
+package girc
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+func TestWhowas(t *testing.T) {
+	c, server := mockClient(t, Config{AllowFlood: true})
+	defer c.Stop()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	type result struct {
+		whowas []Whowas
+		err    error
+	}
+	results := make(chan result, 1)
+
+	go func() {
+		w, err := c.Commands.Whowas(ctx, "old", 2)
+		results <- result{w, err}
+	}()
+
+	server.expect("WHOWAS old 2")
+	server.send(":irc.example.com 314 nick Old ident one.example.com * :First User")
+	server.send(":irc.example.com 312 nick Old irc.example.com :Fri Oct 16 12:00:00 2026")
+	server.send(":irc.example.com 330 nick Old account :was logged in as")
+	server.send(":irc.example.com 314 nick someone ident other.example.com * :Unrelated")
+	server.send(":irc.example.com 314 nick Old ident2 two.example.com * :Second User")
+	server.send(":irc.example.com 312 nick Old irc2.example.com :Thu Oct 15 12:00:00 2026")
+	server.send(":irc.example.com 369 nick Old :End of WHOWAS")
+
+	r := <-results
+	if r.err != nil {
+		t.Fatalf("Whowas() = %s", r.err)
+	}
+
+	want := []Whowas{
+		{Nick: "Old", Ident: "ident", Host: "one.example.com", Name: "First User", Server: "irc.example.com", SignOff: "Fri Oct 16 12:00:00 2026", Account: "account"},
+		{Nick: "Old", Ident: "ident2", Host: "two.example.com", Name: "Second User", Server: "irc2.example.com", SignOff: "Thu Oct 15 12:00:00 2026"},
+	}
+	if !reflect.DeepEqual(r.whowas, want) {
+		t.Errorf("Whowas() = %+v, want %+v", r.whowas, want)
+	}
+
+	go func() {
+		w, err := c.Commands.Whowas(ctx, "unknown", 0)
+		results <- result{w, err}
+	}()
+
+	server.expect("WHOWAS unknown")
+	server.send(":irc.example.com 406 nick unknown :There was no such nickname")
+	server.send(":irc.example.com 369 nick unknown :End of WHOWAS")
+
+	if r = <-results; !errors.Is(r.err, ErrWasNoSuchNick) {
+		t.Errorf("Whowas() for unknown nick = %v, want %v", r.err, ErrWasNoSuchNick)
+	}
+
+	if _, err := c.Commands.Whowas(ctx, "bad nick", 0); err == nil {
+		t.Error("Whowas() with an invalid nick didn't fail")
+	}
+
+	// With ascii, replies for a{b} aren't for a[b].
+	c.state.mu.Lock()
+	c.state.isupport.Casemapping = CaseMappingASCII
+	c.state.mu.Unlock()
+
+	go func() {
+		w, err := c.Commands.Whowas(ctx, "a[b]", 0)
+		results <- result{w, err}
+	}()
+
+	server.expect("WHOWAS a[b]")
+	server.send(":irc.example.com 314 nick a{b} other other.example.com * :Someone Else")
+	server.send(":irc.example.com 369 nick a{b} :End of WHOWAS")
+	server.send(":irc.example.com 314 nick A[B] ident one.example.com * :Real Name")
+	server.send(":irc.example.com 369 nick A[B] :End of WHOWAS")
+
+	if r = <-results; r.err != nil || len(r.whowas) != 1 || r.whowas[0].Name != "Real Name" {
+		t.Errorf("Whowas() = %+v, %v, want the reply for A[B]", r.whowas, r.err)
+	}
+}
+
+func TestLookupNickHistory(t *testing.T) {
+	c, server := mockClient(t, Config{AllowFlood: true})
+	defer c.Stop()
+
+	server.send(":irc.example.com 001 nick :Welcome")
+	server.send(":nick!user@host JOIN #chan")
+	server.send(":old!ident@host.example.com JOIN #chan")
+	server.send(":old!ident@host.example.com NICK new")
+	server.send("PING :sync")
+	server.expect("PONG sync")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	// We've seen the user changing nickname ourselves.
+	history, err := c.LookupNickHistory(ctx, "OLD")
+	if err != nil {
+		t.Fatalf("LookupNickHistory() = %s", err)
+	}
+
+	if len(history) != 1 || history[0].Nick != "old" || history[0].NewNick != "new" || history[0].Time.IsZero() {
+		t.Fatalf("LookupNickHistory() = %+v, want old -> new", history)
+	}
+
+	// Otherwise WHOWAS is used.
+	type result struct {
+		whowas []Whowas
+		err    error
+	}
+	results := make(chan result, 1)
+
+	go func() {
+		w, err := c.LookupNickHistory(ctx, "gone")
+		results <- result{w, err}
+	}()
+
+	server.expect("WHOWAS gone")
+	server.send(":irc.example.com 314 nick gone ident gone.example.com * :Gone User")
+	server.send(":irc.example.com 369 nick gone :End of WHOWAS")
+
+	r := <-results
+	if r.err != nil || len(r.whowas) != 1 || r.whowas[0].Host != "gone.example.com" {
+		t.Errorf("LookupNickHistory() = %+v, %v, want WHOWAS results", r.whowas, r.err)
+	}
+}
@@ -0,0 +1,274 @@
+// Copyright (c) Liam Stanley <me@liamstanley.io>. All rights reserved. Use
+// of this source code is governed by the MIT license that can be found in
+// the LICENSE file.
+
+package girc
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+// WhoxField is a field which can be requested in a WHOX query. See
+// Commands.Whox and http://faerion.sourceforge.net/doc/irc/whox.var.
+type WhoxField byte
+
+// WHOX fields. See WhoReply for their meaning.
+const (
+	WhoxChannel  WhoxField = 0x63 // c
+	WhoxIdent    WhoxField = 0x75 // u
+	WhoxIP       WhoxField = 0x69 // i
+	WhoxHost     WhoxField = 0x68 // h
+	WhoxServer   WhoxField = 0x73 // s
+	WhoxNick     WhoxField = 0x6E // n
+	WhoxFlags    WhoxField = 0x66 // f
+	WhoxHops     WhoxField = 0x64 // d
+	WhoxIdle     WhoxField = 0x6C // l
+	WhoxAccount  WhoxField = 0x61 // a
+	WhoxOpLevel  WhoxField = 0x6F // o
+	WhoxRealname WhoxField = 0x72 // r
+)
+
+const (
+	// whoxFieldToken is the field for the query token, which is always
+	// requested.
+	whoxFieldToken = 0x74 // t
+	// whoxOrder is the order in which fields are sent in replies, regardless
+	// of the order in which they were requested.
+	whoxOrder = "tcuihsnfdlaor"
+	// whoxTrackingToken is the token of the queries used to track users (see
+	// handleWHO), which is ignored by Commands.Whox.
+	whoxTrackingToken = "1"
+	// whoxUserToken is the token used by Commands.Who.
+	whoxUserToken = "2"
+	// whoxMaxToken is the highest token allowed by servers.
+	whoxMaxToken = 999
+)
+
+// whoxTrackingFields are the fields requested to track users.
+var whoxTrackingFields = []WhoxField{
+	WhoxChannel, WhoxIdent, WhoxHost, WhoxNick, WhoxFlags, WhoxAccount, WhoxRealname,
+}
+
+// ErrWhoxUnsupported is returned by Commands.Whox when the server doesn't
+// support WHOX (see ISupport.Raw).
+var ErrWhoxUnsupported = errors.New("server does not support WHOX")
+
+// WhoReply is a reply to a WHOX query. Fields are only set if they were
+// requested, and the server knows them.
+type WhoReply struct {
+	// Channel is a channel the user is in, or "*" if not applicable.
+	Channel string
+	// Ident, IP and Host are the ident, IP address ("255.255.255.255" if
+	// hidden) and host of the user.
+	Ident string
+	IP    string
+	Host  string
+	// Server is the server the user is connected to.
+	Server string
+	// Nick is the nickname of the user.
+	Nick string
+	// Flags start with "H" (here) or "G" (gone/away), optionally followed by
+	// "*" if the user is an IRC operator, and the channel user prefixes.
+	// See Away and Operator.
+	Flags string
+	// Hops is the amount of servers between us and the user.
+	Hops int
+	// Idle is how long the user has been idle.
+	Idle time.Duration
+	// Account is the account the user is logged in as, if any.
+	Account string
+	// OpLevel is the channel op level of the user, or "n/a".
+	OpLevel string
+	// Realname is the "realname" (gecos) of the user.
+	Realname string
+}
+
+// Away returns true if the user is marked as away.
+func (r WhoReply) Away() bool {
+	return len(r.Flags) > 0 && r.Flags[0] == 0x47 // G
+}
+
+// Operator returns true if the user is an IRC operator.
+func (r WhoReply) Operator() bool {
+	return len(r.Flags) > 1 && strings.IndexByte(r.Flags[1:], 0x2A) > -1 // *
+}
+
+// whoxFields returns the fields in the order they are sent in replies,
+// including the token, without duplicates.
+func whoxFields(fields []WhoxField) string {
+	var out []byte
+	for i := 0; i < len(whoxOrder); i++ {
+		if whoxOrder[i] == whoxFieldToken {
+			out = append(out, whoxOrder[i])
+			continue
+		}
+
+		for _, field := range fields {
+			if byte(field) == whoxOrder[i] {
+				out = append(out, whoxOrder[i])
+				break
+			}
+		}
+	}
+
+	return string(out)
+}
+
+// whoxParams returns the WHO parameters to query mask, requesting fields,
+// e.g. "#channel", "%tcnr,5".
+func whoxParams(mask, token string, fields []WhoxField) []string {
+	return []string{mask, "%" + whoxFields(fields) + "," + token}
+}
+
+// parseWhoxReply parses a RPL_WHOSPCRPL reply to a query of fields,
+// returning the token of the query, and false if the reply doesn't match
+// the fields.
+func parseWhoxReply(e *Event, fields []WhoxField) (token string, reply WhoReply, ok bool) {
+	if len(e.Params) < 1 {
+		return "", reply, false
+	}
+
+	values := e.Params[1:]
+	if len(e.Trailing) > 0 || e.EmptyTrailing {
+		values = append(values[:len(values):len(values)], e.Trailing)
+	}
+
+	order := whoxFields(fields)
+
+	// Some servers omit an empty realname, rather than sending it as an
+	// empty trailing parameter.
+	if len(values) == len(order)-1 && order[len(order)-1] == byte(WhoxRealname) {
+		values = append(values[:len(values):len(values)], "")
+	}
+
+	if len(values) != len(order) {
+		return "", reply, false
+	}
+
+	for i := 0; i < len(order); i++ {
+		value := values[i]
+
+		switch order[i] {
+		case whoxFieldToken:
+			token = value
+		case byte(WhoxChannel):
+			reply.Channel = value
+		case byte(WhoxIdent):
+			reply.Ident = value
+		case byte(WhoxIP):
+			reply.IP = value
+		case byte(WhoxHost):
+			reply.Host = value
+		case byte(WhoxServer):
+			reply.Server = value
+		case byte(WhoxNick):
+			reply.Nick = value
+		case byte(WhoxFlags):
+			reply.Flags = value
+		case byte(WhoxHops):
+			reply.Hops, _ = strconv.Atoi(value)
+		case byte(WhoxIdle):
+			idle, _ := strconv.Atoi(value)
+			reply.Idle = time.Duration(idle) * time.Second
+		case byte(WhoxAccount):
+			if value != "0" {
+				reply.Account = value
+			}
+		case byte(WhoxOpLevel):
+			reply.OpLevel = value
+		case byte(WhoxRealname):
+			reply.Realname = value
+		}
+	}
+
+	return token, reply, true
+}
+
+// nextWhoxToken returns a token for a new WHOX query, which doesn't clash
+// with the tokens used for tracking, or by Commands.Who.
+func (c *Client) nextWhoxToken() string {
+	n := atomic.AddUint32(&c.whoxTokens, 1)
+	return strconv.Itoa(3 + int(n%(whoxMaxToken-2)))
+}
+
+// Whox sends a WHOX query for mask (e.g. a channel or a nickname), requesting
+// fields, and waits for all replies (until RPL_ENDOFWHO), or until ctx is
+// done. If no fields are given, all fields are requested. The replies are
+// correlated with the query using a token, so multiple queries can be in
+// progress at once. If the server doesn't support WHOX, ErrWhoxUnsupported
+// is returned.
+func (cmd *Commands) Whox(ctx context.Context, mask string, fields ...WhoxField) ([]WhoReply, error) {
+	if mask == "" {
+		return nil, &ErrInvalidTarget{Target: mask}
+	}
+
+	c := cmd.c
+	c.state.mu.RLock()
+	_, supported := c.state.isupport.Raw["WHOX"]
+	id := c.state.toLower(mask)
+	c.state.mu.RUnlock()
+
+	if !supported {
+		return nil, ErrWhoxUnsupported
+	}
+
+	if len(fields) == 0 {
+		fields = []WhoxField{
+			WhoxChannel, WhoxIdent, WhoxIP, WhoxHost, WhoxServer, WhoxNick,
+			WhoxFlags, WhoxHops, WhoxIdle, WhoxAccount, WhoxOpLevel, WhoxRealname,
+		}
+	}
+
+	token := c.nextWhoxToken()
+
+	var mu sync.Mutex
+	var replies []WhoReply
+	done := make(chan struct{}, 1)
+
+	cuid := c.Handlers.sregisterOwned(ALLEVENTS, HandlerFunc(func(client *Client, e Event) {
+		switch e.Command {
+		case RPL_WHOSPCRPL:
+			replyToken, reply, ok := parseWhoxReply(&e, fields)
+			if !ok || replyToken != token {
+				return
+			}
+
+			mu.Lock()
+			replies = append(replies, reply)
+			mu.Unlock()
+			return
+		case RPL_ENDOFWHO:
+			if len(e.Params) < 2 || client.toLower(e.Params[1]) != id {
+				return
+			}
+		default:
+			return
+		}
+
+		select {
+		case done <- struct{}{}:
+		default:
+			// Already finished.
+		}
+	}))
+	defer c.Handlers.Remove(cuid)
+
+	c.Send(&Event{Command: WHO, Params: whoxParams(mask, token, fields)})
+
+	select {
+	case <-done:
+		mu.Lock()
+		defer mu.Unlock()
+
+		return append([]WhoReply(nil), replies...), nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
@@ -0,0 +1,129 @@
+// Copyright (c) Liam Stanley <me@liamstanley.io>. All rights reserved. Use
+// of this source code is governed by the MIT license that can be found in
+// the LICENSE file.
+
+package girc
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+func TestWhoxParams(t *testing.T) {
+	tests := []struct {
+		fields []WhoxField
+		want   string
+	}{
+		{fields: nil, want: "%t,5"},
+		{fields: []WhoxField{WhoxRealname, WhoxNick}, want: "%tnr,5"},
+		{fields: []WhoxField{WhoxNick, WhoxNick, WhoxChannel}, want: "%tcn,5"},
+		{fields: whoxTrackingFields, want: "%tcuhnfar,5"},
+		{
+			fields: []WhoxField{
+				WhoxRealname, WhoxOpLevel, WhoxAccount, WhoxIdle, WhoxHops, WhoxFlags,
+				WhoxNick, WhoxServer, WhoxHost, WhoxIP, WhoxIdent, WhoxChannel,
+			},
+			want: "%tcuihsnfdlaor,5",
+		},
+	}
+
+	for _, tt := range tests {
+		if got := whoxParams("#channel", "5", tt.fields); got[0] != "#channel" || got[1] != tt.want {
+			t.Errorf("whoxParams(%q) = %q, want %q", tt.fields, got, tt.want)
+		}
+	}
+}
+
+func TestParseWhoxReply(t *testing.T) {
+	fields := []WhoxField{WhoxNick, WhoxFlags, WhoxIdle, WhoxAccount, WhoxRealname}
+
+	token, reply, ok := parseWhoxReply(ParseEvent(":irc.example.com 354 nick 7 other G* 120 0 :Other User"), fields)
+	want := WhoReply{Nick: "other", Flags: "G*", Idle: 120 * time.Second, Realname: "Other User"}
+	if !ok || token != "7" || !reflect.DeepEqual(reply, want) {
+		t.Fatalf("parseWhoxReply() = %q, %#v, %t, want 7, %#v", token, reply, ok, want)
+	}
+
+	if !reply.Away() || !reply.Operator() {
+		t.Fatalf("Away() = %t, Operator() = %t, want both true", reply.Away(), reply.Operator())
+	}
+
+	if _, _, ok = parseWhoxReply(ParseEvent(":irc.example.com 354 nick 7 other G* :Other User"), fields); ok {
+		t.Fatal("parseWhoxReply() accepted a reply with missing fields")
+	}
+}
+
+func TestWhox(t *testing.T) {
+	c, server := mockClient(t, Config{})
+	defer c.Stop()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	if _, err := c.Commands.Whox(ctx, "#channel"); err != ErrWhoxUnsupported {
+		t.Fatalf("Whox() without WHOX returned %v, want ErrWhoxUnsupported", err)
+	}
+
+	server.send(":irc.example.com 001 nick :Welcome")
+	server.send(":irc.example.com 005 nick WHOX :are supported by this server")
+	server.send("PING :sync")
+	server.expect("PONG sync")
+
+	type result struct {
+		replies []WhoReply
+		err     error
+	}
+	results := make(chan result, 1)
+
+	go func() {
+		replies, err := c.Commands.Whox(ctx, "#Channel", WhoxNick, WhoxAccount, WhoxHost)
+		results <- result{replies, err}
+	}()
+
+	line := server.expect("WHO #Channel %thna,")
+	token := line[strings.LastIndexByte(line, 0x2C)+1:]
+
+	server.send(":irc.example.com 354 nick 1 #channel user host.example.com other H 0 :Tracking")
+	server.send(":irc.example.com 354 nick " + token + " one.example.com one account")
+	server.send(":irc.example.com 354 nick " + token + " two.example.com two 0")
+	server.send(":irc.example.com 315 nick #channel :End of /WHO list.")
+
+	res := <-results
+	if res.err != nil {
+		t.Fatalf("Whox() returned error: %s", res.err)
+	}
+
+	want := []WhoReply{
+		{Host: "one.example.com", Nick: "one", Account: "account"},
+		{Host: "two.example.com", Nick: "two"},
+	}
+
+	if !reflect.DeepEqual(res.replies, want) {
+		t.Fatalf("Whox() = %#v, want %#v", res.replies, want)
+	}
+
+	// With ascii, the end of the WHO of #a{b} isn't that of #a[b].
+	c.state.mu.Lock()
+	c.state.isupport.Casemapping = CaseMappingASCII
+	c.state.mu.Unlock()
+
+	go func() {
+		replies, err := c.Commands.Whox(ctx, "#a[b]", WhoxNick)
+		results <- result{replies, err}
+	}()
+
+	line = server.expect("WHO #a[b] %tn,")
+	token = line[strings.LastIndexByte(line, 0x2C)+1:]
+
+	server.send(":irc.example.com 315 nick #a{b} :End of /WHO list.")
+	server.send(":irc.example.com 354 nick " + token + " one")
+	server.send(":irc.example.com 315 nick #A[B] :End of /WHO list.")
+
+	res = <-results
+	if res.err != nil || len(res.replies) != 1 || res.replies[0].Nick != "one" {
+		t.Fatalf("Whox() = %#v, %v, want the reply for #A[B]", res.replies, res.err)
+	}
+}